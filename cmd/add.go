@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/repository"
+	"buchhalter/lib/vault"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var addCmd = &cobra.Command{
+	Use:   "add <supplier>",
+	Short: "Sets up a supplier step by step",
+	Long:  "The add command looks up the recipe of a supplier in the Open Invoice Collector Database, finds the login item of the supplier in your vault (or creates it), tags it with credential_provider_item_tag, runs a first sync to validate the login and adds the supplier to buchhalter_selected_suppliers.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunAddCommand,
+}
+
+func init() {
+	addCmd.Flags().Bool("no-sync", false, "skip the first sync validating the login")
+	rootCmd.AddCommand(addCmd)
+}
+
+func RunAddCommand(cmd *cobra.Command, cmdArgs []string) {
+	query := cmdArgs[0]
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	noSync, err := cmd.Flags().GetBool("no-sync")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading no-sync flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	if !isInteractiveTerminal() {
+		exitWithLogo(i18n.T("The add command asks questions, please run it in a terminal."))
+	}
+	input := bufio.NewReader(os.Stdin)
+
+	// Find the recipe of the supplier
+	recipeParser := parser.NewRecipeParser(logger, viper.GetString("buchhalter_config_directory"), buchhalterDirectory)
+	if viper.GetBool("buchhalter_oicdb_auto_update") {
+		updateOICDB(cmd.Context(), logger, recipeParser)
+	}
+	_, err = recipeParser.LoadRecipes(developmentMode)
+	if err != nil {
+		logger.Error("Error loading recipes for suppliers", "error", err)
+		exitMessage := fmt.Sprintf("Error loading recipes for suppliers: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	recipe := findAddRecipe(recipeParser, query)
+	if recipe == nil {
+		return
+	}
+	logger.Info("Adding supplier", "supplier", recipe.Supplier, "domains", recipe.Domains)
+	fmt.Println(textStyleBold(i18n.Tf("Adding %s (%s)", recipe.Supplier, strings.Join(recipe.Domains, ", "))))
+
+	// Init vault provider
+	vaultConfigBinary := viper.GetString("credential_provider_cli_command")
+	vaultConfigBase := viper.GetString("credential_provider_vault")
+	vaultConfigTag := viper.GetString("credential_provider_item_tag")
+	logger.Info("Initializing credential provider", "provider", "1Password", "cli_command", vaultConfigBinary, "vault", vaultConfigBase, "tag", vaultConfigTag)
+	vaultProvider, err := vault.GetProvider(vault.PROVIDER_1PASSWORD, vaultConfigBinary, vaultConfigBase, vaultConfigTag)
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+
+	// Find or create the vault item
+	items, err := vaultProvider.FindItemsByDomains(recipe.Domains)
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+	item, ok := selectAddItem(logger, input, vaultProvider, recipe, items)
+	if !ok {
+		return
+	}
+
+	if !item.HasTag(vaultConfigTag) {
+		if !askYesNo(input, i18n.Tf("Tag the vault item %s with %s, so buchhalter uses it?", item.Title, vaultConfigTag)) {
+			fmt.Println(i18n.Tf("Without the tag %s, buchhalter does not use the vault item.", vaultConfigTag))
+			return
+		}
+		err = vaultProvider.AddTag(item)
+		if err != nil {
+			logger.Error("Error tagging vault item", "item", item.ID, "error", err)
+			exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+			exitWithLogo(exitMessage)
+		}
+		logger.Info("Vault item tagged", "item", item.ID, "tag", vaultConfigTag)
+	}
+	fmt.Println(textStyle(i18n.Tf("%s uses the vault item %s.", recipe.Supplier, item.Title)))
+
+	// An empty selection syncs all suppliers, a non-empty one needs to include the new supplier
+	selectedSuppliers := viper.GetStringSlice("buchhalter_selected_suppliers")
+	if len(selectedSuppliers) > 0 && !containsString(selectedSuppliers, recipe.Supplier) {
+		err = writeConfigValue("buchhalter_selected_suppliers", append(selectedSuppliers, recipe.Supplier))
+		if err != nil {
+			logger.Error("Error storing supplier selection", "error", err)
+			exitMessage := fmt.Sprintf("Error writing configuration file: %s", err)
+			exitWithLogo(exitMessage)
+		}
+		fmt.Println(textStyle(i18n.Tf("%s was added to buchhalter_selected_suppliers.", recipe.Supplier)))
+	}
+
+	if noSync || !askYesNo(input, i18n.Tf("Run a first sync of %s now to validate the login?", recipe.Supplier)) {
+		fmt.Println(textStyleBold(i18n.Tf("%s is ready, documents are downloaded with the next sync.", recipe.Supplier)))
+		return
+	}
+
+	_, err = vaultProvider.LoadVaultItems()
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+	runSync(cmd.Context(), logger, vaultProvider, initializeDocumentArchives(logger), nil, syncOptions{supplier: recipe.Supplier})
+}
+
+// updateOICDB downloads updates of the Open Invoice Collector Database from the API host or its mirrors, e.g. before the
+// first sync. Errors are reported only, the local database is used instead.
+func updateOICDB(ctx context.Context, logger *slog.Logger, recipeParser *parser.RecipeParser) {
+	buchhalterAPIClient, err := repository.NewBuchhalterAPIClient(logger, initializeHTTPClient(logger), viper.GetString("buchhalter_api_host"), viper.GetString("buchhalter_config_directory"), viper.GetString("buchhalter_api_token"), cliVersion)
+	if err != nil {
+		logger.Error("Error initializing Buchhalter API client", "error", err)
+		return
+	}
+	err = buchhalterAPIClient.SetRepositoryMirrors(viper.GetStringSlice("buchhalter_oicdb_mirrors"))
+	if err != nil {
+		logger.Error("Error initializing Buchhalter API client", "error", err)
+		fmt.Println(errorStyle.Render(err.Error()))
+	}
+	buchhalterAPIClient.SetRepositoryPublicKeys(viper.GetStringSlice("buchhalter_oicdb_public_keys"))
+
+	localOICDBSchemaChecksum, _ := recipeParser.GetChecksumOfLocalOICDBSchema()
+	logger.Info("Checking for OICDB schema updates ...", "local_checksum", localOICDBSchemaChecksum)
+	err = buchhalterAPIClient.UpdateOpenInvoiceCollectorDBSchemaIfAvailable(ctx, localOICDBSchemaChecksum)
+	if err != nil {
+		logger.Error("Error checking for OICDB schema updates", "error", err)
+		fmt.Println(errorStyle.Render(i18n.Tf("Checking for OICDB schema updates: %s", httpclient.GetHumanReadableErrorMessage(err))))
+	}
+
+	if viper.GetBool("dev") {
+		return
+	}
+	localOICDBChecksum, _ := recipeParser.GetChecksumOfLocalOICDB()
+	logger.Info("Checking for OICDB repository updates ...", "local_checksum", localOICDBChecksum)
+	err = buchhalterAPIClient.UpdateOpenInvoiceCollectorDBIfAvailable(ctx, localOICDBChecksum)
+	if err != nil {
+		logger.Error("Error checking for OICDB repository updates", "error", err)
+		fmt.Println(errorStyle.Render(i18n.Tf("Checking for OICDB repository updates: %s", httpclient.GetHumanReadableErrorMessage(err))))
+	}
+}
+
+// findAddRecipe returns the recipe of the supplier query. Unknown suppliers list the suppliers with a similar name or domain.
+func findAddRecipe(recipeParser *parser.RecipeParser, query string) *parser.Recipe {
+	recipe := recipeParser.GetRecipeBySupplier(query)
+	if recipe != nil {
+		return recipe
+	}
+
+	suppliers := recipeParser.FindSuppliers(query)
+	if len(suppliers) == 1 {
+		return recipeParser.GetRecipeBySupplier(suppliers[0])
+	}
+	fmt.Println(i18n.Tf("Supplier %s not found in the Open Invoice Collector Database.", query))
+	if len(suppliers) > 0 {
+		fmt.Println(i18n.T("Did you mean one of these suppliers?"))
+		for _, supplier := range suppliers {
+			fmt.Println("  " + supplier)
+		}
+	}
+	return nil
+}
+
+// selectAddItem returns the vault item of a supplier. Without items, it offers to create one, with several items the
+// user chooses one.
+func selectAddItem(logger *slog.Logger, input *bufio.Reader, vaultProvider *vault.Provider1Password, recipe *parser.Recipe, items vault.Items) (vault.Item, bool) {
+	switch len(items) {
+	case 0:
+		fmt.Println(i18n.Tf("No login for %s found in your vault.", strings.Join(recipe.Domains, ", ")))
+		if !askYesNo(input, i18n.Tf("Create a login item for %s in your vault?", recipe.Supplier)) {
+			return vault.Item{}, false
+		}
+		return createAddItem(logger, input, vaultProvider, recipe), true
+	case 1:
+		fmt.Println(textStyle(i18n.Tf("Found the vault item %s.", items[0].Title)))
+		return items[0], true
+	}
+
+	fmt.Println(i18n.Tf("Found %d logins for %s in your vault:", len(items), recipe.Supplier))
+	for i, item := range items {
+		fmt.Printf("  %d) %s\n", i+1, item.Title)
+	}
+	for {
+		answer := askLine(input, i18n.Tf("Which vault item should buchhalter use? (1-%d)", len(items)))
+		n, err := strconv.Atoi(answer)
+		if err == nil && n >= 1 && n <= len(items) {
+			return items[n-1], true
+		}
+	}
+}
+
+// createAddItem creates a login item of the supplier with the credentials entered by the user.
+func createAddItem(logger *slog.Logger, input *bufio.Reader, vaultProvider *vault.Provider1Password, recipe *parser.Recipe) vault.Item {
+	loginURL := "https://" + recipe.Domains[0]
+	username := ""
+	for username == "" {
+		username = askLine(input, i18n.T("Username:"))
+	}
+	fmt.Print(i18n.T("Password:") + " ")
+	password, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Println()
+	if err != nil {
+		logger.Error("Error reading password", "error", err)
+		exitMessage := fmt.Sprintf("Error reading password: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	item, err := vaultProvider.CreateLoginItem(recipe.Supplier, loginURL, username, string(password))
+	if err != nil {
+		logger.Error("Error creating vault item", "supplier", recipe.Supplier, "error", err)
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Vault item created", "supplier", recipe.Supplier, "item", item.ID)
+	fmt.Println(textStyle(i18n.Tf("Created the vault item %s.", item.Title)))
+	return item
+}
+
+func askLine(input *bufio.Reader, question string) string {
+	fmt.Print(question + " ")
+	answer, err := input.ReadString('\n')
+	if err != nil && answer == "" {
+		exitWithLogo(i18n.T("Aborted"))
+	}
+	return strings.TrimSpace(answer)
+}
+
+func askYesNo(input *bufio.Reader, question string) bool {
+	return strings.EqualFold(askLine(input, question+" (y/n)"), "y")
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/i18n"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Manages the document archives",
+}
+
+var archiveCommitCmd = &cobra.Command{
+	Use:   "commit",
+	Short: "Moves staged documents into the archive",
+	Long:  "The commit command moves all documents downloaded with `--read-only-archive` from the staging directory into the (shared) archive directory. Use `--archive` to commit a single archive only.",
+	Run:   RunArchiveCommitCommand,
+}
+
+var archiveTrashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Lists the documents in the trash",
+	Long:  "The trash command lists the rejected documents, which are kept in the trash of the archive and can be restored with `buchhalter archive restore`.",
+	Run:   RunArchiveTrashCommand,
+}
+
+var archiveRestoreCmd = &cobra.Command{
+	Use:   "restore <document>",
+	Short: "Restores a document from the trash",
+	Long:  "The restore command moves a document of the trash, identified by its checksum, its path or its original path, back into its original directory. Restored documents need to be reviewed again.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunArchiveRestoreCommand,
+}
+
+var archiveVerifyCmd = &cobra.Command{
+	Use:   "verify [manifest]",
+	Short: "Verifies the documents of a sync run against its manifest",
+	Long:  "Every sync writes a manifest of the run (recipe versions and checksums, OICDB checksum, CLI and Chrome version, document checksums) into the `_manifests` directory of the archive. The verify command checks that the documents of a manifest (default: the latest one) are unchanged in the archive.",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   RunArchiveVerifyCommand,
+}
+
+func init() {
+	archiveCmd.AddCommand(archiveCommitCmd)
+	archiveCmd.AddCommand(archiveTrashCmd)
+	archiveCmd.AddCommand(archiveRestoreCmd)
+	archiveCmd.AddCommand(archiveVerifyCmd)
+	rootCmd.AddCommand(archiveCmd)
+}
+
+func RunArchiveCommitCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	// The staging directories are the same as during a read-only sync
+	viper.Set("buchhalter_read_only_archive", true)
+	archives := initializeDocumentArchives(logger)
+	names := archives.Names()
+	if name := viper.GetString("buchhalter_archive"); name != "" {
+		names = []string{name}
+	}
+
+	for _, name := range names {
+		documentArchive, _ := archives.Get(name)
+		err = documentArchive.BuildArchiveIndex(cmd.Context())
+		if err != nil {
+			logger.Error("Error building document archive index", "archive", name, "error", err)
+			exitMessage := fmt.Sprintf("Error building index of archive %s: %s", name, err)
+			exitWithLogo(exitMessage)
+		}
+
+		moved, err := documentArchive.CommitStagedFiles()
+		if err != nil {
+			logger.Error("Error committing staged documents", "archive", name, "moved", moved, "error", err)
+			exitMessage := fmt.Sprintf("Error committing staged documents of archive %s (%d moved): %s", name, moved, err)
+			exitWithLogo(exitMessage)
+		}
+		logger.Info("Committed staged documents", "archive", name, "moved", moved)
+		fmt.Println(textStyle(fmt.Sprintf("Archive '%s': %d documents committed", name, moved)))
+	}
+}
+
+func RunArchiveTrashCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	documentArchive := initializeDocumentArchive(logger)
+	err = documentArchive.BuildArchiveIndex(cmd.Context())
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+		exitMessage := fmt.Sprintf("Error building document archive index: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	checksums := documentArchive.TrashedFiles()
+	if len(checksums) == 0 {
+		fmt.Println(i18n.T("The trash is empty."))
+		return
+	}
+	for _, checksum := range checksums {
+		f, _ := documentArchive.GetFile(checksum)
+		trashedAt := i18n.T("(unknown)")
+		if !f.TrashedAt.IsZero() {
+			trashedAt = f.TrashedAt.Format(time.DateTime)
+		}
+		originalPath := f.OriginalPath
+		if originalPath == "" {
+			originalPath = f.Supplier
+		}
+		fmt.Printf("%s  %s  %s\n", trashedAt, checksum[:min(12, len(checksum))], textStyleBold(filepath.Base(f.Path)))
+		fmt.Println(dotStyle.Render(fmt.Sprintf("    %s %s", i18n.T("from"), originalPath)))
+	}
+}
+
+func RunArchiveRestoreCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	documentArchive := initializeDocumentArchive(logger)
+	err = documentArchive.BuildArchiveIndex(cmd.Context())
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+		exitMessage := fmt.Sprintf("Error building document archive index: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	checksum, ok := findTrashedFile(documentArchive, cmdArgs[0])
+	if !ok {
+		fmt.Println(i18n.Tf("Document %s not found in the trash.", cmdArgs[0]))
+		return
+	}
+
+	err = documentArchive.RestoreFile(checksum)
+	if err != nil {
+		logger.Error("Error restoring document", "document", cmdArgs[0], "error", err)
+		exitMessage := fmt.Sprintf("Error restoring document: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	f, _ := documentArchive.GetFile(checksum)
+	logger.Info("Restored document", "checksum", checksum, "path", f.Path)
+	fmt.Println(textStyle(i18n.Tf("Restored %s", f.Path)))
+}
+
+func RunArchiveVerifyCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	documentArchive := initializeDocumentArchive(logger)
+	err = documentArchive.BuildArchiveIndex(cmd.Context())
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+		exitMessage := fmt.Sprintf("Error building document archive index: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	var manifestFile string
+	if len(cmdArgs) > 0 {
+		manifestFile = cmdArgs[0]
+	} else {
+		manifestFiles, err := documentArchive.Manifests()
+		if err != nil {
+			logger.Error("Error listing run manifests", "error", err)
+			exitMessage := fmt.Sprintf("Error listing run manifests: %s", err)
+			exitWithLogo(exitMessage)
+		}
+		if len(manifestFiles) == 0 {
+			fmt.Println(i18n.T("No run manifests found in the archive."))
+			return
+		}
+		manifestFile = manifestFiles[len(manifestFiles)-1]
+	}
+	manifest, err := archive.ReadManifest(manifestFile)
+	if err != nil {
+		logger.Error("Error reading run manifest", "manifest", manifestFile, "error", err)
+		exitMessage := fmt.Sprintf("Error reading run manifest: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	fmt.Println(headerStyle(i18n.Tf("Run of %s", manifest.StartedAt.Local().Format(time.DateTime))))
+	fmt.Println(dotStyle.Render(fmt.Sprintf("buchhalter %s, Chrome %s, OICDB %s (%s)", manifest.CliVersion, manifest.ChromeVersion, manifest.OicdbVersion, manifest.OicdbChecksum)))
+	for _, recipe := range manifest.Recipes {
+		fmt.Printf("%s  %s  %s\n", recipe.Checksum[:min(12, len(recipe.Checksum))], textStyleBold(recipe.Supplier), recipe.Version)
+	}
+	fmt.Println()
+
+	mismatches, err := documentArchive.VerifyManifest(manifest)
+	if err != nil {
+		logger.Error("Error verifying run manifest", "manifest", manifestFile, "error", err)
+		exitMessage := fmt.Sprintf("Error verifying run manifest: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Run manifest verified", "manifest", manifestFile, "documents", len(manifest.Documents), "mismatches", len(mismatches))
+	fmt.Println(textStyle(i18n.Tf("%d of %d documents verified", len(manifest.Documents)-len(mismatches), len(manifest.Documents))))
+	for _, mismatch := range mismatches {
+		reason := i18n.T("missing")
+		if mismatch.Reason == "changed" {
+			reason = i18n.T("changed")
+		}
+		fmt.Println(errorStyle.Render(fmt.Sprintf("%s  %s (%s)", mismatch.Document.Checksum[:min(12, len(mismatch.Document.Checksum))], mismatch.Document.Path, reason)))
+	}
+	if len(mismatches) > 0 {
+		os.Exit(1)
+	}
+}
+
+// findTrashedFile returns the checksum of a document in the trash, identified by its checksum, path, original path or
+// the beginning of its checksum (as listed by the trash command).
+func findTrashedFile(documentArchive *archive.DocumentArchive, document string) (string, bool) {
+	if checksum, ok := documentArchive.FindFile(document); ok {
+		return checksum, true
+	}
+
+	absolutePath, _ := filepath.Abs(document)
+	for _, checksum := range documentArchive.TrashedFiles() {
+		f, _ := documentArchive.GetFile(checksum)
+		if f.OriginalPath == absolutePath || (len(document) >= 12 && strings.HasPrefix(checksum, document)) {
+			return checksum, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"buchhalter/lib/backup"
+	"buchhalter/lib/history"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/repository"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// backupPassphraseEnv sets the passphrase of backups without a prompt, e.g. in scripts
+const backupPassphraseEnv = "BUCHHALTER_BACKUP_PASSPHRASE"
+
+// Files of the configuration directory holding credentials are only backed up with --include-tokens.
+// The OICDB isn't backed up, it is downloaded again with the next sync.
+var (
+	backupCredentialFiles = []string{".buchhalter-api-token", ".buchhalter-upload-key", ".secrets.json"}
+	backupSkippedFiles    = []string{"oicdb.json", "oicdb.schema.json"}
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Backs up and restores the buchhalter state",
+}
+
+var backupCreateCmd = &cobra.Command{
+	Use:   "create <file>",
+	Short: "Creates an encrypted backup of the buchhalter state",
+	Long:  "The create command writes the configuration, the indexes and document sources of all archives and the run history into a backup encrypted with a passphrase. With --include-tokens, the cached OAuth2 tokens and the credentials of the Buchhalter Platform are included as well, so suppliers don't need to log in again after moving to another machine. Documents aren't part of the backup.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunBackupCreateCommand,
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restores the buchhalter state from a backup",
+	Long:  "The restore command replaces the configuration, the archive indexes, the run history and the cached tokens of this machine with the state of a backup. Copy the documents into the archive directories before, the paths of the indexes are moved from the archive directories of the backup to the configured archive directories.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunBackupRestoreCommand,
+}
+
+func init() {
+	backupCreateCmd.Flags().Bool("include-tokens", false, "include the cached OAuth2 tokens and the credentials of the Buchhalter Platform")
+	backupRestoreCmd.Flags().Bool("yes", false, "replace the state of this machine without confirmation")
+	backupCmd.AddCommand(backupCreateCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+	rootCmd.AddCommand(backupCmd)
+}
+
+func RunBackupCreateCommand(cmd *cobra.Command, cmdArgs []string) {
+	outputFile := cmdArgs[0]
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	includeTokens, err := cmd.Flags().GetBool("include-tokens")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading include-tokens flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if team, ok := initializeTeam(cmd.Context(), logger); ok && team.Restrictions.DenyExport {
+		logger.Warn("Role of user in team doesn't permit exports", "team", team.Slug, "role", team.Role)
+		exitWithLogo(i18n.Tf("Your role %s in team %s doesn't permit exports.", team.Role, team.Name))
+	}
+
+	archives := initializeDocumentArchives(logger)
+	defer archives.Close()
+	homeDirectory, _ := os.UserHomeDir()
+	manifest := backup.Manifest{
+		CreatedAt:     time.Now(),
+		CliVersion:    cliVersion,
+		HomeDirectory: homeDirectory,
+		Archives:      map[string]backup.ArchiveDirectories{},
+	}
+	entries, err := backupConfigEntries(viper.GetString("buchhalter_config_directory"), includeTokens)
+	if err != nil {
+		logger.Error("Error reading configuration directory", "error", err)
+		exitMessage := fmt.Sprintf("Error reading configuration directory: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	for _, name := range archives.Names() {
+		documentArchive, _ := archives.Get(name)
+		manifest.Archives[name] = backup.ArchiveDirectories{
+			Directory:        documentArchive.Directory(),
+			StagingDirectory: documentArchive.StagingDirectory(),
+		}
+		for _, stateFile := range documentArchive.StateFiles() {
+			entries = append(entries, backup.Entry{Name: path.Join("archives", name, filepath.Base(stateFile)), Path: stateFile})
+		}
+	}
+	for _, historyFile := range []string{history.HISTORY_FILE_NAME, repository.LAST_RUN_METRICS_FILE_NAME} {
+		entries = append(entries, backup.Entry{Name: path.Join("history", historyFile), Path: filepath.Join(buchhalterDirectory, historyFile)})
+	}
+	if includeTokens {
+		tokenDirectory := initializeTokenDirectory(logger)
+		tokenFiles, err := os.ReadDir(tokenDirectory)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Error("Error reading token cache", "error", err)
+			exitMessage := fmt.Sprintf("Error reading token cache: %s", err)
+			exitWithLogo(exitMessage)
+		}
+		for _, tokenFile := range tokenFiles {
+			entries = append(entries, backup.Entry{Name: path.Join("tokens", tokenFile.Name()), Path: filepath.Join(tokenDirectory, tokenFile.Name())})
+		}
+	}
+
+	passphrase := backupPassphrase(true)
+
+	// An existing backup is never overwritten
+	out, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		logger.Error("Error creating backup file", "file", outputFile, "error", err)
+		exitMessage := fmt.Sprintf("Error creating backup file: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Creating backup ...", "file", outputFile, "include_tokens", includeTokens)
+	manifest, err = backup.Create(out, passphrase, manifest, entries)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(outputFile)
+		logger.Error("Error creating backup", "file", outputFile, "error", err)
+		exitMessage := fmt.Sprintf("Error creating backup: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Creating backup ... completed", "file", outputFile, "files", len(manifest.Files))
+
+	fmt.Println(textStyleBold(i18n.Tf("Backed up %d files to %s.", len(manifest.Files), outputFile)))
+	if includeTokens {
+		fmt.Println(textStyle(i18n.T("The backup contains your cached tokens, keep it and its passphrase safe.")))
+	}
+}
+
+func RunBackupRestoreCommand(cmd *cobra.Command, cmdArgs []string) {
+	inputFile := cmdArgs[0]
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading yes flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	in, err := os.Open(inputFile)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error opening backup file: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	b, err := backup.Open(in, backupPassphrase(false))
+	_ = in.Close()
+	if err != nil {
+		logger.Error("Error opening backup", "file", inputFile, "error", err)
+		exitMessage := fmt.Sprintf("Error opening backup: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	fmt.Println(textStyle(i18n.Tf("Backup of %s (CLI v%s) with %d files.", b.Manifest.CreatedAt.Local().Format(time.DateTime), b.Manifest.CliVersion, len(b.Manifest.Files))))
+	if !yes && !askYesNo(bufio.NewReader(os.Stdin), i18n.T("Replace the configuration, archive indexes, run history and cached tokens of this machine?")) {
+		exitWithLogo(i18n.T("Aborted"))
+	}
+	logger.Info("Restoring backup ...", "file", inputFile, "created_at", b.Manifest.CreatedAt, "files", len(b.Manifest.Files))
+
+	// The configuration is restored first, it defines the directories of the archives
+	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
+	restored := 0
+	for _, name := range b.FilesIn("config") {
+		restoreBackupFile(logger, b, name, filepath.Join(buchhalterConfigDirectory, path.Base(name)))
+		restored++
+	}
+	err = viper.ReadInConfig()
+	if err == nil {
+		err = relocateRestoredConfig(logger, b.Manifest.HomeDirectory)
+	}
+	if err != nil {
+		logger.Error("Error reading restored configuration", "error", err)
+		exitMessage := fmt.Sprintf("Error reading restored configuration: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	archives := initializeDocumentArchives(logger)
+	defer archives.Close()
+	for name, directories := range b.Manifest.Archives {
+		documentArchive, ok := archives.Get(name)
+		if !ok {
+			logger.Warn("Archive of backup is not configured", "archive", name)
+			fmt.Println(textStyle(i18n.Tf("Skipped the archive %s, it is not configured anymore.", name)))
+			continue
+		}
+		for _, stateFile := range documentArchive.StateFiles() {
+			backupName := path.Join("archives", name, filepath.Base(stateFile))
+			if !backupHasFile(b, backupName) {
+				// E.g. the journal of an index merged into the index file, it must not be applied to the restored index
+				err := os.Remove(stateFile)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					logger.Error("Error removing archive state file", "archive", name, "file", stateFile, "error", err)
+				}
+				continue
+			}
+			restoreBackupFile(logger, b, backupName, stateFile)
+			restored++
+		}
+		err = documentArchive.Relocate(directories.Directory, directories.StagingDirectory)
+		if err != nil {
+			logger.Error("Error relocating archive index", "archive", name, "error", err)
+			exitMessage := fmt.Sprintf("Error relocating the index of archive %s: %s", name, err)
+			exitWithLogo(exitMessage)
+		}
+	}
+
+	buchhalterDirectory = viper.GetString("buchhalter_directory")
+	for _, name := range b.FilesIn("history") {
+		restoreBackupFile(logger, b, name, filepath.Join(buchhalterDirectory, path.Base(name)))
+		restored++
+	}
+	if tokenFiles := b.FilesIn("tokens"); len(tokenFiles) > 0 {
+		tokenDirectory := initializeTokenDirectory(logger)
+		for _, name := range tokenFiles {
+			restoreBackupFile(logger, b, name, filepath.Join(tokenDirectory, path.Base(name)))
+			restored++
+		}
+	}
+	logger.Info("Restoring backup ... completed", "file", inputFile, "restored", restored)
+
+	fmt.Println(textStyleBold(i18n.Tf("Restored %d files from %s.", restored, inputFile)))
+	fmt.Println(textStyle(i18n.T("Secrets stored in the keychain (buchhalter config set --secret) aren't part of backups, set them again on this machine.")))
+}
+
+// relocateRestoredConfig moves the directories of the restored configuration (e.g. buchhalter_directory) from the home
+// directory of the machine of the backup to the home directory of this machine.
+func relocateRestoredConfig(logger *slog.Logger, previousHomeDirectory string) error {
+	homeDirectory, err := os.UserHomeDir()
+	if err != nil || previousHomeDirectory == "" || previousHomeDirectory == homeDirectory {
+		return nil
+	}
+
+	fileConfig := viper.New()
+	fileConfig.SetConfigFile(viper.ConfigFileUsed())
+	err = fileConfig.ReadInConfig()
+	if err != nil {
+		return err
+	}
+	for _, key := range fileConfig.AllKeys() {
+		value, ok := fileConfig.Get(key).(string)
+		if !ok {
+			continue
+		}
+		relativePath, err := filepath.Rel(previousHomeDirectory, value)
+		if !filepath.IsAbs(value) || err != nil || strings.HasPrefix(relativePath, "..") {
+			continue
+		}
+		err = writeConfigValue(key, filepath.Join(homeDirectory, relativePath))
+		if err != nil {
+			return err
+		}
+		logger.Info("Moved directory of restored configuration", "key", key, "previous_directory", value, "directory", viper.GetString(key))
+	}
+	return nil
+}
+
+// backupConfigEntries returns the files of the configuration directory, without credentials unless includeCredentials is set.
+func backupConfigEntries(buchhalterConfigDirectory string, includeCredentials bool) ([]backup.Entry, error) {
+	files, err := os.ReadDir(buchhalterConfigDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []backup.Entry
+	for _, f := range files {
+		if f.IsDir() || containsString(backupSkippedFiles, f.Name()) || (!includeCredentials && containsString(backupCredentialFiles, f.Name())) {
+			continue
+		}
+		entries = append(entries, backup.Entry{Name: path.Join("config", f.Name()), Path: filepath.Join(buchhalterConfigDirectory, f.Name())})
+	}
+	return entries, nil
+}
+
+func restoreBackupFile(logger *slog.Logger, b *backup.Backup, name, filePath string) {
+	err := b.Restore(name, filePath)
+	if err != nil {
+		logger.Error("Error restoring file of backup", "name", name, "file", filePath, "error", err)
+		exitMessage := fmt.Sprintf("Error restoring %s: %s", filePath, err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Restored file of backup", "name", name, "file", filePath)
+}
+
+func backupHasFile(b *backup.Backup, name string) bool {
+	return containsString(b.Manifest.Files, name)
+}
+
+// backupPassphrase returns the passphrase of BUCHHALTER_BACKUP_PASSPHRASE or asks for it (twice for new backups).
+func backupPassphrase(confirm bool) string {
+	if passphrase := os.Getenv(backupPassphraseEnv); passphrase != "" {
+		return passphrase
+	}
+
+	passphrase := readBackupPassphrase(i18n.T("Passphrase of the backup:"))
+	if passphrase == "" {
+		exitWithLogo(i18n.T("The passphrase must not be empty."))
+	}
+	if confirm && readBackupPassphrase(i18n.T("Repeat the passphrase:")) != passphrase {
+		exitWithLogo(i18n.T("The passphrases don't match."))
+	}
+	return passphrase
+}
+
+func readBackupPassphrase(prompt string) string {
+	fmt.Print(prompt + " ")
+	passphrase, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Println()
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading passphrase: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	return string(passphrase)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"buchhalter/lib/browser"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var chromeCmd = &cobra.Command{
+	Use:   "chrome",
+	Short: "Checks and installs the Chrome browser used by recipes",
+	Run:   RunChromeCheckCommand,
+}
+
+var chromeInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Installs a Chrome build buchhalter is tested with",
+	Long:  "The install command downloads a pinned Chrome for Testing build into the buchhalter config directory and configures it in `buchhalter_chrome_path`.",
+	Run:   RunChromeInstallCommand,
+}
+
+func init() {
+	chromeCmd.AddCommand(chromeInstallCmd)
+	rootCmd.AddCommand(chromeCmd)
+}
+
+func RunChromeCheckCommand(cmd *cobra.Command, cmdArgs []string) {
+	chromePath := viper.GetString("buchhalter_chrome_path")
+	chromeVersion, err := browser.DetectChromeVersion(chromePath)
+	if err == nil {
+		err = browser.CheckChromeVersion(chromeVersion)
+	}
+	if err != nil {
+		exitWithLogo(browser.GetHumanReadableChromeErrorMessage(err))
+	}
+
+	fmt.Println(textStyle(fmt.Sprintf("Chrome %s is supported (tested versions: %d - %d).", chromeVersion, browser.ChromeMinSupportedVersion, browser.ChromeMaxTestedVersion)))
+}
+
+func RunChromeInstallCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	installDirectory := filepath.Join(viper.GetString("buchhalter_config_directory"), "chrome")
+	fmt.Println(textStyle(fmt.Sprintf("Installing Chrome %s ...", browser.ChromePinnedVersion)))
+	logger.Info("Installing pinned chrome ...", "version", browser.ChromePinnedVersion, "directory", installDirectory)
+	chromePath, err := browser.InstallPinnedChrome(cmd.Context(), initializeHTTPClient(logger), installDirectory)
+	if err != nil {
+		logger.Error("Error installing pinned chrome", "version", browser.ChromePinnedVersion, "error", err)
+		exitMessage := fmt.Sprintf("Error installing Chrome %s: %s", browser.ChromePinnedVersion, err)
+		exitWithLogo(exitMessage)
+	}
+
+	err = writeConfigValue("buchhalter_chrome_path", chromePath)
+	if err != nil {
+		logger.Error("Error writing config", "error", err)
+		exitMessage := fmt.Sprintf("Error writing config: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	logger.Info("Installing pinned chrome ... completed", "version", browser.ChromePinnedVersion, "chrome_path", chromePath)
+	fmt.Println(textStyle(fmt.Sprintf("Installing Chrome %s ... completed. Recipes use %s from now on.", browser.ChromePinnedVersion, chromePath)))
+}
@@ -0,0 +1,391 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/vault"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var closePeriodCmd = &cobra.Command{
+	Use:   "close-period <period>",
+	Short: "Checks the documents of an accounting period and bundles them for the tax advisor",
+	Long:  "The close-period command verifies that each configured supplier has at least one document in the period (e.g. `2024`, `2024-Q3` or `2024-09`), syncs the suppliers without documents, prints a completeness report and bundles the documents of the period into a zip file.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunClosePeriodCommand,
+}
+
+func init() {
+	closePeriodCmd.Flags().Bool("no-sync", false, "don't sync suppliers without documents in the period")
+	closePeriodCmd.Flags().StringP("output", "o", "", "path of the zip file (default: <buchhalter_directory>/periods/<period>.zip)")
+	closePeriodCmd.Flags().String("group", "", "close the period for the suppliers of a group of buchhalter_groups only (e.g. a client)")
+	rootCmd.AddCommand(closePeriodCmd)
+}
+
+func RunClosePeriodCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	noSync, err := cmd.Flags().GetBool("no-sync")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading no-sync flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	outputFile, err := cmd.Flags().GetString("output")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading output flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	group, err := cmd.Flags().GetString("group")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading group flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	period, err := archive.ParsePeriod(cmdArgs[0], time.Local)
+	if err != nil {
+		exitWithLogo(err.Error())
+	}
+	if team, ok := initializeTeam(cmd.Context(), logger); ok && team.Restrictions.DenyExport {
+		logger.Warn("Role of user in team doesn't permit exports", "team", team.Slug, "role", team.Role)
+		exitWithLogo(i18n.Tf("Your role %s in team %s doesn't permit exports.", team.Role, team.Name))
+	}
+	var groupMembers map[string]bool
+	if group != "" {
+		members, err := groupSuppliers(group)
+		if err != nil {
+			exitWithLogo(err.Error())
+		}
+		groupMembers = make(map[string]bool, len(members))
+		for _, supplier := range members {
+			groupMembers[supplier] = true
+		}
+	}
+	if outputFile == "" {
+		name := period.Name
+		if group != "" {
+			name = group + "-" + period.Name
+		}
+		outputFile = filepath.Join(buchhalterDirectory, "periods", name+".zip")
+	}
+
+	// Init vault provider
+	vaultConfigBinary := viper.GetString("credential_provider_cli_command")
+	vaultConfigBase := viper.GetString("credential_provider_vault")
+	vaultConfigTag := viper.GetString("credential_provider_item_tag")
+	logger.Info("Initializing credential provider", "provider", "1Password", "cli_command", vaultConfigBinary, "vault", vaultConfigBase, "tag", vaultConfigTag)
+	vaultProvider, err := vault.GetProvider(vault.PROVIDER_1PASSWORD, vaultConfigBinary, vaultConfigBase, vaultConfigTag)
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+	_, err = vaultProvider.LoadVaultItems()
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+
+	// The configured suppliers are the suppliers with a recipe and credentials in the vault
+	recipes, err := prepareRecipes(logger, "", vaultProvider, parser.NewRecipeParser(logger, viper.GetString("buchhalter_config_directory"), buchhalterDirectory))
+	if err != nil || len(recipes) == 0 {
+		logger.Error("No recipes found for suppliers", "error", err)
+		exitWithLogo("No recipes found for suppliers")
+	}
+	var suppliers []string
+	seen := make(map[string]bool)
+	for _, recipe := range recipes {
+		if groupMembers != nil && !groupMembers[recipe.recipe.Supplier] {
+			continue
+		}
+		if !seen[recipe.recipe.Supplier] {
+			seen[recipe.recipe.Supplier] = true
+			suppliers = append(suppliers, recipe.recipe.Supplier)
+		}
+	}
+	sort.Strings(suppliers)
+	if group != "" && len(suppliers) == 0 {
+		exitWithLogo(fmt.Sprintf("No recipes found for the suppliers of group %s", group))
+	}
+
+	archives := initializeDocumentArchives(logger)
+	documents := periodDocuments(cmd.Context(), logger, archives, period, groupMembers)
+	missingSuppliers := missingPeriodSuppliers(suppliers, documents)
+
+	if len(missingSuppliers) > 0 && !noSync {
+		logger.Info("Syncing suppliers without documents in period", "period", period.Name, "suppliers", missingSuppliers)
+		runSync(cmd.Context(), logger, vaultProvider, archives, nil, syncOptions{selectedSuppliers: missingSuppliers, force: true})
+
+		archives = initializeDocumentArchives(logger)
+		documents = periodDocuments(cmd.Context(), logger, archives, period, groupMembers)
+		missingSuppliers = missingPeriodSuppliers(suppliers, documents)
+	}
+
+	report := periodReport(period, group, suppliers, documents)
+	if spend := spendReport(period, documents); spend != "" {
+		report += "\n" + spend
+	}
+	fmt.Println()
+	fmt.Print(report)
+
+	err = os.MkdirAll(filepath.Dir(outputFile), 0755)
+	if err != nil {
+		logger.Error("Error creating directory of period bundle", "file", outputFile, "error", err)
+		exitMessage := fmt.Sprintf("Error creating directory of %s: %s", outputFile, err)
+		exitWithLogo(exitMessage)
+	}
+	err = archive.BundleFiles(outputFile, documents, map[string][]byte{"report.txt": []byte(report)})
+	if err != nil {
+		logger.Error("Error writing period bundle", "file", outputFile, "error", err)
+		exitMessage := fmt.Sprintf("Error writing %s: %s", outputFile, err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Period bundle written", "period", period.Name, "group", group, "file", outputFile, "documents", len(documents), "missing_suppliers", missingSuppliers)
+
+	fmt.Println()
+	fmt.Println(textStyle(i18n.Tf("%d documents bundled in %s", len(documents), outputFile)))
+	if len(missingSuppliers) > 0 {
+		fmt.Println(textStyleBold(i18n.Tf("The period is incomplete, no documents of: %s", strings.Join(missingSuppliers, ", "))))
+		os.Exit(1)
+	}
+}
+
+// periodDocuments returns the documents of all archives added in period. Rejected documents are skipped.
+// If suppliers is not nil, only the documents of these suppliers are returned.
+func periodDocuments(ctx context.Context, logger *slog.Logger, archives *archive.Archives, period archive.Period, suppliers map[string]bool) map[string]archive.File {
+	err := archives.BuildArchiveIndex(ctx)
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+		exitMessage := fmt.Sprintf("Error building document archive index: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	documents := map[string]archive.File{}
+	for checksum, f := range archives.GetFileIndex() {
+		if f.Rejected || !period.Contains(f.AddedAt) || (suppliers != nil && !suppliers[f.Supplier]) {
+			continue
+		}
+		documents[checksum] = f
+	}
+
+	return documents
+}
+
+func missingPeriodSuppliers(suppliers []string, documents map[string]archive.File) []string {
+	found := map[string]bool{}
+	for _, f := range documents {
+		found[f.Supplier] = true
+	}
+
+	var missing []string
+	for _, supplier := range suppliers {
+		if !found[supplier] {
+			missing = append(missing, supplier)
+		}
+	}
+
+	return missing
+}
+
+// periodReport lists the number of documents per supplier. Documents of suppliers that are not configured (anymore) are listed as well.
+// The report of a group is titled with its name.
+func periodReport(period archive.Period, group string, suppliers []string, documents map[string]archive.File) string {
+	counts := map[string]int{}
+	for _, f := range documents {
+		counts[f.Supplier]++
+	}
+	configured := map[string]bool{}
+	for _, supplier := range suppliers {
+		configured[supplier] = true
+	}
+	var others []string
+	for supplier := range counts {
+		if !configured[supplier] {
+			others = append(others, supplier)
+		}
+	}
+	sort.Strings(others)
+
+	var b strings.Builder
+	title := i18n.Tf("Completeness report %s", period.Name)
+	if group != "" {
+		title = i18n.Tf("Completeness report %s of %s", period.Name, group)
+	}
+	fmt.Fprintf(&b, "%s (%s - %s)\n\n", title, period.Start.Format("2006-01-02"), period.End.AddDate(0, 0, -1).Format("2006-01-02"))
+	for _, supplier := range suppliers {
+		status := i18n.T("OK")
+		if counts[supplier] == 0 {
+			status = i18n.T("MISSING")
+		}
+		fmt.Fprintf(&b, "  %-30s %5d  %s\n", supplier, counts[supplier], status)
+	}
+	for _, supplier := range others {
+		name := supplier
+		if name == "" {
+			name = i18n.T("(unknown)")
+		}
+		fmt.Fprintf(&b, "  %-30s %5d  %s\n", name, counts[supplier], i18n.T("NOT CONFIGURED"))
+	}
+	fmt.Fprintf(&b, "\n  %-30s %5d\n", i18n.T("Total"), len(documents))
+
+	return b.String()
+}
+
+// spendReport sums up the amounts of the documents per supplier and currency, using the currency and VAT rate of
+// buchhalter_supplier_currency and buchhalter_supplier_vat_rate for documents without them.
+// Amounts taken from these defaults are marked, conflicts with extracted values are listed. Empty if no document has an amount.
+func spendReport(period archive.Period, documents map[string]archive.File) string {
+	type spendKey struct{ supplier, currency string }
+	type spendSum struct {
+		gross, vat int64
+		defaults   bool
+	}
+	defaults := configuredSupplierDefaults()
+
+	sums := map[spendKey]*spendSum{}
+	totals := map[string]*spendSum{}
+	var conflicts, invalid []string
+	withoutAmount, withoutVatRate := 0, 0
+	for _, f := range documents {
+		if f.Metadata == nil || f.Metadata.Amount == "" {
+			withoutAmount++
+			continue
+		}
+		spend, err := archive.ResolveSpend(f.Metadata, defaults[f.Supplier])
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s %s: %s", f.Supplier, filepath.Base(f.Path), err))
+			continue
+		}
+		if !spend.HasVatRate {
+			withoutVatRate++
+		}
+		for _, conflict := range spend.Conflicts {
+			conflicts = append(conflicts, fmt.Sprintf("%s %s: %s", f.Supplier, filepath.Base(f.Path), conflict))
+		}
+
+		key := spendKey{f.Supplier, spend.Currency}
+		if sums[key] == nil {
+			sums[key] = &spendSum{}
+		}
+		if totals[spend.Currency] == nil {
+			totals[spend.Currency] = &spendSum{}
+		}
+		for _, sum := range []*spendSum{sums[key], totals[spend.Currency]} {
+			sum.gross += spend.Gross
+			sum.vat += spend.Vat
+			sum.defaults = sum.defaults || len(spend.Defaults) > 0
+		}
+	}
+	if len(sums) == 0 && len(invalid) == 0 {
+		return ""
+	}
+
+	keys := make([]spendKey, 0, len(sums))
+	for key := range sums {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].supplier != keys[j].supplier {
+			return keys[i].supplier < keys[j].supplier
+		}
+		return keys[i].currency < keys[j].currency
+	})
+	line := func(b *strings.Builder, name, currency string, sum *spendSum) {
+		marker := ""
+		if sum.defaults {
+			marker = "*"
+		}
+		if currency == "" {
+			currency = "?"
+		}
+		fmt.Fprintf(b, "  %-30s %12s %-3s %12s %s%s\n", name, archive.FormatAmount(sum.gross), currency, archive.FormatAmount(sum.vat), i18n.T("VAT"), marker)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", i18n.Tf("Spend report %s (gross amounts)", period.Name))
+	for _, key := range keys {
+		name := key.supplier
+		if name == "" {
+			name = i18n.T("(unknown)")
+		}
+		line(&b, name, key.currency, sums[key])
+	}
+	b.WriteString("\n")
+	currencyNames := make([]string, 0, len(totals))
+	for currency := range totals {
+		currencyNames = append(currencyNames, currency)
+	}
+	sort.Strings(currencyNames)
+	for _, currency := range currencyNames {
+		line(&b, i18n.T("Total"), currency, totals[currency])
+	}
+
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "  %s\n", i18n.T("* incl. the currency or VAT rate of buchhalter_supplier_currency or buchhalter_supplier_vat_rate"))
+	if withoutAmount > 0 {
+		fmt.Fprintf(&b, "  %s\n", i18n.Tf("%d documents without amount are not included", withoutAmount))
+	}
+	if withoutVatRate > 0 {
+		fmt.Fprintf(&b, "  %s\n", i18n.Tf("%d documents without VAT rate are included without VAT", withoutVatRate))
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		fmt.Fprintf(&b, "\n  %s\n", i18n.T("Conflicts with the supplier defaults (the extracted values are used):"))
+		for _, conflict := range conflicts {
+			fmt.Fprintf(&b, "    %s\n", conflict)
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		fmt.Fprintf(&b, "\n  %s\n", i18n.T("Not included because of invalid amounts or VAT rates:"))
+		for _, document := range invalid {
+			fmt.Fprintf(&b, "    %s\n", document)
+		}
+	}
+
+	return b.String()
+}
+
+// configuredSupplierDefaults returns the currencies and VAT rates of buchhalter_supplier_currency and
+// buchhalter_supplier_vat_rate per supplier.
+func configuredSupplierDefaults() map[string]archive.SupplierDefaults {
+	defaults := map[string]archive.SupplierDefaults{}
+	for supplier, currency := range viper.GetStringMapString("buchhalter_supplier_currency") {
+		d := defaults[supplier]
+		d.Currency = currency
+		defaults[supplier] = d
+	}
+	for supplier, vatRate := range viper.GetStringMapString("buchhalter_supplier_vat_rate") {
+		d := defaults[supplier]
+		d.VatRate = vatRate
+		defaults[supplier] = d
+	}
+	return defaults
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"buchhalter/lib/keychain"
+	"buchhalter/lib/redact"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Changes the buchhalter configuration",
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Sets a configuration value",
+	Long:  "The set command writes a value to the configuration file. With --secret, the value is stored in the keychain of the operating system and the configuration file only references it (e.g. for webhook URLs with tokens or passwords).",
+	Args:  cobra.ExactArgs(2),
+	Run:   RunConfigSetCommand,
+}
+
+func init() {
+	configSetCmd.Flags().Bool("secret", false, "store the value in the keychain instead of the configuration file")
+	configCmd.AddCommand(configSetCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func RunConfigSetCommand(cmd *cobra.Command, cmdArgs []string) {
+	key, value := cmdArgs[0], cmdArgs[1]
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	secret, err := cmd.Flags().GetBool("secret")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading secret flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	if secret {
+		redact.AddSecrets(value)
+		err = keychain.New().Set(key, value)
+		if err != nil {
+			logger.Error("Error storing secret in keychain", "key", key, "error", err)
+			exitWithLogo(keychain.GetHumanReadableErrorMessage(err))
+		}
+		value = keychain.Reference(key)
+	}
+
+	err = writeConfigValue(key, value)
+	if err != nil {
+		logger.Error("Error writing configuration", "key", key, "error", err)
+		exitMessage := fmt.Sprintf("Error writing configuration: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Configuration value set", "key", key, "secret", secret)
+
+	if secret {
+		fmt.Println(textStyle(fmt.Sprintf("Stored %s in the keychain, the configuration file references it as %s.", key, value)))
+		return
+	}
+	fmt.Println(textStyle(fmt.Sprintf("Set %s to %s.", key, value)))
+}
+
+// writeConfigValue sets key in the configuration file.
+// Only the values of the file are written: values resolved at runtime (e.g. keychain secrets or the API token) stay out of it.
+func writeConfigValue(key string, value interface{}) error {
+	configFile := viper.ConfigFileUsed()
+	fileConfig := viper.New()
+	fileConfig.SetConfigFile(configFile)
+	err := fileConfig.ReadInConfig()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	fileConfig.Set(key, value)
+	// Keep the value of the running command up to date as well
+	viper.Set(key, value)
+	return fileConfig.WriteConfigAs(configFile)
+}
+
+// resolveKeychainSecrets replaces configuration values referencing keychain secrets (`keychain:<key>`) with the secrets.
+// Unresolvable secrets are reported and keep their reference.
+func resolveKeychainSecrets() {
+	k := keychain.New()
+	for _, key := range viper.AllKeys() {
+		value, ok := viper.Get(key).(string)
+		if !ok {
+			continue
+		}
+		secretKey, ok := keychain.ParseReference(value)
+		if !ok {
+			continue
+		}
+
+		secret, err := k.Get(secretKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s from keychain: %s\n", key, keychain.GetHumanReadableErrorMessage(err))
+			continue
+		}
+		redact.AddSecrets(secret)
+		viper.Set(key, secret)
+	}
+}
@@ -2,7 +2,10 @@ package cmd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,17 +13,29 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/keychain"
 	"buchhalter/lib/repository"
 )
 
+// apiTokenEnv sets the API token of connect without a prompt, e.g. when provisioning servers
+const apiTokenEnv = "BUCHHALTER_API_TOKEN"
+
+// apiTokenSecretKey is the keychain key of the API token stored with connect --secret
+const apiTokenSecretKey = "buchhalter_api_token"
+
 var connectCmd = &cobra.Command{
 	Use:   "connect",
 	Short: "Connects to the Buchhalter Platform and verifies your premium membership",
-	Long:  "The connect command verifies your premium membership by logging into the Buchhalter Platform. This is required to use your premium membership.",
+	Long:  "The connect command verifies your premium membership by logging into the Buchhalter Platform. This is required to use your premium membership. To provision servers (e.g. with Ansible or Terraform), pass the API token with --token or the BUCHHALTER_API_TOKEN environment variable and --non-interactive: connect then never reads from stdin and exits with an error code if the token is invalid.",
 	Run:   RunConnectCommand,
 }
 
 func init() {
+	connectCmd.Flags().String("token", "", "API token of the Buchhalter Platform (default: BUCHHALTER_API_TOKEN environment variable)")
+	connectCmd.Flags().Bool("non-interactive", false, "never ask for input and exit with an error code if connecting fails")
+	connectCmd.Flags().String("team", "", "slug of the team to connect to (default: your first team)")
+	connectCmd.Flags().Bool("secret", false, "store the API token in the keychain instead of the API token file")
 	rootCmd.AddCommand(connectCmd)
 }
 
@@ -41,42 +56,64 @@ func RunConnectCommand(cmd *cobra.Command, cmdArgs []string) {
 	logger.Info("Booting up", "development_mode", developmentMode)
 	defer logger.Info("Shutting down")
 
-	// Print welcome message
-	s := fmt.Sprintf(
-		"%s\n%s\n%s%s\n%s\n",
-		headerStyle(LogoText),
-		textStyle("Automatically sync all your incoming invoices from your suppliers. "),
-		textStyle("More information at: "),
-		textStyleBold("https://buchhalter.ai"),
-		textStyleGrayBold(fmt.Sprintf("Using CLI v%s", cliVersion)),
-	)
-	if developmentMode {
-		s += textStyleGrayBold(fmt.Sprintf("Build time: %s\nCommit: %s\n", cliBuildTime, cliCommitHash))
+	apiToken, err := cmd.Flags().GetString("token")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading token flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	nonInteractive, err := cmd.Flags().GetBool("non-interactive")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading non-interactive flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	team, err := cmd.Flags().GetString("team")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading team flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	secret, err := cmd.Flags().GetBool("secret")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading secret flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if apiToken == "" {
+		apiToken = os.Getenv(apiTokenEnv)
+	}
+	apiToken = strings.TrimSpace(apiToken)
+	if nonInteractive && apiToken == "" {
+		connectFailed(logger, true, "No API token given, pass it with --token or the BUCHHALTER_API_TOKEN environment variable.")
+		return
+	}
+
+	// Print welcome message, provisioning tools only need the result
+	if !nonInteractive {
+		printConnectWelcome(developmentMode)
 	}
-	fmt.Println(s)
 	fmt.Println(textStyle("Connecting to the Buchhalter Platform ..."))
 
 	// Read text input from user (API key)
-	logger.Info("Reading user input")
-	apiToken := ""
-	for {
+	for apiToken == "" {
+		logger.Info("Reading user input")
 		fmt.Print("Your buchhalter API-Token: ")
 		reader := bufio.NewReader(os.Stdin)
 		input, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) && input == "" {
+			// Stdin is closed, e.g. in a provisioning run without --non-interactive
+			fmt.Println()
+			connectFailed(logger, true, "No API token given, pass it with --token or the BUCHHALTER_API_TOKEN environment variable.")
+		}
 		if err != nil {
 			logger.Error("User input could not be read", "error", err)
 			fmt.Println("An error occurred while reading your api token. Please try again", err)
 		}
-		apiToken = strings.TrimSuffix(input, "\n")
-		if len(apiToken) > 0 {
-			break
-		}
+		apiToken = strings.TrimSpace(input)
 	}
 
 	// Making API call
 	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
 	apiHost := viper.GetString("buchhalter_api_host")
-	buchhalterAPIClient, err := repository.NewBuchhalterAPIClient(logger, apiHost, buchhalterConfigDirectory, apiToken, cliVersion)
+	httpClient := initializeHTTPClient(logger)
+	buchhalterAPIClient, err := repository.NewBuchhalterAPIClient(logger, httpClient, apiHost, buchhalterConfigDirectory, apiToken, cliVersion)
 	if err != nil {
 		logger.Error("Error initializing Buchhalter API client", "error", err)
 		exitMessage := fmt.Sprintf("Error initializing Buchhalter API client: %s", err)
@@ -84,19 +121,17 @@ func RunConnectCommand(cmd *cobra.Command, cmdArgs []string) {
 	}
 
 	logger.Info("Making API call")
-	cliSyncResponse, err := buchhalterAPIClient.GetAuthenticatedUser()
+	cliSyncResponse, err := buchhalterAPIClient.GetAuthenticatedUser(cmd.Context())
 	fmt.Println("")
 	if err != nil {
 		logger.Error("GetAuthenticatedUser API call not successful input could not be read", "error", err)
-		fmt.Println(textStyle("Connecting to the Buchhalter Platform ... unsuccessful"))
-		fmt.Println(textStyle("Please check your API-Token at https://app.buchhalter.ai/token and try again."))
+		connectFailed(logger, nonInteractive, httpclient.GetHumanReadableErrorMessage(err), "Please check your API-Token at https://app.buchhalter.ai/token and try again.")
 		return
 	}
 
 	if cliSyncResponse == nil {
 		logger.Error("GetAuthenticatedUser API call successful, but no valid response due to wrong API key")
-		fmt.Println(textStyle("Connecting to the Buchhalter Platform ... unsuccessful"))
-		fmt.Println(textStyle("Please check your API-Token at https://app.buchhalter.ai/token and try again."))
+		connectFailed(logger, nonInteractive, "Please check your API-Token at https://app.buchhalter.ai/token and try again.")
 		return
 	}
 
@@ -111,17 +146,72 @@ func RunConnectCommand(cmd *cobra.Command, cmdArgs []string) {
 	homeDir, _ := os.UserHomeDir()
 	buchhalterConfigDir := filepath.Join(homeDir, ".buchhalter")
 
-	// We select the first team for now
-	// TODO Make this selectable
-	teamSlug := cliSyncResponse.User.Teams[0].Slug
+	// Without --team, the first team is selected
+	var teamSlugs []string
+	for _, t := range cliSyncResponse.User.Teams {
+		teamSlugs = append(teamSlugs, t.Slug)
+	}
+	if len(teamSlugs) == 0 {
+		logger.Error("Authenticated user has no team")
+		connectFailed(logger, nonInteractive, "You are not a member of any team of the Buchhalter Platform.")
+		return
+	}
+	teamSlug := teamSlugs[0]
+	if team != "" {
+		if !containsString(teamSlugs, team) {
+			logger.Error("Authenticated user is no member of team", "team", team, "teams", teamSlugs)
+			connectFailed(logger, nonInteractive, fmt.Sprintf("You are not a member of the team %s (your teams: %s).", team, strings.Join(teamSlugs, ", ")))
+			return
+		}
+		teamSlug = team
+	}
+
+	// With --secret, the API token file only references the token in the keychain
+	storedToken := apiToken
+	if secret {
+		err = keychain.New().Set(apiTokenSecretKey, apiToken)
+		if err != nil {
+			logger.Error("Error storing API token in keychain", "error", err)
+			connectFailed(logger, nonInteractive, keychain.GetHumanReadableErrorMessage(err))
+			return
+		}
+		storedToken = keychain.Reference(apiTokenSecretKey)
+	}
 	buchhalterConfig := repository.NewBuchhalterConfig(logger, buchhalterConfigDir)
-	err = buchhalterConfig.WriteLocalAPIConfig(apiToken, teamSlug)
+	err = buchhalterConfig.WriteLocalAPIConfig(storedToken, teamSlug)
 	if err != nil {
 		logger.Error("API token could not be written to file", "error", err)
-		fmt.Println(textStyle("Connecting to the Buchhalter Platform ... unsuccessful"))
-		fmt.Println(textStyle("Token could not be written to disk. Please try again."))
+		connectFailed(logger, nonInteractive, "Token could not be written to disk. Please try again.")
 		return
 	}
+	logger.Info("Connected to the Buchhalter Platform", "team", teamSlug, "secret", secret)
 
 	fmt.Println(textStyle("Connecting to the Buchhalter Platform ... successful"))
 }
+
+func printConnectWelcome(developmentMode bool) {
+	s := fmt.Sprintf(
+		"%s\n%s\n%s%s\n%s\n",
+		headerStyle(LogoText),
+		textStyle("Automatically sync all your incoming invoices from your suppliers. "),
+		textStyle("More information at: "),
+		textStyleBold("https://buchhalter.ai"),
+		textStyleGrayBold(fmt.Sprintf("Using CLI v%s", cliVersion)),
+	)
+	if developmentMode {
+		s += textStyleGrayBold(fmt.Sprintf("Build time: %s\nCommit: %s\n", cliBuildTime, cliCommitHash))
+	}
+	fmt.Println(s)
+}
+
+// connectFailed prints why connecting failed. Non-interactive runs exit with an error code, e.g. to fail a provisioning run.
+func connectFailed(logger *slog.Logger, nonInteractive bool, messages ...string) {
+	fmt.Println(textStyle("Connecting to the Buchhalter Platform ... unsuccessful"))
+	for _, message := range messages {
+		fmt.Println(textStyle(message))
+	}
+	if nonInteractive {
+		logger.Info("Shutting down")
+		os.Exit(1)
+	}
+}
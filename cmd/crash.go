@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"buchhalter/lib/crash"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/repository"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/viper"
+)
+
+var (
+	// crashProgram is the running interactive program, its terminal is restored before a crash is reported
+	crashProgram atomic.Pointer[tea.Program]
+	crashOnce    sync.Once
+)
+
+// newProgram creates an interactive bubbletea program. Panics are not caught by bubbletea (which dumps the stack over
+// the TUI), but reported by recoverCrash.
+func newProgram(model tea.Model, opts ...tea.ProgramOption) *tea.Program {
+	p := tea.NewProgram(model, append(opts, tea.WithoutCatchPanics())...)
+	crashProgram.Store(p)
+	return p
+}
+
+// recoverCrash reports a panic of the calling goroutine, see reportCrash.
+// It must be deferred in Execute and at the start of the goroutines of commands.
+func recoverCrash() {
+	if r := recover(); r != nil {
+		reportCrash(r, debug.Stack())
+	}
+}
+
+// reportCrash restores the terminal, writes a sanitized crash report and, in interactive sessions, asks the user
+// whether to submit it to the Buchhalter Platform. Only the first crash is reported, the process exits afterward.
+func reportCrash(value any, stack []byte) {
+	crashOnce.Do(func() {
+		if p := crashProgram.Load(); p != nil {
+			_ = p.ReleaseTerminal()
+		}
+
+		report := crash.NewReport(value, stack, crashCommand(), cliVersion)
+		fmt.Println()
+		fmt.Println(errorStyle.Render(i18n.Tf("buchhalter crashed unexpectedly: %s", report.Message)))
+		filePath, err := report.Write(filepath.Join(viper.GetString("buchhalter_directory"), crash.DIRECTORY_NAME))
+		if err != nil {
+			// Without a report file, the stack is the only trace of the crash
+			fmt.Println(report.Stack)
+			os.Exit(1)
+		}
+		fmt.Println(i18n.Tf("A crash report was written to %s. It contains the error message and the code location of the crash, but neither documents nor credentials.", filePath))
+
+		if isInteractiveTerminal() {
+			fmt.Print(i18n.T("Send the crash report to the Buchhalter Platform to help us fix the error? (y/n) "))
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if strings.EqualFold(strings.TrimSpace(answer), "y") {
+				// The context of the command may be gone, ctrl+c cancels sending the report
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+				err = submitCrashReport(ctx, report)
+				stop()
+				if err != nil {
+					fmt.Println(errorStyle.Render(i18n.Tf("Sending the crash report failed: %s", err)))
+				} else {
+					fmt.Println(i18n.T("Crash report sent, thank you!"))
+				}
+			}
+		}
+		os.Exit(1)
+	})
+}
+
+// crashCommand returns the path of the executed command (e.g. "buchhalter sync") without its arguments.
+func crashCommand() string {
+	c, _, err := rootCmd.Find(os.Args[1:])
+	if err != nil || c == nil {
+		return rootCmd.Name()
+	}
+	return c.CommandPath()
+}
+
+func submitCrashReport(ctx context.Context, report crash.Report) error {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	buchhalterAPIClient, err := repository.NewBuchhalterAPIClient(logger, initializeHTTPClient(logger), viper.GetString("buchhalter_api_host"), viper.GetString("buchhalter_config_directory"), viper.GetString("buchhalter_api_token"), cliVersion)
+	if err != nil {
+		return err
+	}
+	return buchhalterAPIClient.SendCrashReport(ctx, report)
+}
+
+// isInteractiveTerminal returns true if buchhalter runs in a terminal and can ask the user, e.g. not as service.
+func isInteractiveTerminal() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		info, err := f.Stat()
+		if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"buchhalter/lib/browser"
+	"buchhalter/lib/diagnostics"
+	"buchhalter/lib/parser"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "Tools to debug failing supplier recipes",
+}
+
+var debugBundleCmd = &cobra.Command{
+	Use:   "bundle <supplier>",
+	Short: "Creates a shareable diagnostic bundle for a supplier",
+	Long:  "The bundle command collects the recipe version, the step timeline of the last run, redacted logs, debug artifacts (see `buchhalter_debug_artifacts`), the Chrome version and OS info of a supplier into a zip file. Attach it to a GitHub issue or a support ticket.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunDebugBundleCommand,
+}
+
+func init() {
+	debugBundleCmd.Flags().StringP("output", "o", "", "path of the zip file (default: buchhalter-debug-<supplier>-<timestamp>.zip)")
+	debugCmd.AddCommand(debugBundleCmd)
+	rootCmd.AddCommand(debugCmd)
+}
+
+func RunDebugBundleCommand(cmd *cobra.Command, cmdArgs []string) {
+	supplier := cmdArgs[0]
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	outputFile, err := cmd.Flags().GetString("output")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading output flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("buchhalter-debug-%s-%s.zip", supplier, time.Now().Format("20060102-150405"))
+	}
+
+	// A bundle without recipe is still helpful, e.g. if the recipe database is broken
+	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
+	recipeParser := parser.NewRecipeParser(logger, buchhalterConfigDirectory, buchhalterDirectory)
+	_, err = recipeParser.LoadRecipes(developmentMode)
+	if err != nil {
+		logger.Error("Error loading recipes for suppliers", "error", err)
+	}
+	recipe := recipeParser.GetRecipeBySupplier(supplier)
+	info := diagnostics.BundleInfo{
+		Supplier:      supplier,
+		OicdbVersion:  recipeParser.OicdbVersion,
+		CliVersion:    cliVersion,
+		CliCommitHash: cliCommitHash,
+	}
+	if recipe != nil {
+		info.RecipeVersion = recipe.Version
+	}
+
+	logFile := filepath.Join(buchhalterDirectory, "buchhalter-cli.log")
+	bundleCreator := diagnostics.NewBundleCreator(logger, logFile, browser.DebugArtifactsDirectory(buchhalterDirectory))
+	err = bundleCreator.CreateBundle(outputFile, info, recipe)
+	if err != nil {
+		logger.Error("Error creating debug bundle", "supplier", supplier, "file", outputFile, "error", err)
+		exitMessage := fmt.Sprintf("Error creating debug bundle: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	fmt.Println(textStyle(fmt.Sprintf("Debug bundle for %s written to %s", supplier, outputFile)))
+	fmt.Println(textStyle("Please check its content before sharing it."))
+}
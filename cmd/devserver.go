@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"buchhalter/lib/devserver"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var devserverCmd = &cobra.Command{
+	Use:   "devserver",
+	Short: "Starts a fake supplier portal to develop and test recipes",
+	Long:  "The devserver command serves a fake supplier portal on localhost with a login form, 2FA, an invoice list with downloads and an OAuth2 identity provider. Use it to test drivers and recipe step actions end-to-end without a real supplier. Example recipes are served at /recipes/browser.json and /recipes/client.json.",
+	Run:   RunDevserverCommand,
+}
+
+func init() {
+	devserverCmd.Flags().String("address", "127.0.0.1:8742", "address to listen on")
+	devserverCmd.Flags().String("username", "buchhalter", "username of the fake portal")
+	devserverCmd.Flags().String("password", "buchhalter", "password of the fake portal")
+	devserverCmd.Flags().String("totp", "123456", "expected one-time password, empty to disable 2FA")
+	rootCmd.AddCommand(devserverCmd)
+}
+
+func RunDevserverCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	flags := map[string]string{}
+	for _, name := range []string{"address", "username", "password", "totp"} {
+		flags[name], err = cmd.Flags().GetString(name)
+		if err != nil {
+			exitMessage := fmt.Sprintf("Error reading %s flag: %s", name, err)
+			exitWithLogo(exitMessage)
+		}
+	}
+
+	server := devserver.NewServer(logger, flags["username"], flags["password"], flags["totp"], devserver.DefaultInvoices)
+
+	logger.Info("Starting devserver", "address", flags["address"], "totp_enabled", flags["totp"] != "")
+	fmt.Println(textStyle(fmt.Sprintf("Serving the fake supplier portal on http://%s (press ctrl+c to stop)", flags["address"])))
+	fmt.Println(textStyle(fmt.Sprintf("Login with username %q, password %q and one-time password %q", flags["username"], flags["password"], flags["totp"])))
+	err = http.ListenAndServe(flags["address"], server.Handler())
+	if err != nil {
+		logger.Error("Error serving devserver", "address", flags["address"], "error", err)
+		exitMessage := fmt.Sprintf("Error serving devserver on %s: %s", flags["address"], err)
+		exitWithLogo(exitMessage)
+	}
+}
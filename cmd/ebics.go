@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"buchhalter/lib/ebics"
+	"buchhalter/lib/keychain"
+	"buchhalter/lib/parser"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ebicsCmd = &cobra.Command{
+	Use:   "ebics",
+	Short: "Manages the EBICS keys of company accounts",
+}
+
+var ebicsInitCmd = &cobra.Command{
+	Use:   "init <supplier>",
+	Short: "Creates EBICS keys and sends them to the bank",
+	Long:  "The init command creates the signature, authentication and encryption keys of an EBICS subscriber, stores them in the keychain and sends them to the bank (INI and HIA). Print, sign and send the initialization letters to your bank, which activates the subscriber afterwards.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunEbicsInitCommand,
+}
+
+var ebicsActivateCmd = &cobra.Command{
+	Use:   "activate <supplier>",
+	Short: "Retrieves the public keys of the bank",
+	Long:  "The activate command retrieves the public keys of the bank (HPB) once the bank activated the subscriber. Compare the shown hashes with the hashes published by your bank before confirming them.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunEbicsActivateCommand,
+}
+
+func init() {
+	ebicsInitCmd.Flags().String("partner-id", "", "EBICS partner ID (Kunden-ID) assigned by the bank")
+	ebicsInitCmd.Flags().String("user-id", "", "EBICS user ID (Teilnehmer-ID) assigned by the bank")
+	ebicsInitCmd.Flags().Bool("force", false, "replace existing keys of the supplier")
+	ebicsActivateCmd.Flags().Bool("yes", false, "trust the keys of the bank without confirmation")
+	ebicsCmd.AddCommand(ebicsInitCmd)
+	ebicsCmd.AddCommand(ebicsActivateCmd)
+	rootCmd.AddCommand(ebicsCmd)
+}
+
+func RunEbicsInitCommand(cmd *cobra.Command, cmdArgs []string) {
+	supplier := cmdArgs[0]
+	partnerID, _ := cmd.Flags().GetString("partner-id")
+	userID, _ := cmd.Flags().GetString("user-id")
+	force, _ := cmd.Flags().GetBool("force")
+	if partnerID == "" || userID == "" {
+		exitWithLogo("The partner ID (--partner-id) and user ID (--user-id) of your EBICS contract are required")
+	}
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	step := ebicsRecipeStep(logger, supplier)
+
+	keyStore := keychain.New()
+	if existing, loadErr := ebics.LoadKeys(keyStore, supplier); loadErr == nil && !force {
+		exitWithLogo(fmt.Sprintf("EBICS keys of %s exist already (%s), use --force to replace them", supplier, existing.State))
+	}
+
+	fmt.Println(textStyle("Creating EBICS keys ..."))
+	keys, err := ebics.GenerateKeys(step.Ebics.HostID, partnerID, userID)
+	if err != nil {
+		logger.Error("Error creating EBICS keys", "error", err)
+		exitWithLogo(fmt.Sprintf("Error creating EBICS keys: %s", err))
+	}
+	// The keys are stored before they are sent, the bank only accepts INI and HIA once per subscriber
+	err = ebics.SaveKeys(keyStore, supplier, keys)
+	if err != nil {
+		logger.Error("Error storing EBICS keys in keychain", "supplier", supplier, "error", err)
+		exitWithLogo(keychain.GetHumanReadableErrorMessage(err))
+	}
+
+	client := ebics.NewClient(logger, initializeHTTPClient(logger), step.URL, keys, "buchhalter-cli "+cliVersion)
+	ctx := cmd.Context()
+	fmt.Println(textStyle("Sending the signature key (INI) ..."))
+	err = client.INI(ctx)
+	if err != nil {
+		logger.Error("Error sending INI", "supplier", supplier, "error", err)
+		exitWithLogo(fmt.Sprintf("Error sending the signature key: %s", err))
+	}
+	fmt.Println(textStyle("Sending the authentication and encryption keys (HIA) ..."))
+	err = client.HIA(ctx)
+	if err != nil {
+		logger.Error("Error sending HIA", "supplier", supplier, "error", err)
+		exitWithLogo(fmt.Sprintf("Error sending the authentication and encryption keys: %s", err))
+	}
+	keys.State = ebics.STATE_INITIALIZED
+	err = ebics.SaveKeys(keyStore, supplier, keys)
+	if err != nil {
+		logger.Error("Error storing EBICS keys in keychain", "supplier", supplier, "error", err)
+		exitWithLogo(keychain.GetHumanReadableErrorMessage(err))
+	}
+
+	lettersFile := filepath.Join(buchhalterDirectory, "ebics", supplier+"-letters.txt")
+	err = os.MkdirAll(filepath.Dir(lettersFile), 0755)
+	if err == nil {
+		err = os.WriteFile(lettersFile, []byte(ebics.Letters(keys, supplier)), 0600)
+	}
+	if err != nil {
+		logger.Error("Error writing initialization letters", "file", lettersFile, "error", err)
+		exitWithLogo(fmt.Sprintf("Error writing initialization letters: %s", err))
+	}
+
+	fmt.Println()
+	fmt.Println(textStyleBold(fmt.Sprintf("The keys of %s were sent to the bank.", supplier)))
+	fmt.Println(textStyle(fmt.Sprintf("Print and sign the initialization letters %s and send them to your bank.", lettersFile)))
+	fmt.Println(textStyle(fmt.Sprintf("Once the bank activated your access, run: buchhalter ebics activate %s", supplier)))
+}
+
+func RunEbicsActivateCommand(cmd *cobra.Command, cmdArgs []string) {
+	supplier := cmdArgs[0]
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	step := ebicsRecipeStep(logger, supplier)
+
+	keyStore := keychain.New()
+	keys, err := ebics.LoadKeys(keyStore, supplier)
+	if err != nil {
+		logger.Error("Error loading EBICS keys", "supplier", supplier, "error", err)
+		exitWithLogo(fmt.Sprintf("No EBICS keys of %s found, run: buchhalter ebics init %s", supplier, supplier))
+	}
+
+	fmt.Println(textStyle("Retrieving the keys of the bank (HPB) ..."))
+	client := ebics.NewClient(logger, initializeHTTPClient(logger), step.URL, keys, "buchhalter-cli "+cliVersion)
+	authentication, encryption, err := client.HPB(cmd.Context())
+	if err != nil {
+		logger.Error("Error retrieving bank keys", "supplier", supplier, "error", err)
+		exitWithLogo(fmt.Sprintf("Error retrieving the keys of the bank: %s", err))
+	}
+
+	fmt.Println()
+	fmt.Println(textStyle("Authentication key (X002): " + ebics.FormatHash(ebics.PublicKeyHash(authentication))))
+	fmt.Println(textStyle("Encryption key (E002):     " + ebics.FormatHash(ebics.PublicKeyHash(encryption))))
+	fmt.Println()
+	if !yes {
+		fmt.Print("Do the hashes match the hashes published by your bank? [y/N] ")
+		input, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer := strings.ToLower(strings.TrimSpace(input)); answer != "y" && answer != "yes" {
+			exitWithLogo("The keys of the bank were not stored")
+		}
+	}
+
+	err = keys.SetBankKeys(authentication, encryption)
+	if err == nil {
+		err = ebics.SaveKeys(keyStore, supplier, keys)
+	}
+	if err != nil {
+		logger.Error("Error storing EBICS keys in keychain", "supplier", supplier, "error", err)
+		exitWithLogo(keychain.GetHumanReadableErrorMessage(err))
+	}
+	fmt.Println(textStyleBold(fmt.Sprintf("EBICS access of %s is ready, statements are downloaded with the next sync.", supplier)))
+}
+
+// ebicsRecipeStep returns the step of the EBICS recipe of supplier with the server URL and host ID.
+func ebicsRecipeStep(logger *slog.Logger, supplier string) parser.Step {
+	recipeParser := parser.NewRecipeParser(logger, viper.GetString("buchhalter_config_directory"), viper.GetString("buchhalter_directory"))
+	_, err := recipeParser.LoadRecipes(viper.GetBool("dev"))
+	if err != nil {
+		logger.Error("Error loading recipes for suppliers", "error", err)
+		exitWithLogo(fmt.Sprintf("Error loading recipes for suppliers: %s", err))
+	}
+	recipe := recipeParser.GetRecipeBySupplier(supplier)
+	if recipe == nil || recipe.Type != "ebics" {
+		exitWithLogo(fmt.Sprintf("No EBICS recipe found for supplier %s", supplier))
+	}
+	for _, step := range recipe.Steps {
+		if step.Action == "ebics-statements" && step.URL != "" && step.Ebics.HostID != "" {
+			return step
+		}
+	}
+	exitWithLogo(fmt.Sprintf("The recipe of %s has no ebics-statements step with url and hostId", supplier))
+	return parser.Step{}
+}
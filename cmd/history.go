@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+
+	"buchhalter/lib/history"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Analyzes the history of sync runs",
+}
+
+var historySlowestCmd = &cobra.Command{
+	Use:   "slowest",
+	Short: "Lists the suppliers and recipe steps dominating the runtime",
+	Long:  "The slowest command lists the suppliers and recipe steps with the highest average duration over the last sync runs.",
+	Run:   RunHistorySlowestCommand,
+}
+
+func init() {
+	historySlowestCmd.Flags().IntP("limit", "n", 10, "number of suppliers and steps to list")
+	historyCmd.AddCommand(historySlowestCmd)
+	rootCmd.AddCommand(historyCmd)
+}
+
+func RunHistorySlowestCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	limit, err := cmd.Flags().GetInt("limit")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading limit flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	runHistory := history.NewRunHistory(logger, buchhalterDirectory)
+	suppliers, err := runHistory.SlowestSuppliers(limit)
+	if err != nil {
+		logger.Error("Error reading run history", "error", err)
+		exitMessage := fmt.Sprintf("Error reading run history: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if len(suppliers) == 0 {
+		fmt.Println(textStyle("No sync runs recorded yet."))
+		return
+	}
+	steps, err := runHistory.SlowestSteps(limit)
+	if err != nil {
+		logger.Error("Error reading run history", "error", err)
+		exitMessage := fmt.Sprintf("Error reading run history: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	fmt.Println(textStyleBold("Slowest suppliers (average / max over runs):"))
+	for _, s := range suppliers {
+		fmt.Printf("  %-30s %7.1fs / %7.1fs  (%d runs)\n", s.Supplier, s.AverageDuration, s.MaxDuration, s.Count)
+	}
+
+	fmt.Println()
+	fmt.Println(textStyleBold("Slowest recipe steps (average / max over runs):"))
+	for _, s := range steps {
+		step := fmt.Sprintf("%s #%d %s", s.Supplier, s.Number, s.Action)
+		fmt.Printf("  %-40s %7.1fs / %7.1fs  %s\n", step, s.AverageDuration, s.MaxDuration, textStyleGrayBold(s.Description))
+	}
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/repository"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Inspects the usage metrics sent to the Buchhalter Platform",
+}
+
+var metricsPreviewCmd = &cobra.Command{
+	Use:   "preview",
+	Short: "Shows the usage metrics of the last run",
+	Long:  "The preview command shows the exact payload the usage metrics of the last sync run are sent (or written, see buchhalter_metrics_file) as, with the fields of buchhalter_metrics_redact left out.",
+	Args:  cobra.NoArgs,
+	Run:   RunMetricsPreviewCommand,
+}
+
+func init() {
+	metricsCmd.AddCommand(metricsPreviewCmd)
+	rootCmd.AddCommand(metricsCmd)
+}
+
+func RunMetricsPreviewCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	runMetrics, ok, err := repository.LoadRunMetrics(filepath.Join(buchhalterDirectory, repository.LAST_RUN_METRICS_FILE_NAME))
+	if err != nil {
+		logger.Error("Error reading usage metrics of the last run", "error", err)
+		exitMessage := fmt.Sprintf("Error reading usage metrics of the last run: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if !ok {
+		fmt.Println(i18n.T("No usage metrics recorded yet, run buchhalter sync first."))
+		return
+	}
+
+	metric, err := runMetricsPayload(runMetrics)
+	if err != nil {
+		exitWithLogo(fmt.Sprintf("Error in setting buchhalter_metrics_redact: %s", err))
+	}
+	payload, _ := json.MarshalIndent(metric, "", "  ")
+	var runData repository.RunData
+	_ = json.Unmarshal([]byte(metric.Data), &runData)
+	decodedData, _ := json.MarshalIndent(runData, "", "  ")
+
+	metricsFile := viper.GetString("buchhalter_metrics_file")
+	switch {
+	case metricsFile != "":
+		fmt.Println(headerStyle(i18n.Tf("Payload written to %s:", metricsFile)))
+	default:
+		metricsURL, _ := repository.MetricsURL(viper.GetString("buchhalter_api_host"))
+		fmt.Println(headerStyle(i18n.Tf("Payload sent to %s:", metricsURL)))
+	}
+	fmt.Println(string(payload))
+	fmt.Println()
+	fmt.Println(headerStyle(i18n.T("Decoded data field:")))
+	fmt.Println(string(decodedData))
+
+	if metricsFile == "" && !viper.GetBool("buchhalter_always_send_metrics") {
+		fmt.Println(helpStyle.Render(i18n.T("Usage metrics are only sent with your consent, sync asks for it after each run (see buchhalter_always_send_metrics).")))
+	}
+}
+
+// currentRunMetrics returns the usage metrics of the current run.
+func currentRunMetrics(vaultVersion, oicdbVersion string) repository.RunMetrics {
+	return repository.RunMetrics{
+		RunData:       RunData,
+		CliVersion:    cliVersion,
+		ChromeVersion: ChromeVersion,
+		VaultVersion:  vaultVersion,
+		OicdbVersion:  oicdbVersion,
+	}
+}
+
+// runMetricsPayload returns the payload of the usage metrics without the fields of `buchhalter_metrics_redact`.
+func runMetricsPayload(runMetrics repository.RunMetrics) (repository.Metric, error) {
+	return runMetrics.Payload(viper.GetStringSlice("buchhalter_metrics_redact"))
+}
+
+func sendRunMetrics(ctx context.Context, buchhalterAPIClient *repository.BuchhalterAPIClient, runMetrics repository.RunMetrics) error {
+	metric, err := runMetricsPayload(runMetrics)
+	if err != nil {
+		return err
+	}
+	return buchhalterAPIClient.SendMetrics(ctx, metric)
+}
+
+// exportRunMetrics appends the usage metrics to the local metrics file instead of sending them.
+func exportRunMetrics(runMetrics repository.RunMetrics, metricsFile string) error {
+	metric, err := runMetricsPayload(runMetrics)
+	if err != nil {
+		return err
+	}
+	return repository.AppendMetric(metricsFile, metric)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Moves all documents into the configured directory layout",
+	Long:  "The migrate command moves all existing documents into the directory layout configured in `buchhalter_documents_layout`.",
+	Run:   RunMigrateCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func RunMigrateCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	documentArchive := initializeDocumentArchive(logger)
+
+	documentsLayout := viper.GetString("buchhalter_documents_layout")
+	fmt.Println(textStyle(fmt.Sprintf("Migrating documents to layout '%s' ...", documentsLayout)))
+	err = documentArchive.BuildArchiveIndex(cmd.Context())
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+		exitMessage := fmt.Sprintf("Error building document archive index: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	moved, err := documentArchive.MigrateLayout()
+	if err != nil {
+		logger.Error("Error migrating documents", "layout", documentsLayout, "moved", moved, "error", err)
+		exitMessage := fmt.Sprintf("Error migrating documents (%d moved): %s", moved, err)
+		exitWithLogo(exitMessage)
+	}
+
+	fmt.Println(textStyle(fmt.Sprintf("Migrating documents to layout '%s' ... %d documents moved", documentsLayout, moved)))
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/utils"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var yearPattern = regexp.MustCompile(`^\d{4}$`)
+
+var openCmd = &cobra.Command{
+	Use:   "open [supplier] [year]",
+	Short: "Opens the documents directory or a document",
+	Long:  "The open command opens the documents directory, the directory of a supplier and/or year (according to buchhalter_documents_layout) in the file manager or, with --document, a document identified by its checksum, path or a part of its file name in the default viewer.",
+	Args:  cobra.MaximumNArgs(2),
+	Run:   RunOpenCommand,
+}
+
+func init() {
+	openCmd.Flags().String("document", "", "open the document with this checksum, path or part of the file name")
+	rootCmd.AddCommand(openCmd)
+}
+
+func RunOpenCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	query, err := cmd.Flags().GetString("document")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading document flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	documentArchive := initializeDocumentArchive(logger)
+
+	target := ""
+	if query != "" {
+		if len(cmdArgs) > 0 {
+			exitWithLogo("Use either --document or a supplier and year, not both")
+		}
+		err = documentArchive.BuildArchiveIndex(cmd.Context())
+		if err != nil {
+			logger.Error("Error building document archive index", "error", err)
+			exitMessage := fmt.Sprintf("Error building document archive index: %s", err)
+			exitWithLogo(exitMessage)
+		}
+		path, ok := findDocument(documentArchive, query)
+		if !ok {
+			return
+		}
+		target = path
+	} else {
+		supplier, year, err := parseOpenArgs(cmdArgs)
+		if err != nil {
+			exitWithLogo(err.Error())
+		}
+		target = documentArchive.LayoutDirectory(supplier, year)
+		if _, err := os.Stat(target); err != nil {
+			fmt.Println(i18n.Tf("Directory %s does not exist.", target))
+			return
+		}
+	}
+
+	logger.Info("Opening", "path", target)
+	err = utils.OpenFile(target)
+	if err != nil {
+		logger.Error("Error opening path", "path", target, "error", err)
+		exitMessage := fmt.Sprintf("Error opening %s: %s", target, err)
+		exitWithLogo(exitMessage)
+	}
+	fmt.Println(i18n.Tf("Opening %s", target))
+}
+
+// parseOpenArgs returns the supplier and year of the arguments of the open command. A year can be given without a supplier.
+func parseOpenArgs(cmdArgs []string) (string, int, error) {
+	supplier := ""
+	year := 0
+	for i, arg := range cmdArgs {
+		if !yearPattern.MatchString(arg) {
+			if i > 0 {
+				return "", 0, fmt.Errorf("invalid year %s", arg)
+			}
+			supplier = arg
+			continue
+		}
+		if year > 0 {
+			return "", 0, fmt.Errorf("invalid supplier %s", cmdArgs[0])
+		}
+		year, _ = strconv.Atoi(arg)
+	}
+	return supplier, year, nil
+}
+
+// findDocument returns the path of the document identified by query. Ambiguous queries list the matching documents.
+func findDocument(documentArchive *archive.DocumentArchive, query string) (string, bool) {
+	checksum, ok := documentArchive.FindFile(query)
+	if ok {
+		f, _ := documentArchive.GetFile(checksum)
+		return f.Path, true
+	}
+
+	checksums := documentArchive.MatchFiles(query)
+	switch len(checksums) {
+	case 0:
+		fmt.Println(i18n.Tf("Document %s not found in archive.", query))
+		return "", false
+	case 1:
+		f, _ := documentArchive.GetFile(checksums[0])
+		return f.Path, true
+	}
+
+	fmt.Println(i18n.Tf("%d documents match %s, please refine the query:", len(checksums), query))
+	for _, checksum := range checksums {
+		f, _ := documentArchive.GetFile(checksum)
+		fmt.Println("  " + f.Path)
+	}
+	return "", false
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"buchhalter/lib/i18n"
+
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/spf13/viper"
+)
+
+// outputTheme contains the colors of the output, see `buchhalter_theme`.
+// Colors are hex codes (e.g. #9FC131).
+type outputTheme struct {
+	Primary   string `mapstructure:"primary"`
+	Secondary string `mapstructure:"secondary"`
+	Muted     string `mapstructure:"muted"`
+	Highlight string `mapstructure:"highlight"`
+	Error     string `mapstructure:"error"`
+}
+
+// outputSymbols contains the symbols of the output, the ASCII symbols are used on terminals without unicode support.
+type outputSymbols struct {
+	success       string
+	separator     string
+	ellipsis      string
+	up            string
+	down          string
+	selected      string
+	progressFull  rune
+	progressEmpty rune
+	spinner       spinner.Spinner
+}
+
+var (
+	defaultTheme = outputTheme{
+		Primary:   "#9FC131",
+		Secondary: "#DBF227",
+		Muted:     "#666666",
+		Highlight: "#D6D58E",
+		Error:     "#EA4335",
+	}
+	unicodeSymbols = outputSymbols{
+		success:       "✓",
+		separator:     "•",
+		ellipsis:      "…",
+		up:            "↑",
+		down:          "↓",
+		selected:      "•",
+		progressFull:  '█',
+		progressEmpty: '░',
+		spinner:       spinner.Dot,
+	}
+	asciiSymbols = outputSymbols{
+		success:       "+",
+		separator:     "|",
+		ellipsis:      "...",
+		up:            "^",
+		down:          "v",
+		selected:      "*",
+		progressFull:  '#',
+		progressEmpty: '-',
+		spinner:       spinner.Line,
+	}
+
+	colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+	theme   = defaultTheme
+	symbols = unicodeSymbols
+)
+
+var (
+	textStyle         func(...string) string
+	textStyleGrayBold func(...string) string
+	textStyleBold     func(...string) string
+	headerStyle       func(...string) string
+	helpStyle         lipgloss.Style
+	dotStyle          lipgloss.Style
+	errorStyle        lipgloss.Style
+	durationStyle     lipgloss.Style
+	spinnerStyle      lipgloss.Style
+	paneBadgeStyle    lipgloss.Style
+)
+
+// applyTheme creates the styles of the output with the colors of t.
+func applyTheme(t outputTheme) {
+	theme = t
+	textStyle = lipgloss.NewStyle().Render
+	textStyleGrayBold = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(t.Muted)).Render
+	textStyleBold = lipgloss.NewStyle().Bold(true).Render
+	headerStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(t.Primary)).Render
+	helpStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(t.Highlight)).Margin(1, 0)
+	dotStyle = helpStyle.UnsetMargins()
+	errorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(t.Error))
+	durationStyle = dotStyle
+	spinnerStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(t.Highlight))
+	paneBadgeStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFFFF")).Background(lipgloss.Color(t.Error)).Padding(0, 1)
+}
+
+// initializeOutput applies the output settings of the config.
+// Colors are disabled with `--no-color` or the NO_COLOR environment variable (https://no-color.org), ASCII symbols are
+// used with `--ascii`. Dumb terminals (TERM=dumb) get neither colors nor unicode symbols.
+func initializeOutput() error {
+	dumbTerminal := os.Getenv("TERM") == "dumb"
+	if viper.GetBool("buchhalter_ascii") || dumbTerminal {
+		symbols = asciiSymbols
+	}
+	if viper.GetBool("buchhalter_no_color") || os.Getenv("NO_COLOR") != "" || dumbTerminal {
+		// The ASCII profile renders neither colors nor text attributes like bold
+		lipgloss.SetColorProfile(termenv.Ascii)
+	}
+
+	t := defaultTheme
+	err := viper.UnmarshalKey("buchhalter_theme", &t)
+	if err != nil {
+		return fmt.Errorf("error in setting buchhalter_theme: %w", err)
+	}
+	for name, color := range map[string]string{"primary": t.Primary, "secondary": t.Secondary, "muted": t.Muted, "highlight": t.Highlight, "error": t.Error} {
+		if !colorPattern.MatchString(color) {
+			return fmt.Errorf("error in setting buchhalter_theme: invalid color %q of %s, use a hex code like #9FC131", color, name)
+		}
+	}
+	applyTheme(t)
+	rootCmd.Long = rootDescription()
+
+	return nil
+}
+
+// initializeLanguage sets the language of the output to `buchhalter_language` or, if empty, to the language of the
+// locale (e.g. LANG=de_DE.UTF-8).
+func initializeLanguage() error {
+	language := viper.GetString("buchhalter_language")
+	if language == "" {
+		language = i18n.DetectLanguage(os.Getenv)
+	}
+	err := i18n.SetLanguage(language)
+	if err != nil {
+		return fmt.Errorf("error in setting buchhalter_language: %w", err)
+	}
+	return nil
+}
+
+// newProgressBar returns the progress bar of the sync view in the colors and symbols of the output settings.
+func newProgressBar() progress.Model {
+	return progress.New(
+		progress.WithGradient(theme.Primary, theme.Secondary),
+		progress.WithFillCharacters(symbols.progressFull, symbols.progressEmpty),
+		progress.WithColorProfile(lipgloss.ColorProfile()),
+	)
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+
+	"buchhalter/lib/i18n"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// supplierPickerModel is the bubbletea application model to select the suppliers of a sync run.
+type supplierPickerModel struct {
+	suppliers []string
+	selected  map[string]bool
+	cursor    int
+	confirmed bool
+	quitting  bool
+}
+
+// initialSupplierPickerModel preselects the suppliers of the last selection.
+// Without a previous selection, all suppliers are selected.
+func initialSupplierPickerModel(suppliers, previousSelection []string) supplierPickerModel {
+	selected := make(map[string]bool)
+	for _, supplier := range previousSelection {
+		selected[supplier] = true
+	}
+	if len(previousSelection) == 0 {
+		for _, supplier := range suppliers {
+			selected[supplier] = true
+		}
+	}
+
+	return supplierPickerModel{
+		suppliers: suppliers,
+		selected:  selected,
+	}
+}
+
+// Init initializes the bubbletea application.
+func (m supplierPickerModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update updates the bubbletea application model.
+func (m supplierPickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	switch keyMsg.String() {
+	case "q", "esc", "ctrl+c":
+		m.quitting = true
+		return m, tea.Quit
+
+	case "enter":
+		if len(m.Selection()) == 0 {
+			return m, nil
+		}
+		m.confirmed = true
+		m.quitting = true
+		return m, tea.Quit
+
+	case " ", "x":
+		supplier := m.suppliers[m.cursor]
+		m.selected[supplier] = !m.selected[supplier]
+
+	case "a":
+		// Select all, or none if all are selected already
+		selectAll := len(m.Selection()) < len(m.suppliers)
+		for _, supplier := range m.suppliers {
+			m.selected[supplier] = selectAll
+		}
+
+	case "down", "j":
+		m.cursor++
+		if m.cursor >= len(m.suppliers) {
+			m.cursor = 0
+		}
+
+	case "up", "k":
+		m.cursor--
+		if m.cursor < 0 {
+			m.cursor = len(m.suppliers) - 1
+		}
+	}
+
+	return m, nil
+}
+
+// View renders the bubbletea application view.
+func (m supplierPickerModel) View() string {
+	s := fmt.Sprintf(
+		"%s\n%s\n",
+		headerStyle(LogoText),
+		textStyleGrayBold(fmt.Sprintf("Using CLI %s", cliVersion)),
+	) + "\n"
+
+	if m.quitting {
+		return appStyle.Render(s)
+	}
+
+	s += fmt.Sprintf("Select the suppliers to sync (%d of %d selected):\n\n", len(m.Selection()), len(m.suppliers))
+	for i, supplier := range m.suppliers {
+		if m.cursor == i {
+			s += "> "
+		} else {
+			s += "  "
+		}
+		if m.selected[supplier] {
+			s += "[x] "
+		} else {
+			s += "[ ] "
+		}
+		s += supplier + "\n"
+	}
+	s += helpStyle.Render(i18n.Tf("space: toggle %[1]s a: toggle all %[1]s enter: sync selected %[1]s q: exit", symbols.separator))
+
+	return appStyle.Render(s)
+}
+
+// Selection returns the selected suppliers in the order they are listed.
+func (m supplierPickerModel) Selection() []string {
+	var selection []string
+	for _, supplier := range m.suppliers {
+		if m.selected[supplier] {
+			selection = append(selection, supplier)
+		}
+	}
+	return selection
+}
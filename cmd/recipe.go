@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"buchhalter/lib/parser"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var recipeCmd = &cobra.Command{
+	Use:   "recipe",
+	Short: "Inspects the recipes of suppliers",
+}
+
+var recipeExplainCmd = &cobra.Command{
+	Use:   "explain <supplier>",
+	Short: "Describes what the recipe of a supplier does",
+	Long:  "The explain command renders a human-readable description of each step of a supplier recipe (e.g. navigate to a page, enter credentials, download documents). Use it to audit what automation runs against your account before granting credentials.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunRecipeExplainCommand,
+}
+
+func init() {
+	recipeCmd.AddCommand(recipeExplainCmd)
+	rootCmd.AddCommand(recipeCmd)
+}
+
+func RunRecipeExplainCommand(cmd *cobra.Command, cmdArgs []string) {
+	supplier := cmdArgs[0]
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
+	recipeParser := parser.NewRecipeParser(logger, buchhalterConfigDirectory, buchhalterDirectory)
+	_, err = recipeParser.LoadRecipes(developmentMode)
+	if err != nil {
+		logger.Error("Error loading recipes for suppliers", "error", err)
+		exitMessage := fmt.Sprintf("Error loading recipes for suppliers: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	recipe := recipeParser.GetRecipeBySupplier(supplier)
+	if recipe == nil {
+		exitWithLogo(fmt.Sprintf("No recipe found for supplier %s", supplier))
+	}
+
+	if scope, ok := viper.GetStringMapString("buchhalter_oauth2_scopes")[recipe.Supplier]; ok {
+		recipe.OverrideOauth2Scope(scope)
+	}
+
+	fmt.Println(headerStyle(fmt.Sprintf("Recipe of %s (version %s, %s recipe)", recipe.Supplier, recipe.Version, recipe.Type)))
+	fmt.Println(textStyle(fmt.Sprintf("Runs against: %s", strings.Join(recipe.Domains, ", "))))
+	if len(recipe.Permissions) > 0 {
+		fmt.Println(textStyle(fmt.Sprintf("Requested permissions: %s", strings.Join(recipe.Permissions, ", "))))
+	}
+	if len(recipe.DependsOn) > 0 {
+		fmt.Println(textStyle(fmt.Sprintf("Runs after: %s", strings.Join(recipe.DependsOn, ", "))))
+	}
+	if recipe.Frequency != "" {
+		fmt.Println(textStyle(fmt.Sprintf("Runs once per period: %s", recipe.Frequency)))
+	}
+	for _, warning := range recipe.ScopeWarnings(minimalScopeCatalogue()) {
+		fmt.Println(textStyle(fmt.Sprintf("Warning: the recipe %s", warning)))
+	}
+	fmt.Println()
+	for i, explanation := range recipe.Explain() {
+		fmt.Println(textStyle(fmt.Sprintf("%2d. %s", i+1, explanation)))
+	}
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"buchhalter/lib/audit"
+	"buchhalter/lib/browser"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/vault"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var refetchCmd = &cobra.Command{
+	Use:   "refetch <supplier>",
+	Short: "Downloads single documents of a supplier again",
+	Long:  "The refetch command downloads documents of a supplier again, e.g. to replace corrupted or deleted files, without a full recipe run. Documents are identified with `--invoice` by their id at the supplier, the date of their download (e.g. 2024-03) or a part of their file name. The sources of documents are stored with their download, refetching is supported for client recipes (supplier APIs).",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunRefetchCommand,
+}
+
+func init() {
+	refetchCmd.Flags().String("invoice", "", "id, download date (e.g. 2024-03) or part of the file name of the documents to download again")
+	rootCmd.AddCommand(refetchCmd)
+}
+
+func RunRefetchCommand(cmd *cobra.Command, cmdArgs []string) {
+	supplier := cmdArgs[0]
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	invoice, err := cmd.Flags().GetString("invoice")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading invoice flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if invoice == "" {
+		exitWithLogo(i18n.T("Please select the documents to download again with --invoice."))
+	}
+	if team, ok := initializeTeam(cmd.Context(), logger); ok && !team.Restrictions.AllowsSupplier(supplier) {
+		logger.Warn("Role of user in team doesn't permit syncing supplier", "supplier", supplier, "team", team.Slug, "role", team.Role)
+		exitWithLogo(i18n.Tf("Your role %s in team %s doesn't permit syncing %s.", team.Role, team.Name, supplier))
+	}
+
+	archives := initializeDocumentArchives(logger)
+	defer archives.Close()
+	documentArchive := archives.ForSupplier(supplier)
+	sources, err := documentArchive.FindSources(supplier, invoice)
+	if err != nil {
+		logger.Error("Error reading document sources", "error", err)
+		exitMessage := fmt.Sprintf("Error reading document sources: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if len(sources) == 0 {
+		exitWithLogo(i18n.Tf("No downloaded documents of %s match %s.", supplier, invoice))
+	}
+
+	// Init vault provider
+	vaultConfigBinary := viper.GetString("credential_provider_cli_command")
+	vaultConfigBase := viper.GetString("credential_provider_vault")
+	vaultConfigTag := viper.GetString("credential_provider_item_tag")
+	vaultProvider, err := vault.GetProvider(vault.PROVIDER_1PASSWORD, vaultConfigBinary, vaultConfigBase, vaultConfigTag)
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+	_, err = vaultProvider.LoadVaultItems()
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+
+	recipeParser := parser.NewRecipeParser(logger, viper.GetString("buchhalter_config_directory"), buchhalterDirectory)
+	recipes, err := prepareRecipes(logger, supplier, vaultProvider, recipeParser)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error loading recipes for suppliers: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if len(recipes) == 0 {
+		exitWithLogo(i18n.Tf("No recipe with credentials found for supplier %s.", supplier))
+	}
+	recipe := recipes[0].recipe
+	if recipe.Type != "client" {
+		exitWithLogo(i18n.Tf("Refetching is only supported for client recipes, %s is a %s recipe. Run buchhalter sync %s --force instead.", supplier, recipe.Type, supplier))
+	}
+	credentials, err := vaultProvider.GetCredentialsByItemId(cmd.Context(), recipes[0].vaultItemId)
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+	auditLog := initializeAuditLog(logger, "refetch")
+	defer auditLog.Close()
+	auditLog.Record(audit.Event{Kind: audit.KIND_VAULT_ITEM, Source: recipes[0].vaultItemId, Supplier: supplier})
+	oauth2Variables := map[string]map[string]string{}
+	err = viper.UnmarshalKey("buchhalter_oauth2_variables", &oauth2Variables)
+	if err != nil {
+		logger.Error("Error in setting buchhalter_oauth2_variables", "error", err)
+	}
+	initializeTokenDirectory(logger)
+
+	p := tea.NewProgram(replayModel{}, tea.WithoutRenderer(), tea.WithInput(nil), tea.WithOutput(io.Discard))
+	go func() {
+		_, _ = p.Run()
+	}()
+
+	fmt.Println(textStyle(i18n.Tf("Downloading %d documents of %s again ...", len(sources), supplier)))
+	logger.Info("Refetching documents ...", "supplier", supplier, "invoice", invoice, "documents", len(sources))
+	clientDriver := browser.NewClientAuthBrowserDriver(cmd.Context(), logger, initializeHTTPClient(logger), nil, credentials, viper.GetString("buchhalter_config_directory"), viper.GetString("buchhalter_documents_directory"), documentArchive)
+	clientDriver.ChromePath = viper.GetString("buchhalter_chrome_path")
+	clientDriver.ShredTemporaryFiles = viper.GetBool("buchhalter_shred_temporary_files")
+	clientDriver.Oauth2Variables = oauth2Variables[supplier]
+	clientDriver.TokenProfile = viper.GetString("buchhalter_profile")
+	clientDriver.RefetchSources = sources
+	clientDriver.AuditLog = auditLog
+	recipeResult := clientDriver.RunRecipe(p, len(recipe.Steps), len(recipe.Steps), 0, recipe)
+	err = clientDriver.Quit()
+	if err != nil {
+		logger.Error("Error quitting browser", "error", err)
+	}
+	p.Send(viewMsgQuit{})
+	p.Wait()
+
+	// The index entries of the replaced files are outdated
+	err = documentArchive.BuildArchiveIndex(cmd.Context())
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+	}
+
+	logger.Info("Refetching documents ... completed", "supplier", supplier, "status", recipeResult.Status, "new_files", recipeResult.NewFilesCount, "error", recipeResult.LastErrorMessage)
+	if recipeResult.Status != "success" && recipeResult.Status != "warning" {
+		exitWithLogo(i18n.Tf("Refetching documents of %s failed: %s", supplier, recipeResult.LastErrorMessage))
+	}
+	for _, source := range sources {
+		fmt.Println(textStyle(i18n.Tf("Replaced %s", source.Path)))
+	}
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/browser"
+	"buchhalter/lib/fixture"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/utils"
+	"buchhalter/lib/vault"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <fixture-file>",
+	Short: "Replays a supplier recipe against a recorded fixture",
+	Long:  "The replay command runs the recipe of a supplier against a fixture recorded with `buchhalter sync <supplier> --record-fixture <fixture-file>` instead of the real supplier portal. Use it to detect recipe regressions (e.g. in CI).",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunReplayCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+}
+
+// replayModel is a bubbletea model without user interface.
+// Recipes report their progress to a bubbletea program, which is not needed for replays.
+type replayModel struct{}
+
+func (m replayModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m replayModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if _, ok := msg.(viewMsgQuit); ok {
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m replayModel) View() string {
+	return ""
+}
+
+func RunReplayCommand(cmd *cobra.Command, cmdArgs []string) {
+	fixtureFile := cmdArgs[0]
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	f, err := fixture.Load(fixtureFile)
+	if err != nil {
+		logger.Error("Error loading fixture", "fixture_file", fixtureFile, "error", err)
+		exitMessage := fmt.Sprintf("Error loading fixture %s: %s", fixtureFile, err)
+		exitWithLogo(exitMessage)
+	}
+
+	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
+	recipeParser := parser.NewRecipeParser(logger, buchhalterConfigDirectory, buchhalterDirectory)
+	_, err = recipeParser.LoadRecipes(developmentMode)
+	if err != nil {
+		logger.Error("Error loading recipes for suppliers", "error", err)
+		exitMessage := fmt.Sprintf("Error loading recipes for suppliers: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	recipe := recipeParser.GetRecipeBySupplier(f.Supplier)
+	if recipe == nil {
+		exitWithLogo(fmt.Sprintf("No recipe found for supplier %s", f.Supplier))
+	}
+	if recipe.Type != "browser" {
+		exitWithLogo(fmt.Sprintf("Replays are only supported for browser recipes, %s is a %s recipe", f.Supplier, recipe.Type))
+	}
+	if recipe.Version != f.RecipeVersion {
+		logger.Warn("Fixture was recorded with another recipe version", "supplier", f.Supplier, "recipe_version", recipe.Version, "fixture_recipe_version", f.RecipeVersion)
+	}
+
+	// Replayed documents must not end up in the real document archive
+	replayDirectory, err := os.MkdirTemp("", "buchhalter-replay-")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error creating replay directory: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	documentArchive := archive.NewDocumentArchive(logger, replayDirectory, archive.LAYOUT_SUPPLIER, "", nil)
+
+	// The fixture contains the responses of the logged in portal, any credentials work
+	credentials := &vault.Credentials{Id: "fixture", Username: "buchhalter", Password: "buchhalter", Totp: "000000"}
+
+	p := tea.NewProgram(replayModel{}, tea.WithoutRenderer(), tea.WithInput(nil), tea.WithOutput(io.Discard))
+	go func() {
+		_, _ = p.Run()
+	}()
+
+	fmt.Println(textStyle(fmt.Sprintf("Replaying recipe of %s (version %s) against %s ...", recipe.Supplier, recipe.Version, fixtureFile)))
+	logger.Info("Replaying recipe ...", "supplier", recipe.Supplier, "recipe_version", recipe.Version, "fixture_file", fixtureFile)
+	browserDriver := browser.NewBrowserDriver(cmd.Context(), logger, initializeHTTPClient(logger), credentials, replayDirectory, "", documentArchive, viper.GetInt("buchhalter_max_download_files_per_receipt"))
+	browserDriver.ChromePath = viper.GetString("buchhalter_chrome_path")
+	browserDriver.FixtureReplay = f
+	recipeResult := browserDriver.RunRecipe(p, len(recipe.Steps), len(recipe.Steps), 0, recipe)
+	err = browserDriver.Quit()
+	if err != nil {
+		logger.Error("Error quitting browser", "error", err)
+	}
+	p.Send(viewMsgQuit{})
+	p.Wait()
+	// Removed before exiting, exitWithLogo skips deferred functions
+	err = utils.RemoveTemporaryDirectory(replayDirectory, false)
+	if err != nil {
+		logger.Error("Error removing replay directory", "directory", replayDirectory, "error", err)
+	}
+	logger.Info("Replaying recipe ... completed", "supplier", recipe.Supplier, "status", recipeResult.Status, "last_step", recipeResult.LastStepId, "error", recipeResult.LastErrorMessage)
+
+	if recipeResult.Status != "success" && recipeResult.Status != "warning" {
+		exitMessage := fmt.Sprintf("Replay failed at %s (%s): %s", recipeResult.LastStepId, recipeResult.LastStepDescription, recipeResult.LastErrorMessage)
+		exitWithLogo(exitMessage)
+	}
+	fmt.Println(textStyle(fmt.Sprintf("Replay succeeded: %s", recipeResult.StatusText)))
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/utils"
+)
+
+var reviewCmd = &cobra.Command{
+	Use:   "review",
+	Short: "Review all documents downloaded since the last review",
+	Long:  "The review command lists all newly downloaded documents. Each document can be opened, renamed, accepted or rejected. Rejected documents are moved into the trash (see `buchhalter archive restore`).",
+	Run:   RunReviewCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(reviewCmd)
+}
+
+func RunReviewCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	documentArchive := initializeDocumentArchive(logger)
+	err = documentArchive.BuildArchiveIndex(cmd.Context())
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+		exitMessage := fmt.Sprintf("Error building document archive index: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	checksums := documentArchive.UnreviewedFiles()
+	if len(checksums) == 0 {
+		fmt.Println(textStyle("No new documents to review."))
+		return
+	}
+
+	p := newProgram(initialReviewModel(logger, documentArchive, checksums))
+	if _, err := p.Run(); err != nil {
+		logger.Error("Error running program", "error", err)
+		exitMessage := fmt.Sprintf("Error running program: %s", err)
+		exitWithLogo(exitMessage)
+	}
+}
+
+/**
+ * Bubbletea UI
+ */
+
+// reviewViewModel is the bubbletea application model for the review command
+type reviewViewModel struct {
+	checksums []string
+	cursor    int
+	renaming  bool
+	tagging   bool
+	input     textinput.Model
+	status    string
+	hasError  bool
+	quitting  bool
+
+	documentArchive *archive.DocumentArchive
+	logger          *slog.Logger
+}
+
+func initialReviewModel(logger *slog.Logger, documentArchive *archive.DocumentArchive, checksums []string) reviewViewModel {
+	input := textinput.New()
+	input.CharLimit = 255
+
+	return reviewViewModel{
+		checksums: checksums,
+		input:     input,
+		status:    fmt.Sprintf("%d new documents to review", len(checksums)),
+
+		documentArchive: documentArchive,
+		logger:          logger,
+	}
+}
+
+// Init initializes the bubbletea application.
+func (m reviewViewModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update updates the bubbletea application model.
+func (m reviewViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	if m.renaming || m.tagging {
+		switch keyMsg.String() {
+		case "esc":
+			m.renaming = false
+			m.tagging = false
+			m.input.Blur()
+			return m, nil
+
+		case "enter":
+			if m.renaming {
+				m.setResult(m.documentArchive.RenameFile(m.checksums[m.cursor], m.input.Value()), "Renamed to "+m.input.Value())
+			} else {
+				tags := strings.Fields(strings.ReplaceAll(m.input.Value(), ",", " "))
+				m.setResult(m.documentArchive.AddTags(m.checksums[m.cursor], tags...), "Tagged with "+strings.Join(tags, ", "))
+			}
+			m.renaming = false
+			m.tagging = false
+			m.input.Blur()
+			return m, nil
+		}
+
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		return m, cmd
+	}
+
+	switch keyMsg.String() {
+	case "q", "esc", "ctrl+c":
+		m.logger.Info("Initiating shutdown sequence", "key_hit", keyMsg.String())
+		m.quitting = true
+		return m, tea.Quit
+
+	case "down", "j":
+		m.cursor++
+		if m.cursor >= len(m.checksums) {
+			m.cursor = 0
+		}
+
+	case "up", "k":
+		m.cursor--
+		if m.cursor < 0 {
+			m.cursor = len(m.checksums) - 1
+		}
+
+	case "o":
+		f, _ := m.documentArchive.GetFile(m.checksums[m.cursor])
+		m.setResult(utils.OpenFile(f.Path), "Opened "+filepath.Base(f.Path))
+
+	case "r":
+		f, _ := m.documentArchive.GetFile(m.checksums[m.cursor])
+		m.renaming = true
+		m.input.Prompt = "New filename: "
+		m.input.SetValue(filepath.Base(f.Path))
+		m.input.Focus()
+		return m, textinput.Blink
+
+	case "t":
+		m.tagging = true
+		m.input.Prompt = "Tags (comma separated): "
+		m.input.SetValue("")
+		m.input.Focus()
+		return m, textinput.Blink
+
+	case "a", "enter":
+		f, _ := m.documentArchive.GetFile(m.checksums[m.cursor])
+		err := m.documentArchive.AcceptFile(m.checksums[m.cursor])
+		m.setResult(err, "Accepted "+filepath.Base(f.Path))
+		if err == nil {
+			return m.removeCurrent()
+		}
+
+	case "x":
+		f, _ := m.documentArchive.GetFile(m.checksums[m.cursor])
+		err := m.documentArchive.RejectFile(m.checksums[m.cursor])
+		m.setResult(err, "Rejected "+filepath.Base(f.Path))
+		if err == nil {
+			return m.removeCurrent()
+		}
+	}
+
+	return m, nil
+}
+
+// View renders the bubbletea application view.
+func (m reviewViewModel) View() string {
+	s := fmt.Sprintf(
+		"%s\n%s\n",
+		headerStyle(LogoText),
+		textStyleGrayBold(fmt.Sprintf("Using CLI %s", cliVersion)),
+	) + "\n"
+
+	if m.hasError {
+		s += errorStyle.Render("ERROR: "+m.status) + "\n\n"
+	} else {
+		s += m.status + "\n\n"
+	}
+
+	if m.quitting {
+		return appStyle.Render(s)
+	}
+
+	for i, checksum := range m.checksums {
+		f, _ := m.documentArchive.GetFile(checksum)
+		if m.cursor == i {
+			s += "> "
+		} else {
+			s += "  "
+		}
+		s += fmt.Sprintf("%s %s", textStyleBold(f.Supplier), filepath.Base(f.Path))
+		if len(f.Tags) > 0 {
+			s += textStyleGrayBold(" [" + strings.Join(f.Tags, ", ") + "]")
+		}
+		s += "\n"
+	}
+
+	if m.renaming || m.tagging {
+		s += "\n" + m.input.View() + "\n"
+		s += helpStyle.Render(i18n.Tf("enter: save %s esc: cancel", symbols.separator))
+	} else {
+		s += helpStyle.Render(i18n.Tf("o: open %[1]s r: rename %[1]s t: tag %[1]s a/enter: accept %[1]s x: reject %[1]s q: exit", symbols.separator))
+	}
+
+	return appStyle.Render(s)
+}
+
+func (m *reviewViewModel) setResult(err error, successMessage string) {
+	if err != nil {
+		m.logger.Error("Error reviewing document", "error", err)
+		m.hasError = true
+		m.status = err.Error()
+		return
+	}
+
+	m.hasError = false
+	m.status = successMessage
+}
+
+func (m reviewViewModel) removeCurrent() (tea.Model, tea.Cmd) {
+	m.checksums = append(m.checksums[:m.cursor], m.checksums[m.cursor+1:]...)
+	if len(m.checksums) == 0 {
+		m.status = "All documents reviewed. Thanks for using buchhalter.ai!"
+		m.quitting = true
+		return m, tea.Quit
+	}
+	if m.cursor >= len(m.checksums) {
+		m.cursor = len(m.checksums) - 1
+	}
+
+	return m, nil
+}
@@ -1,17 +1,29 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/charmbracelet/lipgloss"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"buchhalter/lib/archive"
+	"buchhalter/lib/audit"
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/keychain"
+	"buchhalter/lib/redact"
 	"buchhalter/lib/repository"
+	"buchhalter/lib/secrets"
 	"buchhalter/lib/utils"
 )
 
@@ -41,26 +53,21 @@ var (
 	cliBuildTime = "unknown"
 )
 
-var (
-	longDescription = fmt.Sprintf(
+// rootDescription returns the long description of the root command in the styles of the output settings.
+func rootDescription() string {
+	return fmt.Sprintf(
 		"%s\n%s\n%s%s\n",
 		headerStyle(LogoText),
-		textStyle("Automatically sync all your incoming invoices from your suppliers. "),
-		textStyle("More information at: "),
+		textStyle(i18n.T("Automatically sync all your incoming invoices from your suppliers. ")),
+		textStyle(i18n.T("More information at: ")),
 		textStyleBold("https://buchhalter.ai"),
 	)
-)
-
-var textStyle = lipgloss.NewStyle().Render
-var textStyleGrayBold = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#666666")).Render
-var textStyleBold = lipgloss.NewStyle().Bold(true).Render
-var headerStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#9FC131")).Render
+}
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "buchhalter",
 	Short: "Automatically sync invoices from all your suppliers",
-	Long:  longDescription,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -69,7 +76,19 @@ func Execute(version, commitHash, buildTime string) {
 	cliCommitHash = commitHash
 	cliBuildTime = buildTime
 
-	err := rootCmd.Execute()
+	// Panic messages may contain secrets (e.g. in failed requests), the crash report is sanitized
+	defer recoverCrash()
+
+	// The root context of all commands is cancelled on SIGINT and SIGTERM, e.g. to stop Chrome and running requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// A second signal terminates immediately
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -77,6 +96,10 @@ func Execute(version, commitHash, buildTime string) {
 }
 
 func init() {
+	// The default styles are used until the config is read, e.g. in the help output
+	applyTheme(defaultTheme)
+	rootCmd.Long = rootDescription()
+
 	cobra.OnInitialize(initConfig)
 
 	// Disable the `completion` command
@@ -95,6 +118,34 @@ func init() {
 		fmt.Printf("Failed to bind 'log' flag: %v\n", err)
 		os.Exit(1)
 	}
+
+	rootCmd.PersistentFlags().String("archive", "", "named archive (see buchhalter_archives) to store new documents in (sync) or to work on (review, show, open, tag, migrate, archive)")
+	err = viper.BindPFlag("buchhalter_archive", rootCmd.PersistentFlags().Lookup("archive"))
+	if err != nil {
+		fmt.Printf("Failed to bind 'archive' flag: %v\n", err)
+		os.Exit(1)
+	}
+
+	rootCmd.PersistentFlags().Bool("read-only-archive", false, "write new documents only to the staging directory, see buchhalter archive commit")
+	err = viper.BindPFlag("buchhalter_read_only_archive", rootCmd.PersistentFlags().Lookup("read-only-archive"))
+	if err != nil {
+		fmt.Printf("Failed to bind 'read-only-archive' flag: %v\n", err)
+		os.Exit(1)
+	}
+
+	rootCmd.PersistentFlags().Bool("no-color", false, "disable colors and text styles of the output (also with the NO_COLOR environment variable)")
+	err = viper.BindPFlag("buchhalter_no_color", rootCmd.PersistentFlags().Lookup("no-color"))
+	if err != nil {
+		fmt.Printf("Failed to bind 'no-color' flag: %v\n", err)
+		os.Exit(1)
+	}
+
+	rootCmd.PersistentFlags().Bool("ascii", false, "use ASCII symbols only, e.g. for terminals without unicode support")
+	err = viper.BindPFlag("buchhalter_ascii", rootCmd.PersistentFlags().Lookup("ascii"))
+	if err != nil {
+		fmt.Printf("Failed to bind 'ascii' flag: %v\n", err)
+		os.Exit(1)
+	}
 }
 
 func initConfig() {
@@ -108,11 +159,74 @@ func initConfig() {
 	viper.SetDefault("credential_provider_cli_command", "")
 	viper.SetDefault("credential_provider_vault", "Base")
 	viper.SetDefault("credential_provider_item_tag", "buchhalter-ai")
+	viper.SetDefault("credential_provider_write_back", false)
 	viper.SetDefault("buchhalter_directory", buchhalterDir)
 	viper.SetDefault("buchhalter_config_directory", buchhalterConfigDir)
 	viper.SetDefault("buchhalter_max_download_files_per_receipt", 2)
+	viper.SetDefault("buchhalter_recipe_timeout", 600)
+	viper.SetDefault("buchhalter_documents_layout", "supplier")
+	viper.SetDefault("buchhalter_staging_directory", "")
+	viper.SetDefault("buchhalter_archives", map[string]archiveConfig{})
+	viper.SetDefault("buchhalter_groups", map[string]groupConfig{})
+	viper.SetDefault("buchhalter_read_only_archive", false)
+	viper.SetDefault("buchhalter_remote_archive", "")
+	viper.SetDefault("buchhalter_remote_archive_ssh_config", "")
+	viper.SetDefault("archive.git.enabled", false)
+	viper.SetDefault("archive.git.lfs", true)
+	viper.SetDefault("buchhalter_pdfa_conversion", false)
+	viper.SetDefault("buchhalter_pdfa_level", 2)
+	viper.SetDefault("buchhalter_ghostscript_path", "gs")
+	viper.SetDefault("buchhalter_tsa_url", "")
+	viper.SetDefault("buchhalter_paperless_host", "")
+	viper.SetDefault("buchhalter_paperless_token", "")
+	viper.SetDefault("buchhalter_document_sink_url", "")
+	viper.SetDefault("buchhalter_document_sink_secret", "")
+	viper.SetDefault("buchhalter_webhook_url", "")
+	viper.SetDefault("buchhalter_webhook_secret", "")
+	viper.SetDefault("buchhalter_webhook_events", []string{})
+	viper.SetDefault("buchhalter_fints_product_id", "")
+	viper.SetDefault("buchhalter_fints_tan_medium", "")
+	viper.SetDefault("buchhalter_supplier_tags", map[string][]string{})
+	viper.SetDefault("buchhalter_supplier_cadence", map[string]string{})
+	viper.SetDefault("buchhalter_supplier_frequency", map[string]string{})
+	viper.SetDefault("buchhalter_supplier_currency", map[string]string{})
+	viper.SetDefault("buchhalter_supplier_vat_rate", map[string]string{})
 	viper.SetDefault("buchhalter_api_host", "https://app.buchhalter.ai/")
+	viper.SetDefault("buchhalter_oicdb_mirrors", []string{})
+	viper.SetDefault("buchhalter_oicdb_auto_update", true)
+	viper.SetDefault("buchhalter_oicdb_public_keys", []string{})
 	viper.SetDefault("buchhalter_always_send_metrics", false)
+	viper.SetDefault("buchhalter_metrics_redact", []string{})
+	viper.SetDefault("buchhalter_metrics_file", "")
+	viper.SetDefault("buchhalter_audit_report", false)
+	viper.SetDefault("buchhalter_supplier_advisories", true)
+	viper.SetDefault("buchhalter_upload_bandwidth_limit", 0)
+	viper.SetDefault("buchhalter_http_timeout", 10)
+	viper.SetDefault("buchhalter_http_max_retries", 3)
+	viper.SetDefault("buchhalter_http_cache", false)
+	viper.SetDefault("buchhalter_http_cache_ttl", 3600)
+	viper.SetDefault("buchhalter_debug_artifacts", false)
+	viper.SetDefault("buchhalter_serve_address", "127.0.0.1:8741")
+	viper.SetDefault("buchhalter_selected_suppliers", []string{})
+	viper.SetDefault("buchhalter_chrome_path", "")
+	viper.SetDefault("buchhalter_chrome_memory_limit", 0)
+	viper.SetDefault("buchhalter_chrome_restart_after_steps", 0)
+	viper.SetDefault("buchhalter_chrome_restart_after_minutes", 0)
+	viper.SetDefault("buchhalter_remote_debugging_url", "")
+	viper.SetDefault("buchhalter_block_trackers", false)
+	viper.SetDefault("buchhalter_shred_temporary_files", false)
+	viper.SetDefault("buchhalter_blocklists", []string{"https://easylist.to/easylist/easylist.txt", "https://easylist.to/easylist/easyprivacy.txt"})
+	viper.SetDefault("buchhalter_minimal_scopes", map[string][]string{})
+	viper.SetDefault("buchhalter_oauth2_scopes", map[string]string{})
+	viper.SetDefault("buchhalter_oauth2_variables", map[string]map[string]string{})
+	viper.SetDefault("buchhalter_profile", "default")
+	viper.SetDefault("buchhalter_serve_token", "")
+	viper.SetDefault("buchhalter_serve_sync_interval", "")
+	viper.SetDefault("buchhalter_serve_monthly_digest", false)
+	viper.SetDefault("buchhalter_no_color", false)
+	viper.SetDefault("buchhalter_ascii", false)
+	viper.SetDefault("buchhalter_theme", map[string]string{})
+	viper.SetDefault("buchhalter_language", "")
 	viper.SetDefault("dev", false)
 
 	// Non documented settings (on purpose)
@@ -148,6 +262,18 @@ func initConfig() {
 		fmt.Println("Error reading config file:", err)
 		os.Exit(1)
 	}
+	resolveKeychainSecrets()
+
+	err = initializeLanguage()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	err = initializeOutput()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	// Read local API settings
 	dummyLogger := slog.New(slog.NewTextHandler(os.Stderr, nil))
@@ -157,7 +283,15 @@ func initConfig() {
 		fmt.Println("Error reading api token file:", err)
 		os.Exit(1)
 	}
+	// The API token is stored in the keychain with connect --secret
+	if secretKey, ok := keychain.ParseReference(apiConfig.APIKey); ok {
+		apiConfig.APIKey, err = keychain.New().Get(secretKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading the API token from keychain: %s\n", keychain.GetHumanReadableErrorMessage(err))
+		}
+	}
 	viper.Set("buchhalter_api_token", apiConfig.APIKey)
+	redact.AddSecrets(apiConfig.APIKey)
 	teamSlug := "default"
 	if len(apiConfig.TeamSlug) > 0 {
 		teamSlug = apiConfig.TeamSlug
@@ -202,22 +336,240 @@ func initializeLogger(logSetting, developmentMode bool, buchhalterDir string) (*
 			return nil, fmt.Errorf("can't open %s for logging: %+v", fileName, err)
 		}
 		// defer outputWriter.Close()
-		logger = slog.New(slog.NewTextHandler(outputWriter, handlerOptions))
+		logger = slog.New(redact.NewHandler(slog.NewTextHandler(outputWriter, handlerOptions)))
 	} else {
-		logger = slog.New(slog.NewTextHandler(io.Discard, handlerOptions))
+		logger = slog.New(redact.NewHandler(slog.NewTextHandler(io.Discard, handlerOptions)))
 	}
 
 	return logger, nil
 }
 
+// initializeHTTPClient creates the http client shared by all requests to the Buchhalter API and supplier APIs.
+func initializeHTTPClient(logger *slog.Logger) *httpclient.Client {
+	timeout := time.Duration(viper.GetInt("buchhalter_http_timeout")) * time.Second
+	maxRetries := viper.GetInt("buchhalter_http_max_retries")
+
+	return httpclient.New(logger, timeout, maxRetries)
+}
+
+// archiveConfig is a named archive of the `buchhalter_archives` setting.
+type archiveConfig struct {
+	Directory        string   `mapstructure:"directory"`
+	StagingDirectory string   `mapstructure:"staging_directory"`
+	Suppliers        []string `mapstructure:"suppliers"`
+}
+
+type groupConfig struct {
+	Directory string   `mapstructure:"directory"`
+	Suppliers []string `mapstructure:"suppliers"`
+}
+
+// readGroupConfigs returns the supplier groups of `buchhalter_groups` (e.g. clients or cost centers).
+func readGroupConfigs() (map[string]groupConfig, error) {
+	groupConfigs := map[string]groupConfig{}
+	err := viper.UnmarshalKey("buchhalter_groups", &groupConfigs)
+	if err != nil {
+		return nil, fmt.Errorf("error in setting buchhalter_groups: %w", err)
+	}
+	return groupConfigs, nil
+}
+
+// groupSuppliers returns the suppliers of a group of `buchhalter_groups`.
+func groupSuppliers(group string) ([]string, error) {
+	groupConfigs, err := readGroupConfigs()
+	if err != nil {
+		return nil, err
+	}
+	config, ok := groupConfigs[group]
+	if !ok {
+		names := make([]string, 0, len(groupConfigs))
+		for name := range groupConfigs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("group %s is not defined in buchhalter_groups (defined: %s)", group, strings.Join(names, ", "))
+	}
+	if len(config.Suppliers) == 0 {
+		return nil, fmt.Errorf("group %s has no suppliers", group)
+	}
+	return config.Suppliers, nil
+}
+
+// initializeDocumentArchives creates the default document archive based on the configured documents directory, layout and staging directory
+// and the named archives of `buchhalter_archives` and `buchhalter_groups` with the same layout.
+// If the `--archive` flag is set, the documents of all suppliers are routed to this archive.
+// Read-only archives without a staging directory are staged in the config directory.
+func initializeDocumentArchives(logger *slog.Logger) *archive.Archives {
+	buchhalterDocumentsDirectory := viper.GetString("buchhalter_documents_directory")
+	documentsLayout := viper.GetString("buchhalter_documents_layout")
+	if err := archive.ValidateLayout(documentsLayout); err != nil {
+		exitMessage := fmt.Sprintf("Error in setting buchhalter_documents_layout: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	stagingDirectory := viper.GetString("buchhalter_staging_directory")
+	supplierTags := map[string][]string{}
+	err := viper.UnmarshalKey("buchhalter_supplier_tags", &supplierTags)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error in setting buchhalter_supplier_tags: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	archiveConfigs := map[string]archiveConfig{}
+	err = viper.UnmarshalKey("buchhalter_archives", &archiveConfigs)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error in setting buchhalter_archives: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	readOnly := viper.GetBool("buchhalter_read_only_archive")
+	newDocumentArchive := func(name, directory, stagingDirectory string) *archive.DocumentArchive {
+		if readOnly && stagingDirectory == "" {
+			stagingDirectory = filepath.Join(viper.GetString("buchhalter_config_directory"), "staging", name)
+		}
+		documentArchive := archive.NewDocumentArchive(logger, directory, documentsLayout, stagingDirectory, supplierTags)
+		if readOnly {
+			// Can't fail, the staging directory is set
+			_ = documentArchive.EnableReadOnly()
+		}
+		return documentArchive
+	}
+
+	archives := archive.NewArchives(newDocumentArchive(archive.DEFAULT_ARCHIVE, buchhalterDocumentsDirectory, stagingDirectory))
+	for name, config := range archiveConfigs {
+		directory := config.Directory
+		if directory == "" {
+			directory = filepath.Join(viper.GetString("buchhalter_directory"), "archives", name)
+		}
+		err = utils.CreateDirectoryIfNotExists(directory)
+		if err != nil {
+			exitMessage := fmt.Sprintf("Error creating directory of archive %s: %s", name, err)
+			exitWithLogo(exitMessage)
+		}
+		err = archives.Add(name, newDocumentArchive(name, directory, config.StagingDirectory), config.Suppliers)
+		if err != nil {
+			exitMessage := fmt.Sprintf("Error in setting buchhalter_archives: %s", err)
+			exitWithLogo(exitMessage)
+		}
+	}
+
+	// Each group stores its documents in an archive of the same name
+	groupConfigs, err := readGroupConfigs()
+	if err != nil {
+		exitWithLogo(err.Error())
+	}
+	for name, config := range groupConfigs {
+		if _, ok := archiveConfigs[name]; ok {
+			exitMessage := fmt.Sprintf("Error in setting buchhalter_groups: group %s has the name of an archive of buchhalter_archives", name)
+			exitWithLogo(exitMessage)
+		}
+		directory := config.Directory
+		if directory == "" {
+			directory = filepath.Join(viper.GetString("buchhalter_directory"), "groups", name)
+		}
+		err = utils.CreateDirectoryIfNotExists(directory)
+		if err != nil {
+			exitMessage := fmt.Sprintf("Error creating directory of group %s: %s", name, err)
+			exitWithLogo(exitMessage)
+		}
+		err = archives.Add(name, newDocumentArchive(name, directory, ""), config.Suppliers)
+		if err != nil {
+			exitMessage := fmt.Sprintf("Error in setting buchhalter_groups: %s", err)
+			exitWithLogo(exitMessage)
+		}
+	}
+
+	if name := viper.GetString("buchhalter_archive"); name != "" {
+		err = archives.RouteAllTo(name)
+		if err != nil {
+			exitMessage := fmt.Sprintf("Error in archive flag: %s", err)
+			exitWithLogo(exitMessage)
+		}
+	}
+
+	return archives
+}
+
+// initializeDocumentArchive returns the document archive selected with the `--archive` flag, the default archive otherwise.
+func initializeDocumentArchive(logger *slog.Logger) *archive.DocumentArchive {
+	archives := initializeDocumentArchives(logger)
+	name := viper.GetString("buchhalter_archive")
+	if name == "" {
+		name = archive.DEFAULT_ARCHIVE
+	}
+	documentArchive, _ := archives.Get(name)
+
+	return documentArchive
+}
+
+// initializeRemoteArchive creates the remote copy of the default archive configured in `buchhalter_remote_archive`.
+// Returns nil if no remote archive is configured.
+func initializeRemoteArchive(logger *slog.Logger) (*archive.RemoteArchive, error) {
+	destination := viper.GetString("buchhalter_remote_archive")
+	if destination == "" {
+		return nil, nil
+	}
+	cacheDirectory := filepath.Join(viper.GetString("buchhalter_config_directory"), "remote", viper.GetString("buchhalter_api_team_slug"))
+
+	return archive.NewRemoteArchive(logger, destination, viper.GetString("buchhalter_remote_archive_ssh_config"), cacheDirectory)
+}
+
+// initializeTokenDirectory returns the OAuth2 token cache directory of the configured profile.
+// Tokens of the legacy token cache file are migrated on first use.
+func initializeTokenDirectory(logger *slog.Logger) string {
+	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
+	tokenDirectory := secrets.TokenDirectory(buchhalterConfigDirectory, viper.GetString("buchhalter_profile"))
+	migrated, err := secrets.MigrateLegacyTokens(buchhalterConfigDirectory, tokenDirectory)
+	if err != nil {
+		logger.Error("Error migrating cached OAuth2 tokens", "token_directory", tokenDirectory, "error", err)
+	} else if migrated > 0 {
+		logger.Info("Migrated cached OAuth2 tokens", "token_directory", tokenDirectory, "migrated", migrated)
+	}
+
+	return tokenDirectory
+}
+
+// initializeAuditLog opens the audit log of credential accesses in the buchhalter directory for command.
+// Accesses aren't recorded (nil) if it can't be opened, the command runs anyway.
+func initializeAuditLog(logger *slog.Logger, command string) *audit.Log {
+	auditLogFile := filepath.Join(viper.GetString("buchhalter_directory"), audit.LOG_FILE_NAME)
+	auditLog, err := audit.Open(logger, auditLogFile, command)
+	if err != nil {
+		logger.Error("Error opening audit log", "file", auditLogFile, "error", err)
+		return nil
+	}
+
+	return auditLog
+}
+
+// initializeTeam returns the team of the user connected to the Buchhalter Platform incl. the restrictions of the role of
+// the user. It returns false if the user isn't connected or the platform isn't reachable, nothing is restricted then.
+func initializeTeam(ctx context.Context, logger *slog.Logger) (repository.Team, bool) {
+	apiToken := viper.GetString("buchhalter_api_token")
+	if apiToken == "" {
+		return repository.Team{}, false
+	}
+	buchhalterAPIClient, err := repository.NewBuchhalterAPIClient(logger, initializeHTTPClient(logger), viper.GetString("buchhalter_api_host"), viper.GetString("buchhalter_config_directory"), apiToken, cliVersion)
+	if err != nil {
+		logger.Error("Error initializing Buchhalter API client", "error", err)
+		return repository.Team{}, false
+	}
+	buchhalterAPIClient.SetTeamSlug(viper.GetString("buchhalter_api_team_slug"))
+	_, err = buchhalterAPIClient.GetAuthenticatedUser(ctx)
+	if err != nil {
+		logger.Error("Error retrieving authenticated user", "error", err)
+		return repository.Team{}, false
+	}
+
+	return buchhalterAPIClient.Team()
+}
+
 func exitWithLogo(message string) {
 	s := fmt.Sprintf(
 		"%s\n%s\n%s%s\n%s\n\n%s",
 		headerStyle(LogoText),
-		textStyle("Automatically sync all your incoming invoices from your suppliers. "),
-		textStyle("More information at: "),
+		textStyle(i18n.T("Automatically sync all your incoming invoices from your suppliers. ")),
+		textStyle(i18n.T("More information at: ")),
 		textStyleBold("https://buchhalter.ai"),
-		textStyleGrayBold(fmt.Sprintf("Using CLI v%s", cliVersion)),
+		textStyleGrayBold(i18n.Tf("Using CLI v%s", cliVersion)),
 		textStyle(message),
 	)
 	fmt.Println(s)
@@ -0,0 +1,774 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"buchhalter/lib/control"
+	"buchhalter/lib/history"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/repository"
+	"buchhalter/lib/secrets"
+	"buchhalter/lib/vault"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// maxServeRunEvents is the number of events of a run kept for the status endpoint.
+	maxServeRunEvents = 500
+
+	// defaultServeWaitTimeout and maxServeWaitTimeout limit long polling requests waiting for the run to complete.
+	defaultServeWaitTimeout = 30 * time.Second
+	maxServeWaitTimeout     = 5 * time.Minute
+
+	// serveTokenExpiryWarning is the time before the end of a cached OAuth2 session the health checks warn about it.
+	serveTokenExpiryWarning = 7 * 24 * time.Hour
+)
+
+// serveOpenAPISpec describes the REST API, e.g. for n8n nodes or other HTTP clients.
+//
+//go:embed serve_openapi.json
+var serveOpenAPISpec []byte
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Starts a local REST API to control buchhalter",
+	Long:  "The serve command starts a REST API on localhost to list suppliers, trigger syncs, query the document archive and stream the run status (e.g. for desktop apps or home automation setups).",
+	Run:   RunServeCommand,
+}
+
+func init() {
+	serveCmd.Flags().String("address", "", "address to listen on (default: buchhalter_serve_address)")
+	rootCmd.AddCommand(serveCmd)
+}
+
+// serveRun is the state of the sync run triggered via the REST API.
+type serveRun struct {
+	mutex sync.Mutex
+
+	ID         string             `json:"id,omitempty"`
+	Running    bool               `json:"running"`
+	Supplier   string             `json:"supplier,omitempty"`
+	StartedAt  time.Time          `json:"startedAt,omitempty"`
+	FinishedAt time.Time          `json:"finishedAt,omitempty"`
+	Results    repository.RunData `json:"results"`
+	Events     []control.Event    `json:"events"`
+
+	subscribers map[chan control.Event]bool
+	// done is closed once the run is completed
+	done chan struct{}
+}
+
+func (r *serveRun) publish(event control.Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Events = append(r.Events, event)
+	if len(r.Events) > maxServeRunEvents {
+		r.Events = r.Events[1:]
+	}
+	for subscriber := range r.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// Slow subscribers miss events instead of blocking the run
+		}
+	}
+}
+
+// serveModel is a bubbletea model without user interface.
+// It records the messages of a sync run as events and quits once the run is completed.
+type serveModel struct {
+	logger     *slog.Logger
+	run        *serveRun
+	statusFile *control.StatusFile
+	// panes track the suppliers of the run to estimate its remaining time
+	panes supplierPanes
+}
+
+func (m serveModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m serveModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m.panes.update(msg)
+	if event, ok := controlEvent(msg); ok {
+		m.panes.annotate(&event)
+		m.run.publish(event)
+		m.statusFile.Publish(event)
+	}
+
+	switch msg := msg.(type) {
+	case viewMsgPermissionRequest:
+		// Nobody can answer interactive questions, changed recipes and scripts need to be approved via `buchhalter sync`
+		m.logger.Info("Denying permission request without interactive user", "title", msg.title)
+		m.run.publish(control.Event{Type: control.EVENT_ERROR, Title: msg.title, Error: "approval required, please run `buchhalter sync` once"})
+		msg.answer <- false
+	case viewMsgStatusUpdate:
+		if msg.shouldQuit {
+			return m, tea.Quit
+		}
+	case viewMsgQuit, viewMsgModeUpdate:
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m serveModel) View() string {
+	return ""
+}
+
+// serveAPI holds the long living dependencies of the REST API.
+type serveAPI struct {
+	// ctx ends when the daemon stops, it cancels running syncs
+	ctx    context.Context
+	logger *slog.Logger
+	token  string
+	// address the REST API listens on, requests have to name it (or localhost or an IP address) as host
+	address string
+
+	vaultProvider       *vault.Provider1Password
+	buchhalterAPIClient *repository.BuchhalterAPIClient
+
+	runMutex   sync.Mutex
+	run        *serveRun
+	statusFile *control.StatusFile
+	// syncInterval is the interval of scheduled syncs, 0 if syncs are only started via the API
+	syncInterval time.Duration
+}
+
+func RunServeCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	address, err := cmd.Flags().GetString("address")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading address flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if address == "" {
+		address = viper.GetString("buchhalter_serve_address")
+	}
+	var syncInterval time.Duration
+	if value := viper.GetString("buchhalter_serve_sync_interval"); value != "" {
+		syncInterval, err = time.ParseDuration(value)
+		if err != nil || syncInterval < time.Minute {
+			exitMessage := fmt.Sprintf("Invalid buchhalter_serve_sync_interval %s, expected a duration of at least one minute (e.g. 24h)", value)
+			exitWithLogo(exitMessage)
+		}
+	}
+
+	if viper.GetBool("buchhalter_serve_monthly_digest") && viper.GetString("buchhalter_webhook_url") == "" {
+		exitWithLogo("buchhalter_serve_monthly_digest requires buchhalter_webhook_url to deliver the digests")
+	}
+
+	// Init vault provider
+	vaultConfigBinary := viper.GetString("credential_provider_cli_command")
+	vaultConfigBase := viper.GetString("credential_provider_vault")
+	vaultConfigTag := viper.GetString("credential_provider_item_tag")
+	logger.Info("Initializing credential provider", "provider", "1Password", "cli_command", vaultConfigBinary, "vault", vaultConfigBase, "tag", vaultConfigTag)
+	vaultProvider, err := vault.GetProvider(vault.PROVIDER_1PASSWORD, vaultConfigBinary, vaultConfigBase, vaultConfigTag)
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+
+	apiHost := viper.GetString("buchhalter_api_host")
+	apiToken := viper.GetString("buchhalter_api_token")
+	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
+	buchhalterAPIClient, err := repository.NewBuchhalterAPIClient(logger, initializeHTTPClient(logger), apiHost, buchhalterConfigDirectory, apiToken, cliVersion)
+	if err != nil {
+		logger.Error("Error initializing Buchhalter API client", "error", err)
+		exitMessage := fmt.Sprintf("Error initializing Buchhalter API client: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	buchhalterAPIClient.SetTeamSlug(viper.GetString("buchhalter_api_team_slug"))
+
+	// `buchhalter status` shows the status of the daemon and of its runs
+	statusFile, err := control.NewStatusFile(logger, filepath.Join(buchhalterDirectory, control.STATUS_FILE_NAME), true, "")
+	if err != nil {
+		logger.Error("Error writing status file", "error", err)
+	}
+	defer statusFile.Close()
+
+	token, err := ensureServeToken()
+	if err != nil {
+		logger.Error("Error generating REST API token", "error", err)
+		exitMessage := fmt.Sprintf("Error generating a token for the REST API: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	api := &serveAPI{
+		ctx:                 cmd.Context(),
+		logger:              logger,
+		token:               token,
+		address:             address,
+		vaultProvider:       vaultProvider,
+		buchhalterAPIClient: buchhalterAPIClient,
+		run:                 &serveRun{subscribers: make(map[chan control.Event]bool)},
+		statusFile:          statusFile,
+		syncInterval:        syncInterval,
+	}
+	if syncInterval > 0 {
+		logger.Info("Scheduling syncs", "interval", syncInterval)
+		go api.scheduleSyncs(syncInterval)
+	}
+	if viper.GetBool("buchhalter_serve_monthly_digest") {
+		logger.Info("Scheduling monthly digests")
+		go api.scheduleMonthlyDigests()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/suppliers", api.handleListSuppliers)
+	mux.HandleFunc("POST /api/sync", api.handleSync)
+	mux.HandleFunc("GET /api/runs/current", api.handleRunStatus)
+	mux.HandleFunc("GET /api/runs/current/events", api.handleRunEvents)
+	mux.HandleFunc("GET /api/runs/current/wait", api.handleRunWait)
+	mux.HandleFunc("GET /api/documents", api.handleListDocuments)
+	mux.HandleFunc("GET /api/openapi.json", api.handleOpenAPISpec)
+	mux.HandleFunc("GET /api/health", api.handleHealth)
+	mux.HandleFunc("GET /healthz", api.handleHealthz)
+	mux.HandleFunc("GET /readyz", api.handleReadyz)
+
+	logger.Info("Starting REST API", "address", address)
+	fmt.Println(textStyle(fmt.Sprintf("Serving the buchhalter REST API on http://%s/api (press ctrl+c to stop)", address)))
+	server := &http.Server{Addr: address, Handler: api.authenticate(mux)}
+	go func() {
+		<-api.ctx.Done()
+		logger.Info("Stopping REST API", "cause", context.Cause(api.ctx))
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(api.ctx), runShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Error serving REST API", "address", address, "error", err)
+		exitMessage := fmt.Sprintf("Error serving REST API on %s: %s", address, err)
+		exitWithLogo(exitMessage)
+	}
+}
+
+// ensureServeToken returns the token of the REST API. On the first start, a random token is generated and stored as
+// `buchhalter_serve_token` in the configuration file.
+func ensureServeToken() (string, error) {
+	token := viper.GetString("buchhalter_serve_token")
+	if token != "" {
+		return token, nil
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token = hex.EncodeToString(b)
+	err = writeConfigValue("buchhalter_serve_token", token)
+	if err != nil {
+		return "", err
+	}
+	fmt.Println(textStyle(fmt.Sprintf("Generated a token for the REST API, see buchhalter_serve_token in %s.", viper.ConfigFileUsed())))
+	return token, nil
+}
+
+// authenticate requires the bearer token configured in `buchhalter_serve_token`.
+// The health checks are public, as uptime monitors and Kubernetes probes usually can't send tokens.
+// Requests of web pages (cross-origin or via DNS rebinding) are rejected for all endpoints, see allowRequest.
+func (a *serveAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusCode, err := a.allowRequest(r)
+		if err != nil {
+			a.logger.Warn("Rejected REST API request", "method", r.Method, "path", r.URL.Path, "host", r.Host, "origin", r.Header.Get("Origin"), "error", err)
+			writeJSONError(w, statusCode, err.Error())
+			return
+		}
+		public := r.URL.Path == "/healthz" || r.URL.Path == "/readyz"
+		if !public && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+a.token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowRequest rejects requests with a Host header naming neither the loopback interface, an IP address nor the
+// configured address (DNS rebinding), with an Origin header of another origin, and mutating requests without JSON body,
+// which web pages could send without CORS preflight.
+func (a *serveAPI) allowRequest(r *http.Request) (int, error) {
+	if !allowedServeHost(r.Host, a.address) {
+		return http.StatusMisdirectedRequest, fmt.Errorf("host %s not allowed", r.Host)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return http.StatusForbidden, fmt.Errorf("origin %s not allowed", origin)
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return http.StatusUnsupportedMediaType, errors.New("content type application/json required")
+		}
+	}
+	return 0, nil
+}
+
+// allowedServeHost returns whether host (of the Host header) is localhost, an IP address or the host of address.
+// Domain names other than the configured one may resolve to the REST API via DNS rebinding.
+func allowedServeHost(host, address string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	hostname = strings.Trim(hostname, "[]")
+	if hostname == "" {
+		return false
+	}
+	if strings.EqualFold(hostname, "localhost") || net.ParseIP(hostname) != nil {
+		return true
+	}
+	configuredHost, _, err := net.SplitHostPort(address)
+	return err == nil && configuredHost != "" && strings.EqualFold(hostname, configuredHost)
+}
+
+type serveSupplier struct {
+	Supplier    string `json:"supplier"`
+	Type        string `json:"type"`
+	Version     string `json:"version"`
+	VaultItemId string `json:"vaultItemId"`
+}
+
+func (a *serveAPI) handleListSuppliers(w http.ResponseWriter, r *http.Request) {
+	_, err := a.vaultProvider.LoadVaultItems()
+	if err != nil {
+		a.logger.Error(a.vaultProvider.GetHumanReadableErrorMessage(err))
+		writeJSONError(w, http.StatusBadGateway, a.vaultProvider.GetHumanReadableErrorMessage(err))
+		return
+	}
+
+	recipeParser := parser.NewRecipeParser(a.logger, viper.GetString("buchhalter_config_directory"), viper.GetString("buchhalter_directory"))
+	recipes, err := prepareRecipes(a.logger, "", a.vaultProvider, recipeParser)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	suppliers := make([]serveSupplier, 0, len(recipes))
+	for _, recipe := range recipes {
+		suppliers = append(suppliers, serveSupplier{
+			Supplier:    recipe.recipe.Supplier,
+			Type:        recipe.recipe.Type,
+			Version:     recipe.recipe.Version,
+			VaultItemId: recipe.vaultItemId,
+		})
+	}
+	writeJSON(w, http.StatusOK, suppliers)
+}
+
+type serveSyncRequest struct {
+	Supplier    string `json:"supplier"`
+	NoUpload    bool   `json:"noUpload"`
+	AutoApprove bool   `json:"autoApprove"`
+	Force       bool   `json:"force"`
+}
+
+// handleSync starts a sync run in the background. Only one run can be active at a time.
+func (a *serveAPI) handleSync(w http.ResponseWriter, r *http.Request) {
+	var syncRequest serveSyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&syncRequest); err != nil && err != io.EOF {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	runID, statusCode, err := a.startSync(syncRequest)
+	if err != nil {
+		writeJSONError(w, statusCode, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "started", "runId": runID})
+}
+
+// scheduleSyncs starts a sync of all suppliers every interval.
+func (a *serveAPI) scheduleSyncs(interval time.Duration) {
+	for {
+		next := time.Now().Add(interval)
+		a.statusFile.SetNextRun(next)
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		runID, _, err := a.startSync(serveSyncRequest{})
+		if err != nil {
+			a.logger.Error("Error starting scheduled sync", "error", err)
+			continue
+		}
+		a.logger.Info("Started scheduled sync", "run_id", runID)
+	}
+}
+
+// startSync starts a sync run in the background and returns its id.
+// If the run can't be started, the HTTP status code for the error is returned.
+func (a *serveAPI) startSync(syncRequest serveSyncRequest) (string, int, error) {
+	a.runMutex.Lock()
+	defer a.runMutex.Unlock()
+	a.run.mutex.Lock()
+	running := a.run.Running
+	a.run.mutex.Unlock()
+	if running {
+		return "", http.StatusConflict, errors.New("a sync is running already")
+	}
+
+	_, err := a.vaultProvider.LoadVaultItems()
+	if err != nil {
+		a.logger.Error(a.vaultProvider.GetHumanReadableErrorMessage(err))
+		return "", http.StatusBadGateway, errors.New(a.vaultProvider.GetHumanReadableErrorMessage(err))
+	}
+
+	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
+	recipeParser := parser.NewRecipeParser(a.logger, buchhalterConfigDirectory, viper.GetString("buchhalter_directory"))
+	localOICDBChecksum, err := recipeParser.GetChecksumOfLocalOICDB()
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("error calculating checksum of local Open Invoice Collector Database: %w", err)
+	}
+	localOICDBSchemaChecksum, err := recipeParser.GetChecksumOfLocalOICDBSchema()
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("error calculating checksum of local Open Invoice Collector Database Schema: %w", err)
+	}
+
+	// Results of previous runs must not be reported again
+	RunData = nil
+
+	runID := newServeRunID()
+	a.run.mutex.Lock()
+	a.run.ID = runID
+	a.run.Running = true
+	a.run.Supplier = syncRequest.Supplier
+	a.run.StartedAt = time.Now()
+	a.run.FinishedAt = time.Time{}
+	a.run.Results = nil
+	a.run.Events = nil
+	a.run.done = make(chan struct{})
+	a.run.mutex.Unlock()
+
+	a.logger.Info("Starting sync via REST API", "supplier", syncRequest.Supplier, "no_upload", syncRequest.NoUpload, "auto_approve", syncRequest.AutoApprove)
+	p := tea.NewProgram(serveModel{logger: a.logger, run: a.run, statusFile: a.statusFile}, tea.WithoutRenderer(), tea.WithInput(nil), tea.WithOutput(io.Discard))
+	go func() {
+		defer recoverCrash()
+
+		httpClient := initializeHTTPClient(a.logger)
+		archives := initializeDocumentArchives(a.logger)
+		deps := syncDeps{
+			logger:              a.logger,
+			httpClient:          httpClient,
+			vaultProvider:       a.vaultProvider,
+			archives:            archives,
+			recipeParser:        recipeParser,
+			buchhalterAPIClient: a.buchhalterAPIClient,
+			statusFile:          a.statusFile,
+		}
+		opts := syncOptions{
+			supplier:    syncRequest.Supplier,
+			noUpload:    syncRequest.NoUpload,
+			autoApprove: syncRequest.AutoApprove,
+			force:       syncRequest.Force,
+		}
+		go runRecipes(a.ctx, p, deps, opts, localOICDBChecksum, localOICDBSchemaChecksum)
+		if _, err := p.Run(); err != nil {
+			a.logger.Error("Error running sync via REST API", "error", err)
+		}
+		archives.Close()
+
+		a.run.mutex.Lock()
+		a.run.Running = false
+		a.run.FinishedAt = time.Now()
+		a.run.Results = append(repository.RunData{}, RunData...)
+		close(a.run.done)
+		a.run.mutex.Unlock()
+		a.statusFile.FinishRun()
+		a.run.publish(control.Event{Type: control.EVENT_COMPLETED, Supplier: syncRequest.Supplier})
+		a.logger.Info("Sync via REST API completed", "supplier", syncRequest.Supplier, "run_id", runID)
+	}()
+
+	return runID, http.StatusAccepted, nil
+}
+
+func newServeRunID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return "run_" + hex.EncodeToString(id)
+}
+
+func (a *serveAPI) handleRunStatus(w http.ResponseWriter, r *http.Request) {
+	a.run.mutex.Lock()
+	defer a.run.mutex.Unlock()
+	writeJSON(w, http.StatusOK, a.run)
+}
+
+// handleRunWait long-polls until the current run is completed or the `timeout` (in seconds) is reached.
+// The status of the run is returned in both cases, clients poll again as long as `running` is true.
+func (a *serveAPI) handleRunWait(w http.ResponseWriter, r *http.Request) {
+	timeout := defaultServeWaitTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid timeout "+value)
+			return
+		}
+		timeout = min(time.Duration(seconds)*time.Second, maxServeWaitTimeout)
+	}
+
+	a.run.mutex.Lock()
+	done := a.run.done
+	a.run.mutex.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-time.After(timeout):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	a.handleRunStatus(w, r)
+}
+
+func (a *serveAPI) handleOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(serveOpenAPISpec)
+}
+
+// serveHealth is the response of the health checks.
+type serveHealth struct {
+	// Status is "ok" or "degraded", see Problems
+	Status              string              `json:"status"`
+	Problems            []string            `json:"problems"`
+	Running             bool                `json:"running"`
+	LastRunAt           time.Time           `json:"lastRunAt,omitempty"`
+	LastSuccessfulRunAt time.Time           `json:"lastSuccessfulRunAt,omitempty"`
+	FailingSuppliers    []string            `json:"failingSuppliers"`
+	TokenWarnings       []serveTokenWarning `json:"tokenWarnings"`
+}
+
+// serveTokenWarning is a cached OAuth2 session that ended or ends soon, the next sync has to log in again.
+type serveTokenWarning struct {
+	Supplier     string    `json:"supplier"`
+	CredentialId string    `json:"credentialId"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	Expired      bool      `json:"expired"`
+}
+
+// handleHealthz is the liveness check: it reports the health status, but always succeeds while the daemon responds.
+// The checks are public, so they only report the status. The details (suppliers, vault items) require the token, see
+// handleHealth.
+func (a *serveAPI) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": a.health().Status})
+}
+
+// handleReadyz is the readiness check: it fails with 503 while the health is degraded.
+func (a *serveAPI) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	health := a.health()
+	statusCode := http.StatusOK
+	if health.Status != "ok" {
+		statusCode = http.StatusServiceUnavailable
+	}
+	writeJSON(w, statusCode, map[string]string{"status": health.Status})
+}
+
+// handleHealth reports the health of the runs with its problems, failing suppliers and ending OAuth2 sessions.
+func (a *serveAPI) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.health())
+}
+
+// health checks the run history and the cached OAuth2 sessions. The health is degraded if suppliers failed in their
+// last run or, with scheduled syncs, no run succeeded within two intervals. Ending sessions are only warnings, as the
+// next sync logs in again.
+func (a *serveAPI) health() serveHealth {
+	now := time.Now()
+	health := serveHealth{Status: "ok", Problems: []string{}, FailingSuppliers: []string{}, TokenWarnings: []serveTokenWarning{}}
+	a.run.mutex.Lock()
+	health.Running = a.run.Running
+	a.run.mutex.Unlock()
+
+	runs, err := history.NewRunHistory(a.logger, viper.GetString("buchhalter_directory")).Runs()
+	if err != nil {
+		a.logger.Error("Error reading run history for health check", "error", err)
+		health.Problems = append(health.Problems, "run history not readable")
+	}
+	runHealth := history.CheckHealth(runs)
+	health.LastRunAt = runHealth.LastRunAt
+	health.LastSuccessfulRunAt = runHealth.LastSuccessfulRunAt
+	health.FailingSuppliers = runHealth.FailingSuppliers
+	if len(health.FailingSuppliers) > 0 {
+		health.Problems = append(health.Problems, fmt.Sprintf("%d supplier(s) failed in their last run", len(health.FailingSuppliers)))
+	}
+	if a.syncInterval > 0 && !runHealth.LastSuccessfulRunAt.IsZero() && now.Sub(runHealth.LastSuccessfulRunAt) > 2*a.syncInterval {
+		health.Problems = append(health.Problems, "no successful run within two sync intervals")
+	}
+
+	cachedTokens, err := secrets.ListOauth2Tokens(initializeTokenDirectory(a.logger))
+	if err != nil {
+		a.logger.Error("Error reading token cache for health check", "error", err)
+		health.Problems = append(health.Problems, "token cache not readable")
+	}
+	for _, cached := range cachedTokens {
+		expiresAt, ok := cached.Tokens.SessionExpiresAt()
+		if !ok || expiresAt.Sub(now) > serveTokenExpiryWarning {
+			continue
+		}
+		health.TokenWarnings = append(health.TokenWarnings, serveTokenWarning{Supplier: cached.Supplier, CredentialId: cached.CredentialId, ExpiresAt: expiresAt, Expired: now.After(expiresAt)})
+	}
+
+	if len(health.Problems) > 0 {
+		health.Status = "degraded"
+	}
+	return health
+}
+
+// handleRunEvents streams the events of the current run as server-sent events.
+func (a *serveAPI) handleRunEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	events := make(chan control.Event, 100)
+	a.run.mutex.Lock()
+	a.run.subscribers[events] = true
+	a.run.mutex.Unlock()
+	defer func() {
+		a.run.mutex.Lock()
+		delete(a.run.subscribers, events)
+		a.run.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+type serveDocument struct {
+	Checksum string    `json:"checksum"`
+	Path     string    `json:"path"`
+	Supplier string    `json:"supplier"`
+	AddedAt  time.Time `json:"addedAt"`
+	Reviewed bool      `json:"reviewed"`
+	Rejected bool      `json:"rejected"`
+	Tags     []string  `json:"tags"`
+	// Verification of the download against the supplier (see archive.VERIFICATION_* constants)
+	Verification string `json:"verification,omitempty"`
+}
+
+// handleListDocuments lists the documents of the archive, optionally filtered by `supplier` and `tag`.
+func (a *serveAPI) handleListDocuments(w http.ResponseWriter, r *http.Request) {
+	// Fresh archives are read on every request, as a running sync changes the archives
+	archives := initializeDocumentArchives(a.logger)
+	defer archives.Close()
+	err := archives.BuildArchiveIndex(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "error building document archive index: "+err.Error())
+		return
+	}
+
+	supplier := r.URL.Query().Get("supplier")
+	tag := r.URL.Query().Get("tag")
+	documents := []serveDocument{}
+	for checksum, file := range archives.GetFileIndex() {
+		if supplier != "" && file.Supplier != supplier {
+			continue
+		}
+		if tag != "" && !containsString(file.Tags, tag) {
+			continue
+		}
+		documents = append(documents, serveDocument{
+			Checksum:     checksum,
+			Path:         file.Path,
+			Supplier:     file.Supplier,
+			AddedAt:      file.AddedAt,
+			Reviewed:     file.Reviewed,
+			Rejected:     file.Rejected,
+			Tags:         file.Tags,
+			Verification: file.Verification,
+		})
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].AddedAt.After(documents[j].AddedAt)
+	})
+
+	writeJSON(w, http.StatusOK, documents)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"error": message})
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/history"
+	"buchhalter/lib/redact"
+	"buchhalter/lib/webhook"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// monthlyDigestStateFileName stores the last month a digest was sent for (in the config directory)
+	monthlyDigestStateFileName = "monthly_digest_sent.json"
+
+	// monthlyDigestRetryInterval is the time to wait before a failed digest is sent again
+	monthlyDigestRetryInterval = time.Hour
+)
+
+type monthlyDigestState struct {
+	Month  string    `json:"month"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+// scheduleMonthlyDigests sends a `digest.monthly` webhook event after the end of each month.
+// A digest missed while the daemon was stopped is sent on start. On the very first start, the digest of the previous
+// month is skipped, it would cover a month the daemon didn't run in.
+func (a *serveAPI) scheduleMonthlyDigests() {
+	stateFile := filepath.Join(viper.GetString("buchhalter_config_directory"), monthlyDigestStateFileName)
+	for {
+		now := time.Now()
+		startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+		previousMonth := startOfMonth.AddDate(0, -1, 0).Format("2006-01")
+		wait := time.Until(startOfMonth.AddDate(0, 1, 0))
+
+		state, err := readMonthlyDigestState(stateFile)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("error reading %s: %w", stateFile, err)
+		case state.Month == "":
+			a.logger.Info("Skipping monthly digest of the month before the first start", "month", previousMonth)
+			err = writeMonthlyDigestState(stateFile, monthlyDigestState{Month: previousMonth})
+		case state.Month < previousMonth:
+			err = a.sendMonthlyDigest(previousMonth)
+			if err == nil {
+				err = writeMonthlyDigestState(stateFile, monthlyDigestState{Month: previousMonth, SentAt: time.Now()})
+			}
+		}
+		if err != nil {
+			a.logger.Error("Error sending monthly digest", "month", previousMonth, "error", err)
+			wait = min(wait, monthlyDigestRetryInterval)
+		}
+
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// sendMonthlyDigest sends the digest of month (e.g. 2024-09) to buchhalter_webhook_url.
+func (a *serveAPI) sendMonthlyDigest(month string) error {
+	period, err := archive.ParsePeriod(month, time.Local)
+	if err != nil {
+		return err
+	}
+
+	// Fresh archives are read, like for the documents endpoint
+	archives := initializeDocumentArchives(a.logger)
+	defer archives.Close()
+	err = archives.BuildArchiveIndex(a.ctx)
+	if err != nil {
+		return err
+	}
+	runs, err := history.NewRunHistory(a.logger, viper.GetString("buchhalter_directory")).Runs()
+	if err != nil {
+		return err
+	}
+	digest := webhook.NewMonthlyDigest(period, runs, archives.GetFileIndex(), configuredCadences(a.logger), configuredSupplierDefaults())
+
+	webhookSecret := viper.GetString("buchhalter_webhook_secret")
+	redact.AddSecrets(webhookSecret)
+	sender := webhook.NewEventSender(a.logger, initializeHTTPClient(a.logger), viper.GetString("buchhalter_webhook_url"), webhookSecret, viper.GetStringSlice("buchhalter_webhook_events"))
+	a.logger.Info("Sending monthly digest", "month", month, "documents", digest.Documents, "failures", digest.Failures, "missing_suppliers", digest.MissingSuppliers)
+	return sender.Send(a.ctx, webhook.NewEvent(webhook.EVENT_MONTHLY_DIGEST, digest))
+}
+
+func readMonthlyDigestState(filePath string) (monthlyDigestState, error) {
+	var state monthlyDigestState
+	fileContent, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(fileContent, &state)
+	return state, err
+}
+
+func writeMonthlyDigestState(filePath string, state monthlyDigestState) error {
+	fileContent, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, fileContent, 0644)
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/pdf"
+	"buchhalter/lib/termimage"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// previewDPI renders an A4 page about 800 pixels wide
+	previewDPI = 96
+	// previewMaxWidth limits the width of image documents (e.g. scanned receipts)
+	previewMaxWidth = 1000
+)
+
+var showCmd = &cobra.Command{
+	Use:   "show <document>",
+	Short: "Shows a document of the archive in the terminal",
+	Long:  "The show command renders the first page of a document, identified by its path or checksum, in terminals supporting kitty or sixel graphics and lists its metadata. Other terminals only get the metadata.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunShowCommand,
+}
+
+func init() {
+	showCmd.Flags().String("graphics", "auto", "graphics protocol of the terminal: auto, kitty, sixel or none")
+	rootCmd.AddCommand(showCmd)
+}
+
+func RunShowCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	protocol, err := cmd.Flags().GetString("graphics")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading graphics flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	switch protocol {
+	case "auto":
+		protocol = termimage.DetectProtocol(os.Getenv)
+	case termimage.PROTOCOL_KITTY, termimage.PROTOCOL_SIXEL, termimage.PROTOCOL_NONE:
+	default:
+		exitWithLogo(fmt.Sprintf("Unsupported graphics protocol %s, use auto, kitty, sixel or none", protocol))
+	}
+
+	documentArchive := initializeDocumentArchive(logger)
+	err = documentArchive.BuildArchiveIndex(cmd.Context())
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+		exitMessage := fmt.Sprintf("Error building document archive index: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	checksum, ok := documentArchive.FindFile(cmdArgs[0])
+	if !ok {
+		fmt.Println(i18n.Tf("Document %s not found in archive.", cmdArgs[0]))
+		return
+	}
+	f, _ := documentArchive.GetFile(checksum)
+
+	if protocol != termimage.PROTOCOL_NONE {
+		img, err := previewImage(f.Path)
+		if err == nil {
+			err = termimage.Write(os.Stdout, protocol, img)
+		}
+		if err != nil {
+			logger.Error("Error rendering document preview", "document", f.Path, "error", err)
+			fmt.Println(errorStyle.Render(i18n.Tf("No preview: %s", err)))
+		}
+	}
+
+	printDocumentMetadata(checksum, f)
+}
+
+// previewImage returns the first page of a PDF document or the image of an image document.
+func previewImage(path string) (image.Image, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pdf":
+		return pdf.NewPageRenderer(viper.GetString("buchhalter_ghostscript_path")).Render(path, 1, previewDPI)
+	case ".png", ".jpg", ".jpeg":
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		img, _, err := image.Decode(file)
+		if err != nil {
+			return nil, err
+		}
+		return termimage.Fit(img, previewMaxWidth), nil
+	}
+	return nil, fmt.Errorf("preview of %s files is not supported", filepath.Ext(path))
+}
+
+// printDocumentMetadata lists the metadata of the archive and, for PDF documents, of the document itself.
+func printDocumentMetadata(checksum string, f archive.File) {
+	row := func(label, value string) {
+		if value != "" {
+			fmt.Printf("%s %s\n", textStyleBold(fmt.Sprintf("%-14s", i18n.T(label))), value)
+		}
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(time.DateTime)
+	}
+
+	fmt.Println(headerStyle(filepath.Base(f.Path)))
+	row("Path:", f.Path)
+	row("Supplier:", f.Supplier)
+	row("Checksum:", checksum)
+	row("Added:", formatTime(f.AddedAt))
+	if stat, err := os.Stat(f.Path); err == nil {
+		row("Size:", fmt.Sprintf("%.1f KB", float64(stat.Size())/1024))
+	}
+
+	if strings.ToLower(filepath.Ext(f.Path)) == ".pdf" {
+		if pageCount, err := pdf.New().PageCount(f.Path); err == nil {
+			row("Pages:", fmt.Sprintf("%d", pageCount))
+		}
+		if content, err := os.ReadFile(f.Path); err == nil {
+			info := pdf.ReadInfo(content)
+			row("Title:", info.Title)
+			row("Author:", info.Author)
+			row("Creator:", info.Creator)
+			row("Producer:", info.Producer)
+			row("Created:", formatTime(info.CreationDate))
+		}
+	}
+
+	if f.Metadata != nil {
+		row("Invoice no.:", f.Metadata.InvoiceNumber)
+		row("Invoice date:", f.Metadata.Date)
+		row("Amount:", f.Metadata.Amount)
+	}
+	row("Tags:", strings.Join(f.Tags, ", "))
+	switch {
+	case f.Rejected:
+		row("Review:", i18n.T("rejected"))
+	case f.Reviewed:
+		row("Review:", i18n.T("accepted"))
+	case f.Staged:
+		row("Review:", i18n.T("pending"))
+	}
+}
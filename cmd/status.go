@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"buchhalter/lib/control"
+	"buchhalter/lib/history"
+	"buchhalter/lib/i18n"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows the progress of a running sync and the last run",
+	Long:  "The status command shows the current supplier, step, elapsed time and queue of a running sync (started by `buchhalter sync` or `buchhalter serve`). Without a running sync, it shows a summary of the last run and the next scheduled run.",
+	Run:   RunStatusCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
+
+func RunStatusCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	status, err := control.ReadStatus(filepath.Join(buchhalterDirectory, control.STATUS_FILE_NAME))
+	if err != nil {
+		logger.Error("Error reading status file", "error", err)
+		exitMessage := fmt.Sprintf("Error reading status file: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	if status != nil && status.Running {
+		fmt.Println(headerStyle(i18n.Tf("Sync running (pid %d, started %s, elapsed %s)", status.PID, status.StartedAt.Format(time.DateTime), time.Since(status.StartedAt).Round(time.Second))))
+		if status.Supplier != "" {
+			fmt.Printf("  %-16s %s\n", i18n.T("Supplier:"), status.Supplier)
+		}
+		if status.Step != "" {
+			fmt.Printf("  %-16s %s\n", i18n.T("Step:"), status.Step)
+		}
+		fmt.Printf("  %-16s %.0f%%\n", i18n.T("Progress:"), status.Percent*100)
+		if !status.EstimatedEndAt.IsZero() {
+			fmt.Printf("  %-16s %s\n", i18n.T("ETA:"), i18n.Tf("%s (in %s)", status.EstimatedEndAt.Format(time.TimeOnly), max(0, time.Until(status.EstimatedEndAt)).Round(time.Second)))
+		}
+		queue := i18n.T("(empty)")
+		if len(status.Queue) > 0 {
+			queue = fmt.Sprintf("%s (%d)", strings.Join(status.Queue, ", "), len(status.Queue))
+		}
+		fmt.Printf("  %-16s %s\n", i18n.T("Queue:"), queue)
+		if status.ControlSocket != "" {
+			fmt.Printf("  %-16s %s\n", i18n.T("Control socket:"), status.ControlSocket)
+		}
+		return
+	}
+
+	if status != nil && status.Daemon {
+		fmt.Println(headerStyle(i18n.Tf("buchhalter serve is running (pid %d), no sync is running", status.PID)))
+	} else {
+		fmt.Println(headerStyle(i18n.T("No sync is running")))
+	}
+
+	runs, err := history.NewRunHistory(logger, buchhalterDirectory).Runs()
+	if err != nil {
+		logger.Error("Error reading run history", "error", err)
+		exitMessage := fmt.Sprintf("Error reading run history: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if len(runs) == 0 {
+		fmt.Println(textStyle(i18n.T("No sync runs recorded yet.")))
+	} else {
+		lastRun := runs[len(runs)-1]
+		newFiles := 0
+		var failedSuppliers []string
+		for _, supplierRun := range lastRun.Suppliers {
+			newFiles += supplierRun.NewFiles
+			if supplierRun.Status == "error" {
+				failedSuppliers = append(failedSuppliers, supplierRun.Supplier)
+			}
+		}
+		fmt.Println(textStyleBold(i18n.T("Last run:")))
+		fmt.Printf("  %-16s %s\n", i18n.T("Started:"), i18n.Tf("%s (took %s)", lastRun.StartedAt.Format(time.DateTime), (time.Duration(lastRun.Duration)*time.Second).Round(time.Second)))
+		fmt.Printf("  %-16s %d\n", i18n.T("Suppliers:"), len(lastRun.Suppliers))
+		fmt.Printf("  %-16s %d\n", i18n.T("New documents:"), newFiles)
+		if len(failedSuppliers) > 0 {
+			fmt.Printf("  %-16s %s\n", i18n.T("Failed:"), strings.Join(failedSuppliers, ", "))
+		}
+	}
+
+	if status != nil && !status.NextRunAt.IsZero() {
+		fmt.Printf("%s %s\n", textStyleBold(i18n.T("Next scheduled run:")), i18n.Tf("%s (in %s)", status.NextRunAt.Format(time.DateTime), time.Until(status.NextRunAt).Round(time.Second)))
+	} else {
+		fmt.Println(textStyle(i18n.T("No scheduled runs (see buchhalter_serve_sync_interval).")))
+	}
+}
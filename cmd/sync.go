@@ -1,22 +1,36 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"buchhalter/lib/archive"
-	"buchhalter/lib/browser"
+	"buchhalter/lib/control"
+	"buchhalter/lib/fixture"
+	"buchhalter/lib/history"
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/paperless"
 	"buchhalter/lib/parser"
+	"buchhalter/lib/pdf"
+	"buchhalter/lib/redact"
 	"buchhalter/lib/repository"
+	"buchhalter/lib/timestamp"
 	"buchhalter/lib/utils"
 	"buchhalter/lib/vault"
+	"buchhalter/lib/webhook"
 
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -26,6 +40,9 @@ var (
 	RunData       repository.RunData
 )
 
+// runShutdownTimeout is how long a cancelled run may take to release its resources (e.g. to quit the browser)
+const runShutdownTimeout = 10 * time.Second
+
 type recipeToExecute struct {
 	recipe      *parser.Recipe
 	vaultItemId string
@@ -39,6 +56,23 @@ var syncCmd = &cobra.Command{
 }
 
 func init() {
+	syncCmd.Flags().Bool("no-upload", false, "skip uploading new documents to the Buchhalter Platform")
+	syncCmd.Flags().Bool("auto-approve", false, "run changed recipes without asking for confirmation")
+	syncCmd.Flags().Bool("force", false, "run recipes of suppliers already synced within their frequency (weekly or monthly)")
+	syncCmd.Flags().BoolP("interactive", "i", false, "select the suppliers to sync from a list")
+	syncCmd.Flags().String("group", "", "sync the suppliers of a group of buchhalter_groups (e.g. a client)")
+	syncCmd.Flags().String("control-socket", "", "path of a unix socket streaming progress events and accepting commands (pause, resume, skip, abort)")
+	syncCmd.Flags().String("record-fixture", "", "record network traffic and DOM snapshots of a successful run into a fixture file (requires a supplier)")
+	syncCmd.Flags().String("record-video", "", "record a video (animated GIF) of each browser recipe run into a directory, e.g. to debug recipes")
+	syncCmd.Flags().Int("devtools", 0, "expose the remote debugging port of Chrome (default 9222 if no port is given) to attach Chrome DevTools to running browser recipes")
+	syncCmd.Flags().Lookup("devtools").NoOptDefVal = "9222"
+	syncCmd.Flags().Bool("list-only", false, "list the documents offered by the suppliers without downloading them, e.g. to estimate a backfill or verify access")
+	syncCmd.Flags().String("remote-debugging-url", "", "run browser recipes in a running Chrome instead of a local one, e.g. ws://127.0.0.1:3000 (browserless) or http://scraper:9222")
+	err := viper.BindPFlag("buchhalter_remote_debugging_url", syncCmd.Flags().Lookup("remote-debugging-url"))
+	if err != nil {
+		fmt.Printf("Failed to bind 'remote-debugging-url' flag: %v\n", err)
+		os.Exit(1)
+	}
 	rootCmd.AddCommand(syncCmd)
 }
 
@@ -64,9 +98,99 @@ func RunSyncCommand(cmd *cobra.Command, cmdArgs []string) {
 	logger.Info("Booting up", "development_mode", developmentMode)
 	defer logger.Info("Shutting down")
 
-	// Init document archive
-	buchhalterDocumentsDirectory := viper.GetString("buchhalter_documents_directory")
-	documentArchive := archive.NewDocumentArchive(logger, buchhalterDocumentsDirectory)
+	noUpload, err := cmd.Flags().GetBool("no-upload")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading no-upload flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	autoApprove, err := cmd.Flags().GetBool("auto-approve")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading auto-approve flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading force flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	interactive, err := cmd.Flags().GetBool("interactive")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading interactive flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	controlSocket, err := cmd.Flags().GetString("control-socket")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading control-socket flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	recordFixture, err := cmd.Flags().GetString("record-fixture")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading record-fixture flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if recordFixture != "" && supplier == "" {
+		exitWithLogo("The record-fixture flag requires a supplier, e.g. `buchhalter sync hetzner --record-fixture hetzner.json`")
+	}
+
+	recordVideo, err := cmd.Flags().GetString("record-video")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading record-video flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	devToolsPort, err := cmd.Flags().GetInt("devtools")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading devtools flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	group, err := cmd.Flags().GetString("group")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading group flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	listOnly, err := cmd.Flags().GetBool("list-only")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading list-only flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	if listOnly && recordFixture != "" {
+		exitWithLogo("The list-only flag can't be combined with the record-fixture flag")
+	}
+	if devToolsPort != 0 && viper.GetString("buchhalter_remote_debugging_url") != "" {
+		exitWithLogo("The devtools flag can't be combined with a remote browser, attach Chrome DevTools to the remote browser instead")
+	}
+
+	var selectedSuppliers []string
+	if group != "" {
+		if supplier != "" || interactive {
+			exitWithLogo("The group flag can't be combined with a supplier or the interactive flag")
+		}
+		selectedSuppliers, err = groupSuppliers(group)
+		if err != nil {
+			exitWithLogo(err.Error())
+		}
+		logger.Info("Syncing suppliers of group", "group", group, "suppliers", selectedSuppliers)
+	}
+
+	var controlServer *control.Server
+	if controlSocket != "" {
+		controlServer, err = control.NewServer(logger, controlSocket)
+		if err != nil {
+			logger.Error("Error opening control socket", "socket", controlSocket, "error", err)
+			exitMessage := fmt.Sprintf("Error opening control socket %s: %s", controlSocket, err)
+			exitWithLogo(exitMessage)
+		}
+		defer controlServer.Close()
+	}
+
+	// Init document archives
+	archives := initializeDocumentArchives(logger)
 
 	// Init vault provider
 	vaultConfigBinary := viper.GetString("credential_provider_cli_command")
@@ -80,8 +204,46 @@ func RunSyncCommand(cmd *cobra.Command, cmdArgs []string) {
 		exitWithLogo(exitMessage)
 	}
 
+	// Load vault items/try to connect to vault
+	vaultItems, err := vaultProvider.LoadVaultItems()
+	if err != nil {
+		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		exitWithLogo(exitMessage)
+	}
+
+	// Check if vault items are available
+	if len(vaultItems) == 0 {
+		// TODO Add link with help article
+		logger.Error("No credential items loaded from vault", "provider", "1Password", "cli_command", vaultConfigBinary, "vault", vaultConfigBase, "tag", vaultConfigTag)
+		exitMessage := fmt.Sprintf("No credential items found in vault '%s' with tag '%s'. Please check your 1password vault items.", vaultConfigBase, vaultConfigTag)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Credential items loaded from vault", "num_items", len(vaultItems), "provider", "1Password", "cli_command", vaultConfigBinary, "vault", vaultConfigBase, "tag", vaultConfigTag)
+
+	if interactive {
+		selectedSuppliers = pickSuppliers(logger, vaultProvider, viper.GetString("buchhalter_config_directory"), buchhalterDirectory)
+	}
+
+	runSync(cmd.Context(), logger, vaultProvider, archives, controlServer, syncOptions{
+		supplier:          supplier,
+		selectedSuppliers: selectedSuppliers,
+		noUpload:          noUpload,
+		autoApprove:       autoApprove,
+		force:             force,
+		listOnly:          listOnly,
+		recordFixture:     recordFixture,
+		recordVideo:       recordVideo,
+		devToolsPort:      devToolsPort,
+	})
+}
+
+// runSync runs the recipes of opts.supplier (or of opts.selectedSuppliers, or of all suppliers) with the sync user interface.
+// The vault items need to be loaded before. The run is cancelled when ctx is done or the user quits the interface.
+// With opts.listOnly, the documents offered by the suppliers are printed instead of being downloaded.
+func runSync(ctx context.Context, logger *slog.Logger, vaultProvider *vault.Provider1Password, archives *archive.Archives, controlServer *control.Server, opts syncOptions) {
 	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
-	recipeParser := parser.NewRecipeParser(logger, buchhalterConfigDirectory, buchhalterDirectory)
+	recipeParser := parser.NewRecipeParser(logger, buchhalterConfigDirectory, viper.GetString("buchhalter_directory"))
 
 	localOICDBChecksum, err := recipeParser.GetChecksumOfLocalOICDB()
 	if err != nil {
@@ -99,281 +261,642 @@ func RunSyncCommand(cmd *cobra.Command, cmdArgs []string) {
 
 	apiHost := viper.GetString("buchhalter_api_host")
 	apiToken := viper.GetString("buchhalter_api_token")
-	buchhalterAPIClient, err := repository.NewBuchhalterAPIClient(logger, apiHost, buchhalterConfigDirectory, apiToken, cliVersion)
+	httpClient := initializeHTTPClient(logger)
+	buchhalterAPIClient, err := repository.NewBuchhalterAPIClient(logger, httpClient, apiHost, buchhalterConfigDirectory, apiToken, cliVersion)
 	if err != nil {
 		logger.Error("Error initializing Buchhalter API client", "error", err)
 		exitMessage := fmt.Sprintf("Error initializing Buchhalter API client: %s", err)
 		exitWithLogo(exitMessage)
 	}
-
-	viewModel := initialModel(logger, vaultProvider, buchhalterAPIClient, recipeParser)
-	p := tea.NewProgram(viewModel)
-
-	// Load vault items/try to connect to vault
-	vaultItems, err := vaultProvider.LoadVaultItems()
+	err = buchhalterAPIClient.SetRepositoryMirrors(viper.GetStringSlice("buchhalter_oicdb_mirrors"))
 	if err != nil {
-		logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
-		exitMessage := fmt.Sprintln(vaultProvider.GetHumanReadableErrorMessage(err))
+		logger.Error("Error initializing Buchhalter API client", "error", err)
+		exitMessage := fmt.Sprintf("Error initializing Buchhalter API client: %s", err)
 		exitWithLogo(exitMessage)
 	}
+	buchhalterAPIClient.SetRepositoryPublicKeys(viper.GetStringSlice("buchhalter_oicdb_public_keys"))
+	buchhalterAPIClient.SetTeamSlug(viper.GetString("buchhalter_api_team_slug"))
 
-	// Check if vault items are available
-	if len(vaultItems) == 0 {
-		// TODO Add link with help article
-		logger.Error("No credential items loaded from vault", "provider", "1Password", "cli_command", vaultConfigBinary, "vault", vaultConfigBase, "tag", vaultConfigTag)
-		exitMessage := fmt.Sprintf("No credential items found in vault '%s' with tag '%s'. Please check your 1password vault items.", vaultConfigBase, vaultConfigTag)
-		exitWithLogo(exitMessage)
+	// `buchhalter status` shows the progress of the run
+	statusFile, err := control.NewStatusFile(logger, filepath.Join(viper.GetString("buchhalter_directory"), control.STATUS_FILE_NAME), false, controlServer.SocketPath())
+	if err != nil {
+		logger.Error("Error writing status file", "error", err)
 	}
-	logger.Info("Credential items loaded from vault", "num_items", len(vaultItems), "provider", "1Password", "cli_command", vaultConfigBinary, "vault", vaultConfigBase, "tag", vaultConfigTag)
-
-	// Run recipes
-	go runRecipes(p, logger, supplier, localOICDBChecksum, localOICDBSchemaChecksum, vaultProvider, documentArchive, recipeParser, buchhalterAPIClient)
+	defer statusFile.Close()
+
+	// The log pane of the view shows the last log entries of the run
+	logs := &logPane{}
+	logger = withLogPane(logger, logs)
+
+	model := initialModel(ctx, logger, vaultProvider, buchhalterAPIClient, recipeParser, controlServer, statusFile, logs)
+	p := newProgram(model)
+
+	// Run recipes. Quitting the interface cancels the run, the recipes still release their resources (e.g. the browser).
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	recipesDone := make(chan struct{})
+	go func() {
+		defer close(recipesDone)
+		deps := syncDeps{
+			logger:              logger,
+			httpClient:          httpClient,
+			vaultProvider:       vaultProvider,
+			archives:            archives,
+			recipeParser:        recipeParser,
+			buchhalterAPIClient: buchhalterAPIClient,
+			controlServer:       controlServer,
+			statusFile:          statusFile,
+		}
+		runRecipes(ctx, p, deps, opts, localOICDBChecksum, localOICDBSchemaChecksum)
+	}()
 
-	if _, err := p.Run(); err != nil {
+	finalModel, err := p.Run()
+	if err != nil {
 		logger.Error("Error running program", "error", err)
 		exitMessage := fmt.Sprintf("Error running program: %s", err)
 		exitWithLogo(exitMessage)
 	}
+
+	cancel()
+	select {
+	case <-recipesDone:
+	case <-time.After(runShutdownTimeout):
+		logger.Warn("Run didn't stop in time after the cancellation", "timeout", runShutdownTimeout)
+	}
+
+	if m, ok := finalModel.(viewModel); ok && opts.listOnly {
+		printListedDocuments(m.listedDocuments)
+	}
 }
 
-func runRecipes(p *tea.Program, logger *slog.Logger, supplier, localOICDBChecksum, localOICDBSchemaChecksum string, vaultProvider *vault.Provider1Password, documentArchive *archive.DocumentArchive, recipeParser *parser.RecipeParser, buchhalterAPIClient *repository.BuchhalterAPIClient) {
-	p.Send(viewMsgStatusUpdate{
-		title:    "Build archive index",
-		hasError: false,
-	})
-	logger.Info("Building document archive index ...")
+// recipeApproved checks if a recipe changed since the user approved it the last time.
+// Changed recipes are only executed after a confirmation of the user (or with --auto-approve).
+// The first version of a recipe is approved implicitly.
+func recipeApproved(p *tea.Program, logger *slog.Logger, recipeApprovalStore *parser.RecipeApprovalStore, recipe *parser.Recipe, autoApprove bool) bool {
+	diff := recipeApprovalStore.Diff(recipe)
+	if diff == nil {
+		if !recipeApprovalStore.IsKnown(recipe.Supplier) {
+			err := recipeApprovalStore.Approve(recipe)
+			if err != nil {
+				logger.Error("Error storing approved recipe", "supplier", recipe.Supplier, "error", err)
+			}
+		}
+		return true
+	}
+
+	logger.Info("Recipe changed since last approval", "supplier", recipe.Supplier, "old_version", diff.OldVersion, "new_version", diff.NewVersion, "num_changes", len(diff.Changes))
+	for _, change := range diff.Changes {
+		logger.Info("Recipe change", "supplier", recipe.Supplier, "change", change)
+	}
+
+	approved := autoApprove
+	if !approved {
+		answer := make(chan bool)
+		p.Send(viewMsgPermissionRequest{
+			title:    i18n.Tf("The recipe for %s changed (%s -> %s):", recipe.Supplier, diff.OldVersion, diff.NewVersion),
+			details:  diff.Changes,
+			question: i18n.T("Run the changed recipe? The full changelog is written to the log file. (y/n)"),
+			answer:   answer,
+		})
+		approved = <-answer
+	}
+	if !approved {
+		logger.Info("Skipping recipe due to denied recipe changes", "supplier", recipe.Supplier)
+		return false
+	}
 
-	err := documentArchive.BuildArchiveIndex()
+	err := recipeApprovalStore.Approve(recipe)
 	if err != nil {
-		logger.Error("Error building document archive index", "error", err)
+		logger.Error("Error storing approved recipe", "supplier", recipe.Supplier, "error", err)
+	}
+	return true
+}
+
+// scriptsAllowed checks if a recipe may execute its custom scripts in the authenticated browser session.
+// Recipes need to declare the script permission and the user has to approve the scripts per supplier.
+// Once the scripts of a recipe change, the user is asked again.
+func scriptsAllowed(p *tea.Program, logger *slog.Logger, permissionStore *parser.PermissionStore, recipe *parser.Recipe) bool {
+	if len(recipe.ScriptSources()) == 0 || permissionStore.ScriptsApproved(recipe) {
+		return true
+	}
+
+	if !recipe.HasPermission(parser.PERMISSION_SCRIPT) {
+		logger.Error("Skipping recipe with scripts but without declared script permission", "supplier", recipe.Supplier)
 		p.Send(viewMsgStatusUpdate{
-			title:      "Building document archive index",
-			hasError:   true,
-			shouldQuit: false,
+			title:    i18n.Tf("Skipping recipe for %s: scripts without declared permission", recipe.Supplier),
+			hasError: false,
 		})
+		return false
+	}
+
+	changedScripts := permissionStore.ChangedScripts(recipe)
+	for _, script := range changedScripts {
+		logger.Info("Script of recipe needs approval", "supplier", recipe.Supplier, "script", script)
 	}
 
-	// Check for OICDB schema updates
-	p.Send(viewMsgStatusUpdate{
-		title:    "Checking for OICDB schema updates ...",
-		hasError: false,
+	answer := make(chan bool)
+	p.Send(viewMsgPermissionRequest{
+		title:    i18n.Tf("The recipe for %s wants to run %d new or changed script(s) in your logged in session:", recipe.Supplier, len(changedScripts)),
+		details:  changedScripts,
+		question: i18n.T("Allow these scripts? The full sources are written to the log file. (y/n)"),
+		answer:   answer,
 	})
-	logger.Info("Checking for OICDB schema updates ...", "local_checksum", localOICDBSchemaChecksum)
+	allowed := <-answer
 
-	err = buchhalterAPIClient.UpdateOpenInvoiceCollectorDBSchemaIfAvailable(localOICDBSchemaChecksum)
+	err := permissionStore.StoreScriptDecision(recipe, allowed)
 	if err != nil {
-		logger.Error("Error checking for OICDB schema updates", "error", err)
-		p.Send(viewMsgStatusUpdate{
-			title:      "Checking for OICDB schema updates",
-			hasError:   true,
-			shouldQuit: false,
+		logger.Error("Error storing script permission decision", "supplier", recipe.Supplier, "error", err)
+	}
+	if !allowed {
+		logger.Info("Skipping recipe due to denied script permission", "supplier", recipe.Supplier)
+	}
+
+	return allowed
+}
+
+// minimalScopeCatalogue returns the built-in minimal OAuth2 scopes per supplier, extended by `buchhalter_minimal_scopes`.
+func minimalScopeCatalogue() map[string][]string {
+	configuredScopes := map[string][]string{}
+	err := viper.UnmarshalKey("buchhalter_minimal_scopes", &configuredScopes)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error in setting buchhalter_minimal_scopes: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	minimalScopes := make(map[string][]string, len(parser.MinimalScopes)+len(configuredScopes))
+	for supplier, scopes := range parser.MinimalScopes {
+		minimalScopes[supplier] = scopes
+	}
+	for supplier, scopes := range configuredScopes {
+		minimalScopes[supplier] = scopes
+	}
+	return minimalScopes
+}
+
+// checkRecipeScopes applies the configured OAuth2 scope override of a recipe and warns
+// if the recipe requests more access to the supplier account than needed to download documents.
+func checkRecipeScopes(p *tea.Program, logger *slog.Logger, recipe *parser.Recipe, minimalScopes map[string][]string, oauth2ScopeOverrides map[string]string) {
+	if scope, ok := oauth2ScopeOverrides[recipe.Supplier]; ok {
+		logger.Info("Overriding OAuth2 scopes of recipe", "supplier", recipe.Supplier, "recipe_scopes", recipe.Oauth2Scopes(), "scope", scope)
+		recipe.OverrideOauth2Scope(scope)
+	}
+
+	for _, warning := range recipe.ScopeWarnings(minimalScopes) {
+		logger.Warn("Recipe requests more access than needed", "supplier", recipe.Supplier, "warning", warning)
+		p.Send(viewMsgRecipeDownloadResultMsg{
+			step: fmt.Sprintf("! The recipe for %s %s", recipe.Supplier, warning),
 		})
 	}
+}
 
-	developmentMode := viper.GetBool("dev")
-	if !developmentMode {
-		// Check for OICDB repository updates
-		p.Send(viewMsgStatusUpdate{
-			title:    "Checking for OICDB repository updates ...",
-			hasError: false,
+// pickSuppliers lets the user select the suppliers to sync from all suppliers with a recipe and credentials.
+// The selection is stored in `buchhalter_selected_suppliers` and preselected next time.
+func pickSuppliers(logger *slog.Logger, vaultProvider *vault.Provider1Password, buchhalterConfigDirectory, buchhalterDirectory string) []string {
+	recipes, err := prepareRecipes(logger, "", vaultProvider, parser.NewRecipeParser(logger, buchhalterConfigDirectory, buchhalterDirectory))
+	if err != nil || len(recipes) == 0 {
+		logger.Error("No recipes found for suppliers", "error", err)
+		exitWithLogo(i18n.T("No recipes found for suppliers"))
+	}
+
+	var suppliers []string
+	seen := make(map[string]bool)
+	for _, recipe := range recipes {
+		if !seen[recipe.recipe.Supplier] {
+			seen[recipe.recipe.Supplier] = true
+			suppliers = append(suppliers, recipe.recipe.Supplier)
+		}
+	}
+	sort.Strings(suppliers)
+
+	model, err := newProgram(initialSupplierPickerModel(suppliers, viper.GetStringSlice("buchhalter_selected_suppliers"))).Run()
+	if err != nil {
+		logger.Error("Error running program", "error", err)
+		exitMessage := fmt.Sprintf("Error running program: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	pickerModel := model.(supplierPickerModel)
+	if !pickerModel.confirmed {
+		logger.Info("Supplier selection cancelled")
+		os.Exit(0)
+	}
+
+	selection := pickerModel.Selection()
+	logger.Info("Suppliers selected", "suppliers", selection)
+	err = writeConfigValue("buchhalter_selected_suppliers", selection)
+	if err != nil {
+		logger.Error("Error storing supplier selection", "error", err)
+	}
+
+	return selection
+}
+
+// historySupplierRun converts the result of a supplier recipe into an entry of the run history.
+// saveFixture saves the recorded fixture of a successful recipe run. Fixtures of failed runs are useless for replays.
+func saveFixture(logger *slog.Logger, recorder *fixture.Recorder, fixtureFile string, recipeResult utils.RecipeResult) {
+	if recipeResult.Status != "success" && recipeResult.Status != "warning" {
+		logger.Warn("Recipe failed, not saving fixture", "fixture_file", fixtureFile, "status", recipeResult.Status)
+		return
+	}
+
+	err := recorder.Save(fixtureFile)
+	if err != nil {
+		logger.Error("Error saving fixture", "fixture_file", fixtureFile, "error", err)
+		return
+	}
+	logger.Info("Fixture saved", "fixture_file", fixtureFile)
+}
+
+func historySupplierRun(rdx repository.RunDataSupplier, recipeResult utils.RecipeResult) history.SupplierRun {
+	supplierRun := history.SupplierRun{
+		Supplier: rdx.Supplier,
+		Version:  rdx.Version,
+		Status:   recipeResult.Status,
+		Duration: rdx.Duration,
+		NewFiles: rdx.NewFilesCount,
+	}
+	for _, stepTiming := range recipeResult.StepTimings {
+		supplierRun.Steps = append(supplierRun.Steps, history.StepRun{
+			Number:        stepTiming.Number,
+			Action:        stepTiming.Action,
+			Description:   stepTiming.Description,
+			Status:        stepTiming.Status,
+			Duration:      stepTiming.Duration.Seconds(),
+			StepArtifacts: stepTiming.Artifacts,
 		})
-		logger.Info("Checking for OICDB repository updates ...", "local_checksum", localOICDBChecksum)
+	}
+	return supplierRun
+}
 
-		err = buchhalterAPIClient.UpdateOpenInvoiceCollectorDBIfAvailable(localOICDBChecksum)
+// commitArchives commits the new documents and index updates of all (writable) archives to their git repositories.
+func commitArchives(p *tea.Program, logger *slog.Logger, archives *archive.Archives, historyRun history.Run) {
+	newFilesCount := 0
+	for _, supplierRun := range historyRun.Suppliers {
+		newFilesCount += supplierRun.NewFiles
+	}
+	message := fmt.Sprintf("Sync of %s: %d new documents", historyRun.StartedAt.Format(time.DateTime), newFilesCount)
+
+	for _, name := range archives.Names() {
+		documentArchive, _ := archives.Get(name)
+		if documentArchive.IsReadOnly() {
+			continue
+		}
+
+		gitArchive := archive.NewGitArchive(logger, documentArchive.Directory(), viper.GetBool("archive.git.lfs"))
+		err := gitArchive.Init()
+		if err == nil {
+			var committed bool
+			committed, err = gitArchive.Commit(message)
+			logger.Info("Committing documents to git ... completed", "archive", name, "committed", committed)
+		}
 		if err != nil {
-			logger.Error("Error checking for OICDB repository updates", "error", err)
+			logger.Error("Error committing documents to git", "archive", name, "error", err)
 			p.Send(viewMsgStatusUpdate{
-				title:      "Checking for OICDB repository updates",
+				title:      i18n.Tf("Committing documents of archive %s to git: %s", name, err),
 				hasError:   true,
 				shouldQuit: false,
 			})
 		}
 	}
+}
 
-	recipesToExecute, err := prepareRecipes(logger, supplier, vaultProvider, recipeParser)
-	// No credentials found for supplier/recipes
-	if len(recipesToExecute) == 0 || err != nil {
-		logger.Error("No recipes found for suppliers", "supplier", supplier, "error", err)
+// convertToPdfA stores PDF/A copies of the PDF documents added in this run next to the originals.
+func convertToPdfA(p *tea.Program, logger *slog.Logger, archives *archive.Archives, historyRun history.Run) {
+	converter, err := pdf.NewPdfAConverter(viper.GetString("buchhalter_ghostscript_path"), viper.GetInt("buchhalter_pdfa_level"))
+	if err != nil {
+		logger.Error("Error converting documents to PDF/A", "error", err)
 		p.Send(viewMsgStatusUpdate{
-			title:      "No recipes found for suppliers",
+			title:      i18n.Tf("Converting documents to PDF/A: %s", err),
 			hasError:   true,
-			shouldQuit: true,
+			shouldQuit: false,
 		})
 		return
 	}
 
-	var t string
-	recipeCount := len(recipesToExecute)
-	if recipeCount == 1 {
-		t = fmt.Sprintf("Running one recipe for supplier %s ...", recipesToExecute[0].recipe.Supplier)
-		logger.Info("Running one recipe ...", "supplier", recipesToExecute[0].recipe.Supplier)
-	} else {
-		t = fmt.Sprintf("Running recipes for %d suppliers ...", recipeCount)
-		logger.Info("Running recipes for multiple suppliers ...", "num_suppliers", recipeCount)
-	}
-	p.Send(viewMsgStatusUpdate{
-		title:    t,
-		hasError: false,
-	})
-	p.Send(viewMsgProgressUpdate{Percent: 0.001})
-
-	buchhalterDocumentsDirectory := viper.GetString("buchhalter_documents_directory")
-	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
-	buchhalterMaxDownloadFilesPerReceipt := viper.GetInt("buchhalter_max_download_files_per_receipt")
-
-	totalStepCount := 0
-	stepCountInCurrentRecipe := 0
-	baseCountStep := 0
-	var recipeResult utils.RecipeResult
-	for i := range recipesToExecute {
-		totalStepCount += len(recipesToExecute[i].recipe.Steps)
-	}
-	for i := range recipesToExecute {
-		startTime := time.Now()
-		stepCountInCurrentRecipe = len(recipesToExecute[i].recipe.Steps)
-		p.Send(viewMsgStatusUpdate{
-			title:    "Downloading invoices from " + recipesToExecute[i].recipe.Supplier + ":",
-			hasError: false,
-		})
-
-		// Load username, password, totp from vault
-		logger.Info("Requesting credentials from vault", "supplier", recipesToExecute[i].recipe.Supplier)
-		recipeCredentials, err := vaultProvider.GetCredentialsByItemId(recipesToExecute[i].vaultItemId)
-		if err != nil {
-			// TODO Implement better error handling
-			logger.Error(vaultProvider.GetHumanReadableErrorMessage(err))
-			fmt.Println(vaultProvider.GetHumanReadableErrorMessage(err))
+	for _, name := range archives.Names() {
+		documentArchive, _ := archives.Get(name)
+		if documentArchive.IsReadOnly() {
 			continue
 		}
 
-		logger.Info("Downloading invoices ...", "supplier", recipesToExecute[i].recipe.Supplier, "supplier_type", recipesToExecute[i].recipe.Type)
-		switch recipesToExecute[i].recipe.Type {
-		case "browser":
-			browserDriver := browser.NewBrowserDriver(logger, recipeCredentials, buchhalterDocumentsDirectory, documentArchive, buchhalterMaxDownloadFilesPerReceipt)
-			recipeResult = browserDriver.RunRecipe(p, totalStepCount, stepCountInCurrentRecipe, baseCountStep, recipesToExecute[i].recipe)
-			if ChromeVersion == "" {
-				ChromeVersion = browserDriver.ChromeVersion
+		// Collect the documents first, the copies are added to the index
+		var files []archive.File
+		for _, file := range documentArchive.GetFileIndex() {
+			if file.Rejected || file.AddedAt.Before(historyRun.StartedAt) || !strings.EqualFold(filepath.Ext(file.Path), ".pdf") || pdf.IsPdfAFileName(file.Path) {
+				continue
 			}
-			// TODO Should we quit it here or inside RunRecipe?
-			err = browserDriver.Quit()
-			if err != nil {
-				// TODO Implement better error handling
-				fmt.Println(err)
+			files = append(files, file)
+		}
+
+		converted := 0
+		for _, file := range files {
+			dest := pdf.PdfAFileName(file.Path)
+			if _, err := os.Stat(dest); err == nil {
+				continue
 			}
-		case "client":
-			clientDriver := browser.NewClientAuthBrowserDriver(logger, recipeCredentials, buchhalterConfigDirectory, buchhalterDocumentsDirectory, documentArchive)
-			recipeResult = clientDriver.RunRecipe(p, totalStepCount, stepCountInCurrentRecipe, baseCountStep, recipesToExecute[i].recipe)
-			if ChromeVersion == "" {
-				ChromeVersion = clientDriver.ChromeVersion
+
+			err := converter.Convert(file.Path, dest)
+			if err == nil {
+				err = documentArchive.AddFile(dest, file.Supplier)
 			}
-			// TODO Should we quit it here or inside RunRecipe?
-			err = clientDriver.Quit()
 			if err != nil {
-				// TODO Implement better error handling
-				fmt.Println(err)
+				logger.Error("Error converting document to PDF/A", "archive", name, "file", file.Path, "error", err)
+				p.Send(viewMsgStatusUpdate{
+					title:      i18n.Tf("Converting %s to PDF/A: %s", filepath.Base(file.Path), err),
+					hasError:   true,
+					shouldQuit: false,
+				})
+				continue
 			}
+			converted++
 		}
-		rdx := repository.RunDataSupplier{
-			Supplier:         recipesToExecute[i].recipe.Supplier,
-			Version:          recipesToExecute[i].recipe.Version,
-			Status:           recipeResult.StatusText,
-			LastErrorMessage: recipeResult.LastErrorMessage,
-			Duration:         time.Since(startTime).Seconds(),
-			NewFilesCount:    recipeResult.NewFilesCount,
-		}
-		RunData = append(RunData, rdx)
-		// TODO Check for recipeResult.LastErrorMessage
-		p.Send(viewMsgRecipeDownloadResultMsg{
-			duration:      time.Since(startTime),
-			newFilesCount: recipeResult.NewFilesCount,
-			step:          recipeResult.StatusTextFormatted,
-			errorMessage:  recipeResult.LastErrorMessage,
-		})
-		logger.Info("Downloading invoices ... completed", "supplier", recipesToExecute[i].recipe.Supplier, "supplier_type", recipesToExecute[i].recipe.Type, "duration", time.Since(startTime), "new_files", recipeResult.NewFilesCount)
-
-		baseCountStep += stepCountInCurrentRecipe
+		logger.Info("Converting documents to PDF/A ... completed", "archive", name, "converted", converted)
 	}
+}
 
-	// If we have a premium user run, upload the documents to the buchhalter API
-	logger.Info("Checking if we have a premium subscription to Buchhalter API ...")
-	user, err := buchhalterAPIClient.GetAuthenticatedUser()
+// timestampDocuments stores RFC 3161 timestamp tokens of all documents that don't have one yet next to the documents.
+func timestampDocuments(ctx context.Context, p *tea.Program, logger *slog.Logger, httpClient *httpclient.Client, tsaURL string, archives *archive.Archives) {
+	tsaClient, err := timestamp.NewClient(logger, httpClient, tsaURL)
+	if err == nil {
+		for _, name := range archives.Names() {
+			documentArchive, _ := archives.Get(name)
+			if documentArchive.IsReadOnly() {
+				continue
+			}
+			var timestamped int
+			timestamped, err = tsaClient.TimestampAll(ctx, documentArchive.GetFileIndex())
+			logger.Info("Timestamping documents ... completed", "archive", name, "timestamped", timestamped)
+			if err != nil {
+				break
+			}
+		}
+	}
 	if err != nil {
-		logger.Error("Error retrieving authenticated user", "error", err)
+		logger.Error("Error timestamping documents", "error", err)
 		p.Send(viewMsgStatusUpdate{
-			title:      "Retrieving authenticated user",
+			title:      i18n.Tf("Timestamping documents: %s", httpclient.GetHumanReadableErrorMessage(err)),
 			hasError:   true,
 			shouldQuit: false,
 		})
 	}
-	if user != nil && len(user.User.ID) > 0 {
-		uiDocumentUploadMessage := "Uploading documents to Buchhalter API ..."
-		if len(supplier) > 0 {
-			uiDocumentUploadMessage = fmt.Sprintf("Uploading documents of supplier %s to Buchhalter API ...", supplier)
+}
+
+// writeManifests writes the manifest of the run into every archive documents of the run are routed to.
+func writeManifests(p *tea.Program, logger *slog.Logger, archives *archive.Archives, recipeParser *parser.RecipeParser, historyRun history.Run) {
+	// The database may have been updated since the start of the run
+	oicdbChecksum, err := recipeParser.GetChecksumOfLocalOICDB()
+	if err != nil {
+		logger.Error("Error calculating checksum of local OICDB", "error", err)
+	}
+
+	recipesByArchive := map[string][]archive.ManifestRecipe{}
+	for _, supplierRun := range historyRun.Suppliers {
+		manifestRecipe := archive.ManifestRecipe{
+			Supplier: supplierRun.Supplier,
+			Version:  supplierRun.Version,
+			Status:   supplierRun.Status,
 		}
+		if recipe := recipeParser.GetRecipeBySupplier(supplierRun.Supplier); recipe != nil {
+			manifestRecipe.Checksum = recipe.Checksum()
+		}
+		name := archives.NameOfSupplier(supplierRun.Supplier)
+		recipesByArchive[name] = append(recipesByArchive[name], manifestRecipe)
+	}
+
+	for _, name := range archives.Names() {
+		if len(recipesByArchive[name]) == 0 {
+			continue
+		}
+		documentArchive, _ := archives.Get(name)
+		manifestFile, err := documentArchive.WriteManifest(archive.Manifest{
+			StartedAt:     historyRun.StartedAt,
+			CliVersion:    cliVersion,
+			ChromeVersion: ChromeVersion,
+			OicdbVersion:  recipeParser.OicdbVersion,
+			OicdbChecksum: oicdbChecksum,
+			Recipes:       recipesByArchive[name],
+		})
+		if err != nil {
+			logger.Error("Error writing run manifest", "archive", name, "error", err)
+			p.Send(viewMsgStatusUpdate{
+				title:      i18n.Tf("Writing run manifest: %s", err),
+				hasError:   true,
+				shouldQuit: false,
+			})
+			continue
+		}
+		logger.Info("Run manifest written", "archive", name, "manifest", manifestFile)
+	}
+}
+
+// pushToPaperless pushes the documents of all archives that haven't been pushed before to Paperless-ngx.
+func pushToPaperless(ctx context.Context, p *tea.Program, logger *slog.Logger, httpClient *httpclient.Client, archives *archive.Archives) {
+	paperlessToken := viper.GetString("buchhalter_paperless_token")
+	redact.AddSecrets(paperlessToken)
+	paperlessClient, err := paperless.NewClient(logger, httpClient, viper.GetString("buchhalter_paperless_host"), paperlessToken)
+	if err == nil {
+		consumer := paperless.NewConsumer(logger, paperlessClient, viper.GetString("buchhalter_config_directory"))
+		var pushed int
+		pushed, err = consumer.Push(ctx, archives.GetFileIndex())
+		logger.Info("Pushing documents to Paperless-ngx ... completed", "pushed", pushed)
+	}
+	if err != nil {
+		logger.Error("Error pushing documents to Paperless-ngx", "error", err)
 		p.Send(viewMsgStatusUpdate{
-			title:    uiDocumentUploadMessage,
-			hasError: false,
+			title:      i18n.Tf("Pushing documents to Paperless-ngx: %s", httpclient.GetHumanReadableErrorMessage(err)),
+			hasError:   true,
+			shouldQuit: false,
 		})
-		fileIndex := documentArchive.GetFileIndex()
-		for fileChecksum, fileInfo := range fileIndex {
-			// If the user is only working on a specific supplier, skip the upload of documents for other suppliers
-			if len(supplier) > 0 && fileInfo.Supplier != supplier {
-				logger.Info("Skipping document upload to Buchhalter API due to mismatch in supplier", "file", fileInfo.Path, "selected_supplier", supplier, "file_supplier", fileInfo.Supplier)
-				continue
-			}
+	}
+}
 
-			logger.Info("Uploading document to Buchhalter API ...", "file", fileInfo.Path, "checksum", fileChecksum)
-			result, err := buchhalterAPIClient.DoesDocumentExist(fileChecksum)
-			if err != nil {
-				// TODO Implement better error handling
-				logger.Error("Error checking if document exists already in Buchhalter API", "file", fileInfo.Path, "checksum", fileChecksum, "error", err)
+// sendWebhookEvents sends a `document.created` event for every document added during the run and a `run.completed` event.
+func sendWebhookEvents(ctx context.Context, p *tea.Program, logger *slog.Logger, httpClient *httpclient.Client, webhookURL string, archives *archive.Archives, historyRun history.Run) {
+	webhookSecret := viper.GetString("buchhalter_webhook_secret")
+	redact.AddSecrets(webhookSecret)
+	sender := webhook.NewEventSender(logger, httpClient, webhookURL, webhookSecret, viper.GetStringSlice("buchhalter_webhook_events"))
+
+	var events []webhook.Event
+	for _, name := range archives.Names() {
+		documentArchive, _ := archives.Get(name)
+		for checksum, file := range documentArchive.GetFileIndex() {
+			if file.AddedAt.Before(historyRun.StartedAt) || file.Rejected {
 				continue
 			}
-			// If the file exists already, skip it
-			if result {
-				logger.Info("Uploading document to Buchhalter API ... exists already", "file", fileInfo.Path, "checksum", fileChecksum)
-				continue
+			tags := file.Tags
+			if tags == nil {
+				tags = []string{}
 			}
-			logger.Info("Uploading document to Buchhalter API ... does not exist already", "file", fileInfo.Path, "checksum", fileChecksum)
+			events = append(events, webhook.NewEvent(webhook.EVENT_DOCUMENT_CREATED, webhook.DocumentCreatedData{
+				Checksum: checksum,
+				FileName: filepath.Base(file.Path),
+				Supplier: file.Supplier,
+				Archive:  name,
+				Tags:     tags,
+				AddedAt:  file.AddedAt,
+			}))
+		}
+	}
 
-			err = buchhalterAPIClient.UploadDocument(fileInfo.Path, fileInfo.Supplier)
-			if err != nil {
-				// TODO Implement better error handling
-				logger.Error("Error uploading document to Buchhalter API", "file", fileInfo.Path, "supplier", fileInfo.Supplier, "error", err)
-				continue
-			}
+	errorMessages := map[string]string{}
+	for _, rdx := range RunData {
+		errorMessages[rdx.Supplier] = rdx.LastErrorMessage
+	}
+	runCompleted := webhook.RunCompletedData{
+		StartedAt:       historyRun.StartedAt,
+		DurationSeconds: historyRun.Duration,
+		Suppliers:       []webhook.RunCompletedSupplier{},
+	}
+	for _, supplierRun := range historyRun.Suppliers {
+		runCompleted.NewDocuments += supplierRun.NewFiles
+		if supplierRun.Status == "error" {
+			runCompleted.FailedSuppliers++
 		}
-	} else {
-		logger.Info("Skipping document upload to Buchhalter API due to missing premium subscription")
+		runCompleted.Suppliers = append(runCompleted.Suppliers, webhook.RunCompletedSupplier{
+			Supplier:     supplierRun.Supplier,
+			Status:       supplierRun.Status,
+			NewDocuments: supplierRun.NewFiles,
+			ErrorMessage: errorMessages[supplierRun.Supplier],
+		})
 	}
+	events = append(events, webhook.NewEvent(webhook.EVENT_RUN_COMPLETED, runCompleted))
 
-	alwaysSendMetrics := viper.GetBool("buchhalter_always_send_metrics")
-	if !developmentMode && alwaysSendMetrics {
-		logger.Info("Sending usage metrics to Buchhalter API", "always_send_metrics", alwaysSendMetrics, "development_mode", developmentMode)
-		err = buchhalterAPIClient.SendMetrics(RunData, cliVersion, ChromeVersion, vaultProvider.Version, recipeParser.OicdbVersion)
+	for _, event := range events {
+		err := sender.Send(ctx, event)
 		if err != nil {
-			logger.Error("Error sending usage metrics to Buchhalter API", "error", err)
+			logger.Error("Error sending webhook event", "type", event.Type, "id", event.ID, "error", err)
 			p.Send(viewMsgStatusUpdate{
-				title:      "Sending usage metrics to Buchhalter API",
+				title:      i18n.Tf("Sending webhook events: %s", httpclient.GetHumanReadableErrorMessage(err)),
 				hasError:   true,
 				shouldQuit: false,
 			})
+			return
 		}
+	}
+}
 
-		p.Send(viewMsgQuit{})
+// checkDocumentAnomalies warns about suppliers without new documents for longer than their configured cadence
+// or with far more new documents than usual, both hint to a silently broken recipe.
+func checkDocumentAnomalies(p *tea.Program, logger *slog.Logger, runHistory *history.RunHistory, archives *archive.Archives) {
+	cadences := configuredCadences(logger)
+	lastDocumentAt := map[string]time.Time{}
+	for _, file := range archives.GetFileIndex() {
+		if file.AddedAt.After(lastDocumentAt[file.Supplier]) {
+			lastDocumentAt[file.Supplier] = file.AddedAt
+		}
+	}
 
-	} else if developmentMode {
-		p.Send(viewMsgQuit{})
+	runs, err := runHistory.Runs()
+	if err != nil {
+		logger.Error("Error reading run history", "error", err)
+		return
+	}
+	for _, anomaly := range history.DetectAnomalies(runs, lastDocumentAt, cadences, time.Now()) {
+		logger.Warn("Document anomaly detected", "supplier", anomaly.Supplier, "kind", anomaly.Kind, "message", anomaly.Message)
+		p.Send(viewMsgRecipeDownloadResultMsg{
+			step: "! " + anomaly.Message + ". Please check the recipe.",
+		})
+	}
+}
 
-	} else {
-		p.Send(viewMsgModeUpdate{
-			mode:    "sendMetrics",
-			title:   "Let's improve buchhalter-cli together!",
-			details: "Allow buchhalter-cli to send anonymized usage data to our api?",
+// configuredCadences returns the expected time between two documents per supplier of buchhalter_supplier_cadence.
+// Invalid cadences are logged and skipped.
+func configuredCadences(logger *slog.Logger) map[string]time.Duration {
+	cadences := map[string]time.Duration{}
+	for supplier, value := range viper.GetStringMapString("buchhalter_supplier_cadence") {
+		cadence, err := history.ParseCadence(value)
+		if err != nil {
+			logger.Error("Error in setting buchhalter_supplier_cadence", "supplier", supplier, "error", err)
+			continue
+		}
+		cadences[supplier] = cadence
+	}
+	return cadences
+}
+
+// showSupplierAdvisories shows the known issues of the recipe announced by the Buchhalter Platform.
+func showSupplierAdvisories(p *tea.Program, logger *slog.Logger, recipe *parser.Recipe, advisories []repository.SupplierAdvisory) {
+	for _, advisory := range advisories {
+		if !advisory.Affects(recipe.Version) {
+			continue
+		}
+		logger.Warn("Supplier advisory", "supplier", recipe.Supplier, "recipe_version", recipe.Version, "severity", advisory.Severity, "since", advisory.Since, "message", advisory.Message)
+		message := fmt.Sprintf("! Known issue with %s: %s", recipe.Supplier, advisory.Message)
+		if advisory.Since != "" {
+			message = fmt.Sprintf("! Known issue with %s since %s: %s", recipe.Supplier, advisory.Since, advisory.Message)
+		}
+		p.Send(viewMsgRecipeDownloadResultMsg{
+			step: message,
 		})
 	}
 }
 
+// filterRecipes returns only the recipes of the given suppliers.
+func filterRecipes(recipes []recipeToExecute, suppliers []string) []recipeToExecute {
+	var filtered []recipeToExecute
+	for _, recipe := range recipes {
+		if containsString(suppliers, recipe.recipe.Supplier) {
+			filtered = append(filtered, recipe)
+		}
+	}
+	return filtered
+}
+
+// orderRecipes sorts recipes by their dependencies and priorities. On dependency cycles, the order is kept.
+func orderRecipes(p *tea.Program, logger *slog.Logger, recipes []recipeToExecute) []recipeToExecute {
+	parserRecipes := make([]*parser.Recipe, len(recipes))
+	for i := range recipes {
+		parserRecipes[i] = recipes[i].recipe
+	}
+	order, err := parser.ExecutionOrder(parserRecipes)
+	if err != nil {
+		logger.Error("Error ordering recipes", "error", err)
+		p.Send(viewMsgStatusUpdate{
+			title:      i18n.Tf("Ordering recipes: %s", err),
+			hasError:   true,
+			shouldQuit: false,
+		})
+		return recipes
+	}
+
+	ordered := make([]recipeToExecute, len(recipes))
+	for i, index := range order {
+		ordered[i] = recipes[index]
+	}
+	return ordered
+}
+
+// syncedInPeriod checks if the supplier of recipe was synced successfully within its frequency (weekly or monthly).
+// The frequency of buchhalter_supplier_frequency overrides the one of the recipe.
+func syncedInPeriod(logger *slog.Logger, recipe *parser.Recipe, lastSyncs map[string]time.Time) bool {
+	frequency := recipe.Frequency
+	if configured, ok := viper.GetStringMapString("buchhalter_supplier_frequency")[recipe.Supplier]; ok {
+		frequency = configured
+	}
+	if frequency == "" {
+		return false
+	}
+
+	synced, err := history.SyncedInPeriod(frequency, lastSyncs[recipe.Supplier], time.Now())
+	if err != nil {
+		logger.Error("Error in frequency of supplier", "supplier", recipe.Supplier, "error", err)
+		return false
+	}
+	if synced {
+		logger.Info("Skipping recipe, supplier already synced in this period", "supplier", recipe.Supplier, "frequency", frequency, "last_sync", lastSyncs[recipe.Supplier])
+	}
+	return synced
+}
+
+// failedDependency returns the first supplier of the run recipe depends on that didn't complete.
+func failedDependency(recipe *parser.Recipe, suppliersOfRun []string, completedSuppliers map[string]bool) string {
+	for _, dependency := range recipe.DependsOn {
+		if dependency != recipe.Supplier && containsString(suppliersOfRun, dependency) && !completedSuppliers[dependency] {
+			return dependency
+		}
+	}
+	return ""
+}
+
 func prepareRecipes(logger *slog.Logger, supplier string, vaultProvider *vault.Provider1Password, recipeParser *parser.RecipeParser) ([]recipeToExecute, error) {
 	var r []recipeToExecute
 
@@ -417,17 +940,16 @@ func prepareRecipes(logger *slog.Logger, supplier string, vaultProvider *vault.P
 	return r, nil
 }
 
-func sendMetrics(buchhalterAPIClient *repository.BuchhalterAPIClient, a bool, vaultVersion, oicdbVersion string) {
+func sendMetrics(ctx context.Context, buchhalterAPIClient *repository.BuchhalterAPIClient, a bool, vaultVersion, oicdbVersion string) {
 	// TODO Add logging for sendMetrics
 
-	err := buchhalterAPIClient.SendMetrics(RunData, cliVersion, ChromeVersion, vaultVersion, oicdbVersion)
+	err := sendRunMetrics(ctx, buchhalterAPIClient, currentRunMetrics(vaultVersion, oicdbVersion))
 	if err != nil {
 		// TODO Implement better error handling
 		fmt.Println(err)
 	}
 	if a {
-		viper.Set("buchhalter_always_send_metrics", true)
-		err = viper.WriteConfig()
+		err = writeConfigValue("buchhalter_always_send_metrics", true)
 		if err != nil {
 			// TODO Implement better error handling
 			fmt.Println(err)
@@ -444,13 +966,8 @@ const (
 )
 
 var (
-	helpStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("#D6D58E")).Margin(1, 0)
-	dotStyle      = helpStyle.UnsetMargins()
-	errorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#EA4335"))
-	durationStyle = dotStyle
-	spinnerStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#D6D58E"))
-	appStyle      = lipgloss.NewStyle().Margin(1, 2, 0, 2)
-	choices       = []string{"Yes", "No", "Always yes (don't ask again)"}
+	appStyle = lipgloss.NewStyle().Margin(1, 2, 0, 2)
+	choices  = []string{"Yes", "No", "Always yes (don't ask again)"}
 )
 
 // viewModel is the bubbletea application main viewModel (view)
@@ -464,11 +981,25 @@ type viewModel struct {
 	progress      progress.Model
 	spinner       spinner.Model
 	results       []viewMsgRecipeDownloadResultMsg
+	panes         supplierPanes
+	logs          *logPane
+	showLogs      bool
 	quitting      bool
 	hasError      bool
 	cursor        int
 	choice        string
 
+	permissionQuestion string
+	permissionAnswer   chan bool
+
+	// listedDocuments are the documents offered by the suppliers of list-only runs
+	listedDocuments []viewMsgDocumentsListed
+
+	controlServer *control.Server
+	statusFile    *control.StatusFile
+
+	// ctx is the context of the command, it cancels requests of the view (e.g. sending the usage metrics)
+	ctx                 context.Context
 	vaultProvider       *vault.Provider1Password
 	buchhalterAPIClient *repository.BuchhalterAPIClient
 	recipeParser        *parser.RecipeParser
@@ -479,24 +1010,29 @@ type viewModel struct {
 type viewMsgQuit struct{}
 
 // viewMsgRecipeDownloadResultMsg registers a recipe download result in the bubbletea application.
+// Results of a supplier are shown in its pane, all others (e.g. warnings) in the list of results.
 type viewMsgRecipeDownloadResultMsg struct {
+	supplier      string
+	status        string
 	duration      time.Duration
 	step          string
 	errorMessage  string
 	newFilesCount int
+	// summary is shown in the pane of the supplier, e.g. the number of listed documents (optional)
+	summary string
 }
 
 func (r viewMsgRecipeDownloadResultMsg) String() string {
 	s := len(r.step)
 	if r.duration == 0 {
 		if r.step != "" {
-			r.step = r.step + " " + strings.Repeat(".", maxWidth-1-s)
+			r.step = r.step + " " + strings.Repeat(".", max(0, maxWidth-1-s))
 			return r.step
 		}
 		return dotStyle.Render(strings.Repeat(".", maxWidth))
 	}
 	d := r.duration.Round(time.Second).String()
-	fill := strings.Repeat(".", maxWidth-1-s-(len(d)-8))
+	fill := strings.Repeat(".", max(0, maxWidth-1-s-(len(d)-8)))
 	return fmt.Sprintf("%s %s%s", r.step, fill, durationStyle.Render(d))
 }
 
@@ -520,6 +1056,15 @@ type viewMsgStatusUpdate struct {
 	shouldQuit bool
 }
 
+// viewMsgPermissionRequest asks the user a yes/no question about a supplier recipe (e.g. to allow changed scripts).
+// The decision of the user is sent back on the answer channel.
+type viewMsgPermissionRequest struct {
+	title    string
+	details  []string
+	question string
+	answer   chan bool
+}
+
 // viewMsgProgressUpdate updates the progress bar in the bubbletea application.
 // "Percent" represents the percentage of the progress bar.
 type viewMsgProgressUpdate struct {
@@ -529,27 +1074,31 @@ type viewMsgProgressUpdate struct {
 type tickMsg time.Time
 
 // initialModel returns the model for the bubbletea application.
-func initialModel(logger *slog.Logger, vaultProvider *vault.Provider1Password, buchhalterAPIClient *repository.BuchhalterAPIClient, recipeParser *parser.RecipeParser) viewModel {
+func initialModel(ctx context.Context, logger *slog.Logger, vaultProvider *vault.Provider1Password, buchhalterAPIClient *repository.BuchhalterAPIClient, recipeParser *parser.RecipeParser, controlServer *control.Server, statusFile *control.StatusFile, logs *logPane) viewModel {
 	const numLastResults = 5
 
 	s := spinner.New()
-	s.Spinner = spinner.Dot
+	s.Spinner = symbols.spinner
 	s.Style = spinnerStyle
 
 	m := viewModel{
 		mode:          "sync",
-		currentAction: "Initializing...",
-		details:       "Loading...",
+		currentAction: i18n.T("Initializing..."),
+		details:       i18n.T("Loading..."),
 		showProgress:  true,
-		progress:      progress.New(progress.WithGradient("#9FC131", "#DBF227")),
+		progress:      newProgressBar(),
 		spinner:       s,
 		results:       make([]viewMsgRecipeDownloadResultMsg, numLastResults),
+		logs:          logs,
 		hasError:      false,
 
+		ctx:                 ctx,
 		vaultProvider:       vaultProvider,
 		buchhalterAPIClient: buchhalterAPIClient,
 		recipeParser:        recipeParser,
 		logger:              logger,
+		controlServer:       controlServer,
+		statusFile:          statusFile,
 	}
 
 	return m
@@ -566,9 +1115,43 @@ func (m viewModel) Init() tea.Cmd {
 // Update updates the bubbletea application model.
 // Handles incoming events and updates the model accordingly.
 func (m viewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m.panes.update(msg)
+	if event, ok := controlEvent(msg); ok {
+		m.panes.annotate(&event)
+		m.controlServer.Publish(event)
+		m.statusFile.Publish(event)
+	}
+
 	switch msg := msg.(type) {
 
 	case tea.KeyMsg:
+		if m.mode == "approve" {
+			switch msg.String() {
+			case "y", "n":
+				allowed := msg.String() == "y"
+				m.logger.Info("Permission decision", "question", m.permissionQuestion, "allowed", allowed)
+				m.permissionAnswer <- allowed
+				m.mode = "sync"
+				m.details = ""
+				m.showProgress = true
+				return m, nil
+			}
+		}
+
+		if m.mode == "sync" {
+			switch msg.String() {
+			case "l":
+				m.showLogs = !m.showLogs
+				return m, nil
+			case "up", "k":
+				m.panes.scroll(-1)
+				return m, nil
+			case "down", "j":
+				m.panes.scroll(1)
+				return m, nil
+			}
+		}
+
 		switch msg.String() {
 		case "q", "esc", "ctrl+c":
 			m.logger.Info("Initiating shutdown sequence", "key_hit", msg.String())
@@ -582,7 +1165,7 @@ func (m viewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.mode = "sync"
 			switch m.choice {
 			case "Yes":
-				sendMetrics(m.buchhalterAPIClient, false, m.vaultProvider.Version, m.recipeParser.OicdbVersion)
+				sendMetrics(m.ctx, m.buchhalterAPIClient, false, m.vaultProvider.Version, m.recipeParser.OicdbVersion)
 				mn := quit(m)
 				return mn, tea.Quit
 
@@ -591,7 +1174,7 @@ func (m viewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return mn, tea.Quit
 
 			case "Always yes (don't ask again)":
-				sendMetrics(m.buchhalterAPIClient, true, m.vaultProvider.Version, m.recipeParser.OicdbVersion)
+				sendMetrics(m.ctx, m.buchhalterAPIClient, true, m.vaultProvider.Version, m.recipeParser.OicdbVersion)
 				mn := quit(m)
 				return mn, tea.Quit
 			}
@@ -611,6 +1194,10 @@ func (m viewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		return m, nil
 
+	case viewMsgDocumentsListed:
+		m.listedDocuments = append(m.listedDocuments, msg)
+		return m, nil
+
 	case viewMsgQuit:
 		m.logger.Info("Initiating shutdown sequence")
 
@@ -625,7 +1212,10 @@ func (m viewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case viewMsgRecipeDownloadResultMsg:
-		m.results = append(m.results[1:], msg)
+		// Results of suppliers are shown in their panes
+		if msg.supplier == "" {
+			m.results = append(m.results[1:], msg)
+		}
 		if msg.errorMessage != "" {
 			m.hasError = true
 			m.details = msg.errorMessage
@@ -652,6 +1242,15 @@ func (m viewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.showProgress = false
 		return m, nil
 
+	case viewMsgPermissionRequest:
+		m.mode = "approve"
+		m.currentAction = msg.title
+		m.details = previewLines(msg.details)
+		m.permissionQuestion = msg.question
+		m.permissionAnswer = msg.answer
+		m.showProgress = false
+		return m, nil
+
 	case viewMsgProgressUpdate:
 		cmd := m.progress.SetPercent(msg.Percent)
 		return m, cmd
@@ -693,53 +1292,122 @@ func (m viewModel) View() string {
 	s = fmt.Sprintf(
 		"%s\n%s\n%s%s\n%s\n",
 		headerStyle(LogoText),
-		textStyle("Automatically sync all your incoming invoices from your suppliers. "),
-		textStyle("More information at: "),
+		textStyle(i18n.T("Automatically sync all your incoming invoices from your suppliers. ")),
+		textStyle(i18n.T("More information at: ")),
 		textStyleBold("https://buchhalter.ai"),
-		textStyleGrayBold(fmt.Sprintf("Using OICDB %s and CLI %s", m.recipeParser.OicdbVersion, cliVersion)),
+		textStyleGrayBold(i18n.Tf("Using OICDB %s and CLI %s", m.recipeParser.OicdbVersion, cliVersion)),
 	) + "\n"
 
 	if !m.hasError {
 		s += m.spinner.View() + m.currentAction
 		s += helpStyle.Render("  " + m.details)
 	} else {
-		s += errorStyle.Render("ERROR: " + m.currentAction)
+		s += errorStyle.Render(i18n.T("ERROR: ") + m.currentAction)
 		s += helpStyle.Render("  " + m.details)
 	}
 
 	s += "\n"
 
 	if m.showProgress {
-		s += m.progress.View() + "\n\n"
+		s += m.progress.View()
+		if remaining, ok := m.panes.remaining(time.Now()); ok && m.mode == "sync" {
+			s += "  " + durationStyle.Render(i18n.Tf("ETA %s (%s)", formatEstimate(remaining), time.Now().Add(remaining).Format("15:04")))
+		}
+		s += "\n\n"
+	}
+
+	if m.mode == "sync" {
+		s += m.panes.View(m.spinner.View())
 	}
 
 	if !m.hasError && m.mode == "sync" {
 		for _, res := range m.results {
-			s += res.String() + "\n"
+			if res.step != "" {
+				s += res.String() + "\n"
+			}
 		}
 	}
 
+	if m.showLogs && m.mode == "sync" {
+		s += "\n" + textStyleGrayBold("Log") + "\n" + m.logs.View()
+	}
+
 	if m.mode == "sendMetrics" && !m.quitting {
 		for i := 0; i < len(choices); i++ {
 			if m.cursor == i {
-				s += "(•) "
+				s += "(" + symbols.selected + ") "
 			} else {
 				s += "( ) "
 			}
-			s += choices[i]
+			s += i18n.T(choices[i])
 			s += "\n"
 		}
 	}
 
+	if m.mode == "approve" && !m.quitting {
+		s += m.permissionQuestion + "\n"
+	}
+
 	// Quitting or not?
 	if !m.quitting {
-		s += helpStyle.Render("Press q to exit")
+		if m.mode == "sync" {
+			s += helpStyle.Render(i18n.Tf("l: toggle log %[1]s %[2]s/%[3]s: scroll suppliers %[1]s q: exit", symbols.separator, symbols.up, symbols.down))
+		} else {
+			s += helpStyle.Render(i18n.T("Press q to exit"))
+		}
 	}
 	if m.quitting {
 		s += "\n"
 	}
 
-	return appStyle.Render(s)
+	// Error messages of recipe steps may contain credentials
+	return appStyle.Render(redact.String(s))
+}
+
+// controlEvent converts a bubbletea message into an event for clients of the control socket.
+func controlEvent(msg tea.Msg) (control.Event, bool) {
+	switch msg := msg.(type) {
+	case viewMsgStatusUpdate:
+		event := control.Event{Type: control.EVENT_STATUS, Title: msg.title}
+		if msg.hasError {
+			event.Error = msg.title
+		}
+		return event, true
+	case utils.ViewMsgStatusAndDescriptionUpdate:
+		return control.Event{Type: control.EVENT_STATUS, Title: msg.Title, Description: msg.Description}, true
+	case viewMsgProgressUpdate:
+		return control.Event{Type: control.EVENT_PROGRESS, Percent: msg.Percent}, true
+	case utils.ViewMsgProgressUpdate:
+		return control.Event{Type: control.EVENT_PROGRESS, Percent: msg.Percent}, true
+	case viewMsgRecipeDownloadResultMsg:
+		return control.Event{
+			Type:     control.EVENT_SUPPLIER_RESULT,
+			Title:    ansi.Strip(msg.step),
+			NewFiles: msg.newFilesCount,
+			Duration: msg.duration.Seconds(),
+			Error:    msg.errorMessage,
+		}, true
+	}
+	return control.Event{}, false
+}
+
+// previewLines renders the first line of each entry, cut to the width of the view.
+func previewLines(entries []string) string {
+	const maxLines = 10
+
+	lines := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		if i == maxLines {
+			lines = append(lines, fmt.Sprintf("... and %d more", len(entries)-maxLines))
+			break
+		}
+		line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(entry), "\n", 2)[0])
+		if len(line) > maxWidth-4 {
+			line = line[:maxWidth-7] + "..."
+		}
+		lines = append(lines, "> "+line)
+	}
+	return strings.Join(lines, "\n  ")
 }
 
 func tickCmd() tea.Cmd {
@@ -750,15 +1418,15 @@ func tickCmd() tea.Cmd {
 
 func quit(m viewModel) viewModel {
 	if m.hasError {
-		m.currentAction = "ERROR while running recipes!"
+		m.currentAction = i18n.T("ERROR while running recipes!")
 		m.quitting = true
 		m.showProgress = false
 
 	} else {
-		m.currentAction = "Thanks for using buchhalter.ai!"
+		m.currentAction = i18n.T("Thanks for using buchhalter.ai!")
 		m.quitting = true
 		m.showProgress = false
-		m.details = "HAVE A NICE DAY! :)"
+		m.details = i18n.T("HAVE A NICE DAY! :)")
 	}
 
 	// TODO Double check where we need to quit running browser sessions
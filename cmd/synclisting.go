@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/utils"
+)
+
+// viewMsgDocumentsListed registers the documents offered by a supplier in list-only runs (see `sync --list-only`).
+// They are printed after the sync view ended.
+type viewMsgDocumentsListed struct {
+	supplier  string
+	documents []utils.DiscoveredDocument
+}
+
+// listedDocuments returns the documents listed by the steps of a recipe run in list-only mode.
+func listedDocuments(recipeResult utils.RecipeResult) []utils.DiscoveredDocument {
+	var documents []utils.DiscoveredDocument
+	for _, stepTiming := range recipeResult.StepTimings {
+		documents = append(documents, stepTiming.Artifacts.Documents...)
+	}
+	return documents
+}
+
+// listingSummary describes the listed documents of a supplier: their number, how many are archived already and the
+// dates of the oldest and the newest document (if the recipe extracts dates).
+func listingSummary(documents []utils.DiscoveredDocument) string {
+	archived := 0
+	var dates []string
+	for _, document := range documents {
+		if document.Archived {
+			archived++
+		}
+		if document.Date != "" {
+			dates = append(dates, document.Date)
+		}
+	}
+
+	summary := i18n.Tf("%d found, %d archived", len(documents), archived)
+	if len(dates) > 0 {
+		sort.Strings(dates)
+		summary += ", " + i18n.Tf("%s to %s", dates[0], dates[len(dates)-1])
+	}
+	return summary
+}
+
+// printListedDocuments prints the documents of each supplier that aren't archived yet.
+func printListedDocuments(listings []viewMsgDocumentsListed) {
+	if len(listings) == 0 {
+		return
+	}
+
+	fmt.Println(headerStyle(i18n.T("Documents offered by the suppliers (nothing was downloaded)")))
+	for _, listing := range listings {
+		fmt.Println(textStyleBold(listing.supplier) + " " + textStyle(listingSummary(listing.documents)))
+		for _, document := range listing.documents {
+			if document.Archived {
+				continue
+			}
+			name := document.ID
+			if name == "" {
+				name = document.URL
+			}
+			if name == "" {
+				name = i18n.T("(no id or link)")
+			}
+			if document.Date != "" {
+				name = document.Date + "  " + name
+			}
+			fmt.Println(textStyle("  - " + name))
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"buchhalter/lib/control"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// numVisiblePanes is the number of supplier panes shown at once, the list scrolls beyond
+	numVisiblePanes = 10
+	// numLogLines is the number of log lines shown in the log pane
+	numLogLines = 10
+)
+
+var paneNameStyle = lipgloss.NewStyle().Bold(true)
+
+// supplierPane is the status line of a supplier in the sync view.
+// The state is one of queued, running, success, warning, error or skipped.
+type supplierPane struct {
+	supplier string
+	state    string
+	steps    int
+	step     int
+	newFiles int
+	// detail is the description of the current step, the result or the reason for skipping
+	detail    string
+	startedAt time.Time
+	duration  time.Duration
+	// estimates are the estimated durations of the steps, progressAt the time the last step completed
+	estimates  []time.Duration
+	progressAt time.Time
+}
+
+// supplierPanes is the scrollable list of suppliers of a run.
+type supplierPanes struct {
+	panes  []supplierPane
+	offset int
+	// follow keeps the running supplier visible until the list is scrolled manually
+	follow bool
+}
+
+// viewMsgSupplierQueue registers the suppliers of a run with the number and estimated durations of the steps of
+// their recipes.
+type viewMsgSupplierQueue struct {
+	suppliers []string
+	steps     []int
+	estimates [][]time.Duration
+}
+
+// viewMsgSupplierStart marks a supplier as running.
+type viewMsgSupplierStart struct {
+	supplier string
+}
+
+// viewMsgSupplierSkipped marks a supplier as skipped, e.g. due to a failed dependency.
+type viewMsgSupplierSkipped struct {
+	supplier string
+	reason   string
+}
+
+func newSupplierPanes(suppliers []string, steps []int, estimates [][]time.Duration) supplierPanes {
+	panes := make([]supplierPane, len(suppliers))
+	for i, supplier := range suppliers {
+		panes[i] = supplierPane{supplier: supplier, state: "queued"}
+		if i < len(steps) {
+			panes[i].steps = steps[i]
+		}
+		if i < len(estimates) {
+			panes[i].estimates = estimates[i]
+		}
+	}
+	return supplierPanes{panes: panes, follow: true}
+}
+
+// update applies the messages of a run to the panes.
+func (l *supplierPanes) update(msg tea.Msg) {
+	switch msg := msg.(type) {
+	case viewMsgSupplierQueue:
+		*l = newSupplierPanes(msg.suppliers, msg.steps, msg.estimates)
+	case viewMsgSupplierStart:
+		l.start(msg.supplier)
+	case viewMsgSupplierSkipped:
+		l.skip(msg.supplier, msg.reason)
+	case viewMsgRecipeDownloadResultMsg:
+		if msg.supplier != "" {
+			l.finish(msg.supplier, msg.status, msg.newFilesCount, msg.duration, msg.errorMessage, msg.summary)
+		}
+	case utils.ViewMsgProgressUpdate:
+		l.progress(msg.Step, msg.NewFilesCount)
+	case utils.ViewMsgStatusAndDescriptionUpdate:
+		l.describe(msg.Description)
+	}
+}
+
+func (l *supplierPanes) find(supplier string) *supplierPane {
+	for i := range l.panes {
+		if l.panes[i].supplier == supplier {
+			return &l.panes[i]
+		}
+	}
+	return nil
+}
+
+// running returns the pane of the running supplier, nil if no supplier is running.
+func (l *supplierPanes) running() *supplierPane {
+	for i := range l.panes {
+		if l.panes[i].state == "running" {
+			return &l.panes[i]
+		}
+	}
+	return nil
+}
+
+func (l *supplierPanes) start(supplier string) {
+	for i := range l.panes {
+		if l.panes[i].supplier != supplier {
+			continue
+		}
+		l.panes[i].state = "running"
+		l.panes[i].startedAt = time.Now()
+		l.panes[i].progressAt = l.panes[i].startedAt
+		if l.follow {
+			l.show(i)
+		}
+	}
+}
+
+func (l *supplierPanes) skip(supplier, reason string) {
+	if pane := l.find(supplier); pane != nil {
+		pane.state = "skipped"
+		pane.detail = reason
+	}
+}
+
+// progress updates the step and document counter of the running supplier.
+func (l *supplierPanes) progress(step, newFiles int) {
+	if pane := l.running(); pane != nil {
+		pane.step = step
+		pane.newFiles = newFiles
+		pane.progressAt = time.Now()
+	}
+}
+
+// describe sets the description of the current step of the running supplier.
+func (l *supplierPanes) describe(description string) {
+	if pane := l.running(); pane != nil {
+		pane.detail = description
+	}
+}
+
+// finish stores the result of a supplier. Results with an error message get an error badge, partial results a warning.
+func (l *supplierPanes) finish(supplier, status string, newFiles int, duration time.Duration, errorMessage, summary string) {
+	pane := l.find(supplier)
+	if pane == nil {
+		return
+	}
+	pane.newFiles = newFiles
+	pane.duration = duration
+	pane.detail = summary
+	switch {
+	case errorMessage != "" || status == "error":
+		pane.state = "error"
+		pane.detail = errorMessage
+	case status == "warning" || status == "partial":
+		pane.state = "warning"
+	default:
+		pane.state = "success"
+		pane.step = pane.steps
+	}
+}
+
+// remaining estimates the remaining time of the queued and running suppliers, false if none is left.
+func (l *supplierPanes) remaining(now time.Time) (time.Duration, bool) {
+	var total time.Duration
+	left := false
+	for _, pane := range l.panes {
+		if remaining, ok := pane.remaining(now); ok {
+			total += remaining
+			left = true
+		}
+	}
+	return total, left
+}
+
+// annotate adds the estimated remaining time of the running supplier and of the run to event.
+func (l *supplierPanes) annotate(event *control.Event) {
+	now := time.Now()
+	if pane := l.running(); pane != nil {
+		if remaining, ok := pane.remaining(now); ok {
+			event.SupplierRemaining = remaining.Seconds()
+		}
+	}
+	if remaining, ok := l.remaining(now); ok {
+		event.Remaining = remaining.Seconds()
+	}
+}
+
+// scroll moves the visible part of the list by delta lines.
+func (l *supplierPanes) scroll(delta int) {
+	l.follow = false
+	l.offset = max(0, min(l.offset+delta, len(l.panes)-numVisiblePanes))
+}
+
+// show scrolls the pane at index into the visible part of the list.
+func (l *supplierPanes) show(index int) {
+	if index < l.offset {
+		l.offset = index
+	}
+	if index >= l.offset+numVisiblePanes {
+		l.offset = index - numVisiblePanes + 1
+	}
+}
+
+// View renders one status line per visible supplier. spinner is the current frame of the spinner of running suppliers.
+func (l supplierPanes) View(spinner string) string {
+	if len(l.panes) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	if l.offset > 0 {
+		b.WriteString(dotStyle.Render("  "+i18n.Tf("%s %d more", symbols.up, l.offset)) + "\n")
+	}
+	end := min(l.offset+numVisiblePanes, len(l.panes))
+	for _, pane := range l.panes[l.offset:end] {
+		b.WriteString(pane.View(spinner) + "\n")
+	}
+	if end < len(l.panes) {
+		b.WriteString(dotStyle.Render("  "+i18n.Tf("%s %d more", symbols.down, len(l.panes)-end)) + "\n")
+	}
+	return b.String()
+}
+
+// remaining estimates the remaining time of a queued or running supplier, false for all other states.
+// The running step counts as remaining until its estimated duration passed.
+func (p supplierPane) remaining(now time.Time) (time.Duration, bool) {
+	var remaining time.Duration
+	switch p.state {
+	case "queued":
+		for _, estimate := range p.estimates {
+			remaining += estimate
+		}
+		return remaining, true
+	case "running":
+		for _, estimate := range p.estimates[min(p.step, len(p.estimates)):] {
+			remaining += estimate
+		}
+		return max(0, remaining-now.Sub(p.progressAt)), true
+	}
+	return 0, false
+}
+
+func (p supplierPane) View(spinner string) string {
+	icon := "·"
+	switch p.state {
+	case "running":
+		icon = strings.TrimSpace(spinner)
+	case "success":
+		icon = symbols.success
+	case "warning":
+		icon = "!"
+	case "error":
+		icon = "x"
+	case "skipped":
+		icon = "-"
+	}
+
+	steps := ""
+	if p.steps > 0 {
+		steps = fmt.Sprintf("%d/%d", p.step, p.steps)
+	}
+	documents := ""
+	if p.state != "queued" && p.state != "skipped" {
+		documents = i18n.Tf("%d docs", p.newFiles)
+	}
+	elapsed := ""
+	switch {
+	case p.duration > 0:
+		elapsed = p.duration.Round(time.Second).String()
+	case p.state == "running":
+		elapsed = time.Since(p.startedAt).Round(time.Second).String()
+	}
+
+	estimate := ""
+	if remaining, ok := p.remaining(time.Now()); ok && len(p.estimates) > 0 {
+		estimate = formatEstimate(remaining)
+	}
+
+	line := fmt.Sprintf("%s %s %5s %8s %6s %7s", icon, paneNameStyle.Render(fmt.Sprintf("%-20s", truncate(p.supplier, 20))), steps, documents, elapsed, estimate)
+
+	detail := strings.TrimSpace(strings.SplitN(p.detail, "\n", 2)[0])
+	width := maxWidth - lipgloss.Width(line) - 2
+	if p.state == "error" {
+		badge := paneBadgeStyle.Render(i18n.T("ERROR"))
+		line += " " + badge
+		width -= lipgloss.Width(badge) + 1
+	}
+	if detail != "" && width > 3 {
+		line += " " + dotStyle.Render(truncate(detail, width))
+	}
+	return strings.TrimRight(line, " ")
+}
+
+// formatEstimate renders an estimated duration, rounded to seconds below a minute and to ten seconds above.
+func formatEstimate(d time.Duration) string {
+	if d >= time.Minute {
+		d = d.Round(10 * time.Second)
+	}
+	return "~" + d.Round(time.Second).String()
+}
+
+// truncate cuts s to width characters.
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	ellipsis := []rune(symbols.ellipsis)
+	return string(runes[:max(0, width-len(ellipsis))]) + symbols.ellipsis
+}
+
+// logPane keeps the last log lines of a run, so they can be shown in the sync view.
+type logPane struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+// View renders the last log lines, cut to the width of the view.
+func (l *logPane) View() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.lines) == 0 {
+		return dotStyle.Render(i18n.T("(no log entries yet)")) + "\n"
+	}
+	var b strings.Builder
+	for _, line := range l.lines {
+		b.WriteString(dotStyle.Render(truncate(line, maxWidth)) + "\n")
+	}
+	return b.String()
+}
+
+func (l *logPane) add(line string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.lines = append(l.lines, line)
+	if len(l.lines) > numLogLines {
+		l.lines = l.lines[len(l.lines)-numLogLines:]
+	}
+}
+
+// logPaneHandler passes log records to the handler of the logger and adds them to a log pane.
+type logPaneHandler struct {
+	next slog.Handler
+	pane *logPane
+}
+
+// withLogPane returns a logger that adds its records to pane as well.
+func withLogPane(logger *slog.Logger, pane *logPane) *slog.Logger {
+	return slog.New(&logPaneHandler{next: logger.Handler(), pane: pane})
+}
+
+func (h *logPaneHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *logPaneHandler) Handle(ctx context.Context, record slog.Record) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", record.Time.Format(time.TimeOnly), record.Level, record.Message)
+	record.Attrs(func(attr slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", attr.Key, attr.Value)
+		return true
+	})
+	h.pane.add(b.String())
+	return h.next.Handle(ctx, record)
+}
+
+func (h *logPaneHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logPaneHandler{next: h.next.WithAttrs(attrs), pane: h.pane}
+}
+
+func (h *logPaneHandler) WithGroup(name string) slog.Handler {
+	return &logPaneHandler{next: h.next.WithGroup(name), pane: h.pane}
+}
@@ -0,0 +1,910 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/audit"
+	"buchhalter/lib/blocklist"
+	"buchhalter/lib/browser"
+	"buchhalter/lib/control"
+	"buchhalter/lib/driver"
+	"buchhalter/lib/ebics"
+	"buchhalter/lib/fints"
+	"buchhalter/lib/fixture"
+	"buchhalter/lib/history"
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/keychain"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/redact"
+	"buchhalter/lib/repository"
+	"buchhalter/lib/utils"
+	"buchhalter/lib/vault"
+	"buchhalter/lib/webhook"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/viper"
+)
+
+// syncOptions are the options of a sync run, set by the flags of the sync command.
+type syncOptions struct {
+	// supplier is the only supplier of the run, selectedSuppliers limit the run to several suppliers (both optional)
+	supplier          string
+	selectedSuppliers []string
+	noUpload          bool
+	autoApprove       bool
+	// force runs suppliers already synced in their period
+	force bool
+	// listOnly lists the documents offered by the suppliers instead of downloading them
+	listOnly      bool
+	recordFixture string
+	recordVideo   string
+	devToolsPort  int
+}
+
+// syncDeps are the clients and stores of a sync run.
+type syncDeps struct {
+	logger              *slog.Logger
+	httpClient          *httpclient.Client
+	vaultProvider       *vault.Provider1Password
+	archives            *archive.Archives
+	recipeParser        *parser.RecipeParser
+	buchhalterAPIClient *repository.BuchhalterAPIClient
+	controlServer       *control.Server
+	statusFile          *control.StatusFile
+}
+
+// syncRun is the state of a sync run shared by its recipes, see runRecipes.
+type syncRun struct {
+	syncDeps
+	opts syncOptions
+	p    *tea.Program
+
+	developmentMode bool
+	runID           string
+	user            *repository.CliSyncResponse
+	restrictions    repository.RoleRestrictions
+	// queue are the suppliers of the run in the order of execution
+	queue []string
+	// completedSuppliers are the suppliers of this run that completed, recipes depending on others are skipped
+	completedSuppliers map[string]bool
+	lastSyncs          map[string]time.Time
+	historyRun         history.Run
+	auditLog           *audit.Log
+	remoteArchive      *archive.RemoteArchive
+	defaultArchive     *archive.DocumentArchive
+
+	recipeApprovalStore  *parser.RecipeApprovalStore
+	permissionStore      *parser.PermissionStore
+	minimalScopes        map[string][]string
+	oauth2ScopeOverrides map[string]string
+	supplierAdvisories   map[string][]repository.SupplierAdvisory
+	recipeTimeout        time.Duration
+	vaultWriteBack       bool
+
+	// Settings of the recipe drivers
+	buchhalterConfigDirectory    string
+	buchhalterDocumentsDirectory string
+	debugArtifactsDirectory      string
+	maxDownloadFilesPerReceipt   int
+	responseCache                *httpclient.ResponseCache
+	chromePath                   string
+	remoteDebuggingURL           string
+	adBlocklist                  *blocklist.Blocklist
+	shredTemporaryFiles          bool
+	chromeMemoryLimit            int
+	chromeRestartAfterSteps      int
+	chromeRestartAfter           time.Duration
+	tokenProfile                 string
+	oauth2Variables              map[string]map[string]string
+
+	// Progress of the run in steps
+	totalStepCount int
+	baseCountStep  int
+}
+
+// runRecipes runs the recipes of the sync run and processes the downloaded documents afterward.
+func runRecipes(ctx context.Context, p *tea.Program, deps syncDeps, opts syncOptions, localOICDBChecksum, localOICDBSchemaChecksum string) {
+	defer recoverCrash()
+
+	logger := deps.logger
+	r := &syncRun{syncDeps: deps, opts: opts, p: p, developmentMode: viper.GetBool("dev")}
+	r.statusFile.StartRun()
+	p.Send(viewMsgStatusUpdate{
+		title:    i18n.T("Build archive index"),
+		hasError: false,
+	})
+	logger.Info("Building document archive index ...")
+
+	err := r.archives.BuildArchiveIndex(ctx)
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+		p.Send(viewMsgStatusUpdate{
+			title:      i18n.T("Building document archive index"),
+			hasError:   true,
+			shouldQuit: false,
+		})
+	}
+
+	// Documents in the remote archive are not downloaded again
+	r.remoteArchive, err = initializeRemoteArchive(logger)
+	if err != nil {
+		logger.Error("Error in setting buchhalter_remote_archive", "error", err)
+		p.Send(viewMsgStatusUpdate{
+			title:      i18n.Tf("Remote archive: %s", err),
+			hasError:   true,
+			shouldQuit: false,
+		})
+	}
+	r.defaultArchive, _ = r.archives.Get(archive.DEFAULT_ARCHIVE)
+	if r.remoteArchive != nil {
+		p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Fetching remote archive index"),
+			hasError: false,
+		})
+		remoteIndex, err := r.remoteArchive.FetchIndex()
+		if err != nil {
+			// The cached remote index is used instead
+			logger.Error("Error fetching remote archive index", "error", err)
+		}
+		r.defaultArchive.SetRemoteIndex(remoteIndex)
+	}
+
+	r.updateRecipes(ctx, localOICDBChecksum, localOICDBSchemaChecksum)
+
+	recipesToExecute, err := prepareRecipes(logger, opts.supplier, r.vaultProvider, r.recipeParser)
+	if len(opts.selectedSuppliers) > 0 {
+		recipesToExecute = filterRecipes(recipesToExecute, opts.selectedSuppliers)
+	}
+	// No credentials found for supplier/recipes
+	if len(recipesToExecute) == 0 || err != nil {
+		logger.Error("No recipes found for suppliers", "supplier", opts.supplier, "error", err)
+		p.Send(viewMsgStatusUpdate{
+			title:      i18n.T("No recipes found for suppliers"),
+			hasError:   true,
+			shouldQuit: true,
+		})
+		return
+	}
+
+	recipesToExecute = orderRecipes(p, logger, recipesToExecute)
+	r.queue = make([]string, 0, len(recipesToExecute))
+	for i := range recipesToExecute {
+		r.queue = append(r.queue, recipesToExecute[i].recipe.Supplier)
+	}
+	r.statusFile.SetQueue(r.queue)
+	// The run history is used to estimate the remaining time and to skip suppliers synced in their period
+	runs, err := history.NewRunHistory(logger, viper.GetString("buchhalter_directory")).Runs()
+	if err != nil {
+		logger.Error("Error reading run history", "error", err)
+	}
+	estimator := history.NewEstimator(runs)
+	stepCounts := make([]int, 0, len(recipesToExecute))
+	stepEstimates := make([][]time.Duration, 0, len(recipesToExecute))
+	for i := range recipesToExecute {
+		stepCounts = append(stepCounts, len(recipesToExecute[i].recipe.Steps))
+		stepEstimates = append(stepEstimates, estimator.StepDurations(recipesToExecute[i].recipe.Supplier, len(recipesToExecute[i].recipe.Steps)))
+	}
+	p.Send(viewMsgSupplierQueue{suppliers: r.queue, steps: stepCounts, estimates: stepEstimates})
+
+	var t string
+	recipeCount := len(recipesToExecute)
+	if recipeCount == 1 {
+		t = i18n.Tf("Running one recipe for supplier %s ...", recipesToExecute[0].recipe.Supplier)
+		logger.Info("Running one recipe ...", "supplier", recipesToExecute[0].recipe.Supplier)
+	} else {
+		t = i18n.Tf("Running recipes for %d suppliers ...", recipeCount)
+		logger.Info("Running recipes for multiple suppliers ...", "num_suppliers", recipeCount)
+	}
+	p.Send(viewMsgStatusUpdate{
+		title:    t,
+		hasError: false,
+	})
+	p.Send(viewMsgProgressUpdate{Percent: 0.001})
+
+	// Premium users see the status of this run on the Buchhalter Platform
+	logger.Info("Checking if we have a premium subscription to Buchhalter API ...")
+	r.user, err = r.buchhalterAPIClient.GetAuthenticatedUser(ctx)
+	if err != nil {
+		logger.Error("Error retrieving authenticated user", "error", err)
+		p.Send(viewMsgStatusUpdate{
+			title:      i18n.Tf("Retrieving authenticated user: %s", httpclient.GetHumanReadableErrorMessage(err)),
+			hasError:   true,
+			shouldQuit: false,
+		})
+	}
+	// The role of the user in the team may restrict the run
+	if team, ok := r.buchhalterAPIClient.Team(); ok {
+		r.restrictions = team.Restrictions
+		if r.restrictions.DenySync {
+			logger.Warn("Role of user in team doesn't permit syncs", "team", team.Slug, "role", team.Role)
+			p.Send(viewMsgStatusUpdate{
+				title:      i18n.Tf("Your role %s in team %s doesn't permit syncs", team.Role, team.Name),
+				hasError:   true,
+				shouldQuit: true,
+			})
+			return
+		}
+	}
+	// Listing documents isn't a run of the team
+	if r.user != nil && len(r.user.User.ID) > 0 && !opts.listOnly {
+		suppliers := make([]string, 0, len(recipesToExecute))
+		var restrictedSuppliers []string
+		for i := range recipesToExecute {
+			if !r.restrictions.AllowsSupplier(recipesToExecute[i].recipe.Supplier) {
+				restrictedSuppliers = append(restrictedSuppliers, recipesToExecute[i].recipe.Supplier)
+				continue
+			}
+			suppliers = append(suppliers, recipesToExecute[i].recipe.Supplier)
+		}
+		r.runID, err = r.buchhalterAPIClient.ReportRunStart(ctx, cliVersion, suppliers, restrictedSuppliers)
+		if err != nil {
+			logger.Error("Error reporting run start to Buchhalter API", "error", err)
+		}
+	}
+
+	r.buchhalterDocumentsDirectory = viper.GetString("buchhalter_documents_directory")
+	r.buchhalterConfigDirectory = viper.GetString("buchhalter_config_directory")
+	r.maxDownloadFilesPerReceipt = viper.GetInt("buchhalter_max_download_files_per_receipt")
+	if viper.GetBool("buchhalter_debug_artifacts") {
+		r.debugArtifactsDirectory = browser.DebugArtifactsDirectory(viper.GetString("buchhalter_directory"))
+	}
+
+	if viper.GetBool("buchhalter_http_cache") {
+		cacheTTL := time.Duration(viper.GetInt("buchhalter_http_cache_ttl")) * time.Second
+		r.responseCache = httpclient.NewResponseCache(filepath.Join(r.buchhalterConfigDirectory, "cache", "http"), cacheTTL)
+	}
+
+	r.permissionStore, err = parser.NewPermissionStore(logger, r.buchhalterConfigDirectory)
+	if err != nil {
+		logger.Error("Error loading recipe permissions", "error", err)
+		p.Send(viewMsgStatusUpdate{
+			title:      i18n.T("Loading recipe permissions"),
+			hasError:   true,
+			shouldQuit: true,
+		})
+		return
+	}
+
+	r.recipeApprovalStore, err = parser.NewRecipeApprovalStore(logger, r.buchhalterConfigDirectory)
+	if err != nil {
+		logger.Error("Error loading approved recipes", "error", err)
+		p.Send(viewMsgStatusUpdate{
+			title:      i18n.T("Loading approved recipes"),
+			hasError:   true,
+			shouldQuit: true,
+		})
+		return
+	}
+
+	// Warn about Chrome versions known to break chromedp-undetected instead of failing with cryptic errors mid-recipe.
+	// A remote Chrome isn't launched by buchhalter, the local Chrome is only used for client recipes then.
+	r.remoteDebuggingURL = viper.GetString("buchhalter_remote_debugging_url")
+	r.chromePath = viper.GetString("buchhalter_chrome_path")
+	chromeVersion, err := browser.DetectChromeVersion(r.chromePath)
+	if err == nil {
+		err = browser.CheckChromeVersion(chromeVersion)
+	}
+	if err != nil && r.remoteDebuggingURL == "" {
+		logger.Warn("Chrome compatibility check failed", "chrome_path", r.chromePath, "chrome_version", chromeVersion, "error", err)
+		p.Send(viewMsgRecipeDownloadResultMsg{
+			step: "! " + browser.GetHumanReadableChromeErrorMessage(err),
+		})
+	}
+
+	// Ads and trackers slow down page loads of supplier portals
+	if viper.GetBool("buchhalter_block_trackers") {
+		blocklistLoader := blocklist.NewLoader(logger, r.httpClient, filepath.Join(r.buchhalterConfigDirectory, "blocklists"), 24*time.Hour)
+		r.adBlocklist, err = blocklistLoader.Load(viper.GetStringSlice("buchhalter_blocklists"))
+		if err != nil {
+			// Recipes work without blocklist, just slower
+			logger.Error("Error loading blocklists", "error", err)
+		}
+	}
+
+	r.shredTemporaryFiles = viper.GetBool("buchhalter_shred_temporary_files")
+	r.chromeMemoryLimit = viper.GetInt("buchhalter_chrome_memory_limit")
+	r.chromeRestartAfterSteps = viper.GetInt("buchhalter_chrome_restart_after_steps")
+	r.chromeRestartAfter = time.Duration(viper.GetInt("buchhalter_chrome_restart_after_minutes")) * time.Minute
+	r.recipeTimeout = time.Duration(viper.GetInt("buchhalter_recipe_timeout")) * time.Second
+	r.vaultWriteBack = viper.GetBool("credential_provider_write_back")
+
+	r.minimalScopes = minimalScopeCatalogue()
+	r.oauth2ScopeOverrides = viper.GetStringMapString("buchhalter_oauth2_scopes")
+	// Migrates the legacy token cache before client recipes read it
+	initializeTokenDirectory(logger)
+	r.tokenProfile = viper.GetString("buchhalter_profile")
+	r.oauth2Variables = map[string]map[string]string{}
+	err = viper.UnmarshalKey("buchhalter_oauth2_variables", &r.oauth2Variables)
+	if err != nil {
+		logger.Error("Error in setting buchhalter_oauth2_variables", "error", err)
+	}
+
+	// Known issues of recipes are shown before running the supplier
+	r.supplierAdvisories = map[string][]repository.SupplierAdvisory{}
+	if viper.GetBool("buchhalter_supplier_advisories") {
+		r.supplierAdvisories, err = r.buchhalterAPIClient.GetSupplierAdvisories(ctx, r.queue)
+		if err != nil {
+			// Recipes work without advisories
+			logger.Error("Error retrieving supplier advisories from Buchhalter API", "error", err)
+		}
+	}
+
+	// Suppliers with a frequency run once per period, documents are listed regardless of the last sync
+	if r.opts.listOnly {
+		r.opts.force = true
+	}
+	if !r.opts.force {
+		r.lastSyncs = history.LastSuccessfulSyncs(runs)
+	}
+
+	r.auditLog = initializeAuditLog(logger, "sync")
+	defer r.auditLog.Close()
+
+	// The credentials of all suppliers of the run are loaded from the vault at once and kept in memory until the run ends
+	vaultItemIds := make([]string, 0, len(recipesToExecute))
+	for i := range recipesToExecute {
+		vaultItemIds = append(vaultItemIds, recipesToExecute[i].vaultItemId)
+	}
+	err = r.vaultProvider.PrefetchCredentials(ctx, vaultItemIds)
+	if err != nil {
+		// The credentials are requested per supplier instead
+		logger.Error("Error prefetching credentials from vault", "error", err)
+	}
+	defer r.vaultProvider.ClearCredentials()
+
+	r.historyRun = history.Run{StartedAt: time.Now()}
+	r.completedSuppliers = map[string]bool{}
+	for i := range recipesToExecute {
+		r.totalStepCount += len(recipesToExecute[i].recipe.Steps)
+	}
+	for i := range recipesToExecute {
+		// The run was cancelled, e.g. on SIGINT or because the user quit the interface
+		if ctx.Err() != nil {
+			logger.Info("Run cancelled", "cause", context.Cause(ctx))
+			break
+		}
+		aborted := r.runRecipe(ctx, recipesToExecute[i])
+		r.baseCountStep += len(recipesToExecute[i].recipe.Steps)
+		if aborted {
+			break
+		}
+	}
+
+	r.finishRun(ctx)
+}
+
+// updateRecipes updates the Open Invoice Collector Database and its schema, unless it is imported manually.
+func (r *syncRun) updateRecipes(ctx context.Context, localOICDBChecksum, localOICDBSchemaChecksum string) {
+	// Air-gapped installations import the database with `buchhalter update --from-file` instead
+	autoUpdate := viper.GetBool("buchhalter_oicdb_auto_update")
+	if autoUpdate {
+		// Check for OICDB schema updates
+		r.p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Checking for OICDB schema updates ..."),
+			hasError: false,
+		})
+		r.logger.Info("Checking for OICDB schema updates ...", "local_checksum", localOICDBSchemaChecksum)
+
+		err := r.buchhalterAPIClient.UpdateOpenInvoiceCollectorDBSchemaIfAvailable(ctx, localOICDBSchemaChecksum)
+		if err != nil {
+			r.logger.Error("Error checking for OICDB schema updates", "error", err)
+			r.p.Send(viewMsgStatusUpdate{
+				title:      i18n.Tf("Checking for OICDB schema updates: %s", httpclient.GetHumanReadableErrorMessage(err)),
+				hasError:   true,
+				shouldQuit: false,
+			})
+		}
+	}
+
+	if autoUpdate && !r.developmentMode {
+		// Check for OICDB repository updates
+		r.p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Checking for OICDB repository updates ..."),
+			hasError: false,
+		})
+		r.logger.Info("Checking for OICDB repository updates ...", "local_checksum", localOICDBChecksum)
+
+		err := r.buchhalterAPIClient.UpdateOpenInvoiceCollectorDBIfAvailable(ctx, localOICDBChecksum)
+		if err != nil {
+			r.logger.Error("Error checking for OICDB repository updates", "error", err)
+			r.p.Send(viewMsgStatusUpdate{
+				title:      i18n.Tf("Checking for OICDB repository updates: %s", httpclient.GetHumanReadableErrorMessage(err)),
+				hasError:   true,
+				shouldQuit: false,
+			})
+		}
+	}
+}
+
+// runRecipe runs the recipe of a supplier, unless it is skipped (e.g. not approved or synced in its period).
+// It returns true if the run was aborted via the control socket.
+func (r *syncRun) runRecipe(ctx context.Context, recipeToRun recipeToExecute) bool {
+	p := r.p
+	logger := r.logger
+	recipe := recipeToRun.recipe
+	startTime := time.Now()
+	stepCountInCurrentRecipe := len(recipe.Steps)
+	if !r.restrictions.AllowsSupplier(recipe.Supplier) {
+		logger.Info("Skipping recipe, supplier not permitted by role", "supplier", recipe.Supplier)
+		p.Send(viewMsgSupplierSkipped{supplier: recipe.Supplier, reason: i18n.T("not permitted by your role")})
+		return false
+	}
+	if !recipeApproved(p, logger, r.recipeApprovalStore, recipe, r.opts.autoApprove) || !scriptsAllowed(p, logger, r.permissionStore, recipe) {
+		p.Send(viewMsgSupplierSkipped{supplier: recipe.Supplier, reason: i18n.T("not approved")})
+		return false
+	}
+	if dependency := failedDependency(recipe, r.queue, r.completedSuppliers); dependency != "" {
+		logger.Info("Skipping recipe due to failed dependency", "supplier", recipe.Supplier, "dependency", dependency)
+		p.Send(viewMsgStatusUpdate{
+			title:      i18n.Tf("Skipping %s, as %s didn't complete", recipe.Supplier, dependency),
+			hasError:   true,
+			shouldQuit: false,
+		})
+		p.Send(viewMsgSupplierSkipped{supplier: recipe.Supplier, reason: i18n.Tf("%s didn't complete", dependency)})
+		return false
+	}
+	if !r.opts.force && syncedInPeriod(logger, recipe, r.lastSyncs) {
+		p.Send(viewMsgSupplierSkipped{supplier: recipe.Supplier, reason: i18n.T("already synced in this period")})
+		return false
+	}
+	if r.opts.listOnly && recipe.Type != "browser" && recipe.Type != "client" {
+		logger.Info("Skipping recipe, documents of the recipe type can't be listed", "supplier", recipe.Supplier, "supplier_type", recipe.Type)
+		p.Send(viewMsgSupplierSkipped{supplier: recipe.Supplier, reason: i18n.T("documents can't be listed")})
+		return false
+	}
+	checkRecipeScopes(p, logger, recipe, r.minimalScopes, r.oauth2ScopeOverrides)
+	showSupplierAdvisories(p, logger, recipe, r.supplierAdvisories[recipe.Supplier])
+
+	// Clients of the control socket can pause the run and skip or abort suppliers.
+	// Recipes running longer than buchhalter_recipe_timeout are aborted.
+	recipeCtx, cancelRecipe := context.WithTimeoutCause(ctx, r.recipeTimeout, fmt.Errorf("recipe timeout of %s exceeded", r.recipeTimeout))
+	defer cancelRecipe()
+	if !r.controlServer.StartSupplier(recipe.Supplier, cancelRecipe) {
+		if r.controlServer.Aborted() {
+			logger.Info("Run aborted via control socket")
+			return true
+		}
+		logger.Info("Skipping recipe via control socket", "supplier", recipe.Supplier)
+		p.Send(viewMsgSupplierSkipped{supplier: recipe.Supplier, reason: i18n.T("skipped via control socket")})
+		return false
+	}
+	r.statusFile.StartSupplier(recipe.Supplier)
+	p.Send(viewMsgSupplierStart{supplier: recipe.Supplier})
+
+	p.Send(viewMsgStatusUpdate{
+		title:    i18n.Tf("Downloading invoices from %s:", recipe.Supplier),
+		hasError: false,
+	})
+
+	// Load username, password, totp from vault
+	logger.Info("Requesting credentials from vault", "supplier", recipe.Supplier)
+	recipeCredentials, err := r.vaultProvider.GetCredentialsByItemId(ctx, recipeToRun.vaultItemId)
+	if err != nil {
+		// TODO Implement better error handling
+		logger.Error(r.vaultProvider.GetHumanReadableErrorMessage(err))
+		fmt.Println(r.vaultProvider.GetHumanReadableErrorMessage(err))
+		p.Send(viewMsgSupplierSkipped{supplier: recipe.Supplier, reason: i18n.T("credentials not available")})
+		return false
+	}
+	r.auditLog.Record(audit.Event{Kind: audit.KIND_VAULT_ITEM, Source: recipeToRun.vaultItemId, Supplier: recipe.Supplier})
+
+	logger.Info("Downloading invoices ...", "supplier", recipe.Supplier, "supplier_type", recipe.Type, "archive", r.archives.NameOfSupplier(recipe.Supplier))
+	var recipeResult utils.RecipeResult
+	recipeDriver := r.newRecipeDriver(recipeCtx, recipe, recipeCredentials)
+	if recipeDriver != nil {
+		recipeResult = recipeDriver.RunRecipe(p, r.totalStepCount, stepCountInCurrentRecipe, r.baseCountStep, recipe)
+		switch d := recipeDriver.(type) {
+		case *browser.BrowserDriver:
+			if r.opts.recordFixture != "" {
+				saveFixture(logger, d.FixtureRecorder, r.opts.recordFixture, recipeResult)
+			}
+			if ChromeVersion == "" {
+				ChromeVersion = d.ChromeVersion
+			}
+		case *browser.ClientAuthBrowserDriver:
+			if ChromeVersion == "" {
+				ChromeVersion = d.ChromeVersion
+			}
+		}
+		err = recipeDriver.Quit()
+		if err != nil {
+			// TODO Implement better error handling
+			fmt.Println(err)
+		}
+	} else {
+		recipeResult = driver.ErrorResult(recipe.Supplier, fmt.Errorf("unknown recipe type %s", recipe.Type))
+	}
+	r.controlServer.FinishSupplier()
+	cancelRecipe()
+
+	if r.opts.listOnly {
+		documents := listedDocuments(recipeResult)
+		notArchived := 0
+		for _, document := range documents {
+			if !document.Archived {
+				notArchived++
+			}
+		}
+		logger.Info("Listing documents ... completed", "supplier", recipe.Supplier, "duration", time.Since(startTime), "documents", len(documents), "not_archived", notArchived)
+		p.Send(viewMsgDocumentsListed{supplier: recipe.Supplier, documents: documents})
+		p.Send(viewMsgRecipeDownloadResultMsg{
+			supplier:      recipe.Supplier,
+			status:        recipeResult.Status,
+			duration:      time.Since(startTime),
+			newFilesCount: notArchived,
+			step:          recipeResult.StatusTextFormatted,
+			errorMessage:  recipeResult.LastErrorMessage,
+			summary:       listingSummary(documents),
+		})
+		return false
+	}
+
+	rdx := repository.RunDataSupplier{
+		Supplier:         recipe.Supplier,
+		Version:          recipe.Version,
+		Status:           recipeResult.StatusText,
+		LastErrorMessage: recipeResult.LastErrorMessage,
+		Duration:         time.Since(startTime).Seconds(),
+		NewFilesCount:    recipeResult.NewFilesCount,
+	}
+	for _, stepTiming := range recipeResult.StepTimings {
+		rdx.Steps = append(rdx.Steps, repository.RunDataStep{
+			Number:        stepTiming.Number,
+			Action:        stepTiming.Action,
+			Status:        stepTiming.Status,
+			Duration:      stepTiming.Duration.Seconds(),
+			StepArtifacts: stepTiming.Artifacts,
+		})
+	}
+	if recipeResult.Status == "error" && len(recipeResult.StepTimings) > 0 {
+		failedStep := recipeResult.StepTimings[len(recipeResult.StepTimings)-1]
+		rdx.FailedStepAction = failedStep.Action
+		rdx.ErrorCategory = repository.ClassifyRecipeError(failedStep.Action, failedStep.Status, recipeResult.LastErrorMessage)
+	}
+	RunData = append(RunData, rdx)
+	if recipeResult.Status == "success" || recipeResult.Status == "warning" {
+		r.completedSuppliers[rdx.Supplier] = true
+	}
+	if r.vaultWriteBack && (recipeResult.Status == "success" || recipeResult.Status == "warning") {
+		vaultItemId := recipeToRun.vaultItemId
+		err = r.vaultProvider.UpdateItemMetadata(ctx, vaultItemId, vault.ItemMetadata{
+			LastSynced: time.Now(),
+			LoginURL:   vault.CorrectedLoginURL(r.vaultProvider.UrlsByItemId[vaultItemId]),
+		})
+		if err != nil {
+			// The sync itself was successful
+			logger.Error("Error writing metadata to vault item", "supplier", rdx.Supplier, "credentials_id", vaultItemId, "error", err)
+		}
+	}
+	r.historyRun.Suppliers = append(r.historyRun.Suppliers, historySupplierRun(rdx, recipeResult))
+	if r.runID != "" {
+		err = r.buchhalterAPIClient.ReportSupplierStatus(ctx, r.runID, rdx)
+		if err != nil {
+			logger.Error("Error reporting supplier status to Buchhalter API", "supplier", rdx.Supplier, "error", err)
+		}
+	}
+	// TODO Check for recipeResult.LastErrorMessage
+	p.Send(viewMsgRecipeDownloadResultMsg{
+		supplier:      rdx.Supplier,
+		status:        recipeResult.Status,
+		duration:      time.Since(startTime),
+		newFilesCount: recipeResult.NewFilesCount,
+		step:          recipeResult.StatusTextFormatted,
+		errorMessage:  recipeResult.LastErrorMessage,
+	})
+	logger.Info("Downloading invoices ... completed", "supplier", recipe.Supplier, "supplier_type", recipe.Type, "duration", time.Since(startTime), "new_files", recipeResult.NewFilesCount, "warnings", recipeResult.Warnings)
+	return false
+}
+
+// newRecipeDriver returns the driver of the recipe type, nil for unknown types.
+func (r *syncRun) newRecipeDriver(recipeCtx context.Context, recipe *parser.Recipe, recipeCredentials *vault.Credentials) driver.RecipeDriver {
+	documentArchive := r.archives.ForSupplier(recipe.Supplier)
+	switch recipe.Type {
+	case "browser":
+		browserDriver := browser.NewBrowserDriver(recipeCtx, r.logger, r.httpClient, recipeCredentials, r.buchhalterDocumentsDirectory, r.debugArtifactsDirectory, documentArchive, r.maxDownloadFilesPerReceipt)
+		// The Chrome version is only probed once per run
+		browserDriver.ChromeVersion = ChromeVersion
+		browserDriver.ChromePath = r.chromePath
+		browserDriver.Blocklist = r.adBlocklist
+		browserDriver.ShredTemporaryFiles = r.shredTemporaryFiles
+		browserDriver.VideoDirectory = r.opts.recordVideo
+		browserDriver.DevToolsPort = r.opts.devToolsPort
+		browserDriver.AuditLog = r.auditLog
+		browserDriver.ListOnly = r.opts.listOnly
+		browserDriver.MemoryLimit = r.chromeMemoryLimit
+		browserDriver.RestartAfterSteps = r.chromeRestartAfterSteps
+		browserDriver.RestartAfter = r.chromeRestartAfter
+		browserDriver.RemoteDebuggingURL = r.remoteDebuggingURL
+		if r.opts.recordFixture != "" {
+			browserDriver.FixtureRecorder = fixture.NewRecorder(recipe.Supplier, recipe.Version)
+		}
+		return browserDriver
+	case "client":
+		clientDriver := browser.NewClientAuthBrowserDriver(recipeCtx, r.logger, r.httpClient, r.responseCache, recipeCredentials, r.buchhalterConfigDirectory, r.buchhalterDocumentsDirectory, documentArchive)
+		clientDriver.ChromeVersion = ChromeVersion
+		clientDriver.ChromePath = r.chromePath
+		clientDriver.ShredTemporaryFiles = r.shredTemporaryFiles
+		clientDriver.Oauth2Variables = r.oauth2Variables[recipe.Supplier]
+		clientDriver.TokenProfile = r.tokenProfile
+		clientDriver.AuditLog = r.auditLog
+		clientDriver.ListOnly = r.opts.listOnly
+		return clientDriver
+	case "fints":
+		fintsDriver := fints.NewFinTSDriver(recipeCtx, r.logger, r.httpClient, recipeCredentials, r.buchhalterDocumentsDirectory, documentArchive)
+		fintsDriver.ProductID = viper.GetString("buchhalter_fints_product_id")
+		fintsDriver.ProductVersion = cliVersion
+		fintsDriver.TanMedium = viper.GetString("buchhalter_fints_tan_medium")
+		fintsDriver.ShredTemporaryFiles = r.shredTemporaryFiles
+		fintsDriver.AuditLog = r.auditLog
+		return fintsDriver
+	case "ebics":
+		ebicsDriver := ebics.NewEBICSDriver(recipeCtx, r.logger, r.httpClient, keychain.New(), r.buchhalterDocumentsDirectory, documentArchive)
+		ebicsDriver.Product = "buchhalter-cli " + cliVersion
+		ebicsDriver.ShredTemporaryFiles = r.shredTemporaryFiles
+		ebicsDriver.AuditLog = r.auditLog
+		return ebicsDriver
+	}
+	return nil
+}
+
+// finishRun reports the end of the run and processes the downloaded documents (conversion, uploads, deliveries).
+// The usage metrics are sent at the end.
+func (r *syncRun) finishRun(ctx context.Context) {
+	p := r.p
+	logger := r.logger
+
+	// Nothing was downloaded, so there is nothing to process
+	if r.opts.listOnly {
+		p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Documents listed, nothing was downloaded"),
+			hasError: false,
+		})
+		p.Send(viewMsgQuit{})
+		return
+	}
+
+	if r.runID != "" {
+		runStatus := repository.RUN_STATUS_COMPLETED
+		for _, rdx := range RunData {
+			if rdx.LastErrorMessage != "" {
+				runStatus = repository.RUN_STATUS_FAILED
+			}
+		}
+		var credentialAccess audit.Counts
+		if viper.GetBool("buchhalter_audit_report") {
+			credentialAccess = r.auditLog.Counts()
+		}
+		err := r.buchhalterAPIClient.ReportRunEnd(context.WithoutCancel(ctx), r.runID, runStatus, credentialAccess)
+		if err != nil {
+			logger.Error("Error reporting run end to Buchhalter API", "error", err)
+		}
+	}
+
+	r.historyRun.Duration = time.Since(r.historyRun.StartedAt).Seconds()
+	runHistory := history.NewRunHistory(logger, viper.GetString("buchhalter_directory"))
+	err := runHistory.AddRun(r.historyRun)
+	if err != nil {
+		logger.Error("Error writing run history", "error", err)
+	}
+	checkDocumentAnomalies(p, logger, runHistory, r.archives)
+
+	if r.controlServer.Aborted() {
+		p.Send(viewMsgStatusUpdate{
+			title:      i18n.T("Run aborted"),
+			hasError:   true,
+			shouldQuit: true,
+		})
+		return
+	}
+
+	// Convert first, so that the PDF/A copies are uploaded and delivered like the originals
+	if viper.GetBool("buchhalter_pdfa_conversion") {
+		p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Converting documents to PDF/A ..."),
+			hasError: false,
+		})
+		convertToPdfA(p, logger, r.archives, r.historyRun)
+	}
+
+	if tsaURL := viper.GetString("buchhalter_tsa_url"); tsaURL != "" {
+		p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Timestamping documents ..."),
+			hasError: false,
+		})
+		timestampDocuments(ctx, p, logger, r.httpClient, tsaURL, r.archives)
+	}
+
+	writeManifests(p, logger, r.archives, r.recipeParser, r.historyRun)
+
+	r.uploadDocuments(ctx)
+
+	if r.remoteArchive != nil {
+		p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Uploading documents to remote archive ..."),
+			hasError: false,
+		})
+		uploaded, err := r.remoteArchive.Upload(r.defaultArchive)
+		if err != nil {
+			logger.Error("Error uploading documents to remote archive", "error", err)
+			p.Send(viewMsgStatusUpdate{
+				title:      i18n.Tf("Uploading documents to remote archive: %s", err),
+				hasError:   true,
+				shouldQuit: false,
+			})
+		} else {
+			logger.Info("Uploading documents to remote archive ... completed", "uploaded", uploaded)
+		}
+	}
+
+	if viper.GetBool("archive.git.enabled") {
+		p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Committing documents to git ..."),
+			hasError: false,
+		})
+		commitArchives(p, logger, r.archives, r.historyRun)
+	}
+
+	if viper.GetString("buchhalter_paperless_host") != "" {
+		p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Pushing documents to Paperless-ngx ..."),
+			hasError: false,
+		})
+		pushToPaperless(ctx, p, logger, r.httpClient, r.archives)
+	}
+
+	if documentSinkURL := viper.GetString("buchhalter_document_sink_url"); documentSinkURL != "" {
+		p.Send(viewMsgStatusUpdate{
+			title:    i18n.T("Delivering documents to document sink ..."),
+			hasError: false,
+		})
+		documentSinkSecret := viper.GetString("buchhalter_document_sink_secret")
+		redact.AddSecrets(documentSinkSecret)
+		documentSink := webhook.NewDocumentSink(logger, r.httpClient, documentSinkURL, documentSinkSecret, r.buchhalterConfigDirectory)
+		delivered, err := documentSink.DeliverAll(ctx, r.archives.GetFileIndex())
+		if err != nil {
+			logger.Error("Error delivering documents to document sink", "delivered", delivered, "error", err)
+			p.Send(viewMsgStatusUpdate{
+				title:      i18n.Tf("Delivering documents to document sink: %s", httpclient.GetHumanReadableErrorMessage(err)),
+				hasError:   true,
+				shouldQuit: false,
+			})
+		} else {
+			logger.Info("Delivering documents to document sink ... completed", "delivered", delivered)
+		}
+	}
+
+	if webhookURL := viper.GetString("buchhalter_webhook_url"); webhookURL != "" {
+		sendWebhookEvents(ctx, p, logger, r.httpClient, webhookURL, r.archives, r.historyRun)
+	}
+
+	r.sendRunMetrics(ctx)
+}
+
+// uploadDocuments uploads the documents of the archive to the Buchhalter API, if the user has a premium subscription.
+func (r *syncRun) uploadDocuments(ctx context.Context) {
+	logger := r.logger
+	supplier := r.opts.supplier
+	premiumUser := r.user != nil && len(r.user.User.ID) > 0
+	if r.opts.noUpload {
+		logger.Info("Skipping document upload to Buchhalter API due to --no-upload flag")
+		premiumUser = false
+	}
+	uploadOptions := repository.UploadOptions{
+		BandwidthLimit: viper.GetInt64("buchhalter_upload_bandwidth_limit"),
+	}
+	if premiumUser {
+		var err error
+		buchhalterConfig := repository.NewBuchhalterConfig(logger, r.buchhalterConfigDirectory)
+		uploadOptions.EncryptionKey, err = buchhalterConfig.GetOrCreateUploadKey()
+		if err != nil {
+			logger.Error("Error reading upload encryption key", "error", err)
+			premiumUser = false
+		}
+	}
+	if !premiumUser {
+		logger.Info("Skipping document upload to Buchhalter API due to missing premium subscription")
+		return
+	}
+
+	uiDocumentUploadMessage := "Uploading documents to Buchhalter API ..."
+	if len(supplier) > 0 {
+		uiDocumentUploadMessage = fmt.Sprintf("Uploading documents of supplier %s to Buchhalter API ...", supplier)
+	}
+	r.p.Send(viewMsgStatusUpdate{
+		title:    uiDocumentUploadMessage,
+		hasError: false,
+	})
+	fileIndex := r.archives.GetFileIndex()
+	for fileChecksum, fileInfo := range fileIndex {
+		// Rejected documents are kept in the trash only
+		if fileInfo.Rejected {
+			continue
+		}
+
+		// If the user is only working on a specific supplier, skip the upload of documents for other suppliers
+		if len(supplier) > 0 && fileInfo.Supplier != supplier {
+			logger.Info("Skipping document upload to Buchhalter API due to mismatch in supplier", "file", fileInfo.Path, "selected_supplier", supplier, "file_supplier", fileInfo.Supplier)
+			continue
+		}
+
+		logger.Info("Uploading document to Buchhalter API ...", "file", fileInfo.Path, "checksum", fileChecksum)
+		result, err := r.buchhalterAPIClient.DoesDocumentExist(ctx, fileChecksum)
+		if err != nil {
+			// TODO Implement better error handling
+			logger.Error("Error checking if document exists already in Buchhalter API", "file", fileInfo.Path, "checksum", fileChecksum, "error", err)
+			continue
+		}
+		// If the file exists already, skip it
+		if result {
+			logger.Info("Uploading document to Buchhalter API ... exists already", "file", fileInfo.Path, "checksum", fileChecksum)
+			continue
+		}
+		logger.Info("Uploading document to Buchhalter API ... does not exist already", "file", fileInfo.Path, "checksum", fileChecksum)
+
+		err = r.buchhalterAPIClient.UploadDocumentChunked(ctx, fileInfo.Path, fileChecksum, fileInfo.Supplier, fileInfo.Tags, uploadOptions)
+		if err != nil {
+			// TODO Implement better error handling
+			logger.Error("Error uploading document to Buchhalter API", "file", fileInfo.Path, "supplier", fileInfo.Supplier, "error", err)
+			continue
+		}
+	}
+}
+
+// sendRunMetrics stores the usage metrics of the run and sends them, writes them to the local metrics file or asks the
+// user for consent to send them.
+func (r *syncRun) sendRunMetrics(ctx context.Context) {
+	p := r.p
+	logger := r.logger
+	runMetrics := currentRunMetrics(r.vaultProvider.Version, r.recipeParser.OicdbVersion)
+	err := repository.SaveRunMetrics(filepath.Join(viper.GetString("buchhalter_directory"), repository.LAST_RUN_METRICS_FILE_NAME), runMetrics)
+	if err != nil {
+		logger.Error("Error storing usage metrics of the run", "error", err)
+	}
+
+	alwaysSendMetrics := viper.GetBool("buchhalter_always_send_metrics")
+	if metricsFile := viper.GetString("buchhalter_metrics_file"); metricsFile != "" {
+		// Local-only mode: the metrics never leave the machine, so there is nothing to ask for
+		logger.Info("Writing usage metrics to local metrics file", "file", metricsFile)
+		err = exportRunMetrics(runMetrics, metricsFile)
+		if err != nil {
+			logger.Error("Error writing usage metrics to local metrics file", "file", metricsFile, "error", err)
+			p.Send(viewMsgStatusUpdate{
+				title:      i18n.Tf("Writing usage metrics to %s", metricsFile),
+				hasError:   true,
+				shouldQuit: false,
+			})
+		}
+
+		p.Send(viewMsgQuit{})
+
+	} else if !r.developmentMode && alwaysSendMetrics {
+		logger.Info("Sending usage metrics to Buchhalter API", "always_send_metrics", alwaysSendMetrics, "development_mode", r.developmentMode)
+		err = sendRunMetrics(ctx, r.buchhalterAPIClient, runMetrics)
+		if err != nil {
+			logger.Error("Error sending usage metrics to Buchhalter API", "error", err)
+			p.Send(viewMsgStatusUpdate{
+				title:      i18n.T("Sending usage metrics to Buchhalter API"),
+				hasError:   true,
+				shouldQuit: false,
+			})
+		}
+
+		p.Send(viewMsgQuit{})
+
+	} else if r.developmentMode {
+		p.Send(viewMsgQuit{})
+
+	} else {
+		p.Send(viewMsgModeUpdate{
+			mode:    "sendMetrics",
+			title:   i18n.T("Let's improve buchhalter-cli together!"),
+			details: i18n.T("Allow buchhalter-cli to send anonymized usage data to our api?"),
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var tagCmd = &cobra.Command{
+	Use:   "tag <document> [tags...]",
+	Short: "Adds tags to a document or lists its tags",
+	Long:  "The tag command adds tags (e.g. cost center or project) to a document, identified by its path or checksum. Without tags, the current tags of the document are listed.",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   RunTagCommand,
+}
+
+func init() {
+	tagCmd.Flags().BoolP("remove", "r", false, "remove the given tags instead of adding them")
+	rootCmd.AddCommand(tagCmd)
+}
+
+func RunTagCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	removeTags, err := cmd.Flags().GetBool("remove")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading remove flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	documentArchive := initializeDocumentArchive(logger)
+	err = documentArchive.BuildArchiveIndex(cmd.Context())
+	if err != nil {
+		logger.Error("Error building document archive index", "error", err)
+		exitMessage := fmt.Sprintf("Error building document archive index: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	checksum, ok := documentArchive.FindFile(cmdArgs[0])
+	if !ok {
+		fmt.Printf("Document %s not found in archive.\n", cmdArgs[0])
+		return
+	}
+
+	tags := cmdArgs[1:]
+	if len(tags) > 0 {
+		if removeTags {
+			err = documentArchive.RemoveTags(checksum, tags...)
+		} else {
+			err = documentArchive.AddTags(checksum, tags...)
+		}
+		if err != nil {
+			logger.Error("Error updating document tags", "document", cmdArgs[0], "tags", tags, "error", err)
+			exitMessage := fmt.Sprintf("Error updating document tags: %s", err)
+			exitWithLogo(exitMessage)
+		}
+	}
+
+	f, _ := documentArchive.GetFile(checksum)
+	fmt.Printf("%s: %s\n", f.Path, strings.Join(f.Tags, ", "))
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"buchhalter/lib/secrets"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var tokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "Inspects and clears cached OAuth2 tokens",
+}
+
+var tokensListCmd = &cobra.Command{
+	Use:   "list [supplier]",
+	Short: "Lists the cached OAuth2 tokens",
+	Long:  "The list command shows the cached OAuth2 tokens of all suppliers (or of the given supplier) with issuer, expiry and scopes. Token values are never shown.",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   RunTokensListCommand,
+}
+
+var tokensClearCmd = &cobra.Command{
+	Use:   "clear <supplier>",
+	Short: "Deletes the cached OAuth2 tokens of a supplier",
+	Long:  "The clear command deletes the cached OAuth2 tokens of all credentials of a supplier. The next sync logs in again.",
+	Args:  cobra.ExactArgs(1),
+	Run:   RunTokensClearCommand,
+}
+
+func init() {
+	tokensCmd.AddCommand(tokensListCmd)
+	tokensCmd.AddCommand(tokensClearCmd)
+	rootCmd.AddCommand(tokensCmd)
+}
+
+func RunTokensListCommand(cmd *cobra.Command, cmdArgs []string) {
+	supplier := ""
+	if len(cmdArgs) > 0 {
+		supplier = cmdArgs[0]
+	}
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	cachedTokens, err := secrets.ListOauth2Tokens(initializeTokenDirectory(logger))
+	if err != nil {
+		logger.Error("Error reading token cache", "error", err)
+		exitMessage := fmt.Sprintf("Error reading token cache: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	listed := 0
+	for _, cached := range cachedTokens {
+		if supplier != "" && cached.Supplier != supplier {
+			continue
+		}
+		listed++
+
+		issuer, scopes, kind := "unknown", "unknown", "opaque"
+		if claims, ok := secrets.ParseJWTClaims(cached.Tokens.AccessToken); ok {
+			kind = "JWT"
+			if claims.Issuer != "" {
+				issuer = claims.Issuer
+			}
+			if claims.Scope != "" {
+				scopes = claims.Scope
+			}
+		}
+		expiresAt := cached.Tokens.ExpiresAt()
+		expiry := "expires " + expiresAt.Format(time.RFC3339)
+		if time.Now().After(expiresAt) {
+			expiry = "expired " + expiresAt.Format(time.RFC3339)
+		}
+		refreshToken := "no refresh token"
+		if cached.Tokens.RefreshToken != "" {
+			refreshToken = "with refresh token"
+		}
+
+		fmt.Println(textStyleBold(fmt.Sprintf("%s (credential %s)", cached.Supplier, cached.CredentialId)))
+		fmt.Printf("  %s access token, %s, %s\n", kind, expiry, refreshToken)
+		fmt.Printf("  issuer: %s, scopes: %s\n", issuer, scopes)
+	}
+
+	if listed == 0 {
+		fmt.Println(textStyle("No cached OAuth2 tokens found."))
+	}
+}
+
+func RunTokensClearCommand(cmd *cobra.Command, cmdArgs []string) {
+	supplier := cmdArgs[0]
+
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	removed, err := secrets.DeleteOauth2Tokens(supplier, initializeTokenDirectory(logger))
+	if err != nil {
+		logger.Error("Error clearing cached tokens", "supplier", supplier, "error", err)
+		exitMessage := fmt.Sprintf("Error clearing cached tokens of %s: %s", supplier, err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Cleared cached tokens", "supplier", supplier, "removed", removed)
+
+	if removed == 0 {
+		fmt.Println(textStyle(fmt.Sprintf("No cached OAuth2 tokens found for %s.", supplier)))
+		return
+	}
+	fmt.Println(textStyle(fmt.Sprintf("Removed %d cached OAuth2 token(s) of %s. The next sync logs in again.", removed, supplier)))
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/repository"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Updates the Open Invoice Collector Database",
+	Long:  "The update command downloads updates of the Open Invoice Collector Database from the Buchhalter API or the mirrors in buchhalter_oicdb_mirrors. In air-gapped environments, `--from-file` imports a database file instead, which needs to be signed with one of the keys of the Buchhalter Platform or in buchhalter_oicdb_public_keys.",
+	Run:   RunUpdateCommand,
+}
+
+func init() {
+	updateCmd.Flags().String("from-file", "", "import the database from a file (e.g. oicdb.json) instead of downloading it")
+	updateCmd.Flags().String("signature", "", "signature file of the imported database (default: <file>.sig)")
+	rootCmd.AddCommand(updateCmd)
+}
+
+func RunUpdateCommand(cmd *cobra.Command, cmdArgs []string) {
+	// Init logging
+	buchhalterDirectory := viper.GetString("buchhalter_directory")
+	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
+	developmentMode := viper.GetBool("dev")
+	logSetting, err := cmd.Flags().GetBool("log")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading log flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger, err := initializeLogger(logSetting, developmentMode, buchhalterDirectory)
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error on initializing logging: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	logger.Info("Booting up", "development_mode", developmentMode)
+	defer logger.Info("Shutting down")
+
+	fromFile, err := cmd.Flags().GetString("from-file")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading from-file flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	signatureFile, err := cmd.Flags().GetString("signature")
+	if err != nil {
+		exitMessage := fmt.Sprintf("Error reading signature flag: %s", err)
+		exitWithLogo(exitMessage)
+	}
+
+	recipeParser := parser.NewRecipeParser(logger, buchhalterConfigDirectory, buchhalterDirectory)
+	if fromFile == "" {
+		updateOICDB(cmd.Context(), logger, recipeParser)
+	} else {
+		if signatureFile == "" {
+			signatureFile = fromFile + ".sig"
+		}
+		importOICDB(fromFile, signatureFile)
+		logger.Info("Open Invoice Collector Database imported", "file", fromFile, "signature", signatureFile)
+	}
+
+	_, err = recipeParser.LoadRecipes(developmentMode)
+	if err != nil {
+		logger.Error("Error loading recipes for suppliers", "error", err)
+		exitMessage := fmt.Sprintf("Error loading recipes for suppliers: %s", err)
+		exitWithLogo(exitMessage)
+	}
+	fmt.Println(textStyle(i18n.Tf("Open Invoice Collector Database version %s", recipeParser.OicdbVersion)))
+}
+
+// importOICDB replaces the local Open Invoice Collector Database with a signed database file.
+func importOICDB(databaseFile, signatureFile string) {
+	database, err := os.ReadFile(databaseFile)
+	if err != nil {
+		exitWithLogo(fmt.Sprintf("Error reading database file: %s", err))
+	}
+	signature, err := os.ReadFile(signatureFile)
+	if err != nil {
+		exitWithLogo(fmt.Sprintf("Error reading signature file: %s", err))
+	}
+
+	buchhalterConfigDirectory := viper.GetString("buchhalter_config_directory")
+	err = parser.ValidateDatabase(buchhalterConfigDirectory, database)
+	if err != nil {
+		exitWithLogo(fmt.Sprintf("Error validating database file: %s", err))
+	}
+	err = repository.ImportOpenInvoiceCollectorDB(buchhalterConfigDirectory, database, signature, viper.GetStringSlice("buchhalter_oicdb_public_keys"))
+	if err != nil {
+		exitWithLogo(fmt.Sprintf("Error importing database file: %s", err))
+	}
+}
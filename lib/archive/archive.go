@@ -1,7 +1,9 @@
 package archive
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,71 +12,161 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+)
+
+const indexFileName = "_index.json"
+
+const (
+	// VERIFICATION_CHECKSUM marks documents matching the checksum announced by the supplier
+	VERIFICATION_CHECKSUM = "checksum"
+	// VERIFICATION_SIZE marks documents matching the size announced by the supplier, if no checksum was announced
+	VERIFICATION_SIZE = "size"
 )
 
 type DocumentArchive struct {
 	logger *slog.Logger
 
 	storageDirectory string
-	fileIndex        map[string]File
+	stagingDirectory string
+	layout           string
+	defaultTags      map[string][]string
+	readOnly         bool
+	index            *fileIndex
+	closeOnce        sync.Once
+	remoteIndex      map[string]File
+	// sourcesMutex serializes the changes of the sources file (see AddSource)
+	sourcesMutex sync.Mutex
 }
 
 type File struct {
-	Path     string
-	Supplier string
+	Path     string    `json:"path"`
+	Supplier string    `json:"supplier"`
+	Staged   bool      `json:"staged,omitempty"`
+	AddedAt  time.Time `json:"addedAt,omitempty"`
+	Reviewed bool      `json:"reviewed,omitempty"`
+	Rejected bool      `json:"rejected,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	// Verification of the download against the supplier (see VERIFICATION_* constants), empty if the supplier announced nothing
+	Verification string `json:"verification,omitempty"`
+	// Metadata scraped from the document listing of the supplier portal (optional)
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// TrashedAt and OriginalPath are set for documents in the trash (see TrashFile)
+	TrashedAt    time.Time `json:"trashedAt,omitempty"`
+	OriginalPath string    `json:"originalPath,omitempty"`
 }
 
-func NewDocumentArchive(logger *slog.Logger, archiveDirectory string) *DocumentArchive {
-	return &DocumentArchive{
+// NewDocumentArchive creates a new document archive.
+// New documents are stored in `layout` (see LAYOUT_* constants) below the archive directory.
+// If a staging directory is set, new documents are stored there first until they are reviewed.
+// New documents are tagged with the default tags of their supplier.
+func NewDocumentArchive(logger *slog.Logger, archiveDirectory, layout, stagingDirectory string, defaultTags map[string][]string) *DocumentArchive {
+	if layout == "" {
+		layout = LAYOUT_SUPPLIER
+	}
+
+	a := &DocumentArchive{
 		logger:           logger,
 		storageDirectory: archiveDirectory,
-
-		fileIndex: map[string]File{},
+		stagingDirectory: stagingDirectory,
+		layout:           layout,
+		defaultTags:      defaultTags,
 	}
+	a.index = newFileIndex(a.indexDirectory)
+
+	return a
 }
 
-func (a *DocumentArchive) BuildArchiveIndex() error {
+// BuildArchiveIndex hashes all documents of the archive. It stops with the error of ctx when ctx is done, e.g. on SIGINT.
+func (a *DocumentArchive) BuildArchiveIndex(ctx context.Context) error {
+	// The persisted index keeps the metadata (e.g. the supplier) that can't be derived from the file path in every layout
+	persistedIndex, err := a.readIndexFile()
+	if err != nil {
+		return fmt.Errorf("error reading the archive index file: %w", err)
+	}
+
 	// Iterate over all files in the archive directory and build an index with all existing file hashes.
 	// This index will be used to detect if a downloaded invoice/file is new or already exists.
-	err := filepath.Walk(a.storageDirectory, func(filePath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Exclude `_local` directory
-		localDir := fmt.Sprintf("%s%s_local", a.storageDirectory, string(os.PathSeparator))
-		if strings.Contains(filePath, localDir) {
-			return nil
+	files := map[string]File{}
+	directories := []string{a.storageDirectory}
+	if a.stagingDirectory != "" {
+		directories = append(directories, a.stagingDirectory)
+	}
+	for _, directory := range directories {
+		if _, err := os.Stat(directory); errors.Is(err, os.ErrNotExist) {
+			continue
 		}
 
-		// Exclude directories, hidden files and log files
-		if !info.IsDir() && info.Name()[0:1] != "_" && info.Name()[0:1] != "." && path.Ext(info.Name()) != ".log" {
-			hash, err := computeHash(filePath)
+		err := filepath.Walk(directory, func(filePath string, info os.FileInfo, err error) error {
 			if err != nil {
-				return fmt.Errorf("error computing hash for %s: %w", filePath, err)
+				return err
 			}
-			a.fileIndex[hash] = File{
-				Path:     filePath,
-				Supplier: a.determineSupplierFromPath(filePath),
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
+
+			// Exclude hidden directories (e.g. `.git`) and run manifests
+			if info.IsDir() && filePath != directory && (info.Name()[0:1] == "." || info.Name() == manifestDirectoryName) {
+				return filepath.SkipDir
+			}
+
+			// Exclude `_local` directory
+			localDir := fmt.Sprintf("%s%s_local", directory, string(os.PathSeparator))
+			if strings.Contains(filePath, localDir) {
+				return nil
+			}
+
+			// Exclude directories, hidden files, log files and RFC 3161 timestamp tokens of documents (see lib/timestamp)
+			if !info.IsDir() && info.Name()[0:1] != "_" && info.Name()[0:1] != "." && path.Ext(info.Name()) != ".log" && path.Ext(info.Name()) != ".tsr" {
+				hash, err := computeHash(filePath)
+				if err != nil {
+					return fmt.Errorf("error computing hash for %s: %w", filePath, err)
+				}
+				f, ok := persistedIndex[hash]
+				if !ok || f.Path != filePath {
+					// Documents we haven't downloaded ourselves don't need a review
+					f = File{
+						Path:     filePath,
+						Supplier: a.determineSupplierFromPath(filePath),
+						AddedAt:  info.ModTime(),
+						Reviewed: true,
+					}
+				}
+				f.Staged = directory == a.stagingDirectory
+				files[hash] = f
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error walking the directory: %w", err)
 		}
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("error walking the directory: %w", err)
 	}
 
-	a.logger.Info("Building document archive index ... completed", "files_in_index", len(a.fileIndex))
+	a.logger.Info("Building document archive index ... completed", "files_in_index", len(files))
 
-	return nil
+	return a.index.replace(files, "")
 }
 
+// Close stops the writer of the index, later changes of the archive fail.
+// Long-running processes (e.g. buchhalter serve) close the archives they don't need anymore.
+func (a *DocumentArchive) Close() {
+	a.closeOnce.Do(a.index.close)
+}
+
+// FileExists returns true if the document at filePath is in the archive already. Safe for concurrent use.
 func (a *DocumentArchive) FileExists(filePath string) bool {
 	hash, _ := computeHash(filePath)
 	return a.fileHashExists(hash)
 }
 
-func (a *DocumentArchive) AddFile(filePath string) error {
+// AddFile adds the document at filePath to the index. Safe for concurrent use.
+func (a *DocumentArchive) AddFile(filePath, supplier string) error {
+	return a.AddVerifiedFile(filePath, supplier, "")
+}
+
+// AddVerifiedFile adds a document that was verified against the checksum or size announced by the supplier.
+func (a *DocumentArchive) AddVerifiedFile(filePath, supplier, verification string) error {
 	// Right now, we overwrite the file if it exists already
 	// if a.fileHashExists(filePath) {
 	// 	return fmt.Errorf("file %s already exists in archive", filePath)
@@ -85,11 +177,14 @@ func (a *DocumentArchive) AddFile(filePath string) error {
 		return err
 	}
 
-	a.fileIndex[hash] = File{
-		Path:     filePath,
-		Supplier: a.determineSupplierFromPath(filePath),
-	}
-	return nil
+	return a.index.put(hash, File{
+		Path:         filePath,
+		Supplier:     supplier,
+		Staged:       a.stagingDirectory != "" && strings.HasPrefix(filePath, a.stagingDirectory),
+		AddedAt:      time.Now(),
+		Tags:         a.defaultTags[supplier],
+		Verification: verification,
+	})
 }
 
 func computeHash(filePath string) (string, error) {
@@ -127,19 +222,82 @@ func (a *DocumentArchive) fileHashExists(hash string) bool {
 		return false
 	}
 
-	if _, ok := a.fileIndex[hash]; ok {
+	if _, ok := a.index.get(hash); ok {
+		return true
+	}
+	if _, ok := a.remoteIndex[hash]; ok {
 		return true
 	}
 
 	return false
 }
 
+// SetRemoteIndex sets the index of the remote copy of the archive (see RemoteArchive).
+// Documents of the remote index are not downloaded again, even if they are missing locally.
+func (a *DocumentArchive) SetRemoteIndex(remoteIndex map[string]File) {
+	a.remoteIndex = remoteIndex
+}
+
+// Directory returns the archive directory.
+func (a *DocumentArchive) Directory() string {
+	return a.storageDirectory
+}
+
+// StagingDirectory returns the directory new documents are stored in until they are reviewed, empty without staging.
+func (a *DocumentArchive) StagingDirectory() string {
+	return a.stagingDirectory
+}
+
+// GetFileIndex returns a snapshot of the index, it must not be modified.
 func (a *DocumentArchive) GetFileIndex() map[string]File {
-	return a.fileIndex
+	return a.index.files()
+}
+
+func (a *DocumentArchive) readIndexFile() (map[string]File, error) {
+	index, err := readIndexFileFrom(a.storageDirectory)
+	if err != nil || !a.readOnly {
+		return index, err
+	}
+
+	// In read-only mode, the metadata of staged documents and local changes are kept in the index of the staging directory
+	stagingIndex, err := readIndexFileFrom(a.stagingDirectory)
+	for hash, f := range stagingIndex {
+		index[hash] = f
+	}
+	return index, err
+}
+
+// readIndexFileFrom reads the index of directory: the index file and the changes of the journal not merged into it yet.
+func readIndexFileFrom(directory string) (map[string]File, error) {
+	index, err := readIndex(filepath.Join(directory, indexFileName))
+	if err != nil {
+		return index, err
+	}
+
+	entries, _, err := readJournal(filepath.Join(directory, journalFileName))
+	applyJournal(index, entries)
+	return index, err
+}
+
+func readIndex(filePath string) (map[string]File, error) {
+	index := map[string]File{}
+
+	fileContent, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+
+	err = json.Unmarshal(fileContent, &index)
+	return index, err
 }
 
-func (a *DocumentArchive) determineSupplierFromPath(filePath string) string {
-	p := path.Dir(filePath)
-	_, file := filepath.Split(p)
-	return file
+// indexDirectory returns the directory the index is persisted in, the staging directory of read-only archives.
+func (a *DocumentArchive) indexDirectory() string {
+	if a.readOnly {
+		return a.stagingDirectory
+	}
+	return a.storageDirectory
 }
@@ -0,0 +1,114 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DEFAULT_ARCHIVE is the name of the archive in the documents directory, it stores the documents of all suppliers without a named archive.
+const DEFAULT_ARCHIVE = "default"
+
+// Archives routes the documents of suppliers to named archives with their own directory and index,
+// e.g. to separate private and company invoices.
+type Archives struct {
+	archives          map[string]*DocumentArchive
+	archiveBySupplier map[string]string
+	routeAllTo        string
+}
+
+func NewArchives(defaultArchive *DocumentArchive) *Archives {
+	return &Archives{
+		archives:          map[string]*DocumentArchive{DEFAULT_ARCHIVE: defaultArchive},
+		archiveBySupplier: map[string]string{},
+	}
+}
+
+// Add adds the named archive storing the documents of suppliers.
+func (a *Archives) Add(name string, documentArchive *DocumentArchive, suppliers []string) error {
+	if _, ok := a.archives[name]; ok {
+		return fmt.Errorf("archive %s is defined twice", name)
+	}
+	for _, supplier := range suppliers {
+		if other, ok := a.archiveBySupplier[supplier]; ok {
+			return fmt.Errorf("supplier %s is assigned to archive %s and %s", supplier, other, name)
+		}
+		a.archiveBySupplier[supplier] = name
+	}
+	a.archives[name] = documentArchive
+
+	return nil
+}
+
+// Get returns the archive with the given name.
+func (a *Archives) Get(name string) (*DocumentArchive, bool) {
+	documentArchive, ok := a.archives[name]
+	return documentArchive, ok
+}
+
+// RouteAllTo stores the documents of all suppliers in the named archive, regardless of the configured suppliers.
+func (a *Archives) RouteAllTo(name string) error {
+	if _, ok := a.archives[name]; !ok {
+		return fmt.Errorf("archive %s is not defined (defined: %s)", name, strings.Join(a.Names(), ", "))
+	}
+	a.routeAllTo = name
+
+	return nil
+}
+
+// NameOfSupplier returns the name of the archive storing the documents of supplier.
+func (a *Archives) NameOfSupplier(supplier string) string {
+	if a.routeAllTo != "" {
+		return a.routeAllTo
+	}
+	if name, ok := a.archiveBySupplier[supplier]; ok {
+		return name
+	}
+	return DEFAULT_ARCHIVE
+}
+
+// ForSupplier returns the archive storing the documents of supplier.
+func (a *Archives) ForSupplier(supplier string) *DocumentArchive {
+	return a.archives[a.NameOfSupplier(supplier)]
+}
+
+// Names returns the names of all archives, sorted.
+func (a *Archives) Names() []string {
+	names := make([]string, 0, len(a.archives))
+	for name := range a.archives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuildArchiveIndex builds the index of all archives.
+func (a *Archives) BuildArchiveIndex(ctx context.Context) error {
+	for _, name := range a.Names() {
+		if err := a.archives[name].BuildArchiveIndex(ctx); err != nil {
+			return fmt.Errorf("archive %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// GetFileIndex returns the files of all archives by their hash.
+func (a *Archives) GetFileIndex() map[string]File {
+	fileIndex := map[string]File{}
+	for _, documentArchive := range a.archives {
+		for hash, f := range documentArchive.GetFileIndex() {
+			fileIndex[hash] = f
+		}
+	}
+
+	return fileIndex
+}
+
+// Close closes all archives, see DocumentArchive.Close.
+func (a *Archives) Close() {
+	for _, documentArchive := range a.archives {
+		documentArchive.Close()
+	}
+}
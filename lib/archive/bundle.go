@@ -0,0 +1,82 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BundleFiles writes the files into a zip file (one directory per supplier) along with additional files (e.g. a report).
+// Rejected files are skipped.
+func BundleFiles(outputFile string, files map[string]File, additionalFiles map[string][]byte) error {
+	outFile, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	zipWriter := zip.NewWriter(outFile)
+	for name, content := range additionalFiles {
+		w, err := zipWriter.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		if err != nil {
+			return err
+		}
+	}
+
+	checksums := make([]string, 0, len(files))
+	for checksum := range files {
+		checksums = append(checksums, checksum)
+	}
+	sort.Strings(checksums)
+
+	names := map[string]bool{}
+	for _, checksum := range checksums {
+		f := files[checksum]
+		if f.Rejected {
+			continue
+		}
+		supplier := f.Supplier
+		if supplier == "" {
+			supplier = "unknown"
+		}
+		name := supplier + "/" + filepath.Base(f.Path)
+		if names[name] {
+			// Different documents with the same file name
+			name = supplier + "/" + checksum[:min(8, len(checksum))] + "-" + filepath.Base(f.Path)
+		}
+		names[name] = true
+
+		err = addFileToZip(zipWriter, name, f.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		return err
+	}
+	return outFile.Close()
+}
+
+func addFileToZip(zipWriter *zip.Writer, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := zipWriter.Create(strings.ReplaceAll(name, "\\", "/"))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
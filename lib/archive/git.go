@@ -0,0 +1,108 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitIgnore keeps temporary downloads and logs out of the repository
+const gitIgnore = "_tmp/\n*.log\n"
+
+// GitArchive versions an archive directory in a git repository.
+type GitArchive struct {
+	logger *slog.Logger
+
+	directory string
+	lfs       bool
+}
+
+// NewGitArchive creates a git repository for the archive directory.
+// If lfs is set, PDF documents are stored with Git LFS.
+func NewGitArchive(logger *slog.Logger, directory string, lfs bool) *GitArchive {
+	return &GitArchive{
+		logger:    logger,
+		directory: directory,
+		lfs:       lfs,
+	}
+}
+
+// Init initializes the repository if the archive directory is not a git repository yet.
+func (g *GitArchive) Init() error {
+	if _, err := os.Stat(filepath.Join(g.directory, ".git")); err == nil {
+		return nil
+	}
+
+	g.logger.Info("Initializing git repository of archive", "directory", g.directory, "lfs", g.lfs)
+	_, err := g.run("init")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(g.directory, ".gitignore"), []byte(gitIgnore), 0644)
+	if err != nil {
+		return err
+	}
+	if g.lfs {
+		_, err = g.run("lfs", "install", "--local")
+		if err != nil {
+			return fmt.Errorf("git lfs is not available (disable it in archive.git.lfs): %w", err)
+		}
+		_, err = g.run("lfs", "track", "*.pdf", "*.PDF")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Commit commits all changes of the archive directory (new documents and index updates).
+// If the repository has a remote, the commit is pushed.
+// Returns false if there was nothing to commit.
+func (g *GitArchive) Commit(message string) (bool, error) {
+	_, err := g.run("add", "--all")
+	if err != nil {
+		return false, err
+	}
+	status, err := g.run("status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	if status == "" {
+		return false, nil
+	}
+	_, err = g.run("commit", "--quiet", "--message", message)
+	if err != nil {
+		return false, err
+	}
+
+	remotes, err := g.run("remote")
+	if err != nil || remotes == "" {
+		return true, err
+	}
+	g.logger.Info("Pushing git repository of archive", "directory", g.directory)
+	_, err = g.run("push", "--quiet")
+	if err != nil {
+		return true, fmt.Errorf("committed, but push failed: %w", err)
+	}
+
+	return true, nil
+}
+
+func (g *GitArchive) run(args ...string) (string, error) {
+	// #nosec G204
+	output, err := exec.Command("git", append([]string{"-C", g.directory}, args...)...).CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(output)))
+		}
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
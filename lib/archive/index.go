@@ -0,0 +1,339 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"buchhalter/lib/utils"
+)
+
+const (
+	journalFileName = "_index.journal"
+	// journalCompactionThreshold is the number of journal entries after which the journal is merged into the index file
+	journalCompactionThreshold = 256
+)
+
+var errIndexClosed = errors.New("the archive is closed")
+
+// journalEntry is a change of the index: the new state of a document or its removal (File is nil).
+// Entries carry the full state of the document, so replaying a journal twice has the same result.
+type journalEntry struct {
+	Checksum string `json:"checksum"`
+	File     *File  `json:"file,omitempty"`
+}
+
+// fileIndex is the index of the documents of an archive, safe for concurrent use (e.g. by recipes running in parallel).
+// Readers get immutable snapshots of the index. All changes are applied by a single writer goroutine: it appends them to
+// a write-ahead journal (_index.journal) before they become visible, so a crash loses no acknowledged change and never
+// leaves a half written index file (_index.json) behind.
+type fileIndex struct {
+	snapshot atomic.Pointer[map[string]File]
+	writes   chan indexWrite
+	closing  chan struct{}
+	stopped  chan struct{}
+	// directory returns the directory the index is persisted in
+	directory func() string
+
+	// The journal is only accessed by the writer goroutine
+	journal          journalFile
+	journalDirectory string
+	journalEntries   int
+	// journalSize is the length of the journal, the offset of the next entries
+	journalSize int64
+}
+
+// journalFile is the opened journal, an *os.File outside tests.
+type journalFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// indexWrite is a change sent to the writer goroutine: journal entries or, with replace set, a new index.
+type indexWrite struct {
+	entries   []journalEntry
+	replace   map[string]File
+	directory string
+	done      chan error
+}
+
+func newFileIndex(directory func() string) *fileIndex {
+	x := &fileIndex{
+		writes:    make(chan indexWrite),
+		closing:   make(chan struct{}),
+		stopped:   make(chan struct{}),
+		directory: directory,
+	}
+	files := map[string]File{}
+	x.snapshot.Store(&files)
+
+	go x.run()
+
+	return x
+}
+
+// files returns a snapshot of the index. It must not be modified, changes go through put, update and replace.
+func (x *fileIndex) files() map[string]File {
+	return *x.snapshot.Load()
+}
+
+// copy returns a modifiable copy of the index.
+func (x *fileIndex) copy() map[string]File {
+	files := x.files()
+	c := make(map[string]File, len(files))
+	for checksum, f := range files {
+		c[checksum] = f
+	}
+	return c
+}
+
+func (x *fileIndex) get(checksum string) (File, bool) {
+	f, ok := x.files()[checksum]
+	return f, ok
+}
+
+// put stores the document with checksum in the index.
+func (x *fileIndex) put(checksum string, f File) error {
+	return x.update(journalEntry{Checksum: checksum, File: &f})
+}
+
+// update applies entries to the index. It returns once they are written to the journal.
+func (x *fileIndex) update(entries ...journalEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return x.write(indexWrite{entries: entries})
+}
+
+// replace replaces the index with files and writes it as index file into directory (the directory of the index if
+// empty). The index takes ownership of files.
+func (x *fileIndex) replace(files map[string]File, directory string) error {
+	return x.write(indexWrite{replace: files, directory: directory})
+}
+
+func (x *fileIndex) write(w indexWrite) error {
+	w.done = make(chan error, 1)
+	select {
+	case x.writes <- w:
+		return <-w.done
+	case <-x.closing:
+		return errIndexClosed
+	}
+}
+
+// close stops the writer goroutine and closes the journal, later changes fail with errIndexClosed.
+func (x *fileIndex) close() {
+	close(x.closing)
+	<-x.stopped
+}
+
+func (x *fileIndex) run() {
+	defer close(x.stopped)
+	defer x.closeJournal()
+
+	for {
+		select {
+		case w := <-x.writes:
+			if w.replace != nil {
+				w.done <- x.applyReplace(w.replace, w.directory)
+				continue
+			}
+			w.done <- x.applyEntries(w.entries)
+		case <-x.closing:
+			return
+		}
+	}
+}
+
+func (x *fileIndex) applyEntries(entries []journalEntry) error {
+	directory := x.directory()
+	err := x.openJournal(directory)
+	if err != nil {
+		return err
+	}
+
+	var lines bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		lines.Write(line)
+		lines.WriteByte('\n')
+	}
+	offset := x.journalSize
+	_, err = x.journal.Write(lines.Bytes())
+	if err == nil {
+		err = x.journal.Sync()
+	}
+	if err != nil {
+		// Drop the partially written entries, they are not applied and must not be replayed either
+		truncateErr := x.journal.Truncate(offset)
+		if truncateErr == nil {
+			truncateErr = x.journal.Sync()
+		}
+		if truncateErr != nil {
+			x.closeJournal()
+			return errors.Join(err, truncateErr)
+		}
+		return err
+	}
+
+	files := x.copy()
+	applyJournal(files, entries)
+	x.snapshot.Store(&files)
+
+	x.journalSize += int64(lines.Len())
+	x.journalEntries += len(entries)
+	if x.journalEntries >= journalCompactionThreshold {
+		return x.writeIndex(files, directory)
+	}
+	return nil
+}
+
+func (x *fileIndex) applyReplace(files map[string]File, directory string) error {
+	x.snapshot.Store(&files)
+	if directory == "" {
+		directory = x.directory()
+	}
+	return x.writeIndex(files, directory)
+}
+
+// writeIndex writes files as index file into directory and truncates the journal of the directory.
+// The index file is replaced atomically, a crash leaves either the old or the new index file behind.
+func (x *fileIndex) writeIndex(files map[string]File, directory string) error {
+	fileContent, err := json.MarshalIndent(files, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	err = utils.CreateDirectoryIfNotExists(directory)
+	if err != nil {
+		return err
+	}
+	temporaryFile, err := os.CreateTemp(directory, indexFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+	_, err = temporaryFile.Write(fileContent)
+	if err == nil {
+		err = temporaryFile.Sync()
+	}
+	if closeErr := temporaryFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temporaryFile.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temporaryFile.Name(), filepath.Join(directory, indexFileName))
+	}
+	if err != nil {
+		return err
+	}
+
+	// The index file contains all entries of the journal now
+	if x.journal != nil && x.journalDirectory == directory {
+		x.journalEntries = 0
+		x.journalSize = 0
+		return x.journal.Truncate(0)
+	}
+	err = os.Truncate(filepath.Join(directory, journalFileName), 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// openJournal opens the journal of directory for appending. The torn tail of a crashed write is cut off first.
+func (x *fileIndex) openJournal(directory string) error {
+	if x.journal != nil && x.journalDirectory == directory {
+		return nil
+	}
+	x.closeJournal()
+
+	err := utils.CreateDirectoryIfNotExists(directory)
+	if err != nil {
+		return err
+	}
+	journalPath := filepath.Join(directory, journalFileName)
+	entries, validLength, err := readJournal(journalPath)
+	if err != nil {
+		return err
+	}
+	journal, err := os.OpenFile(journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	err = journal.Truncate(validLength)
+	if err != nil {
+		journal.Close()
+		return err
+	}
+
+	x.journal = journal
+	x.journalDirectory = directory
+	x.journalEntries = len(entries)
+	x.journalSize = validLength
+	return nil
+}
+
+func (x *fileIndex) closeJournal() {
+	if x.journal != nil {
+		x.journal.Close()
+	}
+	x.journal = nil
+	x.journalDirectory = ""
+	x.journalEntries = 0
+	x.journalSize = 0
+}
+
+// readJournal reads the entries of a journal file. It stops at the first incomplete or invalid line, which is the torn
+// tail of a write interrupted by a crash, and returns the length of the valid part of the journal.
+func readJournal(filePath string) ([]journalEntry, int64, error) {
+	fileContent, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries, validLength := parseJournal(fileContent)
+	return entries, validLength, nil
+}
+
+func parseJournal(journal []byte) ([]journalEntry, int64) {
+	var entries []journalEntry
+	var validLength int64
+	for {
+		end := bytes.IndexByte(journal[validLength:], '\n')
+		if end < 0 {
+			return entries, validLength
+		}
+		var entry journalEntry
+		err := json.Unmarshal(journal[validLength:validLength+int64(end)], &entry)
+		if err != nil || entry.Checksum == "" {
+			return entries, validLength
+		}
+		entries = append(entries, entry)
+		validLength += int64(end) + 1
+	}
+}
+
+func applyJournal(files map[string]File, entries []journalEntry) {
+	for _, entry := range entries {
+		if entry.File == nil {
+			delete(files, entry.Checksum)
+			continue
+		}
+		files[entry.Checksum] = *entry.File
+	}
+}
@@ -0,0 +1,247 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentAddFile(t *testing.T) {
+	directory := t.TempDir()
+	a := NewDocumentArchive(slog.Default(), directory, LAYOUT_SUPPLIER, "", nil)
+	defer a.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			documentPath := filepath.Join(directory, "acme", fmt.Sprintf("invoice-%d.pdf", i))
+			if err := os.MkdirAll(filepath.Dir(documentPath), 0755); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := os.WriteFile(documentPath, []byte(fmt.Sprintf("%%PDF %d", i)), 0644); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := a.AddFile(documentPath, "acme"); err != nil {
+				t.Error(err)
+			}
+			if !a.FileExists(documentPath) {
+				t.Errorf("%s not found after adding it", documentPath)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(a.GetFileIndex()) != 20 {
+		t.Fatalf("expected 20 documents, got %d", len(a.GetFileIndex()))
+	}
+	persisted, err := readIndexFileFrom(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for checksum, f := range a.GetFileIndex() {
+		if persisted[checksum].Path != f.Path {
+			t.Errorf("document %s missing in the persisted index", f.Path)
+		}
+	}
+
+	a.Close()
+	if err := a.AddFile(filepath.Join(directory, "acme", "invoice-0.pdf"), "acme"); err == nil {
+		t.Errorf("expected an error adding a document to a closed archive")
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	directory := t.TempDir()
+	x := newFileIndex(func() string { return directory })
+	defer x.close()
+	for i := 0; i < journalCompactionThreshold+1; i++ {
+		if err := x.put(fmt.Sprintf("c%d", i), File{Path: fmt.Sprintf("invoice-%d.pdf", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index, err := readIndex(filepath.Join(directory, indexFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != journalCompactionThreshold {
+		t.Errorf("expected %d documents in the index file, got %d", journalCompactionThreshold, len(index))
+	}
+	entries, _, err := readJournal(filepath.Join(directory, journalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected 1 journal entry after compaction, got %d", len(entries))
+	}
+	persisted, err := readIndexFileFrom(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted) != journalCompactionThreshold+1 {
+		t.Errorf("expected %d documents, got %d", journalCompactionThreshold+1, len(persisted))
+	}
+}
+
+// failingJournal writes the first failAfter bytes of the next write to the journal and fails then.
+type failingJournal struct {
+	journalFile
+	failAfter int
+	failed    bool
+}
+
+func (j *failingJournal) Write(p []byte) (int, error) {
+	if j.failed {
+		return j.journalFile.Write(p)
+	}
+	j.failed = true
+	n, _ := j.journalFile.Write(p[:j.failAfter])
+	return n, errors.New("disk full")
+}
+
+func TestFailedJournalWriteIsRolledBack(t *testing.T) {
+	directory := t.TempDir()
+	x := newFileIndex(func() string { return directory })
+	defer x.close()
+	if err := x.put("c1", File{Path: "invoice-1.pdf"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first entry of the batch is written completely, the second one partially
+	entries := []journalEntry{{Checksum: "c2", File: &File{Path: "invoice-2.pdf"}}, {Checksum: "c3", File: &File{Path: "invoice-3.pdf"}}}
+	line, _ := json.Marshal(entries[0])
+	x.journal = &failingJournal{journalFile: x.journal, failAfter: len(line) + 10}
+	if err := x.update(entries...); err == nil {
+		t.Fatal("expected the journal write to fail")
+	}
+	if _, ok := x.get("c2"); ok {
+		t.Error("expected the entries of the failed write not to be applied")
+	}
+	journal, _, err := readJournal(filepath.Join(directory, journalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal) != 1 || journal[0].Checksum != "c1" {
+		t.Errorf("expected the journal to be rolled back to c1, got %+v", journal)
+	}
+
+	// The journal is still usable after the failed write
+	if err := x.put("c4", File{Path: "invoice-4.pdf"}); err != nil {
+		t.Fatal(err)
+	}
+	persisted, err := readIndexFileFrom(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted) != 2 || persisted["c1"].Path != "invoice-1.pdf" || persisted["c4"].Path != "invoice-4.pdf" {
+		t.Errorf("expected c1 and c4 in the persisted index, got %+v", persisted)
+	}
+}
+
+// FuzzParseJournal checks that arbitrary journal contents (e.g. garbage after a crash) never break reading the journal
+// and that the valid part of the journal reads the same without the rest.
+func FuzzParseJournal(f *testing.F) {
+	f.Add([]byte(`{"checksum":"c1","file":{"path":"a.pdf","supplier":"acme"}}` + "\n"))
+	f.Add([]byte(`{"checksum":"c1","file":{"path":"a.pdf"}}` + "\n" + `{"checksum":"c1"}` + "\n"))
+	f.Add([]byte(`{"checksum":"c1","file":{"path":"a.pdf"}}` + "\n" + `{"checksum":"c2","fi`))
+	f.Add([]byte("\n\n{}\n"))
+	f.Add([]byte{0, 0, 0, '\n'})
+
+	f.Fuzz(func(t *testing.T, journal []byte) {
+		entries, validLength := parseJournal(journal)
+		if validLength < 0 || validLength > int64(len(journal)) {
+			t.Fatalf("invalid length %d of journal with %d bytes", validLength, len(journal))
+		}
+		validEntries, length := parseJournal(journal[:validLength])
+		if length != validLength || !reflect.DeepEqual(validEntries, entries) {
+			t.Errorf("valid part of the journal reads differently")
+		}
+		for _, entry := range entries {
+			if entry.Checksum == "" {
+				t.Errorf("entry without checksum %+v", entry)
+			}
+		}
+	})
+}
+
+// FuzzJournalCrash simulates crashes at every point of writing the journal: the journal is cut at cut bytes. Reading the
+// index has to result in the state after a prefix of the writes, and the next write has to continue from there.
+func FuzzJournalCrash(f *testing.F) {
+	f.Add(uint16(0))
+	f.Add(uint16(17))
+	f.Add(uint16(80))
+	f.Add(uint16(1000))
+
+	f.Fuzz(func(t *testing.T, cut uint16) {
+		directory := t.TempDir()
+		x := newFileIndex(func() string { return directory })
+		defer x.close()
+
+		// states[i] is the index after i writes
+		states := []map[string]File{{}}
+		writes := []journalEntry{
+			{Checksum: "c1", File: &File{Path: "a.pdf", Supplier: "acme"}},
+			{Checksum: "c2", File: &File{Path: "b.pdf", Supplier: "acme", Tags: []string{"travel"}}},
+			{Checksum: "c1", File: &File{Path: "a.pdf", Supplier: "acme", Reviewed: true}},
+			{Checksum: "c2"},
+			{Checksum: "c3", File: &File{Path: "c.pdf", Supplier: "hetzner"}},
+		}
+		for _, entry := range writes {
+			if err := x.update(entry); err != nil {
+				t.Fatal(err)
+			}
+			states = append(states, x.copy())
+		}
+
+		journalPath := filepath.Join(directory, journalFileName)
+		journal, err := os.ReadFile(journalPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal = journal[:min(int(cut), len(journal))]
+		if err := os.WriteFile(journalPath, journal, 0644); err != nil {
+			t.Fatal(err)
+		}
+		complete := bytes.Count(journal, []byte("\n"))
+
+		index, err := readIndexFileFrom(directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(index, states[complete]) {
+			t.Fatalf("index after crash at byte %d is %v, expected %v", cut, index, states[complete])
+		}
+
+		// A new index continues after the last complete write
+		y := newFileIndex(func() string { return directory })
+		defer y.close()
+		if err := y.replace(index, directory); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(journalPath, journal, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := y.put("c4", File{Path: "d.pdf"}); err != nil {
+			t.Fatal(err)
+		}
+		entries, _, err := readJournal(journalPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != complete+1 || entries[complete].Checksum != "c4" {
+			lines, _ := json.Marshal(entries)
+			t.Errorf("unexpected journal after crash at byte %d: %s", cut, lines)
+		}
+	})
+}
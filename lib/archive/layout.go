@@ -0,0 +1,147 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LAYOUT_SUPPLIER stores documents in <archive>/<supplier>/ (default)
+	LAYOUT_SUPPLIER = "supplier"
+	// LAYOUT_SUPPLIER_YEAR stores documents in <archive>/<supplier>/<year>/
+	LAYOUT_SUPPLIER_YEAR = "supplier-year"
+	// LAYOUT_YEAR stores documents in <archive>/<year>/
+	LAYOUT_YEAR = "year"
+	// LAYOUT_FLAT stores all documents in <archive>/
+	LAYOUT_FLAT = "flat"
+)
+
+// ValidateLayout returns an error if layout is not a supported directory layout.
+func ValidateLayout(layout string) error {
+	switch layout {
+	case LAYOUT_SUPPLIER, LAYOUT_SUPPLIER_YEAR, LAYOUT_YEAR, LAYOUT_FLAT:
+		return nil
+	}
+
+	return fmt.Errorf("directory layout %s not supported (supported: %s, %s, %s, %s)", layout, LAYOUT_SUPPLIER, LAYOUT_SUPPLIER_YEAR, LAYOUT_YEAR, LAYOUT_FLAT)
+}
+
+// SupplierDirectory returns the directory new documents of supplier are stored in.
+// If a staging directory is configured, the directory is placed below the staging directory.
+func (a *DocumentArchive) SupplierDirectory(supplier string) string {
+	baseDirectory := a.storageDirectory
+	if a.stagingDirectory != "" {
+		baseDirectory = a.stagingDirectory
+	}
+
+	return filepath.Join(baseDirectory, layoutPath(a.layout, supplier, time.Now()))
+}
+
+// LayoutDirectory returns the directory of the (non staged) documents of supplier in year according to the layout.
+// supplier and year are optional (empty and 0). If the layout doesn't separate them, the parent directory is returned.
+func (a *DocumentArchive) LayoutDirectory(supplier string, year int) string {
+	directory := a.storageDirectory
+	switch a.layout {
+	case LAYOUT_SUPPLIER, LAYOUT_SUPPLIER_YEAR:
+		if supplier == "" {
+			return directory
+		}
+		directory = filepath.Join(directory, supplier)
+		if a.layout == LAYOUT_SUPPLIER_YEAR && year > 0 {
+			directory = filepath.Join(directory, strconv.Itoa(year))
+		}
+	case LAYOUT_YEAR:
+		if year > 0 {
+			directory = filepath.Join(directory, strconv.Itoa(year))
+		}
+	}
+
+	return directory
+}
+
+// MigrateLayout moves all (non staged) documents of the archive into the configured layout.
+// Documents of unknown suppliers are only moved for layouts that don't include the supplier.
+// Returns the number of moved documents.
+func (a *DocumentArchive) MigrateLayout() (int, error) {
+	if a.readOnly {
+		return 0, errors.New("can't migrate the layout of a read-only archive")
+	}
+
+	moved := 0
+	var entries []journalEntry
+	for hash, f := range a.index.files() {
+		if f.Staged || f.Rejected {
+			continue
+		}
+		if f.Supplier == "" && (a.layout == LAYOUT_SUPPLIER || a.layout == LAYOUT_SUPPLIER_YEAR) {
+			a.logger.Info("Skipping document of unknown supplier during layout migration", "file", f.Path)
+			continue
+		}
+
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			return moved, errors.Join(err, a.index.update(entries...))
+		}
+		targetDirectory := filepath.Join(a.storageDirectory, layoutPath(a.layout, f.Supplier, info.ModTime()))
+		if filepath.Dir(f.Path) == targetDirectory {
+			continue
+		}
+
+		a.logger.Info("Moving document to new layout", "source", f.Path, "destination", targetDirectory, "layout", a.layout)
+		targetPath, err := moveFile(f.Path, targetDirectory)
+		if err != nil {
+			// The documents moved so far are kept in the index
+			return moved, errors.Join(err, a.index.update(entries...))
+		}
+
+		f.Path = targetPath
+		entries = append(entries, journalEntry{Checksum: hash, File: &f})
+		moved++
+	}
+
+	return moved, a.index.update(entries...)
+}
+
+func layoutPath(layout, supplier string, t time.Time) string {
+	year := strconv.Itoa(t.Year())
+	switch layout {
+	case LAYOUT_SUPPLIER_YEAR:
+		return filepath.Join(supplier, year)
+	case LAYOUT_YEAR:
+		return year
+	case LAYOUT_FLAT:
+		return ""
+	}
+
+	return supplier
+}
+
+// determineSupplierFromPath is the fallback for documents that are not part of the persisted index (yet).
+func (a *DocumentArchive) determineSupplierFromPath(filePath string) string {
+	baseDirectory := a.storageDirectory
+	if a.stagingDirectory != "" && strings.HasPrefix(filePath, a.stagingDirectory) {
+		baseDirectory = a.stagingDirectory
+	}
+	relativePath, err := filepath.Rel(baseDirectory, filePath)
+	if err != nil {
+		return ""
+	}
+
+	directory := filepath.Dir(relativePath)
+	switch a.layout {
+	case LAYOUT_SUPPLIER_YEAR:
+		directory = filepath.Dir(directory)
+	case LAYOUT_YEAR, LAYOUT_FLAT:
+		return ""
+	}
+	if directory == "." {
+		return ""
+	}
+
+	return filepath.Base(directory)
+}
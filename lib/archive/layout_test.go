@@ -0,0 +1,32 @@
+package archive
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+func TestLayoutDirectory(t *testing.T) {
+	root := "archive"
+	tests := []struct {
+		layout   string
+		supplier string
+		year     int
+		expected string
+	}{
+		{LAYOUT_SUPPLIER, "", 0, root},
+		{LAYOUT_SUPPLIER, "acme", 2024, filepath.Join(root, "acme")},
+		{LAYOUT_SUPPLIER_YEAR, "acme", 0, filepath.Join(root, "acme")},
+		{LAYOUT_SUPPLIER_YEAR, "acme", 2024, filepath.Join(root, "acme", "2024")},
+		{LAYOUT_SUPPLIER_YEAR, "", 2024, root},
+		{LAYOUT_YEAR, "acme", 2024, filepath.Join(root, "2024")},
+		{LAYOUT_YEAR, "acme", 0, root},
+		{LAYOUT_FLAT, "acme", 2024, root},
+	}
+	for _, test := range tests {
+		a := NewDocumentArchive(slog.Default(), root, test.layout, "", nil)
+		if directory := a.LayoutDirectory(test.supplier, test.year); directory != test.expected {
+			t.Errorf("%s (%q, %d): expected %s, got %s", test.layout, test.supplier, test.year, test.expected, directory)
+		}
+	}
+}
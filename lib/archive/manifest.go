@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"buchhalter/lib/utils"
+)
+
+const (
+	manifestDirectoryName = "_manifests"
+	manifestTimeFormat    = "20060102T150405Z"
+)
+
+// Manifest records how the documents of a sync run were produced: the versions and checksums of the recipes, the
+// Open Invoice Collector Database and the tools involved, and the hashes of the new documents.
+// It is written deterministically (sorted, with paths relative to the archive), so equal runs result in equal manifests.
+type Manifest struct {
+	StartedAt     time.Time          `json:"startedAt"`
+	CliVersion    string             `json:"cliVersion"`
+	ChromeVersion string             `json:"chromeVersion,omitempty"`
+	OicdbVersion  string             `json:"oicdbVersion,omitempty"`
+	OicdbChecksum string             `json:"oicdbChecksum,omitempty"`
+	Recipes       []ManifestRecipe   `json:"recipes"`
+	Documents     []ManifestDocument `json:"documents"`
+}
+
+type ManifestRecipe struct {
+	Supplier string `json:"supplier"`
+	Version  string `json:"version"`
+	// Checksum of the recipe definition, it changes even if the version of a changed recipe doesn't
+	Checksum string `json:"checksum"`
+	Status   string `json:"status,omitempty"`
+}
+
+type ManifestDocument struct {
+	Checksum string `json:"checksum"`
+	// Path relative to the archive (or staging) directory at the time of the run
+	Path     string `json:"path"`
+	Supplier string `json:"supplier"`
+}
+
+// ManifestMismatch is a document of a manifest that is missing or changed in the archive.
+type ManifestMismatch struct {
+	Document ManifestDocument
+	// Reason is "missing" or "changed"
+	Reason string
+}
+
+// WriteManifest adds the documents added to the archive since the start of the run to m and writes it into the manifest
+// directory of the archive (of the staging directory of read-only archives). Returns the path of the manifest.
+func (a *DocumentArchive) WriteManifest(m Manifest) (string, error) {
+	m.StartedAt = m.StartedAt.UTC().Truncate(time.Second)
+	m.Documents = []ManifestDocument{}
+	for checksum, f := range a.index.files() {
+		if f.Rejected || f.AddedAt.Before(m.StartedAt) {
+			continue
+		}
+		m.Documents = append(m.Documents, ManifestDocument{
+			Checksum: checksum,
+			Path:     filepath.ToSlash(a.relativePath(f)),
+			Supplier: f.Supplier,
+		})
+	}
+	sort.Slice(m.Documents, func(i, j int) bool {
+		return m.Documents[i].Checksum < m.Documents[j].Checksum
+	})
+	if m.Recipes == nil {
+		m.Recipes = []ManifestRecipe{}
+	}
+	sort.Slice(m.Recipes, func(i, j int) bool {
+		return m.Recipes[i].Supplier < m.Recipes[j].Supplier
+	})
+
+	fileContent, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	directory := filepath.Join(a.indexDirectory(), manifestDirectoryName)
+	err = utils.CreateDirectoryIfNotExists(directory)
+	if err != nil {
+		return "", err
+	}
+	manifestFile := filepath.Join(directory, m.StartedAt.Format(manifestTimeFormat)+".json")
+
+	return manifestFile, os.WriteFile(manifestFile, append(fileContent, '\n'), 0644)
+}
+
+// Manifests returns the paths of all manifests of the archive, oldest first.
+func (a *DocumentArchive) Manifests() ([]string, error) {
+	var manifestFiles []string
+	directories := []string{a.storageDirectory}
+	if a.stagingDirectory != "" {
+		directories = append(directories, a.stagingDirectory)
+	}
+	for _, directory := range directories {
+		files, err := filepath.Glob(filepath.Join(directory, manifestDirectoryName, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		manifestFiles = append(manifestFiles, files...)
+	}
+
+	// The file names start with the time of the run
+	sort.Slice(manifestFiles, func(i, j int) bool {
+		return filepath.Base(manifestFiles[i]) < filepath.Base(manifestFiles[j])
+	})
+	return manifestFiles, nil
+}
+
+func ReadManifest(manifestFile string) (Manifest, error) {
+	var m Manifest
+	fileContent, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(fileContent, &m)
+	return m, err
+}
+
+// VerifyManifest checks that the documents of m are still in the archive, unchanged. Documents are identified by their
+// checksum, so documents moved since the run (e.g. after the review) are verified as well.
+func (a *DocumentArchive) VerifyManifest(m Manifest) ([]ManifestMismatch, error) {
+	var mismatches []ManifestMismatch
+	for _, document := range m.Documents {
+		f, ok := a.index.get(document.Checksum)
+		if !ok {
+			mismatches = append(mismatches, ManifestMismatch{Document: document, Reason: "missing"})
+			continue
+		}
+		hash, err := computeHash(f.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			mismatches = append(mismatches, ManifestMismatch{Document: document, Reason: "missing"})
+			continue
+		}
+		if err != nil {
+			return mismatches, err
+		}
+		if hash != document.Checksum {
+			mismatches = append(mismatches, ManifestMismatch{Document: document, Reason: "changed"})
+		}
+	}
+	return mismatches, nil
+}
+
+// relativePath returns the path of a document relative to the archive or staging directory.
+func (a *DocumentArchive) relativePath(f File) string {
+	base := a.storageDirectory
+	if f.Staged {
+		base = a.stagingDirectory
+	}
+	relativePath, err := filepath.Rel(base, f.Path)
+	if err != nil || strings.HasPrefix(relativePath, "..") {
+		return f.Path
+	}
+	return relativePath
+}
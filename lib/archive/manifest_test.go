@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteAndVerifyManifest(t *testing.T) {
+	directory := t.TempDir()
+	a := NewDocumentArchive(slog.Default(), directory, LAYOUT_SUPPLIER, "", nil)
+	defer a.Close()
+
+	startedAt := time.Now()
+	var documentPaths []string
+	for _, supplier := range []string{"hetzner", "acme"} {
+		documentPath := filepath.Join(directory, supplier, "invoice.pdf")
+		if err := os.MkdirAll(filepath.Dir(documentPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(documentPath, []byte("%PDF "+supplier), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.AddFile(documentPath, supplier); err != nil {
+			t.Fatal(err)
+		}
+		documentPaths = append(documentPaths, documentPath)
+	}
+
+	m := Manifest{
+		StartedAt:  startedAt,
+		CliVersion: "v1.0.0",
+		Recipes: []ManifestRecipe{
+			{Supplier: "hetzner", Version: "1.0.0", Checksum: "b"},
+			{Supplier: "acme", Version: "2.1.0", Checksum: "a"},
+		},
+	}
+	manifestFile, err := a.WriteManifest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same run results in the same manifest
+	if _, err := a.WriteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	rewritten, _ := os.ReadFile(manifestFile)
+	if !bytes.Equal(written, rewritten) {
+		t.Errorf("manifest is not deterministic:\n%s\n%s", written, rewritten)
+	}
+
+	manifestFiles, err := a.Manifests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifestFiles) != 1 || manifestFiles[0] != manifestFile {
+		t.Fatalf("expected manifest %s, got %v", manifestFile, manifestFiles)
+	}
+	manifest, err := ReadManifest(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]bool{}
+	for _, document := range manifest.Documents {
+		paths[document.Path] = true
+	}
+	if len(manifest.Documents) != 2 || !paths["acme/invoice.pdf"] || !paths["hetzner/invoice.pdf"] {
+		t.Errorf("unexpected documents %+v", manifest.Documents)
+	}
+	if manifest.Recipes[0].Supplier != "acme" {
+		t.Errorf("expected recipes sorted by supplier, got %+v", manifest.Recipes)
+	}
+
+	// Manifests are not indexed as documents
+	if err := a.BuildArchiveIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.GetFileIndex()) != 2 {
+		t.Errorf("expected 2 documents in the index, got %d", len(a.GetFileIndex()))
+	}
+
+	mismatches, err := a.VerifyManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("expected no mismatches, got %+v", mismatches)
+	}
+
+	if err := os.WriteFile(documentPaths[0], []byte("%PDF changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(documentPaths[1]); err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err = a.VerifyManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := map[string]string{}
+	for _, mismatch := range mismatches {
+		reasons[mismatch.Document.Supplier] = mismatch.Reason
+	}
+	if reasons["hetzner"] != "changed" || reasons["acme"] != "missing" {
+		t.Errorf("unexpected mismatches %+v", mismatches)
+	}
+}
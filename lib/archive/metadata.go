@@ -0,0 +1,33 @@
+package archive
+
+import "fmt"
+
+// Metadata of a document as listed by the supplier, e.g. scraped from the invoice table of a portal (see the
+// `extractTable` step). It is kept as shown by the supplier, dates and amounts are not normalized.
+type Metadata struct {
+	InvoiceNumber string `json:"invoiceNumber,omitempty"`
+	Date          string `json:"date,omitempty"`
+	Amount        string `json:"amount,omitempty"`
+	// VatRate in percent (e.g. `19 %`)
+	VatRate string `json:"vatRate,omitempty"`
+}
+
+// IsEmpty returns true if no field of the metadata is set.
+func (m Metadata) IsEmpty() bool {
+	return m == Metadata{}
+}
+
+// SetMetadata stores the metadata of the document at filePath, which must be in the archive already.
+func (a *DocumentArchive) SetMetadata(filePath string, metadata Metadata) error {
+	checksum, err := computeHash(filePath)
+	if err != nil {
+		return err
+	}
+	f, ok := a.index.get(checksum)
+	if !ok {
+		return fmt.Errorf("document %s not found in archive", filePath)
+	}
+
+	f.Metadata = &metadata
+	return a.index.put(checksum, f)
+}
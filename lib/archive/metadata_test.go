@@ -0,0 +1,46 @@
+package archive
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetMetadata(t *testing.T) {
+	directory := t.TempDir()
+	a := NewDocumentArchive(slog.Default(), directory, LAYOUT_SUPPLIER, "", nil)
+	defer a.Close()
+
+	filePath := filepath.Join(directory, "acme", "invoice.pdf")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte("invoice"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := Metadata{InvoiceNumber: "R-1001", Date: "31.03.2024", Amount: "119,00 €"}
+	if err := a.SetMetadata(filePath, metadata); err == nil {
+		t.Error("expected an error for a document that is not in the archive")
+	}
+
+	if err := a.AddFile(filePath, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetMetadata(filePath, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, ok := a.FindFile(filePath)
+	if !ok {
+		t.Fatal("expected the document to be in the archive")
+	}
+	f, _ := a.GetFile(checksum)
+	if f.Metadata == nil || *f.Metadata != metadata {
+		t.Errorf("expected metadata %+v, got %+v", metadata, f.Metadata)
+	}
+	if f.Supplier != "acme" {
+		t.Errorf("expected the other fields to be kept, got %+v", f)
+	}
+}
@@ -0,0 +1,50 @@
+package archive
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	yearPattern    = regexp.MustCompile(`^(\d{4})$`)
+	quarterPattern = regexp.MustCompile(`^(\d{4})-[Qq]([1-4])$`)
+	monthPattern   = regexp.MustCompile(`^(\d{4})-(0[1-9]|1[0-2])$`)
+)
+
+// Period is an accounting period, e.g. a quarter.
+// Start is inclusive, End is exclusive.
+type Period struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// ParsePeriod parses a year (`2024`), a quarter (`2024-Q3`) or a month (`2024-09`) in the given location.
+func ParsePeriod(value string, location *time.Location) (Period, error) {
+	if m := yearPattern.FindStringSubmatch(value); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		start := time.Date(year, time.January, 1, 0, 0, 0, 0, location)
+		return Period{Name: value, Start: start, End: start.AddDate(1, 0, 0)}, nil
+	}
+	if m := quarterPattern.FindStringSubmatch(value); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		quarter, _ := strconv.Atoi(m[2])
+		start := time.Date(year, time.Month(3*(quarter-1)+1), 1, 0, 0, 0, 0, location)
+		return Period{Name: fmt.Sprintf("%d-Q%d", year, quarter), Start: start, End: start.AddDate(0, 3, 0)}, nil
+	}
+	if m := monthPattern.FindStringSubmatch(value); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, location)
+		return Period{Name: value, Start: start, End: start.AddDate(0, 1, 0)}, nil
+	}
+
+	return Period{}, fmt.Errorf("invalid period %s (supported: 2024, 2024-Q3, 2024-09)", value)
+}
+
+// Contains returns true if t is part of the period.
+func (p Period) Contains(t time.Time) bool {
+	return !t.Before(p.Start) && t.Before(p.End)
+}
@@ -0,0 +1,40 @@
+package archive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end string
+	}{
+		{"2024", "2024-01-01", "2025-01-01"},
+		{"2024-Q3", "2024-07-01", "2024-10-01"},
+		{"2024-q4", "2024-10-01", "2025-01-01"},
+		{"2024-09", "2024-09-01", "2024-10-01"},
+	}
+	for _, test := range tests {
+		period, err := ParsePeriod(test.value, time.UTC)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", test.value, err)
+		}
+		if period.Start.Format(time.DateOnly) != test.start || period.End.Format(time.DateOnly) != test.end {
+			t.Errorf("unexpected period %s - %s for %s", period.Start, period.End, test.value)
+		}
+	}
+
+	for _, value := range []string{"", "2024-Q5", "2024-13", "24-Q1"} {
+		if _, err := ParsePeriod(value, time.UTC); err == nil {
+			t.Errorf("expected error for %q", value)
+		}
+	}
+}
+
+func TestPeriodContains(t *testing.T) {
+	period, _ := ParsePeriod("2024-Q3", time.UTC)
+	if !period.Contains(time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)) || period.Contains(time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected bounds of %+v", period)
+	}
+}
@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// EnableReadOnly protects the archive directory (e.g. on a NAS or in a Dropbox shared by multiple machines) from writes.
+// New documents and the index are only written to the staging directory until they are moved into the archive with CommitStagedFiles.
+func (a *DocumentArchive) EnableReadOnly() error {
+	if a.stagingDirectory == "" {
+		return errors.New("a read-only archive requires a staging directory")
+	}
+	a.readOnly = true
+
+	return nil
+}
+
+// IsReadOnly returns true if the archive directory is protected from writes (see EnableReadOnly).
+func (a *DocumentArchive) IsReadOnly() bool {
+	return a.readOnly
+}
+
+// CommitStagedFiles moves all staged (and not rejected) documents into the archive and updates the index of the archive directory.
+// Documents committed by other machines in the meantime are kept in the index.
+// Returns the number of moved documents.
+func (a *DocumentArchive) CommitStagedFiles() (int, error) {
+	files := a.index.copy()
+	moved := 0
+	for hash, f := range files {
+		if !f.Staged || f.Rejected {
+			continue
+		}
+
+		targetDirectory := filepath.Join(a.storageDirectory, layoutPath(a.layout, f.Supplier, f.AddedAt))
+		a.logger.Info("Committing staged document", "source", f.Path, "destination", targetDirectory)
+		targetPath, err := moveFile(f.Path, targetDirectory)
+		if err != nil {
+			// The documents moved so far are kept in the index
+			return moved, errors.Join(err, a.index.replace(files, ""))
+		}
+
+		f.Path = targetPath
+		f.Staged = false
+		files[hash] = f
+		moved++
+	}
+
+	archiveIndex, err := readIndexFileFrom(a.storageDirectory)
+	if err != nil {
+		return moved, errors.Join(err, a.index.replace(files, ""))
+	}
+	for hash, f := range archiveIndex {
+		if _, ok := files[hash]; !ok {
+			files[hash] = f
+		}
+	}
+
+	err = a.index.replace(files, a.storageDirectory)
+	if err != nil {
+		return moved, err
+	}
+
+	return moved, a.index.replace(files, "")
+}
@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"buchhalter/lib/utils"
+)
+
+const remoteIndexCacheFileName = "_remote_index.json"
+
+// RemoteArchive mirrors the documents of an archive to a directory on a remote server via SFTP.
+// The `sftp` binary of OpenSSH is used, so host aliases, keys and jump hosts of the SSH config are reused.
+type RemoteArchive struct {
+	logger *slog.Logger
+
+	host           string
+	directory      string
+	sshConfigFile  string
+	cacheDirectory string
+}
+
+// NewRemoteArchive creates a remote archive for destination (`[user@]host:directory`).
+// The remote index is cached in cacheDirectory, so known documents are skipped even if the server can't be reached.
+// If sshConfigFile is empty, the default SSH config is used.
+func NewRemoteArchive(logger *slog.Logger, destination, sshConfigFile, cacheDirectory string) (*RemoteArchive, error) {
+	host, directory, ok := strings.Cut(destination, ":")
+	if !ok || host == "" {
+		return nil, fmt.Errorf("invalid remote archive %s (expected [user@]host:directory)", destination)
+	}
+	if directory == "" {
+		directory = "."
+	}
+	err := utils.CreateDirectoryIfNotExists(cacheDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RemoteArchive{
+		logger:         logger,
+		host:           host,
+		directory:      directory,
+		sshConfigFile:  sshConfigFile,
+		cacheDirectory: cacheDirectory,
+	}, nil
+}
+
+// FetchIndex downloads the index of the remote archive and updates the local cache.
+// If the server can't be reached, the cached index is returned along with the error.
+func (r *RemoteArchive) FetchIndex() (map[string]File, error) {
+	cacheFile := filepath.Join(r.cacheDirectory, remoteIndexCacheFileName)
+	downloadFile := cacheFile + ".download"
+	err := r.runBatch([]string{
+		// A missing index (e.g. of a new remote archive) is not an error
+		fmt.Sprintf("-get %s %s", quoteSftpPath(path.Join(r.directory, indexFileName)), quoteSftpPath(downloadFile)),
+	})
+	if err == nil {
+		if _, statErr := os.Stat(downloadFile); statErr == nil {
+			err = os.Rename(downloadFile, cacheFile)
+		}
+	}
+
+	index, readErr := readIndex(cacheFile)
+	if err != nil {
+		return index, fmt.Errorf("error fetching remote archive index from %s: %w", r.host, err)
+	}
+	return index, readErr
+}
+
+// Upload copies all documents of the archive that are missing on the remote server in one SFTP session
+// and updates the remote index. The remote index is fetched again before, so documents uploaded by other machines are kept.
+// Returns the number of uploaded documents.
+func (r *RemoteArchive) Upload(documentArchive *DocumentArchive) (int, error) {
+	remoteIndex, err := r.FetchIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	var commands []string
+	directories := map[string]bool{}
+	uploaded := 0
+	for hash, f := range documentArchive.GetFileIndex() {
+		if f.Staged || f.Rejected {
+			continue
+		}
+		if _, ok := remoteIndex[hash]; ok {
+			continue
+		}
+		relativePath, err := filepath.Rel(documentArchive.storageDirectory, f.Path)
+		if err != nil || strings.HasPrefix(relativePath, "..") {
+			r.logger.Info("Skipping upload of document outside of the archive directory", "file", f.Path)
+			continue
+		}
+
+		remotePath := path.Join(r.directory, filepath.ToSlash(relativePath))
+		for _, directory := range parentDirectories(r.directory, path.Dir(remotePath)) {
+			if !directories[directory] {
+				directories[directory] = true
+				// Existing directories are not an error
+				commands = append(commands, "-mkdir "+quoteSftpPath(directory))
+			}
+		}
+		commands = append(commands, fmt.Sprintf("put %s %s", quoteSftpPath(f.Path), quoteSftpPath(remotePath)))
+
+		f.Path = remotePath
+		remoteIndex[hash] = f
+		uploaded++
+	}
+	if uploaded == 0 {
+		return 0, nil
+	}
+
+	fileContent, err := json.MarshalIndent(remoteIndex, "", "    ")
+	if err != nil {
+		return 0, err
+	}
+	cacheFile := filepath.Join(r.cacheDirectory, remoteIndexCacheFileName)
+	err = os.WriteFile(cacheFile, fileContent, 0644)
+	if err != nil {
+		return 0, err
+	}
+	commands = append(commands, fmt.Sprintf("put %s %s", quoteSftpPath(cacheFile), quoteSftpPath(path.Join(r.directory, indexFileName))))
+
+	r.logger.Info("Uploading documents to remote archive ...", "host", r.host, "directory", r.directory, "documents", uploaded)
+	err = r.runBatch(commands)
+	if err != nil {
+		// The cached index must not list documents that haven't been uploaded
+		_ = os.Remove(cacheFile)
+		return 0, fmt.Errorf("error uploading documents to %s: %w", r.host, err)
+	}
+
+	return uploaded, nil
+}
+
+// runBatch executes the sftp commands in a single session.
+func (r *RemoteArchive) runBatch(commands []string) error {
+	batchFile, err := os.CreateTemp(r.cacheDirectory, "_sftp-batch-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(batchFile.Name())
+	_, err = batchFile.WriteString(strings.Join(commands, "\n") + "\n")
+	if closeErr := batchFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	cmdArgs := []string{"-q", "-b", batchFile.Name()}
+	if r.sshConfigFile != "" {
+		cmdArgs = append(cmdArgs, "-F", r.sshConfigFile)
+	}
+	cmdArgs = append(cmdArgs, r.host)
+
+	// #nosec G204
+	output, err := exec.Command("sftp", cmdArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// parentDirectories returns all directories between base (exclusive) and directory (inclusive), from top to bottom.
+func parentDirectories(base, directory string) []string {
+	var directories []string
+	for directory != base && directory != "." && directory != "/" {
+		directories = append(directories, directory)
+		directory = path.Dir(directory)
+	}
+	sort.Strings(directories)
+
+	return directories
+}
+
+// quoteSftpPath quotes p for sftp batch files.
+func quoteSftpPath(p string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + `"`
+}
@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"buchhalter/lib/utils"
+)
+
+// UnreviewedFiles returns the checksums of all documents that have been downloaded since the last review.
+// The checksums are ordered by the time the documents have been added.
+func (a *DocumentArchive) UnreviewedFiles() []string {
+	files := a.index.files()
+	var checksums []string
+	for checksum, f := range files {
+		if !f.Reviewed && !f.Rejected {
+			checksums = append(checksums, checksum)
+		}
+	}
+
+	sort.Slice(checksums, func(i, j int) bool {
+		return files[checksums[i]].AddedAt.Before(files[checksums[j]].AddedAt)
+	})
+
+	return checksums
+}
+
+// GetFile returns the document with the given checksum.
+func (a *DocumentArchive) GetFile(checksum string) (File, bool) {
+	f, ok := a.index.get(checksum)
+	return f, ok
+}
+
+// AcceptFile marks the document as reviewed.
+// Staged documents are moved from the staging directory into the archive, unless the archive is read-only (see CommitStagedFiles).
+func (a *DocumentArchive) AcceptFile(checksum string) error {
+	f, ok := a.index.get(checksum)
+	if !ok {
+		return fmt.Errorf("document with checksum %s not found in archive", checksum)
+	}
+
+	if f.Staged && !a.readOnly {
+		targetDirectory := filepath.Join(a.storageDirectory, layoutPath(a.layout, f.Supplier, f.AddedAt))
+		targetPath, err := moveFile(f.Path, targetDirectory)
+		if err != nil {
+			return err
+		}
+		f.Path = targetPath
+		f.Staged = false
+	}
+
+	f.Reviewed = true
+	return a.index.put(checksum, f)
+}
+
+// RejectFile moves the document into the trash (see TrashFile).
+// The document stays in the index, so it won't be downloaded again.
+func (a *DocumentArchive) RejectFile(checksum string) error {
+	err := a.TrashFile(checksum)
+	if err != nil {
+		return err
+	}
+
+	f, _ := a.index.get(checksum)
+	f.Reviewed = true
+	return a.index.put(checksum, f)
+}
+
+// RenameFile renames the document inside its current directory.
+func (a *DocumentArchive) RenameFile(checksum, newName string) error {
+	f, ok := a.index.get(checksum)
+	if !ok {
+		return fmt.Errorf("document with checksum %s not found in archive", checksum)
+	}
+	if a.readOnly && !f.Staged {
+		return fmt.Errorf("can't rename %s: archive is read-only", f.Path)
+	}
+
+	// Sanitize the filename to prevent path traversal
+	newName = filepath.Base(newName)
+	if newName == "." || newName == ".." || newName[0:1] == "_" || newName[0:1] == "." {
+		return fmt.Errorf("invalid filename %s", newName)
+	}
+
+	targetPath := filepath.Join(filepath.Dir(f.Path), newName)
+	if _, err := os.Stat(targetPath); !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("can't rename %s: %s exists already", f.Path, targetPath)
+	}
+	err := os.Rename(f.Path, targetPath)
+	if err != nil {
+		return err
+	}
+
+	f.Path = targetPath
+	return a.index.put(checksum, f)
+}
+
+func moveFile(filePath, targetDirectory string) (string, error) {
+	targetPath := filepath.Join(targetDirectory, filepath.Base(filePath))
+	if targetPath == filePath {
+		return targetPath, nil
+	}
+	if _, err := os.Stat(targetPath); !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("can't move %s: %s exists already", filePath, targetPath)
+	}
+
+	err := utils.CreateDirectoryIfNotExists(targetDirectory)
+	if err != nil {
+		return "", err
+	}
+
+	return targetPath, os.Rename(filePath, targetPath)
+}
@@ -0,0 +1,142 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"buchhalter/lib/utils"
+)
+
+const sourcesFileName = "_sources.json"
+
+// Source is the origin of a downloaded document, so it can be downloaded again without a full recipe run (see the
+// refetch command). Unlike the entries of the index, sources are kept when their document is deleted or its file changes.
+type Source struct {
+	Supplier string `json:"supplier"`
+	Path     string `json:"path"`
+	// ID of the document at the supplier, e.g. the invoice id of a supplier API
+	ID           string    `json:"id,omitempty"`
+	URL          string    `json:"url"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+	// Checksum of the downloaded document, the key of the sources file
+	Checksum string `json:"-"`
+}
+
+// AddSource stores the source of the document at source.Path. Safe for concurrent use.
+func (a *DocumentArchive) AddSource(source Source) error {
+	checksum, err := computeHash(source.Path)
+	if err != nil {
+		return err
+	}
+
+	a.sourcesMutex.Lock()
+	defer a.sourcesMutex.Unlock()
+	sources, err := a.readSources()
+	if err != nil {
+		return err
+	}
+	// A document downloaded again replaces the source of its previous download
+	for previousChecksum, previousSource := range sources {
+		if previousSource.Path == source.Path {
+			delete(sources, previousChecksum)
+		}
+	}
+	sources[checksum] = source
+	return a.writeSources(sources)
+}
+
+// FindSources returns the sources of the documents of supplier matching query, ordered by path: the id of the
+// document, the date of the download (e.g. 2024-03 or 2024-03-31) or a part of the file name.
+func (a *DocumentArchive) FindSources(supplier, query string) ([]Source, error) {
+	a.sourcesMutex.Lock()
+	sources, err := a.readSources()
+	a.sourcesMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []Source
+	for checksum, source := range sources {
+		if source.Supplier != supplier {
+			continue
+		}
+		if source.ID == query || strings.HasPrefix(source.DownloadedAt.Format(time.DateOnly), query) || strings.Contains(strings.ToLower(filepath.Base(source.Path)), strings.ToLower(query)) {
+			source.Checksum = checksum
+			matches = append(matches, source)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Path < matches[j].Path
+	})
+
+	return matches, nil
+}
+
+// SourcesOf returns the sources of all documents of supplier, e.g. to tell which documents offered by the supplier
+// were downloaded before.
+func (a *DocumentArchive) SourcesOf(supplier string) ([]Source, error) {
+	a.sourcesMutex.Lock()
+	sources, err := a.readSources()
+	a.sourcesMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var supplierSources []Source
+	for checksum, source := range sources {
+		if source.Supplier == supplier {
+			source.Checksum = checksum
+			supplierSources = append(supplierSources, source)
+		}
+	}
+	return supplierSources, nil
+}
+
+func (a *DocumentArchive) readSources() (map[string]Source, error) {
+	sources := map[string]Source{}
+	fileContent, err := os.ReadFile(filepath.Join(a.indexDirectory(), sourcesFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return sources, nil
+	}
+	if err != nil {
+		return sources, err
+	}
+
+	err = json.Unmarshal(fileContent, &sources)
+	return sources, err
+}
+
+// writeSources replaces the sources file atomically, like the index file.
+func (a *DocumentArchive) writeSources(sources map[string]Source) error {
+	fileContent, err := json.MarshalIndent(sources, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	directory := a.indexDirectory()
+	err = utils.CreateDirectoryIfNotExists(directory)
+	if err != nil {
+		return err
+	}
+	temporaryFile, err := os.CreateTemp(directory, sourcesFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+	_, err = temporaryFile.Write(fileContent)
+	if closeErr := temporaryFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temporaryFile.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryFile.Name(), filepath.Join(directory, sourcesFileName))
+}
@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddAndFindSources(t *testing.T) {
+	directory := t.TempDir()
+	a := NewDocumentArchive(slog.Default(), directory, LAYOUT_SUPPLIER, "", nil)
+	defer a.Close()
+	downloadedAt := time.Date(2024, 3, 31, 12, 0, 0, 0, time.Local)
+	for _, id := range []string{"2024-001", "2024-002"} {
+		documentPath := filepath.Join(directory, "acme", "invoice-"+id+".pdf")
+		if err := os.MkdirAll(filepath.Dir(documentPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(documentPath, []byte("%PDF "+id), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.AddSource(Source{Supplier: "acme", Path: documentPath, ID: id, URL: "https://acme.example/invoices/" + id, DownloadedAt: downloadedAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		supplier string
+		query    string
+		expected int
+	}{
+		{supplier: "acme", query: "2024-002", expected: 1},
+		{supplier: "acme", query: "2024-03", expected: 2},
+		{supplier: "acme", query: "INVOICE-2024-001", expected: 1},
+		{supplier: "acme", query: "2023", expected: 0},
+		{supplier: "hetzner", query: "2024-03", expected: 0},
+	}
+	for _, tt := range tests {
+		sources, err := a.FindSources(tt.supplier, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sources) != tt.expected {
+			t.Errorf("expected %d sources of %s matching %s, got %v", tt.expected, tt.supplier, tt.query, sources)
+		}
+	}
+
+	// A download of the same document replaces its source, even if the document changed
+	documentPath := filepath.Join(directory, "acme", "invoice-2024-001.pdf")
+	if err := os.WriteFile(documentPath, []byte("%PDF 2024-001 regenerated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddSource(Source{Supplier: "acme", Path: documentPath, ID: "2024-001", URL: "https://acme.example/invoices/2024-001", DownloadedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := a.FindSources("acme", "2024-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, _ := computeHash(documentPath)
+	if len(sources) != 1 || sources[0].Checksum != checksum {
+		t.Errorf("expected the source of the new download, got %v", sources)
+	}
+
+	sources, err = a.SourcesOf("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 {
+		t.Errorf("expected the sources of both documents, got %v", sources)
+	}
+	sources, err = a.SourcesOf("hetzner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 0 {
+		t.Errorf("expected no sources of another supplier, got %v", sources)
+	}
+}
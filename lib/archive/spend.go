@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	currencyCodePattern = regexp.MustCompile(`\b[A-Z]{3}\b`)
+	numberPattern       = regexp.MustCompile(`-?\d[\d.,' ]*`)
+
+	currencySymbols = map[string]string{"€": "EUR", "$": "USD", "£": "GBP", "¥": "JPY", "Fr.": "CHF"}
+)
+
+// SupplierDefaults are the currency and VAT rate of a supplier used for documents whose metadata lacks them.
+type SupplierDefaults struct {
+	Currency string
+	// VatRate in percent (e.g. 19), empty if unknown
+	VatRate string
+}
+
+// Spend is the amount of a document for spend reports. Amounts are in minor units (e.g. cents).
+type Spend struct {
+	Gross    int64
+	Vat      int64
+	Currency string
+	// HasVatRate is false if neither the metadata nor the supplier defaults have a VAT rate, Vat is 0 then
+	HasVatRate bool
+	// Defaults are the fields taken from the supplier defaults (currency, vatRate)
+	Defaults []string
+	// Conflicts are the extracted values differing from the supplier defaults, the extracted values are used
+	Conflicts []string
+}
+
+// ResolveSpend returns the spend of a document with metadata. Extracted values take precedence over the supplier
+// defaults, conflicts between them are flagged. Documents without an amount can't be part of spend reports.
+func ResolveSpend(metadata *Metadata, defaults SupplierDefaults) (Spend, error) {
+	if metadata == nil || metadata.Amount == "" {
+		return Spend{}, errors.New("no amount")
+	}
+	gross, currency, err := ParseAmount(metadata.Amount)
+	if err != nil {
+		return Spend{}, err
+	}
+
+	spend := Spend{Gross: gross, Currency: currency}
+	defaultCurrency := strings.ToUpper(strings.TrimSpace(defaults.Currency))
+	switch {
+	case spend.Currency == "" && defaultCurrency != "":
+		spend.Currency = defaultCurrency
+		spend.Defaults = append(spend.Defaults, "currency")
+	case spend.Currency != "" && defaultCurrency != "" && spend.Currency != defaultCurrency:
+		spend.Conflicts = append(spend.Conflicts, fmt.Sprintf("currency %s (default %s)", spend.Currency, defaultCurrency))
+	}
+
+	vatRate, hasVatRate, err := parseVatRate(metadata.VatRate)
+	if err != nil {
+		return Spend{}, err
+	}
+	defaultVatRate, hasDefaultVatRate, err := parseVatRate(defaults.VatRate)
+	if err != nil {
+		return Spend{}, fmt.Errorf("invalid default VAT rate: %w", err)
+	}
+	switch {
+	case !hasVatRate && hasDefaultVatRate:
+		vatRate, hasVatRate = defaultVatRate, true
+		spend.Defaults = append(spend.Defaults, "vatRate")
+	case hasVatRate && hasDefaultVatRate && vatRate != defaultVatRate:
+		spend.Conflicts = append(spend.Conflicts, fmt.Sprintf("VAT rate %s%% (default %s%%)", formatRate(vatRate), formatRate(defaultVatRate)))
+	}
+	if hasVatRate {
+		// Amounts listed by suppliers are gross amounts
+		spend.HasVatRate = true
+		spend.Vat = int64(math.Round(float64(gross) * vatRate / (100 + vatRate)))
+	}
+
+	return spend, nil
+}
+
+// ParseAmount parses an amount as listed by a supplier (e.g. `1.234,56 €`, `USD 1,234.56` or `-19.99`) into minor units and
+// the currency (empty if the amount has none). The last `.` or `,` followed by one or two digits is the decimal separator.
+func ParseAmount(amount string) (int64, string, error) {
+	currency := ""
+	if code := currencyCodePattern.FindString(amount); code != "" {
+		currency = code
+	} else {
+		for symbol, code := range currencySymbols {
+			if strings.Contains(amount, symbol) {
+				currency = code
+				break
+			}
+		}
+	}
+
+	number := strings.TrimSpace(numberPattern.FindString(amount))
+	if number == "" {
+		return 0, "", fmt.Errorf("invalid amount %s", amount)
+	}
+	negative := strings.HasPrefix(number, "-")
+	number = strings.TrimPrefix(number, "-")
+
+	integerPart, fraction := number, ""
+	if i := strings.LastIndexAny(number, ".,"); i >= 0 && len(number)-i-1 <= 2 {
+		integerPart, fraction = number[:i], number[i+1:]
+	}
+	integerPart = strings.NewReplacer(".", "", ",", "", "'", "", " ", "").Replace(integerPart)
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+	minorUnits, err := strconv.ParseInt(integerPart+fraction, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid amount %s", amount)
+	}
+	if negative {
+		minorUnits = -minorUnits
+	}
+	return minorUnits, currency, nil
+}
+
+// FormatAmount formats minor units with two decimals, e.g. 123456 as 1234.56.
+func FormatAmount(minorUnits int64) string {
+	sign := ""
+	if minorUnits < 0 {
+		sign = "-"
+		minorUnits = -minorUnits
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minorUnits/100, minorUnits%100)
+}
+
+// parseVatRate parses a VAT rate in percent (e.g. `19`, `19 %` or `7,5%`). It returns false for an empty rate.
+func parseVatRate(rate string) (float64, bool, error) {
+	rate = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rate), "%"))
+	if rate == "" {
+		return 0, false, nil
+	}
+	value, err := strconv.ParseFloat(strings.Replace(rate, ",", ".", 1), 64)
+	if err != nil || value < 0 {
+		return 0, false, fmt.Errorf("invalid VAT rate %s", rate)
+	}
+	return value, true, nil
+}
+
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64)
+}
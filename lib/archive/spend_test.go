@@ -0,0 +1,93 @@
+package archive
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		amount     string
+		minorUnits int64
+		currency   string
+	}{
+		{"1.234,56 €", 123456, "EUR"},
+		{"USD 1,234.56", 123456, "USD"},
+		{"-19.99", -1999, ""},
+		{"1.234 EUR", 123400, "EUR"},
+		{"CHF 1'250.5", 125050, "CHF"},
+		{"£7", 700, "GBP"},
+	}
+	for _, test := range tests {
+		minorUnits, currency, err := ParseAmount(test.amount)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", test.amount, err)
+			continue
+		}
+		if minorUnits != test.minorUnits || currency != test.currency {
+			t.Errorf("expected %d %s for %s, got %d %s", test.minorUnits, test.currency, test.amount, minorUnits, currency)
+		}
+	}
+
+	if _, _, err := ParseAmount("n/a"); err == nil {
+		t.Error("expected an error for an amount without number")
+	}
+}
+
+func TestResolveSpend(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata *Metadata
+		defaults SupplierDefaults
+		expected Spend
+	}{
+		{
+			name:     "extracted values",
+			metadata: &Metadata{Amount: "119,00 €", VatRate: "19 %"},
+			expected: Spend{Gross: 11900, Vat: 1900, Currency: "EUR", HasVatRate: true},
+		},
+		{
+			name:     "supplier defaults",
+			metadata: &Metadata{Amount: "107,00"},
+			defaults: SupplierDefaults{Currency: "eur", VatRate: "7"},
+			expected: Spend{Gross: 10700, Vat: 700, Currency: "EUR", HasVatRate: true, Defaults: []string{"currency", "vatRate"}},
+		},
+		{
+			name:     "conflicts",
+			metadata: &Metadata{Amount: "USD 50.00", VatRate: "0"},
+			defaults: SupplierDefaults{Currency: "EUR", VatRate: "19"},
+			expected: Spend{Gross: 5000, Currency: "USD", HasVatRate: true, Conflicts: []string{"currency USD (default EUR)", "VAT rate 0% (default 19%)"}},
+		},
+		{
+			name:     "unknown VAT rate",
+			metadata: &Metadata{Amount: "10.00 EUR"},
+			expected: Spend{Gross: 1000, Currency: "EUR"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spend, err := ResolveSpend(test.metadata, test.defaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(spend, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, spend)
+			}
+		})
+	}
+
+	if _, err := ResolveSpend(nil, SupplierDefaults{Currency: "EUR"}); err == nil {
+		t.Error("expected an error for a document without metadata")
+	}
+	if _, err := ResolveSpend(&Metadata{Amount: "10,00"}, SupplierDefaults{VatRate: "high"}); err == nil {
+		t.Error("expected an error for an invalid default VAT rate")
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	for minorUnits, expected := range map[int64]string{123456: "1234.56", -1999: "-19.99", 5: "0.05"} {
+		if formatted := FormatAmount(minorUnits); formatted != expected {
+			t.Errorf("expected %s, got %s", expected, formatted)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package archive
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// StateFiles returns the files of the archive that aren't documents: the index, its journal and the document sources.
+// They are part of backups (see lib/backup), some may not exist.
+func (a *DocumentArchive) StateFiles() []string {
+	directory := a.indexDirectory()
+	return []string{
+		filepath.Join(directory, indexFileName),
+		filepath.Join(directory, journalFileName),
+		filepath.Join(directory, sourcesFileName),
+	}
+}
+
+// Relocate rewrites the paths of the persisted index and of the document sources from the archive directories of another
+// machine to the directories of the archive, e.g. after restoring a backup. Documents outside both directories keep their paths.
+func (a *DocumentArchive) Relocate(previousDirectory, previousStagingDirectory string) error {
+	relocate := func(filePath string) string {
+		if previousStagingDirectory != "" && a.stagingDirectory != "" {
+			if relativePath, ok := relativeTo(previousStagingDirectory, filePath); ok {
+				return filepath.Join(a.stagingDirectory, relativePath)
+			}
+		}
+		if relativePath, ok := relativeTo(previousDirectory, filePath); ok {
+			return filepath.Join(a.storageDirectory, relativePath)
+		}
+		return filePath
+	}
+
+	files, err := readIndexFileFrom(a.indexDirectory())
+	if err != nil {
+		return err
+	}
+	for checksum, f := range files {
+		f.Path = relocate(f.Path)
+		if f.OriginalPath != "" {
+			f.OriginalPath = relocate(f.OriginalPath)
+		}
+		files[checksum] = f
+	}
+	err = a.index.replace(files, "")
+	if err != nil {
+		return err
+	}
+
+	a.sourcesMutex.Lock()
+	defer a.sourcesMutex.Unlock()
+	sources, err := a.readSources()
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	for checksum, source := range sources {
+		source.Path = relocate(source.Path)
+		sources[checksum] = source
+	}
+	return a.writeSources(sources)
+}
+
+// relativeTo returns the path of filePath relative to directory, if filePath is below directory.
+func relativeTo(directory, filePath string) (string, bool) {
+	if directory == "" {
+		return "", false
+	}
+	relativePath, err := filepath.Rel(directory, filePath)
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return relativePath, true
+}
@@ -0,0 +1,78 @@
+package archive
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRelocate(t *testing.T) {
+	previousDirectory := t.TempDir()
+	a := NewDocumentArchive(slog.Default(), previousDirectory, LAYOUT_SUPPLIER, "", nil)
+	documentPath := filepath.Join(previousDirectory, "acme", "invoice.pdf")
+	if err := os.MkdirAll(filepath.Dir(documentPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(documentPath, []byte("%PDF acme"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddFile(documentPath, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddSource(Source{Supplier: "acme", Path: documentPath, ID: "1", URL: "https://acme.example/invoices/1", DownloadedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	// The documents and the state files are moved to the directory of the new machine
+	directory := filepath.Join(t.TempDir(), "documents")
+	if err := os.Rename(previousDirectory, directory); err != nil {
+		t.Fatal(err)
+	}
+	a = NewDocumentArchive(slog.Default(), directory, LAYOUT_SUPPLIER, "", nil)
+	defer a.Close()
+	if err := a.Relocate(previousDirectory, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.BuildArchiveIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedPath := filepath.Join(directory, "acme", "invoice.pdf")
+	for _, f := range a.GetFileIndex() {
+		// Documents added by a recipe need a review, unlike documents found by rebuilding the index
+		if f.Path != expectedPath || f.Reviewed {
+			t.Errorf("expected the relocated document %s with its metadata, got %+v", expectedPath, f)
+		}
+	}
+	sources, err := a.FindSources("acme", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].Path != expectedPath {
+		t.Errorf("expected the relocated source of %s, got %v", expectedPath, sources)
+	}
+}
+
+func TestRelativeTo(t *testing.T) {
+	tests := []struct {
+		directory string
+		filePath  string
+		expected  string
+		ok        bool
+	}{
+		{directory: "/home/a/buchhalter", filePath: "/home/a/buchhalter/acme/invoice.pdf", expected: filepath.Join("acme", "invoice.pdf"), ok: true},
+		{directory: "/home/a/buchhalter", filePath: "/home/a/buchhalter-old/acme/invoice.pdf", ok: false},
+		{directory: "/home/a/buchhalter", filePath: "/home/a/..invoice.pdf", ok: false},
+		{directory: "", filePath: "/home/a/buchhalter/acme/invoice.pdf", ok: false},
+	}
+	for _, tt := range tests {
+		relativePath, ok := relativeTo(tt.directory, tt.filePath)
+		if ok != tt.ok || relativePath != tt.expected {
+			t.Errorf("expected %q (%t) for %s in %s, got %q (%t)", tt.expected, tt.ok, tt.filePath, tt.directory, relativePath, ok)
+		}
+	}
+}
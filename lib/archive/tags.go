@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// FindFile returns the checksum of a document identified by its checksum or its file path.
+func (a *DocumentArchive) FindFile(checksumOrPath string) (string, bool) {
+	files := a.index.files()
+	if _, ok := files[checksumOrPath]; ok {
+		return checksumOrPath, true
+	}
+
+	absolutePath, err := filepath.Abs(checksumOrPath)
+	if err != nil {
+		return "", false
+	}
+	for checksum, f := range files {
+		if f.Path == absolutePath {
+			return checksum, true
+		}
+	}
+
+	return "", false
+}
+
+// MatchFiles returns the checksums of the documents whose file name contains query (case-insensitive), ordered by path.
+func (a *DocumentArchive) MatchFiles(query string) []string {
+	query = strings.ToLower(query)
+	files := a.index.files()
+	var checksums []string
+	for checksum, f := range files {
+		if strings.Contains(strings.ToLower(filepath.Base(f.Path)), query) {
+			checksums = append(checksums, checksum)
+		}
+	}
+	slices.SortFunc(checksums, func(x, y string) int {
+		return strings.Compare(files[x].Path, files[y].Path)
+	})
+
+	return checksums
+}
+
+// AddTags adds tags to the document.
+func (a *DocumentArchive) AddTags(checksum string, tags ...string) error {
+	f, ok := a.index.get(checksum)
+	if !ok {
+		return fmt.Errorf("document with checksum %s not found in archive", checksum)
+	}
+
+	for _, tag := range tags {
+		if tag != "" && !slices.Contains(f.Tags, tag) {
+			f.Tags = append(f.Tags, tag)
+		}
+	}
+	sort.Strings(f.Tags)
+
+	return a.index.put(checksum, f)
+}
+
+// RemoveTags removes tags from the document.
+func (a *DocumentArchive) RemoveTags(checksum string, tags ...string) error {
+	f, ok := a.index.get(checksum)
+	if !ok {
+		return fmt.Errorf("document with checksum %s not found in archive", checksum)
+	}
+
+	f.Tags = slices.DeleteFunc(f.Tags, func(tag string) bool {
+		return slices.Contains(tags, tag)
+	})
+
+	return a.index.put(checksum, f)
+}
@@ -0,0 +1,29 @@
+package archive
+
+import (
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+func TestMatchFiles(t *testing.T) {
+	a := NewDocumentArchive(slog.Default(), t.TempDir(), LAYOUT_SUPPLIER, "", nil)
+	err := a.index.replace(map[string]File{
+		"c1": {Path: "archive/hetzner/Invoice-2024-02.pdf", Supplier: "hetzner"},
+		"c2": {Path: "archive/hetzner/invoice-2024-01.pdf", Supplier: "hetzner"},
+		"c3": {Path: "archive/invoice/receipt.pdf", Supplier: "invoice"},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checksums := a.MatchFiles("INVOICE-2024"); !reflect.DeepEqual(checksums, []string{"c1", "c2"}) {
+		t.Errorf("unexpected matches %v", checksums)
+	}
+	if checksums := a.MatchFiles("2024-01"); !reflect.DeepEqual(checksums, []string{"c2"}) {
+		t.Errorf("unexpected matches %v", checksums)
+	}
+	if checksums := a.MatchFiles("unknown"); len(checksums) != 0 {
+		t.Errorf("expected no matches, got %v", checksums)
+	}
+}
@@ -0,0 +1,99 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const trashDirectoryName = "_trash"
+
+// TrashFile moves the document into the trash directory, it can be restored with RestoreFile.
+// Trashed documents are marked as rejected: they stay in the index, so they won't be downloaded again, but are skipped by
+// uploads and reports. In read-only mode, the trash directory is placed below the staging directory.
+func (a *DocumentArchive) TrashFile(checksum string) error {
+	f, ok := a.index.get(checksum)
+	if !ok {
+		return fmt.Errorf("document with checksum %s not found in archive", checksum)
+	}
+	if f.Rejected {
+		return fmt.Errorf("%s is in the trash already", f.Path)
+	}
+	if a.readOnly && !f.Staged {
+		return fmt.Errorf("can't trash %s: archive is read-only", f.Path)
+	}
+
+	baseDirectory := a.storageDirectory
+	if a.readOnly {
+		baseDirectory = a.stagingDirectory
+	}
+	// Documents of different suppliers often share their file name (e.g. invoice.pdf)
+	targetPath, err := moveFile(f.Path, filepath.Join(baseDirectory, trashDirectoryName, checksum[:min(12, len(checksum))]))
+	if err != nil {
+		return err
+	}
+
+	f.OriginalPath = f.Path
+	f.Path = targetPath
+	f.TrashedAt = time.Now()
+	f.Staged = false
+	f.Rejected = true
+	return a.index.put(checksum, f)
+}
+
+// TrashedFiles returns the checksums of all documents in the trash, ordered by the time they have been trashed.
+func (a *DocumentArchive) TrashedFiles() []string {
+	files := a.index.files()
+	var checksums []string
+	for checksum, f := range files {
+		if f.Rejected {
+			checksums = append(checksums, checksum)
+		}
+	}
+
+	sort.Slice(checksums, func(i, j int) bool {
+		return files[checksums[i]].TrashedAt.Before(files[checksums[j]].TrashedAt)
+	})
+
+	return checksums
+}
+
+// RestoreFile moves a trashed document back to its original directory and into the review queue.
+// Documents rejected before the trash existed (without original path) are restored into the directory of their supplier.
+func (a *DocumentArchive) RestoreFile(checksum string) error {
+	f, ok := a.index.get(checksum)
+	if !ok {
+		return fmt.Errorf("document with checksum %s not found in archive", checksum)
+	}
+	if !f.Rejected {
+		return fmt.Errorf("%s is not in the trash", f.Path)
+	}
+
+	targetDirectory := filepath.Dir(f.OriginalPath)
+	if f.OriginalPath == "" {
+		targetDirectory = filepath.Join(a.storageDirectory, layoutPath(a.layout, f.Supplier, f.AddedAt))
+	}
+	staged := a.stagingDirectory != "" && strings.HasPrefix(targetDirectory, a.stagingDirectory)
+	if a.readOnly && !staged {
+		return fmt.Errorf("can't restore %s: archive is read-only", f.Path)
+	}
+
+	trashDirectory := filepath.Dir(f.Path)
+	targetPath, err := moveFile(f.Path, targetDirectory)
+	if err != nil {
+		return err
+	}
+	// Only removes the directory of the document in the trash if it's empty now
+	_ = os.Remove(trashDirectory)
+
+	f.Path = targetPath
+	f.OriginalPath = ""
+	f.TrashedAt = time.Time{}
+	f.Staged = staged
+	f.Reviewed = false
+	f.Rejected = false
+	return a.index.put(checksum, f)
+}
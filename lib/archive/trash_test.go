@@ -0,0 +1,93 @@
+package archive
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrashAndRestoreFile(t *testing.T) {
+	directory := t.TempDir()
+	a := NewDocumentArchive(slog.Default(), directory, LAYOUT_SUPPLIER, "", nil)
+	for _, supplier := range []string{"acme", "hetzner"} {
+		documentPath := filepath.Join(directory, supplier, "invoice.pdf")
+		if err := os.MkdirAll(filepath.Dir(documentPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(documentPath, []byte("%PDF "+supplier), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.BuildArchiveIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Documents with the same file name can be trashed side by side
+	for checksum := range a.GetFileIndex() {
+		if err := a.TrashFile(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trashed := a.TrashedFiles()
+	if len(trashed) != 2 {
+		t.Fatalf("expected 2 trashed documents, got %v", trashed)
+	}
+	f, _ := a.GetFile(trashed[0])
+	if !f.Rejected || f.TrashedAt.IsZero() || filepath.Dir(filepath.Dir(f.Path)) != filepath.Join(directory, trashDirectoryName) {
+		t.Errorf("unexpected trashed document %+v", f)
+	}
+	if err := a.TrashFile(trashed[0]); err == nil {
+		t.Error("expected an error for a document in the trash")
+	}
+
+	// The trash survives a rebuild of the index
+	a = NewDocumentArchive(slog.Default(), directory, LAYOUT_SUPPLIER, "", nil)
+	if err := a.BuildArchiveIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.TrashedFiles()) != 2 {
+		t.Fatalf("expected 2 trashed documents after rebuilding the index, got %v", a.TrashedFiles())
+	}
+
+	originalPath := f.OriginalPath
+	if err := a.RestoreFile(trashed[0]); err != nil {
+		t.Fatal(err)
+	}
+	f, _ = a.GetFile(trashed[0])
+	if f.Path != originalPath || f.Rejected || f.Reviewed || f.OriginalPath != "" {
+		t.Errorf("unexpected restored document %+v", f)
+	}
+	if _, err := os.Stat(originalPath); err != nil {
+		t.Errorf("expected the document at %s: %s", originalPath, err)
+	}
+	if _, err := os.Stat(filepath.Join(directory, trashDirectoryName, trashed[0][:12])); !os.IsNotExist(err) {
+		t.Errorf("expected the empty trash directory of the document to be removed: %v", err)
+	}
+	if err := a.RestoreFile(trashed[0]); err == nil {
+		t.Error("expected an error for a document not in the trash")
+	}
+}
+
+func TestRestoreFileWithoutOriginalPath(t *testing.T) {
+	directory := t.TempDir()
+	quarantinePath := filepath.Join(directory, "_quarantine", "invoice.pdf")
+	if err := os.MkdirAll(filepath.Dir(quarantinePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(quarantinePath, []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewDocumentArchive(slog.Default(), directory, LAYOUT_SUPPLIER, "", nil)
+	if err := a.index.put("c1", File{Path: quarantinePath, Supplier: "acme", Reviewed: true, Rejected: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RestoreFile("c1"); err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := a.GetFile("c1"); f.Path != filepath.Join(directory, "acme", "invoice.pdf") {
+		t.Errorf("unexpected path %s", f.Path)
+	}
+}
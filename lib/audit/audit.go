@@ -0,0 +1,131 @@
+// Package audit keeps a local, append-only log of every access to credentials and tokens,
+// so users can review which secrets the automation read for which supplier and step.
+package audit
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LOG_FILE_NAME is the file in the buchhalter directory the accesses are appended to (one JSON object per line)
+const LOG_FILE_NAME = "_audit.log"
+
+const (
+	// KIND_VAULT_ITEM is the retrieval of the credentials of a vault item
+	KIND_VAULT_ITEM = "vaultItem"
+	// KIND_CREDENTIAL is the use of a field of the credentials (username, password, totp) by a step
+	KIND_CREDENTIAL = "credential"
+	// KIND_OAUTH2_TOKEN is the use of a cached OAuth2 token (accessToken, refreshToken) by a step
+	KIND_OAUTH2_TOKEN = "oauth2Token"
+	// KIND_EBICS_KEYS is the retrieval of the EBICS keys of a supplier from the keychain
+	KIND_EBICS_KEYS = "ebicsKeys"
+)
+
+// Event is a single access to a credential or token. Values of secrets are never part of an event.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Command is the buchhalter command accessing the secret (e.g. sync)
+	Command string `json:"command"`
+	Kind    string `json:"kind"`
+	// Field of the credentials or the token (optional)
+	Field string `json:"field,omitempty"`
+	// Source identifies the secret, e.g. the id of the vault item
+	Source   string `json:"source,omitempty"`
+	Supplier string `json:"supplier,omitempty"`
+	// Step is the action of the recipe step accessing the secret (optional)
+	Step string `json:"step,omitempty"`
+}
+
+// Counts are the number of accesses by kind and field (e.g. `credential.password`), without suppliers or sources.
+type Counts map[string]int
+
+// Log appends events to the audit log file. A nil *Log discards all events.
+type Log struct {
+	logger  *slog.Logger
+	command string
+
+	mutex  sync.Mutex
+	file   *os.File
+	counts Counts
+}
+
+// Open opens the audit log at filePath for appending the events of command. The file is created if it doesn't exist.
+func Open(logger *slog.Logger, filePath, command string) (*Log, error) {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G304
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log{
+		logger:  logger,
+		command: command,
+		file:    file,
+		counts:  make(Counts),
+	}, nil
+}
+
+// Record appends an access to the log. Errors are logged only, a failing audit log doesn't abort the run.
+func (l *Log) Record(e Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Command = l.command
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		l.logger.Error("Error encoding audit event", "kind", e.Kind, "error", err)
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.counts[countKey(e)]++
+	// A single write per event, so concurrent writers don't interleave lines
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		l.logger.Error("Error writing audit event", "kind", e.Kind, "supplier", e.Supplier, "error", err)
+	}
+}
+
+// Counts returns the number of accesses recorded since the log was opened.
+func (l *Log) Counts() Counts {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	counts := make(Counts, len(l.counts))
+	for key, count := range l.counts {
+		counts[key] = count
+	}
+	return counts
+}
+
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+func countKey(e Event) string {
+	if e.Field == "" {
+		return e.Kind
+	}
+	return e.Kind + "." + e.Field
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), LOG_FILE_NAME)
+	if err := os.WriteFile(filePath, []byte(`{"command":"refetch","kind":"vaultItem"}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(slog.Default(), filePath, "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(Event{Kind: KIND_VAULT_ITEM, Source: "item-1", Supplier: "acme"})
+	l.Record(Event{Kind: KIND_CREDENTIAL, Field: "password", Source: "item-1", Supplier: "acme", Step: "type"})
+	l.Record(Event{Kind: KIND_CREDENTIAL, Field: "password", Source: "item-2", Supplier: "globex", Step: "type"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCounts := Counts{"vaultItem": 1, "credential.password": 2}
+	if counts := l.Counts(); !reflect.DeepEqual(counts, expectedCounts) {
+		t.Errorf("expected counts %v, got %v", expectedCounts, counts)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	// Existing events are kept
+	if len(events) != 4 || events[0].Command != "refetch" {
+		t.Fatalf("expected the events to be appended, got %+v", events)
+	}
+	if e := events[2]; e.Command != "sync" || e.Supplier != "acme" || e.Field != "password" || e.Step != "type" || e.Time.IsZero() {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the audit log to be readable by the user only, got %s", info.Mode().Perm())
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	l.Record(Event{Kind: KIND_VAULT_ITEM})
+	if counts := l.Counts(); counts != nil {
+		t.Errorf("expected no counts, got %v", counts)
+	}
+	if err := l.Close(); err != nil {
+		t.Error(err)
+	}
+}
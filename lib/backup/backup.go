@@ -0,0 +1,323 @@
+// Package backup writes and reads encrypted backups of the buchhalter state (configuration, archive indexes, run history
+// and optionally cached tokens), e.g. to move buchhalter to another machine. Documents aren't part of a backup.
+//
+// A backup is a zip file encrypted with AES-256-GCM. The key is derived from a passphrase with PBKDF2-HMAC-SHA256,
+// the header (magic, version, iterations, salt and nonce) is authenticated as additional data.
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"buchhalter/lib/utils"
+)
+
+const (
+	magic         = "BUCHHALTER-BACKUP"
+	formatVersion = 1
+
+	// iterations of PBKDF2 for new backups, see the OWASP recommendation for PBKDF2-HMAC-SHA256
+	iterations = 600000
+	saltSize   = 16
+	nonceSize  = 12
+	keySize    = 32
+
+	manifestFileName = "manifest.json"
+)
+
+var (
+	ErrInvalidBackup     = errors.New("the file is no buchhalter backup")
+	ErrWrongPassphrase   = errors.New("wrong passphrase or damaged backup")
+	ErrUnsupportedFormat = errors.New("the backup was created by a newer version of buchhalter")
+)
+
+// Manifest describes a backup and the machine it was created on.
+type Manifest struct {
+	CreatedAt  time.Time `json:"createdAt"`
+	CliVersion string    `json:"cliVersion"`
+	// HomeDirectory of the user, directories below it are moved to the home directory of the new machine
+	HomeDirectory string `json:"homeDirectory"`
+	// Archives are the directories of the archives of the machine, restored indexes are relocated to the new directories
+	Archives map[string]ArchiveDirectories `json:"archives"`
+	// Files are the names of the files in the backup, sorted
+	Files []string `json:"files"`
+}
+
+type ArchiveDirectories struct {
+	Directory        string `json:"directory"`
+	StagingDirectory string `json:"stagingDirectory,omitempty"`
+}
+
+// Entry is a file of the buchhalter state, stored as Name (a slash separated path) in the backup.
+type Entry struct {
+	Name string
+	Path string
+}
+
+// Backup is a decrypted backup.
+type Backup struct {
+	Manifest Manifest
+
+	files map[string]*zip.File
+}
+
+// Create writes a backup of the files of entries to w. Entries of files that don't exist are skipped.
+// It returns the manifest of the backup.
+func Create(w io.Writer, passphrase string, manifest Manifest, entries []Entry) (Manifest, error) {
+	if passphrase == "" {
+		return manifest, errors.New("the passphrase of a backup must not be empty")
+	}
+
+	var content bytes.Buffer
+	zipWriter := zip.NewWriter(&content)
+	manifest.Files = []string{}
+	for _, entry := range entries {
+		ok, err := addFile(zipWriter, entry)
+		if err != nil {
+			return manifest, fmt.Errorf("error adding %s to the backup: %w", entry.Path, err)
+		}
+		if ok {
+			manifest.Files = append(manifest.Files, entry.Name)
+		}
+	}
+	sort.Strings(manifest.Files)
+
+	manifestContent, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	manifestWriter, err := zipWriter.Create(manifestFileName)
+	if err != nil {
+		return manifest, err
+	}
+	if _, err := manifestWriter.Write(manifestContent); err != nil {
+		return manifest, err
+	}
+	if err := zipWriter.Close(); err != nil {
+		return manifest, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return manifest, err
+	}
+	aead, err := newAEAD(passphrase, salt, iterations)
+	if err != nil {
+		return manifest, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return manifest, err
+	}
+
+	header := encodeHeader(iterations, salt, nonce)
+	if _, err := w.Write(header); err != nil {
+		return manifest, err
+	}
+	_, err = w.Write(aead.Seal(nil, nonce, content.Bytes(), header))
+	return manifest, err
+}
+
+// Open decrypts the backup read from r.
+func Open(r io.Reader, passphrase string) (*Backup, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: magic, version (1 byte), iterations (uint32), salt, nonce
+	headerSize := len(magic) + 1 + 4 + saltSize + nonceSize
+	if len(data) < headerSize || string(data[:len(magic)]) != magic {
+		return nil, ErrInvalidBackup
+	}
+	if data[len(magic)] > formatVersion {
+		return nil, ErrUnsupportedFormat
+	}
+	n := binary.BigEndian.Uint32(data[len(magic)+1:])
+	// Newer versions may raise the iterations, but not without limit
+	if n > 100*iterations {
+		return nil, ErrInvalidBackup
+	}
+	salt := data[len(magic)+5 : len(magic)+5+saltSize]
+	nonce := data[len(magic)+5+saltSize : headerSize]
+
+	aead, err := newAEAD(passphrase, salt, int(n))
+	if err != nil {
+		return nil, err
+	}
+	content, err := aead.Open(nil, nonce, data[headerSize:], data[:headerSize])
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+	b := &Backup{files: map[string]*zip.File{}}
+	for _, f := range zipReader.File {
+		b.files[f.Name] = f
+	}
+	manifestFile, ok := b.files[manifestFileName]
+	if !ok {
+		return nil, fmt.Errorf("%w: the manifest is missing", ErrInvalidBackup)
+	}
+	manifestContent, err := readZipFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(manifestContent, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	return b, nil
+}
+
+// Restore writes the file name of the backup to filePath, with the permissions it had on the machine of the backup.
+// An existing file is replaced atomically.
+func (b *Backup) Restore(name, filePath string) error {
+	f, ok := b.files[name]
+	if !ok || name == manifestFileName {
+		return fmt.Errorf("%s is not in the backup", name)
+	}
+	content, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+
+	directory := filepath.Dir(filePath)
+	err = utils.CreateDirectoryIfNotExists(directory)
+	if err != nil {
+		return err
+	}
+	temporaryFile, err := os.CreateTemp(directory, filepath.Base(filePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+	_, err = temporaryFile.Write(content)
+	if closeErr := temporaryFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temporaryFile.Name(), f.Mode().Perm())
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryFile.Name(), filePath)
+}
+
+// FilesIn returns the names of the files of the backup in the directory dir (a slash separated path), sorted.
+func (b *Backup) FilesIn(dir string) []string {
+	var names []string
+	for _, name := range b.Manifest.Files {
+		if path.Dir(name) == dir {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func addFile(zipWriter *zip.Writer, entry Entry) (bool, error) {
+	info, err := os.Stat(entry.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() {
+		return false, nil
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return false, err
+	}
+	header.Name = entry.Name
+	header.Method = zip.Deflate
+	w, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(entry.Path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err == nil, err
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func encodeHeader(n int, salt, nonce []byte) []byte {
+	header := []byte(magic)
+	header = append(header, formatVersion)
+	header = binary.BigEndian.AppendUint32(header, uint32(n))
+	header = append(header, salt...)
+	return append(header, nonce...)
+}
+
+func newAEAD(passphrase string, salt []byte, n int) (cipher.AEAD, error) {
+	if n < 1 {
+		return nil, ErrInvalidBackup
+	}
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, n, keySize, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a key from password as specified in RFC 8018. crypto/pbkdf2 requires Go 1.24.
+func pbkdf2(password, salt []byte, n, keyLength int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	hashLength := prf.Size()
+	blocks := (keyLength + hashLength - 1) / hashLength
+
+	key := make([]byte, 0, blocks*hashLength)
+	u := make([]byte, hashLength)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, uint32(block)))
+		u = prf.Sum(u[:0])
+		t := append([]byte{}, u...)
+		for i := 1; i < n; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
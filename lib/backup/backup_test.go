@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCreateAndRestore(t *testing.T) {
+	directory := t.TempDir()
+	configFile := filepath.Join(directory, ".buchhalter.yaml")
+	tokenFile := filepath.Join(directory, "tokens", "default", "0123.json")
+	if err := os.MkdirAll(filepath.Dir(tokenFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, []byte("buchhalter_language: de\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tokenFile, []byte(`{"supplier":"acme"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	manifest, err := Create(&b, "correct horse", Manifest{CreatedAt: time.Now(), CliVersion: "1.0.0"}, []Entry{
+		{Name: "config/.buchhalter.yaml", Path: configFile},
+		{Name: "tokens/0123.json", Path: tokenFile},
+		{Name: "history/_history.json", Path: filepath.Join(directory, "_history.json")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifest.Files, []string{"config/.buchhalter.yaml", "tokens/0123.json"}) {
+		t.Errorf("expected missing files to be skipped, got %v", manifest.Files)
+	}
+	if bytes.Contains(b.Bytes(), []byte("buchhalter_language")) {
+		t.Error("expected an encrypted backup")
+	}
+
+	_, err = Open(bytes.NewReader(b.Bytes()), "wrong horse")
+	if !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+	_, err = Open(bytes.NewReader([]byte("buchhalter_language: de\n")), "correct horse")
+	if !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("expected ErrInvalidBackup, got %v", err)
+	}
+
+	opened, err := Open(bytes.NewReader(b.Bytes()), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Manifest.CliVersion != "1.0.0" || !reflect.DeepEqual(opened.Manifest.Files, manifest.Files) {
+		t.Errorf("unexpected manifest %+v", opened.Manifest)
+	}
+	if files := opened.FilesIn("tokens"); !reflect.DeepEqual(files, []string{"tokens/0123.json"}) {
+		t.Errorf("unexpected token files %v", files)
+	}
+
+	restoredDirectory := t.TempDir()
+	restoredTokenFile := filepath.Join(restoredDirectory, "tokens", "default", "0123.json")
+	if err := opened.Restore("tokens/0123.json", restoredTokenFile); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(restoredTokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != `{"supplier":"acme"}` {
+		t.Errorf("unexpected restored content %s", content)
+	}
+	info, err := os.Stat(restoredTokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the permissions of the token file, got %s", info.Mode().Perm())
+	}
+	if err := opened.Restore("manifest.json", filepath.Join(restoredDirectory, "manifest.json")); err == nil {
+		t.Error("expected an error for the manifest")
+	}
+}
+
+func TestTamperedBackup(t *testing.T) {
+	var b bytes.Buffer
+	if _, err := Create(&b, "correct horse", Manifest{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The header is authenticated as well
+	tampered := bytes.Clone(b.Bytes())
+	tampered[len(magic)+5] ^= 0xff
+	if _, err := Open(bytes.NewReader(tampered), "correct horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected an error for a tampered salt, got %v", err)
+	}
+	tampered = bytes.Clone(b.Bytes())
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := Open(bytes.NewReader(tampered), "correct horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected an error for a tampered content, got %v", err)
+	}
+}
+
+func TestPbkdf2(t *testing.T) {
+	// Test vectors of RFC 7914, section 11
+	tests := []struct {
+		password string
+		salt     string
+		n        int
+		expected string
+	}{
+		{password: "passwd", salt: "salt", n: 1, expected: "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{password: "Password", salt: "NaCl", n: 80000, expected: "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		key := pbkdf2([]byte(tt.password), []byte(tt.salt), tt.n, 64, sha256.New)
+		if hex.EncodeToString(key) != tt.expected {
+			t.Errorf("unexpected key of %s with %d iterations: %x", tt.password, tt.n, key)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package blocklist
+
+// Ad and tracker blocklists in the EasyList/EasyPrivacy format.
+// Only domain rules (`||example.com^`) and their exceptions (`@@||example.com^`) are supported,
+// cosmetic rules and URL patterns are ignored.
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/utils"
+)
+
+type Blocklist struct {
+	blocked map[string]struct{}
+	allowed map[string]struct{}
+}
+
+func New() *Blocklist {
+	return &Blocklist{
+		blocked: map[string]struct{}{},
+		allowed: map[string]struct{}{},
+	}
+}
+
+// Parse adds all domain rules of a blocklist and returns the number of added rules.
+func (b *Blocklist) Parse(r io.Reader) (int, error) {
+	n := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		rules := b.blocked
+		if strings.HasPrefix(line, "@@") {
+			rules = b.allowed
+			line = line[2:]
+		}
+
+		domain, ok := parseDomainRule(line)
+		if !ok {
+			continue
+		}
+		rules[domain] = struct{}{}
+		n++
+	}
+
+	return n, scanner.Err()
+}
+
+// parseDomainRule returns the domain of a rule like `||example.com^` or `||example.com^$third-party`.
+func parseDomainRule(line string) (string, bool) {
+	if !strings.HasPrefix(line, "||") {
+		return "", false
+	}
+	line = line[2:]
+
+	// Options (e.g. `$third-party`) are ignored
+	if i := strings.Index(line, "$"); i >= 0 {
+		line = line[:i]
+	}
+	if !strings.HasSuffix(line, "^") {
+		return "", false
+	}
+	domain := strings.ToLower(strings.TrimSuffix(line, "^"))
+
+	// Rules with paths or wildcards are no plain domain rules
+	if domain == "" || strings.ContainsAny(domain, "/*^|") {
+		return "", false
+	}
+	return domain, true
+}
+
+// Blocks returns true if host or one of its parent domains is blocked.
+func (b *Blocklist) Blocks(host string) bool {
+	if b == nil || host == "" {
+		return false
+	}
+
+	host = strings.ToLower(host)
+	blocked := false
+	for domain := host; domain != ""; {
+		if _, ok := b.allowed[domain]; ok {
+			return false
+		}
+		if _, ok := b.blocked[domain]; ok {
+			blocked = true
+		}
+
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return blocked
+}
+
+// Len returns the number of block rules.
+func (b *Blocklist) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.blocked)
+}
+
+// Loader downloads blocklists and caches them locally.
+type Loader struct {
+	logger     *slog.Logger
+	httpClient *httpclient.Client
+
+	cacheDirectory string
+	maxAge         time.Duration
+}
+
+func NewLoader(logger *slog.Logger, httpClient *httpclient.Client, cacheDirectory string, maxAge time.Duration) *Loader {
+	return &Loader{
+		logger:     logger,
+		httpClient: httpClient,
+
+		cacheDirectory: cacheDirectory,
+		maxAge:         maxAge,
+	}
+}
+
+// Load returns a blocklist with the rules of all lists at urls.
+// Lists are downloaded again after maxAge. If a download fails, an outdated copy is used.
+func (l *Loader) Load(urls []string) (*Blocklist, error) {
+	err := utils.CreateDirectoryIfNotExists(l.cacheDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	b := New()
+	for _, url := range urls {
+		cacheFile := filepath.Join(l.cacheDirectory, cacheFileName(url))
+		info, err := os.Stat(cacheFile)
+		if err != nil || time.Since(info.ModTime()) > l.maxAge {
+			l.logger.Info("Downloading blocklist ...", "url", url)
+			err = l.download(url, cacheFile)
+			if err != nil {
+				l.logger.Error("Error downloading blocklist", "url", url, "error", err)
+				if info == nil {
+					return nil, err
+				}
+			}
+		}
+
+		f, err := os.Open(cacheFile)
+		if err != nil {
+			return nil, err
+		}
+		n, err := b.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing blocklist %s: %w", url, err)
+		}
+		l.logger.Info("Blocklist loaded", "url", url, "rules", n)
+	}
+
+	return b, nil
+}
+
+func (l *Loader) download(url, cacheFile string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpclient.StatusError(resp, "error downloading blocklist")
+	}
+
+	// Write to a temporary file first, so a failed download doesn't destroy the cached list
+	tmpFile := cacheFile + ".tmp"
+	out, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, resp.Body)
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(tmpFile, cacheFile)
+}
+
+func cacheFileName(url string) string {
+	hash := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(hash[:8]) + ".txt"
+}
@@ -0,0 +1,51 @@
+package blocklist
+
+import (
+	"strings"
+	"testing"
+)
+
+const testList = `[Adblock Plus 2.0]
+! Title: Test list
+||doubleclick.net^
+||google-analytics.com^$third-party
+||tracker.example.org^
+@@||allowed.tracker.example.org^
+||example.com/ads/*
+example.net##.banner
+`
+
+func TestParseAndBlocks(t *testing.T) {
+	b := New()
+	n, err := b.Parse(strings.NewReader(testList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 domain rules, got %d", n)
+	}
+
+	tests := map[string]bool{
+		"doubleclick.net":             true,
+		"stats.g.doubleclick.net":     true,
+		"www.google-analytics.com":    true,
+		"tracker.example.org":         true,
+		"allowed.tracker.example.org": false,
+		"example.org":                 false,
+		"example.com":                 false,
+		"notdoubleclick.net":          false,
+		"":                            false,
+	}
+	for host, expected := range tests {
+		if blocked := b.Blocks(host); blocked != expected {
+			t.Errorf("expected Blocks(%q) = %t, got %t", host, expected, blocked)
+		}
+	}
+}
+
+func TestNilBlocklistBlocksNothing(t *testing.T) {
+	var b *Blocklist
+	if b.Blocks("doubleclick.net") {
+		t.Errorf("expected nil blocklist not to block")
+	}
+}
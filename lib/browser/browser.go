@@ -5,9 +5,18 @@ package browser
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -16,28 +25,66 @@ import (
 	"time"
 
 	"buchhalter/lib/archive"
+	"buchhalter/lib/audit"
+	"buchhalter/lib/blocklist"
+	"buchhalter/lib/driver"
+	"buchhalter/lib/fixture"
+	"buchhalter/lib/httpclient"
 	"buchhalter/lib/parser"
+	"buchhalter/lib/pdf"
+	"buchhalter/lib/statement"
 	"buchhalter/lib/utils"
 	"buchhalter/lib/vault"
 
-	cu "github.com/Davincible/chromedp-undetected"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/chromedp/cdproto/browser"
 	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/fetch"
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 )
 
 type BrowserDriver struct {
 	logger          *slog.Logger
+	httpClient      *httpclient.Client
 	credentials     *vault.Credentials
 	documentArchive *archive.DocumentArchive
 
 	buchhalterDocumentsDirectory string
 
 	ChromeVersion string
+	// ChromePath is the Chrome executable to launch (empty to let chromedp search for it)
+	ChromePath string
+	// ShredTemporaryFiles overwrites downloaded files before they are removed from the downloads directory
+	ShredTemporaryFiles bool
+	// Blocklist of ads and trackers that are blocked on supplier portals (optional)
+	Blocklist *blocklist.Blocklist
+	// FixtureRecorder records network traffic and DOM snapshots of the run (optional)
+	FixtureRecorder *fixture.Recorder
+	// FixtureReplay answers all requests with the recorded responses of a fixture instead of the network (optional)
+	FixtureReplay *fixture.Fixture
+	// DevToolsPort is the remote debugging port of Chrome to attach Chrome DevTools to the recipe run (optional)
+	DevToolsPort int
+	// VideoDirectory stores a screencast of each recipe run as animated GIF (optional)
+	VideoDirectory string
+	// AuditLog records the credentials used by the steps (optional)
+	AuditLog *audit.Log
+	// ListOnly lists the documents of the download steps instead of downloading them, nothing is archived
+	ListOnly bool
+	// MemoryLimit is the maximum size of the JavaScript heap of a page in MB (optional)
+	MemoryLimit int
+	// RestartAfterSteps restarts Chrome before a step once it ran this number of steps (optional)
+	RestartAfterSteps int
+	// RestartAfter restarts Chrome before a step once it runs this long (optional)
+	RestartAfter time.Duration
+	// RemoteDebuggingURL is the URL of a running Chrome the recipes run in instead of a local one (optional),
+	// e.g. ws://127.0.0.1:3000 for browserless or http://scraper:9222
+	RemoteDebuggingURL string
+
+	// supplier of the recipe that is currently executed
+	supplier string
 
 	// TODO Check if those are needed
 	downloadsDirectory string
@@ -47,224 +94,247 @@ type BrowserDriver struct {
 	recipeTimeout      time.Duration
 	maxFilesDownloaded int
 
+	// program shows the DevTools URL of restarted Chrome instances
+	program *tea.Program
+	// chrome is the Chrome instance the steps run in, it is replaced on restarts
+	chrome   *chromeInstance
+	chromeMu sync.Mutex
+	// screencast collects the frames of the recipe run (nil without VideoDirectory)
+	screencast *screencast
+	// remoteDownloads are the downloads of a remote Chrome (see RemoteDebuggingURL)
+	remoteDownloads remoteDownloads
+
+	resourceBlocker *resourceBlocker
+	// locale of the recipe that is currently executed
+	locale string
+
 	// downloadedFilesCount is used to count the number of files that have been downloaded in the `downloadAll` step
 	downloadedFilesCount int
 
 	// newFilesCount is used to count the number of new files that have been moved to the local storage
 	// Incl. a check if we had this document already
 	newFilesCount int
+
+	// recipeVariables holds values extracted from the page by the `extract` step.
+	// They can be used as `{{ name }}` placeholders in later steps.
+	recipeVariables map[string]string
+
+	// tableRows are the documents listed by `extractTable` steps, their metadata is stored with the moved documents
+	tableRows []tableRow
+	// downloadUrls are the urls of the downloaded files by file name, to find their rows in tableRows
+	downloadUrls map[string]string
+
+	// debugArtifactsDirectory is the directory to store screenshots and DOM dumps of failed steps in.
+	// Empty if debug artifacts are disabled.
+	debugArtifactsDirectory string
 }
 
-func NewBrowserDriver(logger *slog.Logger, credentials *vault.Credentials, buchhalterDocumentsDirectory string, documentArchive *archive.DocumentArchive, maxFilesDownloaded int) *BrowserDriver {
+func NewBrowserDriver(ctx context.Context, logger *slog.Logger, httpClient *httpclient.Client, credentials *vault.Credentials, buchhalterDocumentsDirectory, debugArtifactsDirectory string, documentArchive *archive.DocumentArchive, maxFilesDownloaded int) *BrowserDriver {
 	return &BrowserDriver{
 		logger:          logger,
+		httpClient:      httpClient,
 		credentials:     credentials,
 		documentArchive: documentArchive,
 
 		buchhalterDocumentsDirectory: buchhalterDocumentsDirectory,
 
-		browserCtx:         context.Background(),
+		browserCtx:         ctx,
 		recipeTimeout:      60 * time.Second,
 		maxFilesDownloaded: maxFilesDownloaded,
 		newFilesCount:      0,
+		recipeVariables:    make(map[string]string),
+		downloadUrls:       make(map[string]string),
+
+		debugArtifactsDirectory: debugArtifactsDirectory,
 	}
 }
 
 func (b *BrowserDriver) RunRecipe(p *tea.Program, totalStepCount int, stepCountInCurrentRecipe int, baseCountStep int, recipe *parser.Recipe) utils.RecipeResult {
-	// Init browser
-	b.logger.Info("Starting chrome browser driver ...", "recipe", recipe.Supplier, "recipe_version", recipe.Version)
-
-	// Setting chrome flags
-	// Docs: https://github.com/GoogleChrome/chrome-launcher/blob/main/docs/chrome-flags-for-tools.md
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("disable-search-engine-choice-screen", true),
-		chromedp.Flag("enable-automation", false),
-		chromedp.Flag("headless", false),
-	)
-
-	ctx, cancel, err := cu.New(cu.NewConfig(
-		cu.WithContext(b.browserCtx),
-		cu.WithChromeFlags(opts...),
-		// create a timeout as a safety net to prevent any infinite wait loops
-		cu.WithTimeout(600*time.Second),
-	))
-	if err != nil {
-		// TODO Implement error handling
-		panic(err)
-	}
-	defer cancel()
-
-	// get chrome version for metrics
-	if b.ChromeVersion == "" {
-		err := chromedp.Run(ctx, chromedp.Tasks{
-			chromedp.Navigate("chrome://version"),
-			chromedp.Text(`#version`, &b.ChromeVersion, chromedp.NodeVisible),
-		})
-		if err != nil {
-			// TODO Implement error handling
-			panic(err)
-		}
-		b.ChromeVersion = strings.TrimSpace(b.ChromeVersion)
-	}
-	b.logger.Info("Starting chrome browser driver ... completed ", "recipe", recipe.Supplier, "recipe_version", recipe.Version, "chrome_version", b.ChromeVersion)
+	b.supplier = recipe.Supplier
+	b.locale = recipe.Locale
+	b.program = p
 
 	// create download directories
-	b.downloadsDirectory, b.documentsDirectory, err = utils.InitSupplierDirectories(b.buchhalterDocumentsDirectory, recipe.Supplier)
+	keepDownloadsDirectory := false
+	var err error
+	b.downloadsDirectory, b.documentsDirectory, err = utils.InitSupplierDirectories(b.buchhalterDocumentsDirectory, b.documentArchive.SupplierDirectory(recipe.Supplier), recipe.Supplier)
 	if err != nil {
 		// TODO Implement error handling
 		fmt.Println(err)
 	}
 	b.logger.Info("Download directories created", "downloads_directory", b.downloadsDirectory, "documents_directory", b.documentsDirectory)
+	// Runs on errors and panics as well
+	defer func() {
+		if keepDownloadsDirectory {
+			return
+		}
+		b.removeDownloadsDirectory()
+	}()
+
+	// Block resources (by default images) for performance reasons
+	b.resourceBlocker = newResourceBlocker(recipe.Domains, b.Blocklist)
+	if b.VideoDirectory != "" {
+		// Frames of all Chrome instances of the recipe end up in one video
+		b.screencast = &screencast{}
+	}
 
-	err = chromedp.Run(ctx, chromedp.Tasks{
-		browser.
-			SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllow).
-			WithDownloadPath(b.downloadsDirectory).
-			WithEventsEnabled(true),
-		chromedp.ActionFunc(func(ctx context.Context) error {
-			// TODO Implement error handling
-			_ = b.waitForLoadEvent(ctx)
-			return nil
-		}),
-	})
+	err = b.startChrome(recipe)
 	if err != nil {
-		// TODO Implement error handling
-		panic(err)
+		// e.g. the remote Chrome isn't reachable
+		b.logger.Error("Error starting chrome browser", "supplier", recipe.Supplier, "error", err)
+		return driver.ErrorResult(recipe.Supplier, err)
+	}
+	defer b.stopChrome()
+	if b.screencast != nil {
+		// Failed runs are recorded as well, they are the most interesting ones
+		defer func() {
+			b.saveScreencast(b.chromeContext(), b.screencast, recipe)
+		}()
 	}
 
-	// Disable downloading images for performance reasons
-	chromedp.ListenTarget(ctx, b.disableImages(ctx))
-
-	_ = b.enableLifeCycleEvents()
+	// Steps get the context of the recipe, RunStep runs them in the current Chrome (see chromeStepContext)
+	engine := driver.NewEngine(b.logger, b, b.recipeTimeout)
+	// The page of a failed step is unknown, the following steps can't continue
+	engine.AbortOnError = true
+	if b.FixtureRecorder != nil {
+		engine.OnStepSucceeded = func(n int, step parser.Step) {
+			b.recordSnapshot(b.chromeContext(), n, step)
+		}
+	}
+	engine.OnStepFailed = func(n int, step parser.Step, message string) []string {
+		if screenshot := b.captureDebugArtifacts(b.chromeContext(), recipe, n, step, message); screenshot != "" {
+			return []string{screenshot}
+		}
+		return nil
+	}
+	// Killing Chrome closes the DevTools connection, so chromedp calls of the hung step fail and it returns
+	engine.OnStepHung = func(n int, step parser.Step) {
+		b.logger.Warn("Killing chrome browser of hung recipe step", "supplier", recipe.Supplier, "step", n, "action", step.Action)
+		b.stopChrome()
+	}
+	// Process the documents downloaded before the timeout: move them to the documents directory and add them to the archive
+	engine.OnTimeout = func(remainingSteps []parser.Step) (bool, error) {
+		if b.ListOnly || b.downloadedFilesCount == 0 {
+			return false, nil
+		}
+		archiveResult := b.archivePartialDownloads(remainingSteps)
+		if archiveResult.Status != "success" {
+			// Keep the downloaded files, so they can be recovered manually
+			keepDownloadsDirectory = true
+			return false, fmt.Errorf("%s (downloaded files are kept in %s)", archiveResult.Message, b.downloadsDirectory)
+		}
+		return true, nil
+	}
 
-	var cs float64
-	n := 1
-	var result utils.RecipeResult
-	for _, step := range recipe.Steps {
-		p.Send(utils.ViewMsgStatusAndDescriptionUpdate{
-			Title:       fmt.Sprintf("Downloading invoices from %s (%d/%d):", recipe.Supplier, n, stepCountInCurrentRecipe),
-			Description: step.Description,
-		})
+	return engine.Run(b.browserCtx, p, totalStepCount, stepCountInCurrentRecipe, baseCountStep, recipe)
+}
 
-		stepResultChan := make(chan utils.StepResult, 1)
+// RunStep executes a single step of recipe in the browser.
+func (b *BrowserDriver) RunStep(ctx context.Context, recipe *parser.Recipe, step parser.Step) utils.StepResult {
+	// Chrome is restarted between steps to curb its memory usage in long recipes (see RestartAfterSteps)
+	err := b.restartChromeIfDue(recipe)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error(), Break: true}
+	}
+	ctx, cancel := b.chromeStepContext(ctx)
+	defer cancel()
 
-		// Check if step should be skipped
-		if step.When.URL != "" {
-			var currentURL string
-			if err := chromedp.Run(ctx, chromedp.Location(&currentURL)); err != nil {
-				// TODO implement better error handling
-				b.logger.Error("Failed to get current URL", "error", err.Error())
+	b.resourceBlocker.setPolicy(step.ResourcePolicy, recipe.ResourcePolicy)
 
-				// Skipping step
-				continue
-			}
+	// Check if step should be skipped
+	if step.When.URL != "" {
+		var currentURL string
+		if err := chromedp.Run(ctx, chromedp.Location(&currentURL)); err != nil {
+			// TODO implement better error handling
+			b.logger.Error("Failed to get current URL", "error", err.Error())
 
-			// Check if the current URL is not equal to step.When.URL
-			if currentURL != step.When.URL {
-				go func() {
-					stepResultChan <- utils.StepResult{Status: "success"}
-				}()
-			}
+			// Skipping step
+			return utils.StepResult{Status: "success"}
 		}
+		if currentURL != step.When.URL {
+			return utils.StepResult{Status: "success"}
+		}
+	}
 
-		// Timeout recipe if something goes wrong
-		go func() {
-			switch action := step.Action; action {
-			case "open":
-				stepResultChan <- b.stepOpen(ctx, step)
-			case "removeElement":
-				stepResultChan <- b.stepRemoveElement(ctx, step)
-			case "click":
-				stepResultChan <- b.stepClick(ctx, step)
-			case "type":
-				stepResultChan <- b.stepType(ctx, step, b.credentials)
-			case "sleep":
-				stepResultChan <- b.stepSleep(ctx, step)
-			case "waitFor":
-				stepResultChan <- b.stepWaitFor(ctx, step)
-			case "downloadAll":
-				stepResultChan <- b.stepDownloadAll(ctx, step)
-			case "transform":
-				stepResultChan <- b.stepTransform(step)
-			case "move":
-				stepResultChan <- b.stepMove(step, b.documentArchive)
-			case "runScript":
-				stepResultChan <- b.stepRunScript(ctx, step)
-			case "runScriptDownloadUrls":
-				stepResultChan <- b.stepRunScriptDownloadUrls(ctx, step)
-			}
-		}()
-
-		select {
-		case lastStepResult := <-stepResultChan:
-			newDocumentsText := fmt.Sprintf("%d new documents", b.newFilesCount)
-			if b.newFilesCount == 1 {
-				newDocumentsText = "One new document"
-			}
-			if b.newFilesCount == 0 {
-				newDocumentsText = "No new documents"
-			}
-			if lastStepResult.Status == "success" {
-				result = utils.RecipeResult{
-					Status:              "success",
-					StatusText:          recipe.Supplier + ": " + newDocumentsText,
-					StatusTextFormatted: "- " + textStyleBold(recipe.Supplier) + ": " + newDocumentsText,
-					LastStepId:          fmt.Sprintf("%s-%s-%d-%s", recipe.Supplier, recipe.Version, n, step.Action),
-					LastStepDescription: step.Description,
-					NewFilesCount:       b.newFilesCount,
-				}
-			} else {
-				result = utils.RecipeResult{
-					Status:              "error",
-					StatusText:          recipe.Supplier + "aborted with error.",
-					StatusTextFormatted: "x " + textStyleBold(recipe.Supplier) + " aborted with error.",
-					LastStepId:          fmt.Sprintf("%s-%s-%d-%s", recipe.Supplier, recipe.Version, n, step.Action),
-					LastStepDescription: step.Description,
-					LastErrorMessage:    lastStepResult.Message,
-					NewFilesCount:       b.newFilesCount,
-				}
-				err = utils.TruncateDirectory(b.downloadsDirectory)
-				if err != nil {
-					// TODO Implement error handling
-					fmt.Println(err)
-				}
-				return result
-			}
+	step = b.localizeStep(ctx, step, recipe.Locale)
+	if b.ListOnly {
+		switch step.Action {
+		case "downloadAll", "runScriptDownloadUrls", "downloadWithSession", "downloadViaFetch", "printToPdf":
+			return b.stepListDocuments(ctx, step)
+		case "transform", "move":
+			// Nothing was downloaded
+			return utils.StepResult{Status: "success"}
+		}
+	}
+	switch action := step.Action; action {
+	case "open":
+		return b.stepOpen(ctx, step)
+	case "removeElement":
+		return b.stepRemoveElement(ctx, step)
+	case "click":
+		return b.stepClick(ctx, step)
+	case "type":
+		return b.stepType(ctx, step, b.credentials)
+	case "sleep":
+		return b.stepSleep(ctx, step)
+	case "waitFor":
+		return b.stepWaitFor(ctx, step)
+	case "downloadAll":
+		return b.stepDownloadAll(ctx, step)
+	case "transform":
+		return b.stepTransform(step)
+	case "move":
+		return b.stepMove(step, b.documentArchive)
+	case "runScript":
+		return b.stepRunScript(ctx, step)
+	case "runScriptDownloadUrls":
+		return b.stepRunScriptDownloadUrls(ctx, step)
+	case "extract":
+		return b.stepExtract(ctx, step)
+	case "extractTable":
+		return b.stepExtractTable(ctx, step)
+	case "downloadWithSession":
+		return b.stepDownloadWithSession(ctx, step)
+	case "downloadViaFetch":
+		return b.stepDownloadViaFetch(ctx, step)
+	case "printToPdf":
+		return b.stepPrintToPdf(ctx, step)
+	case "captureResponse":
+		return b.stepCaptureResponse(ctx, step)
+	}
+	return utils.StepResult{Status: "error", Message: "unknown action " + step.Action + " of browser recipe"}
+}
 
-		case <-time.After(b.recipeTimeout):
-			result = utils.RecipeResult{
-				Status:              "error",
-				StatusText:          recipe.Supplier + " aborted with timeout.",
-				StatusTextFormatted: "x " + textStyleBold(recipe.Supplier) + " aborted with timeout.",
-				LastStepId:          fmt.Sprintf("%s-%s-%d-%s", recipe.Supplier, recipe.Version, n, step.Action),
-				LastStepDescription: step.Description,
-				NewFilesCount:       b.newFilesCount,
-			}
-			err = utils.TruncateDirectory(b.downloadsDirectory)
-			if err != nil {
-				// TODO Implement error handling
-				fmt.Println(err)
-			}
+func (b *BrowserDriver) NewFilesCount() int {
+	return b.newFilesCount
+}
 
-			// Imagine we run the `downloadALl` step, we download 2 files and then the recipe times out.
-			// It is bad that the recipe timed out, however, we still want to process with the 2 new downloaded documents.
-			// Process in this context means to move the files to the documents directory and add them to the document archive.
-			// Thats why we don't abort if the recipe timed out in this stage.
-			if !(step.Action == "downloadAll" && b.downloadedFilesCount > 0) {
-				return result
-			}
+// archivePartialDownloads runs the local post-processing steps (`transform` and `move`) of the given steps.
+// It is used to archive documents that were downloaded before a recipe aborted.
+func (b *BrowserDriver) archivePartialDownloads(steps []parser.Step) utils.StepResult {
+	for _, step := range steps {
+		var stepResult utils.StepResult
+		switch step.Action {
+		case "transform":
+			stepResult = b.stepTransform(step)
+		case "move":
+			stepResult = b.stepMove(step, b.documentArchive)
+		default:
+			continue
+		}
+		if stepResult.Status != "success" {
+			return stepResult
 		}
-		cs = (float64(baseCountStep) + float64(n)) / float64(totalStepCount)
-		p.Send(utils.ViewMsgProgressUpdate{Percent: cs})
-		n++
 	}
 
-	err = utils.TruncateDirectory(b.downloadsDirectory)
+	return utils.StepResult{Status: "success"}
+}
+
+func (b *BrowserDriver) removeDownloadsDirectory() {
+	err := utils.RemoveTemporaryDirectory(b.downloadsDirectory, b.ShredTemporaryFiles)
 	if err != nil {
-		// TODO Implement error handling
-		fmt.Println(err)
+		b.logger.Error("Error removing downloads directory", "downloads_directory", b.downloadsDirectory, "error", err)
 	}
-	return result
 }
 
 func (b *BrowserDriver) Quit() error {
@@ -278,17 +348,20 @@ func (b *BrowserDriver) Quit() error {
 func (b *BrowserDriver) stepOpen(ctx context.Context, step parser.Step) utils.StepResult {
 	b.logger.Debug("Executing recipe step", "action", step.Action, "url", step.URL)
 
-	if err := chromedp.Run(ctx,
-		// navigate to the page
-		chromedp.Navigate(step.URL),
-		chromedp.ActionFunc(func(ctx context.Context) error {
-			_ = b.waitForLoadEvent(ctx)
-			return nil
-		}),
-	); err != nil {
-		return utils.StepResult{Status: "error", Message: err.Error()}
+	step.URL = utils.ReplacePlaceholders(step.URL, b.recipeVariables)
+	statusCodes := recordDocumentStatusCodes(ctx)
+	err := b.runAndWaitForNavigation(ctx, step, chromedp.Navigate(step.URL))
+	artifacts := utils.StepArtifacts{HTTPStatusCodes: statusCodes()}
+	// Pages that never become idle (e.g. because of polling) are usable anyway.
+	// A timeout is only an error if the recipe explicitly asks for a wait strategy.
+	if errors.Is(err, errNavigationTimeout) && step.WaitStrategy == "" {
+		b.logger.Warn("Page did not finish loading in time, continuing", "action", step.Action, "url", step.URL, "error", err)
+		err = nil
 	}
-	return utils.StepResult{Status: "success"}
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error(), Artifacts: artifacts}
+	}
+	return utils.StepResult{Status: "success", Artifacts: artifacts}
 }
 
 func (b *BrowserDriver) stepRemoveElement(ctx context.Context, step parser.Step) utils.StepResult {
@@ -312,7 +385,7 @@ func (b *BrowserDriver) stepClick(ctx context.Context, step parser.Step) utils.S
 	opts = b.getSelectorTypeQueryOptions(step.SelectorType, opts)
 
 	if err := chromedp.Run(ctx,
-		chromedp.Click(step.Selector, opts...),
+		clickWhenReady(step.Selector, opts...),
 	); err != nil {
 		return utils.StepResult{Status: "error", Message: err.Error()}
 	}
@@ -320,9 +393,10 @@ func (b *BrowserDriver) stepClick(ctx context.Context, step parser.Step) utils.S
 }
 
 func (b *BrowserDriver) stepType(ctx context.Context, step parser.Step, credentials *vault.Credentials) utils.StepResult {
-	b.logger.Debug("Executing recipe step", "action", step.Action, "selector", step.Selector, "value", step.Value)
+	// The value is not logged as it may contain credentials
+	b.logger.Debug("Executing recipe step", "action", step.Action, "selector", step.Selector)
 
-	step.Value = b.parseCredentialPlaceholders(step.Value, credentials)
+	step.Value = b.parseCredentialPlaceholders(step.Value, credentials, step)
 
 	opts := []chromedp.QueryOption{
 		chromedp.NodeReady,
@@ -330,7 +404,7 @@ func (b *BrowserDriver) stepType(ctx context.Context, step parser.Step, credenti
 	opts = b.getSelectorTypeQueryOptions(step.SelectorType, opts)
 
 	if err := chromedp.Run(ctx,
-		chromedp.SendKeys(step.Selector, step.Value, opts...),
+		typeWhenReady(step.Selector, step.Value, opts...),
 	); err != nil {
 		return utils.StepResult{Status: "error", Message: err.Error()}
 	}
@@ -376,26 +450,28 @@ func (b *BrowserDriver) stepDownloadAll(ctx context.Context, step parser.Step) u
 		return utils.StepResult{Status: "error", Message: err.Error()}
 	}
 
-	b.downloadedFilesCount = 0
-
-	// Limit nodes to 2 to prevent too many downloads at once/rate limiting
-	concurrentDownloadsPool := make(chan struct{}, 2)
-	wg := &sync.WaitGroup{}
-	chromedp.ListenTarget(ctx, func(v interface{}) {
+	// Limit downloads to 2 at once to prevent rate limiting
+	const maxConcurrentDownloads = 2
+	tracker := newDownloadTracker(defaultDownloadTimeout)
+	// The listener runs in another goroutine, so the downloads are counted by the tracker. Downloads completed before
+	// an error (e.g. the recipe timeout) are counted as well.
+	defer func() {
+		completed, _ := tracker.result()
+		b.downloadedFilesCount = len(completed)
+	}()
+	listenCtx, cancelListener := context.WithCancel(ctx)
+	defer cancelListener()
+	b.listenDownloads(listenCtx, func(v interface{}) {
+		completed := tracker.handleEvent(v)
 		switch ev := v.(type) {
 		case *browser.EventDownloadWillBegin:
 			b.logger.Debug("Executing recipe step ... download begins", "action", step.Action, "guid", ev.GUID, "url", ev.URL)
 		case *browser.EventDownloadProgress:
-			switch ev.State {
-			case browser.DownloadProgressStateCompleted:
+			switch {
+			case completed:
 				b.logger.Debug("Executing recipe step ... download completed", "action", step.Action, "guid", ev.GUID, "received_bytes", ev.ReceivedBytes)
-				b.downloadedFilesCount++
-				<-concurrentDownloadsPool
-				wg.Done()
-			case browser.DownloadProgressStateCanceled:
+			case ev.State == browser.DownloadProgressStateCanceled:
 				b.logger.Debug("Executing recipe step ... download cancelled", "action", step.Action, "guid", ev.GUID, "received_bytes", ev.ReceivedBytes)
-				<-concurrentDownloadsPool
-				wg.Done()
 			}
 		}
 	})
@@ -414,10 +490,11 @@ func (b *BrowserDriver) stepDownloadAll(ctx context.Context, step parser.Step) u
 		}
 
 		b.logger.Debug("Executing recipe step ... trigger download click", "action", step.Action, "selector", n.FullXPath()+step.Value, "loop", x, "max_files_downloaded", b.maxFilesDownloaded, "len(nodes)", len(nodes))
-		wg.Add(1)
-		concurrentDownloadsPool <- struct{}{}
+		if err := tracker.waitForSlot(ctx, maxConcurrentDownloads); err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
 		if err := chromedp.Run(ctx, fetch.Enable(), chromedp.Tasks{
-			chromedp.MouseClickNode(n),
+			clickNodeWhenReady(n),
 		}); err != nil {
 			// If we get an "Node does not have a layout object (-32000)" error here,
 			// this could mean that the node selector is not good enough.
@@ -431,8 +508,7 @@ func (b *BrowserDriver) stepDownloadAll(ctx context.Context, step parser.Step) u
 
 		if step.Value != "" {
 			if err := chromedp.Run(ctx, fetch.Enable(), chromedp.Tasks{
-				chromedp.WaitVisible(n.FullXPath() + step.Value),
-				chromedp.Click(n.FullXPath() + step.Value),
+				clickWhenReady(n.FullXPath() + step.Value),
 			}); err != nil {
 				return utils.StepResult{Status: "error", Message: err.Error()}
 			}
@@ -440,33 +516,145 @@ func (b *BrowserDriver) stepDownloadAll(ctx context.Context, step parser.Step) u
 
 		// Delay clicks to prevent too many downloads at once/rate limiting
 		b.logger.Debug("Executing recipe step ... sleeping a bit before we trigger the next download", "action", step.Action, "loop", x)
-		time.Sleep(sleepTime)
+		select {
+		case <-time.After(sleepTime):
+		case <-ctx.Done():
+			return utils.StepResult{Status: "error", Message: ctx.Err().Error()}
+		}
 		x++
 	}
 	b.logger.Debug("Executing recipe step ... waiting for downloads to complete", "action", step.Action)
-	wg.Wait()
-	close(concurrentDownloadsPool)
+	if err := tracker.wait(ctx); err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+
+	completed, failed := tracker.result()
+	files := make([]string, 0, len(completed))
+	for _, d := range completed {
+		files = append(files, filepath.Join(b.downloadsDirectory, d.Filename))
+		b.downloadUrls[d.Filename] = d.URL
+	}
+	for _, d := range failed {
+		b.logger.Warn("Download failed", "action", step.Action, "guid", d.GUID, "url", d.URL, "error", d.Error)
+	}
+	if len(failed) > 0 && len(completed) == 0 {
+		return utils.StepResult{Status: "error", Message: fmt.Sprintf("all %d downloads failed", len(failed))}
+	}
 
 	b.logger.Debug("Executing recipe step ... downloads completed", "action", step.Action)
-	b.logger.Info("All downloads completed")
+	b.logger.Info("All downloads completed", "num_files", len(completed), "num_failed", len(failed))
 
-	return utils.StepResult{Status: "success"}
+	return utils.StepResult{Status: "success", Artifacts: utils.StepArtifacts{Files: files}}
 }
 
 func (b *BrowserDriver) stepTransform(step parser.Step) utils.StepResult {
 	b.logger.Debug("Executing recipe step", "action", step.Action, "value", step.Value)
 
 	switch step.Value {
-	case "unzip":
-		zipFiles, err := utils.FindFiles(b.downloadsDirectory, ".zip")
+	case "unzip", "extract":
+		entries, err := os.ReadDir(b.downloadsDirectory)
 		if err != nil {
-			// TODO improve error handling
-			fmt.Println(err)
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+		options := utils.ExtractOptions{
+			Filter:   step.Extract.Filter,
+			Password: b.parseCredentialPlaceholders(step.Extract.Password, b.credentials, step),
+			MaxDepth: step.Extract.MaxDepth,
 		}
-		for _, s := range zipFiles {
-			b.logger.Debug("Executing recipe step ... unzipping file", "action", step.Action, "source", s, "destination", b.downloadsDirectory)
-			b.logger.Info("Unzipping file", "source", s, "destination", b.downloadsDirectory)
-			err := utils.UnzipFile(s, b.downloadsDirectory)
+		for _, entry := range entries {
+			if entry.IsDir() || !utils.IsArchive(entry.Name()) {
+				continue
+			}
+			s := filepath.Join(b.downloadsDirectory, entry.Name())
+			b.logger.Debug("Executing recipe step ... extracting archive", "action", step.Action, "source", s, "destination", b.downloadsDirectory, "filter", options.Filter)
+			b.logger.Info("Extracting archive", "source", s, "destination", b.downloadsDirectory)
+			files, err := utils.ExtractArchive(s, b.downloadsDirectory, options)
+			if err != nil {
+				return utils.StepResult{Status: "error", Message: fmt.Sprintf("error extracting %s: %s", entry.Name(), err)}
+			}
+			b.logger.Info("Archive extracted", "source", s, "num_files", len(files))
+		}
+	case "splitPdf":
+		files, err := b.downloadedFiles(step.SplitPdf.Filter, "*.pdf")
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+		var ranges []pdf.PageRange
+		if step.SplitPdf.Pages != "" {
+			ranges, err = pdf.ParsePageRanges(step.SplitPdf.Pages)
+			if err != nil {
+				return utils.StepResult{Status: "error", Message: err.Error()}
+			}
+		}
+		tool := pdf.New()
+		for _, f := range files {
+			fileRanges := ranges
+			if fileRanges == nil {
+				fileRanges, err = tool.BookmarkRanges(f)
+				if err != nil {
+					return utils.StepResult{Status: "error", Message: err.Error()}
+				}
+				// Without bookmarks, the file contains one invoice
+				if len(fileRanges) < 2 {
+					continue
+				}
+			}
+			parts, err := tool.Split(f, fileRanges)
+			if err != nil {
+				return utils.StepResult{Status: "error", Message: fmt.Sprintf("error splitting %s: %s", filepath.Base(f), err)}
+			}
+			b.logger.Info("PDF split", "source", f, "num_parts", len(parts))
+			// Only the parts are moved into the document archive
+			err = os.Remove(f)
+			if err != nil {
+				return utils.StepResult{Status: "error", Message: err.Error()}
+			}
+		}
+	case "mergePdf":
+		files, err := b.downloadedFiles(step.MergePdf.Filter, "*.pdf")
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+		if len(files) < 2 {
+			b.logger.Debug("Executing recipe step ... nothing to merge", "action", step.Action, "num_files", len(files))
+			break
+		}
+		output := step.MergePdf.Output
+		if output == "" {
+			output = "merged.pdf"
+		}
+		output = utils.UniqueFileName(filepath.Join(b.downloadsDirectory, filepath.Base(output)))
+		err = pdf.New().Merge(files, output)
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: fmt.Sprintf("error merging PDF files: %s", err)}
+		}
+		b.logger.Info("PDF files merged", "output", output, "num_files", len(files))
+		for _, f := range files {
+			err = os.Remove(f)
+			if err != nil {
+				return utils.StepResult{Status: "error", Message: err.Error()}
+			}
+		}
+	case "normalize-csv":
+		csvFiles, err := utils.FindFiles(b.downloadsDirectory, ".csv")
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+		format := statement.CSVFormat{
+			Delimiter:    step.Csv.Delimiter,
+			Encoding:     step.Csv.Encoding,
+			DecimalComma: step.Csv.DecimalComma,
+			SkipRows:     step.Csv.SkipRows,
+			DateFormat:   step.Csv.DateFormat,
+			Currency:     step.Csv.Currency,
+			Columns:      step.Csv.Columns,
+		}
+		for _, s := range csvFiles {
+			if strings.HasSuffix(s, statement.NORMALIZED_SUFFIX) {
+				continue
+			}
+			b.logger.Info("Normalizing CSV statement", "source", s, "destination", statement.NormalizedFileName(s))
+			err := statement.NormalizeCSVFile(s, statement.NormalizedFileName(s), format)
 			if err != nil {
 				return utils.StepResult{Status: "error", Message: err.Error()}
 			}
@@ -476,10 +664,36 @@ func (b *BrowserDriver) stepTransform(step parser.Step) utils.StepResult {
 	return utils.StepResult{Status: "success"}
 }
 
+// downloadedFiles returns the files of the downloads directory matching the glob filter (or defaultFilter), sorted by name.
+func (b *BrowserDriver) downloadedFiles(filter, defaultFilter string) ([]string, error) {
+	if filter == "" {
+		filter = defaultFilter
+	}
+	entries, err := os.ReadDir(b.downloadsDirectory)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match, err := filepath.Match(filter, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %s: %w", filter, err)
+		}
+		if match {
+			files = append(files, filepath.Join(b.downloadsDirectory, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
 func (b *BrowserDriver) stepMove(step parser.Step, documentArchive *archive.DocumentArchive) utils.StepResult {
 	b.logger.Debug("Executing recipe step", "action", step.Action, "value", step.Value)
 
 	b.newFilesCount = 0
+	var artifacts utils.StepArtifacts
 	err := filepath.WalkDir(b.downloadsDirectory, func(s string, d fs.DirEntry, e error) error {
 		if e != nil {
 			return e
@@ -501,24 +715,32 @@ func (b *BrowserDriver) stepMove(step parser.Step, documentArchive *archive.Docu
 				if err != nil {
 					return err
 				}
-				err = documentArchive.AddFile(dstFile)
+				err = documentArchive.AddFile(dstFile, b.supplier)
 				if err != nil {
 					return err
 				}
+				if metadata, ok := documentMetadata(b.tableRows, d.Name(), b.downloadUrls[d.Name()]); ok {
+					err = documentArchive.SetMetadata(dstFile, metadata)
+					if err != nil {
+						return err
+					}
+				}
+				artifacts.Files = append(artifacts.Files, dstFile)
 			}
 		}
 		return nil
 	})
 	if err != nil {
-		return utils.StepResult{Status: "error", Message: err.Error()}
+		return utils.StepResult{Status: "error", Message: err.Error(), Artifacts: artifacts}
 	}
 
-	return utils.StepResult{Status: "success"}
+	return utils.StepResult{Status: "success", Artifacts: artifacts}
 }
 
 func (b *BrowserDriver) stepRunScript(ctx context.Context, step parser.Step) utils.StepResult {
 	b.logger.Debug("Executing recipe step", "action", step.Action, "value", step.Value)
 
+	step.Value = utils.ReplacePlaceholders(step.Value, b.recipeVariables)
 	var res []string
 	if err := chromedp.Run(ctx,
 		chromedp.Evaluate(step.Value, &res),
@@ -531,93 +753,540 @@ func (b *BrowserDriver) stepRunScript(ctx context.Context, step parser.Step) uti
 func (b *BrowserDriver) stepRunScriptDownloadUrls(ctx context.Context, step parser.Step) utils.StepResult {
 	b.logger.Debug("Executing recipe step", "action", step.Action, "value", step.Value)
 
+	step.Value = utils.ReplacePlaceholders(step.Value, b.recipeVariables)
 	var res []string
 	chromedp.Evaluate(`Object.values(`+step.Value+`);`, &res)
 	for _, url := range res {
 		b.logger.Debug("Executing recipe step ... download", "action", step.Action, "url", url)
-		if err := chromedp.Run(ctx,
-			browser.
-				SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllowAndName).
-				WithDownloadPath(b.downloadsDirectory).
-				WithEventsEnabled(true),
-			chromedp.Navigate(url),
-			chromedp.ActionFunc(func(ctx context.Context) error {
-				_ = b.waitForLoadEvent(ctx)
-				return nil
-			}),
-		); err != nil {
+		tasks := chromedp.Tasks{chromedp.Navigate(url)}
+		// Downloads of a remote Chrome are retrieved over CDP (see retrieveRemoteDownload)
+		if b.RemoteDebuggingURL == "" {
+			tasks = append(chromedp.Tasks{
+				browser.
+					SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllowAndName).
+					WithDownloadPath(b.downloadsDirectory).
+					WithEventsEnabled(true),
+			}, tasks...)
+		}
+		err := b.runAndWaitForNavigation(ctx, step, tasks)
+		// Downloads don't load a new document, so there is nothing to wait for
+		if errors.Is(err, errNavigationTimeout) {
+			b.logger.Debug("Executing recipe step ... no page loaded", "action", step.Action, "url", url)
+			err = nil
+		}
+		if err != nil {
 			return utils.StepResult{Status: "error", Message: err.Error()}
 		}
 	}
 
+	if err := b.remoteDownloads.wait(ctx); err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+
 	return utils.StepResult{Status: "success"}
 }
 
-func (b *BrowserDriver) parseCredentialPlaceholders(value string, credentials *vault.Credentials) string {
-	value = strings.Replace(value, "{{ username }}", credentials.Username, -1)
-	value = strings.Replace(value, "{{ password }}", credentials.Password, -1)
-	value = strings.Replace(value, "{{ totp }}", credentials.Totp, -1)
-	return value
+func (b *BrowserDriver) stepExtract(ctx context.Context, step parser.Step) utils.StepResult {
+	b.logger.Debug("Executing recipe step", "action", step.Action, "selector", step.Selector, "attribute", step.Attribute, "regex", step.Regex, "variable", step.Variable)
+
+	if step.Variable == "" {
+		return utils.StepResult{Status: "error", Message: "extract step without a variable name"}
+	}
+
+	// Without a selector, the regex is applied to the full page source
+	var value string
+	var err error
+	switch {
+	case step.Selector == "":
+		err = chromedp.Run(ctx, chromedp.OuterHTML("html", &value, chromedp.ByQuery))
+	case step.Attribute != "":
+		opts := []chromedp.QueryOption{chromedp.NodeReady}
+		opts = b.getSelectorTypeQueryOptions(step.SelectorType, opts)
+		var ok bool
+		err = chromedp.Run(ctx, chromedp.AttributeValue(step.Selector, step.Attribute, &value, &ok, opts...))
+		if err == nil && !ok {
+			err = fmt.Errorf("attribute %s not found on %s", step.Attribute, step.Selector)
+		}
+	default:
+		opts := []chromedp.QueryOption{chromedp.NodeReady}
+		opts = b.getSelectorTypeQueryOptions(step.SelectorType, opts)
+		err = chromedp.Run(ctx, chromedp.Text(step.Selector, &value, opts...))
+	}
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+
+	if step.Regex != "" {
+		re, err := regexp.Compile(step.Regex)
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+		matches := re.FindStringSubmatch(value)
+		if matches == nil {
+			return utils.StepResult{Status: "error", Message: fmt.Sprintf("regex %s did not match for variable %s", step.Regex, step.Variable)}
+		}
+		// Prefer the first capture group, fall back to the full match
+		value = matches[0]
+		if len(matches) > 1 {
+			value = matches[1]
+		}
+	}
+
+	b.recipeVariables[step.Variable] = strings.TrimSpace(value)
+	b.logger.Debug("Executing recipe step ... variable extracted", "action", step.Action, "variable", step.Variable)
+
+	return utils.StepResult{Status: "success", Artifacts: utils.StepArtifacts{Variables: map[string]string{step.Variable: b.recipeVariables[step.Variable]}}}
 }
 
-func (b *BrowserDriver) disableImages(ctx context.Context) func(event interface{}) {
-	return func(event interface{}) {
-		switch ev := event.(type) {
-		case *fetch.EventRequestPaused:
-			go func() {
-				c := chromedp.FromContext(ctx)
-				ctx := cdp.WithExecutor(ctx, c.Target)
-				if ev.ResourceType == network.ResourceTypeImage {
-					err := fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient).Do(ctx)
-					if err != nil {
-						b.logger.Debug("Failed to block image request", "error", err.Error())
-						return
-					}
-				} else {
-					err := fetch.ContinueRequest(ev.RequestID).Do(ctx)
-					if err != nil {
-						b.logger.Debug("Failed to continue request", "error", err.Error())
-						return
-					}
-				}
+// stepDownloadWithSession downloads documents via plain HTTP requests instead of navigating the browser per file.
+// The browser is only used for the login: The session cookies and the user agent are handed over to the HTTP client.
+// Document urls are either read from the `attribute` (default: href) of all nodes matching `selector`
+// or returned as an array by the JavaScript expression in `value`.
+func (b *BrowserDriver) stepDownloadWithSession(ctx context.Context, step parser.Step) utils.StepResult {
+	b.logger.Debug("Executing recipe step", "action", step.Action, "selector", step.Selector, "value", step.Value, "concurrency", step.Concurrency)
+
+	urls, err := b.collectDownloadUrls(ctx, step)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+	if b.maxFilesDownloaded > 0 && len(urls) > b.maxFilesDownloaded {
+		urls = urls[:b.maxFilesDownloaded]
+	}
+
+	var userAgent string
+	cookieHeaders := make(map[string]string, len(urls))
+	err = chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		_, _, _, ua, _, err := browser.GetVersion().Do(ctx)
+		if err != nil {
+			return err
+		}
+		userAgent = ua
+
+		for _, u := range urls {
+			cookies, err := network.GetCookies().WithUrls([]string{u}).Do(ctx)
+			if err != nil {
+				return err
+			}
+			parts := make([]string, 0, len(cookies))
+			for _, c := range cookies {
+				parts = append(parts, c.Name+"="+c.Value)
+			}
+			cookieHeaders[u] = strings.Join(parts, "; ")
+		}
+		return nil
+	}))
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+
+	concurrency := step.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	concurrentDownloadsPool := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+	mu := &sync.Mutex{}
+	var downloadErrors []string
+	var artifacts utils.StepArtifacts
+	b.downloadedFilesCount = 0
+	for _, u := range urls {
+		wg.Add(1)
+		concurrentDownloadsPool <- struct{}{}
+		go func(u string) {
+			defer func() {
+				<-concurrentDownloadsPool
+				wg.Done()
 			}()
+
+			b.logger.Debug("Executing recipe step ... download", "action", step.Action, "url", u)
+			file, statusCode, err := b.downloadWithCookies(ctx, u, cookieHeaders[u], userAgent)
+			mu.Lock()
+			defer mu.Unlock()
+			if statusCode != 0 {
+				artifacts.HTTPStatusCodes = append(artifacts.HTTPStatusCodes, statusCode)
+			}
+			if err != nil {
+				b.logger.Error("Executing recipe step ... download failed", "action", step.Action, "url", u, "error", err)
+				downloadErrors = append(downloadErrors, err.Error())
+				return
+			}
+			artifacts.Files = append(artifacts.Files, file)
+			b.downloadUrls[filepath.Base(file)] = u
+			b.downloadedFilesCount++
+		}(u)
+	}
+	wg.Wait()
+	close(concurrentDownloadsPool)
+
+	if len(downloadErrors) > 0 {
+		return utils.StepResult{Status: "error", Message: strings.Join(downloadErrors, ", "), Artifacts: artifacts}
+	}
+
+	b.logger.Info("All downloads completed", "num_files", b.downloadedFilesCount)
+	return utils.StepResult{Status: "success", Artifacts: artifacts}
+}
+
+func (b *BrowserDriver) collectDownloadUrls(ctx context.Context, step parser.Step) ([]string, error) {
+	var urls []string
+	if step.Selector == "" {
+		script := utils.ReplacePlaceholders(step.Value, b.recipeVariables)
+		err := chromedp.Run(ctx, chromedp.Evaluate(`Object.values(`+script+`);`, &urls))
+		return urls, err
+	}
+
+	attribute := step.Attribute
+	if attribute == "" {
+		attribute = "href"
+	}
+	opts := []chromedp.QueryOption{}
+	opts = b.getSelectorTypeQueryOptions(step.SelectorType, opts)
+	var nodes []*cdp.Node
+	err := chromedp.Run(ctx, chromedp.Tasks{
+		chromedp.WaitReady(step.Selector, opts...),
+		chromedp.Nodes(step.Selector, &nodes, opts...),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var location string
+	if err := chromedp.Run(ctx, chromedp.Location(&location)); err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range nodes {
+		href := n.AttributeValue(attribute)
+		if href == "" {
+			continue
 		}
+		// Resolve relative links against the current page
+		u, err := base.Parse(href)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u.String())
 	}
+
+	return urls, nil
 }
 
-func (b *BrowserDriver) enableLifeCycleEvents() chromedp.ActionFunc {
-	return func(ctx context.Context) error {
-		err := page.Enable().Do(ctx)
+// downloadWithCookies downloads a document into the downloads directory and returns its path and the HTTP status code.
+func (b *BrowserDriver) downloadWithCookies(ctx context.Context, documentUrl, cookieHeader, userAgent string) (string, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, documentUrl, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if cookieHeader != "" {
+		req.Header.Set("Cookie", cookieHeader)
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	if b.locale != "" {
+		req.Header.Set("Accept-Language", acceptLanguage(b.locale))
+	}
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", resp.StatusCode, httpclient.StatusError(resp, "")
+	}
+
+	filename := documentFilename(resp.Header.Get("Content-Disposition"), resp.Request.URL.Path)
+	out, err := createUniqueFile(b.downloadsDirectory, filename)
+	if err != nil {
+		return "", resp.StatusCode, err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, resp.Body)
+	return out.Name(), resp.StatusCode, err
+}
+
+// stepDownloadViaFetch downloads documents by running `fetch()` inside the page (incl. all cookies and in-page auth context).
+// The response is transferred back base64 encoded via CDP.
+// This is needed for portals that block direct navigation to document urls.
+func (b *BrowserDriver) stepDownloadViaFetch(ctx context.Context, step parser.Step) utils.StepResult {
+	b.logger.Debug("Executing recipe step", "action", step.Action, "selector", step.Selector, "value", step.Value)
+
+	urls, err := b.collectDownloadUrls(ctx, step)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+	if b.maxFilesDownloaded > 0 && len(urls) > b.maxFilesDownloaded {
+		urls = urls[:b.maxFilesDownloaded]
+	}
+
+	var artifacts utils.StepArtifacts
+	b.downloadedFilesCount = 0
+	for _, u := range urls {
+		b.logger.Debug("Executing recipe step ... fetch", "action", step.Action, "url", u)
+
+		documentUrl, err := json.Marshal(u)
 		if err != nil {
-			return err
+			return utils.StepResult{Status: "error", Message: err.Error()}
 		}
-		err = page.SetLifecycleEventsEnabled(true).Do(ctx)
+		script := `(async () => {
+	const response = await fetch(` + string(documentUrl) + `, {credentials: 'include'});
+	if (!response.ok) {
+		throw new Error('fetch failed with status code ' + response.status);
+	}
+	const blob = await response.blob();
+	const data = await new Promise((resolve, reject) => {
+		const reader = new FileReader();
+		reader.onload = () => resolve(reader.result.split(',', 2)[1] || '');
+		reader.onerror = () => reject(reader.error);
+		reader.readAsDataURL(blob);
+	});
+	return {contentDisposition: response.headers.get('Content-Disposition') || '', url: response.url, status: response.status, data: data};
+})()`
+
+		var res struct {
+			ContentDisposition string `json:"contentDisposition"`
+			URL                string `json:"url"`
+			Status             int    `json:"status"`
+			Data               string `json:"data"`
+		}
+		err = chromedp.Run(ctx, chromedp.Evaluate(script, &res, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+			return p.WithAwaitPromise(true)
+		}))
 		if err != nil {
-			return err
+			return utils.StepResult{Status: "error", Message: err.Error()}
 		}
-		return nil
+
+		content, err := base64.StdEncoding.DecodeString(res.Data)
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+
+		urlPath := res.URL
+		if parsedUrl, err := url.Parse(res.URL); err == nil {
+			urlPath = parsedUrl.Path
+		}
+		artifacts.HTTPStatusCodes = append(artifacts.HTTPStatusCodes, res.Status)
+		out, err := createUniqueFile(b.downloadsDirectory, documentFilename(res.ContentDisposition, urlPath))
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error(), Artifacts: artifacts}
+		}
+		file := out.Name()
+		_, err = out.Write(content)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error(), Artifacts: artifacts}
+		}
+		artifacts.Files = append(artifacts.Files, file)
+		b.downloadUrls[filepath.Base(file)] = u
+		b.downloadedFilesCount++
 	}
+
+	b.logger.Info("All downloads completed", "num_files", b.downloadedFilesCount)
+	return utils.StepResult{Status: "success", Artifacts: artifacts}
 }
 
-func (b *BrowserDriver) waitForLoadEvent(ctx context.Context) error {
-	ch := make(chan struct{})
-	cctx, cancel := context.WithCancel(ctx)
+// stepCaptureResponse captures the body of the first XHR/fetch response whose url matches `regex`.
+// If `url` is set, the page is opened after the listener is in place to trigger the request.
+// The body is stored in the recipe variable `variable` and, if `value` is set, written as file into the downloads directory.
+func (b *BrowserDriver) stepCaptureResponse(ctx context.Context, step parser.Step) utils.StepResult {
+	b.logger.Debug("Executing recipe step", "action", step.Action, "regex", step.Regex, "url", step.URL, "variable", step.Variable, "value", step.Value)
+
+	re, err := regexp.Compile(step.Regex)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
 
-	chromedp.ListenTarget(cctx, func(ev interface{}) {
+	mu := &sync.Mutex{}
+	matchingRequests := make(map[network.RequestID]string)
+	statusCodes := make(map[network.RequestID]int)
+	finishedRequests := make(chan network.RequestID, 1)
+	listenerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chromedp.ListenTarget(listenerCtx, func(ev interface{}) {
 		switch e := ev.(type) {
-		case *page.EventLifecycleEvent:
-			if e.Name == "networkIdle" {
-				cancel()
-				close(ch)
+		case *network.EventResponseReceived:
+			if re.MatchString(e.Response.URL) {
+				mu.Lock()
+				matchingRequests[e.RequestID] = e.Response.URL
+				statusCodes[e.RequestID] = int(e.Response.Status)
+				mu.Unlock()
+			}
+		case *network.EventLoadingFinished:
+			mu.Lock()
+			_, ok := matchingRequests[e.RequestID]
+			mu.Unlock()
+			if ok {
+				select {
+				case finishedRequests <- e.RequestID:
+				default:
+				}
 			}
 		}
 	})
 
+	tasks := chromedp.Tasks{network.Enable()}
+	if step.URL != "" {
+		tasks = append(tasks, chromedp.Navigate(utils.ReplacePlaceholders(step.URL, b.recipeVariables)))
+	}
+	if err := chromedp.Run(ctx, tasks); err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+
+	var requestID network.RequestID
 	select {
-	case <-ch:
-		return nil
+	case requestID = <-finishedRequests:
 	case <-ctx.Done():
-		return ctx.Err()
+		return utils.StepResult{Status: "error", Message: ctx.Err().Error()}
+	}
+
+	var body []byte
+	err = chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		body, err = network.GetResponseBody(requestID).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+	mu.Lock()
+	b.logger.Debug("Executing recipe step ... response captured", "action", step.Action, "url", matchingRequests[requestID], "bytes", len(body))
+	artifacts := utils.StepArtifacts{HTTPStatusCodes: []int{statusCodes[requestID]}}
+	mu.Unlock()
+
+	if step.Variable != "" {
+		// The response body is not an artifact, it can be large
+		b.recipeVariables[step.Variable] = string(body)
+	}
+	if step.Value != "" {
+		file := filepath.Join(b.downloadsDirectory, filepath.Base(utils.ReplacePlaceholders(step.Value, b.recipeVariables)))
+		err = os.WriteFile(file, body, 0644)
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error(), Artifacts: artifacts}
+		}
+		artifacts.Files = append(artifacts.Files, file)
+		b.downloadedFilesCount++
+	}
+
+	return utils.StepResult{Status: "success", Artifacts: artifacts}
+}
+
+// paperSizes contains the width and height (in inches) of supported paper formats for the `printToPdf` step.
+var paperSizes = map[string][2]float64{
+	"A4":     {8.27, 11.69},
+	"A5":     {5.83, 8.27},
+	"Letter": {8.5, 11},
+	"Legal":  {8.5, 14},
+}
+
+// stepPrintToPdf prints the current page into a PDF file for suppliers that only render invoices as HTML.
+// The file is stored in the downloads directory (filename from `value`) and processed by a later `move` step.
+func (b *BrowserDriver) stepPrintToPdf(ctx context.Context, step parser.Step) utils.StepResult {
+	b.logger.Debug("Executing recipe step", "action", step.Action, "value", step.Value, "paper_format", step.Pdf.PaperFormat)
+
+	filename := utils.ReplacePlaceholders(step.Value, b.recipeVariables)
+	if filename == "" {
+		filename = fmt.Sprintf("invoice-%s.pdf", time.Now().Format("2006-01-02-150405"))
+	}
+	// Sanitize the filename to prevent path traversal
+	filename = filepath.Base(filename)
+
+	params := page.PrintToPDF().
+		WithLandscape(step.Pdf.Landscape).
+		WithPrintBackground(step.Pdf.PrintBackground).
+		WithMarginTop(step.Pdf.MarginTop).
+		WithMarginBottom(step.Pdf.MarginBottom).
+		WithMarginLeft(step.Pdf.MarginLeft).
+		WithMarginRight(step.Pdf.MarginRight)
+	if step.Pdf.PaperFormat != "" {
+		size, ok := paperSizes[step.Pdf.PaperFormat]
+		if !ok {
+			return utils.StepResult{Status: "error", Message: fmt.Sprintf("unsupported paper format %s", step.Pdf.PaperFormat)}
+		}
+		params = params.WithPaperWidth(size[0]).WithPaperHeight(size[1])
+	}
+
+	var content []byte
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		content, _, err = params.Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+
+	file := filepath.Join(b.downloadsDirectory, filename)
+	err = os.WriteFile(file, content, 0644)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+	b.downloadedFilesCount++
+
+	return utils.StepResult{Status: "success", Artifacts: utils.StepArtifacts{Files: []string{file}}}
+}
+
+// documentFilename determines the local filename of a downloaded document.
+// The filename of the Content-Disposition header is preferred over the last segment of the url path.
+func documentFilename(contentDisposition, urlPath string) string {
+	filename := ""
+	if _, params, err := mime.ParseMediaType(contentDisposition); err == nil {
+		filename = params["filename"]
+	}
+	if filename == "" {
+		filename = path.Base(urlPath)
+	}
+	// Sanitize the filename to prevent path traversal
+	filename = filepath.Base(filename)
+	if filename == "." || filename == "/" || filename == ".." {
+		filename = utils.RandomString(16) + ".pdf"
+	}
+
+	return filename
+}
+
+// createUniqueFile creates filename in directory. If the file exists (e.g. `download` of the urls `/download?id=1` and
+// `/download?id=2`), a number is added to the name (see uniqueFilename), so concurrent downloads never overwrite each other.
+func createUniqueFile(directory, filename string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(filepath.Join(directory, uniqueFilename(directory, filename)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+}
+
+func (b *BrowserDriver) parseCredentialPlaceholders(value string, credentials *vault.Credentials, step parser.Step) string {
+	for _, c := range []struct{ field, value string }{{"username", credentials.Username}, {"password", credentials.Password}, {"totp", credentials.Totp}} {
+		placeholder := "{{ " + c.field + " }}"
+		if !strings.Contains(value, placeholder) {
+			continue
+		}
+		b.AuditLog.Record(audit.Event{Kind: audit.KIND_CREDENTIAL, Field: c.field, Source: credentials.Id, Supplier: b.supplier, Step: step.Action})
+		value = strings.Replace(value, placeholder, c.value, -1)
+	}
+	value = utils.ReplacePlaceholders(value, b.recipeVariables)
+	return value
+}
+
+func (b *BrowserDriver) enableLifeCycleEvents() chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		err := page.Enable().Do(ctx)
+		if err != nil {
+			return err
+		}
+		err = page.SetLifecycleEventsEnabled(true).Do(ctx)
+		if err != nil {
+			return err
+		}
+		return nil
 	}
 }
 
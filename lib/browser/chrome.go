@@ -0,0 +1,304 @@
+package browser
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+const (
+	// Chrome versions chromedp-undetected is known to work with.
+	// Older versions miss CDP features, newer ones may change the detection surface.
+	ChromeMinSupportedVersion = 120
+	ChromeMaxTestedVersion    = 131
+
+	// ChromePinnedVersion is the Chrome for Testing build installed by `buchhalter chrome install`.
+	ChromePinnedVersion = "131.0.6778.85"
+
+	chromeForTestingURL = "https://storage.googleapis.com/chrome-for-testing-public/%s/%s/chrome-%s.zip"
+)
+
+// chromePinnedChecksums are the SHA-256 checksums of the Chrome for Testing archives of ChromePinnedVersion by platform.
+// Archives of platforms without a checksum aren't installed.
+// TODO Add the checksums of the archives of ChromePinnedVersion (sha256sum chrome-<platform>.zip)
+var chromePinnedChecksums = map[string]string{}
+
+const (
+	ChromeNotFoundErrorCode        int = 9201
+	ChromeVersionTooOldErrorCode   int = 9202
+	ChromeVersionUntestedErrorCode int = 9203
+)
+
+type ChromeNotFoundError struct {
+	Code int
+	Err  error
+}
+
+func (e ChromeNotFoundError) Error() string {
+	return fmt.Sprintf("Error %d chrome could not be found: %s", e.Code, e.Err.Error())
+}
+
+type ChromeVersionError struct {
+	Code    int
+	Version string
+	Min     int
+	Max     int
+}
+
+func (e ChromeVersionError) Error() string {
+	return fmt.Sprintf("Error %d chrome version %s is outside of the supported range %d - %d", e.Code, e.Version, e.Min, e.Max)
+}
+
+var chromeVersionPattern = regexp.MustCompile(`(\d+)\.\d+\.\d+\.\d+`)
+
+// chromeExecutables are the names and paths chromedp looks for Chrome, in the same order.
+func chromeExecutables() []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{
+			"/Applications/Chromium.app/Contents/MacOS/Chromium",
+			"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+		}
+	case "windows":
+		return []string{
+			"chrome",
+			"chrome.exe",
+			filepath.Join(os.Getenv("ProgramFiles"), "Google/Chrome/Application/chrome.exe"),
+			filepath.Join(os.Getenv("ProgramFiles(x86)"), "Google/Chrome/Application/chrome.exe"),
+			filepath.Join(os.Getenv("LocalAppData"), "Google/Chrome/Application/chrome.exe"),
+		}
+	}
+	return []string{
+		"headless_shell",
+		"headless-shell",
+		"chromium",
+		"chromium-browser",
+		"google-chrome",
+		"google-chrome-stable",
+		"google-chrome-beta",
+		"google-chrome-unstable",
+		"/usr/bin/google-chrome",
+	}
+}
+
+// DetectChromeVersion returns the version of the Chrome executable at chromePath.
+// Without a path, Chrome is searched at the places chromedp launches it from.
+func DetectChromeVersion(chromePath string) (string, error) {
+	candidates := chromeExecutables()
+	if chromePath != "" {
+		candidates = []string{chromePath}
+	}
+
+	for _, candidate := range candidates {
+		executable, err := exec.LookPath(candidate)
+		if err != nil {
+			continue
+		}
+		output, err := exec.Command(executable, "--version").Output()
+		if err != nil {
+			continue
+		}
+		if version := chromeVersionPattern.FindString(string(output)); version != "" {
+			return version, nil
+		}
+	}
+
+	return "", ChromeNotFoundError{Code: ChromeNotFoundErrorCode, Err: errors.New("no chrome executable found (set buchhalter_chrome_path or run `buchhalter chrome install`)")}
+}
+
+// CheckChromeVersion returns a ChromeVersionError if version is outside of the known-good version range.
+func CheckChromeVersion(version string) error {
+	majorVersion, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return fmt.Errorf("invalid chrome version %s: %w", version, err)
+	}
+
+	if majorVersion < ChromeMinSupportedVersion {
+		return ChromeVersionError{Code: ChromeVersionTooOldErrorCode, Version: version, Min: ChromeMinSupportedVersion, Max: ChromeMaxTestedVersion}
+	}
+	if majorVersion > ChromeMaxTestedVersion {
+		return ChromeVersionError{Code: ChromeVersionUntestedErrorCode, Version: version, Min: ChromeMinSupportedVersion, Max: ChromeMaxTestedVersion}
+	}
+	return nil
+}
+
+// GetHumanReadableChromeErrorMessage converts errors of DetectChromeVersion and CheckChromeVersion into a message for users.
+func GetHumanReadableChromeErrorMessage(err error) string {
+	var notFoundError ChromeNotFoundError
+	var versionError ChromeVersionError
+	switch {
+	case errors.As(err, &notFoundError):
+		return "Google Chrome could not be found. Please install it or run `buchhalter chrome install`."
+	case errors.As(err, &versionError) && versionError.Code == ChromeVersionTooOldErrorCode:
+		return fmt.Sprintf("Your Chrome %s is too old (at least %d is required). Please update it or run `buchhalter chrome install`.", versionError.Version, versionError.Min)
+	case errors.As(err, &versionError):
+		return fmt.Sprintf("Your Chrome %s is newer than the versions buchhalter is tested with (up to %d). If recipes fail, run `buchhalter chrome install`.", versionError.Version, versionError.Max)
+	}
+	return err.Error()
+}
+
+// chromeForTestingPlatform returns the platform name of Chrome for Testing builds for this machine.
+func chromeForTestingPlatform() (string, error) {
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "linux/amd64":
+		return "linux64", nil
+	case "darwin/arm64":
+		return "mac-arm64", nil
+	case "darwin/amd64":
+		return "mac-x64", nil
+	case "windows/amd64":
+		return "win64", nil
+	case "windows/386":
+		return "win32", nil
+	}
+	return "", fmt.Errorf("no chrome for testing builds available for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// InstallPinnedChrome downloads the pinned Chrome for Testing build into installDirectory.
+// The archive is only extracted if its checksum matches the pinned one. It returns the path of the Chrome executable.
+func InstallPinnedChrome(ctx context.Context, httpClient *httpclient.Client, installDirectory string) (string, error) {
+	platform, err := chromeForTestingPlatform()
+	if err != nil {
+		return "", err
+	}
+	checksum, ok := chromePinnedChecksums[platform]
+	if !ok {
+		return "", fmt.Errorf("no checksum of chrome %s for %s pinned, install chrome %s manually and set buchhalter_chrome_path", ChromePinnedVersion, platform, ChromePinnedVersion)
+	}
+
+	versionDirectory := filepath.Join(installDirectory, ChromePinnedVersion)
+	executable := filepath.Join(versionDirectory, "chrome-"+platform, "chrome")
+	switch {
+	case strings.HasPrefix(platform, "mac"):
+		executable = filepath.Join(versionDirectory, "chrome-"+platform, "Google Chrome for Testing.app", "Contents", "MacOS", "Google Chrome for Testing")
+	case strings.HasPrefix(platform, "win"):
+		executable += ".exe"
+	}
+	if _, err := os.Stat(executable); err == nil {
+		return executable, nil
+	}
+
+	archiveFile, err := os.CreateTemp("", "buchhalter-chrome-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archiveFile.Name())
+	// The archive has more than 100 MB
+	err = downloadVerified(ctx, httpClient.WithTimeout(10*time.Minute), fmt.Sprintf(chromeForTestingURL, ChromePinnedVersion, platform, platform), checksum, archiveFile)
+	archiveFile.Close()
+	if err != nil {
+		return "", fmt.Errorf("error downloading chrome %s: %w", ChromePinnedVersion, err)
+	}
+
+	err = extractArchive(archiveFile.Name(), versionDirectory)
+	if err != nil {
+		return "", err
+	}
+	return executable, nil
+}
+
+// downloadVerified writes the response body of url to out and fails if its SHA-256 checksum isn't checksum (hex).
+func downloadVerified(ctx context.Context, httpClient *httpclient.Client, url, checksum string, out io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpclient.StatusError(resp, "")
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), resp.Body)
+	if err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, checksum) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", checksum, actual)
+	}
+	return nil
+}
+
+// extractArchive extracts a zip file with its directory structure, file modes and symlinks (as used by app bundles).
+// Symlinks have to point into destination.
+func extractArchive(source, destination string) error {
+	reader, err := zip.OpenReader(source)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		target := filepath.Join(destination, file.Name)
+		// Prevent path traversal
+		if !strings.HasPrefix(target, filepath.Clean(destination)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid file path in archive: %s", file.Name)
+		}
+
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		err := extractArchiveFile(file, target, destination)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractArchiveFile(file *zip.File, target, destination string) error {
+	in, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if file.Mode()&os.ModeSymlink != 0 {
+		linkTarget, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		// Later entries would be written through a symlink pointing outside of destination
+		resolvedTarget := filepath.Join(filepath.Dir(target), string(linkTarget))
+		if filepath.IsAbs(string(linkTarget)) || !strings.HasPrefix(resolvedTarget, filepath.Clean(destination)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid symlink in archive: %s -> %s", file.Name, linkTarget)
+		}
+		return os.Symlink(string(linkTarget), target)
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, file.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
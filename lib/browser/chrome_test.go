@@ -0,0 +1,85 @@
+package browser
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+func TestExtractArchiveSymlinks(t *testing.T) {
+	tests := []struct {
+		name       string
+		linkTarget string
+		valid      bool
+	}{
+		{"relative", "Versions/Current/Chrome", true},
+		{"absolute", "/etc", false},
+		{"escaping", "../../outside", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "chrome.zip")
+			out, err := os.Create(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := zip.NewWriter(out)
+			header := &zip.FileHeader{Name: "chrome/link"}
+			header.SetMode(os.ModeSymlink | 0777)
+			entry, err := w.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry.Write([]byte(test.linkTarget))
+			w.Close()
+			out.Close()
+
+			err = extractArchive(archive, t.TempDir())
+			if test.valid && err != nil {
+				t.Errorf("expected the symlink to be extracted, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected the symlink to be rejected")
+			}
+		})
+	}
+}
+
+func TestDownloadVerified(t *testing.T) {
+	archive := []byte("chrome archive")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	httpClient := httpclient.New(logger, 5*time.Second, 0)
+	checksum := sha256.Sum256(archive)
+
+	var out bytes.Buffer
+	err := downloadVerified(context.Background(), httpClient, server.URL, hex.EncodeToString(checksum[:]), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Error("expected the archive to be written")
+	}
+
+	err = downloadVerified(context.Background(), httpClient, server.URL, hex.EncodeToString(make([]byte, sha256.Size)), io.Discard)
+	if err == nil {
+		t.Error("expected a checksum mismatch")
+	}
+}
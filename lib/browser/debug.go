@@ -0,0 +1,122 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"buchhalter/lib/parser"
+	"buchhalter/lib/redact"
+
+	"github.com/chromedp/chromedp"
+)
+
+// scrubInputsScript removes all values typed into (or prefilled in) form fields before debug artifacts are captured.
+const scrubInputsScript = `(() => {
+	const ignoredTypes = ['submit', 'button', 'reset', 'checkbox', 'radio', 'image'];
+	document.querySelectorAll('input, textarea').forEach((el) => {
+		if (ignoredTypes.includes((el.type || '').toLowerCase())) {
+			return;
+		}
+		el.value = '';
+		el.removeAttribute('value');
+	});
+	document.querySelectorAll('[contenteditable="true"]').forEach((el) => {
+		el.textContent = '';
+	});
+	return true;
+})()`
+
+var valueAttributePattern = regexp.MustCompile(`(?i)\svalue="[^"]*"`)
+
+// DebugArtifactMetadata describes a failed recipe step, stored next to its screenshot and DOM dump.
+type DebugArtifactMetadata struct {
+	Supplier        string    `json:"supplier"`
+	RecipeVersion   string    `json:"recipeVersion"`
+	StepNumber      int       `json:"stepNumber"`
+	StepAction      string    `json:"stepAction"`
+	StepDescription string    `json:"stepDescription"`
+	ErrorMessage    string    `json:"errorMessage"`
+	URL             string    `json:"url"`
+	ChromeVersion   string    `json:"chromeVersion"`
+	CapturedAt      time.Time `json:"capturedAt"`
+}
+
+// captureDebugArtifacts stores a screenshot, the DOM and metadata of a failed recipe step.
+// All form field values, registered secrets and URL tokens are removed before anything is written to disk,
+// so the artifacts are safe to share with recipe maintainers. Returns the path of the screenshot, empty if none was captured.
+func (b *BrowserDriver) captureDebugArtifacts(ctx context.Context, recipe *parser.Recipe, stepNumber int, step parser.Step, errorMessage string) string {
+	if b.debugArtifactsDirectory == "" {
+		return ""
+	}
+
+	artifactsDirectory := filepath.Join(b.debugArtifactsDirectory, recipe.Supplier, time.Now().Format("20060102-150405"))
+	b.logger.Info("Capturing debug artifacts of failed recipe step ...", "supplier", recipe.Supplier, "step", stepNumber, "directory", artifactsDirectory)
+
+	// Don't let a broken page block the shutdown of the recipe
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	var scrubbed bool
+	var currentURL, dom string
+	var screenshot []byte
+	err := chromedp.Run(ctx,
+		chromedp.Evaluate(scrubInputsScript, &scrubbed),
+		chromedp.Location(&currentURL),
+		chromedp.OuterHTML("html", &dom, chromedp.ByQuery),
+		chromedp.CaptureScreenshot(&screenshot),
+	)
+	if err != nil {
+		b.logger.Error("Error capturing debug artifacts", "supplier", recipe.Supplier, "error", err)
+		return ""
+	}
+
+	err = os.MkdirAll(artifactsDirectory, 0700)
+	if err != nil {
+		b.logger.Error("Error creating debug artifacts directory", "directory", artifactsDirectory, "error", err)
+		return ""
+	}
+
+	dom = valueAttributePattern.ReplaceAllString(dom, "")
+	dom = redact.Text(dom)
+
+	metadata, err := json.MarshalIndent(DebugArtifactMetadata{
+		Supplier:        recipe.Supplier,
+		RecipeVersion:   recipe.Version,
+		StepNumber:      stepNumber,
+		StepAction:      step.Action,
+		StepDescription: step.Description,
+		ErrorMessage:    redact.String(errorMessage),
+		URL:             redact.URL(currentURL),
+		ChromeVersion:   b.ChromeVersion,
+		CapturedAt:      time.Now(),
+	}, "", "  ")
+	if err != nil {
+		b.logger.Error("Error encoding debug artifacts metadata", "error", err)
+		return ""
+	}
+
+	files := map[string][]byte{
+		"metadata.json":  metadata,
+		"dom.html":       []byte(dom),
+		"screenshot.png": screenshot,
+	}
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(artifactsDirectory, name), content, 0600)
+		if err != nil {
+			b.logger.Error("Error writing debug artifact", "file", name, "error", err)
+			return ""
+		}
+	}
+
+	b.logger.Info("Capturing debug artifacts of failed recipe step ... completed", "supplier", recipe.Supplier, "directory", artifactsDirectory)
+	return filepath.Join(artifactsDirectory, "screenshot.png")
+}
+
+// DebugArtifactsDirectory returns the directory debug artifacts of failed recipe steps are stored in.
+func DebugArtifactsDirectory(buchhalterDirectory string) string {
+	return filepath.Join(buchhalterDirectory, "_debug")
+}
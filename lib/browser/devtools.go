@@ -0,0 +1,37 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+
+	"buchhalter/lib/i18n"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+)
+
+// devToolsChromeFlags allows Chrome DevTools served on the remote debugging port to connect to the browser.
+func devToolsChromeFlags(port int) []chromedp.ExecAllocatorOption {
+	return []chromedp.ExecAllocatorOption{
+		chromedp.Flag("remote-allow-origins", fmt.Sprintf("http://127.0.0.1:%d", port)),
+	}
+}
+
+// devToolsURL returns the URL of Chrome DevTools inspecting the page with the target id on the remote debugging port.
+func devToolsURL(port int, targetID target.ID) string {
+	return fmt.Sprintf("http://127.0.0.1:%d/devtools/inspector.html?ws=127.0.0.1:%d/devtools/page/%s", port, port, targetID)
+}
+
+// announceDevTools prints the DevTools URL of the page the recipe runs in above the sync view.
+func (b *BrowserDriver) announceDevTools(ctx context.Context, p *tea.Program, supplier string) {
+	c := chromedp.FromContext(ctx)
+	if c == nil || c.Target == nil {
+		b.logger.Warn("No browser page to inspect with Chrome DevTools", "supplier", supplier)
+		return
+	}
+
+	url := devToolsURL(b.DevToolsPort, c.Target.TargetID)
+	b.logger.Info("Chrome DevTools available", "supplier", supplier, "url", url)
+	p.Println(i18n.Tf("Inspect %s with Chrome DevTools: %s", supplier, url))
+}
@@ -0,0 +1,11 @@
+package browser
+
+import "testing"
+
+func TestDevToolsURL(t *testing.T) {
+	url := devToolsURL(9222, "E1C2D3")
+	expected := "http://127.0.0.1:9222/devtools/inspector.html?ws=127.0.0.1:9222/devtools/page/E1C2D3"
+	if url != expected {
+		t.Errorf("expected %s, got %s", expected, url)
+	}
+}
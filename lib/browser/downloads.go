@@ -0,0 +1,145 @@
+package browser
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+)
+
+const (
+	// defaultDownloadTimeout is the maximum time a download may not make any progress before it counts as failed
+	defaultDownloadTimeout = 120 * time.Second
+
+	downloadPollInterval = 250 * time.Millisecond
+)
+
+// trackedDownload is a single browser download identified by its GUID.
+type trackedDownload struct {
+	GUID     string
+	URL      string
+	Filename string
+	State    browser.DownloadProgressState
+	// Error is set if the download was canceled or timed out
+	Error string
+
+	lastProgress time.Time
+}
+
+func (d *trackedDownload) finished() bool {
+	return d.State == browser.DownloadProgressStateCompleted || d.Error != ""
+}
+
+// downloadTracker keeps track of all downloads started by a recipe step.
+// Downloads are keyed by their GUID, so failed or unexpected downloads can't confuse the bookkeeping.
+type downloadTracker struct {
+	mu        sync.Mutex
+	downloads map[string]*trackedDownload
+	// order of the GUIDs in which the downloads started
+	order   []string
+	timeout time.Duration
+	now     func() time.Time
+}
+
+func newDownloadTracker(timeout time.Duration) *downloadTracker {
+	if timeout <= 0 {
+		timeout = defaultDownloadTimeout
+	}
+	return &downloadTracker{
+		downloads: map[string]*trackedDownload{},
+		timeout:   timeout,
+		now:       time.Now,
+	}
+}
+
+// handleEvent processes chromedp target events. It returns true if the event completed a download.
+func (t *downloadTracker) handleEvent(ev interface{}) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch e := ev.(type) {
+	case *browser.EventDownloadWillBegin:
+		d := t.download(e.GUID)
+		d.URL = e.URL
+		d.Filename = e.SuggestedFilename
+	case *browser.EventDownloadProgress:
+		d := t.download(e.GUID)
+		if d.finished() {
+			return false
+		}
+		d.State = e.State
+		d.lastProgress = t.now()
+		switch e.State {
+		case browser.DownloadProgressStateCompleted:
+			return true
+		case browser.DownloadProgressStateCanceled:
+			d.Error = "canceled"
+		}
+	}
+	return false
+}
+
+// download returns the download with guid and registers it if it is unknown. The caller must hold the lock.
+func (t *downloadTracker) download(guid string) *trackedDownload {
+	d, ok := t.downloads[guid]
+	if !ok {
+		d = &trackedDownload{GUID: guid, State: browser.DownloadProgressStateInProgress, lastProgress: t.now()}
+		t.downloads[guid] = d
+		t.order = append(t.order, guid)
+	}
+	return d
+}
+
+// active returns the number of running downloads and marks downloads without progress as timed out.
+func (t *downloadTracker) active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, d := range t.downloads {
+		if d.finished() {
+			continue
+		}
+		if t.now().Sub(d.lastProgress) > t.timeout {
+			d.Error = "timeout"
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// waitForSlot blocks until less than max downloads are running.
+func (t *downloadTracker) waitForSlot(ctx context.Context, max int) error {
+	for t.active() >= max {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(downloadPollInterval):
+		}
+	}
+	return nil
+}
+
+// wait blocks until all downloads are completed, canceled or timed out.
+func (t *downloadTracker) wait(ctx context.Context) error {
+	return t.waitForSlot(ctx, 1)
+}
+
+// result returns the completed and the failed downloads in the order they started.
+func (t *downloadTracker) result() (completed []trackedDownload, failed []trackedDownload) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, guid := range t.order {
+		d := *t.downloads[guid]
+		switch {
+		case d.State == browser.DownloadProgressStateCompleted:
+			completed = append(completed, d)
+		case d.Error != "":
+			failed = append(failed, d)
+		}
+	}
+	return completed, failed
+}
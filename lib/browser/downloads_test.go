@@ -0,0 +1,118 @@
+package browser
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"buchhalter/lib/httpclient"
+
+	"github.com/chromedp/cdproto/browser"
+)
+
+func TestDownloadTrackerTracksDownloadsByGUID(t *testing.T) {
+	tracker := newDownloadTracker(time.Minute)
+
+	tracker.handleEvent(&browser.EventDownloadWillBegin{GUID: "a", URL: "https://example.com/a.pdf", SuggestedFilename: "a.pdf"})
+	tracker.handleEvent(&browser.EventDownloadWillBegin{GUID: "b", URL: "https://example.com/b.pdf", SuggestedFilename: "b.pdf"})
+	// An unexpected third download
+	tracker.handleEvent(&browser.EventDownloadWillBegin{GUID: "c", URL: "https://example.com/c.pdf", SuggestedFilename: "c.pdf"})
+	if n := tracker.active(); n != 3 {
+		t.Fatalf("expected 3 active downloads, got %d", n)
+	}
+
+	if !tracker.handleEvent(&browser.EventDownloadProgress{GUID: "a", State: browser.DownloadProgressStateCompleted}) {
+		t.Errorf("expected completion of download a to be reported")
+	}
+	// Duplicate completion events must not be counted twice
+	if tracker.handleEvent(&browser.EventDownloadProgress{GUID: "a", State: browser.DownloadProgressStateCompleted}) {
+		t.Errorf("expected duplicate completion of download a to be ignored")
+	}
+	tracker.handleEvent(&browser.EventDownloadProgress{GUID: "b", State: browser.DownloadProgressStateCanceled})
+	tracker.handleEvent(&browser.EventDownloadProgress{GUID: "c", State: browser.DownloadProgressStateCompleted})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tracker.wait(ctx); err != nil {
+		t.Fatalf("expected all downloads to be finished, got %s", err)
+	}
+
+	completed, failed := tracker.result()
+	if len(completed) != 2 || completed[0].Filename != "a.pdf" || completed[1].Filename != "c.pdf" {
+		t.Errorf("unexpected completed downloads: %+v", completed)
+	}
+	if len(failed) != 1 || failed[0].GUID != "b" || failed[0].Error != "canceled" {
+		t.Errorf("unexpected failed downloads: %+v", failed)
+	}
+}
+
+func TestDownloadTrackerTimesOutStalledDownloads(t *testing.T) {
+	now := time.Now()
+	tracker := newDownloadTracker(time.Minute)
+	tracker.now = func() time.Time { return now }
+
+	tracker.handleEvent(&browser.EventDownloadWillBegin{GUID: "a", SuggestedFilename: "a.pdf"})
+	tracker.handleEvent(&browser.EventDownloadProgress{GUID: "a", State: browser.DownloadProgressStateInProgress})
+
+	now = now.Add(2 * time.Minute)
+	if n := tracker.active(); n != 0 {
+		t.Fatalf("expected stalled download to be finished, got %d active downloads", n)
+	}
+
+	_, failed := tracker.result()
+	if len(failed) != 1 || failed[0].Error != "timeout" {
+		t.Errorf("unexpected failed downloads: %+v", failed)
+	}
+}
+
+func TestDownloadWithCookiesKeepsDownloadsWithTheSameName(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("invoice " + r.URL.Query().Get("id")))
+	}))
+	defer server.Close()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	b := &BrowserDriver{logger: logger, httpClient: httpclient.New(logger, 5*time.Second, 0), downloadsDirectory: t.TempDir()}
+
+	var mu sync.Mutex
+	var files []string
+	var wg sync.WaitGroup
+	for _, id := range []string{"1", "2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			file, _, err := b.downloadWithCookies(context.Background(), server.URL+"/download?id="+id, "", "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			files = append(files, file)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(files)
+	if len(files) != 2 || filepath.Base(files[0]) != "download" || filepath.Base(files[1]) != "download (1)" {
+		t.Fatalf("expected the files download and download (1), got %v", files)
+	}
+	contents := []string{}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(content))
+	}
+	sort.Strings(contents)
+	if contents[0] != "invoice 1" || contents[1] != "invoice 2" {
+		t.Errorf("expected both invoices, got %v", contents)
+	}
+}
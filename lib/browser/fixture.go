@@ -0,0 +1,124 @@
+package browser
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"buchhalter/lib/parser"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// recordedResponse is a response whose body is not loaded yet
+type recordedResponse struct {
+	method  string
+	url     string
+	status  int
+	headers map[string]string
+}
+
+// recordNetwork records all responses of the page into the fixture recorder.
+func (b *BrowserDriver) recordNetwork(ctx context.Context) error {
+	err := chromedp.Run(ctx, network.Enable())
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	methods := map[network.RequestID]string{}
+	responses := map[network.RequestID]recordedResponse{}
+	chromedp.ListenTarget(ctx, func(event interface{}) {
+		switch ev := event.(type) {
+		case *network.EventRequestWillBeSent:
+			mu.Lock()
+			methods[ev.RequestID] = ev.Request.Method
+			mu.Unlock()
+		case *network.EventResponseReceived:
+			headers := map[string]string{}
+			for name, value := range ev.Response.Headers {
+				if s, ok := value.(string); ok {
+					headers[name] = s
+				}
+			}
+			mu.Lock()
+			responses[ev.RequestID] = recordedResponse{
+				method:  methods[ev.RequestID],
+				url:     ev.Response.URL,
+				status:  int(ev.Response.Status),
+				headers: headers,
+			}
+			mu.Unlock()
+		case *network.EventLoadingFinished:
+			mu.Lock()
+			response, ok := responses[ev.RequestID]
+			delete(responses, ev.RequestID)
+			delete(methods, ev.RequestID)
+			mu.Unlock()
+			if !ok {
+				return
+			}
+
+			// Events are handled sequentially, loading the body must not block them
+			go func() {
+				c := chromedp.FromContext(ctx)
+				ctx := cdp.WithExecutor(ctx, c.Target)
+				body, err := network.GetResponseBody(ev.RequestID).Do(ctx)
+				if err != nil {
+					b.logger.Debug("Failed to load response body for fixture", "url", response.url, "error", err.Error())
+					return
+				}
+				b.FixtureRecorder.AddExchange(response.method, response.url, response.status, response.headers, body)
+			}()
+		}
+	})
+
+	return nil
+}
+
+// recordSnapshot records the DOM of the current page after a step.
+func (b *BrowserDriver) recordSnapshot(ctx context.Context, stepNumber int, step parser.Step) {
+	// Don't let a broken page block the recipe
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var currentURL, dom string
+	err := chromedp.Run(ctx,
+		chromedp.Location(&currentURL),
+		chromedp.OuterHTML("html", &dom, chromedp.ByQuery),
+	)
+	if err != nil {
+		b.logger.Debug("Failed to record DOM snapshot", "step", stepNumber, "action", step.Action, "error", err.Error())
+		return
+	}
+	b.FixtureRecorder.AddSnapshot(stepNumber, step.Action, currentURL, dom)
+}
+
+// replayRequest answers a paused request with the recorded response of the fixture.
+// Requests without a recorded response fail as if the browser was offline.
+func (b *BrowserDriver) replayRequest(ctx context.Context, ev *fetch.EventRequestPaused) error {
+	exchange, ok := b.FixtureReplay.Lookup(ev.Request.Method, ev.Request.URL)
+	if !ok {
+		b.logger.Debug("No recorded response in fixture", "method", ev.Request.Method, "url", ev.Request.URL)
+		return fetch.FailRequest(ev.RequestID, network.ErrorReasonInternetDisconnected).Do(ctx)
+	}
+
+	headers := make([]*fetch.HeaderEntry, 0, len(exchange.Headers))
+	for name, value := range exchange.Headers {
+		headers = append(headers, &fetch.HeaderEntry{Name: name, Value: value})
+	}
+	status := exchange.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return fetch.FulfillRequest(ev.RequestID, int64(status)).
+		WithResponseHeaders(headers).
+		WithBody(base64.StdEncoding.EncodeToString(exchange.Body)).
+		Do(ctx)
+}
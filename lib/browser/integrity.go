@@ -0,0 +1,93 @@
+package browser
+
+import (
+	"crypto/md5"  // #nosec G501 -- only compared to checksums announced by suppliers
+	"crypto/sha1" // #nosec G505 -- only compared to checksums announced by suppliers
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"buchhalter/lib/archive"
+)
+
+// maxDocumentDownloadAttempts is how often a document not matching the checksum or size announced by the supplier is downloaded
+const maxDocumentDownloadAttempts = 3
+
+var errDocumentIntegrity = errors.New("document doesn't match the checksum or size announced by the supplier")
+
+// documentIntegrity is the checksum and size of a document announced by the supplier API.
+// Empty values aren't verified.
+type documentIntegrity struct {
+	algorithm string
+	checksum  string
+	size      string
+}
+
+// documentIntegrityOf returns the announced checksum and size of the n-th document of a listing.
+func documentIntegrityOf(algorithm string, checksums, sizes []string, n int) documentIntegrity {
+	integrity := documentIntegrity{algorithm: algorithm}
+	if n < len(checksums) {
+		integrity.checksum = checksums[n]
+	}
+	if n < len(sizes) {
+		integrity.size = sizes[n]
+	}
+	return integrity
+}
+
+// newHash returns the hash of the announced checksum, nil if no checksum was announced.
+func (i documentIntegrity) newHash() (hash.Hash, error) {
+	if i.checksum == "" {
+		return nil, nil
+	}
+	switch strings.ToLower(i.algorithm) {
+	case "", "sha256":
+		return sha256.New(), nil
+	case "sha1":
+		// #nosec G401
+		return sha1.New(), nil
+	case "md5":
+		// #nosec G401
+		return md5.New(), nil
+	}
+	return nil, fmt.Errorf("unknown document checksum algorithm %s", i.algorithm)
+}
+
+// verify compares the hash and the size of a download to the announced values and returns the verification
+// of the document (see archive.VERIFICATION_* constants).
+func (i documentIntegrity) verify(h hash.Hash, size int64) (string, error) {
+	verification := ""
+	if i.size != "" {
+		expectedSize, err := strconv.ParseInt(i.size, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid document size %s announced by the supplier", i.size)
+		}
+		if expectedSize != size {
+			return "", fmt.Errorf("%w: %d bytes instead of %d bytes", errDocumentIntegrity, size, expectedSize)
+		}
+		verification = archive.VERIFICATION_SIZE
+	}
+	if h != nil {
+		// Some suppliers prefix checksums with the algorithm, e.g. "sha256:..."
+		checksum := i.checksum
+		if prefix, value, ok := strings.Cut(checksum, ":"); ok && strings.EqualFold(prefix, i.algorithmName()) {
+			checksum = value
+		}
+		actualChecksum := fmt.Sprintf("%x", h.Sum(nil))
+		if !strings.EqualFold(checksum, actualChecksum) {
+			return "", fmt.Errorf("%w: %s checksum %s instead of %s", errDocumentIntegrity, i.algorithmName(), actualChecksum, checksum)
+		}
+		verification = archive.VERIFICATION_CHECKSUM
+	}
+	return verification, nil
+}
+
+func (i documentIntegrity) algorithmName() string {
+	if i.algorithm == "" {
+		return "sha256"
+	}
+	return strings.ToLower(i.algorithm)
+}
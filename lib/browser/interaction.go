@@ -0,0 +1,166 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// actionabilityPollInterval is the time to wait before an element that is not actionable yet is checked again
+const actionabilityPollInterval = 100 * time.Millisecond
+
+// Checks of the actionability script, see https://playwright.dev/docs/actionability
+const (
+	// actionClick requires the element to be visible, stable, enabled and to receive the click (not being covered)
+	actionClick = "click"
+	// actionType requires the element to be visible, stable, enabled and editable
+	actionType = "type"
+)
+
+// State of an actionable element, other states name the failed check (e.g. hidden or covered)
+const actionable = "ready"
+
+// actionabilityScript scrolls the element into view and returns whether it can receive the action (first argument).
+// An element is stable if its position is unchanged for an animation frame, i.e. it is not animating.
+const actionabilityScript = `async function(action) {
+	if (!this.isConnected) {
+		return "detached";
+	}
+	const style = getComputedStyle(this);
+	if (style.visibility !== "visible" || this.getClientRects().length === 0) {
+		return "hidden";
+	}
+	if (this.disabled || this.closest("fieldset[disabled]") || this.getAttribute("aria-disabled") === "true") {
+		return "disabled";
+	}
+	if (action === "type" && (this.readOnly || (!("value" in this) && !this.isContentEditable))) {
+		return "not editable";
+	}
+
+	this.scrollIntoView({block: "center", inline: "center", behavior: "instant"});
+	const before = this.getBoundingClientRect();
+	// Background tabs don't render frames, they don't animate either
+	await new Promise((resolve) => {
+		requestAnimationFrame(resolve);
+		setTimeout(resolve, 100);
+	});
+	const rect = this.getBoundingClientRect();
+	if (rect.x !== before.x || rect.y !== before.y || rect.width !== before.width || rect.height !== before.height) {
+		return "animating";
+	}
+	if (rect.width === 0 || rect.height === 0) {
+		return "hidden";
+	}
+
+	if (action === "click") {
+		const root = this.getRootNode().elementFromPoint ? this.getRootNode() : document;
+		const hit = root.elementFromPoint(rect.x + rect.width / 2, rect.y + rect.height / 2);
+		if (hit && !this.contains(hit) && !(hit.control === this)) {
+			return "covered";
+		}
+	}
+	return "ready";
+}`
+
+// clickWhenReady clicks the first element matching sel once it is actionable (see waitActionable).
+func clickWhenReady(sel interface{}, opts ...chromedp.QueryOption) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		node, err := queryActionable(ctx, actionClick, sel, opts...)
+		if err != nil {
+			return err
+		}
+		return chromedp.MouseClickNode(node).Do(ctx)
+	}
+}
+
+// clickNodeWhenReady clicks node once it is actionable (see waitActionable).
+func clickNodeWhenReady(node *cdp.Node) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		state, err := waitActionable(ctx, actionClick, node)
+		if err != nil {
+			return fmt.Errorf("%s: %w", node.FullXPath(), err)
+		}
+		if state != actionable {
+			return fmt.Errorf("%s: element was removed from the page", node.FullXPath())
+		}
+		return chromedp.MouseClickNode(node).Do(ctx)
+	}
+}
+
+// typeWhenReady types value into the first element matching sel once it is actionable (see waitActionable).
+func typeWhenReady(sel interface{}, value string, opts ...chromedp.QueryOption) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		node, err := queryActionable(ctx, actionType, sel, opts...)
+		if err != nil {
+			return err
+		}
+		return chromedp.SendKeys([]cdp.NodeID{node.NodeID}, value, chromedp.ByNodeID).Do(ctx)
+	}
+}
+
+// queryActionable waits for the first element matching sel to be actionable. Elements replaced by the page (e.g.
+// re-rendered by a framework) are queried again.
+func queryActionable(ctx context.Context, action string, sel interface{}, opts ...chromedp.QueryOption) (*cdp.Node, error) {
+	for {
+		var nodes []*cdp.Node
+		err := chromedp.Nodes(sel, &nodes, opts...).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		state, err := waitActionable(ctx, action, nodes[0])
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", sel, err)
+		}
+		if state == actionable {
+			return nodes[0], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%v: element was removed from the page: %w", sel, ctx.Err())
+		case <-time.After(actionabilityPollInterval):
+		}
+	}
+}
+
+// waitActionable waits until node is visible, stable (not animating), enabled and, for clicks, not covered by another
+// element, or editable for typing. It scrolls node into view. It returns early with the state "detached" if node was
+// removed from the page.
+func waitActionable(ctx context.Context, action string, node *cdp.Node) (string, error) {
+	state := ""
+	for {
+		var err error
+		state, err = actionabilityState(ctx, action, node)
+		if err != nil || state == actionable || state == "detached" {
+			return state, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return state, fmt.Errorf("element is not actionable (%s): %w", state, ctx.Err())
+		case <-time.After(actionabilityPollInterval):
+		}
+	}
+}
+
+func actionabilityState(ctx context.Context, action string, node *cdp.Node) (string, error) {
+	object, err := dom.ResolveNode().WithBackendNodeID(node.BackendNodeID).Do(ctx)
+	if err != nil {
+		// Nodes removed from the page can't be resolved anymore
+		return "detached", nil
+	}
+	defer func() {
+		_ = runtime.ReleaseObject(object.ObjectID).Do(ctx)
+	}()
+
+	var state string
+	err = chromedp.CallFunctionOn(actionabilityScript, &state, func(p *runtime.CallFunctionOnParams) *runtime.CallFunctionOnParams {
+		return p.WithObjectID(object.ObjectID).WithAwaitPromise(true)
+	}, action).Do(ctx)
+	return state, err
+}
@@ -0,0 +1,111 @@
+package browser
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/utils"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+)
+
+// stepListDocuments lists the documents a download step would download, without downloading them.
+// Documents clicked by `downloadAll` are listed with their link, if they have one.
+func (b *BrowserDriver) stepListDocuments(ctx context.Context, step parser.Step) utils.StepResult {
+	b.logger.Debug("Executing recipe step in list-only mode", "action", step.Action, "selector", step.Selector)
+
+	var urls []string
+	switch step.Action {
+	case "downloadAll":
+		opts := []chromedp.QueryOption{}
+		opts = b.getSelectorTypeQueryOptions(step.SelectorType, opts)
+		var nodes []*cdp.Node
+		err := chromedp.Run(ctx, chromedp.Tasks{
+			chromedp.WaitReady(step.Selector, opts...),
+			chromedp.Nodes(step.Selector, &nodes),
+		})
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+		var location string
+		if err := chromedp.Run(ctx, chromedp.Location(&location)); err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+		for _, n := range nodes {
+			urls = append(urls, resolveLink(location, n.AttributeValue("href")))
+		}
+	case "runScriptDownloadUrls":
+		// The script returns the urls like the `value` of download steps without selector
+		step.Selector = ""
+		fallthrough
+	case "downloadWithSession", "downloadViaFetch":
+		var err error
+		urls, err = b.collectDownloadUrls(ctx, step)
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+	case "printToPdf":
+		var location string
+		if err := chromedp.Run(ctx, chromedp.Location(&location)); err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error()}
+		}
+		urls = []string{location}
+	}
+
+	documents := discoverDocuments(b.logger, b.documentArchive, b.supplier, nil, urls, nil)
+	b.logger.Info("Documents listed", "action", step.Action, "num_documents", len(documents))
+	return utils.StepResult{Status: "success", Artifacts: utils.StepArtifacts{Documents: documents}}
+}
+
+// discoverDocuments returns the documents with the given ids or urls and dates (optional) offered by supplier.
+// Documents are marked as archived if the archive has a source with their id or url.
+func discoverDocuments(logger *slog.Logger, documentArchive *archive.DocumentArchive, supplier string, ids, urls, dates []string) []utils.DiscoveredDocument {
+	archivedIds := map[string]bool{}
+	archivedUrls := map[string]bool{}
+	sources, err := documentArchive.SourcesOf(supplier)
+	if err != nil {
+		// All documents are listed as not archived
+		logger.Error("Error reading sources of documents", "supplier", supplier, "error", err)
+	}
+	for _, source := range sources {
+		if source.ID != "" {
+			archivedIds[source.ID] = true
+		}
+		archivedUrls[source.URL] = true
+	}
+
+	documents := make([]utils.DiscoveredDocument, max(len(ids), len(urls)))
+	for i := range documents {
+		if i < len(ids) {
+			documents[i].ID = ids[i]
+		}
+		if i < len(urls) {
+			documents[i].URL = urls[i]
+		}
+		if i < len(dates) {
+			documents[i].Date = dates[i]
+		}
+		documents[i].Archived = (documents[i].ID != "" && archivedIds[documents[i].ID]) || (documents[i].URL != "" && archivedUrls[documents[i].URL])
+	}
+	return documents
+}
+
+// resolveLink resolves a relative link against the url of the current page. Links that can't be resolved are kept.
+func resolveLink(location, href string) string {
+	if href == "" {
+		return ""
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return href
+	}
+	u, err := base.Parse(href)
+	if err != nil {
+		return href
+	}
+	return u.String()
+}
@@ -0,0 +1,60 @@
+package browser
+
+import (
+	"context"
+	"strings"
+
+	"buchhalter/lib/parser"
+
+	"github.com/chromedp/chromedp"
+)
+
+// acceptLanguage returns the Accept-Language header value of a locale (e.g. "de-DE" -> "de-DE,de;q=0.9").
+func acceptLanguage(locale string) string {
+	language := localeLanguage(locale)
+	if language == "" || strings.EqualFold(language, locale) {
+		return locale
+	}
+	return locale + "," + language + ";q=0.9"
+}
+
+// localeLanguage returns the language part of a locale (e.g. "de-DE" -> "de").
+func localeLanguage(locale string) string {
+	language, _, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	return strings.ToLower(language)
+}
+
+// localeChromeFlags sets the UI language and the Accept-Language header of Chrome.
+func localeChromeFlags(locale string) []chromedp.ExecAllocatorOption {
+	if locale == "" {
+		return nil
+	}
+	return []chromedp.ExecAllocatorOption{
+		chromedp.Flag("lang", locale),
+		chromedp.Flag("accept-lang", acceptLanguage(locale)),
+	}
+}
+
+// localizeStep picks the selector alternative matching the language of the current page.
+// Without a page language, the locale of the recipe is used. If there is no alternative, the default selector is kept.
+func (b *BrowserDriver) localizeStep(ctx context.Context, step parser.Step, recipeLocale string) parser.Step {
+	if len(step.Selectors) == 0 {
+		return step
+	}
+
+	var pageLanguage string
+	err := chromedp.Run(ctx, chromedp.Evaluate(`document.documentElement.lang || ""`, &pageLanguage))
+	if err != nil {
+		b.logger.Debug("Failed to detect page language", "action", step.Action, "error", err.Error())
+	}
+
+	for _, language := range []string{localeLanguage(pageLanguage), localeLanguage(recipeLocale)} {
+		if selector, ok := step.Selectors[language]; ok && language != "" {
+			b.logger.Debug("Using localized selector", "action", step.Action, "language", language, "selector", selector)
+			step.Selector = selector
+			return step
+		}
+	}
+
+	return step
+}
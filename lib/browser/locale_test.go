@@ -0,0 +1,17 @@
+package browser
+
+import "testing"
+
+func TestAcceptLanguage(t *testing.T) {
+	tests := map[string]string{
+		"de-DE": "de-DE,de;q=0.9",
+		"en_US": "en_US,en;q=0.9",
+		"de":    "de",
+		"":      "",
+	}
+	for locale, expected := range tests {
+		if actual := acceptLanguage(locale); actual != expected {
+			t.Errorf("expected acceptLanguage(%q) = %q, got %q", locale, expected, actual)
+		}
+	}
+}
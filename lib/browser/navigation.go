@@ -0,0 +1,149 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"buchhalter/lib/parser"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// Navigation wait strategies of recipe steps (`waitStrategy`)
+const (
+	WAIT_STRATEGY_LOAD               = "load"
+	WAIT_STRATEGY_DOM_CONTENT_LOADED = "domcontentloaded"
+	WAIT_STRATEGY_NETWORK_IDLE       = "networkidle"
+	WAIT_STRATEGY_SELECTOR           = "selector"
+
+	// defaultWaitStrategy is used if a step doesn't define a wait strategy
+	defaultWaitStrategy = WAIT_STRATEGY_NETWORK_IDLE
+	// defaultNavigationTimeout is used if a step doesn't define a wait timeout
+	defaultNavigationTimeout = 30 * time.Second
+)
+
+// lifecycleEventNames maps the wait strategies to the names of the Chrome DevTools lifecycle events
+var lifecycleEventNames = map[string]string{
+	WAIT_STRATEGY_LOAD:               "load",
+	WAIT_STRATEGY_DOM_CONTENT_LOADED: "DOMContentLoaded",
+	WAIT_STRATEGY_NETWORK_IDLE:       "networkIdle",
+}
+
+// errNavigationTimeout is returned if a navigation didn't reach the state of the wait strategy in time.
+var errNavigationTimeout = errors.New("navigation timeout")
+
+// runAndWaitForNavigation runs action (e.g. chromedp.Navigate) and waits until the page reached the state
+// defined by the wait strategy of step.
+//
+// The lifecycle listener is registered before action runs, so fast pages can't finish loading before we listen.
+// Events of the previous document are ignored by only accepting events of the document that started last.
+func (b *BrowserDriver) runAndWaitForNavigation(ctx context.Context, step parser.Step, action chromedp.Action) error {
+	strategy := step.WaitStrategy
+	if strategy == "" {
+		strategy = defaultWaitStrategy
+	}
+	timeout := defaultNavigationTimeout
+	if step.WaitTimeout > 0 {
+		timeout = time.Duration(step.WaitTimeout) * time.Second
+	}
+
+	if strategy == WAIT_STRATEGY_SELECTOR {
+		if step.WaitSelector == "" {
+			return fmt.Errorf("wait strategy %q without waitSelector", strategy)
+		}
+		if err := chromedp.Run(ctx, action); err != nil {
+			return err
+		}
+
+		tctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err := chromedp.Run(tctx, chromedp.WaitVisible(step.WaitSelector, b.getSelectorTypeQueryOptions(step.SelectorType, []chromedp.QueryOption{})...))
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return fmt.Errorf("%w: selector %s not visible after %s", errNavigationTimeout, step.WaitSelector, timeout)
+		}
+		return err
+	}
+
+	eventName, ok := lifecycleEventNames[strategy]
+	if !ok {
+		return fmt.Errorf("unknown wait strategy %q", strategy)
+	}
+
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The main frame of a page target has the ID of the target
+	var mainFrameID cdp.FrameID
+	if c := chromedp.FromContext(ctx); c != nil && c.Target != nil {
+		mainFrameID = cdp.FrameID(c.Target.TargetID)
+	}
+
+	// The listener is called sequentially, loaderID is only accessed from there
+	var loaderID cdp.LoaderID
+	reached := make(chan struct{}, 1)
+	chromedp.ListenTarget(lctx, func(ev interface{}) {
+		e, ok := ev.(*page.EventLifecycleEvent)
+		if !ok || (mainFrameID != "" && e.FrameID != mainFrameID) {
+			return
+		}
+		if e.Name == "init" {
+			loaderID = e.LoaderID
+			return
+		}
+		if e.Name == eventName && (loaderID == "" || e.LoaderID == loaderID) {
+			select {
+			case reached <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	if err := chromedp.Run(ctx, action); err != nil {
+		return err
+	}
+
+	select {
+	case <-reached:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("%w: %s not reached after %s", errNavigationTimeout, strategy, timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// recordDocumentStatusCodes records the HTTP status codes of the documents loaded into the main frame, e.g. by a
+// navigation. The returned function stops recording and returns the status codes in the order of the responses.
+func recordDocumentStatusCodes(ctx context.Context) func() []int {
+	lctx, cancel := context.WithCancel(ctx)
+
+	var mainFrameID cdp.FrameID
+	if c := chromedp.FromContext(ctx); c != nil && c.Target != nil {
+		mainFrameID = cdp.FrameID(c.Target.TargetID)
+	}
+
+	mu := &sync.Mutex{}
+	var statusCodes []int
+	chromedp.ListenTarget(lctx, func(ev interface{}) {
+		e, ok := ev.(*network.EventResponseReceived)
+		if !ok || e.Type != network.ResourceTypeDocument || (mainFrameID != "" && e.FrameID != mainFrameID) {
+			return
+		}
+		mu.Lock()
+		statusCodes = append(statusCodes, int(e.Response.Status))
+		mu.Unlock()
+	})
+
+	return func() []int {
+		cancel()
+		mu.Lock()
+		defer mu.Unlock()
+		return statusCodes
+	}
+}
@@ -14,11 +14,16 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"buchhalter/lib/archive"
+	"buchhalter/lib/audit"
+	"buchhalter/lib/driver"
+	"buchhalter/lib/httpclient"
 	"buchhalter/lib/parser"
+	"buchhalter/lib/redact"
 	"buchhalter/lib/secrets"
 	"buchhalter/lib/utils"
 	"buchhalter/lib/vault"
@@ -30,12 +35,26 @@ import (
 	"github.com/chromedp/chromedp"
 )
 
+const (
+	TOKEN_ENCODING_JSON = "json"
+	TOKEN_ENCODING_FORM = "form"
+
+	CLIENT_AUTH_NONE         = "none"
+	CLIENT_AUTH_SECRET_POST  = "client_secret_post"
+	CLIENT_AUTH_SECRET_BASIC = "client_secret_basic"
+)
+
+// oauth2TokenExpiryLeeway are seconds access tokens are treated as expired before they actually expire
+const oauth2TokenExpiryLeeway = 60
+
 type HiddenInputFields struct {
 	Fields map[string]string
 }
 
 type ClientAuthBrowserDriver struct {
 	logger          *slog.Logger
+	httpClient      *httpclient.Client
+	responseCache   *httpclient.ResponseCache
 	credentials     *vault.Credentials
 	documentArchive *archive.DocumentArchive
 
@@ -43,6 +62,25 @@ type ClientAuthBrowserDriver struct {
 	buchhalterDocumentsDirectory string
 
 	ChromeVersion string
+	// ChromePath is the Chrome executable to launch (empty to let chromedp search for it)
+	ChromePath string
+	// ShredTemporaryFiles overwrites downloaded files before they are removed from the downloads directory
+	ShredTemporaryFiles bool
+	// Oauth2Variables are the configured placeholder values of the additional OAuth2 parameters of the recipe (e.g. tenant)
+	Oauth2Variables map[string]string
+	// TokenProfile isolates the cached OAuth2 tokens of multiple setups sharing a config directory
+	TokenProfile string
+	// RefetchSources are downloaded again instead of listing the documents of the supplier (see the refetch command)
+	RefetchSources []archive.Source
+	// AuditLog records the credentials and cached tokens used by the steps (optional)
+	AuditLog *audit.Log
+	// ListOnly lists the documents of the item listings instead of downloading them, nothing is archived
+	ListOnly bool
+
+	// supplier of the recipe that is currently executed
+	supplier string
+	// locale of the recipe that is currently executed
+	locale string
 
 	downloadsDirectory string
 	documentsDirectory string
@@ -51,6 +89,10 @@ type ClientAuthBrowserDriver struct {
 	browserCtx    context.Context
 	newFilesCount int
 
+	// chromeCtx is the context of the launched Chrome browser (nil as long as no step needed it)
+	chromeCtx    context.Context
+	chromeCancel context.CancelFunc
+
 	oauth2AuthToken          string
 	oauth2AuthUrl            string
 	oauth2TokenUrl           string
@@ -59,11 +101,18 @@ type ClientAuthBrowserDriver struct {
 	oauth2Scope              string
 	oauth2PkceMethod         string
 	oauth2PkceVerifierLength int
+	oauth2AuthorizeParams    map[string]string
+	oauth2TokenParams        map[string]string
+	oauth2TokenEncoding      string
+	oauth2ClientAuthMethod   string
+	oauth2ClientSecret       string
 }
 
-func NewClientAuthBrowserDriver(logger *slog.Logger, credentials *vault.Credentials, buchhalterConfigDirectory, buchhalterDocumentsDirectory string, documentArchive *archive.DocumentArchive) *ClientAuthBrowserDriver {
+func NewClientAuthBrowserDriver(ctx context.Context, logger *slog.Logger, httpClient *httpclient.Client, responseCache *httpclient.ResponseCache, credentials *vault.Credentials, buchhalterConfigDirectory, buchhalterDocumentsDirectory string, documentArchive *archive.DocumentArchive) *ClientAuthBrowserDriver {
 	return &ClientAuthBrowserDriver{
 		logger:          logger,
+		httpClient:      httpClient,
+		responseCache:   responseCache,
 		credentials:     credentials,
 		documentArchive: documentArchive,
 
@@ -71,13 +120,65 @@ func NewClientAuthBrowserDriver(logger *slog.Logger, credentials *vault.Credenti
 		buchhalterDocumentsDirectory: buchhalterDocumentsDirectory,
 
 		recipeTimeout: 120 * time.Second,
-		browserCtx:    context.Background(),
+		browserCtx:    ctx,
 		newFilesCount: 0,
 	}
 }
 
 func (b *ClientAuthBrowserDriver) RunRecipe(p *tea.Program, totalStepCount int, stepCountInCurrentRecipe int, baseCountStep int, recipe *parser.Recipe) utils.RecipeResult {
-	b.logger.Info("Starting client auth chrome browser driver ...", "recipe", recipe.Supplier, "recipe_version", recipe.Version)
+	b.logger.Info("Starting client auth driver ...", "supplier", recipe.Supplier, "recipe_version", recipe.Version)
+	b.supplier = recipe.Supplier
+	b.locale = recipe.Locale
+
+	// Most steps are plain HTTP requests. Chrome is only launched once a step needs it (see startBrowser).
+	defer b.stopBrowser()
+
+	// create download directories
+	var err error
+	b.downloadsDirectory, b.documentsDirectory, err = utils.InitSupplierDirectories(b.buchhalterDocumentsDirectory, b.documentArchive.SupplierDirectory(recipe.Supplier), recipe.Supplier)
+	if err != nil {
+		// TODO Implement error handling
+		fmt.Println(err)
+	}
+	b.logger.Info("Download directories created", "downloads_directory", b.downloadsDirectory, "documents_directory", b.documentsDirectory)
+	// Runs on errors and panics as well
+	defer b.removeDownloadsDirectory()
+
+	// Documents are archived right after their download, so they are kept on timeouts
+	engine := driver.NewEngine(b.logger, b, b.recipeTimeout)
+	return engine.Run(b.browserCtx, p, totalStepCount, stepCountInCurrentRecipe, baseCountStep, recipe)
+}
+
+// RunStep executes a single step of recipe, Chrome is only launched for the authentication.
+func (b *ClientAuthBrowserDriver) RunStep(ctx context.Context, recipe *parser.Recipe, step parser.Step) utils.StepResult {
+	switch step.Action {
+	case "oauth2-setup":
+		return b.stepOauth2Setup(step)
+	case "oauth2-check-tokens":
+		return b.stepOauth2CheckTokens(ctx, recipe, step, b.credentials)
+	case "oauth2-authenticate":
+		return b.stepOauth2Authenticate(ctx, recipe, step, b.credentials)
+	case "oauth2-post-and-get-items":
+		if b.RefetchSources != nil {
+			return b.stepOauth2Refetch(ctx, step, b.documentArchive)
+		}
+		return b.stepOauth2PostAndGetItems(ctx, step, b.documentArchive)
+	}
+	return utils.StepResult{Status: "error", Message: "unknown action " + step.Action + " of client recipe", Break: true}
+}
+
+func (b *ClientAuthBrowserDriver) NewFilesCount() int {
+	return b.newFilesCount
+}
+
+// startBrowser launches Chrome on first use and returns its context.
+// Chrome is bound to the context of the run, not of a step, as it is used by the following steps as well.
+func (b *ClientAuthBrowserDriver) startBrowser() (context.Context, error) {
+	if b.chromeCtx != nil {
+		return b.chromeCtx, nil
+	}
+
+	b.logger.Info("Starting client auth chrome browser driver ...", "supplier", b.supplier)
 
 	// Setting chrome flags
 	// Docs: https://github.com/GoogleChrome/chrome-launcher/blob/main/docs/chrome-flags-for-tools.md
@@ -86,116 +187,45 @@ func (b *ClientAuthBrowserDriver) RunRecipe(p *tea.Program, totalStepCount int,
 		chromedp.Flag("enable-automation", false),
 		chromedp.Flag("headless", false),
 	)
+	if b.ChromePath != "" {
+		opts = append(opts, chromedp.ExecPath(b.ChromePath))
+	}
+	opts = append(opts, localeChromeFlags(b.locale)...)
 
-	ctx, cancel, err := cu.New(cu.NewConfig(
+	chromeCtx, cancel, err := cu.New(cu.NewConfig(
 		cu.WithContext(b.browserCtx),
 		cu.WithChromeFlags(opts...),
-		// create a timeout as a safety net to prevent any infinite wait loops
-		cu.WithTimeout(600*time.Second),
 	))
 	if err != nil {
-		// TODO Implement error handling
-		panic(err)
+		return nil, err
 	}
-	defer cancel()
 
 	// get chrome version for metrics
 	if b.ChromeVersion == "" {
-		err := chromedp.Run(ctx, chromedp.Tasks{
+		err := chromedp.Run(chromeCtx, chromedp.Tasks{
 			chromedp.Navigate("chrome://version"),
 			chromedp.Text(`#version`, &b.ChromeVersion, chromedp.NodeVisible),
 		})
 		if err != nil {
-			// TODO Implement error handling
-			panic(err)
+			cancel()
+			return nil, err
 		}
 		b.ChromeVersion = strings.TrimSpace(b.ChromeVersion)
 	}
-	b.logger.Info("Starting client auth chrome browser driver ... completed ", "recipe", recipe.Supplier, "recipe_version", recipe.Version, "chrome_version", b.ChromeVersion)
-
-	// create download directories
-	b.downloadsDirectory, b.documentsDirectory, err = utils.InitSupplierDirectories(b.buchhalterDocumentsDirectory, recipe.Supplier)
-	if err != nil {
-		// TODO Implement error handling
-		fmt.Println(err)
-	}
-	b.logger.Info("Download directories created", "downloads_directory", b.downloadsDirectory, "documents_directory", b.documentsDirectory)
-
-	var cs float64
-	n := 1
-	var result utils.RecipeResult
-	for _, step := range recipe.Steps {
-		p.Send(utils.ViewMsgStatusAndDescriptionUpdate{
-			Title:       fmt.Sprintf("Downloading invoices from %s (%d/%d):", recipe.Supplier, n, stepCountInCurrentRecipe),
-			Description: step.Description,
-		})
-
-		stepResultChan := make(chan utils.StepResult, 1)
-		// Timeout recipe if something goes wrong
-		go func() {
-			switch step.Action {
-			case "oauth2-setup":
-				stepResultChan <- b.stepOauth2Setup(step)
-			case "oauth2-check-tokens":
-				stepResultChan <- b.stepOauth2CheckTokens(ctx, recipe, step, b.credentials, b.buchhalterConfigDirectory)
-			case "oauth2-authenticate":
-				stepResultChan <- b.stepOauth2Authenticate(ctx, recipe, step, b.credentials, b.buchhalterConfigDirectory)
-			case "oauth2-post-and-get-items":
-				stepResultChan <- b.stepOauth2PostAndGetItems(ctx, step, b.documentArchive)
-			}
-		}()
-
-		select {
-		case lastStepResult := <-stepResultChan:
-			newDocumentsText := fmt.Sprintf("%d new documents", b.newFilesCount)
-			if b.newFilesCount == 1 {
-				newDocumentsText = "One new document"
-			}
-			if b.newFilesCount == 0 {
-				newDocumentsText = "No new documents"
-			}
-			if lastStepResult.Status == "success" {
-				result = utils.RecipeResult{
-					Status:              "success",
-					StatusText:          recipe.Supplier + ": " + newDocumentsText,
-					StatusTextFormatted: "- " + textStyleBold(recipe.Supplier) + ": " + newDocumentsText,
-					LastStepId:          fmt.Sprintf("%s-%s-%d-%s", recipe.Supplier, recipe.Version, n, step.Action),
-					LastStepDescription: step.Description,
-					NewFilesCount:       b.newFilesCount,
-				}
-			} else {
-				result = utils.RecipeResult{
-					Status:              "error",
-					StatusText:          recipe.Supplier + " aborted with error.",
-					StatusTextFormatted: "x " + textStyleBold(recipe.Supplier) + " aborted with error.",
-					LastStepId:          fmt.Sprintf("%s-%s-%d-%s", recipe.Supplier, recipe.Version, n, step.Action),
-					LastStepDescription: step.Description,
-					LastErrorMessage:    lastStepResult.Message,
-					NewFilesCount:       b.newFilesCount,
-				}
-				if lastStepResult.Break {
-					return result
-				}
-			}
 
-		case <-time.After(b.recipeTimeout):
-			result = utils.RecipeResult{
-				Status:              "error",
-				StatusText:          recipe.Supplier + " aborted with timeout.",
-				StatusTextFormatted: "x " + textStyleBold(recipe.Supplier) + " aborted with timeout.",
-				LastStepId:          fmt.Sprintf("%s-%s-%d-%s", recipe.Supplier, recipe.Version, n, step.Action),
-				LastStepDescription: step.Description,
-				NewFilesCount:       b.newFilesCount,
-			}
-			return result
-		}
+	b.chromeCtx = chromeCtx
+	b.chromeCancel = cancel
+	b.logger.Info("Starting client auth chrome browser driver ... completed ", "supplier", b.supplier, "chrome_version", b.ChromeVersion)
+	return chromeCtx, nil
+}
 
-		cs = (float64(baseCountStep) + float64(n)) / float64(totalStepCount)
-		p.Send(utils.ViewMsgProgressUpdate{Percent: cs})
-		n++
+// stopBrowser closes Chrome if it was launched.
+func (b *ClientAuthBrowserDriver) stopBrowser() {
+	if b.chromeCancel != nil {
+		b.chromeCancel()
+		b.chromeCtx = nil
+		b.chromeCancel = nil
 	}
-
-	return result
 }
 
 func (b *ClientAuthBrowserDriver) stepOauth2Setup(step parser.Step) utils.StepResult {
@@ -208,43 +238,67 @@ func (b *ClientAuthBrowserDriver) stepOauth2Setup(step parser.Step) utils.StepRe
 	b.oauth2Scope = step.Oauth2.Scope
 	b.oauth2PkceMethod = step.Oauth2.PkceMethod
 	b.oauth2PkceVerifierLength = step.Oauth2.PkceVerifierLength
+	b.oauth2AuthorizeParams = b.oauth2Parameters(step.Oauth2.AuthorizeParams, step)
+	b.oauth2TokenParams = b.oauth2Parameters(step.Oauth2.TokenParams, step)
+	b.oauth2TokenEncoding = step.Oauth2.TokenRequestEncoding
+	b.oauth2ClientAuthMethod = step.Oauth2.ClientAuthMethod
+	b.oauth2ClientSecret = b.oauth2Parameters(map[string]string{"client_secret": step.Oauth2.ClientSecret}, step)["client_secret"]
+	switch {
+	case b.oauth2TokenEncoding != "" && b.oauth2TokenEncoding != TOKEN_ENCODING_JSON && b.oauth2TokenEncoding != TOKEN_ENCODING_FORM:
+		return utils.StepResult{Status: "error", Message: "unknown OAuth2 token request encoding " + b.oauth2TokenEncoding, Break: true}
+	case b.oauth2ClientAuthMethod != "" && b.oauth2ClientAuthMethod != CLIENT_AUTH_NONE && b.oauth2ClientAuthMethod != CLIENT_AUTH_SECRET_POST && b.oauth2ClientAuthMethod != CLIENT_AUTH_SECRET_BASIC:
+		return utils.StepResult{Status: "error", Message: "unknown OAuth2 client authentication method " + b.oauth2ClientAuthMethod, Break: true}
+	}
+	if b.oauth2ClientSecret != "" {
+		redact.AddSecrets(b.oauth2ClientSecret)
+	}
 
 	return utils.StepResult{Status: "success", Message: "Successfully set up OAuth2 settings."}
 }
 
-func (b *ClientAuthBrowserDriver) stepOauth2CheckTokens(ctx context.Context, recipe *parser.Recipe, step parser.Step, credentials *vault.Credentials, buchhalterConfigDirectory string) utils.StepResult {
+func (b *ClientAuthBrowserDriver) stepOauth2CheckTokens(ctx context.Context, recipe *parser.Recipe, step parser.Step, credentials *vault.Credentials) utils.StepResult {
 	b.logger.Debug("Executing recipe step", "action", step.Action)
 	b.logger.Info("Checking OAuth2 tokens ...")
 
 	// Try to get secrets from cache
-	pii := recipe.Supplier + "|" + credentials.Id
-	tokens, err := secrets.GetOauthAccessTokenFromCache(pii, buchhalterConfigDirectory)
-	if err == nil {
-		if b.validOauth2AuthToken(tokens) {
-			b.logger.Info("Found valid oauth2 access token in cache")
-			b.oauth2AuthToken = tokens.AccessToken
-			return utils.StepResult{Status: "success", Message: "Found valid oauth2 access token in cache"}
-		} else {
-			b.logger.Info("No valid oauth2 access token found in cache. Trying to get one with refresh token")
-			payload := []byte(`{
-"grant_type": "refresh_token",
-"client_id": "` + b.oauth2ClientId + `",
-"refresh_token": "` + tokens.RefreshToken + `",
-"scope": "` + b.oauth2Scope + `"
-}`)
-			nt, err := b.getOauth2Tokens(ctx, payload, pii, buchhalterConfigDirectory)
-			if err == nil {
-				b.oauth2AuthToken = nt.AccessToken
-				b.logger.Error("Error getting oauth2 access token with refresh token")
-				return utils.StepResult{Status: "error", Message: "Error getting oauth2 access token with refresh token", Break: true}
-			}
-		}
+	tokens, err := secrets.GetOauthAccessTokenFromCache(recipe.Supplier, credentials.Id, b.tokenDirectory())
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: "No access token found. New OAuth2 login needed."}
+	}
+	redact.AddSecrets(tokens.AccessToken, tokens.RefreshToken)
+
+	if b.validOauth2AuthToken(tokens) {
+		b.recordCredentialAccess(audit.KIND_OAUTH2_TOKEN, "accessToken", step)
+		b.logger.Info("Found valid oauth2 access token in cache")
+		b.oauth2AuthToken = tokens.AccessToken
+		return utils.StepResult{Status: "success", Message: "Found valid oauth2 access token in cache"}
+	}
+	if tokens.RefreshToken == "" {
+		b.logger.Info("Cached oauth2 access token expired and there is no refresh token")
+		return utils.StepResult{Status: "error", Message: "OAuth2 access token expired. New OAuth2 login needed."}
 	}
 
-	return utils.StepResult{Status: "error", Message: "No access token found. New OAuth2 login needed."}
+	b.logger.Info("No valid oauth2 access token found in cache. Trying to get one with refresh token")
+	b.recordCredentialAccess(audit.KIND_OAUTH2_TOKEN, "refreshToken", step)
+	fields := map[string]string{
+		"grant_type":    "refresh_token",
+		"client_id":     b.oauth2ClientId,
+		"refresh_token": tokens.RefreshToken,
+		"scope":         b.oauth2Scope,
+	}
+	newTokens, err := b.getOauth2Tokens(ctx, fields, recipe.Supplier, credentials.Id)
+	if err != nil {
+		// The login of the next step gets new tokens
+		b.logger.Error("Error getting oauth2 access token with refresh token", "error", err.Error())
+		return utils.StepResult{Status: "error", Message: "Error getting oauth2 access token with refresh token. New OAuth2 login needed."}
+	}
+
+	b.logger.Info("Successfully refreshed OAuth2 access token")
+	b.oauth2AuthToken = newTokens.AccessToken
+	return utils.StepResult{Status: "success", Message: "Successfully refreshed OAuth2 access token"}
 }
 
-func (b *ClientAuthBrowserDriver) stepOauth2Authenticate(ctx context.Context, recipe *parser.Recipe, step parser.Step, credentials *vault.Credentials, buchhalterConfigDirectory string) utils.StepResult {
+func (b *ClientAuthBrowserDriver) stepOauth2Authenticate(ctx context.Context, recipe *parser.Recipe, step parser.Step, credentials *vault.Credentials) utils.StepResult {
 	b.logger.Debug("Executing recipe step", "action", step.Action)
 	b.logger.Info("Authenticating with OAuth2 ...")
 
@@ -252,6 +306,12 @@ func (b *ClientAuthBrowserDriver) stepOauth2Authenticate(ctx context.Context, re
 		return utils.StepResult{Status: "success"}
 	}
 
+	// The login form requires a browser
+	ctx, err := b.startBrowser()
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: "error starting chrome: " + err.Error(), Break: true}
+	}
+
 	verifier, challenge, err := utils.Oauth2Pkce(b.oauth2PkceVerifierLength)
 	if err != nil {
 		// TODO implement error handling
@@ -268,23 +328,21 @@ func (b *ClientAuthBrowserDriver) stepOauth2Authenticate(ctx context.Context, re
 	params.Add("state", state)
 	params.Add("code_challenge", challenge)
 	params.Add("code_challenge_method", b.oauth2PkceMethod)
+	// Additional parameters may overwrite the default ones (e.g. prompt)
+	for name, value := range b.oauth2AuthorizeParams {
+		params.Set(name, value)
+	}
 	loginUrl := b.oauth2AuthUrl + "?" + params.Encode()
 
+	b.recordCredentialAccess(audit.KIND_CREDENTIAL, "username", step)
+	b.recordCredentialAccess(audit.KIND_CREDENTIAL, "password", step)
 	b.listenForNetworkEvent(ctx)
 	err = chromedp.Run(ctx,
 		b.run(5*time.Second, chromedp.Navigate(loginUrl)),
-		chromedp.WaitReady(`#form-input-identity`, chromedp.ByID),
-		chromedp.Sleep(1*time.Second),
-		chromedp.Click(`#form-input-identity`, chromedp.ByID),
-		chromedp.SendKeys("#form-input-identity", credentials.Username, chromedp.ByID),
-		chromedp.Sleep(1*time.Second),
-		chromedp.Click("#form-submit-continue", chromedp.ByID),
-		chromedp.WaitVisible(`#form-input-credential`, chromedp.ByID),
-		chromedp.Sleep(3*time.Second),
-		chromedp.SendKeys("#form-input-credential", credentials.Password, chromedp.ByID),
-		chromedp.Sleep(2*time.Second),
-		chromedp.Click("#form-submit-continue", chromedp.ByID),
-		chromedp.Sleep(2*time.Second),
+		typeWhenReady(`#form-input-identity`, credentials.Username, chromedp.ByID),
+		clickWhenReady("#form-submit-continue", chromedp.ByID),
+		typeWhenReady(`#form-input-credential`, credentials.Password, chromedp.ByID),
+		clickWhenReady("#form-submit-continue", chromedp.ByID),
 	)
 
 	if err != nil {
@@ -306,9 +364,10 @@ func (b *ClientAuthBrowserDriver) stepOauth2Authenticate(ctx context.Context, re
 
 	/** Insert 2FA code */
 	if len(faNodes) > 0 {
+		b.recordCredentialAccess(audit.KIND_CREDENTIAL, "totp", step)
 		err = chromedp.Run(ctx,
-			chromedp.SendKeys("#form-input-passcode", credentials.Totp, chromedp.ByID),
-			chromedp.Click("#form-submit", chromedp.ByID),
+			typeWhenReady("#form-input-passcode", credentials.Totp, chromedp.ByID),
+			clickWhenReady("#form-submit", chromedp.ByID),
 		)
 	}
 
@@ -319,10 +378,7 @@ func (b *ClientAuthBrowserDriver) stepOauth2Authenticate(ctx context.Context, re
 
 	/** Request access token */
 	var u string
-	err = chromedp.Run(ctx,
-		chromedp.Sleep(2*time.Second),
-		chromedp.Location(&u),
-	)
+	err = chromedp.Run(ctx, waitForLocation(b.oauth2RedirectUrl, &u))
 
 	if err != nil {
 		b.logger.Error("Error while requesting access token", "error", err.Error())
@@ -333,16 +389,15 @@ func (b *ClientAuthBrowserDriver) stepOauth2Authenticate(ctx context.Context, re
 	values := parsedURL.Query()
 	code := values.Get("code")
 
-	payload := []byte(`{
-"grant_type": "authorization_code",
-"client_id": "` + b.oauth2ClientId + `",
-"code_verifier": "` + verifier + `",
-"code": "` + code + `",
-"redirect_uri": "` + b.oauth2RedirectUrl + `"
-}`)
+	fields := map[string]string{
+		"grant_type":    "authorization_code",
+		"client_id":     b.oauth2ClientId,
+		"code_verifier": verifier,
+		"code":          code,
+		"redirect_uri":  b.oauth2RedirectUrl,
+	}
 
-	pii := recipe.Supplier + "|" + credentials.Id
-	tokens, err := b.getOauth2Tokens(ctx, payload, pii, buchhalterConfigDirectory)
+	tokens, err := b.getOauth2Tokens(ctx, fields, recipe.Supplier, credentials.Id)
 	if err != nil {
 		b.logger.Error("Error while getting fresh OAuth2 access token", "error", err.Error())
 		return utils.StepResult{Status: "error", Message: err.Error()}
@@ -356,33 +411,13 @@ func (b *ClientAuthBrowserDriver) stepOauth2PostAndGetItems(ctx context.Context,
 	b.logger.Debug("Executing recipe step", "action", step.Action, "url", step.URL)
 
 	payload := []byte(step.Body)
-	req, err := http.NewRequestWithContext(ctx, "POST", step.URL, bytes.NewBuffer(payload))
+	statusCode, body, err := b.postAndGetItems(ctx, step, payload)
 	if err != nil {
-		return utils.StepResult{Status: "error", Message: "error creating post request", Break: true}
+		return utils.StepResult{Status: "error", Message: err.Error(), Break: true}
 	}
+	artifacts := utils.StepArtifacts{HTTPStatusCodes: []int{statusCode}}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	for n, h := range step.Headers {
-		if n == "Authorization" {
-			h = strings.Replace(h, "{{ token }}", b.oauth2AuthToken, -1)
-		}
-		req.Header.Set(n, h)
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return utils.StepResult{Status: "error", Message: "error sending post request: " + err.Error(), Break: true}
-	}
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return utils.StepResult{Status: "error", Message: ""}
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 200 {
+	if statusCode == 200 {
 		b.newFilesCount = 0
 		var jsr interface{}
 		err := json.Unmarshal(body, &jsr)
@@ -393,13 +428,34 @@ func (b *ClientAuthBrowserDriver) stepOauth2PostAndGetItems(ctx context.Context,
 
 		ids := extractJsonValue(jsr, step.ExtractDocumentIds)
 		if len(ids) == 0 {
-			return utils.StepResult{Status: "error", Message: "No content ids found", Break: true}
+			return utils.StepResult{Status: "error", Message: "No content ids found", Break: true, Artifacts: artifacts}
+		}
+		if b.ListOnly {
+			var dates []string
+			if step.ExtractDocumentDates != "" {
+				dates = extractJsonValue(jsr, step.ExtractDocumentDates)
+			}
+			urls := make([]string, 0, len(ids))
+			for _, id := range ids {
+				urls = append(urls, strings.Replace(step.DocumentUrl, "{{ id }}", id, -1))
+			}
+			artifacts.Documents = discoverDocuments(b.logger, documentArchive, b.supplier, ids, urls, dates)
+			b.logger.Info("Documents listed", "action", step.Action, "num_documents", len(artifacts.Documents))
+			return utils.StepResult{Status: "success", Artifacts: artifacts}
 		}
 
 		var filenames []string
 		if step.ExtractDocumentFilenames != "" {
 			filenames = extractJsonValue(jsr, step.ExtractDocumentFilenames)
 		}
+		// Downloads are verified against the checksums and sizes announced by the supplier (if any)
+		var checksums, sizes []string
+		if step.ExtractDocumentChecksums != "" {
+			checksums = extractJsonValue(jsr, step.ExtractDocumentChecksums)
+		}
+		if step.ExtractDocumentSizes != "" {
+			sizes = extractJsonValue(jsr, step.ExtractDocumentSizes)
+		}
 
 		// Get document
 		n := 0
@@ -416,45 +472,169 @@ func (b *ClientAuthBrowserDriver) stepOauth2PostAndGetItems(ctx context.Context,
 				filename = filepath.Join(id, ".pdf")
 
 			}
-			downloadSuccessful, err := b.doRequest(ctx, url, step.DocumentRequestMethod, step.DocumentRequestHeaders, f, nil)
+			integrity := documentIntegrityOf(step.DocumentChecksumAlgorithm, checksums, sizes, n)
+			var downloadSuccessful bool
+			var verification string
+			for attempt := 1; attempt <= maxDocumentDownloadAttempts; attempt++ {
+				downloadSuccessful, verification, err = b.doRequest(ctx, url, step.DocumentRequestMethod, step.DocumentRequestHeaders, f, nil, integrity)
+				if !errors.Is(err, errDocumentIntegrity) {
+					break
+				}
+				b.logger.Warn("Downloaded document doesn't match the supplier, retrying", "url", url, "attempt", attempt, "error", err)
+			}
+			if errors.Is(err, errDocumentIntegrity) {
+				return utils.StepResult{Status: "error", Message: err.Error(), Artifacts: artifacts}
+			}
 			if err != nil {
 				// TODO implement error handling
 				fmt.Println(err)
 			}
 			if !downloadSuccessful {
-				return utils.StepResult{Status: "error", Message: "Error while downloading invoices"}
+				return utils.StepResult{Status: "error", Message: "Error while downloading invoices", Artifacts: artifacts}
 			}
 			if !documentArchive.FileExists(f) {
 				b.newFilesCount++
 				dstFile := filepath.Join(b.documentsDirectory, filename)
 				_, err := utils.CopyFile(f, dstFile)
 				if err != nil {
-					return utils.StepResult{Status: "error", Message: "Error while copying file: " + err.Error()}
+					return utils.StepResult{Status: "error", Message: "Error while copying file: " + err.Error(), Artifacts: artifacts}
+				}
+				err = documentArchive.AddVerifiedFile(dstFile, b.supplier, verification)
+				if err != nil {
+					return utils.StepResult{Status: "error", Message: "Error while adding file " + dstFile + " to document archive: " + err.Error(), Artifacts: artifacts}
 				}
-				err = documentArchive.AddFile(dstFile)
+				// The source allows to download the document again, e.g. if its file gets corrupted
+				err = documentArchive.AddSource(archive.Source{Supplier: b.supplier, Path: dstFile, ID: id, URL: url, DownloadedAt: time.Now()})
 				if err != nil {
-					return utils.StepResult{Status: "error", Message: "Error while adding file " + dstFile + " to document archive: " + err.Error()}
+					b.logger.Error("Error storing source of document", "file", dstFile, "error", err)
 				}
+				artifacts.Files = append(artifacts.Files, dstFile)
 			}
 			n++
 		}
 
-		return utils.StepResult{Status: "success"}
-	} else if resp.StatusCode == 400 {
-		return utils.StepResult{Status: "error"}
+		return utils.StepResult{Status: "success", Artifacts: artifacts}
+	} else if statusCode == 400 {
+		return utils.StepResult{Status: "error", Artifacts: artifacts}
 	}
 
-	return utils.StepResult{Status: "error"}
+	return utils.StepResult{Status: "error", Artifacts: artifacts}
 }
 
-func (b *ClientAuthBrowserDriver) doRequest(ctx context.Context, url string, method string, headers map[string]string, filename string, payload []byte) (bool, error) {
+// stepOauth2Refetch downloads the documents of RefetchSources again with the document request of step and replaces
+// their files. Documents differing from their original download (e.g. regenerated by the supplier) are kept with a warning.
+func (b *ClientAuthBrowserDriver) stepOauth2Refetch(ctx context.Context, step parser.Step, documentArchive *archive.DocumentArchive) utils.StepResult {
+	b.logger.Debug("Executing recipe step", "action", step.Action, "refetch_documents", len(b.RefetchSources))
+	var artifacts utils.StepArtifacts
+	for _, source := range b.RefetchSources {
+		f := filepath.Join(b.downloadsDirectory, filepath.Base(source.Path))
+		integrity := documentIntegrity{checksum: source.Checksum}
+		downloadSuccessful, verification, err := b.doRequest(ctx, source.URL, step.DocumentRequestMethod, step.DocumentRequestHeaders, f, nil, integrity)
+		if errors.Is(err, errDocumentIntegrity) {
+			b.logger.Warn("Refetched document differs from its original download", "file", source.Path, "error", err)
+			downloadSuccessful, err = true, nil
+		}
+		if err != nil || !downloadSuccessful {
+			message := "Error while downloading " + source.URL
+			if err != nil {
+				message += ": " + err.Error()
+			}
+			return utils.StepResult{Status: "error", Message: message, Artifacts: artifacts}
+		}
+
+		err = utils.CreateDirectoryIfNotExists(filepath.Dir(source.Path))
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: "Error while creating directory: " + err.Error(), Artifacts: artifacts}
+		}
+		_, err = utils.CopyFile(f, source.Path)
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: "Error while copying file: " + err.Error(), Artifacts: artifacts}
+		}
+		err = documentArchive.AddVerifiedFile(source.Path, b.supplier, verification)
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: "Error while adding file " + source.Path + " to document archive: " + err.Error(), Artifacts: artifacts}
+		}
+		source.DownloadedAt = time.Now()
+		err = documentArchive.AddSource(source)
+		if err != nil {
+			b.logger.Error("Error storing source of document", "file", source.Path, "error", err)
+		}
+		b.newFilesCount++
+		artifacts.Files = append(artifacts.Files, source.Path)
+	}
+	// Further listing steps of the recipe have nothing left to refetch
+	b.RefetchSources = []archive.Source{}
+
+	return utils.StepResult{Status: "success", Artifacts: artifacts}
+}
+
+// postAndGetItems sends the item listing request of a recipe step.
+// If the response cache is enabled, a cached response for the same request and credentials is used instead.
+func (b *ClientAuthBrowserDriver) postAndGetItems(ctx context.Context, step parser.Step, payload []byte) (int, []byte, error) {
+	cacheKey := httpclient.CacheKey(http.MethodPost, step.URL, payload, b.supplier+"|"+b.credentials.Id)
+	if b.responseCache != nil {
+		if body, ok := b.responseCache.Get(cacheKey); ok {
+			b.logger.Info("Using cached response", "url", step.URL)
+			return http.StatusOK, body, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", step.URL, bytes.NewBuffer(payload))
+	if err != nil {
+		return 0, nil, errors.New("error creating post request")
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	if b.locale != "" {
+		req.Header.Set("Accept-Language", acceptLanguage(b.locale))
+	}
+	for n, h := range step.Headers {
+		if n == "Authorization" {
+			h = strings.Replace(h, "{{ token }}", b.oauth2AuthToken, -1)
+		}
+		req.Header.Set(n, h)
+	}
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error sending post request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusOK && b.responseCache != nil {
+		err = b.responseCache.Set(cacheKey, resp.Header, body)
+		if err != nil {
+			b.logger.Error("Error storing response in cache", "url", step.URL, "error", err)
+		}
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+// doRequest downloads a document to filename and verifies it against the checksum and size announced by the supplier.
+// Returns the verification of the document (see archive.VERIFICATION_* constants).
+func (b *ClientAuthBrowserDriver) doRequest(ctx context.Context, url string, method string, headers map[string]string, filename string, payload []byte, integrity documentIntegrity) (bool, string, error) {
+	h, err := integrity.newHash()
+	if err != nil {
+		return false, "", err
+	}
 	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(payload))
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
+	if b.locale != "" {
+		req.Header.Set("Accept-Language", acceptLanguage(b.locale))
+	}
 	for n, h := range headers {
 		if n == "Authorization" {
 			h = strings.Replace(h, "{{ token }}", b.oauth2AuthToken, -1)
@@ -462,68 +642,162 @@ func (b *ClientAuthBrowserDriver) doRequest(ctx context.Context, url string, met
 		req.Header.Set(n, h)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := b.httpClient.Do(req)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == 200 {
 		out, err := os.Create(filename)
 		if err != nil {
-			return false, err
+			return false, "", err
 		}
 		defer out.Close()
 
-		_, err = io.Copy(out, resp.Body)
-		return err == nil, err
+		var w io.Writer = out
+		if h != nil {
+			w = io.MultiWriter(out, h)
+		}
+		size, err := io.Copy(w, resp.Body)
+		if err != nil {
+			return false, "", err
+		}
+		verification, err := integrity.verify(h, size)
+		return err == nil, verification, err
+	}
+
+	return false, "", nil
+}
+
+// recordCredentialAccess adds the use of a field of the credentials or of a cached token by step to the audit log.
+func (b *ClientAuthBrowserDriver) recordCredentialAccess(kind, field string, step parser.Step) {
+	b.AuditLog.Record(audit.Event{Kind: kind, Field: field, Source: b.credentials.Id, Supplier: b.supplier, Step: step.Action})
+}
+
+// oauth2Parameters replaces the placeholders of additional OAuth2 parameters with the username and the configured variables of the supplier.
+func (b *ClientAuthBrowserDriver) oauth2Parameters(params map[string]string, step parser.Step) map[string]string {
+	variables := map[string]string{"username": b.credentials.Username}
+	for name, value := range b.Oauth2Variables {
+		variables[name] = value
 	}
 
-	return false, nil
+	replaced := make(map[string]string, len(params))
+	for name, value := range params {
+		if _, configured := b.Oauth2Variables["username"]; !configured && strings.Contains(value, "{{ username }}") {
+			b.recordCredentialAccess(audit.KIND_CREDENTIAL, "username", step)
+		}
+		replaced[name] = utils.ReplacePlaceholders(value, variables)
+	}
+	return replaced
 }
 
-func (b *ClientAuthBrowserDriver) getOauth2Tokens(ctx context.Context, payload []byte, pii, buchhalterConfigDirectory string) (secrets.Oauth2Tokens, error) {
+// getOauth2Tokens requests tokens with the fields of a grant and the additional token fields of the recipe.
+func (b *ClientAuthBrowserDriver) getOauth2Tokens(ctx context.Context, fields map[string]string, supplier, credentialId string) (secrets.Oauth2Tokens, error) {
 	var tj secrets.Oauth2Tokens
-	req, err := http.NewRequestWithContext(ctx, "POST", b.oauth2TokenUrl, bytes.NewBuffer(payload))
+	for name, value := range b.oauth2TokenParams {
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+	if b.oauth2ClientAuthMethod == CLIENT_AUTH_SECRET_POST {
+		fields["client_secret"] = b.oauth2ClientSecret
+	}
+
+	req, err := newOauth2TokenRequest(ctx, b.oauth2TokenUrl, b.oauth2TokenEncoding, fields)
 	if err != nil {
-		return tj, fmt.Errorf("failed to create request: %w", err)
+		return tj, err
+	}
+	if b.oauth2ClientAuthMethod == CLIENT_AUTH_SECRET_BASIC {
+		// RFC 6749 requires the client credentials to be form encoded before they are used for basic auth
+		req.SetBasicAuth(url.QueryEscape(b.oauth2ClientId), url.QueryEscape(b.oauth2ClientSecret))
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := b.httpClient.Do(req)
 	if err != nil {
 		return tj, fmt.Errorf("failed to send oauth2 token request: %w", err)
 	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return tj, fmt.Errorf("error reading oauth2 token response body: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 200 {
-		err := json.Unmarshal(body, &tj)
-		if err != nil {
-			return tj, fmt.Errorf("error unmarshalling JSON: %w", err)
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		var oauth2Error struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
 		}
+		_ = json.Unmarshal(body, &oauth2Error)
+		return tj, fmt.Errorf("oauth2 token request for grant %s was rejected: %s %s", fields["grant_type"], oauth2Error.Error, oauth2Error.ErrorDescription)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return tj, fmt.Errorf("unknown error getting oauth2 token: status code %d", resp.StatusCode)
+	}
 
-		err = secrets.SaveOauth2TokensToFile(pii, tj, buchhalterConfigDirectory)
+	err = json.Unmarshal(body, &tj)
+	if err != nil {
+		return tj, fmt.Errorf("error unmarshalling JSON: %w", err)
+	}
+	if tj.AccessToken == "" {
+		return tj, errors.New("oauth2 token response without access token")
+	}
+	// Identity providers without refresh token rotation don't send the refresh token again
+	if tj.RefreshToken == "" {
+		tj.RefreshToken = fields["refresh_token"]
+	}
+	// The lifetime of the tokens starts now, not when the tokens of a refresh token were issued first
+	tj.CreatedAt = int(time.Now().Unix())
+	redact.AddSecrets(tj.AccessToken, tj.RefreshToken)
+
+	err = secrets.SaveOauth2TokensToFile(supplier, credentialId, tj, b.tokenDirectory())
+	if err != nil {
+		return tj, fmt.Errorf("error storing Oauth2 token to file: %w", err)
+	}
+
+	return tj, nil
+}
+
+// newOauth2TokenRequest creates a token request with fields encoded as JSON or as form.
+func newOauth2TokenRequest(ctx context.Context, tokenUrl, encoding string, fields map[string]string) (*http.Request, error) {
+	var body []byte
+	contentType := "application/json"
+	if encoding == TOKEN_ENCODING_FORM {
+		values := url.Values{}
+		for name, value := range fields {
+			values.Set(name, value)
+		}
+		body = []byte(values.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	} else {
+		var err error
+		body, err = json.Marshal(fields)
 		if err != nil {
-			return tj, fmt.Errorf("error storing Oauth2 token ti file: %w", err)
+			return nil, fmt.Errorf("failed to encode oauth2 token request: %w", err)
 		}
+	}
 
-		return tj, nil
-	} else if resp.StatusCode == 400 {
-		return tj, errors.New("unauthorized error while trying to get oauth2 access token with refresh token")
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenUrl, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
 
-	return tj, errors.New("unknown error getting oauth2 token")
+// tokenDirectory returns the token cache directory of the token profile.
+func (b *ClientAuthBrowserDriver) tokenDirectory() string {
+	return secrets.TokenDirectory(b.buchhalterConfigDirectory, b.TokenProfile)
 }
 
+// validOauth2AuthToken returns true if the access token is valid long enough to be used for the next requests.
 func (b *ClientAuthBrowserDriver) validOauth2AuthToken(tokens secrets.Oauth2Tokens) bool {
-	n := int(time.Now().Unix())
-	vu := tokens.CreatedAt + tokens.ExpiresIn
-	return vu > n
+	if tokens.AccessToken == "" {
+		return false
+	}
+	return time.Until(tokens.ExpiresAt()) > oauth2TokenExpiryLeeway*time.Second
 }
 
 func (b *ClientAuthBrowserDriver) run(timeout time.Duration, task chromedp.Action) chromedp.ActionFunc {
@@ -572,10 +846,16 @@ func extractJsonRecursive(data interface{}, keys []string) []string {
 		switch v := data.(type) {
 		case string:
 			results = append(results, v)
+		case float64:
+			// e.g. numeric ids or document sizes
+			results = append(results, strconv.FormatFloat(v, 'f', -1, 64))
 		case []interface{}:
 			for _, item := range v {
-				if str, ok := item.(string); ok {
-					results = append(results, str)
+				switch value := item.(type) {
+				case string:
+					results = append(results, value)
+				case float64:
+					results = append(results, strconv.FormatFloat(value, 'f', -1, 64))
 				}
 			}
 		}
@@ -604,6 +884,13 @@ func extractJsonRecursive(data interface{}, keys []string) []string {
 	return results
 }
 
+func (b *ClientAuthBrowserDriver) removeDownloadsDirectory() {
+	err := utils.RemoveTemporaryDirectory(b.downloadsDirectory, b.ShredTemporaryFiles)
+	if err != nil {
+		b.logger.Error("Error removing downloads directory", "downloads_directory", b.downloadsDirectory, "error", err)
+	}
+}
+
 func (b *ClientAuthBrowserDriver) Quit() error {
 	if b.browserCtx != nil {
 		return chromedp.Cancel(b.browserCtx)
@@ -611,3 +898,24 @@ func (b *ClientAuthBrowserDriver) Quit() error {
 
 	return nil
 }
+
+// waitForLocation waits until the page was redirected to a URL starting with prefix and stores it in u.
+func waitForLocation(prefix string, u *string) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		for {
+			err := chromedp.Location(u).Do(ctx)
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(*u, prefix) {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("not redirected to %s: %w", prefix, ctx.Err())
+			case <-time.After(actionabilityPollInterval):
+			}
+		}
+	}
+}
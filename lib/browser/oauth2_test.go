@@ -0,0 +1,388 @@
+package browser
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/secrets"
+	"buchhalter/lib/utils"
+	"buchhalter/lib/vault"
+)
+
+func TestNewOauth2TokenRequest(t *testing.T) {
+	fields := map[string]string{"grant_type": "authorization_code", "code": "a b&c"}
+
+	req, err := newOauth2TokenRequest(context.Background(), "https://idp.example/token", TOKEN_ENCODING_FORM, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" || values.Get("code") != "a b&c" || values.Get("grant_type") != "authorization_code" {
+		t.Errorf("unexpected form request %s: %s", req.Header.Get("Content-Type"), body)
+	}
+
+	req, err = newOauth2TokenRequest(context.Background(), "https://idp.example/token", "", fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]string
+	err = json.NewDecoder(req.Body).Decode(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("Content-Type") != "application/json" || decoded["code"] != "a b&c" {
+		t.Errorf("unexpected JSON request %s: %v", req.Header.Get("Content-Type"), decoded)
+	}
+}
+
+// newTestTokenDriver returns a client driver set up against a mock token endpoint.
+func newTestTokenDriver(t *testing.T, handler http.HandlerFunc, setup func(b *ClientAuthBrowserDriver, step *parser.Step)) *ClientAuthBrowserDriver {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	credentials := &vault.Credentials{Id: "item", Username: "user"}
+	b := NewClientAuthBrowserDriver(context.Background(), logger, httpclient.New(logger, 5*time.Second, 0), nil, credentials, t.TempDir(), t.TempDir(), nil)
+
+	step := parser.Step{Action: "oauth2-setup"}
+	step.Oauth2.TokenUrl = server.URL + "/token"
+	step.Oauth2.ClientId = "client"
+	if setup != nil {
+		setup(b, &step)
+	}
+	if result := b.stepOauth2Setup(step); result.Status != "success" {
+		t.Fatalf("oauth2 setup failed: %s", result.Message)
+	}
+	return b
+}
+
+func TestOauth2CheckTokens(t *testing.T) {
+	recipe := &parser.Recipe{Supplier: "acme"}
+	credentials := &vault.Credentials{Id: "item", Username: "user"}
+	expired := secrets.Oauth2Tokens{AccessToken: "old-access", RefreshToken: "old-refresh", ExpiresIn: 3600, CreatedAt: int(time.Now().Unix()) - 7200}
+
+	tests := []struct {
+		name             string
+		cached           *secrets.Oauth2Tokens
+		response         string
+		status           int
+		expectedStatus   string
+		expectedToken    string
+		expectedRefresh  string
+		expectedRequests int
+	}{
+		{
+			name:             "no cached tokens",
+			expectedStatus:   "error",
+			expectedRequests: 0,
+		},
+		{
+			name:             "valid cached tokens",
+			cached:           &secrets.Oauth2Tokens{AccessToken: "cached-access", RefreshToken: "cached-refresh", ExpiresIn: 3600, CreatedAt: int(time.Now().Unix())},
+			expectedStatus:   "success",
+			expectedToken:    "cached-access",
+			expectedRefresh:  "cached-refresh",
+			expectedRequests: 0,
+		},
+		{
+			name:             "refresh with rotated refresh token",
+			cached:           &expired,
+			response:         `{"access_token": "new-access", "refresh_token": "new-refresh", "token_type": "Bearer", "expires_in": 3600}`,
+			status:           http.StatusOK,
+			expectedStatus:   "success",
+			expectedToken:    "new-access",
+			expectedRefresh:  "new-refresh",
+			expectedRequests: 1,
+		},
+		{
+			name:             "refresh without rotation keeps refresh token",
+			cached:           &expired,
+			response:         `{"access_token": "new-access", "token_type": "Bearer", "expires_in": 3600}`,
+			status:           http.StatusOK,
+			expectedStatus:   "success",
+			expectedToken:    "new-access",
+			expectedRefresh:  "old-refresh",
+			expectedRequests: 1,
+		},
+		{
+			name:             "rejected refresh token",
+			cached:           &expired,
+			response:         `{"error": "invalid_grant"}`,
+			status:           http.StatusBadRequest,
+			expectedStatus:   "error",
+			expectedRefresh:  "old-refresh",
+			expectedRequests: 1,
+		},
+		{
+			name:             "expired without refresh token",
+			cached:           &secrets.Oauth2Tokens{AccessToken: "old-access", ExpiresIn: 3600, CreatedAt: expired.CreatedAt},
+			expectedStatus:   "error",
+			expectedRequests: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			b := newTestTokenDriver(t, func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				var fields map[string]string
+				_ = json.NewDecoder(r.Body).Decode(&fields)
+				if fields["grant_type"] != "refresh_token" || fields["refresh_token"] != tt.cached.RefreshToken || fields["client_id"] != "client" {
+					t.Errorf("unexpected token request %v", fields)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}, nil)
+			if tt.cached != nil {
+				if err := secrets.SaveOauth2TokensToFile("acme", "item", *tt.cached, b.tokenDirectory()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result := b.stepOauth2CheckTokens(context.Background(), recipe, parser.Step{Action: "oauth2-check-tokens"}, credentials)
+			if result.Status != tt.expectedStatus || result.Break {
+				t.Errorf("expected status %s without break, got %s (%s)", tt.expectedStatus, result.Status, result.Message)
+			}
+			if b.oauth2AuthToken != tt.expectedToken {
+				t.Errorf("expected access token %q, got %q", tt.expectedToken, b.oauth2AuthToken)
+			}
+			if requests != tt.expectedRequests {
+				t.Errorf("expected %d token requests, got %d", tt.expectedRequests, requests)
+			}
+
+			if tt.expectedRefresh != "" {
+				stored, err := secrets.GetOauthAccessTokenFromCache("acme", "item", b.tokenDirectory())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.RefreshToken != tt.expectedRefresh {
+					t.Errorf("expected stored refresh token %q, got %q", tt.expectedRefresh, stored.RefreshToken)
+				}
+				if tt.expectedToken == "new-access" && !b.validOauth2AuthToken(stored) {
+					t.Errorf("expected stored refreshed tokens to be valid, created at %d", stored.CreatedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestOauth2TokenRequestWithClientSecret(t *testing.T) {
+	b := newTestTokenDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		clientId, clientSecret, ok := r.BasicAuth()
+		if !ok || clientId != "client" || clientSecret != "s3cr3t" {
+			t.Errorf("expected basic auth with client credentials, got %q %q", clientId, clientSecret)
+		}
+		if r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" || r.FormValue("tenant") != "my-company" || r.FormValue("client_secret") != "" {
+			t.Errorf("unexpected token request %s %v", r.Header.Get("Content-Type"), r.PostForm)
+		}
+		_, _ = w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "expires_in": 3600}`))
+	}, func(b *ClientAuthBrowserDriver, step *parser.Step) {
+		b.Oauth2Variables = map[string]string{"client_secret": "s3cr3t", "tenant": "my-company"}
+		step.Oauth2.TokenRequestEncoding = TOKEN_ENCODING_FORM
+		step.Oauth2.ClientAuthMethod = CLIENT_AUTH_SECRET_BASIC
+		step.Oauth2.ClientSecret = "{{ client_secret }}"
+		step.Oauth2.TokenParams = map[string]string{"tenant": "{{ tenant }}"}
+	})
+
+	tokens, err := b.getOauth2Tokens(context.Background(), map[string]string{"grant_type": "authorization_code", "client_id": "client", "code": "code"}, "acme", "item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken != "access" || tokens.CreatedAt == 0 {
+		t.Errorf("unexpected tokens %+v", tokens)
+	}
+}
+
+func TestOauth2PostAndGetItemsVerifiesDocuments(t *testing.T) {
+	document := []byte("%PDF-1.4 invoice")
+	checksum := fmt.Sprintf("%x", sha256.Sum256(document))
+	downloads := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/items":
+			_, _ = fmt.Fprintf(w, `{"data": [{"id": "1", "filename": "1.pdf", "sha256": "%s", "size": %d}, {"id": "2", "filename": "2.pdf", "sha256": "%s"}]}`, checksum, len(document), checksum)
+		default:
+			downloads[r.URL.Path]++
+			// The first download of the first document is truncated, the second document is always corrupt
+			if (r.URL.Path == "/documents/1" && downloads[r.URL.Path] == 1) || r.URL.Path == "/documents/2" {
+				_, _ = w.Write(document[:4])
+				return
+			}
+			_, _ = w.Write(document)
+		}
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	documentArchive := archive.NewDocumentArchive(logger, t.TempDir(), archive.LAYOUT_SUPPLIER, "", nil)
+	b := NewClientAuthBrowserDriver(context.Background(), logger, httpclient.New(logger, 5*time.Second, 0), nil, &vault.Credentials{Id: "item"}, t.TempDir(), t.TempDir(), documentArchive)
+	b.supplier = "acme"
+	b.downloadsDirectory = t.TempDir()
+	b.documentsDirectory = t.TempDir()
+
+	step := parser.Step{
+		Action:                   "oauth2-post-and-get-items",
+		URL:                      server.URL + "/items",
+		ExtractDocumentIds:       "data.id",
+		ExtractDocumentFilenames: "data.filename",
+		ExtractDocumentChecksums: "data.sha256",
+		ExtractDocumentSizes:     "data.size",
+		DocumentUrl:              server.URL + "/documents/{{ id }}",
+		DocumentRequestMethod:    http.MethodGet,
+	}
+	result := b.stepOauth2PostAndGetItems(context.Background(), step, documentArchive)
+	if result.Status != "error" || !strings.Contains(result.Message, "checksum") {
+		t.Errorf("expected the corrupt document to fail, got %s (%s)", result.Status, result.Message)
+	}
+	if downloads["/documents/1"] != 2 || downloads["/documents/2"] != maxDocumentDownloadAttempts {
+		t.Errorf("unexpected download attempts %v", downloads)
+	}
+
+	f, ok := documentArchive.GetFile(checksum)
+	if !ok || f.Verification != archive.VERIFICATION_CHECKSUM {
+		t.Errorf("expected the first document to be verified, got %+v", f)
+	}
+}
+
+func TestDocumentIntegrityVerify(t *testing.T) {
+	h := sha256.New()
+	h.Write([]byte("invoice"))
+	checksum := fmt.Sprintf("%x", h.Sum(nil))
+
+	tests := []struct {
+		name         string
+		integrity    documentIntegrity
+		size         int64
+		verification string
+		mismatch     bool
+	}{
+		{name: "nothing announced", size: 7},
+		{name: "size", integrity: documentIntegrity{size: "7"}, size: 7, verification: archive.VERIFICATION_SIZE},
+		{name: "wrong size", integrity: documentIntegrity{size: "8"}, size: 7, mismatch: true},
+		{name: "checksum with prefix", integrity: documentIntegrity{checksum: "SHA256:" + strings.ToUpper(checksum)}, size: 7, verification: archive.VERIFICATION_CHECKSUM},
+		{name: "wrong checksum", integrity: documentIntegrity{checksum: "abc", size: "7"}, size: 7, mismatch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var documentHash hash.Hash
+			if tt.integrity.checksum != "" {
+				documentHash = h
+			}
+			verification, err := tt.integrity.verify(documentHash, tt.size)
+			if errors.Is(err, errDocumentIntegrity) != tt.mismatch || verification != tt.verification {
+				t.Errorf("expected verification %q (mismatch %v), got %q (%v)", tt.verification, tt.mismatch, verification, err)
+			}
+		})
+	}
+}
+
+func TestOauth2RefetchReplacesDocuments(t *testing.T) {
+	document := []byte("%PDF-1.4 invoice")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/documents/1" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		_, _ = w.Write(document)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	archiveDirectory := t.TempDir()
+	documentArchive := archive.NewDocumentArchive(logger, archiveDirectory, archive.LAYOUT_SUPPLIER, "", nil)
+	b := NewClientAuthBrowserDriver(context.Background(), logger, httpclient.New(logger, 5*time.Second, 0), nil, &vault.Credentials{Id: "item"}, t.TempDir(), t.TempDir(), documentArchive)
+	b.supplier = "acme"
+	b.downloadsDirectory = t.TempDir()
+	// The corrupted document was deleted
+	documentPath := filepath.Join(archiveDirectory, "acme", "1.pdf")
+	b.RefetchSources = []archive.Source{{Supplier: "acme", Path: documentPath, ID: "1", URL: server.URL + "/documents/1", Checksum: fmt.Sprintf("%x", sha256.Sum256(document))}}
+
+	step := parser.Step{Action: "oauth2-post-and-get-items", DocumentRequestMethod: http.MethodGet}
+	result := b.RunStep(context.Background(), &parser.Recipe{Supplier: "acme"}, step)
+	if result.Status != "success" || len(result.Artifacts.Files) != 1 {
+		t.Fatalf("expected the document to be downloaded again, got %s (%s)", result.Status, result.Message)
+	}
+	content, err := os.ReadFile(documentPath)
+	if err != nil || !bytes.Equal(content, document) {
+		t.Errorf("expected the document to be replaced, got %q (%v)", content, err)
+	}
+	f, ok := documentArchive.GetFile(fmt.Sprintf("%x", sha256.Sum256(document)))
+	if !ok || f.Verification != archive.VERIFICATION_CHECKSUM {
+		t.Errorf("expected the document to match its original download, got %+v", f)
+	}
+
+	// Further listing steps have nothing left to refetch
+	result = b.RunStep(context.Background(), &parser.Recipe{Supplier: "acme"}, step)
+	if result.Status != "success" || len(result.Artifacts.Files) != 0 {
+		t.Errorf("expected no further downloads, got %s (%v)", result.Status, result.Artifacts.Files)
+	}
+}
+
+func TestOauth2PostAndGetItemsListOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/items" {
+			t.Errorf("expected no document downloads, got %s", r.URL.Path)
+		}
+		_, _ = fmt.Fprint(w, `{"data": [{"id": "1", "date": "2024-01-31"}, {"id": "2", "date": "2024-02-29"}]}`)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	archiveDirectory := t.TempDir()
+	documentArchive := archive.NewDocumentArchive(logger, archiveDirectory, archive.LAYOUT_SUPPLIER, "", nil)
+	// The first document was downloaded before
+	documentPath := filepath.Join(archiveDirectory, "acme", "1.pdf")
+	if err := os.MkdirAll(filepath.Dir(documentPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(documentPath, []byte("%PDF-1.4 invoice"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := documentArchive.AddSource(archive.Source{Supplier: "acme", Path: documentPath, ID: "1", URL: server.URL + "/documents/1"}); err != nil {
+		t.Fatal(err)
+	}
+	b := NewClientAuthBrowserDriver(context.Background(), logger, httpclient.New(logger, 5*time.Second, 0), nil, &vault.Credentials{Id: "item"}, t.TempDir(), t.TempDir(), documentArchive)
+	b.supplier = "acme"
+	b.ListOnly = true
+
+	step := parser.Step{
+		Action:               "oauth2-post-and-get-items",
+		URL:                  server.URL + "/items",
+		ExtractDocumentIds:   "data.id",
+		ExtractDocumentDates: "data.date",
+		DocumentUrl:          server.URL + "/documents/{{ id }}",
+	}
+	result := b.stepOauth2PostAndGetItems(context.Background(), step, documentArchive)
+	if result.Status != "success" || len(result.Artifacts.Files) != 0 || b.NewFilesCount() != 0 {
+		t.Fatalf("expected the documents to be listed only, got %s (%s)", result.Status, result.Message)
+	}
+	expected := []utils.DiscoveredDocument{
+		{ID: "1", URL: server.URL + "/documents/1", Date: "2024-01-31", Archived: true},
+		{ID: "2", URL: server.URL + "/documents/2", Date: "2024-02-29"},
+	}
+	if !reflect.DeepEqual(result.Artifacts.Documents, expected) {
+		t.Errorf("expected documents %+v, got %+v", expected, result.Artifacts.Documents)
+	}
+}
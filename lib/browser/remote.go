@@ -0,0 +1,275 @@
+package browser
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
+	cdpio "github.com/chromedp/cdproto/io"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// remoteDownloadChunkSize is the number of bytes read per request from the response body of a remote download
+const remoteDownloadChunkSize = 1 << 20
+
+// Content types Chrome shows instead of downloading them
+var renderedContentTypes = []string{"text/", "image/", "audio/", "video/", "application/pdf", "application/json", "application/xml", "application/xhtml+xml", "application/javascript"}
+
+// remoteDownloads emits the download events of a remote Chrome. Its downloads are retrieved over CDP instead of
+// being stored by Chrome, so Chrome doesn't send download events for them.
+type remoteDownloads struct {
+	mu        sync.Mutex
+	next      int
+	listeners map[int]func(ev interface{})
+	// active is the number of downloads being retrieved
+	active atomic.Int32
+}
+
+// listen calls fn with the download events until ctx is done.
+func (r *remoteDownloads) listen(ctx context.Context, fn func(ev interface{})) {
+	r.mu.Lock()
+	id := r.next
+	r.next++
+	if r.listeners == nil {
+		r.listeners = map[int]func(ev interface{}){}
+	}
+	r.listeners[id] = fn
+	r.mu.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		r.mu.Lock()
+		delete(r.listeners, id)
+		r.mu.Unlock()
+	})
+}
+
+func (r *remoteDownloads) emit(ev interface{}) {
+	r.mu.Lock()
+	listeners := make([]func(ev interface{}), 0, len(r.listeners))
+	for _, fn := range r.listeners {
+		listeners = append(listeners, fn)
+	}
+	r.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
+
+// wait blocks until all downloads are retrieved.
+func (r *remoteDownloads) wait(ctx context.Context) error {
+	for r.active.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(downloadPollInterval):
+		}
+	}
+	return nil
+}
+
+// connectRemoteChrome opens a new tab in the Chrome at RemoteDebuggingURL. Closing the tab doesn't stop the remote Chrome.
+func (b *BrowserDriver) connectRemoteChrome() (context.Context, context.CancelFunc) {
+	var opts []chromedp.RemoteAllocatorOption
+	if strings.HasPrefix(b.RemoteDebuggingURL, "ws://") || strings.HasPrefix(b.RemoteDebuggingURL, "wss://") {
+		// WebSocket URLs (e.g. of browserless, incl. a token) are used as given, others are looked up via /json/version
+		opts = append(opts, chromedp.NoModifyURL)
+	}
+	allocatorCtx, cancelAllocator := chromedp.NewRemoteAllocator(b.browserCtx, b.RemoteDebuggingURL, opts...)
+	ctx, cancel := chromedp.NewContext(allocatorCtx)
+	return ctx, func() {
+		cancel()
+		cancelAllocator()
+	}
+}
+
+// prepareRemoteChrome prepares the tab of a remote Chrome for the steps. Command line flags of Chrome can't be
+// set remotely, the locale is emulated instead. Downloads are denied, they are retrieved over CDP.
+func (b *BrowserDriver) prepareRemoteChrome(ctx context.Context, locale string) error {
+	if b.ChromeVersion == "" {
+		err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			_, product, _, _, _, err := browser.GetVersion().Do(ctx)
+			// e.g. HeadlessChrome/131.0.6778.85
+			_, b.ChromeVersion, _ = strings.Cut(product, "/")
+			return err
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	if b.MemoryLimit > 0 {
+		b.logger.Warn("Memory limit can't be set for a remote chrome browser", "memory_limit", b.MemoryLimit)
+	}
+
+	tasks := chromedp.Tasks{
+		browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorDeny),
+		b.enableLifeCycleEvents(),
+		fetch.Enable().WithPatterns([]*fetch.RequestPattern{
+			{URLPattern: "*", RequestStage: fetch.RequestStageRequest},
+			{URLPattern: "*", ResourceType: network.ResourceTypeDocument, RequestStage: fetch.RequestStageResponse},
+			{URLPattern: "*", ResourceType: network.ResourceTypeOther, RequestStage: fetch.RequestStageResponse},
+		}),
+	}
+	if locale != "" {
+		tasks = append(tasks,
+			emulation.SetLocaleOverride().WithLocale(locale),
+			network.Enable(),
+			network.SetExtraHTTPHeaders(network.Headers{"Accept-Language": acceptLanguage(locale)}),
+		)
+	}
+	return chromedp.Run(ctx, tasks)
+}
+
+// listenDownloads calls fn with the download events of Chrome until ctx is done.
+func (b *BrowserDriver) listenDownloads(ctx context.Context, fn func(ev interface{})) {
+	if b.RemoteDebuggingURL != "" {
+		b.remoteDownloads.listen(ctx, fn)
+		return
+	}
+	chromedp.ListenTarget(ctx, fn)
+}
+
+// retrieveRemoteDownload stores the response of a paused request in the downloads directory if Chrome would download
+// it. The request is aborted then, so the remote Chrome doesn't store it. Other responses continue.
+func (b *BrowserDriver) retrieveRemoteDownload(ctx context.Context, ev *fetch.EventRequestPaused) {
+	headers := map[string]string{}
+	for _, h := range ev.ResponseHeaders {
+		headers[strings.ToLower(h.Name)] = h.Value
+	}
+	if ev.ResponseErrorReason != "" || !isDownloadResponse(ev.ResponseStatusCode, headers["content-type"], headers["content-disposition"]) {
+		err := fetch.ContinueRequest(ev.RequestID).Do(ctx)
+		if err != nil {
+			b.logger.Debug("Failed to continue response", "error", err.Error())
+		}
+		return
+	}
+
+	b.remoteDownloads.active.Add(1)
+	defer b.remoteDownloads.active.Add(-1)
+	urlPath := ev.Request.URL
+	if u, err := url.Parse(ev.Request.URL); err == nil {
+		urlPath = u.Path
+	}
+	guid := string(ev.RequestID)
+	size := int64(0)
+	f, err := createUniqueFile(b.downloadsDirectory, documentFilename(headers["content-disposition"], urlPath))
+	if err == nil {
+		filename := filepath.Base(f.Name())
+		b.remoteDownloads.emit(&browser.EventDownloadWillBegin{GUID: guid, URL: ev.Request.URL, SuggestedFilename: filename})
+		b.logger.Debug("Retrieving download of remote chrome browser ...", "url", ev.Request.URL, "filename", filename)
+		size, err = b.readResponseBody(ctx, ev.RequestID, f)
+	}
+	state := browser.DownloadProgressStateCompleted
+	if err != nil {
+		b.logger.Error("Error retrieving download of remote chrome browser", "url", ev.Request.URL, "error", err)
+		state = browser.DownloadProgressStateCanceled
+	}
+	// The body was taken, the request can't continue anyway
+	err = fetch.FailRequest(ev.RequestID, network.ErrorReasonAborted).Do(ctx)
+	if err != nil {
+		b.logger.Debug("Failed to abort download request", "error", err.Error())
+	}
+	b.remoteDownloads.emit(&browser.EventDownloadProgress{GUID: guid, ReceivedBytes: float64(size), TotalBytes: float64(size), State: state})
+}
+
+// readResponseBody streams the body of a request paused at the response stage into f and closes it. f is removed if
+// the body can't be read.
+func (b *BrowserDriver) readResponseBody(ctx context.Context, requestID fetch.RequestID, f *os.File) (int64, error) {
+	stream, err := fetch.TakeResponseBodyAsStream(requestID).Do(ctx)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return 0, err
+	}
+	defer func() {
+		_ = cdpio.Close(stream).Do(ctx)
+	}()
+
+	size := int64(0)
+	for {
+		// cdpio.Read drops whether the data is base64 encoded
+		var chunk cdpio.ReadReturns
+		err = cdp.Execute(ctx, cdpio.CommandRead, cdpio.Read(stream).WithSize(remoteDownloadChunkSize), &chunk)
+		if err != nil {
+			break
+		}
+		data := []byte(chunk.Data)
+		if chunk.Base64encoded {
+			data, err = base64.StdEncoding.DecodeString(chunk.Data)
+			if err != nil {
+				break
+			}
+		}
+		_, err = f.Write(data)
+		if err != nil {
+			break
+		}
+		size += int64(len(data))
+		if chunk.EOF {
+			break
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return 0, err
+	}
+	return size, nil
+}
+
+// isDownloadResponse returns whether Chrome downloads a response instead of showing it.
+func isDownloadResponse(statusCode int64, contentType, contentDisposition string) bool {
+	if statusCode < 200 || statusCode >= 300 {
+		return false
+	}
+	if disposition, _, err := mime.ParseMediaType(contentDisposition); err == nil && disposition == "attachment" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, rendered := range renderedContentTypes {
+		if strings.HasPrefix(mediaType, rendered) {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueFilename returns filename or, if a file with the name exists in directory, the name with a number like Chrome
+// does, e.g. "invoice (1).pdf".
+func uniqueFilename(directory, filename string) string {
+	extension := filepath.Ext(filename)
+	name := strings.TrimSuffix(filename, extension)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filepath.Join(directory, filename)); os.IsNotExist(err) {
+			return filename
+		}
+		filename = fmt.Sprintf("%s (%d)%s", name, i, extension)
+	}
+}
+
+// remoteDebuggingHost returns the host of a remote debugging URL, the URL may contain a token.
+func remoteDebuggingHost(remoteDebuggingURL string) string {
+	u, err := url.Parse(remoteDebuggingURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,92 @@
+package browser
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/parser"
+
+	"github.com/chromedp/cdproto/browser"
+)
+
+func TestIsDownloadResponse(t *testing.T) {
+	tests := []struct {
+		name               string
+		statusCode         int64
+		contentType        string
+		contentDisposition string
+		download           bool
+	}{
+		{"attachment", 200, "application/pdf", `attachment; filename="invoice.pdf"`, true},
+		{"inline pdf", 200, "application/pdf", `inline; filename="invoice.pdf"`, false},
+		{"page", 200, "text/html; charset=utf-8", "", false},
+		{"zip", 200, "application/zip", "", true},
+		{"redirect", 302, "application/zip", "", false},
+		{"without content type", 200, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if download := isDownloadResponse(tt.statusCode, tt.contentType, tt.contentDisposition); download != tt.download {
+				t.Errorf("expected download %t, got %t", tt.download, download)
+			}
+		})
+	}
+}
+
+func TestUniqueFilename(t *testing.T) {
+	directory := t.TempDir()
+	if filename := uniqueFilename(directory, "invoice.pdf"); filename != "invoice.pdf" {
+		t.Errorf("expected invoice.pdf, got %s", filename)
+	}
+	for _, existing := range []string{"invoice.pdf", "invoice (1).pdf"} {
+		if err := os.WriteFile(filepath.Join(directory, existing), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if filename := uniqueFilename(directory, "invoice.pdf"); filename != "invoice (2).pdf" {
+		t.Errorf("expected invoice (2).pdf, got %s", filename)
+	}
+}
+
+func TestRemoteDownloadsListen(t *testing.T) {
+	var downloads remoteDownloads
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker := newDownloadTracker(defaultDownloadTimeout)
+	completed := make(chan bool, 2)
+	downloads.listen(ctx, func(ev interface{}) {
+		completed <- tracker.handleEvent(ev)
+	})
+
+	downloads.emit(&browser.EventDownloadWillBegin{GUID: "1", URL: "https://example.com/invoice.pdf", SuggestedFilename: "invoice.pdf"})
+	downloads.emit(&browser.EventDownloadProgress{GUID: "1", State: browser.DownloadProgressStateCompleted})
+	if <-completed || !<-completed {
+		t.Error("expected the second event to complete the download")
+	}
+	if done, _ := tracker.result(); len(done) != 1 || done[0].Filename != "invoice.pdf" {
+		t.Errorf("unexpected downloads %+v", done)
+	}
+}
+
+func TestRunRecipeWithUnreachableRemoteChrome(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	documentArchive := archive.NewDocumentArchive(logger, t.TempDir(), "", "", nil)
+	b := NewBrowserDriver(context.Background(), logger, httpclient.New(logger, 5*time.Second, 0), nil, t.TempDir(), t.TempDir(), documentArchive, 0)
+	// Nothing listens on port 1
+	b.RemoteDebuggingURL = "ws://127.0.0.1:1"
+
+	result := b.RunRecipe(nil, 1, 1, 0, &parser.Recipe{Supplier: "acme", Steps: []parser.Step{{Action: "open", URL: "https://acme.example"}}})
+	if result.Status != "error" {
+		t.Errorf("expected an error result, got %s", result.Status)
+	}
+	if result.LastErrorMessage == "" {
+		t.Error("expected the connection error as message")
+	}
+}
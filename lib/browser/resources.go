@@ -0,0 +1,150 @@
+package browser
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+
+	"buchhalter/lib/blocklist"
+	"buchhalter/lib/parser"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// Resource kinds a resource policy can block (`resourcePolicy.block`)
+const (
+	RESOURCE_IMAGE       = "image"
+	RESOURCE_FONT        = "font"
+	RESOURCE_MEDIA       = "media"
+	RESOURCE_STYLESHEET  = "stylesheet"
+	RESOURCE_THIRD_PARTY = "thirdParty"
+)
+
+// defaultResourcePolicy blocks images for performance reasons if a recipe doesn't define a policy
+var defaultResourcePolicy = &parser.ResourcePolicy{Block: []string{RESOURCE_IMAGE}}
+
+var blockableResourceTypes = map[string]network.ResourceType{
+	RESOURCE_IMAGE:      network.ResourceTypeImage,
+	RESOURCE_FONT:       network.ResourceTypeFont,
+	RESOURCE_MEDIA:      network.ResourceTypeMedia,
+	RESOURCE_STYLESHEET: network.ResourceTypeStylesheet,
+}
+
+// resourceBlocker decides which requests of a page are blocked.
+// The policy changes per step, while requests are paused concurrently.
+type resourceBlocker struct {
+	mu            sync.RWMutex
+	policy        *parser.ResourcePolicy
+	recipeDomains []string
+	// blocklist of ads and trackers (optional)
+	blocklist *blocklist.Blocklist
+}
+
+func newResourceBlocker(recipeDomains []string, adBlocklist *blocklist.Blocklist) *resourceBlocker {
+	return &resourceBlocker{policy: defaultResourcePolicy, recipeDomains: recipeDomains, blocklist: adBlocklist}
+}
+
+// setPolicy sets the policy of the next step. The first non-nil policy wins (e.g. step, recipe).
+func (r *resourceBlocker) setPolicy(policies ...*parser.ResourcePolicy) {
+	policy := defaultResourcePolicy
+	for _, p := range policies {
+		if p != nil {
+			policy = p
+			break
+		}
+	}
+
+	r.mu.Lock()
+	r.policy = policy
+	r.mu.Unlock()
+}
+
+// blocks returns true if a request of resourceType to requestURL is blocked by the current policy.
+func (r *resourceBlocker) blocks(resourceType network.ResourceType, requestURL string) bool {
+	// Pages themselves and downloads are never blocked
+	if resourceType == network.ResourceTypeDocument {
+		return false
+	}
+
+	r.mu.RLock()
+	policy := r.policy
+	r.mu.RUnlock()
+
+	host := ""
+	if u, err := url.Parse(requestURL); err == nil {
+		host = u.Hostname()
+	}
+	if matchesDomain(host, policy.AllowDomains) {
+		return false
+	}
+	if !matchesDomain(host, r.recipeDomains) && r.blocklist.Blocks(host) {
+		return true
+	}
+
+	for _, block := range policy.Block {
+		if block == RESOURCE_THIRD_PARTY {
+			if host != "" && !matchesDomain(host, r.recipeDomains) {
+				return true
+			}
+			continue
+		}
+		if t, ok := blockableResourceTypes[block]; ok && t == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesDomain returns true if host is one of domains or a subdomain of them.
+func matchesDomain(host string, domains []string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockResources fails paused requests blocked by the resource policy and continues all others.
+func (b *BrowserDriver) blockResources(ctx context.Context) func(event interface{}) {
+	return func(event interface{}) {
+		switch ev := event.(type) {
+		case *fetch.EventRequestPaused:
+			go func() {
+				c := chromedp.FromContext(ctx)
+				ctx := cdp.WithExecutor(ctx, c.Target)
+				// Only requests of a remote Chrome are paused at the response stage
+				if ev.ResponseStatusCode != 0 || ev.ResponseErrorReason != "" {
+					b.retrieveRemoteDownload(ctx, ev)
+					return
+				}
+				if b.FixtureReplay != nil {
+					err := b.replayRequest(ctx, ev)
+					if err != nil {
+						b.logger.Debug("Failed to replay request", "url", ev.Request.URL, "error", err.Error())
+					}
+					return
+				}
+				if b.resourceBlocker.blocks(ev.ResourceType, ev.Request.URL) {
+					err := fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient).Do(ctx)
+					if err != nil {
+						b.logger.Debug("Failed to block request", "resource_type", ev.ResourceType, "error", err.Error())
+						return
+					}
+				} else {
+					err := fetch.ContinueRequest(ev.RequestID).Do(ctx)
+					if err != nil {
+						b.logger.Debug("Failed to continue request", "error", err.Error())
+						return
+					}
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package browser
+
+import (
+	"testing"
+
+	"buchhalter/lib/parser"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestResourceBlockerBlocks(t *testing.T) {
+	blocker := newResourceBlocker([]string{"hetzner.com"}, nil)
+	recipePolicy := &parser.ResourcePolicy{
+		Block:        []string{RESOURCE_IMAGE, RESOURCE_FONT, RESOURCE_THIRD_PARTY},
+		AllowDomains: []string{"*.cdn.example.com"},
+	}
+
+	tests := []struct {
+		name         string
+		stepPolicy   *parser.ResourcePolicy
+		resourceType network.ResourceType
+		url          string
+		blocked      bool
+	}{
+		{"image", nil, network.ResourceTypeImage, "https://accounts.hetzner.com/logo.png", true},
+		{"font", nil, network.ResourceTypeFont, "https://accounts.hetzner.com/font.woff", true},
+		{"script of supplier", nil, network.ResourceTypeScript, "https://accounts.hetzner.com/app.js", false},
+		{"third party script", nil, network.ResourceTypeScript, "https://tracker.example.org/t.js", true},
+		{"allowed domain", nil, network.ResourceTypeImage, "https://img.cdn.example.com/invoice.png", false},
+		{"document", nil, network.ResourceTypeDocument, "https://sso.example.org/login", false},
+		{"step allows images", &parser.ResourcePolicy{Block: []string{RESOURCE_FONT}}, network.ResourceTypeImage, "https://accounts.hetzner.com/logo.png", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocker.setPolicy(tt.stepPolicy, recipePolicy)
+			if blocked := blocker.blocks(tt.resourceType, tt.url); blocked != tt.blocked {
+				t.Errorf("expected blocked=%t for %s %s, got %t", tt.blocked, tt.resourceType, tt.url, blocked)
+			}
+		})
+	}
+}
+
+func TestResourceBlockerDefaultPolicyBlocksImages(t *testing.T) {
+	blocker := newResourceBlocker(nil, nil)
+	blocker.setPolicy(nil, nil)
+
+	if !blocker.blocks(network.ResourceTypeImage, "https://example.com/logo.png") {
+		t.Errorf("expected images to be blocked by default")
+	}
+	if blocker.blocks(network.ResourceTypeFont, "https://example.com/font.woff") {
+		t.Errorf("expected fonts not to be blocked by default")
+	}
+}
@@ -0,0 +1,367 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"buchhalter/lib/parser"
+
+	cu "github.com/Davincible/chromedp-undetected"
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+)
+
+// browserStateTimeout limits saving and restoring the browser state on restarts
+const browserStateTimeout = 60 * time.Second
+
+// readWebStorageScript returns the origin and the items of the local and session storage of the current page
+const readWebStorageScript = `(() => {
+	const read = (storage) => {
+		const items = {};
+		for (let i = 0; i < storage.length; i++) {
+			const key = storage.key(i);
+			items[key] = storage.getItem(key);
+		}
+		return items;
+	};
+	try {
+		return {origin: location.origin, local: read(localStorage), session: read(sessionStorage)};
+	} catch (e) {
+		return {origin: location.origin, local: {}, session: {}};
+	}
+})()`
+
+// chromeInstance is a Chrome browser launched for a recipe. Long recipes may run in several instances one after the
+// other (see BrowserDriver.RestartAfterSteps).
+type chromeInstance struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	startedAt time.Time
+	// steps run in the instance
+	steps int
+}
+
+// webStorage are the items of the local and session storage of an origin.
+type webStorage struct {
+	Origin  string            `json:"origin"`
+	Local   map[string]string `json:"local"`
+	Session map[string]string `json:"session"`
+}
+
+// browserState is handed over from a Chrome instance to the next one on restarts, so the steps continue where they left off.
+type browserState struct {
+	url        string
+	cookies    []*network.Cookie
+	webStorage webStorage
+}
+
+// chromeFlags are the flags Chrome is launched with for recipe.
+func (b *BrowserDriver) chromeFlags(recipe *parser.Recipe) []chromedp.ExecAllocatorOption {
+	// Docs: https://github.com/GoogleChrome/chrome-launcher/blob/main/docs/chrome-flags-for-tools.md
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.Flag("disable-search-engine-choice-screen", true),
+		chromedp.Flag("enable-automation", false),
+		chromedp.Flag("headless", false),
+	)
+	if b.ChromePath != "" {
+		opts = append(opts, chromedp.ExecPath(b.ChromePath))
+	}
+	opts = append(opts, localeChromeFlags(recipe.Locale)...)
+	if b.MemoryLimit > 0 {
+		opts = append(opts, memoryLimitChromeFlags(b.MemoryLimit)...)
+	}
+	if b.DevToolsPort != 0 {
+		opts = append(opts, devToolsChromeFlags(b.DevToolsPort)...)
+	}
+	return opts
+}
+
+// memoryLimitChromeFlags limit the JavaScript heap of each page to memoryLimit MB. Pages exceeding it crash instead of
+// growing further, the step fails then.
+func memoryLimitChromeFlags(memoryLimit int) []chromedp.ExecAllocatorOption {
+	return []chromedp.ExecAllocatorOption{
+		chromedp.Flag("js-flags", "--max-old-space-size="+strconv.Itoa(memoryLimit)),
+	}
+}
+
+// startChrome launches Chrome for recipe and prepares it for the steps: downloads, lifecycle events, resource
+// blocking and the recordings.
+func (b *BrowserDriver) startChrome(recipe *parser.Recipe) error {
+	if b.RemoteDebuggingURL != "" {
+		b.logger.Info("Connecting to remote chrome browser ...", "supplier", recipe.Supplier, "recipe_version", recipe.Version, "host", remoteDebuggingHost(b.RemoteDebuggingURL))
+		ctx, cancel := b.connectRemoteChrome()
+		return b.useChrome(ctx, cancel, recipe)
+	}
+	b.logger.Info("Starting chrome browser driver ...", "supplier", recipe.Supplier, "recipe_version", recipe.Version)
+
+	// Chrome ends with the context of the recipe, its deadline is the safety net against infinite wait loops
+	chromeOptions := []cu.Option{
+		cu.WithContext(b.browserCtx),
+	}
+	if b.DevToolsPort != 0 {
+		chromeOptions = append(chromeOptions, cu.WithPort(b.DevToolsPort))
+	}
+	chromeOptions = append(chromeOptions, cu.WithChromeFlags(b.chromeFlags(recipe)...))
+
+	ctx, cancel, err := cu.New(cu.NewConfig(chromeOptions...))
+	if err != nil {
+		return err
+	}
+	return b.useChrome(ctx, cancel, recipe)
+}
+
+// useChrome makes the Chrome of ctx the current instance and prepares it for the steps.
+func (b *BrowserDriver) useChrome(ctx context.Context, cancel context.CancelFunc, recipe *parser.Recipe) error {
+	b.chromeMu.Lock()
+	// Chrome is stopped early if a step hangs (see OnStepHung) or on restarts
+	b.chrome = &chromeInstance{ctx: ctx, cancel: sync.OnceFunc(cancel), startedAt: time.Now()}
+	b.chromeMu.Unlock()
+
+	err := b.prepareChrome(ctx, recipe)
+	if err != nil {
+		b.stopChrome()
+		return err
+	}
+	return nil
+}
+
+func (b *BrowserDriver) prepareChrome(ctx context.Context, recipe *parser.Recipe) error {
+	// Requests are paused once the fetch domain is enabled, the listener continues them
+	chromedp.ListenTarget(ctx, b.blockResources(ctx))
+	if b.RemoteDebuggingURL != "" {
+		err := b.prepareRemoteChrome(ctx, recipe.Locale)
+		if err != nil {
+			return err
+		}
+	}
+
+	// get chrome version for metrics
+	if b.ChromeVersion == "" {
+		err := chromedp.Run(ctx, chromedp.Tasks{
+			chromedp.Navigate("chrome://version"),
+			chromedp.Text(`#version`, &b.ChromeVersion, chromedp.NodeVisible),
+		})
+		if err != nil {
+			return err
+		}
+		b.ChromeVersion = strings.TrimSpace(b.ChromeVersion)
+	}
+	b.logger.Info("Starting chrome browser driver ... completed ", "supplier", recipe.Supplier, "recipe_version", recipe.Version, "chrome_version", b.ChromeVersion)
+	if b.DevToolsPort != 0 {
+		b.announceDevTools(ctx, b.program, recipe.Supplier)
+	}
+
+	if b.RemoteDebuggingURL == "" {
+		// Lifecycle events are needed to wait for navigations (see runAndWaitForNavigation)
+		err := chromedp.Run(ctx, chromedp.Tasks{
+			browser.
+				SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllow).
+				WithDownloadPath(b.downloadsDirectory).
+				WithEventsEnabled(true),
+			b.enableLifeCycleEvents(),
+			fetch.Enable(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.FixtureRecorder != nil {
+		err := b.recordNetwork(ctx)
+		if err != nil {
+			b.logger.Error("Error recording network traffic for fixture", "supplier", recipe.Supplier, "error", err)
+		}
+	}
+
+	if b.screencast != nil {
+		err := b.recordScreencast(ctx, b.screencast)
+		if err != nil {
+			b.logger.Error("Error starting screencast for video", "supplier", recipe.Supplier, "error", err)
+		}
+	}
+	return nil
+}
+
+// stopChrome stops the current Chrome instance. It is safe to call it several times and concurrently to a step.
+func (b *BrowserDriver) stopChrome() {
+	b.chromeMu.Lock()
+	chrome := b.chrome
+	b.chromeMu.Unlock()
+	if chrome != nil {
+		chrome.cancel()
+	}
+}
+
+// chromeContext returns the context of the current Chrome instance, it is done once the instance is stopped.
+func (b *BrowserDriver) chromeContext() context.Context {
+	b.chromeMu.Lock()
+	defer b.chromeMu.Unlock()
+	return b.chrome.ctx
+}
+
+// chromeStepContext returns the context to run the chromedp calls of a step in: the current Chrome instance with the
+// deadline and cancellation of stepCtx.
+func (b *BrowserDriver) chromeStepContext(stepCtx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancelDeadline := b.chromeContext(), context.CancelFunc(func() {})
+	if deadline, ok := stepCtx.Deadline(); ok {
+		ctx, cancelDeadline = context.WithDeadline(ctx, deadline)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(stepCtx, func() {
+		cancel(context.Cause(stepCtx))
+	})
+	return ctx, func() {
+		stop()
+		cancel(nil)
+		cancelDeadline()
+	}
+}
+
+// restartChromeIfDue replaces Chrome by a new instance once it ran RestartAfterSteps steps or runs for RestartAfter.
+// The cookies, the web storage and the page are handed over, so the steps continue where they left off.
+func (b *BrowserDriver) restartChromeIfDue(recipe *parser.Recipe) error {
+	b.chromeMu.Lock()
+	chrome := b.chrome
+	b.chromeMu.Unlock()
+
+	dueBySteps := b.RestartAfterSteps > 0 && chrome.steps >= b.RestartAfterSteps
+	dueByTime := b.RestartAfter > 0 && time.Since(chrome.startedAt) >= b.RestartAfter
+	if !dueBySteps && !dueByTime {
+		chrome.steps++
+		return nil
+	}
+
+	b.logger.Info("Restarting chrome browser ...", "supplier", recipe.Supplier, "steps", chrome.steps, "uptime", time.Since(chrome.startedAt).Round(time.Second))
+	state, err := b.saveBrowserState(chrome.ctx)
+	if err != nil {
+		return fmt.Errorf("error saving browser state for chrome restart: %w", err)
+	}
+	b.stopChrome()
+	err = b.startChrome(recipe)
+	if err != nil {
+		return fmt.Errorf("error restarting chrome: %w", err)
+	}
+	err = b.restoreBrowserState(b.chromeContext(), state)
+	if err != nil {
+		return fmt.Errorf("error restoring browser state after chrome restart: %w", err)
+	}
+	b.logger.Info("Restarting chrome browser ... completed", "supplier", recipe.Supplier, "url", state.url, "cookies", len(state.cookies))
+
+	b.chromeMu.Lock()
+	b.chrome.steps++
+	b.chromeMu.Unlock()
+	return nil
+}
+
+// saveBrowserState reads the page, the cookies and the web storage of the page of a Chrome instance.
+func (b *BrowserDriver) saveBrowserState(ctx context.Context) (browserState, error) {
+	ctx, cancel := context.WithTimeout(ctx, browserStateTimeout)
+	defer cancel()
+
+	var state browserState
+	err := chromedp.Run(ctx,
+		chromedp.Location(&state.url),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			state.cookies, err = storage.GetCookies().Do(ctx)
+			return err
+		}),
+		chromedp.Evaluate(readWebStorageScript, &state.webStorage),
+	)
+	return state, err
+}
+
+// restoreBrowserState sets the cookies of state and opens its page. The web storage is restored before the scripts
+// of the page run.
+func (b *BrowserDriver) restoreBrowserState(ctx context.Context, state browserState) error {
+	ctx, cancel := context.WithTimeout(ctx, browserStateTimeout)
+	defer cancel()
+
+	err := chromedp.Run(ctx, storage.SetCookies(cookieParams(state.cookies)))
+	if err != nil {
+		return err
+	}
+	if state.url == "" || strings.HasPrefix(state.url, "about:") || strings.HasPrefix(state.url, "chrome:") {
+		return nil
+	}
+
+	script, err := writeWebStorageScript(state.webStorage)
+	if err != nil {
+		return err
+	}
+	var scriptId page.ScriptIdentifier
+	err = chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		scriptId, err = page.AddScriptToEvaluateOnNewDocument(script).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return err
+	}
+
+	// The page is opened with a GET request, pages showing the result of a form submission may differ
+	err = b.runAndWaitForNavigation(ctx, parser.Step{}, chromedp.Navigate(state.url))
+	if errors.Is(err, errNavigationTimeout) {
+		b.logger.Warn("Page did not finish loading in time after chrome restart, continuing", "url", state.url, "error", err)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return chromedp.Run(ctx, page.RemoveScriptToEvaluateOnNewDocument(scriptId))
+}
+
+// writeWebStorageScript returns a script restoring the web storage of its origin.
+func writeWebStorageScript(storage webStorage) (string, error) {
+	items, err := json.Marshal(storage)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`(() => {
+	const state = %s;
+	if (location.origin !== state.origin) {
+		return;
+	}
+	try {
+		Object.entries(state.local || {}).forEach(([key, value]) => localStorage.setItem(key, value));
+		Object.entries(state.session || {}).forEach(([key, value]) => sessionStorage.setItem(key, value));
+	} catch (e) {}
+})()`, items), nil
+}
+
+// cookieParams converts cookies read from Chrome into cookies to set. Session cookies stay session cookies.
+func cookieParams(cookies []*network.Cookie) []*network.CookieParam {
+	params := make([]*network.CookieParam, 0, len(cookies))
+	for _, c := range cookies {
+		param := &network.CookieParam{
+			Name:         c.Name,
+			Value:        c.Value,
+			Domain:       c.Domain,
+			Path:         c.Path,
+			Secure:       c.Secure,
+			HTTPOnly:     c.HTTPOnly,
+			SameSite:     c.SameSite,
+			Priority:     c.Priority,
+			SourceScheme: c.SourceScheme,
+			SourcePort:   c.SourcePort,
+			PartitionKey: c.PartitionKey,
+		}
+		if !c.Session {
+			expires := cdp.TimeSinceEpoch(time.Unix(0, int64(c.Expires*float64(time.Second))))
+			param.Expires = &expires
+		}
+		params = append(params, param)
+	}
+	return params
+}
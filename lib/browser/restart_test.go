@@ -0,0 +1,45 @@
+package browser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestCookieParams(t *testing.T) {
+	expires := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	params := cookieParams([]*network.Cookie{
+		{Name: "session", Value: "s3cr3t", Domain: "accounts.hetzner.com", Path: "/", HTTPOnly: true, Secure: true, Session: true, Expires: -1, SameSite: network.CookieSameSiteLax},
+		{Name: "consent", Value: "yes", Domain: ".hetzner.com", Path: "/", Expires: float64(expires.Unix())},
+	})
+
+	if len(params) != 2 {
+		t.Fatalf("expected 2 cookies, got %d", len(params))
+	}
+	session := params[0]
+	if session.Name != "session" || session.Domain != "accounts.hetzner.com" || !session.HTTPOnly || !session.Secure || session.SameSite != network.CookieSameSiteLax {
+		t.Errorf("unexpected session cookie %+v", session)
+	}
+	if session.Expires != nil {
+		t.Errorf("expected a session cookie without expiry, got %s", session.Expires.Time())
+	}
+	if params[1].Expires == nil || !params[1].Expires.Time().Equal(expires) {
+		t.Errorf("expected the cookie to expire at %s, got %v", expires, params[1].Expires)
+	}
+}
+
+func TestWriteWebStorageScript(t *testing.T) {
+	script, err := writeWebStorageScript(webStorage{
+		Origin:  "https://accounts.hetzner.com",
+		Local:   map[string]string{"token": `"quoted"`},
+		Session: map[string]string{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, `"origin":"https://accounts.hetzner.com"`) || !strings.Contains(script, `"token":"\"quoted\""`) {
+		t.Errorf("expected the storage as JSON in the script, got %s", script)
+	}
+}
@@ -0,0 +1,160 @@
+package browser
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"buchhalter/lib/parser"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// maxScreencastFrames limits the memory used by the frames of a single recipe run
+	maxScreencastFrames = 3000
+	// lastScreencastFrameDelay is the time the last frame of a video is shown, in 1/100 seconds
+	lastScreencastFrameDelay = 300
+)
+
+// screencastFrame is a JPEG encoded frame of a screencast and the time it was rendered at.
+type screencastFrame struct {
+	data       []byte
+	renderedAt time.Time
+}
+
+// screencast collects the frames of a recipe run.
+type screencast struct {
+	mu     sync.Mutex
+	frames []screencastFrame
+}
+
+func (s *screencast) add(frame screencastFrame) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.frames) >= maxScreencastFrames {
+		return false
+	}
+	s.frames = append(s.frames, frame)
+	return true
+}
+
+func (s *screencast) snapshot() []screencastFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]screencastFrame(nil), s.frames...)
+}
+
+// recordScreencast starts a screencast of the page into s. Chrome only sends frames if the page changes.
+func (b *BrowserDriver) recordScreencast(ctx context.Context, s *screencast) error {
+	chromedp.ListenTarget(ctx, func(event interface{}) {
+		ev, ok := event.(*page.EventScreencastFrame)
+		if !ok {
+			return
+		}
+		data, err := base64.StdEncoding.DecodeString(ev.Data)
+		if err == nil && !s.add(screencastFrame{data: data, renderedAt: time.Now()}) {
+			// Chrome sends no further frames without an acknowledgement
+			return
+		}
+
+		// Events are handled sequentially, the acknowledgement must not block them
+		go func() {
+			c := chromedp.FromContext(ctx)
+			ctx := cdp.WithExecutor(ctx, c.Target)
+			err := page.ScreencastFrameAck(ev.SessionID).Do(ctx)
+			if err != nil {
+				b.logger.Debug("Failed to acknowledge screencast frame", "error", err.Error())
+			}
+		}()
+	})
+
+	return chromedp.Run(ctx, page.StartScreencast().
+		WithFormat(page.ScreencastFormatJpeg).
+		WithQuality(60).
+		WithMaxWidth(1280).
+		WithMaxHeight(960))
+}
+
+// saveScreencast stops the screencast and stores it as animated GIF in the video directory.
+// The video shows the pages as rendered, incl. account data, so it should be reviewed before it is shared.
+func (b *BrowserDriver) saveScreencast(ctx context.Context, s *screencast, recipe *parser.Recipe) {
+	// Don't let a broken page block the shutdown of the recipe
+	stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	_ = chromedp.Run(stopCtx, page.StopScreencast())
+	cancel()
+
+	frames := s.snapshot()
+	if len(frames) == 0 {
+		b.logger.Warn("No screencast frames recorded, not saving video", "supplier", recipe.Supplier)
+		return
+	}
+	if len(frames) >= maxScreencastFrames {
+		b.logger.Warn("Screencast frame limit reached, the video is incomplete", "supplier", recipe.Supplier, "max_frames", maxScreencastFrames)
+	}
+
+	err := os.MkdirAll(b.VideoDirectory, 0700)
+	if err != nil {
+		b.logger.Error("Error creating video directory", "directory", b.VideoDirectory, "error", err)
+		return
+	}
+	videoFile := filepath.Join(b.VideoDirectory, fmt.Sprintf("%s-%s.gif", recipe.Supplier, time.Now().Format("20060102-150405")))
+	b.logger.Info("Saving video of recipe run ...", "supplier", recipe.Supplier, "frames", len(frames), "file", videoFile)
+
+	f, err := os.OpenFile(videoFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		b.logger.Error("Error creating video file", "file", videoFile, "error", err)
+		return
+	}
+	defer f.Close()
+
+	err = encodeScreencastGIF(f, frames)
+	if err != nil {
+		b.logger.Error("Error encoding video", "file", videoFile, "error", err)
+		return
+	}
+	b.logger.Info("Saving video of recipe run ... completed", "supplier", recipe.Supplier, "file", videoFile)
+}
+
+// encodeScreencastGIF writes frames as animated GIF to w. Each frame is shown until the next frame was rendered.
+func encodeScreencastGIF(w io.Writer, frames []screencastFrame) error {
+	animation := &gif.GIF{}
+	for i, frame := range frames {
+		img, _, err := image.Decode(bytes.NewReader(frame.data))
+		if err != nil {
+			return fmt.Errorf("error decoding frame %d: %w", i, err)
+		}
+
+		// Frames start at the origin, the viewport may change its size during the run
+		bounds := image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+		paletted := image.NewPaletted(bounds, palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, bounds, img, img.Bounds().Min)
+
+		delay := lastScreencastFrameDelay
+		if i+1 < len(frames) {
+			delay = max(1, int(frames[i+1].renderedAt.Sub(frame.renderedAt)/(10*time.Millisecond)))
+		}
+
+		animation.Image = append(animation.Image, paletted)
+		animation.Delay = append(animation.Delay, delay)
+		animation.Config.Width = max(animation.Config.Width, bounds.Dx())
+		animation.Config.Height = max(animation.Config.Height, bounds.Dy())
+	}
+	animation.Config.ColorModel = color.Palette(palette.Plan9)
+
+	return gif.EncodeAll(w, animation)
+}
@@ -0,0 +1,66 @@
+package browser
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+func jpegFrame(t *testing.T, width, height int, c color.Color, renderedAt time.Time) screencastFrame {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return screencastFrame{data: b.Bytes(), renderedAt: renderedAt}
+}
+
+func TestEncodeScreencastGIF(t *testing.T) {
+	start := time.Now()
+	frames := []screencastFrame{
+		jpegFrame(t, 40, 30, color.White, start),
+		jpegFrame(t, 40, 30, color.Black, start.Add(500*time.Millisecond)),
+		// The viewport was resized
+		jpegFrame(t, 60, 20, color.White, start.Add(2*time.Second)),
+	}
+
+	var b bytes.Buffer
+	if err := encodeScreencastGIF(&b, frames); err != nil {
+		t.Fatal(err)
+	}
+
+	animation, err := gif.DecodeAll(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(animation.Image) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(animation.Image))
+	}
+	if animation.Config.Width != 60 || animation.Config.Height != 30 {
+		t.Errorf("expected a size of 60x30, got %dx%d", animation.Config.Width, animation.Config.Height)
+	}
+	expectedDelays := []int{50, 150, lastScreencastFrameDelay}
+	for i, delay := range expectedDelays {
+		if animation.Delay[i] != delay {
+			t.Errorf("expected a delay of %d for frame %d, got %d", delay, i, animation.Delay[i])
+		}
+	}
+}
+
+func TestEncodeScreencastGIFInvalidFrame(t *testing.T) {
+	var b bytes.Buffer
+	err := encodeScreencastGIF(&b, []screencastFrame{{data: []byte("no image"), renderedAt: time.Now()}})
+	if err == nil {
+		t.Error("expected an error for an invalid frame")
+	}
+}
@@ -1,9 +0,0 @@
-package browser
-
-import (
-	"github.com/charmbracelet/lipgloss"
-)
-
-var (
-	textStyleBold = lipgloss.NewStyle().Bold(true).Render
-)
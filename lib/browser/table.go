@@ -0,0 +1,157 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/utils"
+
+	"github.com/chromedp/chromedp"
+)
+
+// scrapedTable is the content of an HTML table: the texts of the header cells and, per row, the texts of the cells and
+// the (absolute) targets of the links.
+type scrapedTable struct {
+	Headers []string `json:"headers"`
+	Rows    []struct {
+		Cells []string `json:"cells"`
+		Links []string `json:"links"`
+	} `json:"rows"`
+}
+
+// tableRow is a document listed by a scraped table.
+type tableRow struct {
+	metadata archive.Metadata
+	links    []string
+}
+
+// stepExtractTable scrapes the document listing table matched by the CSS selector. The metadata of its rows is stored
+// with the documents moved into the archive later on (see documentMetadata).
+func (b *BrowserDriver) stepExtractTable(ctx context.Context, step parser.Step) utils.StepResult {
+	b.logger.Debug("Executing recipe step", "action", step.Action, "selector", step.Selector, "columns", step.Table.Columns)
+
+	selector, err := json.Marshal(step.Selector)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+	script := `(() => {
+	const table = document.querySelector(` + string(selector) + `);
+	if (!table) {
+		throw new Error('table ' + ` + string(selector) + ` + ' not found');
+	}
+	const text = (cell) => cell.innerText.trim();
+	const rows = Array.from(table.querySelectorAll('tr'));
+	const headerRow = rows.find((row) => row.querySelector('th') && !row.querySelector('td'));
+	return {
+		headers: headerRow ? Array.from(headerRow.querySelectorAll('th')).map(text) : [],
+		rows: rows.filter((row) => row.querySelector('td')).map((row) => ({
+			cells: Array.from(row.querySelectorAll('th, td')).map(text),
+			links: Array.from(row.querySelectorAll('a[href]')).map((a) => a.href),
+		})),
+	};
+})()`
+
+	var table scrapedTable
+	if err := chromedp.Run(ctx, chromedp.Evaluate(script, &table)); err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+	rows, err := tableRows(table, step.Table.Columns)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error()}
+	}
+	b.tableRows = append(b.tableRows, rows...)
+	b.logger.Info("Document table extracted", "selector", step.Selector, "num_rows", len(rows))
+
+	return utils.StepResult{Status: "success"}
+}
+
+// tableRows maps the cells of the scraped table to the metadata fields of columns. Rows without metadata are skipped.
+func tableRows(table scrapedTable, columns map[string]string) ([]tableRow, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("extractTable step without columns")
+	}
+	indexes := map[string]int{}
+	for field, column := range columns {
+		switch field {
+		case "invoiceNumber", "date", "amount", "vatRate":
+		default:
+			return nil, fmt.Errorf("unknown metadata field %s (expected invoiceNumber, date, amount or vatRate)", field)
+		}
+		index, err := columnIndex(table.Headers, column)
+		if err != nil {
+			return nil, err
+		}
+		indexes[field] = index
+	}
+
+	var rows []tableRow
+	for _, r := range table.Rows {
+		cell := func(field string) string {
+			index, ok := indexes[field]
+			if !ok || index >= len(r.Cells) {
+				return ""
+			}
+			return r.Cells[index]
+		}
+		row := tableRow{
+			metadata: archive.Metadata{InvoiceNumber: cell("invoiceNumber"), Date: cell("date"), Amount: cell("amount"), VatRate: cell("vatRate")},
+			links:    r.Links,
+		}
+		if !row.metadata.IsEmpty() {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// columnIndex returns the index of a column given by its position (starting at 1) or the text of its header (case-insensitive).
+func columnIndex(headers []string, column string) (int, error) {
+	if position, err := strconv.Atoi(column); err == nil {
+		if position < 1 {
+			return 0, fmt.Errorf("invalid column position %d", position)
+		}
+		return position - 1, nil
+	}
+	for i, header := range headers {
+		if strings.EqualFold(strings.TrimSpace(header), strings.TrimSpace(column)) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column %s not found in the table header", column)
+}
+
+// documentMetadata returns the metadata of the row listing the downloaded file: the row linking the url the file was
+// downloaded from or, e.g. for files extracted from archives, the row with the (longest) invoice number contained in the
+// file name.
+func documentMetadata(rows []tableRow, fileName, downloadUrl string) (archive.Metadata, bool) {
+	if downloadUrl != "" {
+		for _, row := range rows {
+			for _, link := range row.links {
+				if link == downloadUrl {
+					return row.metadata, true
+				}
+			}
+		}
+	}
+
+	var candidates []tableRow
+	for _, row := range rows {
+		if row.metadata.InvoiceNumber != "" && strings.Contains(strings.ToLower(fileName), strings.ToLower(row.metadata.InvoiceNumber)) {
+			candidates = append(candidates, row)
+		}
+	}
+	if len(candidates) == 0 {
+		return archive.Metadata{}, false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i].metadata.InvoiceNumber) > len(candidates[j].metadata.InvoiceNumber)
+	})
+	return candidates[0].metadata, true
+}
@@ -0,0 +1,75 @@
+package browser
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"buchhalter/lib/archive"
+)
+
+func TestTableRows(t *testing.T) {
+	var table scrapedTable
+	err := json.Unmarshal([]byte(`{
+	"headers": ["Rechnung", "Datum", "Betrag", ""],
+	"rows": [
+		{"cells": ["R-1001", "31.03.2024", "119,00 €", "PDF"], "links": ["https://example.com/invoices/1001.pdf"]},
+		{"cells": ["", "", "", ""], "links": []},
+		{"cells": ["R-1002", "30.04.2024"], "links": []}
+	]
+}`), &table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := tableRows(table, map[string]string{"invoiceNumber": "rechnung", "date": "2", "amount": "Betrag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []tableRow{
+		{metadata: archive.Metadata{InvoiceNumber: "R-1001", Date: "31.03.2024", Amount: "119,00 €"}, links: []string{"https://example.com/invoices/1001.pdf"}},
+		{metadata: archive.Metadata{InvoiceNumber: "R-1002", Date: "30.04.2024"}, links: []string{}},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected rows %+v, got %+v", expected, rows)
+	}
+
+	for _, columns := range []map[string]string{
+		nil,
+		{"total": "Betrag"},
+		{"amount": "Summe"},
+		{"amount": "0"},
+	} {
+		if _, err := tableRows(table, columns); err == nil {
+			t.Errorf("expected an error for columns %v", columns)
+		}
+	}
+}
+
+func TestDocumentMetadata(t *testing.T) {
+	rows := []tableRow{
+		{metadata: archive.Metadata{InvoiceNumber: "R-100", Amount: "10,00 €"}, links: []string{"https://example.com/download?id=a"}},
+		{metadata: archive.Metadata{InvoiceNumber: "R-1001", Amount: "20,00 €"}, links: []string{"https://example.com/download?id=b"}},
+	}
+
+	tests := []struct {
+		name        string
+		fileName    string
+		downloadUrl string
+		amount      string
+		ok          bool
+	}{
+		{"by link", "download.pdf", "https://example.com/download?id=b", "20,00 €", true},
+		{"by invoice number", "invoice-r-100.pdf", "", "10,00 €", true},
+		{"by longest invoice number", "Invoice-R-1001.pdf", "https://example.com/other", "20,00 €", true},
+		{"unknown", "invoice.pdf", "https://example.com/other", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, ok := documentMetadata(rows, test.fileName, test.downloadUrl)
+			if ok != test.ok || metadata.Amount != test.amount {
+				t.Errorf("expected amount %q (%t), got %q (%t)", test.amount, test.ok, metadata.Amount, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Package control exposes a running sync over a local Unix socket.
+//
+// Connected clients (e.g. GUI front-ends or editor integrations) receive progress events
+// as newline delimited JSON and can send commands to pause, resume, skip suppliers or abort the run.
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	COMMAND_PAUSE  = "pause"
+	COMMAND_RESUME = "resume"
+	COMMAND_SKIP   = "skip"
+	COMMAND_ABORT  = "abort"
+
+	EVENT_STATUS          = "status"
+	EVENT_PROGRESS        = "progress"
+	EVENT_SUPPLIER_RESULT = "supplierResult"
+	EVENT_ACK             = "ack"
+	EVENT_ERROR           = "error"
+	// EVENT_COMPLETED is sent once the run is completed
+	EVENT_COMPLETED = "completed"
+)
+
+const (
+	// clientQueueSize is the number of events queued for a client. Clients falling further behind are disconnected.
+	clientQueueSize    = 256
+	clientWriteTimeout = 5 * time.Second
+)
+
+// Event is sent to all connected clients.
+type Event struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Supplier    string    `json:"supplier,omitempty"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Percent     float64   `json:"percent,omitempty"`
+	NewFiles    int       `json:"newFiles,omitempty"`
+	Duration    float64   `json:"duration,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	// Remaining is the estimated remaining time of the run in seconds, SupplierRemaining the one of the running supplier
+	Remaining         float64 `json:"remaining,omitempty"`
+	SupplierRemaining float64 `json:"supplierRemaining,omitempty"`
+}
+
+// Command is sent by a client.
+// Supplier is only used by the skip command. Without a supplier the currently running one is skipped.
+type Command struct {
+	Command  string `json:"command"`
+	Supplier string `json:"supplier,omitempty"`
+}
+
+// Server streams events to and receives commands from clients connected to the control socket.
+// All methods can be called on a nil server, which makes the control socket optional for callers.
+type Server struct {
+	logger   *slog.Logger
+	listener net.Listener
+	mutex    sync.Mutex
+	resumed  *sync.Cond
+
+	socketPath string
+	// clients are the connected clients with the queue of their writer (see writeEvents)
+	clients         map[net.Conn]chan []byte
+	paused          bool
+	aborted         bool
+	skipSuppliers   map[string]bool
+	currentSupplier string
+	cancelCurrent   context.CancelFunc
+}
+
+// NewServer listens on socketPath. A stale socket file of a previous run is removed.
+func NewServer(logger *slog.Logger, socketPath string) (*Server, error) {
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	// Only the current user may control the run
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s := &Server{
+		logger:        logger,
+		listener:      listener,
+		socketPath:    socketPath,
+		clients:       make(map[net.Conn]chan []byte),
+		skipSuppliers: make(map[string]bool),
+	}
+	s.resumed = sync.NewCond(&s.mutex)
+	go s.acceptClients()
+
+	logger.Info("Control socket listening", "socket", socketPath)
+	return s, nil
+}
+
+// Publish queues event for all connected clients. It doesn't block, slow clients are disconnected.
+func (s *Server) Publish(event Event) {
+	if s == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		s.logger.Error("Error encoding control event", "type", event.Type, "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn, queue := range s.clients {
+		select {
+		case queue <- line:
+		default:
+			s.logger.Warn("Disconnecting control client not reading its events")
+			s.removeClientLocked(conn)
+		}
+	}
+}
+
+// StartSupplier registers supplier as the currently running one.
+// It blocks while the run is paused and returns false if the supplier should be skipped or the run was aborted.
+// cancel is called if the supplier is skipped while it is running.
+func (s *Server) StartSupplier(supplier string, cancel context.CancelFunc) bool {
+	if s == nil {
+		return true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.paused && !s.aborted {
+		s.resumed.Wait()
+	}
+	if s.aborted || s.skipSuppliers[supplier] {
+		return false
+	}
+	s.currentSupplier = supplier
+	s.cancelCurrent = cancel
+	return true
+}
+
+// FinishSupplier unregisters the currently running supplier.
+func (s *Server) FinishSupplier() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.currentSupplier = ""
+	s.cancelCurrent = nil
+}
+
+// Aborted returns true if a client aborted the run.
+func (s *Server) Aborted() bool {
+	if s == nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.aborted
+}
+
+// SocketPath returns the path of the control socket.
+func (s *Server) SocketPath() string {
+	if s == nil {
+		return ""
+	}
+	return s.socketPath
+}
+
+// Close stops listening, disconnects all clients and removes the socket file.
+func (s *Server) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	for conn := range s.clients {
+		s.removeClientLocked(conn)
+	}
+	s.aborted = true
+	s.resumed.Broadcast()
+	s.mutex.Unlock()
+
+	err := s.listener.Close()
+	_ = os.Remove(s.socketPath)
+	return err
+}
+
+func (s *Server) acceptClients() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			// The listener is closed
+			return
+		}
+
+		queue := make(chan []byte, clientQueueSize)
+		s.mutex.Lock()
+		s.clients[conn] = queue
+		s.mutex.Unlock()
+		s.logger.Info("Control client connected")
+
+		go s.writeEvents(conn, queue)
+		go s.readCommands(conn)
+	}
+}
+
+// writeEvents writes the queued events to conn until the client is removed.
+// Writes happen outside of the mutex, so a client blocking its socket doesn't block the run.
+func (s *Server) writeEvents(conn net.Conn, queue chan []byte) {
+	for line := range queue {
+		_ = conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := conn.Write(line); err != nil {
+			s.logger.Info("Control client disconnected", "error", err)
+			s.removeClient(conn)
+			return
+		}
+	}
+}
+
+func (s *Server) removeClient(conn net.Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.removeClientLocked(conn)
+}
+
+// removeClientLocked closes conn and the queue of its writer. The caller has to hold the mutex.
+func (s *Server) removeClientLocked(conn net.Conn) {
+	queue, ok := s.clients[conn]
+	if !ok {
+		return
+	}
+	delete(s.clients, conn)
+	close(queue)
+	conn.Close()
+}
+
+func (s *Server) readCommands(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var command Command
+		if err := json.Unmarshal(scanner.Bytes(), &command); err != nil {
+			s.Publish(Event{Type: EVENT_ERROR, Error: "invalid command: " + err.Error()})
+			continue
+		}
+
+		if err := s.handleCommand(command); err != nil {
+			s.Publish(Event{Type: EVENT_ERROR, Title: command.Command, Supplier: command.Supplier, Error: err.Error()})
+			continue
+		}
+		s.Publish(Event{Type: EVENT_ACK, Title: command.Command, Supplier: command.Supplier})
+	}
+
+	s.removeClient(conn)
+}
+
+func (s *Server) handleCommand(command Command) error {
+	s.logger.Info("Received control command", "command", command.Command, "supplier", command.Supplier)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch command.Command {
+	case COMMAND_PAUSE:
+		// A running supplier is finished before the run pauses
+		s.paused = true
+	case COMMAND_RESUME:
+		s.paused = false
+		s.resumed.Broadcast()
+	case COMMAND_SKIP:
+		supplier := command.Supplier
+		if supplier == "" {
+			supplier = s.currentSupplier
+		}
+		if supplier == "" {
+			return errors.New("no supplier is running")
+		}
+		s.skipSuppliers[supplier] = true
+		if supplier == s.currentSupplier && s.cancelCurrent != nil {
+			s.cancelCurrent()
+		}
+	case COMMAND_ABORT:
+		s.aborted = true
+		if s.cancelCurrent != nil {
+			s.cancelCurrent()
+		}
+		s.resumed.Broadcast()
+	default:
+		return errors.New("unknown command: " + command.Command)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSkipCommand(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+	server, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(`{"command":"skip","supplier":"hetzner"}` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event Event
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(line, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EVENT_ACK || event.Supplier != "hetzner" {
+		t.Errorf("expected ack for hetzner, got %+v", event)
+	}
+
+	if server.StartSupplier("hetzner", func() {}) {
+		t.Error("expected skipped supplier not to start")
+	}
+	if !server.StartSupplier("digitalocean", func() {}) {
+		t.Error("expected other supplier to start")
+	}
+}
+
+func TestPublishDisconnectsSlowClient(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+	server, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// The client never reads its events
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for server.clientCount() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	published := make(chan bool)
+	go func() {
+		for i := 0; i < 10*clientQueueSize; i++ {
+			server.Publish(Event{Type: EVENT_PROGRESS, Description: strings.Repeat("x", 4096)})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Publish not to block on a slow client")
+	}
+
+	if server.clientCount() != 0 {
+		t.Error("expected the slow client to be disconnected")
+	}
+	if !server.StartSupplier("hetzner", func() {}) {
+		t.Error("expected the supplier to start")
+	}
+}
+
+func (s *Server) clientCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.clients)
+}
@@ -0,0 +1,211 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// STATUS_FILE_NAME is the name of the status file in the buchhalter directory
+const STATUS_FILE_NAME = "_status.json"
+
+// Status is the live status of a sync run or of the REST API daemon (`buchhalter serve`).
+type Status struct {
+	PID           int       `json:"pid"`
+	Daemon        bool      `json:"daemon"`
+	ControlSocket string    `json:"controlSocket,omitempty"`
+	Running       bool      `json:"running"`
+	StartedAt     time.Time `json:"startedAt,omitempty"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	Supplier      string    `json:"supplier,omitempty"`
+	Step          string    `json:"step,omitempty"`
+	Percent       float64   `json:"percent,omitempty"`
+	// Queue contains the suppliers that haven't been started yet
+	Queue []string `json:"queue,omitempty"`
+	// EstimatedEndAt is the estimated end of the run, based on the step durations of previous runs
+	EstimatedEndAt time.Time `json:"estimatedEndAt,omitempty"`
+	NextRunAt      time.Time `json:"nextRunAt,omitempty"`
+}
+
+// StatusFile persists the status of the current process, so `buchhalter status` can show it.
+// The file is written on every change and removed on Close. Errors writing the file are logged only, as the status is
+// informational.
+// All methods can be called on a nil status file, like on a nil Server.
+type StatusFile struct {
+	logger *slog.Logger
+	path   string
+	mutex  sync.Mutex
+	status Status
+}
+
+// NewStatusFile writes the initial status of the current process to path.
+func NewStatusFile(logger *slog.Logger, path string, daemon bool, controlSocket string) (*StatusFile, error) {
+	f := &StatusFile{
+		logger: logger,
+		path:   path,
+		status: Status{
+			PID:           os.Getpid(),
+			Daemon:        daemon,
+			ControlSocket: controlSocket,
+		},
+	}
+	return f, f.write()
+}
+
+// ReadStatus returns the status of a running process, nil if no process is running.
+// Status files of processes that didn't shut down properly (e.g. crashed) are ignored.
+func ReadStatus(path string) (*Status, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status Status
+	err = json.Unmarshal(content, &status)
+	if err != nil {
+		return nil, err
+	}
+	if !processRunning(status.PID) {
+		return nil, nil
+	}
+	return &status, nil
+}
+
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil || pid <= 0 {
+		return false
+	}
+	// Signal 0 only checks the existence of the process, Windows doesn't support it (FindProcess fails there instead)
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM) || runtime.GOOS == "windows"
+}
+
+// StartRun marks a run as running.
+func (f *StatusFile) StartRun() {
+	f.update(func(s *Status) {
+		s.Running = true
+		s.StartedAt = time.Now()
+		s.Supplier = ""
+		s.Step = ""
+		s.Percent = 0
+		s.Queue = nil
+		s.EstimatedEndAt = time.Time{}
+	})
+}
+
+// SetQueue sets the suppliers of the run.
+func (f *StatusFile) SetQueue(suppliers []string) {
+	f.update(func(s *Status) {
+		s.Queue = suppliers
+	})
+}
+
+// StartSupplier marks supplier as the currently running one and removes it from the queue.
+func (f *StatusFile) StartSupplier(supplier string) {
+	f.update(func(s *Status) {
+		s.Supplier = supplier
+		s.Step = ""
+		queue := make([]string, 0, len(s.Queue))
+		for _, queued := range s.Queue {
+			if queued != supplier {
+				queue = append(queue, queued)
+			}
+		}
+		s.Queue = queue
+	})
+}
+
+// Publish updates the current step, progress or estimated end with a status or progress event.
+func (f *StatusFile) Publish(event Event) {
+	switch event.Type {
+	case EVENT_STATUS:
+		f.update(func(s *Status) {
+			s.Step = event.Title
+			if event.Description != "" {
+				s.Step += " " + event.Description
+			}
+			setEstimatedEnd(s, event)
+		})
+	case EVENT_PROGRESS:
+		f.update(func(s *Status) {
+			s.Percent = event.Percent
+			setEstimatedEnd(s, event)
+		})
+	}
+}
+
+func setEstimatedEnd(s *Status, event Event) {
+	if event.Remaining > 0 {
+		s.EstimatedEndAt = time.Now().Add(time.Duration(event.Remaining * float64(time.Second)))
+	}
+}
+
+// FinishRun marks the run as completed.
+func (f *StatusFile) FinishRun() {
+	f.update(func(s *Status) {
+		s.Running = false
+		s.Supplier = ""
+		s.Step = ""
+		s.Percent = 0
+		s.Queue = nil
+		s.EstimatedEndAt = time.Time{}
+	})
+}
+
+// SetNextRun sets the time of the next scheduled run.
+func (f *StatusFile) SetNextRun(next time.Time) {
+	f.update(func(s *Status) {
+		s.NextRunAt = next
+	})
+}
+
+// Close removes the status file.
+func (f *StatusFile) Close() error {
+	if f == nil {
+		return nil
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	err := os.Remove(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f *StatusFile) update(change func(s *Status)) {
+	if f == nil {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	change(&f.status)
+	if err := f.write(); err != nil {
+		f.logger.Error("Error writing status file", "file", f.path, "error", err)
+	}
+}
+
+// write replaces the status file atomically, as it is read by other processes
+func (f *StatusFile) write() error {
+	f.status.UpdatedAt = time.Now()
+	content, err := json.Marshal(f.status)
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	err = os.WriteFile(tmp, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
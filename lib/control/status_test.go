@@ -0,0 +1,74 @@
+package control
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), STATUS_FILE_NAME)
+	statusFile, err := NewStatusFile(slog.New(slog.NewTextHandler(io.Discard, nil)), path, false, "/tmp/buchhalter.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statusFile.StartRun()
+	statusFile.SetQueue([]string{"hetzner", "digitalocean"})
+	statusFile.StartSupplier("hetzner")
+	statusFile.Publish(Event{Type: EVENT_STATUS, Title: "Step 2/5:", Description: "Open invoice list"})
+	statusFile.Publish(Event{Type: EVENT_PROGRESS, Percent: 0.4, Remaining: 90})
+
+	status, err := ReadStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || !status.Running || status.Supplier != "hetzner" || status.Step != "Step 2/5: Open invoice list" || status.Percent != 0.4 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if len(status.Queue) != 1 || status.Queue[0] != "digitalocean" {
+		t.Errorf("expected the remaining supplier in the queue, got %v", status.Queue)
+	}
+	if remaining := time.Until(status.EstimatedEndAt); remaining < 80*time.Second || remaining > 90*time.Second {
+		t.Errorf("expected the run to end in 90 seconds, got %s", remaining)
+	}
+
+	statusFile.FinishRun()
+	if status, _ = ReadStatus(path); status == nil || status.Running || status.Supplier != "" || !status.EstimatedEndAt.IsZero() {
+		t.Errorf("expected an idle status, got %+v", status)
+	}
+
+	if err = statusFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = ReadStatus(path); status != nil || err != nil {
+		t.Errorf("expected no status after closing, got %+v (%v)", status, err)
+	}
+}
+
+func TestReadStatusIgnoresStaleStatusFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), STATUS_FILE_NAME)
+	// PIDs are limited to 2^22 on Linux
+	content, _ := json.Marshal(Status{PID: 1 << 30, Running: true})
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := ReadStatus(path)
+	if status != nil || err != nil {
+		t.Errorf("expected no status of a crashed process, got %+v (%v)", status, err)
+	}
+}
+
+func TestNilStatusFile(t *testing.T) {
+	var statusFile *StatusFile
+	statusFile.StartRun()
+	statusFile.Publish(Event{Type: EVENT_STATUS})
+	if statusFile.Close() != nil {
+		t.Error("expected a nil status file to be a no-op")
+	}
+}
@@ -0,0 +1,87 @@
+// Package crash writes sanitized reports of unexpected errors (panics), so they can be submitted to the Buchhalter
+// Platform with the consent of the user.
+package crash
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"buchhalter/lib/redact"
+)
+
+// DIRECTORY_NAME is the directory below the buchhalter directory crash reports are written to
+const DIRECTORY_NAME = "crash-reports"
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// queryPattern matches the query of URLs, which often carries session ids or one-time codes
+	queryPattern = regexp.MustCompile(`(https?://[^\s?"']+)\?[^\s"']*`)
+)
+
+// Report describes a crash. It contains neither documents nor credentials, only the sanitized panic message and stack.
+type Report struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	CliVersion string    `json:"cliVersion"`
+	GoVersion  string    `json:"goVersion"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	// Command is the command path (e.g. "buchhalter sync") without arguments, as they may contain supplier names or paths
+	Command string `json:"command"`
+	Message string `json:"message"`
+	Stack   string `json:"stack"`
+}
+
+// NewReport creates the report of the panic value with the stack of the panicking goroutine.
+func NewReport(value any, stack []byte, command, cliVersion string) Report {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	homeDirectory, _ := os.UserHomeDir()
+
+	return Report{
+		ID:         hex.EncodeToString(id),
+		CreatedAt:  time.Now(),
+		CliVersion: cliVersion,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Command:    command,
+		Message:    Sanitize(fmt.Sprint(value), homeDirectory),
+		Stack:      Sanitize(string(stack), homeDirectory),
+	}
+}
+
+// Sanitize removes registered secrets (see redact.AddSecrets), email addresses, URL queries and the home directory of
+// the user from s.
+func Sanitize(s, homeDirectory string) string {
+	s = redact.String(s)
+	s = emailPattern.ReplaceAllString(s, redact.Mask)
+	s = queryPattern.ReplaceAllString(s, "$1?"+redact.Mask)
+	if len(homeDirectory) > 1 {
+		s = strings.ReplaceAll(s, homeDirectory, "~")
+	}
+	return s
+}
+
+// Write stores the report as JSON file in directory and returns its path.
+func (r Report) Write(directory string) (string, error) {
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		return "", err
+	}
+	fileContent, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	filePath := filepath.Join(directory, fmt.Sprintf("crash-%s-%s.json", r.CreatedAt.Format("20060102-150405"), r.ID))
+	return filePath, os.WriteFile(filePath, fileContent, 0600)
+}
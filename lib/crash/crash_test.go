@@ -0,0 +1,51 @@
+package crash
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"buchhalter/lib/redact"
+)
+
+func TestSanitize(t *testing.T) {
+	redact.AddSecrets("hunter22")
+
+	sanitized := Sanitize(`login of jane.doe@example.com with hunter22 failed at https://portal.example.com/login?session=abc123&otp=42 (/home/jane/.buchhalter/documents)`, "/home/jane")
+	expected := `login of [REDACTED] with [REDACTED] failed at https://portal.example.com/login?[REDACTED] (~/.buchhalter/documents)`
+	if sanitized != expected {
+		t.Errorf("unexpected sanitized message %q", sanitized)
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	report := NewReport(errors.New("index out of range"), []byte("goroutine 1 [running]:\nmain.main()"), "buchhalter sync", "1.2.3")
+	if report.ID == "" || report.Command != "buchhalter sync" || report.Message != "index out of range" || !strings.HasPrefix(report.Stack, "goroutine 1") {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	directory := filepath.Join(t.TempDir(), DIRECTORY_NAME)
+	filePath, err := report.Write(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the report to be readable by the user only, got %s", info.Mode().Perm())
+	}
+
+	fileContent, _ := os.ReadFile(filePath)
+	var written Report
+	if err := json.Unmarshal(fileContent, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.ID != report.ID || written.Stack != report.Stack {
+		t.Errorf("unexpected written report %+v", written)
+	}
+}
@@ -0,0 +1,580 @@
+package devserver
+
+// Fake supplier portal to test drivers and recipe step actions end-to-end without a real supplier.
+// It provides a login form with 2FA, an invoice list with downloads and an OAuth2 identity provider with an API.
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"buchhalter/lib/parser"
+	"buchhalter/lib/utils"
+)
+
+const (
+	sessionCookieName = "devserver_session"
+	tokenExpiresIn    = 3600
+)
+
+type Invoice struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Date     string `json:"date"`
+	Amount   string `json:"amount"`
+}
+
+// DefaultInvoices are the invoices served by the devserver.
+var DefaultInvoices = []Invoice{
+	{ID: "2024-001", Filename: "invoice-2024-001.pdf", Date: "2024-01-31", Amount: "12.00 EUR"},
+	{ID: "2024-002", Filename: "invoice-2024-002.pdf", Date: "2024-02-29", Amount: "12.00 EUR"},
+	{ID: "2024-003", Filename: "invoice-2024-003.pdf", Date: "2024-03-31", Amount: "14.50 EUR"},
+}
+
+type authorizationCode struct {
+	clientId            string
+	redirectUri         string
+	codeChallenge       string
+	codeChallengeMethod string
+}
+
+type Server struct {
+	logger *slog.Logger
+
+	username string
+	password string
+	// totp is the expected 2FA code. Without a code, 2FA is disabled.
+	totp     string
+	invoices []Invoice
+
+	mu sync.Mutex
+	// sessions of the portal, true if 2FA is completed
+	sessions           map[string]bool
+	authorizationCodes map[string]authorizationCode
+	accessTokens       map[string]time.Time
+	refreshTokens      map[string]bool
+}
+
+func NewServer(logger *slog.Logger, username, password, totp string, invoices []Invoice) *Server {
+	return &Server{
+		logger: logger,
+
+		username: username,
+		password: password,
+		totp:     totp,
+		invoices: invoices,
+
+		sessions:           map[string]bool{},
+		authorizationCodes: map[string]authorizationCode{},
+		accessTokens:       map[string]time.Time{},
+		refreshTokens:      map[string]bool{},
+	}
+}
+
+// Handler returns the http handler of the fake supplier portal.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Portal
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	})
+	mux.HandleFunc("GET /login", s.handleLoginForm)
+	mux.HandleFunc("POST /login", s.handleLogin)
+	mux.HandleFunc("GET /2fa", s.handleTotpForm)
+	mux.HandleFunc("POST /2fa", s.handleTotp)
+	mux.HandleFunc("GET /invoices", s.handleInvoices)
+	mux.HandleFunc("GET /invoices/{id}/download", s.handleInvoiceDownload)
+
+	// OAuth2 identity provider and API
+	mux.HandleFunc("GET /oauth/authorize", s.handleAuthorizeForm)
+	mux.HandleFunc("POST /oauth/authorize", s.handleAuthorize)
+	mux.HandleFunc("POST /oauth/token", s.handleToken)
+	mux.HandleFunc("POST /api/invoices", s.handleAPIInvoices)
+	mux.HandleFunc("GET /api/invoices/{id}", s.handleAPIInvoiceDownload)
+
+	// Example recipes for this server
+	mux.HandleFunc("GET /recipes/{type}", s.handleRecipe)
+
+	return logRequests(s.logger, mux)
+}
+
+func logRequests(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("Devserver request", "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{ .Title }} - Buchhalter Devserver</title></head>
+<body>
+<h1>{{ .Title }}</h1>
+{{ if .Error }}<p class="error" id="error">{{ .Error }}</p>{{ end }}
+{{ .Content }}
+</body>
+</html>
+`))
+
+func renderPage(w http.ResponseWriter, status int, title, errorMessage string, content template.HTML) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = pageTemplate.Execute(w, struct {
+		Title   string
+		Error   string
+		Content template.HTML
+	}{title, errorMessage, content})
+}
+
+func (s *Server) handleLoginForm(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, http.StatusOK, "Login", "", loginForm)
+}
+
+const loginForm = template.HTML(`<form id="login-form" method="post" action="/login">
+<label for="username">Username</label> <input type="text" id="username" name="username">
+<label for="password">Password</label> <input type="password" id="password" name="password">
+<button type="submit" id="login-submit">Login</button>
+</form>`)
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("username") != s.username || r.FormValue("password") != s.password {
+		renderPage(w, http.StatusUnauthorized, "Login", "Invalid username or password", loginForm)
+		return
+	}
+
+	sessionId := utils.RandomString(32)
+	s.mu.Lock()
+	s.sessions[sessionId] = s.totp == ""
+	s.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: sessionId, Path: "/", HttpOnly: true})
+
+	if s.totp != "" {
+		http.Redirect(w, r, "/2fa", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/invoices", http.StatusFound)
+}
+
+const totpForm = template.HTML(`<form id="totp-form" method="post" action="/2fa">
+<label for="totp">One-time password</label> <input type="text" id="totp" name="totp" autocomplete="one-time-code">
+<button type="submit" id="totp-submit">Verify</button>
+</form>`)
+
+func (s *Server) handleTotpForm(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.session(r); !ok {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	renderPage(w, http.StatusOK, "Two-factor authentication", "", totpForm)
+}
+
+func (s *Server) handleTotp(w http.ResponseWriter, r *http.Request) {
+	sessionId, ok := s.session(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if r.FormValue("totp") != s.totp {
+		renderPage(w, http.StatusUnauthorized, "Two-factor authentication", "Invalid one-time password", totpForm)
+		return
+	}
+
+	s.mu.Lock()
+	s.sessions[sessionId] = true
+	s.mu.Unlock()
+	http.Redirect(w, r, "/invoices", http.StatusFound)
+}
+
+// session returns the session id of the request, if the session exists.
+func (s *Server) session(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.sessions[cookie.Value]
+	return cookie.Value, ok
+}
+
+// loggedIn returns true if the request belongs to a session with completed 2FA.
+func (s *Server) loggedIn(r *http.Request) bool {
+	sessionId, ok := s.session(r)
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[sessionId]
+}
+
+func (s *Server) handleInvoices(w http.ResponseWriter, r *http.Request) {
+	if !s.loggedIn(r) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	var rows strings.Builder
+	for _, invoice := range s.invoices {
+		fmt.Fprintf(&rows, `<tr class="invoice"><td>%s</td><td>%s</td><td>%s</td><td><a class="download" href="/invoices/%s/download">Download</a></td></tr>`,
+			template.HTMLEscapeString(invoice.ID), template.HTMLEscapeString(invoice.Date), template.HTMLEscapeString(invoice.Amount), url.PathEscape(invoice.ID))
+		rows.WriteString("\n")
+	}
+	renderPage(w, http.StatusOK, "Invoices", "", template.HTML(`<table id="invoices">
+<tr><th>Number</th><th>Date</th><th>Amount</th><th></th></tr>
+`+rows.String()+`</table>`))
+}
+
+func (s *Server) handleInvoiceDownload(w http.ResponseWriter, r *http.Request) {
+	if !s.loggedIn(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.writeInvoice(w, r.PathValue("id"))
+}
+
+func (s *Server) writeInvoice(w http.ResponseWriter, id string) {
+	for _, invoice := range s.invoices {
+		if invoice.ID == id {
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, invoice.Filename))
+			_, _ = w.Write(InvoicePDF(invoice))
+			return
+		}
+	}
+	http.NotFound(w, nil)
+}
+
+// InvoicePDF returns a minimal PDF document of invoice.
+func InvoicePDF(invoice Invoice) []byte {
+	text := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(fmt.Sprintf("Invoice %s from %s: %s", invoice.ID, invoice.Date, invoice.Amount))
+	stream := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+
+	var pdf strings.Builder
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return []byte(pdf.String())
+}
+
+// authorizeParameters are passed through all pages of the OAuth2 login
+var authorizeParameters = []string{"client_id", "redirect_uri", "state", "code_challenge", "code_challenge_method"}
+
+// handleAuthorizeForm renders the login of the identity provider.
+// The element ids match the ones the OAuth2 client driver expects.
+func (s *Server) handleAuthorizeForm(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("redirect_uri") == "" {
+		http.Error(w, "missing redirect_uri", http.StatusBadRequest)
+		return
+	}
+	s.renderAuthorizeStep(w, r.URL.Query(), "identity", "")
+}
+
+func (s *Server) renderAuthorizeStep(w http.ResponseWriter, params url.Values, step, errorMessage string) {
+	var hidden strings.Builder
+	for _, name := range append(authorizeParameters, "identity") {
+		fmt.Fprintf(&hidden, `<input type="hidden" name="%s" value="%s">`, name, template.HTMLEscapeString(params.Get(name)))
+	}
+	fmt.Fprintf(&hidden, `<input type="hidden" name="step" value="%s">`, step)
+
+	var fields string
+	switch step {
+	case "identity":
+		fields = `<input type="text" id="form-input-identity" name="value"> <button type="submit" id="form-submit-continue">Continue</button>`
+	case "credential":
+		fields = `<input type="password" id="form-input-credential" name="value"> <button type="submit" id="form-submit-continue">Continue</button>`
+	case "passcode":
+		fields = `<input type="text" id="form-input-passcode" name="value"> <button type="submit" id="form-submit">Verify</button>`
+	}
+
+	status := http.StatusOK
+	if errorMessage != "" {
+		status = http.StatusUnauthorized
+	}
+	renderPage(w, status, "Sign in", errorMessage, template.HTML(`<form method="post" action="/oauth/authorize">`+hidden.String()+fields+`</form>`))
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := r.PostForm
+	value := params.Get("value")
+
+	switch params.Get("step") {
+	case "identity":
+		params.Set("identity", value)
+		s.renderAuthorizeStep(w, params, "credential", "")
+		return
+	case "credential":
+		if params.Get("identity") != s.username || value != s.password {
+			s.renderAuthorizeStep(w, params, "identity", "Invalid username or password")
+			return
+		}
+		if s.totp != "" {
+			s.renderAuthorizeStep(w, params, "passcode", "")
+			return
+		}
+	case "passcode":
+		// The password was checked on the page before, the identity is passed along
+		if params.Get("identity") != s.username || value != s.totp {
+			s.renderAuthorizeStep(w, params, "passcode", "Invalid one-time password")
+			return
+		}
+	default:
+		http.Error(w, "unknown step", http.StatusBadRequest)
+		return
+	}
+
+	code := utils.RandomString(32)
+	s.mu.Lock()
+	s.authorizationCodes[code] = authorizationCode{
+		clientId:            params.Get("client_id"),
+		redirectUri:         params.Get("redirect_uri"),
+		codeChallenge:       params.Get("code_challenge"),
+		codeChallengeMethod: params.Get("code_challenge_method"),
+	}
+	s.mu.Unlock()
+
+	redirectUrl, err := url.Parse(params.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	query := redirectUrl.Query()
+	query.Set("code", code)
+	query.Set("state", params.Get("state"))
+	redirectUrl.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectUrl.String(), http.StatusFound)
+}
+
+// tokenRequestParameters reads the parameters of a token request, sent as JSON or form encoded.
+func tokenRequestParameters(r *http.Request) (map[string]string, error) {
+	params := map[string]string{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&params)
+		return params, err
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return params, err
+	}
+	for name := range r.PostForm {
+		params[name] = r.PostForm.Get(name)
+	}
+	return params, nil
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	params, err := tokenRequestParameters(r)
+	if err != nil {
+		writeOauth2Error(w, "invalid_request", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch params["grant_type"] {
+	case "authorization_code":
+		code, ok := s.authorizationCodes[params["code"]]
+		if !ok {
+			writeOauth2Error(w, "invalid_grant", "unknown authorization code")
+			return
+		}
+		// Authorization codes can only be used once
+		delete(s.authorizationCodes, params["code"])
+		if code.redirectUri != params["redirect_uri"] || code.clientId != params["client_id"] {
+			writeOauth2Error(w, "invalid_grant", "redirect_uri or client_id does not match")
+			return
+		}
+		if !verifyPkce(code.codeChallenge, code.codeChallengeMethod, params["code_verifier"]) {
+			writeOauth2Error(w, "invalid_grant", "invalid code_verifier")
+			return
+		}
+	case "refresh_token":
+		if !s.refreshTokens[params["refresh_token"]] {
+			writeOauth2Error(w, "invalid_grant", "unknown refresh token")
+			return
+		}
+		// Refresh tokens are rotated
+		delete(s.refreshTokens, params["refresh_token"])
+	default:
+		writeOauth2Error(w, "unsupported_grant_type", params["grant_type"])
+		return
+	}
+
+	accessToken := utils.RandomString(40)
+	refreshToken := utils.RandomString(40)
+	s.accessTokens[accessToken] = time.Now().Add(tokenExpiresIn * time.Second)
+	s.refreshTokens[refreshToken] = true
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    tokenExpiresIn,
+		"created_at":    time.Now().Unix(),
+	})
+}
+
+func verifyPkce(challenge, method, verifier string) bool {
+	if challenge == "" {
+		return true
+	}
+	if strings.EqualFold(method, "S256") {
+		hash := sha256.Sum256([]byte(verifier))
+		return base64.RawURLEncoding.EncodeToString(hash[:]) == challenge
+	}
+	return verifier == challenge
+}
+
+func writeOauth2Error(w http.ResponseWriter, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+// authorized returns true if the request has a valid bearer token.
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt, ok := s.accessTokens[token]
+	return ok && time.Now().Before(expiresAt)
+}
+
+func (s *Server) handleAPIInvoices(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The API announces the checksums of the invoices, so clients can verify their downloads
+	type apiInvoice struct {
+		Invoice
+		SHA256 string `json:"sha256"`
+	}
+	invoices := make([]apiInvoice, 0, len(s.invoices))
+	for _, invoice := range s.invoices {
+		invoices = append(invoices, apiInvoice{Invoice: invoice, SHA256: fmt.Sprintf("%x", sha256.Sum256(InvoicePDF(invoice)))})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": invoices})
+}
+
+func (s *Server) handleAPIInvoiceDownload(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.writeInvoice(w, r.PathValue("id"))
+}
+
+// Recipes returns example recipes of both recipe types for a devserver reachable at baseURL.
+func Recipes(baseURL string) map[string]parser.Recipe {
+	host := strings.TrimPrefix(strings.TrimPrefix(baseURL, "http://"), "https://")
+
+	browserRecipe := parser.Recipe{
+		Supplier: "devserver",
+		Domains:  []string{host},
+		Version:  "1.0.0",
+		Type:     "browser",
+		Steps: []parser.Step{
+			{Action: "open", URL: baseURL + "/login", Description: "Open login page"},
+			{Action: "type", Selector: "#username", Value: "{{ username }}", Description: "Enter username"},
+			{Action: "type", Selector: "#password", Value: "{{ password }}", Description: "Enter password"},
+			{Action: "click", Selector: "#login-submit", Description: "Submit login form"},
+			{Action: "type", Selector: "#totp", Value: "{{ totp }}", Description: "Enter one-time password", Optional: true},
+			{Action: "click", Selector: "#totp-submit", Description: "Submit one-time password", Optional: true},
+			{Action: "open", URL: baseURL + "/invoices", Description: "Open invoice list"},
+			{Action: "downloadAll", Selector: "a.download", Description: "Download invoices"},
+			{Action: "move", Value: "^invoice-.*\\.pdf$", Description: "Move invoices to document archive"},
+		},
+	}
+
+	clientRecipe := parser.Recipe{
+		Supplier: "devserver-oauth2",
+		Domains:  []string{host},
+		Version:  "1.0.0",
+		Type:     "client",
+		Steps: []parser.Step{
+			{Action: "oauth2-setup", Description: "Set up OAuth2"},
+			{Action: "oauth2-check-tokens", Description: "Check cached tokens"},
+			{Action: "oauth2-authenticate", Description: "Log in"},
+			{
+				Action:                   "oauth2-post-and-get-items",
+				URL:                      baseURL + "/api/invoices",
+				Body:                     "{}",
+				Headers:                  map[string]string{"Authorization": "Bearer {{ token }}", "Content-Type": "application/json"},
+				ExtractDocumentIds:       "data.id",
+				ExtractDocumentFilenames: "data.filename",
+				ExtractDocumentChecksums: "data.sha256",
+				DocumentUrl:              baseURL + "/api/invoices/{{ id }}",
+				DocumentRequestMethod:    http.MethodGet,
+				DocumentRequestHeaders:   map[string]string{"Authorization": "Bearer {{ token }}"},
+				Description:              "Download invoices",
+			},
+		},
+	}
+	clientRecipe.Steps[0].Oauth2.AuthUrl = baseURL + "/oauth/authorize"
+	clientRecipe.Steps[0].Oauth2.TokenUrl = baseURL + "/oauth/token"
+	clientRecipe.Steps[0].Oauth2.RedirectUrl = baseURL + "/callback"
+	clientRecipe.Steps[0].Oauth2.ClientId = "buchhalter-devserver"
+	clientRecipe.Steps[0].Oauth2.Scope = "invoices"
+	clientRecipe.Steps[0].Oauth2.PkceMethod = "S256"
+	clientRecipe.Steps[0].Oauth2.PkceVerifierLength = 64
+
+	return map[string]parser.Recipe{
+		"browser": browserRecipe,
+		"client":  clientRecipe,
+	}
+}
+
+func (s *Server) handleRecipe(w http.ResponseWriter, r *http.Request) {
+	recipe, ok := Recipes("http://" + r.Host)[strings.TrimSuffix(r.PathValue("type"), ".json")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(recipe)
+}
@@ -0,0 +1,195 @@
+package devserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"buchhalter/lib/utils"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := httptest.NewServer(NewServer(logger, "user", "secret", "123456", DefaultInvoices).Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPortalLoginWith2FAAndDownload(t *testing.T) {
+	server := newTestServer(t)
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	resp, err := client.Get(server.URL + "/invoices/2024-001/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected download without login to be unauthorized, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.PostForm(server.URL+"/login", url.Values{"username": {"user"}, "password": {"wrong"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected wrong password to be rejected, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.PostForm(server.URL+"/login", url.Values{"username": {"user"}, "password": {"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/2fa" {
+		t.Fatalf("expected redirect to 2FA, got %s", resp.Request.URL.Path)
+	}
+
+	// The invoice list requires a completed 2FA
+	resp, err = client.Get(server.URL + "/invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/login" {
+		t.Fatalf("expected redirect to login before 2FA, got %s", resp.Request.URL.Path)
+	}
+
+	resp, err = client.PostForm(server.URL+"/2fa", url.Values{"totp": {"123456"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/invoices" || !strings.Contains(string(body), `href="/invoices/2024-003/download"`) {
+		t.Fatalf("expected invoice list after 2FA, got %s: %s", resp.Request.URL.Path, body)
+	}
+
+	resp, err = client.Get(server.URL + "/invoices/2024-001/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Disposition") != `attachment; filename="invoice-2024-001.pdf"` || !bytes.HasPrefix(body, []byte("%PDF-")) {
+		t.Fatalf("unexpected download: %s %q", resp.Header.Get("Content-Disposition"), body)
+	}
+}
+
+func TestOauth2AuthorizationCodeAndRefresh(t *testing.T) {
+	server := newTestServer(t)
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	verifier, challenge, err := utils.Oauth2Pkce(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirectUri := "http://localhost/callback"
+	authorize := func(step, value string, extra url.Values) *http.Response {
+		form := url.Values{
+			"client_id":             {"client"},
+			"redirect_uri":          {redirectUri},
+			"state":                 {"state"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+			"identity":              {"user"},
+			"step":                  {step},
+			"value":                 {value},
+		}
+		for name, values := range extra {
+			form[name] = values
+		}
+		resp, err := client.PostForm(server.URL+"/oauth/authorize", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := authorize("credential", "wrong", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected wrong password to be rejected, got %d", resp.StatusCode)
+	}
+	if resp := authorize("credential", "secret", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected passcode page, got %d", resp.StatusCode)
+	}
+	resp := authorize("passcode", "123456", nil)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect with code, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if location.Query().Get("state") != "state" || location.Query().Get("code") == "" {
+		t.Fatalf("unexpected redirect %s", location)
+	}
+
+	requestTokens := func(payload map[string]string) (*http.Response, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		resp, err := http.Post(server.URL+"/oauth/token", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var tokens map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&tokens)
+		return resp, tokens
+	}
+
+	codeRequest := map[string]string{
+		"grant_type":    "authorization_code",
+		"client_id":     "client",
+		"code_verifier": "wrong",
+		"code":          location.Query().Get("code"),
+		"redirect_uri":  redirectUri,
+	}
+	if resp, _ := requestTokens(codeRequest); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected wrong code verifier to be rejected, got %d", resp.StatusCode)
+	}
+
+	// Authorization codes are single use, even after a failed request
+	resp = authorize("passcode", "123456", nil)
+	location, _ = url.Parse(resp.Header.Get("Location"))
+	codeRequest["code"] = location.Query().Get("code")
+	codeRequest["code_verifier"] = verifier
+	resp, tokens := requestTokens(codeRequest)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected tokens, got %d %v", resp.StatusCode, tokens)
+	}
+
+	// Refresh tokens can be sent form encoded as well
+	resp, err = http.PostForm(server.URL+"/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens["refresh_token"].(string)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refreshed map[string]interface{}
+	_ = json.NewDecoder(resp.Body).Decode(&refreshed)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || refreshed["access_token"] == tokens["access_token"] {
+		t.Fatalf("expected new tokens, got %d %v", resp.StatusCode, refreshed)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/invoices", strings.NewReader("{}"))
+	req.Header.Set("Authorization", "Bearer "+refreshed["access_token"].(string))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var invoices struct {
+		Data []Invoice `json:"data"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&invoices)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(invoices.Data) != len(DefaultInvoices) {
+		t.Fatalf("expected invoice list, got %d %v", resp.StatusCode, invoices)
+	}
+}
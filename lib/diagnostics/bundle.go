@@ -0,0 +1,268 @@
+// Package diagnostics collects sanitized information about failed supplier runs to share them with maintainers.
+package diagnostics
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"buchhalter/lib/parser"
+	"buchhalter/lib/redact"
+)
+
+// maxLogLines is the number of log lines of the supplier (from the end of the log file) added to a bundle.
+const maxLogLines = 2000
+
+// BundleInfo describes the environment a debug bundle was created in.
+type BundleInfo struct {
+	Supplier      string    `json:"supplier"`
+	RecipeVersion string    `json:"recipeVersion"`
+	OicdbVersion  string    `json:"oicdbVersion"`
+	CliVersion    string    `json:"cliVersion"`
+	CliCommitHash string    `json:"cliCommitHash"`
+	ChromeVersion string    `json:"chromeVersion"`
+	OS            string    `json:"os"`
+	Arch          string    `json:"arch"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// BundleCreator writes zipped debug bundles for a single supplier.
+type BundleCreator struct {
+	logger *slog.Logger
+
+	logFile                 string
+	debugArtifactsDirectory string
+}
+
+func NewBundleCreator(logger *slog.Logger, logFile, debugArtifactsDirectory string) *BundleCreator {
+	return &BundleCreator{
+		logger:                  logger,
+		logFile:                 logFile,
+		debugArtifactsDirectory: debugArtifactsDirectory,
+	}
+}
+
+// CreateBundle writes a zip file to outputFile containing the environment info, the recipe,
+// the step timeline of the last run, the redacted log and the latest debug artifacts of the supplier.
+// The log only contains the lines of the supplier, so bundles don't disclose other suppliers.
+func (c *BundleCreator) CreateBundle(outputFile string, info BundleInfo, recipe *parser.Recipe) error {
+	c.logger.Info("Creating debug bundle ...", "supplier", info.Supplier, "file", outputFile)
+
+	logLines, err := c.readLogLines(info.Supplier)
+	if err != nil {
+		return fmt.Errorf("error reading log file %s: %w", c.logFile, err)
+	}
+
+	artifactsDirectory, err := c.latestArtifactsDirectory(info.Supplier)
+	if err != nil {
+		return fmt.Errorf("error reading debug artifacts: %w", err)
+	}
+	if artifactsDirectory != "" {
+		info.ChromeVersion = readChromeVersion(filepath.Join(artifactsDirectory, "metadata.json"))
+	}
+	info.OS = runtime.GOOS
+	info.Arch = runtime.GOARCH
+	info.CreatedAt = time.Now()
+
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	zipWriter := zip.NewWriter(out)
+
+	infoContent, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		"info.json":          infoContent,
+		"timeline.log":       []byte(strings.Join(stepTimeline(logLines, info.Supplier), "\n")),
+		"buchhalter-cli.log": []byte(strings.Join(logLines, "\n")),
+	}
+	if recipe != nil {
+		recipeContent, err := json.MarshalIndent(recipe, "", "  ")
+		if err != nil {
+			return err
+		}
+		files["recipe.json"] = recipeContent
+	}
+	for name, content := range files {
+		if err := writeZipFile(zipWriter, name, content); err != nil {
+			return err
+		}
+	}
+
+	// Debug artifacts are scrubbed when they are captured already
+	if artifactsDirectory != "" {
+		entries, err := os.ReadDir(artifactsDirectory)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			content, err := os.ReadFile(filepath.Join(artifactsDirectory, entry.Name()))
+			if err != nil {
+				return err
+			}
+			if err := writeZipFile(zipWriter, filepath.Join("artifacts", entry.Name()), content); err != nil {
+				return err
+			}
+		}
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("Creating debug bundle ... completed", "supplier", info.Supplier, "file", outputFile, "artifacts_directory", artifactsDirectory)
+	return nil
+}
+
+// readLogLines returns the last lines of the log file belonging to supplier, redacted again in case they were written
+// by an older version. Lines belong to supplier if their supplier attribute is supplier or, without a supplier
+// attribute, if they were written during a run of supplier.
+func (c *BundleCreator) readLogLines(supplier string) ([]string, error) {
+	f, err := os.Open(c.logFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	running := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineSupplier := logAttribute(line, "supplier")
+		if lineSupplier == supplier {
+			switch logAttribute(line, "msg") {
+			case "Downloading invoices ...":
+				running = true
+			case "Downloading invoices ... completed":
+				running = false
+			}
+		}
+		if lineSupplier != supplier && (lineSupplier != "" || !running) {
+			continue
+		}
+
+		lines = append(lines, redact.Text(line))
+		if len(lines) > maxLogLines {
+			lines = lines[1:]
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// latestArtifactsDirectory returns the directory of the most recent debug artifacts of supplier (empty if there are none).
+func (c *BundleCreator) latestArtifactsDirectory(supplier string) (string, error) {
+	supplierDirectory := filepath.Join(c.debugArtifactsDirectory, supplier)
+	entries, err := os.ReadDir(supplierDirectory)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var directories []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			directories = append(directories, entry.Name())
+		}
+	}
+	if len(directories) == 0 {
+		return "", nil
+	}
+
+	// Directory names are timestamps
+	sort.Strings(directories)
+	return filepath.Join(supplierDirectory, directories[len(directories)-1]), nil
+}
+
+// stepTimeline extracts the log lines of the last run of the supplier recipe.
+func stepTimeline(logLines []string, supplier string) []string {
+	startMarker := `msg="Downloading invoices ..." supplier=` + supplier + " "
+	endMarker := `msg="Downloading invoices ... completed" supplier=` + supplier + " "
+
+	start := -1
+	for i := len(logLines) - 1; i >= 0; i-- {
+		if strings.Contains(logLines[i], startMarker) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	for i := start; i < len(logLines); i++ {
+		if strings.Contains(logLines[i], endMarker) {
+			return logLines[start : i+1]
+		}
+	}
+	return logLines[start:]
+}
+
+// logAttribute returns the value of the attribute key of a line written by slog.TextHandler (empty if it has none).
+func logAttribute(line, key string) string {
+	var value string
+	if strings.HasPrefix(line, key+"=") {
+		value = line[len(key)+1:]
+	} else if i := strings.Index(line, " "+key+"="); i >= 0 {
+		value = line[i+len(key)+2:]
+	} else {
+		return ""
+	}
+
+	if strings.HasPrefix(value, `"`) {
+		quoted, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			return ""
+		}
+		unquoted, _ := strconv.Unquote(quoted)
+		return unquoted
+	}
+	value, _, _ = strings.Cut(value, " ")
+	return value
+}
+
+func readChromeVersion(metadataFile string) string {
+	content, err := os.ReadFile(metadataFile)
+	if err != nil {
+		return ""
+	}
+	var metadata struct {
+		ChromeVersion string `json:"chromeVersion"`
+	}
+	_ = json.Unmarshal(content, &metadata)
+	return metadata.ChromeVersion
+}
+
+func writeZipFile(zipWriter *zip.Writer, name string, content []byte) error {
+	w, err := zipWriter.Create(filepath.ToSlash(name))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
@@ -0,0 +1,103 @@
+package diagnostics
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"buchhalter/lib/parser"
+)
+
+func TestCreateBundle(t *testing.T) {
+	directory := t.TempDir()
+	logFile := filepath.Join(directory, "buchhalter-cli.log")
+	logLines := []string{
+		`time=2026-10-15T10:00:00.000Z level=INFO msg="Booting up" development_mode=false`,
+		`time=2026-10-15T10:00:01.000Z level=INFO msg="Downloading invoices ..." supplier=digitalocean supplier_type=browser archive=default`,
+		`time=2026-10-15T10:00:02.000Z level=INFO msg="Opening page" supplier=digitalocean url=https://cloud.digitalocean.com/login`,
+		`time=2026-10-15T10:00:03.000Z level=INFO msg="Downloading invoices ... completed" supplier=digitalocean supplier_type=browser duration=2s`,
+		`time=2026-10-15T10:00:04.000Z level=INFO msg="Downloading invoices ..." supplier=hetzner supplier_type=browser archive=default`,
+		`time=2026-10-15T10:00:05.000Z level=INFO msg="Typing credentials" supplier=hetzner password=s3cr3t-password`,
+		`time=2026-10-15T10:00:06.000Z level=INFO msg="HTTP request" url="https://accounts.hetzner.com/invoice?token=abc"`,
+		`time=2026-10-15T10:00:07.000Z level=ERROR msg="Recipe step failed" supplier=hetzner step=3 error="element not found"`,
+		`time=2026-10-15T10:00:08.000Z level=INFO msg="Downloading invoices ... completed" supplier=hetzner supplier_type=browser duration=4s`,
+		`time=2026-10-15T10:00:09.000Z level=INFO msg="Sending usage metrics to Buchhalter API"`,
+	}
+	if err := os.WriteFile(logFile, []byte(strings.Join(logLines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	debugArtifactsDirectory := filepath.Join(directory, "_debug")
+	artifactsDirectory := filepath.Join(debugArtifactsDirectory, "hetzner", "20261015-100007")
+	if err := os.MkdirAll(artifactsDirectory, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(artifactsDirectory, "metadata.json"), []byte(`{"chromeVersion":"131.0.6778.85"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	outputFile := filepath.Join(directory, "bundle.zip")
+	err := NewBundleCreator(logger, logFile, debugArtifactsDirectory).CreateBundle(outputFile, BundleInfo{Supplier: "hetzner"}, &parser.Recipe{Supplier: "hetzner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := readBundle(t, outputFile)
+	for _, name := range []string{"info.json", "timeline.log", "buchhalter-cli.log", "recipe.json", "artifacts/metadata.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("expected %s in the bundle", name)
+		}
+	}
+
+	var info BundleInfo
+	if err := json.Unmarshal([]byte(files["info.json"]), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ChromeVersion != "131.0.6778.85" {
+		t.Errorf("expected the chrome version of the artifacts, got %s", info.ChromeVersion)
+	}
+
+	log := files["buchhalter-cli.log"]
+	if strings.Contains(log, "digitalocean") || strings.Contains(log, "Booting up") || strings.Contains(log, "usage metrics") {
+		t.Errorf("expected only the lines of hetzner, got %s", log)
+	}
+	if !strings.Contains(log, "accounts.hetzner.com") || !strings.Contains(log, "element not found") {
+		t.Errorf("expected the lines of the hetzner run, got %s", log)
+	}
+	for name, content := range files {
+		if strings.Contains(content, "s3cr3t-password") || strings.Contains(content, "token=abc") {
+			t.Errorf("expected secrets to be redacted in %s, got %s", name, content)
+		}
+	}
+	if timeline := strings.Split(files["timeline.log"], "\n"); len(timeline) != 5 {
+		t.Errorf("expected the 5 lines of the hetzner run in the timeline, got %d", len(timeline))
+	}
+}
+
+func readBundle(t *testing.T, bundleFile string) map[string]string {
+	reader, err := zip.OpenReader(bundleFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	files := make(map[string]string)
+	for _, file := range reader.File {
+		in, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(in)
+		in.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = string(content)
+	}
+	return files
+}
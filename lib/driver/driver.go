@@ -0,0 +1,335 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var textStyleBold = lipgloss.NewStyle().Bold(true).Render
+
+// defaultHungStepGracePeriod is the time a step has to return after its timeout before it is killed
+const defaultHungStepGracePeriod = 10 * time.Second
+
+// stepOutcome is how a step of the engine ended
+type stepOutcome int
+
+const (
+	stepReturned stepOutcome = iota
+	stepTimedOut
+	// stepKilled didn't return after its timeout and was killed with OnStepHung
+	stepKilled
+	// stepAbandoned didn't return even after it was killed, its goroutine is left behind
+	stepAbandoned
+)
+
+// RecipeDriver runs the recipes of a recipe type (e.g. "browser" or "fints").
+type RecipeDriver interface {
+	RunRecipe(p *tea.Program, totalStepCount int, stepCountInCurrentRecipe int, baseCountStep int, recipe *parser.Recipe) utils.RecipeResult
+	// Quit releases the resources of the driver, e.g. the browser
+	Quit() error
+}
+
+// StepRunner executes the actions of the steps of a recipe, the Engine runs the recipe.
+type StepRunner interface {
+	// RunStep executes a single step. It has to return once ctx is done, ctx ends when the step times out.
+	// Steps that don't return in time (e.g. stuck in a call to a hung browser) are killed with Engine.OnStepHung.
+	RunStep(ctx context.Context, recipe *parser.Recipe, step parser.Step) utils.StepResult
+	// NewFilesCount is the number of documents archived so far
+	NewFilesCount() int
+}
+
+// Engine runs the steps of a recipe with a StepRunner. It reports the progress, enforces the step timeout, handles
+// optional steps and builds the recipe result, so drivers only implement the actions of their recipe type.
+type Engine struct {
+	logger *slog.Logger
+	runner StepRunner
+
+	// StepTimeout aborts steps running longer
+	StepTimeout time.Duration
+	// Title is shown while a step runs, it is formatted with the supplier, the number of the step and the step count
+	Title string
+	// AbortOnError aborts the recipe on every failed step, not only on steps that break
+	AbortOnError bool
+	// HungStepGracePeriod is the time a step has to return after its timeout, before OnStepHung is called, and again
+	// after OnStepHung, before the step is abandoned
+	HungStepGracePeriod time.Duration
+
+	// OnStepSucceeded is called after each successful step (optional)
+	OnStepSucceeded func(n int, step parser.Step)
+	// OnStepFailed is called when a step aborts the recipe or times out and returns screenshots of the failure (optional)
+	OnStepFailed func(n int, step parser.Step, message string) []string
+	// OnTimeout is called with the remaining steps when a step timed out and the recipe aborts (optional).
+	// It returns whether documents downloaded before the timeout were archived, the recipe is partially successful then.
+	OnTimeout func(remainingSteps []parser.Step) (bool, error)
+	// OnStepHung is called when step n didn't return within the grace period after its timeout (optional).
+	// It has to force the step to return, e.g. by killing the browser. The recipe aborts, the runner can't be used
+	// for further steps.
+	OnStepHung func(n int, step parser.Step)
+}
+
+func NewEngine(logger *slog.Logger, runner StepRunner, stepTimeout time.Duration) *Engine {
+	return &Engine{
+		logger:      logger,
+		runner:      runner,
+		StepTimeout: stepTimeout,
+		Title:       "Downloading invoices from %s (%d/%d):",
+
+		HungStepGracePeriod: defaultHungStepGracePeriod,
+	}
+}
+
+// Run executes the steps of recipe. The progress bar is advanced from baseCountStep of totalStepCount steps of the run.
+func (e *Engine) Run(ctx context.Context, p *tea.Program, totalStepCount int, stepCountInCurrentRecipe int, baseCountStep int, recipe *parser.Recipe) (result utils.RecipeResult) {
+	// Timings are collected for all executed steps, whatever the recipe result is
+	var stepTimings []utils.StepTiming
+	var warnings []string
+	defer func() {
+		result.StepTimings = stepTimings
+		applyStepWarnings(&result, warnings)
+	}()
+
+	for i, step := range recipe.Steps {
+		n := i + 1
+		p.Send(utils.ViewMsgStatusAndDescriptionUpdate{
+			Title:       i18n.Tf(e.Title, recipe.Supplier, n, stepCountInCurrentRecipe),
+			Description: step.Description,
+		})
+
+		stepStartTime := time.Now()
+		stepResult, outcome := e.runStep(ctx, recipe, step, n)
+		if outcome == stepKilled || outcome == stepAbandoned {
+			// The state of the runner is unknown, neither optional steps nor screenshots can continue with it
+			stepTimings = append(stepTimings, utils.StepTiming{Number: n, Action: step.Action, Description: step.Description, Status: "timeout", Duration: time.Since(stepStartTime), Artifacts: stepResult.Artifacts})
+			if outcome == stepAbandoned || ctx.Err() != nil {
+				return e.killedResult(recipe, n, step, stepResult.Message)
+			}
+			return e.timeoutResult(recipe, n, step)
+		}
+		if outcome == stepTimedOut {
+			stepTimings = append(stepTimings, utils.StepTiming{Number: n, Action: step.Action, Description: step.Description, Status: "timeout", Duration: time.Since(stepStartTime), Artifacts: stepResult.Artifacts})
+			if step.Optional {
+				e.logger.Warn("Optional recipe step timed out", "supplier", recipe.Supplier, "step", n, "action", step.Action)
+				warnings = append(warnings, fmt.Sprintf("Step %d (%s): timeout", n, step.Action))
+				e.sendProgress(p, baseCountStep, n, totalStepCount)
+				continue
+			}
+			if step.ContinueOnTimeout {
+				e.logger.Warn("Recipe step timed out, continuing with next step", "supplier", recipe.Supplier, "step", n, "action", step.Action)
+				warnings = append(warnings, fmt.Sprintf("Step %d (%s): timeout", n, step.Action))
+				e.sendProgress(p, baseCountStep, n, totalStepCount)
+				continue
+			}
+			// Screenshots scrub the inputs of the page, so they are only taken once the following steps don't need it
+			e.addScreenshots(stepTimings, n, step, "timeout")
+			return e.timeoutResult(recipe, n, step)
+		}
+
+		stepTimings = append(stepTimings, utils.StepTiming{Number: n, Action: step.Action, Description: step.Description, Status: stepResult.Status, Duration: time.Since(stepStartTime), Artifacts: stepResult.Artifacts})
+		// Cancelled runs abort, even in optional steps
+		if stepResult.Status != "success" && step.Optional && ctx.Err() == nil {
+			e.logger.Warn("Optional recipe step failed", "supplier", recipe.Supplier, "step", n, "action", step.Action, "error", stepResult.Message)
+			warnings = append(warnings, fmt.Sprintf("Step %d (%s): %s", n, step.Action, stepResult.Message))
+			stepResult.Status = "success"
+		}
+		result = utils.RecipeResult{
+			Status:              "success",
+			StatusText:          recipe.Supplier + ": " + NewDocumentsText(e.runner.NewFilesCount()),
+			StatusTextFormatted: "- " + textStyleBold(recipe.Supplier) + ": " + NewDocumentsText(e.runner.NewFilesCount()),
+			LastStepId:          fmt.Sprintf("%s-%s-%d-%s", recipe.Supplier, recipe.Version, n, step.Action),
+			LastStepDescription: step.Description,
+			NewFilesCount:       e.runner.NewFilesCount(),
+		}
+		if stepResult.Status == "success" {
+			if e.OnStepSucceeded != nil {
+				e.OnStepSucceeded(n, step)
+			}
+		} else {
+			result.Status = "error"
+			result.StatusText = recipe.Supplier + " aborted with error."
+			result.StatusTextFormatted = "x " + textStyleBold(recipe.Supplier) + " aborted with error."
+			result.LastErrorMessage = stepResult.Message
+			if stepResult.Break || e.AbortOnError {
+				e.addScreenshots(stepTimings, n, step, stepResult.Message)
+				return result
+			}
+		}
+
+		e.sendProgress(p, baseCountStep, n, totalStepCount)
+	}
+
+	return result
+}
+
+// runStep executes step with a context that ends after the step timeout. A step that doesn't return within the grace
+// period after its timeout is killed (see OnStepHung), its goroutine can't block the run.
+func (e *Engine) runStep(ctx context.Context, recipe *parser.Recipe, step parser.Step, n int) (utils.StepResult, stepOutcome) {
+	// The run was cancelled (e.g. on SIGINT or via the control socket) or the deadline of the recipe passed
+	if ctx.Err() != nil {
+		return utils.StepResult{Status: "error", Message: context.Cause(ctx).Error(), Break: true}, stepReturned
+	}
+	stepCtx, cancel := context.WithTimeout(ctx, e.StepTimeout)
+	defer cancel()
+
+	// Buffered, so the goroutine of a killed step ends whenever the step returns
+	results := make(chan utils.StepResult, 1)
+	go func() {
+		results <- e.runner.RunStep(stepCtx, recipe, step)
+	}()
+
+	var stepResult utils.StepResult
+	select {
+	case stepResult = <-results:
+	case <-stepCtx.Done():
+		var outcome stepOutcome
+		stepResult, outcome = e.awaitHungStep(ctx, results, recipe, n, step)
+		if outcome != stepReturned {
+			return stepResult, outcome
+		}
+	}
+	if ctx.Err() != nil && stepResult.Status != "success" {
+		return utils.StepResult{Status: "error", Message: context.Cause(ctx).Error(), Break: true, Artifacts: stepResult.Artifacts}, stepReturned
+	}
+	// Steps completing right at the timeout succeed nonetheless
+	if stepResult.Status != "success" && errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		return stepResult, stepTimedOut
+	}
+	return stepResult, stepReturned
+}
+
+// awaitHungStep waits for the result of step n after its context ended. Steps not returning within the grace period
+// are killed with OnStepHung and abandoned if they don't return after that either.
+func (e *Engine) awaitHungStep(ctx context.Context, results <-chan utils.StepResult, recipe *parser.Recipe, n int, step parser.Step) (utils.StepResult, stepOutcome) {
+	grace := time.NewTimer(e.HungStepGracePeriod)
+	defer grace.Stop()
+	select {
+	case stepResult := <-results:
+		return stepResult, stepReturned
+	case <-grace.C:
+	}
+
+	e.logger.Error("Recipe step didn't return after its timeout, killing it", "supplier", recipe.Supplier, "step", n, "action", step.Action, "grace_period", e.HungStepGracePeriod)
+	if e.OnStepHung != nil {
+		e.OnStepHung(n, step)
+	}
+	message := fmt.Sprintf("step killed after it didn't return within %s after its timeout", e.HungStepGracePeriod)
+	if ctx.Err() != nil {
+		message = context.Cause(ctx).Error()
+	}
+
+	grace.Reset(e.HungStepGracePeriod)
+	select {
+	case stepResult := <-results:
+		return utils.StepResult{Status: "error", Message: message, Break: true, Artifacts: stepResult.Artifacts}, stepKilled
+	case <-grace.C:
+		e.logger.Error("Recipe step didn't return after it was killed, abandoning it", "supplier", recipe.Supplier, "step", n, "action", step.Action)
+		return utils.StepResult{Status: "error", Message: message, Break: true}, stepAbandoned
+	}
+}
+
+// timeoutResult is the result of a recipe aborted by a timeout of step n.
+func (e *Engine) timeoutResult(recipe *parser.Recipe, n int, step parser.Step) utils.RecipeResult {
+	result := utils.RecipeResult{
+		Status:              "error",
+		StatusText:          recipe.Supplier + " aborted with timeout.",
+		StatusTextFormatted: "x " + textStyleBold(recipe.Supplier) + " aborted with timeout.",
+		LastStepId:          fmt.Sprintf("%s-%s-%d-%s", recipe.Supplier, recipe.Version, n, step.Action),
+		LastStepDescription: step.Description,
+		NewFilesCount:       e.runner.NewFilesCount(),
+	}
+
+	// Imagine we run the `downloadAll` step, we download 2 files and then the recipe times out.
+	// It is bad that the recipe timed out, however, we still want to process the 2 new downloaded documents.
+	archived := false
+	if e.OnTimeout != nil {
+		var err error
+		archived, err = e.OnTimeout(recipe.Steps[n:])
+		if err != nil {
+			e.logger.Error("Archiving documents downloaded before the timeout failed", "supplier", recipe.Supplier, "error", err)
+			return result
+		}
+	}
+	if archived || e.runner.NewFilesCount() > 0 {
+		partialText := NewDocumentsText(e.runner.NewFilesCount()) + " (aborted with timeout)"
+		result.Status = "partial"
+		result.StatusText = recipe.Supplier + ": " + partialText
+		result.StatusTextFormatted = "! " + textStyleBold(recipe.Supplier) + ": " + partialText
+		result.NewFilesCount = e.runner.NewFilesCount()
+	}
+	return result
+}
+
+// killedResult is the result of a recipe aborted by killing step n. Documents downloaded before aren't archived, as
+// the step may still be running.
+func (e *Engine) killedResult(recipe *parser.Recipe, n int, step parser.Step, message string) utils.RecipeResult {
+	return utils.RecipeResult{
+		Status:              "error",
+		StatusText:          recipe.Supplier + " aborted with error.",
+		StatusTextFormatted: "x " + textStyleBold(recipe.Supplier) + " aborted with error.",
+		LastStepId:          fmt.Sprintf("%s-%s-%d-%s", recipe.Supplier, recipe.Version, n, step.Action),
+		LastStepDescription: step.Description,
+		LastErrorMessage:    message,
+		NewFilesCount:       e.runner.NewFilesCount(),
+	}
+}
+
+// addScreenshots adds the screenshots of a failure of step n to its timing.
+func (e *Engine) addScreenshots(stepTimings []utils.StepTiming, n int, step parser.Step, message string) {
+	if e.OnStepFailed == nil {
+		return
+	}
+	stepTimings[len(stepTimings)-1].Artifacts.Screenshots = append(stepTimings[len(stepTimings)-1].Artifacts.Screenshots, e.OnStepFailed(n, step, message)...)
+}
+
+func (e *Engine) sendProgress(p *tea.Program, baseCountStep, n, totalStepCount int) {
+	p.Send(utils.ViewMsgProgressUpdate{Percent: (float64(baseCountStep) + float64(n)) / float64(totalStepCount), Step: n, NewFilesCount: e.runner.NewFilesCount()})
+}
+
+// ErrorResult is the result of a recipe that couldn't be started, e.g. because its directories couldn't be created.
+func ErrorResult(supplier string, err error) utils.RecipeResult {
+	return utils.RecipeResult{
+		Status:              "error",
+		StatusText:          supplier + " aborted with error.",
+		StatusTextFormatted: "x " + textStyleBold(supplier) + " aborted with error.",
+		LastErrorMessage:    err.Error(),
+	}
+}
+
+func NewDocumentsText(newFilesCount int) string {
+	switch newFilesCount {
+	case 0:
+		return "No new documents"
+	case 1:
+		return "One new document"
+	}
+	return fmt.Sprintf("%d new documents", newFilesCount)
+}
+
+// applyStepWarnings marks a successful recipe result as "completed with warnings" if optional steps failed.
+func applyStepWarnings(result *utils.RecipeResult, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	result.Warnings = warnings
+	if result.Status != "success" {
+		return
+	}
+
+	warningsText := fmt.Sprintf("completed with %d warnings", len(warnings))
+	if len(warnings) == 1 {
+		warningsText = "completed with one warning"
+	}
+	result.Status = "warning"
+	result.StatusText = fmt.Sprintf("%s (%s)", result.StatusText, warningsText)
+	result.StatusTextFormatted = "! " + strings.TrimPrefix(result.StatusTextFormatted, "- ") + " (" + warningsText + ")"
+}
@@ -0,0 +1,201 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"buchhalter/lib/parser"
+	"buchhalter/lib/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// testRunner returns the results of the steps by action, "hang" blocks until the step times out and "stuck" ignores
+// the timeout and blocks until it is killed.
+type testRunner struct {
+	results       map[string]utils.StepResult
+	newFilesCount int
+	killed        chan struct{}
+}
+
+func (r *testRunner) RunStep(ctx context.Context, recipe *parser.Recipe, step parser.Step) utils.StepResult {
+	if step.Action == "hang" {
+		<-ctx.Done()
+		return utils.StepResult{Status: "error", Message: ctx.Err().Error()}
+	}
+	if step.Action == "stuck" {
+		<-r.killed
+		return utils.StepResult{Status: "error", Message: "connection closed"}
+	}
+	if step.Action == "download" {
+		r.newFilesCount++
+	}
+	return r.results[step.Action]
+}
+
+func (r *testRunner) NewFilesCount() int {
+	return r.newFilesCount
+}
+
+// testProgram discards all messages, as it is never started
+func testProgram() *tea.Program {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return tea.NewProgram(nil, tea.WithContext(ctx))
+}
+
+func TestEngineRun(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	results := map[string]utils.StepResult{
+		"download": {Status: "success", Artifacts: utils.StepArtifacts{Files: []string{"invoice.pdf"}}},
+		"fail":     {Status: "error", Message: "element not found"},
+		"break":    {Status: "error", Message: "login failed", Break: true},
+	}
+
+	tests := []struct {
+		name          string
+		steps         []parser.Step
+		abortOnError  bool
+		status        string
+		statusText    string
+		timingsCount  int
+		warningsCount int
+	}{
+		{name: "success", steps: []parser.Step{{Action: "download"}, {Action: "download"}}, status: "success", statusText: "acme: 2 new documents", timingsCount: 2},
+		{name: "optional step fails", steps: []parser.Step{{Action: "fail", Optional: true}, {Action: "download"}}, status: "warning", statusText: "acme: One new document (completed with one warning)", timingsCount: 2, warningsCount: 1},
+		{name: "failed step continues", steps: []parser.Step{{Action: "fail"}, {Action: "download"}}, status: "success", statusText: "acme: One new document", timingsCount: 2},
+		{name: "failed step aborts", steps: []parser.Step{{Action: "fail"}, {Action: "download"}}, abortOnError: true, status: "error", statusText: "acme aborted with error.", timingsCount: 1},
+		{name: "step breaks", steps: []parser.Step{{Action: "break"}, {Action: "download"}}, status: "error", statusText: "acme aborted with error.", timingsCount: 1},
+		{name: "timeout", steps: []parser.Step{{Action: "hang"}, {Action: "download"}}, status: "error", statusText: "acme aborted with timeout.", timingsCount: 1},
+		{name: "timeout after download", steps: []parser.Step{{Action: "download"}, {Action: "hang"}}, status: "partial", statusText: "acme: One new document (aborted with timeout)", timingsCount: 2},
+		{name: "continue on timeout", steps: []parser.Step{{Action: "hang", ContinueOnTimeout: true}, {Action: "download"}}, status: "warning", statusText: "acme: One new document (completed with one warning)", timingsCount: 2, warningsCount: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recipe := &parser.Recipe{Supplier: "acme", Version: "1.0.0", Steps: tt.steps}
+			engine := NewEngine(logger, &testRunner{results: results}, 50*time.Millisecond)
+			engine.AbortOnError = tt.abortOnError
+
+			result := engine.Run(context.Background(), testProgram(), len(tt.steps), len(tt.steps), 0, recipe)
+			if result.Status != tt.status || result.StatusText != tt.statusText {
+				t.Errorf("expected %s (%s), got %s (%s)", tt.status, tt.statusText, result.Status, result.StatusText)
+			}
+			if len(result.StepTimings) != tt.timingsCount {
+				t.Errorf("expected %d step timings, got %d", tt.timingsCount, len(result.StepTimings))
+			}
+			if len(result.Warnings) != tt.warningsCount {
+				t.Errorf("expected %d warnings, got %v", tt.warningsCount, result.Warnings)
+			}
+		})
+	}
+}
+
+func TestEngineRunCollectsScreenshotsOfFailedSteps(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	recipe := &parser.Recipe{Supplier: "acme", Version: "1.0.0", Steps: []parser.Step{{Action: "download"}, {Action: "break"}}}
+	engine := NewEngine(logger, &testRunner{results: map[string]utils.StepResult{
+		"download": {Status: "success"},
+		"break":    {Status: "error", Message: "login failed", Break: true},
+	}}, time.Second)
+	engine.OnStepFailed = func(n int, step parser.Step, message string) []string {
+		return []string{"step-2.png"}
+	}
+
+	result := engine.Run(context.Background(), testProgram(), 2, 2, 0, recipe)
+	if result.LastErrorMessage != "login failed" || result.LastStepId != "acme-1.0.0-2-break" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if screenshots := result.StepTimings[1].Artifacts.Screenshots; len(screenshots) != 1 || screenshots[0] != "step-2.png" {
+		t.Errorf("unexpected screenshots %v", screenshots)
+	}
+}
+
+func TestEngineRunCollectsScreenshotsOfAbortingTimeouts(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	tests := []struct {
+		name        string
+		step        parser.Step
+		screenshots int
+	}{
+		{name: "timeout", step: parser.Step{Action: "hang"}, screenshots: 1},
+		// The following steps continue on the page, its inputs must not be scrubbed
+		{name: "continue on timeout", step: parser.Step{Action: "hang", ContinueOnTimeout: true}, screenshots: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recipe := &parser.Recipe{Supplier: "acme", Version: "1.0.0", Steps: []parser.Step{tt.step, {Action: "download"}}}
+			engine := NewEngine(logger, &testRunner{}, 50*time.Millisecond)
+			engine.OnStepFailed = func(n int, step parser.Step, message string) []string {
+				return []string{"step-1.png"}
+			}
+
+			result := engine.Run(context.Background(), testProgram(), 2, 2, 0, recipe)
+			if screenshots := result.StepTimings[0].Artifacts.Screenshots; len(screenshots) != tt.screenshots {
+				t.Errorf("expected %d screenshots, got %v", tt.screenshots, screenshots)
+			}
+		})
+	}
+}
+
+func TestEngineRunStopsWhenCancelled(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	recipe := &parser.Recipe{Supplier: "acme", Version: "1.0.0", Steps: []parser.Step{{Action: "hang", Optional: true}, {Action: "download"}}}
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 50*time.Millisecond, errors.New("recipe timeout exceeded"))
+	defer cancel()
+	runner := &testRunner{}
+	engine := NewEngine(logger, runner, time.Minute)
+
+	result := engine.Run(ctx, testProgram(), 2, 2, 0, recipe)
+	if result.Status != "error" || result.LastErrorMessage != "recipe timeout exceeded" {
+		t.Errorf("expected the recipe to abort, got %s (%s)", result.Status, result.LastErrorMessage)
+	}
+	if len(result.StepTimings) != 1 || runner.newFilesCount != 0 {
+		t.Errorf("expected no steps after the cancellation, got %d step timings", len(result.StepTimings))
+	}
+}
+
+func TestEngineRunKillsHungSteps(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	tests := []struct {
+		name       string
+		steps      []parser.Step
+		kill       bool
+		status     string
+		statusText string
+	}{
+		{name: "killed", steps: []parser.Step{{Action: "download"}, {Action: "stuck", ContinueOnTimeout: true}, {Action: "download"}}, kill: true, status: "partial", statusText: "acme: One new document (aborted with timeout)"},
+		{name: "abandoned", steps: []parser.Step{{Action: "download"}, {Action: "stuck", Optional: true}, {Action: "download"}}, status: "error", statusText: "acme aborted with error."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recipe := &parser.Recipe{Supplier: "acme", Version: "1.0.0", Steps: tt.steps}
+			runner := &testRunner{killed: make(chan struct{})}
+			defer func() {
+				if !tt.kill {
+					close(runner.killed)
+				}
+			}()
+			engine := NewEngine(logger, runner, 20*time.Millisecond)
+			engine.HungStepGracePeriod = 20 * time.Millisecond
+			killedSteps := 0
+			engine.OnStepHung = func(n int, step parser.Step) {
+				killedSteps++
+				if tt.kill {
+					close(runner.killed)
+				}
+			}
+
+			result := engine.Run(context.Background(), testProgram(), len(tt.steps), len(tt.steps), 0, recipe)
+			if result.Status != tt.status || result.StatusText != tt.statusText {
+				t.Errorf("expected %s (%s), got %s (%s)", tt.status, tt.statusText, result.Status, result.StatusText)
+			}
+			if killedSteps != 1 || len(result.StepTimings) != 2 {
+				t.Errorf("expected the second step to be killed and the recipe to abort, got %d kills and %d step timings", killedSteps, len(result.StepTimings))
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package ebics
+
+// Client of the EBICS 2.5 protocol (H004) for company accounts.
+// Subscribers are initialized with INI (signature key A006) and HIA (authentication key X002, encryption key E002)
+// and activated by the bank after receiving the signed initialization letters. Afterwards, the public keys of the
+// bank are retrieved with HPB and statements are downloaded (STA: MT940, C53: camt.053).
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+const (
+	ORDER_TYPE_STA = "STA"
+	ORDER_TYPE_C53 = "C53"
+
+	RETURN_CODE_OK                = "000000"
+	RETURN_CODE_DOWNLOAD_DONE     = "011000"
+	RETURN_CODE_DOWNLOAD_SKIPPED  = "011001"
+	RETURN_CODE_NO_DOWNLOAD_DATA  = "090005"
+	RETURN_CODE_INVALID_USERSTATE = "091002"
+
+	algorithmC14N      = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	algorithmRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algorithmSHA256    = "http://www.w3.org/2001/04/xmlenc#sha256"
+)
+
+// ReturnCodeError is a technical or business return code of the bank other than success.
+type ReturnCodeError struct {
+	Code string
+	Text string
+}
+
+func (e ReturnCodeError) Error() string {
+	if e.Code == RETURN_CODE_INVALID_USERSTATE {
+		return fmt.Sprintf("EBICS error %s %s (the bank hasn't activated the subscriber yet, send the initialization letters)", e.Code, e.Text)
+	}
+	return fmt.Sprintf("EBICS error %s %s", e.Code, e.Text)
+}
+
+type rsaKeyValue struct {
+	Modulus  string `xml:"RSAKeyValue>Modulus"`
+	Exponent string `xml:"RSAKeyValue>Exponent"`
+}
+
+type hpbOrderData struct {
+	Authentication rsaKeyValue `xml:"AuthenticationPubKeyInfo>PubKeyValue"`
+	Encryption     rsaKeyValue `xml:"EncryptionPubKeyInfo>PubKeyValue"`
+}
+
+// response covers the responses of key management (ebicsKeyManagementResponse) and transactions (ebicsResponse).
+type response struct {
+	Header struct {
+		Static struct {
+			TransactionID string `xml:"TransactionID"`
+			NumSegments   int    `xml:"NumSegments"`
+		} `xml:"static"`
+		Mutable struct {
+			ReturnCode string `xml:"ReturnCode"`
+			ReportText string `xml:"ReportText"`
+		} `xml:"mutable"`
+	} `xml:"header"`
+	Body struct {
+		DataTransfer struct {
+			TransactionKey string `xml:"DataEncryptionInfo>TransactionKey"`
+			OrderData      string `xml:"OrderData"`
+		} `xml:"DataTransfer"`
+		ReturnCode string `xml:"ReturnCode"`
+	} `xml:"body"`
+}
+
+// err returns the first return code other than success (the technical code of the header, then the business code of the body).
+func (r *response) err(accepted ...string) error {
+	for _, code := range []string{r.Header.Mutable.ReturnCode, r.Body.ReturnCode} {
+		if code == "" || code == RETURN_CODE_OK {
+			continue
+		}
+		isAccepted := false
+		for _, acceptedCode := range accepted {
+			isAccepted = isAccepted || code == acceptedCode
+		}
+		if !isAccepted {
+			return ReturnCodeError{Code: code, Text: r.Header.Mutable.ReportText}
+		}
+	}
+	return nil
+}
+
+type Client struct {
+	logger     *slog.Logger
+	httpClient *httpclient.Client
+
+	url     string
+	keys    *Keys
+	product string
+}
+
+// NewClient creates a client for the EBICS server at url. product is sent along with each request (e.g. `buchhalter-cli 1.0.0`).
+func NewClient(logger *slog.Logger, httpClient *httpclient.Client, url string, keys *Keys, product string) *Client {
+	return &Client{
+		logger:     logger,
+		httpClient: httpClient,
+		url:        url,
+		keys:       keys,
+		product:    product,
+	}
+}
+
+// INI sends the public signature key (A006) to the bank.
+func (c *Client) INI(ctx context.Context) error {
+	orderData := document(el("SignaturePubKeyOrderData",
+		el("SignaturePubKeyInfo",
+			el("PubKeyValue", rsaKeyValueElement(&c.keys.signatureKey.PublicKey), text("TimeStamp", timestamp(c.keys.CreatedAt))),
+			text("SignatureVersion", "A006"),
+		),
+		text("PartnerID", c.keys.PartnerID),
+		text("UserID", c.keys.UserID),
+	), namespaces(NAMESPACE_S001))
+
+	return c.sendUnsecured(ctx, "INI", orderData)
+}
+
+// HIA sends the public authentication (X002) and encryption (E002) keys to the bank.
+func (c *Client) HIA(ctx context.Context) error {
+	orderData := document(el("HIARequestOrderData",
+		el("AuthenticationPubKeyInfo",
+			el("PubKeyValue", rsaKeyValueElement(&c.keys.authenticationKey.PublicKey), text("TimeStamp", timestamp(c.keys.CreatedAt))),
+			text("AuthenticationVersion", "X002"),
+		),
+		el("EncryptionPubKeyInfo",
+			el("PubKeyValue", rsaKeyValueElement(&c.keys.encryptionKey.PublicKey), text("TimeStamp", timestamp(c.keys.CreatedAt))),
+			text("EncryptionVersion", "E002"),
+		),
+		text("PartnerID", c.keys.PartnerID),
+		text("UserID", c.keys.UserID),
+	), namespaces(NAMESPACE_H004))
+
+	return c.sendUnsecured(ctx, "HIA", orderData)
+}
+
+func (c *Client) sendUnsecured(ctx context.Context, orderType, orderData string) error {
+	compressedOrderData, err := compress([]byte(orderData))
+	if err != nil {
+		return err
+	}
+
+	request := el("ebicsUnsecuredRequest",
+		el("header",
+			el("static",
+				text("HostID", c.keys.HostID),
+				text("PartnerID", c.keys.PartnerID),
+				text("UserID", c.keys.UserID),
+				text("Product", c.product).attr("Language", "de"),
+				el("OrderDetails", text("OrderType", orderType), text("OrderAttribute", "DZNNN")),
+				text("SecurityMedium", "0000"),
+			),
+			el("mutable"),
+		).attr(authenticateKey, "true"),
+		el("body", el("DataTransfer", text("OrderData", base64.StdEncoding.EncodeToString(compressedOrderData)))),
+	).attr("Version", "H004").attr("Revision", "1")
+
+	resp, err := c.send(ctx, document(request, namespaces(NAMESPACE_H004)))
+	if err != nil {
+		return err
+	}
+	return resp.err()
+}
+
+// HPB retrieves the public authentication and encryption keys of the bank.
+// Compare their hashes (see PublicKeyHash) with the hashes published by the bank before trusting them.
+func (c *Client) HPB(ctx context.Context) (*rsa.PublicKey, *rsa.PublicKey, error) {
+	header := el("header",
+		c.static(el("OrderDetails", text("OrderType", "HPB"), text("OrderAttribute", "DZHNN")), false),
+		el("mutable"),
+	).attr(authenticateKey, "true")
+	request, err := c.signedRequest("ebicsNoPubKeyDigestsRequest", header, el("body"))
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.send(ctx, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = resp.err(); err != nil {
+		return nil, nil, err
+	}
+
+	orderData, err := c.decrypt(resp.Body.DataTransfer.TransactionKey, []string{resp.Body.DataTransfer.OrderData})
+	if err != nil {
+		return nil, nil, err
+	}
+	var keys hpbOrderData
+	err = xml.Unmarshal(orderData, &keys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing HPB order data: %w", err)
+	}
+	authentication, err := keys.Authentication.publicKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid authentication key of the bank: %w", err)
+	}
+	encryption, err := keys.Encryption.publicKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid encryption key of the bank: %w", err)
+	}
+
+	return authentication, encryption, nil
+}
+
+// Download downloads the order data of orderType (e.g. STA) between from and to.
+// Returns nil if the bank has no data for the period.
+func (c *Client) Download(ctx context.Context, orderType string, from, to time.Time) ([]byte, error) {
+	if c.keys.bankAuthKey == nil || c.keys.bankEncryptionKey == nil {
+		return nil, errors.New("the public keys of the bank are missing, run `buchhalter ebics activate` first")
+	}
+
+	orderDetails := el("OrderDetails",
+		text("OrderType", orderType),
+		text("OrderAttribute", "DZHNN"),
+		el("StandardOrderParams", el("DateRange", text("Start", from.Format("2006-01-02")), text("End", to.Format("2006-01-02")))),
+	)
+	header := el("header",
+		c.static(orderDetails, true),
+		el("mutable", text("TransactionPhase", "Initialisation")),
+	).attr(authenticateKey, "true")
+	resp, err := c.sendSigned(ctx, "ebicsRequest", header, el("body"))
+	if err != nil {
+		return nil, err
+	}
+	if err = resp.err(); err != nil {
+		var returnCodeError ReturnCodeError
+		if errors.As(err, &returnCodeError) && returnCodeError.Code == RETURN_CODE_NO_DOWNLOAD_DATA {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	transactionID := resp.Header.Static.TransactionID
+	transactionKey := resp.Body.DataTransfer.TransactionKey
+	segments := []string{resp.Body.DataTransfer.OrderData}
+	for segmentNumber := 2; segmentNumber <= resp.Header.Static.NumSegments; segmentNumber++ {
+		header := el("header",
+			el("static", text("HostID", c.keys.HostID), text("TransactionID", transactionID)),
+			el("mutable",
+				text("TransactionPhase", "Transfer"),
+				text("SegmentNumber", strconv.Itoa(segmentNumber)).attr("lastSegment", strconv.FormatBool(segmentNumber == resp.Header.Static.NumSegments)),
+			),
+		).attr(authenticateKey, "true")
+		segmentResp, err := c.sendSigned(ctx, "ebicsRequest", header, el("body"))
+		if err != nil {
+			return nil, err
+		}
+		if err = segmentResp.err(); err != nil {
+			return nil, err
+		}
+		segments = append(segments, segmentResp.Body.DataTransfer.OrderData)
+	}
+
+	orderData, err := c.decrypt(transactionKey, segments)
+	// The receipt confirms the download, a negative receipt if the order data can't be read
+	receiptCode := "0"
+	if err != nil {
+		receiptCode = "1"
+	}
+	header = el("header",
+		el("static", text("HostID", c.keys.HostID), text("TransactionID", transactionID)),
+		el("mutable", text("TransactionPhase", "Receipt")),
+	).attr(authenticateKey, "true")
+	body := el("body", el("TransferReceipt", text("ReceiptCode", receiptCode)).attr(authenticateKey, "true"))
+	receiptResp, receiptErr := c.sendSigned(ctx, "ebicsRequest", header, body)
+	if err != nil {
+		return nil, err
+	}
+	if receiptErr != nil {
+		return nil, receiptErr
+	}
+	if err = receiptResp.err(RETURN_CODE_DOWNLOAD_DONE, RETURN_CODE_DOWNLOAD_SKIPPED); err != nil {
+		return nil, err
+	}
+
+	return orderData, nil
+}
+
+func (c *Client) sendSigned(ctx context.Context, rootName string, header, body *element) (*response, error) {
+	request, err := c.signedRequest(rootName, header, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(ctx, request)
+}
+
+// static returns the static header of the initialisation of a transaction.
+func (c *Client) static(orderDetails *element, bankPubKeyDigests bool) *element {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+
+	static := el("static",
+		text("HostID", c.keys.HostID),
+		text("Nonce", strings.ToUpper(hex.EncodeToString(nonce))),
+		text("Timestamp", timestamp(time.Now())),
+		text("PartnerID", c.keys.PartnerID),
+		text("UserID", c.keys.UserID),
+		text("Product", c.product).attr("Language", "de"),
+		orderDetails,
+	)
+	if bankPubKeyDigests {
+		static.children = append(static.children, el("BankPubKeyDigests",
+			text("Authentication", base64.StdEncoding.EncodeToString(PublicKeyHash(c.keys.bankAuthKey))).attr("Version", "X002").attr("Algorithm", algorithmSHA256),
+			text("Encryption", base64.StdEncoding.EncodeToString(PublicKeyHash(c.keys.bankEncryptionKey))).attr("Version", "E002").attr("Algorithm", algorithmSHA256),
+		))
+	}
+	static.children = append(static.children, text("SecurityMedium", "0000"))
+
+	return static
+}
+
+// signedRequest renders a request with the authentication signature (X002) of all elements marked with `authenticate="true"`.
+func (c *Client) signedRequest(rootName string, header, body *element) (string, error) {
+	root := el(rootName, header, body).attr("Version", "H004").attr("Revision", "1")
+	ns := namespaces(NAMESPACE_H004)
+
+	var authenticated strings.Builder
+	root.authenticated(&authenticated, ns)
+	digest := sha256.Sum256([]byte(authenticated.String()))
+
+	signedInfo := el("ds:SignedInfo",
+		el("ds:CanonicalizationMethod").attr("Algorithm", algorithmC14N),
+		el("ds:SignatureMethod").attr("Algorithm", algorithmRSASHA256),
+		el("ds:Reference",
+			el("ds:Transforms", el("ds:Transform").attr("Algorithm", algorithmC14N)),
+			el("ds:DigestMethod").attr("Algorithm", algorithmSHA256),
+			text("ds:DigestValue", base64.StdEncoding.EncodeToString(digest[:])),
+		).attr("URI", "#xpointer(//*[@authenticate='true'])"),
+	)
+	var canonicalSignedInfo strings.Builder
+	signedInfo.canonical(&canonicalSignedInfo, ns)
+	signedInfoDigest := sha256.Sum256([]byte(canonicalSignedInfo.String()))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.keys.authenticationKey, crypto.SHA256, signedInfoDigest[:])
+	if err != nil {
+		return "", err
+	}
+
+	root.children = []*element{header, el("AuthSignature", signedInfo, text("ds:SignatureValue", base64.StdEncoding.EncodeToString(signature))), body}
+	return document(root, ns), nil
+}
+
+func (c *Client) send(ctx context.Context, request string) (*response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=UTF-8")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.StatusError(resp, "")
+	}
+
+	var r response
+	err = xml.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing EBICS response: %w", err)
+	}
+	c.logger.Debug("EBICS response", "return_code", r.Header.Mutable.ReturnCode, "body_return_code", r.Body.ReturnCode, "report", r.Header.Mutable.ReportText)
+
+	return &r, nil
+}
+
+// decrypt decrypts the order data segments (E002: AES-128-CBC with a transaction key encrypted with the encryption key
+// of the subscriber) and decompresses them.
+func (c *Client) decrypt(transactionKey string, segments []string) ([]byte, error) {
+	encryptedKey, err := base64.StdEncoding.DecodeString(transactionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction key: %w", err)
+	}
+	key, err := rsa.DecryptPKCS1v15(rand.Reader, c.keys.encryptionKey, encryptedKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting transaction key: %w", err)
+	}
+
+	var encrypted []byte
+	for _, segment := range segments {
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(segment), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid order data: %w", err)
+		}
+		encrypted = append(encrypted, data...)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid length of encrypted order data")
+	}
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(decrypted, encrypted)
+	// Padding of ANSI X9.23: the last byte is the number of padding bytes
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("invalid padding of order data")
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(decrypted[:len(decrypted)-padding]))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing order data: %w", err)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	writer := zlib.NewWriter(&b)
+	_, err := writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	return b.Bytes(), err
+}
+
+func rsaKeyValueElement(key *rsa.PublicKey) *element {
+	return el("ds:RSAKeyValue",
+		text("ds:Modulus", base64.StdEncoding.EncodeToString(key.N.Bytes())),
+		text("ds:Exponent", base64.StdEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())),
+	)
+}
+
+func (v rsaKeyValue) publicKey() (*rsa.PublicKey, error) {
+	modulus, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(v.Modulus), ""))
+	if err != nil || len(modulus) == 0 {
+		return nil, errors.New("invalid modulus")
+	}
+	exponent, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(v.Exponent), ""))
+	if err != nil || len(exponent) == 0 || len(exponent) > 8 {
+		return nil, errors.New("invalid exponent")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}, nil
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
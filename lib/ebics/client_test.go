@@ -0,0 +1,245 @@
+package ebics
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+// fakeBank verifies the authentication signature of requests and answers with order data encrypted for the subscriber.
+type fakeBank struct {
+	t    *testing.T
+	keys *Keys
+
+	authenticationKey *rsa.PrivateKey
+	encryptionKey     *rsa.PrivateKey
+
+	orderTypes []string
+	statement  []byte
+	receipts   []string
+}
+
+func (b *fakeBank) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	request := string(body)
+
+	value := func(name string) string {
+		m := regexp.MustCompile(`<` + name + `(?: [^>]*)?>([^<]*)</` + name + `>`).FindStringSubmatch(request)
+		if m == nil {
+			return ""
+		}
+		return m[1]
+	}
+	orderType := value("OrderType")
+	if orderType != "" {
+		b.orderTypes = append(b.orderTypes, orderType)
+	}
+
+	switch {
+	case orderType == "INI" || orderType == "HIA":
+		orderData := b.inflate(value("OrderData"))
+		key := b.keys.signatureKey
+		if orderType == "HIA" {
+			key = b.keys.encryptionKey
+		}
+		if !strings.Contains(orderData, base64.StdEncoding.EncodeToString(key.N.Bytes())) {
+			b.t.Errorf("expected the public key in the %s order data, got %s", orderType, orderData)
+		}
+		b.respond(w, "ebicsKeyManagementResponse", "", "<body><ReturnCode authenticate=\"true\">000000</ReturnCode></body>")
+	case orderType == "HPB":
+		b.verifySignature(request)
+		orderData := fmt.Sprintf(`<HPBResponseOrderData xmlns="urn:org:ebics:H004" xmlns:ds="http://www.w3.org/2000/09/xmldsig#">`+
+			`<AuthenticationPubKeyInfo><PubKeyValue>%s</PubKeyValue><AuthenticationVersion>X002</AuthenticationVersion></AuthenticationPubKeyInfo>`+
+			`<EncryptionPubKeyInfo><PubKeyValue>%s</PubKeyValue><EncryptionVersion>E002</EncryptionVersion></EncryptionPubKeyInfo>`+
+			`<HostID>HOST</HostID></HPBResponseOrderData>`, rsaKeyValueString(&b.authenticationKey.PublicKey), rsaKeyValueString(&b.encryptionKey.PublicKey))
+		transactionKey, encrypted := b.encrypt([]byte(orderData))
+		b.respond(w, "ebicsKeyManagementResponse", "", fmt.Sprintf("<body><DataTransfer><DataEncryptionInfo authenticate=\"true\"><TransactionKey>%s</TransactionKey></DataEncryptionInfo><OrderData>%s</OrderData></DataTransfer><ReturnCode authenticate=\"true\">000000</ReturnCode></body>", transactionKey, base64.StdEncoding.EncodeToString(encrypted)))
+	case orderType == "STA" && value("Start") == "2024-08-01":
+		b.verifySignature(request)
+		b.respond(w, "ebicsResponse", "", "<body><ReturnCode authenticate=\"true\">090005</ReturnCode></body>")
+	case orderType == "STA":
+		b.verifySignature(request)
+		if value("Start") != "2024-09-01" || value("End") != "2024-09-30" {
+			b.t.Errorf("unexpected date range %s - %s", value("Start"), value("End"))
+		}
+		if value("Authentication") != base64.StdEncoding.EncodeToString(PublicKeyHash(&b.authenticationKey.PublicKey)) {
+			b.t.Errorf("expected the digest of the bank authentication key, got %s", value("Authentication"))
+		}
+		transactionKey, encrypted := b.encrypt(b.statement)
+		b.respond(w, "ebicsResponse", "<TransactionID>TX1</TransactionID><NumSegments>2</NumSegments>",
+			fmt.Sprintf("<body><DataTransfer><DataEncryptionInfo authenticate=\"true\"><TransactionKey>%s</TransactionKey></DataEncryptionInfo><OrderData>%s</OrderData></DataTransfer><ReturnCode authenticate=\"true\">000000</ReturnCode></body>", transactionKey, base64.StdEncoding.EncodeToString(encrypted[:32])))
+		b.statement = encrypted
+	case value("TransactionPhase") == "Transfer":
+		b.verifySignature(request)
+		if value("TransactionID") != "TX1" || value("SegmentNumber") != "2" || !strings.Contains(request, `lastSegment="true"`) {
+			b.t.Errorf("unexpected transfer request %s", request)
+		}
+		b.respond(w, "ebicsResponse", "", fmt.Sprintf("<body><DataTransfer><OrderData>%s</OrderData></DataTransfer><ReturnCode authenticate=\"true\">000000</ReturnCode></body>", base64.StdEncoding.EncodeToString(b.statement[32:])))
+	case value("TransactionPhase") == "Receipt":
+		b.verifySignature(request)
+		b.receipts = append(b.receipts, value("ReceiptCode"))
+		b.respond(w, "ebicsResponse", "", "<body><ReturnCode authenticate=\"true\">011000</ReturnCode></body>")
+	default:
+		b.t.Errorf("unexpected request %s", request)
+		b.respond(w, "ebicsResponse", "", "<body><ReturnCode authenticate=\"true\">091005</ReturnCode></body>")
+	}
+}
+
+func (b *fakeBank) respond(w http.ResponseWriter, root, static, body string) {
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><%s xmlns="urn:org:ebics:H004" Version="H004" Revision="1"><header authenticate="true"><static>%s</static><mutable><ReturnCode>000000</ReturnCode><ReportText>[EBICS_OK] OK</ReportText></mutable></header>%s</%s>`, root, static, body, root)
+}
+
+// verifySignature checks the digest of the authenticated header and the signature of SignedInfo with the
+// authentication key of the subscriber.
+func (b *fakeBank) verifySignature(request string) {
+	ns := ` xmlns="urn:org:ebics:H004" xmlns:ds="http://www.w3.org/2000/09/xmldsig#"`
+	var authenticated strings.Builder
+	for _, m := range regexp.MustCompile(`<(header|TransferReceipt) authenticate="true">.*?</(header|TransferReceipt)>`).FindAllString(request, -1) {
+		name := m[1:strings.Index(m, " ")]
+		authenticated.WriteString("<" + name + ns + m[len(name)+1:])
+	}
+	digest := sha256.Sum256([]byte(authenticated.String()))
+	if !strings.Contains(request, "<ds:DigestValue>"+base64.StdEncoding.EncodeToString(digest[:])+"</ds:DigestValue>") {
+		b.t.Errorf("invalid digest of the authenticated elements in %s", request)
+	}
+
+	signedInfo := regexp.MustCompile(`<ds:SignedInfo>.*</ds:SignedInfo>`).FindString(request)
+	signedInfoDigest := sha256.Sum256([]byte("<ds:SignedInfo" + ns + signedInfo[len("<ds:SignedInfo"):]))
+	signature, _ := base64.StdEncoding.DecodeString(regexp.MustCompile(`<ds:SignatureValue>([^<]*)</ds:SignatureValue>`).FindStringSubmatch(request)[1])
+	if err := rsa.VerifyPKCS1v15(&b.keys.authenticationKey.PublicKey, crypto.SHA256, signedInfoDigest[:], signature); err != nil {
+		b.t.Errorf("invalid authentication signature: %s", err)
+	}
+}
+
+func (b *fakeBank) inflate(orderData string) string {
+	data, _ := base64.StdEncoding.DecodeString(orderData)
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		b.t.Fatalf("invalid order data: %s", err)
+	}
+	inflated, _ := io.ReadAll(reader)
+	return string(inflated)
+}
+
+// encrypt compresses and encrypts data for the subscriber (E002) and returns the encrypted transaction key.
+func (b *fakeBank) encrypt(data []byte) (string, []byte) {
+	compressed, err := compress(data)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(compressed)%aes.BlockSize
+	compressed = append(compressed, make([]byte, padding-1)...)
+	compressed = append(compressed, byte(padding))
+
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	block, _ := aes.NewCipher(key)
+	encrypted := make([]byte, len(compressed))
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(encrypted, compressed)
+	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, &b.keys.encryptionKey.PublicKey, key)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(encryptedKey), encrypted
+}
+
+func rsaKeyValueString(key *rsa.PublicKey) string {
+	var b strings.Builder
+	rsaKeyValueElement(key).canonical(&b, nil)
+	return b.String()
+}
+
+func testKeys(t *testing.T) *Keys {
+	keys, err := GenerateKeys("HOST", "PARTNER", "USER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestClientInitializesActivatesAndDownloads(t *testing.T) {
+	keys := testKeys(t)
+	bankKeys := testKeys(t)
+	bank := &fakeBank{t: t, keys: keys, authenticationKey: bankKeys.authenticationKey, encryptionKey: bankKeys.encryptionKey, statement: bytes.Repeat([]byte(":20:STARTUMS\r\n:61:booking\r\n"), 10)}
+	expectedStatement := bank.statement
+	server := httptest.NewServer(bank)
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := NewClient(logger, httpclient.New(logger, 5*time.Second, 0), server.URL, keys, "buchhalter-cli test")
+	ctx := context.Background()
+
+	if err := client.INI(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.HIA(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Download(ctx, ORDER_TYPE_STA, time.Now(), time.Now()); err == nil {
+		t.Error("expected an error downloading without the keys of the bank")
+	}
+
+	authentication, encryption, err := client.HPB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authentication.N.Cmp(bankKeys.authenticationKey.N) != 0 || encryption.N.Cmp(bankKeys.encryptionKey.N) != 0 {
+		t.Fatal("expected the public keys of the bank")
+	}
+	if err = keys.SetBankKeys(authentication, encryption); err != nil {
+		t.Fatal(err)
+	}
+
+	statement, err := client.Download(ctx, ORDER_TYPE_STA, time.Date(2024, 9, 1, 0, 0, 0, 0, time.Local), time.Date(2024, 9, 30, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(statement, expectedStatement) {
+		t.Errorf("expected the statement joined from both segments, got %q", statement)
+	}
+	if len(bank.receipts) != 1 || bank.receipts[0] != "0" {
+		t.Errorf("expected a positive receipt, got %v", bank.receipts)
+	}
+
+	statement, err = client.Download(ctx, ORDER_TYPE_STA, time.Date(2024, 8, 1, 0, 0, 0, 0, time.Local), time.Date(2024, 8, 31, 0, 0, 0, 0, time.Local))
+	if err != nil || statement != nil {
+		t.Errorf("expected no data without error, got %q, %v", statement, err)
+	}
+
+	if got := strings.Join(bank.orderTypes, ","); got != "INI,HIA,HPB,STA,STA" {
+		t.Errorf("unexpected order types %s", got)
+	}
+}
+
+func TestClientFailsOnReturnCodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ebicsKeyManagementResponse xmlns="urn:org:ebics:H004"><header authenticate="true"><static/><mutable><ReturnCode>091002</ReturnCode><ReportText>[EBICS_INVALID_USER_OR_USER_STATE] Subscriber unknown or subscriber state inadmissible</ReportText></mutable></header><body><ReturnCode authenticate="true">000000</ReturnCode></body></ebicsKeyManagementResponse>`))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := NewClient(logger, httpclient.New(logger, 5*time.Second, 0), server.URL, testKeys(t), "buchhalter-cli test")
+	_, _, err := client.HPB(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "091002") || !strings.Contains(err.Error(), "initialization letters") {
+		t.Errorf("expected the invalid user state error, got %v", err)
+	}
+}
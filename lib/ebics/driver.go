@@ -0,0 +1,235 @@
+package ebics
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/audit"
+	"buchhalter/lib/driver"
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/utils"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// DefaultMonths is the number of completed months statements are downloaded for.
+const DefaultMonths = 3
+
+// EBICSDriver runs recipes of type "ebics": the account statements of company accounts are downloaded from the EBICS
+// server of the bank and stored in the document archive like supplier invoices.
+// The keys of the subscriber are read from the KeyStore, they are created and activated with `buchhalter ebics`.
+type EBICSDriver struct {
+	logger          *slog.Logger
+	httpClient      *httpclient.Client
+	keyStore        KeyStore
+	documentArchive *archive.DocumentArchive
+
+	buchhalterDocumentsDirectory string
+
+	// Product is sent along with each request (e.g. `buchhalter-cli 1.0.0`)
+	Product string
+	// ShredTemporaryFiles overwrites downloaded files before they are removed from the downloads directory
+	ShredTemporaryFiles bool
+	// AuditLog records the keys loaded by the steps (optional)
+	AuditLog *audit.Log
+
+	// supplier of the recipe that is currently executed
+	supplier string
+
+	downloadsDirectory string
+	documentsDirectory string
+
+	recipeTimeout time.Duration
+	ctx           context.Context
+	newFilesCount int
+	// newFiles are the documents archived by the current step
+	newFiles []string
+}
+
+func NewEBICSDriver(ctx context.Context, logger *slog.Logger, httpClient *httpclient.Client, keyStore KeyStore, buchhalterDocumentsDirectory string, documentArchive *archive.DocumentArchive) *EBICSDriver {
+	return &EBICSDriver{
+		logger:          logger,
+		httpClient:      httpClient,
+		keyStore:        keyStore,
+		documentArchive: documentArchive,
+
+		buchhalterDocumentsDirectory: buchhalterDocumentsDirectory,
+
+		recipeTimeout: 120 * time.Second,
+		ctx:           ctx,
+		newFilesCount: 0,
+	}
+}
+
+func (d *EBICSDriver) RunRecipe(p *tea.Program, totalStepCount int, stepCountInCurrentRecipe int, baseCountStep int, recipe *parser.Recipe) utils.RecipeResult {
+	d.logger.Info("Starting EBICS driver ...", "supplier", recipe.Supplier, "recipe_version", recipe.Version)
+	d.supplier = recipe.Supplier
+
+	var err error
+	d.downloadsDirectory, d.documentsDirectory, err = utils.InitSupplierDirectories(d.buchhalterDocumentsDirectory, d.documentArchive.SupplierDirectory(recipe.Supplier), recipe.Supplier)
+	if err != nil {
+		return driver.ErrorResult(recipe.Supplier, err)
+	}
+	defer func() {
+		err := utils.RemoveTemporaryDirectory(d.downloadsDirectory, d.ShredTemporaryFiles)
+		if err != nil {
+			d.logger.Error("Error removing downloads directory", "directory", d.downloadsDirectory, "error", err)
+		}
+	}()
+
+	engine := driver.NewEngine(d.logger, d, d.recipeTimeout)
+	engine.Title = "Downloading statements from %s (%d/%d):"
+	return engine.Run(d.ctx, p, totalStepCount, stepCountInCurrentRecipe, baseCountStep, recipe)
+}
+
+func (d *EBICSDriver) RunStep(ctx context.Context, recipe *parser.Recipe, step parser.Step) utils.StepResult {
+	switch step.Action {
+	case "ebics-statements":
+		return d.stepStatements(ctx, step)
+	}
+	return utils.StepResult{Status: "error", Message: "unknown action " + step.Action + " of EBICS recipe", Break: true}
+}
+
+func (d *EBICSDriver) NewFilesCount() int {
+	return d.newFilesCount
+}
+
+// Quit does nothing, the driver doesn't keep any resources between recipes.
+func (d *EBICSDriver) Quit() error {
+	return nil
+}
+
+// stepStatements downloads the statements of the last completed months (step.Ebics.Months).
+// camt.053 statements (C53) are delivered as ZIP file and archived one by one.
+func (d *EBICSDriver) stepStatements(ctx context.Context, step parser.Step) utils.StepResult {
+	d.logger.Debug("Executing recipe step", "action", step.Action, "url", step.URL, "host_id", step.Ebics.HostID)
+	d.newFiles = nil
+
+	if step.URL == "" || step.Ebics.HostID == "" {
+		return utils.StepResult{Status: "error", Message: "the EBICS recipe step requires a url and a hostId", Break: true}
+	}
+	orderType := step.Ebics.OrderType
+	if orderType == "" {
+		orderType = ORDER_TYPE_STA
+	}
+	if orderType != ORDER_TYPE_STA && orderType != ORDER_TYPE_C53 {
+		return utils.StepResult{Status: "error", Message: "unknown order type " + orderType, Break: true}
+	}
+	months := step.Ebics.Months
+	if months <= 0 {
+		months = DefaultMonths
+	}
+
+	d.AuditLog.Record(audit.Event{Kind: audit.KIND_EBICS_KEYS, Supplier: d.supplier, Step: step.Action})
+	keys, err := LoadKeys(d.keyStore, d.supplier)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: fmt.Sprintf("error loading EBICS keys (run `buchhalter ebics init %s` first): %s", d.supplier, err), Break: true}
+	}
+	if keys.State != STATE_READY {
+		return utils.StepResult{Status: "error", Message: fmt.Sprintf("EBICS keys of %s are not activated yet, run `buchhalter ebics activate %s` after the bank received the letters", d.supplier, d.supplier), Break: true}
+	}
+	if keys.HostID != step.Ebics.HostID {
+		return utils.StepResult{Status: "error", Message: fmt.Sprintf("EBICS keys of %s were created for host %s, the recipe uses %s", d.supplier, keys.HostID, step.Ebics.HostID), Break: true}
+	}
+
+	client := NewClient(d.logger, d.httpClient, step.URL, keys, d.Product)
+	currentMonth := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.Local)
+	for m := months; m >= 1; m-- {
+		start := currentMonth.AddDate(0, -m, 0)
+		end := start.AddDate(0, 1, -1)
+		data, err := client.Download(ctx, orderType, start, end)
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: fmt.Sprintf("error downloading statements of %s: %s", start.Format("2006-01"), err), Break: true}
+		}
+		if data == nil {
+			d.logger.Debug("No EBICS statements", "supplier", d.supplier, "month", start.Format("2006-01"))
+			continue
+		}
+
+		if orderType == ORDER_TYPE_STA {
+			err = d.archiveStatement(fmt.Sprintf("%s-%s.sta", d.supplier, start.Format("2006-01")), data)
+		} else {
+			err = d.archiveStatements(start.Format("2006-01"), data)
+		}
+		if err != nil {
+			return utils.StepResult{Status: "error", Message: err.Error(), Artifacts: utils.StepArtifacts{Files: d.newFiles}}
+		}
+	}
+
+	return utils.StepResult{Status: "success", Artifacts: utils.StepArtifacts{Files: d.newFiles}}
+}
+
+// archiveStatements archives each camt.053 file of a ZIP container.
+func (d *EBICSDriver) archiveStatements(month string, data []byte) error {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("error reading camt.053 container: %w", err)
+	}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		statement, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		err = d.archiveStatement(month+"-"+filepath.Base(file.Name), statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveStatement adds the statement to the document archive, unless the archive contains it already.
+func (d *EBICSDriver) archiveStatement(fileName string, statement []byte) error {
+	fileName = strings.ReplaceAll(fileName, string(filepath.Separator), "_")
+	f := filepath.Join(d.downloadsDirectory, fileName)
+	err := os.WriteFile(f, statement, 0600)
+	if err != nil {
+		return err
+	}
+	if d.documentArchive.FileExists(f) {
+		return nil
+	}
+
+	// A statement of the same month may have changed (e.g. late bookings), the previous version is kept
+	dstFile := filepath.Join(d.documentsDirectory, fileName)
+	extension := filepath.Ext(fileName)
+	for i := 2; fileExists(dstFile); i++ {
+		dstFile = filepath.Join(d.documentsDirectory, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(fileName, extension), i, extension))
+	}
+	_, err = utils.CopyFile(f, dstFile)
+	if err != nil {
+		return fmt.Errorf("error while copying file: %w", err)
+	}
+	err = d.documentArchive.AddFile(dstFile, d.supplier)
+	if err != nil {
+		return fmt.Errorf("error while adding file %s to document archive: %w", dstFile, err)
+	}
+	d.newFilesCount++
+	d.newFiles = append(d.newFiles, dstFile)
+	d.logger.Info("New account statement archived", "supplier", d.supplier, "file", dstFile)
+
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
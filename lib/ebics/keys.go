@@ -0,0 +1,202 @@
+package ebics
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	KEY_SIZE = 2048
+
+	// States of the subscriber keys
+	STATE_NEW         = "new"
+	STATE_INITIALIZED = "initialized"
+	STATE_READY       = "ready"
+)
+
+// KeyStore stores the keys of EBICS subscribers encrypted, e.g. the keychain of the operating system.
+type KeyStore interface {
+	Get(key string) (string, error)
+	Set(key, value string) error
+}
+
+// Keys are the keys of a subscriber (partner and user) at a bank (host) and the public keys of the bank.
+type Keys struct {
+	HostID    string    `json:"hostId"`
+	PartnerID string    `json:"partnerId"`
+	UserID    string    `json:"userId"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Private keys of the subscriber (PEM encoded PKCS #8)
+	Signature      string `json:"signature"`
+	Authentication string `json:"authentication"`
+	Encryption     string `json:"encryption"`
+
+	// Public keys of the bank (PEM encoded PKIX), retrieved with HPB
+	BankAuthentication string `json:"bankAuthentication,omitempty"`
+	BankEncryption     string `json:"bankEncryption,omitempty"`
+
+	signatureKey      *rsa.PrivateKey
+	authenticationKey *rsa.PrivateKey
+	encryptionKey     *rsa.PrivateKey
+	bankAuthKey       *rsa.PublicKey
+	bankEncryptionKey *rsa.PublicKey
+}
+
+// StoreKey returns the key of the subscriber keys of supplier in the KeyStore.
+func StoreKey(supplier string) string {
+	return "ebics-" + supplier
+}
+
+// GenerateKeys creates new signature (A006), authentication (X002) and encryption (E002) keys.
+func GenerateKeys(hostID, partnerID, userID string) (*Keys, error) {
+	k := &Keys{
+		HostID:    hostID,
+		PartnerID: partnerID,
+		UserID:    userID,
+		State:     STATE_NEW,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	var err error
+	for _, key := range []*string{&k.Signature, &k.Authentication, &k.Encryption} {
+		*key, err = generatePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return k, k.parse()
+}
+
+func generatePrivateKey() (string, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, KEY_SIZE)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// LoadKeys reads the keys of supplier from the store.
+func LoadKeys(store KeyStore, supplier string) (*Keys, error) {
+	value, err := store.Get(StoreKey(supplier))
+	if err != nil {
+		return nil, err
+	}
+
+	var k Keys
+	err = json.Unmarshal([]byte(value), &k)
+	if err != nil {
+		return nil, fmt.Errorf("error reading EBICS keys of %s: %w", supplier, err)
+	}
+	return &k, k.parse()
+}
+
+// SaveKeys writes the keys of supplier to the store.
+func SaveKeys(store KeyStore, supplier string, k *Keys) error {
+	value, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return store.Set(StoreKey(supplier), string(value))
+}
+
+// SetBankKeys stores the public keys of the bank and marks the keys as ready.
+func (k *Keys) SetBankKeys(authentication, encryption *rsa.PublicKey) error {
+	for _, key := range []struct {
+		target *string
+		key    *rsa.PublicKey
+	}{{&k.BankAuthentication, authentication}, {&k.BankEncryption, encryption}} {
+		der, err := x509.MarshalPKIXPublicKey(key.key)
+		if err != nil {
+			return err
+		}
+		*key.target = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+	k.State = STATE_READY
+
+	return k.parse()
+}
+
+func (k *Keys) parse() error {
+	var err error
+	for _, key := range []struct {
+		pem    string
+		target **rsa.PrivateKey
+	}{{k.Signature, &k.signatureKey}, {k.Authentication, &k.authenticationKey}, {k.Encryption, &k.encryptionKey}} {
+		*key.target, err = parsePrivateKey(key.pem)
+		if err != nil {
+			return err
+		}
+	}
+	if k.BankAuthentication == "" || k.BankEncryption == "" {
+		return nil
+	}
+	for _, key := range []struct {
+		pem    string
+		target **rsa.PublicKey
+	}{{k.BankAuthentication, &k.bankAuthKey}, {k.BankEncryption, &k.bankEncryptionKey}} {
+		*key.target, err = parsePublicKey(key.pem)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parsePrivateKey(value string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(value))
+	if block == nil {
+		return nil, errors.New("invalid EBICS private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("EBICS private key is no RSA key")
+	}
+	return rsaKey, nil
+}
+
+func parsePublicKey(value string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(value))
+	if block == nil {
+		return nil, errors.New("invalid EBICS public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("EBICS public key is no RSA key")
+	}
+	return rsaKey, nil
+}
+
+// PublicKeyHash returns the SHA-256 hash of a public key as used in the initialization letters and as key digest:
+// the hash of the hex encoded exponent and modulus (lower case, without leading zeros) separated by a space.
+func PublicKeyHash(key *rsa.PublicKey) []byte {
+	exponent := strings.TrimLeft(hex.EncodeToString(big.NewInt(int64(key.E)).Bytes()), "0")
+	modulus := strings.TrimLeft(hex.EncodeToString(key.N.Bytes()), "0")
+	hash := sha256.Sum256([]byte(exponent + " " + modulus))
+	return hash[:]
+}
@@ -0,0 +1,80 @@
+package ebics
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+type memoryKeyStore map[string]string
+
+func (s memoryKeyStore) Get(key string) (string, error) {
+	value, ok := s[key]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return value, nil
+}
+
+func (s memoryKeyStore) Set(key, value string) error {
+	s[key] = value
+	return nil
+}
+
+func TestSaveAndLoadKeys(t *testing.T) {
+	keys := testKeys(t)
+	bankKeys := testKeys(t)
+	err := keys.SetBankKeys(&bankKeys.authenticationKey.PublicKey, &bankKeys.encryptionKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := memoryKeyStore{}
+	if err = SaveKeys(store, "my-bank", keys); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store["ebics-my-bank"]; !ok {
+		t.Fatalf("expected the keys under ebics-my-bank, got %v", store)
+	}
+
+	loaded, err := LoadKeys(store, "my-bank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.State != STATE_READY || loaded.PartnerID != "PARTNER" || loaded.UserID != "USER" || loaded.HostID != "HOST" {
+		t.Errorf("unexpected keys %+v", loaded)
+	}
+	if !loaded.signatureKey.Equal(keys.signatureKey) || !loaded.bankEncryptionKey.Equal(&bankKeys.encryptionKey.PublicKey) {
+		t.Error("expected the parsed private and bank keys")
+	}
+
+	if _, err = LoadKeys(store, "other-bank"); err == nil {
+		t.Error("expected an error loading unknown keys")
+	}
+}
+
+func TestPublicKeyHash(t *testing.T) {
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes([]byte{0x0a, 0xbc, 0xde}), E: 65537}
+	expected := sha256.Sum256([]byte("10001 abcde"))
+	if got := PublicKeyHash(key); string(got) != string(expected[:]) {
+		t.Errorf("expected the hash of exponent and modulus without leading zeros, got %x", got)
+	}
+}
+
+func TestLetters(t *testing.T) {
+	keys := testKeys(t)
+	letters := Letters(keys, "My Bank")
+
+	for _, expected := range []string{"INI-Brief", "HIA-Brief", "A006", "X002", "E002", "Empfänger:     My Bank", "Kunden-ID:     PARTNER"} {
+		if !strings.Contains(letters, expected) {
+			t.Errorf("expected %q in the letters", expected)
+		}
+	}
+	hash := hexBlock(PublicKeyHash(&keys.signatureKey.PublicKey))
+	if !strings.Contains(letters, hash) || len(strings.Split(strings.TrimSpace(hash), "\n")) != 2 {
+		t.Errorf("expected the hash of the signature key in two lines, got %s", hash)
+	}
+}
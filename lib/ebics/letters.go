@@ -0,0 +1,68 @@
+package ebics
+
+import (
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// Letters returns the initialization letters (INI and HIA) of the keys. The bank activates the subscriber after
+// comparing the signed letters with the keys sent with INI and HIA.
+func Letters(k *Keys, bankName string) string {
+	var b strings.Builder
+	letter := func(title, version string, key *rsa.PublicKey, confirmation string) {
+		b.WriteString(title + "\n")
+		b.WriteString(strings.Repeat("=", utf8.RuneCountInString(title)) + "\n\n")
+		for _, line := range [][2]string{
+			{"Datum", k.CreatedAt.Local().Format("02.01.2006")},
+			{"Uhrzeit", k.CreatedAt.Local().Format("15:04:05")},
+			{"Empfänger", bankName},
+			{"Host-ID", k.HostID},
+			{"Teilnehmer-ID", k.UserID},
+			{"Kunden-ID", k.PartnerID},
+			{"Version", version},
+		} {
+			b.WriteString(fmt.Sprintf("%-14s %s\n", line[0]+":", line[1]))
+		}
+		b.WriteString("\nÖffentlicher Schlüssel\n\nExponent:\n")
+		b.WriteString(hexBlock(big.NewInt(int64(key.E)).Bytes()))
+		b.WriteString("\nModulus:\n")
+		b.WriteString(hexBlock(key.N.Bytes()))
+		b.WriteString("\nHash (SHA-256):\n")
+		b.WriteString(hexBlock(PublicKeyHash(key)))
+		b.WriteString("\n" + confirmation + "\n\n\n")
+		b.WriteString("_______________________________    _______________________________\n")
+		b.WriteString("Ort, Datum                         Unterschrift\n\n\n")
+	}
+
+	letter("INI-Brief: Initialisierung der elektronischen Unterschrift", "A006", &k.signatureKey.PublicKey,
+		"Ich bestätige hiermit den obigen öffentlichen Schlüssel für meine elektronische Unterschrift.")
+	letter("HIA-Brief: Initialisierung der Authentifikation", "X002", &k.authenticationKey.PublicKey,
+		"Ich bestätige hiermit den obigen öffentlichen Authentifikationsschlüssel.")
+	letter("HIA-Brief: Initialisierung der Verschlüsselung", "E002", &k.encryptionKey.PublicKey,
+		"Ich bestätige hiermit den obigen öffentlichen Verschlüsselungsschlüssel.")
+
+	return b.String()
+}
+
+// FormatHash formats a key hash in upper case hex bytes, as printed in the letters of banks and subscribers.
+func FormatHash(hash []byte) string {
+	return strings.TrimSpace(strings.ReplaceAll(hexBlock(hash), "\n", " "))
+}
+
+// hexBlock formats data in lines of 16 upper case hex bytes.
+func hexBlock(data []byte) string {
+	var b strings.Builder
+	for i, c := range data {
+		b.WriteString(strings.ToUpper(hex.EncodeToString([]byte{c})))
+		if (i+1)%16 == 0 || i == len(data)-1 {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(" ")
+		}
+	}
+	return b.String()
+}
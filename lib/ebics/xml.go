@@ -0,0 +1,102 @@
+package ebics
+
+// Requests are built as trees of elements and rendered in canonical form (Canonical XML 1.0, without comments), which
+// is required for the authentication signature (X002) over the elements marked with `authenticate="true"`.
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	NAMESPACE_H004  = "urn:org:ebics:H004"
+	NAMESPACE_S001  = "http://www.ebics.org/S001"
+	NAMESPACE_DSIG  = "http://www.w3.org/2000/09/xmldsig#"
+	xmlDeclaration  = `<?xml version="1.0" encoding="UTF-8"?>`
+	authenticateKey = "authenticate"
+)
+
+type element struct {
+	name       string
+	attributes map[string]string
+	children   []*element
+	text       string
+}
+
+func el(name string, children ...*element) *element {
+	return &element{name: name, attributes: map[string]string{}, children: children}
+}
+
+func text(name, value string) *element {
+	return &element{name: name, attributes: map[string]string{}, text: value}
+}
+
+func (e *element) attr(name, value string) *element {
+	e.attributes[name] = value
+	return e
+}
+
+// namespaces returns the namespace declarations of a document with the default namespace and the `ds` prefix.
+func namespaces(defaultNamespace string) map[string]string {
+	return map[string]string{"xmlns": defaultNamespace, "xmlns:ds": NAMESPACE_DSIG}
+}
+
+// document renders the root element with its namespace declarations.
+func document(root *element, namespaceDeclarations map[string]string) string {
+	var b strings.Builder
+	b.WriteString(xmlDeclaration)
+	root.canonical(&b, namespaceDeclarations)
+	return b.String()
+}
+
+// canonical renders the element in canonical form. namespaceDeclarations are rendered before the attributes, as
+// canonicalization renders the namespaces in scope on the apex elements of a document subset.
+func (e *element) canonical(b *strings.Builder, namespaceDeclarations map[string]string) {
+	b.WriteString("<" + e.name)
+
+	prefixes := make([]string, 0, len(namespaceDeclarations))
+	for prefix := range namespaceDeclarations {
+		prefixes = append(prefixes, prefix)
+	}
+	// The default namespace (`xmlns`) sorts first
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		b.WriteString(" " + prefix + `="` + escapeAttribute(namespaceDeclarations[prefix]) + `"`)
+	}
+
+	names := make([]string, 0, len(e.attributes))
+	for name := range e.attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(" " + name + `="` + escapeAttribute(e.attributes[name]) + `"`)
+	}
+	b.WriteString(">")
+
+	b.WriteString(escapeText(e.text))
+	for _, child := range e.children {
+		child.canonical(b, nil)
+	}
+	b.WriteString("</" + e.name + ">")
+}
+
+// authenticated renders all elements marked with `authenticate="true"` in document order, as referenced by the
+// authentication signature (`#xpointer(//*[@authenticate='true'])`).
+func (e *element) authenticated(b *strings.Builder, namespaceDeclarations map[string]string) {
+	if e.attributes[authenticateKey] == "true" {
+		e.canonical(b, namespaceDeclarations)
+		return
+	}
+	for _, child := range e.children {
+		child.authenticated(b, namespaceDeclarations)
+	}
+}
+
+func escapeText(value string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;").Replace(value)
+}
+
+func escapeAttribute(value string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;").Replace(value)
+}
@@ -0,0 +1,595 @@
+package fints
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+const (
+	FORMAT_MT940 = "mt940"
+	FORMAT_CAMT  = "camt"
+
+	// securityFunctionSingleStep is the one-step procedure (PIN only), used to synchronize and to retrieve the bank parameters
+	securityFunctionSingleStep = "999"
+	defaultCamtDescriptor      = "urn:iso:std:iso:20022:tech:xsd:camt.052.001.02"
+)
+
+var (
+	// tanPollInterval is the time between two status requests while waiting for a decoupled TAN
+	tanPollInterval = 2 * time.Second
+
+	segmentTypePattern    = regexp.MustCompile(`^HK[A-Z]{3}$`)
+	securityFunctionRegex = regexp.MustCompile(`^9\d\d$`)
+)
+
+// Account is a SEPA account of the user.
+type Account struct {
+	IBAN          string
+	BIC           string
+	AccountNumber string
+	SubAccount    string
+	BankCode      string
+}
+
+// TanMethod is a two-step TAN procedure offered by the bank.
+type TanMethod struct {
+	SecurityFunction string
+	Name             string
+	ZkaID            string
+	// Decoupled methods are confirmed in a banking app, no TAN is entered
+	Decoupled bool
+}
+
+// ReturnMessage is a return code of the bank (HIRMG/HIRMS). Codes starting with 9 are errors, with 3 warnings.
+type ReturnMessage struct {
+	Code       string
+	Text       string
+	Parameters []string
+}
+
+type requestSegment struct {
+	Type     string
+	Version  int
+	Elements []string
+}
+
+type response struct {
+	segments []Segment
+	messages []ReturnMessage
+}
+
+func (r *response) find(segmentType string) []Segment {
+	var segments []Segment
+	for _, s := range r.segments {
+		if s.Type == segmentType {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+func (r *response) message(code string) *ReturnMessage {
+	for i := range r.messages {
+		if r.messages[i].Code == code {
+			return &r.messages[i]
+		}
+	}
+	return nil
+}
+
+// Client is a FinTS 3.0 PIN/TAN client retrieving account statements.
+// Strong customer authentication is supported with decoupled TAN methods (confirmation in the banking app) only.
+type Client struct {
+	logger     *slog.Logger
+	httpClient *httpclient.Client
+
+	url            string
+	bankCode       string
+	userID         string
+	pin            string
+	productID      string
+	productVersion string
+
+	// TanMedium is the name of the TAN medium (e.g. the device registered for pushTAN), required by some banks
+	TanMedium string
+	// OnTanPending is called while the bank waits for the confirmation in the banking app
+	OnTanPending func(challenge string)
+
+	systemID         string
+	dialogID         string
+	messageNumber    int
+	securityFunction string
+
+	allowedSecurityFunctions []string
+	tanMethods               map[string]TanMethod
+	tanRequired              map[string]bool
+	parameterVersions        map[string][]int
+	camtDescriptors          []string
+}
+
+// NewClient creates a client for the FinTS server at url. The product ID is the registration number of the
+// Deutsche Kreditwirtschaft, which banks require from FinTS clients.
+func NewClient(logger *slog.Logger, httpClient *httpclient.Client, url, bankCode, userID, pin, productID, productVersion string) *Client {
+	return &Client{
+		logger:         logger,
+		httpClient:     httpClient,
+		url:            url,
+		bankCode:       bankCode,
+		userID:         userID,
+		pin:            pin,
+		productID:      productID,
+		productVersion: productVersion,
+
+		systemID:          "0",
+		tanMethods:        map[string]TanMethod{},
+		tanRequired:       map[string]bool{},
+		parameterVersions: map[string][]int{},
+	}
+}
+
+// Connect synchronizes the client (bank parameters, customer system id and TAN methods) and opens a dialog.
+// A decoupled TAN method is preferred, the login may need to be confirmed in the banking app.
+func (c *Client) Connect(ctx context.Context) error {
+	c.securityFunction = securityFunctionSingleStep
+	c.resetDialog()
+	resp, err := c.send(ctx,
+		c.identification(),
+		c.processingPreparation(),
+		requestSegment{Type: "HKSYN", Version: 3, Elements: []string{"0"}},
+	)
+	if err != nil {
+		return fmt.Errorf("synchronization failed: %w", err)
+	}
+	if synchronization := resp.find("HISYN"); len(synchronization) > 0 && synchronization[0].Element(0) != "" {
+		c.systemID = synchronization[0].Element(0)
+	}
+	err = c.end(ctx)
+	if err != nil {
+		return err
+	}
+
+	tanMethod := c.selectTanMethod()
+	c.logger.Info("Selected FinTS TAN method", "security_function", tanMethod.SecurityFunction, "name", tanMethod.Name, "decoupled", tanMethod.Decoupled)
+	c.securityFunction = tanMethod.SecurityFunction
+
+	c.resetDialog()
+	segments := []requestSegment{c.identification(), c.processingPreparation()}
+	if c.securityFunction != securityFunctionSingleStep {
+		segments = append(segments, c.tanSegment("HKIDN"))
+	}
+	resp, err = c.send(ctx, segments...)
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	_, err = c.completeTan(ctx, resp)
+	return err
+}
+
+// Accounts returns the SEPA accounts of the user.
+func (c *Client) Accounts(ctx context.Context) ([]Account, error) {
+	version := c.segmentVersion("HISPAS", 3, 1)
+	segments := []requestSegment{{Type: "HKSPA", Version: version}}
+	if c.tanRequired["HKSPA"] {
+		segments = append(segments, c.tanSegment("HKSPA"))
+	}
+	resp, err := c.send(ctx, segments...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = c.completeTan(ctx, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []Account
+	for _, s := range resp.find("HISPA") {
+		for i := range s.Elements {
+			if s.Component(i, 0) != "J" {
+				continue
+			}
+			accounts = append(accounts, Account{
+				IBAN:          s.Component(i, 1),
+				BIC:           s.Component(i, 2),
+				AccountNumber: s.Component(i, 3),
+				SubAccount:    s.Component(i, 4),
+				BankCode:      s.Component(i, 6),
+			})
+		}
+	}
+
+	return accounts, nil
+}
+
+// Statements returns the booked transactions of account between from and to (inclusive).
+// MT940 statements are returned as one document, camt statements as one document per camt.052 message.
+func (c *Client) Statements(ctx context.Context, account Account, format string, from, to time.Time) ([][]byte, error) {
+	var segmentType string
+	var elements []string
+	switch format {
+	case FORMAT_MT940, "":
+		segmentType = "HKKAZ"
+		version := c.segmentVersion("HIKAZS", 7, 5)
+		accountDeg := deg(escape(account.AccountNumber), escape(account.SubAccount), "280", escape(account.BankCode))
+		if version >= 7 {
+			accountDeg = deg(escape(account.IBAN), escape(account.BIC), accountDeg)
+		}
+		elements = []string{accountDeg, "N", from.Format("20060102"), to.Format("20060102")}
+		return c.fetchStatements(ctx, requestSegment{Type: segmentType, Version: version, Elements: elements}, func(s Segment) [][]byte {
+			if s.Type != "HIKAZ" || s.Element(0) == "" {
+				return nil
+			}
+			return [][]byte{[]byte(s.Element(0))}
+		})
+	case FORMAT_CAMT:
+		segmentType = "HKCAZ"
+		elements = []string{deg(escape(account.IBAN), escape(account.BIC)), escape(c.camtDescriptor()), "N", from.Format("20060102"), to.Format("20060102")}
+		return c.fetchStatements(ctx, requestSegment{Type: segmentType, Version: 1, Elements: elements}, func(s Segment) [][]byte {
+			if s.Type != "HICAZ" || len(s.Elements) < 3 {
+				return nil
+			}
+			var documents [][]byte
+			for _, document := range s.Elements[2] {
+				if document != "" {
+					documents = append(documents, []byte(document))
+				}
+			}
+			return documents
+		})
+	}
+
+	return nil, fmt.Errorf("unknown statement format %s (supported: %s, %s)", format, FORMAT_MT940, FORMAT_CAMT)
+}
+
+// fetchStatements sends the statement request until the bank has no more data (touchdown points, code 3040).
+func (c *Client) fetchStatements(ctx context.Context, request requestSegment, documents func(Segment) [][]byte) ([][]byte, error) {
+	var result [][]byte
+	var mt940 []byte
+	touchdown := ""
+	for {
+		r := request
+		if touchdown != "" {
+			// Maximum number of entries, touchdown point
+			r.Elements = append(append([]string{}, request.Elements...), "", escape(touchdown))
+		}
+		segments := []requestSegment{r}
+		if c.tanRequired[request.Type] {
+			segments = append(segments, c.tanSegment(request.Type))
+		}
+		resp, err := c.send(ctx, segments...)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = c.completeTan(ctx, resp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range resp.segments {
+			for _, document := range documents(s) {
+				if request.Type == "HKKAZ" {
+					// The pages of MT940 statements are joined
+					mt940 = append(mt940, document...)
+					continue
+				}
+				result = append(result, document)
+			}
+		}
+
+		next := resp.message("3040")
+		if next == nil || len(next.Parameters) == 0 {
+			break
+		}
+		touchdown = next.Parameters[0]
+	}
+	if len(mt940) > 0 {
+		result = append(result, mt940)
+	}
+
+	return result, nil
+}
+
+// Close ends the dialog.
+func (c *Client) Close(ctx context.Context) error {
+	if c.dialogID == "0" {
+		return nil
+	}
+	return c.end(ctx)
+}
+
+func (c *Client) end(ctx context.Context) error {
+	_, err := c.send(ctx, requestSegment{Type: "HKEND", Version: 1, Elements: []string{escape(c.dialogID)}})
+	c.resetDialog()
+	return err
+}
+
+func (c *Client) resetDialog() {
+	c.dialogID = "0"
+	c.messageNumber = 0
+}
+
+// completeTan waits for the confirmation of a decoupled TAN if the bank requires it and returns the final response.
+func (c *Client) completeTan(ctx context.Context, resp *response) (*response, error) {
+	if resp.message("0030") == nil && resp.message("3955") == nil {
+		return resp, nil
+	}
+	tanMethod := c.tanMethods[c.securityFunction]
+	if !tanMethod.Decoupled {
+		return nil, fmt.Errorf("the bank requires a TAN of %s, only confirmations in the banking app are supported (offered TAN methods: %s)", tanMethod.Name, c.tanMethodNames())
+	}
+	challenges := resp.find("HITAN")
+	if len(challenges) == 0 || challenges[0].Element(2) == "" {
+		return nil, errors.New("the bank requires a TAN, but didn't send a task reference")
+	}
+	taskReference := challenges[0].Element(2)
+	challenge := fromLatin1(challenges[0].Element(3))
+	c.logger.Info("Waiting for FinTS TAN confirmation in banking app", "challenge", challenge)
+
+	for {
+		if c.OnTanPending != nil {
+			c.OnTanPending(challenge)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(tanPollInterval):
+		}
+
+		resp, err := c.send(ctx, requestSegment{Type: "HKTAN", Version: 7, Elements: []string{"S", "", "", "", escape(taskReference), "N"}})
+		if err != nil {
+			return nil, err
+		}
+		if resp.message("3956") == nil {
+			return resp, nil
+		}
+	}
+}
+
+// selectTanMethod prefers decoupled methods among the methods allowed for the user.
+func (c *Client) selectTanMethod() TanMethod {
+	var selected *TanMethod
+	for _, securityFunction := range c.allowedSecurityFunctions {
+		if securityFunction == securityFunctionSingleStep {
+			continue
+		}
+		tanMethod, ok := c.tanMethods[securityFunction]
+		if !ok {
+			tanMethod = TanMethod{SecurityFunction: securityFunction, Name: securityFunction}
+		}
+		if tanMethod.Decoupled {
+			return tanMethod
+		}
+		if selected == nil {
+			selected = &tanMethod
+		}
+	}
+	if selected == nil {
+		return TanMethod{SecurityFunction: securityFunctionSingleStep, Name: "PIN only"}
+	}
+
+	return *selected
+}
+
+func (c *Client) tanMethodNames() string {
+	var names []string
+	for _, securityFunction := range c.allowedSecurityFunctions {
+		if tanMethod, ok := c.tanMethods[securityFunction]; ok {
+			names = append(names, tanMethod.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (c *Client) identification() requestSegment {
+	return requestSegment{Type: "HKIDN", Version: 2, Elements: []string{
+		deg("280", escape(c.bankCode)),
+		escape(toLatin1(c.userID)),
+		escape(c.systemID),
+		"1",
+	}}
+}
+
+func (c *Client) processingPreparation() requestSegment {
+	return requestSegment{Type: "HKVVB", Version: 3, Elements: []string{"0", "0", "0", escape(c.productID), escape(c.productVersion)}}
+}
+
+// tanSegment is the HKTAN of process 4, sent along with segmentType to announce strong customer authentication.
+func (c *Client) tanSegment(segmentType string) requestSegment {
+	elements := []string{"4", segmentType, "", "", "", "", "", "", "", "", escape(toLatin1(c.TanMedium))}
+	return requestSegment{Type: "HKTAN", Version: c.segmentVersion("HITANS", 7, 6), Elements: elements}
+}
+
+// segmentVersion returns the highest version of a business transaction supported by the bank and the client.
+func (c *Client) segmentVersion(parameterSegmentType string, maxVersion, defaultVersion int) int {
+	version := 0
+	for _, v := range c.parameterVersions[parameterSegmentType] {
+		if v <= maxVersion && v > version {
+			version = v
+		}
+	}
+	if version == 0 {
+		return defaultVersion
+	}
+	return version
+}
+
+func (c *Client) camtDescriptor() string {
+	for _, descriptor := range c.camtDescriptors {
+		if strings.Contains(descriptor, "camt.052") {
+			return descriptor
+		}
+	}
+	return defaultCamtDescriptor
+}
+
+// send sends a message with the given business segments and returns the parsed response.
+// Returns an error if the bank responds with an error code (9xxx).
+func (c *Client) send(ctx context.Context, segments ...requestSegment) (*response, error) {
+	c.messageNumber++
+	now := time.Now()
+	date := now.Format("20060102")
+	timeOfDay := now.Format("150405")
+	controlReference, err := rand.Int(rand.Reader, big.NewInt(9000000))
+	if err != nil {
+		return nil, err
+	}
+	reference := strconv.FormatInt(controlReference.Int64()+1000000, 10)
+	keyName := deg("280", escape(c.bankCode), escape(toLatin1(c.userID)))
+
+	var inner strings.Builder
+	inner.WriteString(segment("HNSHK", 2, 4,
+		deg("PIN", "2"), c.securityFunction, reference, "1", "1", deg("1", "", escape(c.systemID)), "1",
+		deg("1", date, timeOfDay), deg("1", "999", "1"), deg("6", "10", "16"), deg(keyName, "S", "0", "0"),
+	))
+	number := 3
+	for _, s := range segments {
+		inner.WriteString(segment(s.Type, number, s.Version, s.Elements...))
+		number++
+	}
+	inner.WriteString(segment("HNSHA", number, 2, reference, "", escape(toLatin1(c.pin))))
+	number++
+
+	body := segment("HNVSK", 998, 3,
+		deg("PIN", "2"), "998", "1", deg("1", "", escape(c.systemID)), deg("1", date, timeOfDay),
+		deg("2", "2", "13", binary([]byte("00000000")), "5", "1"), deg(keyName, "V", "0", "0"), "0",
+	) + segment("HNVSD", 999, 1, binary([]byte(inner.String())))
+	trailer := segment("HNHBS", number, 1, strconv.Itoa(c.messageNumber))
+	header := func(size int) string {
+		return segment("HNHBK", 1, 3, fmt.Sprintf("%012d", size), "300", escape(c.dialogID), strconv.Itoa(c.messageNumber))
+	}
+	message := header(len(header(0))+len(body)+len(trailer)) + body + trailer
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(base64.StdEncoding.EncodeToString([]byte(message))))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.StatusError(resp, "")
+	}
+	encodedResponse, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	decodedResponse, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(encodedResponse), nil)))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding FinTS response: %w", err)
+	}
+
+	return c.parseResponse(string(decodedResponse))
+}
+
+func (c *Client) parseResponse(message string) (*response, error) {
+	segments, err := ParseSegments(message)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing FinTS response: %w", err)
+	}
+
+	r := &response{}
+	for _, s := range segments {
+		if s.Type != "HNVSD" {
+			r.segments = append(r.segments, s)
+			continue
+		}
+		innerSegments, err := ParseSegments(s.Element(0))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing encrypted FinTS response: %w", err)
+		}
+		r.segments = append(r.segments, innerSegments...)
+	}
+
+	var errorMessages []string
+	for _, s := range r.segments {
+		switch {
+		case s.Type == "HNHBK":
+			if dialogID := s.Element(2); dialogID != "" && c.dialogID == "0" {
+				c.dialogID = dialogID
+			}
+		case s.Type == "HIRMG" || s.Type == "HIRMS":
+			for _, element := range s.Elements {
+				if len(element) < 3 {
+					continue
+				}
+				m := ReturnMessage{Code: element[0], Text: fromLatin1(element[2]), Parameters: element[3:]}
+				r.messages = append(r.messages, m)
+				if strings.HasPrefix(m.Code, "9") {
+					errorMessages = append(errorMessages, m.Code+" "+m.Text)
+				}
+				if m.Code == "3920" {
+					c.allowedSecurityFunctions = m.Parameters
+				}
+			}
+		case s.Type == "HIPINS":
+			c.parseTanRequirements(s)
+		case s.Type == "HITANS":
+			c.parseTanMethods(s)
+		case s.Type == "HICAZS":
+			for _, element := range s.Elements {
+				for _, component := range element {
+					if strings.Contains(component, "camt.") {
+						c.camtDescriptors = append(c.camtDescriptors, component)
+					}
+				}
+			}
+		}
+		if strings.HasPrefix(s.Type, "HI") && strings.HasSuffix(s.Type, "S") && len(s.Type) == 6 {
+			c.parameterVersions[s.Type] = append(c.parameterVersions[s.Type], s.Version)
+		}
+	}
+	for _, m := range r.messages {
+		c.logger.Debug("FinTS return message", "code", m.Code, "text", m.Text)
+	}
+	if len(errorMessages) > 0 {
+		return r, fmt.Errorf("bank error: %s", strings.Join(errorMessages, ", "))
+	}
+
+	return r, nil
+}
+
+// parseTanRequirements reads the business transactions requiring a TAN (pairs of segment type and J/N).
+func (c *Client) parseTanRequirements(s Segment) {
+	for _, element := range s.Elements {
+		for i := 0; i+1 < len(element); i++ {
+			if segmentTypePattern.MatchString(element[i]) {
+				c.tanRequired[element[i]] = element[i+1] == "J"
+			}
+		}
+	}
+}
+
+// parseTanMethods reads the two-step TAN methods. Each method starts with the security function, the TAN process,
+// the technical id, the DK TAN method (e.g. "Decoupled") and its version and the name.
+func (c *Client) parseTanMethods(s Segment) {
+	for _, element := range s.Elements {
+		for i := 0; i+5 < len(element); i++ {
+			if !securityFunctionRegex.MatchString(element[i]) || (element[i+1] != "1" && element[i+1] != "2") {
+				continue
+			}
+			c.tanMethods[element[i]] = TanMethod{
+				SecurityFunction: element[i],
+				ZkaID:            element[i+3],
+				Name:             fromLatin1(element[i+5]),
+				Decoupled:        strings.HasPrefix(strings.ToLower(element[i+3]), "decoupled"),
+			}
+			i += 5
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package fints
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+// fakeBank answers the requests of a decoupled TAN flow: synchronization, login confirmed in the app after one
+// status request, SEPA accounts and MT940 statements in two pages.
+type fakeBank struct {
+	t        *testing.T
+	requests [][]Segment
+	polls    int
+}
+
+func (b *fakeBank) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	message, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		b.t.Fatalf("invalid base64 request: %s", err)
+	}
+	segments, err := ParseSegments(string(message))
+	if err != nil {
+		b.t.Fatalf("invalid request: %s", err)
+	}
+	var inner []Segment
+	for _, s := range segments {
+		if s.Type == "HNVSD" {
+			inner, err = ParseSegments(s.Element(0))
+			if err != nil {
+				b.t.Fatalf("invalid encrypted request: %s", err)
+			}
+		}
+	}
+	b.requests = append(b.requests, inner)
+
+	has := func(segmentType string) *Segment {
+		for i := range inner {
+			if inner[i].Type == segmentType {
+				return &inner[i]
+			}
+		}
+		return nil
+	}
+	var response string
+	switch {
+	case has("HKSYN") != nil:
+		response = "HIRMG:2:2+0010::Nachricht entgegengenommen'" +
+			"HIRMS:3:2:4+3920::Zugelassene TAN-Verfahren:902:922'" +
+			"HITANS:4:7:4+1+1+0+N:N:0:902:2:CT:HHD:1.4:chipTAN optisch:6:1:TAN:999:N:1:N:0:2:N:J:00:2:N:9:::::922:2:pushTAN-dec:Decoupled::pushTAN 2.0:::Aufforderung:2048:N:1:N:0:2:N:J:00:2:N:2:180:1:1:J:J'" +
+			"HIPINS:5:1:4+1+1+0+5:20:6:Benutzer ID::HKSPA:N:HKKAZ:J'" +
+			"HIKAZS:6:7:4+1+1+0+90:N:N'" +
+			"HISYN:7:4:6+system-1'"
+	case has("HKIDN") != nil && has("HKTAN") != nil:
+		if has("HKIDN").Element(2) != "system-1" {
+			b.t.Errorf("expected the synchronized system id, got %s", has("HKIDN").Element(2))
+		}
+		response = "HIRMG:2:2+3060::Bitte beachten Sie die enthaltenen Warnungen'" +
+			"HIRMS:3:2:5+3955::Sicherheitsfreigabe erfolgt über anderen Kanal'" +
+			"HITAN:4:7:5+4++task-1+Bitte bestätigen Sie den Login in Ihrer App'"
+	case has("HKTAN") != nil && has("HKTAN").Element(0) == "S":
+		b.polls++
+		if has("HKTAN").Element(4) != "task-1" {
+			b.t.Errorf("expected task reference task-1, got %s", has("HKTAN").Element(4))
+		}
+		if b.polls == 1 {
+			response = "HIRMS:3:2:3+3956::Starke Kundenauthentifizierung noch ausstehend'"
+		} else {
+			response = "HIRMS:3:2:3+0020::Auftrag ausgeführt'"
+		}
+	case has("HKSPA") != nil:
+		response = "HIRMS:3:2:3+0020::Auftrag ausgeführt'" +
+			"HISPA:4:1:3+J:DE02120300000000202051:BYLADEM1001:0000202051::280:12030000+N:::0000202052::280:12030000'"
+	case has("HKKAZ") != nil:
+		if has("HKTAN") == nil {
+			b.t.Errorf("expected HKTAN along with HKKAZ, because HIPINS requires it")
+		}
+		if has("HKKAZ").Component(0, 0) != "DE02120300000000202051" {
+			b.t.Errorf("expected the account as KTI, got %v", has("HKKAZ").Elements[0])
+		}
+		if has("HKKAZ").Element(5) == "" {
+			mt940 := ":20:STARTUMS\r\n:61:page1\r\n"
+			response = "HIRMS:3:2:3+3040::Es liegen weitere Informationen vor:page-2'HIKAZ:4:7:3+" + binary([]byte(mt940)) + "'"
+		} else {
+			mt940 := ":61:page2\r\n-"
+			response = "HIRMS:3:2:3+0020::Auftrag ausgeführt'HIKAZ:4:7:3+" + binary([]byte(mt940)) + "'"
+		}
+	case has("HKEND") != nil:
+		response = "HIRMG:2:2+0100::Dialog beendet'"
+	default:
+		response = "HIRMG:2:2+9010::Unbekannter Auftrag'"
+	}
+
+	fullResponse := segment("HNHBK", 1, 3, "000000000000", "300", "dialog-1", "1") + segment("HNVSD", 999, 1, binary([]byte(response))) + segment("HNHBS", 5, 1, "1")
+	_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(fullResponse))))
+}
+
+func TestClientRetrievesStatementsWithDecoupledTan(t *testing.T) {
+	tanPollInterval = time.Millisecond
+	defer func() { tanPollInterval = 2 * time.Second }()
+
+	bank := &fakeBank{t: t}
+	server := httptest.NewServer(bank)
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := NewClient(logger, httpclient.New(logger, 5*time.Second, 0), server.URL, "12030000", "user", "12345", "PRODUCT", "1.0.0")
+	var challenges []string
+	client.OnTanPending = func(challenge string) {
+		challenges = append(challenges, challenge)
+	}
+
+	ctx := context.Background()
+	err := client.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.securityFunction != "922" {
+		t.Errorf("expected the decoupled TAN method 922, got %s", client.securityFunction)
+	}
+	if len(challenges) != 2 || !strings.Contains(challenges[0], "Login") {
+		t.Errorf("expected the challenge until the confirmation, got %v", challenges)
+	}
+
+	accounts, err := client.Accounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].BIC != "BYLADEM1001" || accounts[0].BankCode != "12030000" {
+		t.Fatalf("expected the SEPA account only, got %+v", accounts)
+	}
+
+	statements, err := client.Statements(ctx, accounts[0], FORMAT_MT940, time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 9, 30, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statements) != 1 || string(statements[0]) != ":20:STARTUMS\r\n:61:page1\r\n:61:page2\r\n-" {
+		t.Errorf("expected both pages joined into one statement, got %q", statements)
+	}
+	if err = client.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, request := range bank.requests {
+		signature := request[0]
+		if signature.Type != "HNSHK" {
+			t.Fatalf("expected a signature header, got %s", signature.Type)
+		}
+		last := request[len(request)-1]
+		if last.Type != "HNSHA" || last.Element(2) != "12345" {
+			t.Errorf("expected the PIN in the signature trailer, got %+v", last)
+		}
+	}
+}
+
+func TestClientFailsOnBankErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := segment("HNHBK", 1, 3, "000000000000", "300", "0", "1") + segment("HIRMG", 2, 2, deg("9910", "", "PIN falsch"))
+		_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(response))))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := NewClient(logger, httpclient.New(logger, 5*time.Second, 0), server.URL, "12030000", "user", "wrong", "PRODUCT", "1.0.0")
+	err := client.Connect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "9910 PIN falsch") {
+		t.Errorf("expected the bank error, got %v", err)
+	}
+}
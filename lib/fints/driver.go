@@ -0,0 +1,241 @@
+package fints
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/audit"
+	"buchhalter/lib/driver"
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/parser"
+	"buchhalter/lib/utils"
+	"buchhalter/lib/vault"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// DefaultMonths is the number of completed months statements are retrieved for.
+// Banks usually allow 90 days without strong customer authentication.
+const DefaultMonths = 3
+
+// FinTSDriver runs recipes of type "fints": the account statements of all SEPA accounts are retrieved from the FinTS
+// server of the bank and stored in the document archive like supplier invoices.
+// The username of the credentials is the FinTS login name, the password the PIN.
+type FinTSDriver struct {
+	logger          *slog.Logger
+	httpClient      *httpclient.Client
+	credentials     *vault.Credentials
+	documentArchive *archive.DocumentArchive
+
+	buchhalterDocumentsDirectory string
+
+	// ProductID is the FinTS product registration number of the Deutsche Kreditwirtschaft
+	ProductID string
+	// ProductVersion is sent along with the ProductID
+	ProductVersion string
+	// TanMedium is the name of the TAN medium, required by some banks for decoupled TAN methods
+	TanMedium string
+	// ShredTemporaryFiles overwrites downloaded files before they are removed from the downloads directory
+	ShredTemporaryFiles bool
+	// AuditLog records the credentials used by the steps (optional)
+	AuditLog *audit.Log
+
+	// supplier of the recipe that is currently executed
+	supplier string
+	// p shows the progress of the recipe that is currently executed, e.g. pending TAN confirmations
+	p *tea.Program
+
+	downloadsDirectory string
+	documentsDirectory string
+
+	recipeTimeout time.Duration
+	ctx           context.Context
+	newFilesCount int
+	// newFiles are the documents archived by the current step
+	newFiles []string
+}
+
+func NewFinTSDriver(ctx context.Context, logger *slog.Logger, httpClient *httpclient.Client, credentials *vault.Credentials, buchhalterDocumentsDirectory string, documentArchive *archive.DocumentArchive) *FinTSDriver {
+	return &FinTSDriver{
+		logger:          logger,
+		httpClient:      httpClient,
+		credentials:     credentials,
+		documentArchive: documentArchive,
+
+		buchhalterDocumentsDirectory: buchhalterDocumentsDirectory,
+
+		// Includes the time to confirm the login in the banking app
+		recipeTimeout: 300 * time.Second,
+		ctx:           ctx,
+		newFilesCount: 0,
+	}
+}
+
+func (d *FinTSDriver) RunRecipe(p *tea.Program, totalStepCount int, stepCountInCurrentRecipe int, baseCountStep int, recipe *parser.Recipe) utils.RecipeResult {
+	d.logger.Info("Starting FinTS driver ...", "supplier", recipe.Supplier, "recipe_version", recipe.Version)
+	d.supplier = recipe.Supplier
+	d.p = p
+
+	var err error
+	d.downloadsDirectory, d.documentsDirectory, err = utils.InitSupplierDirectories(d.buchhalterDocumentsDirectory, d.documentArchive.SupplierDirectory(recipe.Supplier), recipe.Supplier)
+	if err != nil {
+		return driver.ErrorResult(recipe.Supplier, err)
+	}
+	defer func() {
+		err := utils.RemoveTemporaryDirectory(d.downloadsDirectory, d.ShredTemporaryFiles)
+		if err != nil {
+			d.logger.Error("Error removing downloads directory", "directory", d.downloadsDirectory, "error", err)
+		}
+	}()
+
+	engine := driver.NewEngine(d.logger, d, d.recipeTimeout)
+	engine.Title = "Downloading statements from %s (%d/%d):"
+	return engine.Run(d.ctx, p, totalStepCount, stepCountInCurrentRecipe, baseCountStep, recipe)
+}
+
+func (d *FinTSDriver) RunStep(ctx context.Context, recipe *parser.Recipe, step parser.Step) utils.StepResult {
+	switch step.Action {
+	case "fints-statements":
+		return d.stepStatements(ctx, recipe, step)
+	}
+	return utils.StepResult{Status: "error", Message: "unknown action " + step.Action + " of FinTS recipe", Break: true}
+}
+
+func (d *FinTSDriver) NewFilesCount() int {
+	return d.newFilesCount
+}
+
+// Quit does nothing, the driver doesn't keep any resources between recipes.
+func (d *FinTSDriver) Quit() error {
+	return nil
+}
+
+// stepStatements retrieves the statements of the last completed months (step.Fints.Months) of all SEPA accounts.
+// Statements of a month are requested for the whole month, so unchanged statements are recognized by their checksum.
+func (d *FinTSDriver) stepStatements(ctx context.Context, recipe *parser.Recipe, step parser.Step) utils.StepResult {
+	d.logger.Debug("Executing recipe step", "action", step.Action, "url", step.URL, "bank_code", step.Fints.BankCode)
+	d.newFiles = nil
+
+	if step.URL == "" || step.Fints.BankCode == "" {
+		return utils.StepResult{Status: "error", Message: "the FinTS recipe step requires a url and a bankCode", Break: true}
+	}
+	if d.ProductID == "" {
+		return utils.StepResult{Status: "error", Message: "FinTS requires a product registration number, set buchhalter_fints_product_id", Break: true}
+	}
+	format := step.Fints.Format
+	if format == "" {
+		format = FORMAT_MT940
+	}
+	if format != FORMAT_MT940 && format != FORMAT_CAMT {
+		return utils.StepResult{Status: "error", Message: "unknown statement format " + format, Break: true}
+	}
+	months := step.Fints.Months
+	if months <= 0 {
+		months = DefaultMonths
+	}
+
+	for _, field := range []string{"username", "password"} {
+		d.AuditLog.Record(audit.Event{Kind: audit.KIND_CREDENTIAL, Field: field, Source: d.credentials.Id, Supplier: d.supplier, Step: step.Action})
+	}
+	client := NewClient(d.logger, d.httpClient, step.URL, step.Fints.BankCode, d.credentials.Username, d.credentials.Password, d.ProductID, d.ProductVersion)
+	client.TanMedium = d.TanMedium
+	client.OnTanPending = func(challenge string) {
+		description := "Please confirm the access in your banking app"
+		if challenge != "" {
+			description = challenge
+		}
+		d.p.Send(utils.ViewMsgStatusAndDescriptionUpdate{
+			Title:       i18n.Tf("Waiting for confirmation of %s:", recipe.Supplier),
+			Description: description,
+		})
+	}
+
+	err := client.Connect(ctx)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: err.Error(), Break: true}
+	}
+	defer func() {
+		// The dialog is ended even if the step timed out
+		closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		err := client.Close(closeCtx)
+		if err != nil {
+			d.logger.Error("Error ending FinTS dialog", "supplier", d.supplier, "error", err)
+		}
+	}()
+
+	accounts, err := client.Accounts(ctx)
+	if err != nil {
+		return utils.StepResult{Status: "error", Message: "error retrieving accounts: " + err.Error(), Break: true}
+	}
+	d.logger.Info("FinTS accounts retrieved", "supplier", d.supplier, "num_accounts", len(accounts))
+
+	currentMonth := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.Local)
+	for _, account := range accounts {
+		for m := months; m >= 1; m-- {
+			start := currentMonth.AddDate(0, -m, 0)
+			end := start.AddDate(0, 1, -1)
+			statements, err := client.Statements(ctx, account, format, start, end)
+			if err != nil {
+				return utils.StepResult{Status: "error", Message: fmt.Sprintf("error retrieving statements of %s: %s", account.IBAN, err), Break: true}
+			}
+			for i, statement := range statements {
+				fileName := fmt.Sprintf("%s-%s.sta", account.IBAN, start.Format("2006-01"))
+				if format == FORMAT_CAMT {
+					fileName = fmt.Sprintf("%s-%s-%d.xml", account.IBAN, start.Format("2006-01"), i+1)
+				}
+				err = d.archiveStatement(fileName, statement)
+				if err != nil {
+					return utils.StepResult{Status: "error", Message: err.Error(), Artifacts: utils.StepArtifacts{Files: d.newFiles}}
+				}
+			}
+		}
+	}
+
+	return utils.StepResult{Status: "success", Artifacts: utils.StepArtifacts{Files: d.newFiles}}
+}
+
+// archiveStatement adds the statement to the document archive, unless the archive contains it already.
+func (d *FinTSDriver) archiveStatement(fileName string, statement []byte) error {
+	fileName = strings.ReplaceAll(fileName, string(filepath.Separator), "_")
+	f := filepath.Join(d.downloadsDirectory, fileName)
+	err := os.WriteFile(f, statement, 0600)
+	if err != nil {
+		return err
+	}
+	if d.documentArchive.FileExists(f) {
+		return nil
+	}
+
+	// A statement of the same month may have changed (e.g. late bookings), the previous version is kept
+	dstFile := filepath.Join(d.documentsDirectory, fileName)
+	extension := filepath.Ext(fileName)
+	for i := 2; fileExists(dstFile); i++ {
+		dstFile = filepath.Join(d.documentsDirectory, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(fileName, extension), i, extension))
+	}
+	_, err = utils.CopyFile(f, dstFile)
+	if err != nil {
+		return fmt.Errorf("error while copying file: %w", err)
+	}
+	err = d.documentArchive.AddFile(dstFile, d.supplier)
+	if err != nil {
+		return fmt.Errorf("error while adding file %s to document archive: %w", dstFile, err)
+	}
+	d.newFilesCount++
+	d.newFiles = append(d.newFiles, dstFile)
+	d.logger.Info("New account statement archived", "supplier", d.supplier, "file", dstFile)
+
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,178 @@
+package fints
+
+// Encoding and parsing of FinTS 3.0 messages (see https://www.hbci-zka.de/spec/3_0.htm, "Formals").
+// A message consists of segments terminated by `'`, data elements are separated by `+`, components of data element
+// groups by `:`. Syntax characters are escaped with `?`, binary data is prefixed with its length (`@<length>@`).
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Segment is a parsed segment. Elements are the data elements, each with its components.
+// Binary data elements are kept as they are (Go strings may hold arbitrary bytes).
+type Segment struct {
+	Type      string
+	Number    int
+	Version   int
+	Reference int
+	Elements  [][]string
+}
+
+// Element returns the first component of the data element at index (starting with 0 after the segment header).
+func (s Segment) Element(index int) string {
+	return s.Component(index, 0)
+}
+
+// Component returns a component of the data element group at index, or an empty string.
+func (s Segment) Component(index, component int) string {
+	if index >= len(s.Elements) || component >= len(s.Elements[index]) {
+		return ""
+	}
+	return s.Elements[index][component]
+}
+
+// escape escapes the syntax characters of a text value.
+func escape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '?', '@', '\'', '+', ':':
+			b.WriteByte('?')
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// binary encodes a binary data element.
+func binary(value []byte) string {
+	return "@" + strconv.Itoa(len(value)) + "@" + string(value)
+}
+
+// segment encodes a segment. The elements must be encoded (escaped) already, trailing empty elements are dropped.
+func segment(segmentType string, number, version int, elements ...string) string {
+	for len(elements) > 0 && elements[len(elements)-1] == "" {
+		elements = elements[:len(elements)-1]
+	}
+
+	header := fmt.Sprintf("%s:%d:%d", segmentType, number, version)
+	if len(elements) == 0 {
+		return header + "'"
+	}
+	return header + "+" + strings.Join(elements, "+") + "'"
+}
+
+// deg joins the (encoded) components of a data element group.
+func deg(components ...string) string {
+	return strings.Join(components, ":")
+}
+
+// ParseSegments parses the segments of a message.
+func ParseSegments(message string) ([]Segment, error) {
+	var segments []Segment
+
+	var elements [][]string
+	var components []string
+	var value strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		switch c {
+		case '?':
+			if i+1 < len(message) {
+				i++
+				value.WriteByte(message[i])
+			}
+		case '@':
+			end := strings.IndexByte(message[i+1:], '@')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid binary data at position %d", i)
+			}
+			length, err := strconv.Atoi(message[i+1 : i+1+end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid binary data length at position %d", i)
+			}
+			start := i + 1 + end + 1
+			if start+length > len(message) {
+				return nil, fmt.Errorf("binary data at position %d exceeds the message", i)
+			}
+			value.WriteString(message[start : start+length])
+			i = start + length - 1
+		case ':':
+			components = append(components, value.String())
+			value.Reset()
+		case '+':
+			components = append(components, value.String())
+			value.Reset()
+			elements = append(elements, components)
+			components = nil
+		case '\'':
+			components = append(components, value.String())
+			value.Reset()
+			elements = append(elements, components)
+			components = nil
+
+			s, err := newSegment(elements)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, s)
+			elements = nil
+		default:
+			value.WriteByte(c)
+		}
+	}
+	if value.Len() > 0 || len(components) > 0 || len(elements) > 0 {
+		return nil, fmt.Errorf("unterminated segment at the end of the message")
+	}
+
+	return segments, nil
+}
+
+func newSegment(elements [][]string) (Segment, error) {
+	header := elements[0]
+	if len(header) < 3 {
+		return Segment{}, fmt.Errorf("invalid segment header %s", strings.Join(header, ":"))
+	}
+	number, err := strconv.Atoi(header[1])
+	if err != nil {
+		return Segment{}, fmt.Errorf("invalid segment number %s", header[1])
+	}
+	version, err := strconv.Atoi(header[2])
+	if err != nil {
+		return Segment{}, fmt.Errorf("invalid segment version %s", header[2])
+	}
+	s := Segment{
+		Type:     header[0],
+		Number:   number,
+		Version:  version,
+		Elements: elements[1:],
+	}
+	if len(header) > 3 && header[3] != "" {
+		s.Reference, _ = strconv.Atoi(header[3])
+	}
+
+	return s, nil
+}
+
+// toLatin1 converts text to ISO 8859-1, the character set of FinTS. Characters outside of it are replaced with `?`.
+func toLatin1(value string) string {
+	b := make([]byte, 0, len(value))
+	for _, r := range value {
+		if r > 0xff {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
+
+// fromLatin1 converts ISO 8859-1 text to UTF-8.
+func fromLatin1(value string) string {
+	runes := make([]rune, len(value))
+	for i := 0; i < len(value); i++ {
+		runes[i] = rune(value[i])
+	}
+	return string(runes)
+}
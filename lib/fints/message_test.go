@@ -0,0 +1,64 @@
+package fints
+
+import (
+	"testing"
+)
+
+func TestParseSegments(t *testing.T) {
+	message := "HNHBK:1:3+000000000100+300+dialog?+1+1'" +
+		"HIRMS:4:2:3+3920::Zugelassene TAN-Verfahren:922:999+0020::Auftrag ausgeführt'" +
+		"HIKAZ:5:7:3+@12@:20:STAR'T+:+'"
+
+	segments, err := ParseSegments(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(segments))
+	}
+	if segments[0].Type != "HNHBK" || segments[0].Number != 1 || segments[0].Version != 3 {
+		t.Errorf("unexpected header %+v", segments[0])
+	}
+	if segments[0].Element(2) != "dialog+1" {
+		t.Errorf("expected escaped dialog id dialog+1, got %s", segments[0].Element(2))
+	}
+	if segments[1].Reference != 3 {
+		t.Errorf("expected reference 3, got %d", segments[1].Reference)
+	}
+	if got := segments[1].Elements[0]; len(got) != 5 || got[3] != "922" || got[4] != "999" {
+		t.Errorf("unexpected return message %v", got)
+	}
+	if segments[2].Element(0) != ":20:STAR'T+:" {
+		t.Errorf("expected binary data to be kept as is, got %q", segments[2].Element(0))
+	}
+}
+
+func TestParseSegmentsRejectsTruncatedMessages(t *testing.T) {
+	for _, message := range []string{"HIKAZ:5:7+@20@short'", "HIRMG:2:2+0010", "HI:x:1'"} {
+		if _, err := ParseSegments(message); err == nil {
+			t.Errorf("expected error parsing %q", message)
+		}
+	}
+}
+
+func TestSegmentEscapesAndDropsTrailingElements(t *testing.T) {
+	got := segment("HKTAN", 5, 7, "4", "HKIDN", "", "", escape("pushTAN: my+phone"), "", "")
+	want := "HKTAN:5:7+4+HKIDN+++pushTAN?: my?+phone'"
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	segments, err := ParseSegments(got + segment("HNVSD", 999, 1, binary([]byte("a'b"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments[0].Element(4) != "pushTAN: my+phone" || segments[1].Element(0) != "a'b" {
+		t.Errorf("unexpected round trip %+v", segments)
+	}
+}
+
+func TestLatin1(t *testing.T) {
+	if got := fromLatin1(toLatin1("Müller €")); got != "Müller ?" {
+		t.Errorf("unexpected conversion %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+package fixture
+
+// Fixtures are recordings of the network traffic and DOM snapshots of a successful recipe run.
+// Replaying a recipe against its fixture detects recipe regressions without hitting the real supplier (e.g. in CI).
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"buchhalter/lib/redact"
+)
+
+// skippedHeaders are not recorded, because they contain session secrets
+// or don't match the recorded (decoded) body anymore
+var skippedHeaders = map[string]bool{
+	"authorization":       true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"proxy-authorization": true,
+	"content-encoding":    true,
+	"content-length":      true,
+}
+
+type Fixture struct {
+	Supplier      string     `json:"supplier"`
+	RecipeVersion string     `json:"recipeVersion"`
+	RecordedAt    time.Time  `json:"recordedAt"`
+	Exchanges     []Exchange `json:"exchanges"`
+	Snapshots     []Snapshot `json:"snapshots"`
+}
+
+// Exchange is a recorded response of a request.
+type Exchange struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    []byte            `json:"body"`
+}
+
+// Snapshot is the DOM of the page after a recipe step.
+type Snapshot struct {
+	Step   int    `json:"step"`
+	Action string `json:"action"`
+	URL    string `json:"url"`
+	HTML   string `json:"html"`
+}
+
+// Load reads a fixture file.
+func Load(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f Fixture
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Lookup returns the recorded response of a request.
+// If a URL was requested multiple times, the first response is used. Query parameters are compared without order.
+func (f *Fixture) Lookup(method, requestURL string) (*Exchange, bool) {
+	// Recorded URLs are redacted, so the requested one has to be as well
+	key := requestKey(method, redact.URL(requestURL))
+	for i := range f.Exchanges {
+		if requestKey(f.Exchanges[i].Method, f.Exchanges[i].URL) == key {
+			return &f.Exchanges[i], true
+		}
+	}
+	return nil, false
+}
+
+func requestKey(method, requestURL string) string {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return strings.ToUpper(method) + " " + requestURL
+	}
+	u.Fragment = ""
+	u.RawQuery = u.Query().Encode()
+	return strings.ToUpper(method) + " " + u.String()
+}
+
+// Recorder collects the exchanges and snapshots of a recipe run. It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+func NewRecorder(supplier, recipeVersion string) *Recorder {
+	return &Recorder{
+		fixture: Fixture{
+			Supplier:      supplier,
+			RecipeVersion: recipeVersion,
+			RecordedAt:    time.Now(),
+		},
+	}
+}
+
+// AddExchange records a response. Sensitive headers are dropped and known secrets are redacted from the body.
+func (r *Recorder) AddExchange(method, requestURL string, status int, headers map[string]string, body []byte) {
+	recordedHeaders := map[string]string{}
+	for name, value := range headers {
+		if skippedHeaders[strings.ToLower(name)] {
+			continue
+		}
+		recordedHeaders[http.CanonicalHeaderKey(name)] = value
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fixture.Exchanges = append(r.fixture.Exchanges, Exchange{
+		Method:  method,
+		URL:     redact.URL(requestURL),
+		Status:  status,
+		Headers: recordedHeaders,
+		Body:    redactBody(body, recordedHeaders["Content-Type"]),
+	})
+}
+
+// AddSnapshot records the DOM of the page after a step.
+func (r *Recorder) AddSnapshot(step int, action, pageURL, html string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fixture.Snapshots = append(r.fixture.Snapshots, Snapshot{
+		Step:   step,
+		Action: action,
+		URL:    redact.URL(pageURL),
+		HTML:   redact.Text(html),
+	})
+}
+
+// Save writes the fixture to path.
+func (r *Recorder) Save(path string) error {
+	r.mu.Lock()
+	f := r.fixture
+	f.Exchanges = append([]Exchange(nil), r.fixture.Exchanges...)
+	f.Snapshots = append([]Snapshot(nil), r.fixture.Snapshots...)
+	r.mu.Unlock()
+
+	// Stable order makes fixtures diffable
+	sort.SliceStable(f.Snapshots, func(i, j int) bool { return f.Snapshots[i].Step < f.Snapshots[j].Step })
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// redactBody redacts known secrets of text responses. Binary responses (e.g. PDFs) are kept as they are.
+func redactBody(body []byte, contentType string) []byte {
+	if contentType == "" || strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "json") || strings.Contains(contentType, "javascript") || strings.Contains(contentType, "xml") {
+		return []byte(redact.Text(string(body)))
+	}
+	return body
+}
@@ -0,0 +1,56 @@
+package fixture
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndLookup(t *testing.T) {
+	r := NewRecorder("hetzner", "1.0.0")
+	r.AddExchange("GET", "https://accounts.hetzner.com/invoices?page=1&sort=date", 200, map[string]string{
+		"content-type":     "text/html",
+		"set-cookie":       "session=secret",
+		"content-encoding": "gzip",
+	}, []byte("<html>invoices</html>"))
+	r.AddSnapshot(2, "click", "https://accounts.hetzner.com/invoices", "<html>invoices</html>")
+	r.AddSnapshot(1, "open", "https://accounts.hetzner.com/login", "<html>login</html>")
+
+	path := filepath.Join(t.TempDir(), "hetzner.json")
+	err := r.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Supplier != "hetzner" || f.RecipeVersion != "1.0.0" {
+		t.Errorf("unexpected fixture metadata: %s %s", f.Supplier, f.RecipeVersion)
+	}
+	if len(f.Snapshots) != 2 || f.Snapshots[0].Step != 1 {
+		t.Errorf("expected snapshots ordered by step, got %+v", f.Snapshots)
+	}
+
+	// Query parameters are compared without order
+	exchange, ok := f.Lookup("get", "https://accounts.hetzner.com/invoices?sort=date&page=1#top")
+	if !ok {
+		t.Fatalf("expected recorded exchange to be found")
+	}
+	if string(exchange.Body) != "<html>invoices</html>" {
+		t.Errorf("unexpected body %q", exchange.Body)
+	}
+	if _, ok := exchange.Headers["Set-Cookie"]; ok {
+		t.Errorf("expected cookies not to be recorded")
+	}
+	if _, ok := exchange.Headers["Content-Encoding"]; ok {
+		t.Errorf("expected content encoding not to be recorded")
+	}
+	if exchange.Headers["Content-Type"] != "text/html" {
+		t.Errorf("expected content type to be recorded, got %q", exchange.Headers["Content-Type"])
+	}
+
+	if _, ok := f.Lookup("POST", "https://accounts.hetzner.com/invoices?page=1&sort=date"); ok {
+		t.Errorf("expected requests with other methods not to match")
+	}
+}
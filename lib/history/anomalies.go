@@ -0,0 +1,114 @@
+package history
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ANOMALY_OVERDUE = "overdue"
+	ANOMALY_SPIKE   = "spike"
+
+	// overdueTolerance is the share of the cadence a document may be late (e.g. invoices issued a few days later than usual)
+	overdueTolerance = 0.25
+	// A spike is a run with spikeFactor times more new documents than usual, but at least spikeMinimumFiles
+	spikeFactor       = 3
+	spikeMinimumFiles = 5
+	// spikeMinimumRuns is the number of previous runs with new documents needed to know what is usual
+	spikeMinimumRuns = 3
+)
+
+// Anomaly is an unexpected number of documents of a supplier, which often hints to a silently broken recipe.
+type Anomaly struct {
+	Supplier string
+	Kind     string
+	Message  string
+}
+
+// ParseCadence returns the expected time between two documents of a supplier.
+// Supported are `daily`, `weekly`, `monthly`, `quarterly`, `yearly`, days (e.g. `14d`) and Go durations (e.g. `36h`).
+func ParseCadence(cadence string) (time.Duration, error) {
+	day := 24 * time.Hour
+	switch strings.ToLower(strings.TrimSpace(cadence)) {
+	case "daily":
+		return day, nil
+	case "weekly":
+		return 7 * day, nil
+	case "monthly":
+		return 31 * day, nil
+	case "quarterly":
+		return 92 * day, nil
+	case "yearly":
+		return 366 * day, nil
+	}
+
+	if days, ok := strings.CutSuffix(cadence, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n > 0 {
+			return time.Duration(n) * day, nil
+		}
+	}
+	duration, err := time.ParseDuration(cadence)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid cadence %q (use daily, weekly, monthly, quarterly, yearly or e.g. 14d)", cadence)
+	}
+	return duration, nil
+}
+
+// DetectAnomalies checks the suppliers of the last run in runs.
+// A supplier is overdue if its last document (lastDocumentAt) is older than its expected cadence.
+// A spike is a run with far more new documents than the previous runs of the supplier produced.
+func DetectAnomalies(runs []Run, lastDocumentAt map[string]time.Time, cadences map[string]time.Duration, now time.Time) []Anomaly {
+	var anomalies []Anomaly
+	if len(runs) == 0 {
+		return anomalies
+	}
+
+	lastRun := runs[len(runs)-1]
+	for _, supplierRun := range lastRun.Suppliers {
+		cadence, ok := cadences[supplierRun.Supplier]
+		lastDocument := lastDocumentAt[supplierRun.Supplier]
+		// Without any known document, there is nothing to compare with
+		if ok && !lastDocument.IsZero() {
+			age := now.Sub(lastDocument)
+			if age > cadence+time.Duration(float64(cadence)*overdueTolerance) {
+				anomalies = append(anomalies, Anomaly{
+					Supplier: supplierRun.Supplier,
+					Kind:     ANOMALY_OVERDUE,
+					Message:  fmt.Sprintf("No new document of %s for %d days (expected every %d days)", supplierRun.Supplier, int(age.Hours()/24), int(cadence.Hours()/24)),
+				})
+			}
+		}
+
+		usual, count := usualNewFiles(runs[:len(runs)-1], supplierRun.Supplier)
+		if count >= spikeMinimumRuns && supplierRun.NewFiles >= spikeMinimumFiles && float64(supplierRun.NewFiles) > spikeFactor*usual {
+			anomalies = append(anomalies, Anomaly{
+				Supplier: supplierRun.Supplier,
+				Kind:     ANOMALY_SPIKE,
+				Message:  fmt.Sprintf("%s produced %d new documents (usually %.1f)", supplierRun.Supplier, supplierRun.NewFiles, usual),
+			})
+		}
+	}
+
+	return anomalies
+}
+
+// usualNewFiles returns the average number of new documents of the runs of supplier that produced any and the number of these runs.
+func usualNewFiles(runs []Run, supplier string) (float64, int) {
+	total, count := 0, 0
+	for _, run := range runs {
+		for _, supplierRun := range run.Suppliers {
+			if supplierRun.Supplier != supplier || supplierRun.NewFiles == 0 {
+				continue
+			}
+			total += supplierRun.NewFiles
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return float64(total) / float64(count), count
+}
@@ -0,0 +1,52 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCadence(t *testing.T) {
+	tests := map[string]time.Duration{
+		"monthly": 31 * 24 * time.Hour,
+		"14d":     14 * 24 * time.Hour,
+		"36h":     36 * time.Hour,
+	}
+	for cadence, expected := range tests {
+		duration, err := ParseCadence(cadence)
+		if err != nil || duration != expected {
+			t.Errorf("expected %s for %s, got %s (%v)", expected, cadence, duration, err)
+		}
+	}
+	if _, err := ParseCadence("sometimes"); err == nil {
+		t.Error("expected error for invalid cadence")
+	}
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	now := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	runs := []Run{
+		{Suppliers: []SupplierRun{{Supplier: "acme", NewFiles: 1}, {Supplier: "bulk", NewFiles: 2}}},
+		{Suppliers: []SupplierRun{{Supplier: "acme", NewFiles: 1}, {Supplier: "bulk", NewFiles: 2}}},
+		{Suppliers: []SupplierRun{{Supplier: "acme", NewFiles: 0}, {Supplier: "bulk", NewFiles: 1}}},
+		{Suppliers: []SupplierRun{{Supplier: "acme", NewFiles: 0}, {Supplier: "bulk", NewFiles: 12}, {Supplier: "fresh", NewFiles: 0}}},
+	}
+	lastDocumentAt := map[string]time.Time{
+		"acme":  now.AddDate(0, 0, -60),
+		"fresh": now.AddDate(0, 0, -10),
+	}
+	cadences := map[string]time.Duration{
+		"acme":  31 * 24 * time.Hour,
+		"fresh": 31 * 24 * time.Hour,
+	}
+
+	anomalies := DetectAnomalies(runs, lastDocumentAt, cadences, now)
+	if len(anomalies) != 2 {
+		t.Fatalf("expected 2 anomalies, got %+v", anomalies)
+	}
+	if anomalies[0].Supplier != "acme" || anomalies[0].Kind != ANOMALY_OVERDUE {
+		t.Errorf("expected acme to be overdue, got %+v", anomalies[0])
+	}
+	if anomalies[1].Supplier != "bulk" || anomalies[1].Kind != ANOMALY_SPIKE {
+		t.Errorf("expected spike of bulk, got %+v", anomalies[1])
+	}
+}
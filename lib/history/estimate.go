@@ -0,0 +1,82 @@
+package history
+
+import "time"
+
+// defaultStepDuration is the estimated duration of a recipe step without any history
+const defaultStepDuration = 5 * time.Second
+
+// Estimator estimates the duration of recipe steps by their average duration in the run history.
+type Estimator struct {
+	// steps contains the average duration (in seconds) per supplier and step number
+	steps map[string]map[int]float64
+	// suppliers contains the average duration (in seconds) of all steps of a supplier
+	suppliers map[string]float64
+	// all is the average duration (in seconds) of all steps
+	all float64
+}
+
+// NewEstimator creates an estimator from the successful steps of runs.
+func NewEstimator(runs []Run) *Estimator {
+	type average struct {
+		sum   float64
+		count int
+	}
+	steps := map[string]map[int]*average{}
+	suppliers := map[string]*average{}
+	all := &average{}
+	for _, run := range runs {
+		for _, supplierRun := range run.Suppliers {
+			for _, step := range supplierRun.Steps {
+				// Failed and timed out steps don't tell how long a step usually takes
+				if step.Status != "success" {
+					continue
+				}
+				if steps[supplierRun.Supplier] == nil {
+					steps[supplierRun.Supplier] = map[int]*average{}
+					suppliers[supplierRun.Supplier] = &average{}
+				}
+				if steps[supplierRun.Supplier][step.Number] == nil {
+					steps[supplierRun.Supplier][step.Number] = &average{}
+				}
+				for _, a := range []*average{steps[supplierRun.Supplier][step.Number], suppliers[supplierRun.Supplier], all} {
+					a.sum += step.Duration
+					a.count++
+				}
+			}
+		}
+	}
+
+	e := &Estimator{steps: map[string]map[int]float64{}, suppliers: map[string]float64{}}
+	for supplier, numbers := range steps {
+		e.steps[supplier] = map[int]float64{}
+		for number, a := range numbers {
+			e.steps[supplier][number] = a.sum / float64(a.count)
+		}
+		e.suppliers[supplier] = suppliers[supplier].sum / float64(suppliers[supplier].count)
+	}
+	if all.count > 0 {
+		e.all = all.sum / float64(all.count)
+	}
+	return e
+}
+
+// StepDurations returns the estimated duration of each step (numbered from 1) of a supplier recipe with numSteps steps.
+// Steps without history are estimated with the average step duration of the supplier, of all suppliers or a default.
+func (e *Estimator) StepDurations(supplier string, numSteps int) []time.Duration {
+	durations := make([]time.Duration, numSteps)
+	for i := range durations {
+		seconds, ok := e.steps[supplier][i+1]
+		if !ok {
+			seconds, ok = e.suppliers[supplier]
+		}
+		if !ok && e.all > 0 {
+			seconds, ok = e.all, true
+		}
+		if !ok {
+			durations[i] = defaultStepDuration
+			continue
+		}
+		durations[i] = time.Duration(seconds * float64(time.Second))
+	}
+	return durations
+}
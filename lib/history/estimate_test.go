@@ -0,0 +1,51 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEstimatorStepDurations(t *testing.T) {
+	runs := []Run{
+		{Suppliers: []SupplierRun{
+			{Supplier: "hetzner", Steps: []StepRun{
+				{Number: 1, Status: "success", Duration: 2},
+				{Number: 2, Status: "success", Duration: 10},
+			}},
+		}},
+		{Suppliers: []SupplierRun{
+			{Supplier: "hetzner", Steps: []StepRun{
+				{Number: 1, Status: "success", Duration: 4},
+				// Timeouts don't count
+				{Number: 2, Status: "timeout", Duration: 60},
+			}},
+			{Supplier: "github", Steps: []StepRun{
+				{Number: 1, Status: "success", Duration: 8},
+			}},
+		}},
+	}
+	estimator := NewEstimator(runs)
+
+	tests := []struct {
+		supplier string
+		numSteps int
+		expected []time.Duration
+	}{
+		// Step 3 has no history, the average step of hetzner is used
+		{"hetzner", 3, []time.Duration{3 * time.Second, 10 * time.Second, 16 * time.Second / 3}},
+		// Suppliers without history get the average step of all suppliers
+		{"aws", 2, []time.Duration{6 * time.Second, 6 * time.Second}},
+	}
+	for _, tt := range tests {
+		durations := estimator.StepDurations(tt.supplier, tt.numSteps)
+		if !reflect.DeepEqual(durations, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.supplier, tt.expected, durations)
+		}
+	}
+
+	durations := NewEstimator(nil).StepDurations("aws", 2)
+	if !reflect.DeepEqual(durations, []time.Duration{defaultStepDuration, defaultStepDuration}) {
+		t.Errorf("expected the default step duration without history, got %v", durations)
+	}
+}
@@ -0,0 +1,46 @@
+package history
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	FREQUENCY_WEEKLY  = "weekly"
+	FREQUENCY_MONTHLY = "monthly"
+)
+
+// SyncedInPeriod reports whether lastSync is in the same period as now.
+// Periods are calendar weeks (ISO 8601) for `weekly` and calendar months for `monthly`, in the time zone of now.
+func SyncedInPeriod(frequency string, lastSync, now time.Time) (bool, error) {
+	if lastSync.IsZero() {
+		return false, nil
+	}
+	lastSync = lastSync.In(now.Location())
+
+	switch frequency {
+	case FREQUENCY_WEEKLY:
+		lastYear, lastWeek := lastSync.ISOWeek()
+		year, week := now.ISOWeek()
+		return lastYear == year && lastWeek == week, nil
+	case FREQUENCY_MONTHLY:
+		return lastSync.Year() == now.Year() && lastSync.Month() == now.Month(), nil
+	}
+	return false, fmt.Errorf("invalid frequency %q (use %s or %s)", frequency, FREQUENCY_WEEKLY, FREQUENCY_MONTHLY)
+}
+
+// LastSuccessfulSyncs returns the start of the last run per supplier that completed successfully (incl. warnings).
+func LastSuccessfulSyncs(runs []Run) map[string]time.Time {
+	lastSyncs := map[string]time.Time{}
+	for _, run := range runs {
+		for _, supplierRun := range run.Suppliers {
+			if supplierRun.Status != "success" && supplierRun.Status != "warning" {
+				continue
+			}
+			if run.StartedAt.After(lastSyncs[supplierRun.Supplier]) {
+				lastSyncs[supplierRun.Supplier] = run.StartedAt
+			}
+		}
+	}
+	return lastSyncs
+}
@@ -0,0 +1,60 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncedInPeriod(t *testing.T) {
+	// Wednesday of ISO week 38
+	now := time.Date(2024, time.September, 18, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		frequency string
+		lastSync  time.Time
+		synced    bool
+	}{
+		{"never synced", FREQUENCY_MONTHLY, time.Time{}, false},
+		{"same month", FREQUENCY_MONTHLY, time.Date(2024, time.September, 1, 8, 0, 0, 0, time.UTC), true},
+		{"previous month", FREQUENCY_MONTHLY, time.Date(2024, time.August, 31, 23, 0, 0, 0, time.UTC), false},
+		{"same month of previous year", FREQUENCY_MONTHLY, time.Date(2023, time.September, 18, 9, 0, 0, 0, time.UTC), false},
+		{"same week", FREQUENCY_WEEKLY, time.Date(2024, time.September, 16, 7, 0, 0, 0, time.UTC), true},
+		{"previous week", FREQUENCY_WEEKLY, time.Date(2024, time.September, 15, 23, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synced, err := SyncedInPeriod(tt.frequency, tt.lastSync, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if synced != tt.synced {
+				t.Errorf("expected synced=%t, got %t", tt.synced, synced)
+			}
+		})
+	}
+
+	if _, err := SyncedInPeriod("daily", now, now); err == nil {
+		t.Error("expected an error for an unsupported frequency")
+	}
+}
+
+func TestLastSuccessfulSyncs(t *testing.T) {
+	first := time.Date(2024, time.September, 1, 8, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+	runs := []Run{
+		{StartedAt: first, Suppliers: []SupplierRun{{Supplier: "hetzner", Status: "success"}, {Supplier: "github", Status: "warning"}}},
+		{StartedAt: second, Suppliers: []SupplierRun{{Supplier: "hetzner", Status: "success"}, {Supplier: "github", Status: "error"}}},
+	}
+
+	lastSyncs := LastSuccessfulSyncs(runs)
+	if !lastSyncs["hetzner"].Equal(second) {
+		t.Errorf("expected the last sync of hetzner at %s, got %s", second, lastSyncs["hetzner"])
+	}
+	if !lastSyncs["github"].Equal(first) {
+		t.Errorf("expected the last sync of github at %s, got %s", first, lastSyncs["github"])
+	}
+	if _, ok := lastSyncs["aws"]; ok {
+		t.Error("expected no sync of aws")
+	}
+}
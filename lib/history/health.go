@@ -0,0 +1,51 @@
+package history
+
+import (
+	"sort"
+	"time"
+)
+
+// Health summarizes the run history for health checks (e.g. of `buchhalter serve`).
+type Health struct {
+	LastRunAt time.Time
+	// LastSuccessfulRunAt is the start of the last run without failed suppliers
+	LastSuccessfulRunAt time.Time
+	// FailingSuppliers are the suppliers which failed in their most recent run, sorted by name
+	FailingSuppliers []string
+}
+
+// CheckHealth returns the health of the runs.
+func CheckHealth(runs []Run) Health {
+	var health Health
+	lastResults := map[string]SupplierRun{}
+	lastResultAt := map[string]time.Time{}
+	for _, run := range runs {
+		if run.StartedAt.After(health.LastRunAt) {
+			health.LastRunAt = run.StartedAt
+		}
+
+		successful := true
+		for _, supplierRun := range run.Suppliers {
+			if supplierRun.Status == "error" {
+				successful = false
+			}
+			if !run.StartedAt.Before(lastResultAt[supplierRun.Supplier]) {
+				lastResults[supplierRun.Supplier] = supplierRun
+				lastResultAt[supplierRun.Supplier] = run.StartedAt
+			}
+		}
+		if successful && run.StartedAt.After(health.LastSuccessfulRunAt) {
+			health.LastSuccessfulRunAt = run.StartedAt
+		}
+	}
+
+	health.FailingSuppliers = []string{}
+	for supplier, supplierRun := range lastResults {
+		if supplierRun.Status == "error" {
+			health.FailingSuppliers = append(health.FailingSuppliers, supplier)
+		}
+	}
+	sort.Strings(health.FailingSuppliers)
+
+	return health
+}
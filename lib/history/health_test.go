@@ -0,0 +1,31 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckHealth(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2024, time.September, d, 6, 0, 0, 0, time.UTC)
+	}
+	runs := []Run{
+		{StartedAt: day(1), Suppliers: []SupplierRun{{Supplier: "acme", Status: "success"}, {Supplier: "hetzner", Status: "success"}}},
+		{StartedAt: day(2), Suppliers: []SupplierRun{{Supplier: "acme", Status: "error"}, {Supplier: "hetzner", Status: "error"}}},
+		{StartedAt: day(3), Suppliers: []SupplierRun{{Supplier: "hetzner", Status: "warning"}}},
+	}
+
+	health := CheckHealth(runs)
+	if !health.LastRunAt.Equal(day(3)) || !health.LastSuccessfulRunAt.Equal(day(3)) {
+		t.Errorf("unexpected run times %+v", health)
+	}
+	if !reflect.DeepEqual(health.FailingSuppliers, []string{"acme"}) {
+		t.Errorf("unexpected failing suppliers %v", health.FailingSuppliers)
+	}
+
+	health = CheckHealth(nil)
+	if !health.LastRunAt.IsZero() || len(health.FailingSuppliers) != 0 || health.FailingSuppliers == nil {
+		t.Errorf("unexpected health without runs %+v", health)
+	}
+}
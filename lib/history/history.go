@@ -0,0 +1,193 @@
+// Package history keeps a local history of sync runs incl. the duration of every recipe step.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"buchhalter/lib/utils"
+)
+
+const (
+	// HISTORY_FILE_NAME is the file of the history in the buchhalter directory
+	HISTORY_FILE_NAME = "_history.json"
+
+	// maxRuns is the number of runs kept in the history
+	maxRuns = 100
+)
+
+type Run struct {
+	StartedAt time.Time     `json:"startedAt"`
+	Duration  float64       `json:"duration"`
+	Suppliers []SupplierRun `json:"suppliers"`
+}
+
+type SupplierRun struct {
+	Supplier string    `json:"supplier"`
+	Version  string    `json:"version"`
+	Status   string    `json:"status"`
+	Duration float64   `json:"duration"`
+	NewFiles int       `json:"newFiles"`
+	Steps    []StepRun `json:"steps"`
+}
+
+type StepRun struct {
+	Number      int     `json:"number"`
+	Action      string  `json:"action"`
+	Description string  `json:"description,omitempty"`
+	Status      string  `json:"status"`
+	Duration    float64 `json:"duration"`
+	utils.StepArtifacts
+}
+
+// StepStatistics aggregates the durations (in seconds) of a recipe step over all runs in the history.
+type StepStatistics struct {
+	Supplier        string
+	Number          int
+	Action          string
+	Description     string
+	Count           int
+	AverageDuration float64
+	MaxDuration     float64
+}
+
+// SupplierStatistics aggregates the durations (in seconds) of a supplier recipe over all runs in the history.
+type SupplierStatistics struct {
+	Supplier        string
+	Count           int
+	AverageDuration float64
+	MaxDuration     float64
+}
+
+type RunHistory struct {
+	logger *slog.Logger
+
+	historyFile string
+}
+
+func NewRunHistory(logger *slog.Logger, buchhalterDirectory string) *RunHistory {
+	return &RunHistory{
+		logger:      logger,
+		historyFile: filepath.Join(buchhalterDirectory, HISTORY_FILE_NAME),
+	}
+}
+
+// Runs returns all runs in the history, oldest first.
+func (h *RunHistory) Runs() ([]Run, error) {
+	var runs []Run
+	fileContent, err := os.ReadFile(h.historyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return runs, nil
+	}
+	if err != nil {
+		return runs, err
+	}
+
+	err = json.Unmarshal(fileContent, &runs)
+	return runs, err
+}
+
+// AddRun appends run to the history. Only the last runs are kept.
+func (h *RunHistory) AddRun(run Run) error {
+	runs, err := h.Runs()
+	if err != nil {
+		return err
+	}
+
+	runs = append(runs, run)
+	if len(runs) > maxRuns {
+		runs = runs[len(runs)-maxRuns:]
+	}
+
+	fileContent, err := json.Marshal(runs)
+	if err != nil {
+		return err
+	}
+	h.logger.Info("Writing run history", "file", h.historyFile, "num_runs", len(runs))
+	return os.WriteFile(h.historyFile, fileContent, 0644)
+}
+
+// SlowestSteps returns the recipe steps with the highest average duration.
+func (h *RunHistory) SlowestSteps(limit int) ([]StepStatistics, error) {
+	runs, err := h.Runs()
+	if err != nil {
+		return nil, err
+	}
+
+	type stepKey struct {
+		supplier string
+		number   int
+		action   string
+	}
+	statisticsByStep := make(map[stepKey]*StepStatistics)
+	for _, run := range runs {
+		for _, supplierRun := range run.Suppliers {
+			for _, step := range supplierRun.Steps {
+				key := stepKey{supplierRun.Supplier, step.Number, step.Action}
+				statistics, ok := statisticsByStep[key]
+				if !ok {
+					statistics = &StepStatistics{Supplier: supplierRun.Supplier, Number: step.Number, Action: step.Action}
+					statisticsByStep[key] = statistics
+				}
+				// The most recent description wins, as recipes change over time
+				statistics.Description = step.Description
+				statistics.AverageDuration = (statistics.AverageDuration*float64(statistics.Count) + step.Duration) / float64(statistics.Count+1)
+				statistics.MaxDuration = max(statistics.MaxDuration, step.Duration)
+				statistics.Count++
+			}
+		}
+	}
+
+	steps := make([]StepStatistics, 0, len(statisticsByStep))
+	for _, statistics := range statisticsByStep {
+		steps = append(steps, *statistics)
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].AverageDuration > steps[j].AverageDuration
+	})
+	if limit > 0 && len(steps) > limit {
+		steps = steps[:limit]
+	}
+
+	return steps, nil
+}
+
+// SlowestSuppliers returns the supplier recipes with the highest average duration.
+func (h *RunHistory) SlowestSuppliers(limit int) ([]SupplierStatistics, error) {
+	runs, err := h.Runs()
+	if err != nil {
+		return nil, err
+	}
+
+	statisticsBySupplier := make(map[string]*SupplierStatistics)
+	for _, run := range runs {
+		for _, supplierRun := range run.Suppliers {
+			statistics, ok := statisticsBySupplier[supplierRun.Supplier]
+			if !ok {
+				statistics = &SupplierStatistics{Supplier: supplierRun.Supplier}
+				statisticsBySupplier[supplierRun.Supplier] = statistics
+			}
+			statistics.AverageDuration = (statistics.AverageDuration*float64(statistics.Count) + supplierRun.Duration) / float64(statistics.Count+1)
+			statistics.MaxDuration = max(statistics.MaxDuration, supplierRun.Duration)
+			statistics.Count++
+		}
+	}
+
+	suppliers := make([]SupplierStatistics, 0, len(statisticsBySupplier))
+	for _, statistics := range statisticsBySupplier {
+		suppliers = append(suppliers, *statistics)
+	}
+	sort.Slice(suppliers, func(i, j int) bool {
+		return suppliers[i].AverageDuration > suppliers[j].AverageDuration
+	})
+	if limit > 0 && len(suppliers) > limit {
+		suppliers = suppliers[:limit]
+	}
+
+	return suppliers, nil
+}
@@ -0,0 +1,105 @@
+package httpclient
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResponseCache is an on-disk cache for response bodies of supplier API requests.
+// Entries are keyed by request and auth identity, so cached responses are never shared across credentials.
+type ResponseCache struct {
+	directory  string
+	defaultTTL time.Duration
+}
+
+type cacheEntry struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+	Body      []byte    `json:"body"`
+}
+
+// NewResponseCache creates a new response cache storing its entries in directory.
+// defaultTTL is used for responses without a Cache-Control max-age.
+func NewResponseCache(directory string, defaultTTL time.Duration) *ResponseCache {
+	return &ResponseCache{
+		directory:  directory,
+		defaultTTL: defaultTTL,
+	}
+}
+
+// CacheKey builds the cache key of a request for a given auth identity (e.g. supplier and credential id).
+func CacheKey(method, url string, body []byte, authIdentity string) string {
+	h := sha256.New()
+	h.Write([]byte(method + "\n" + url + "\n" + authIdentity + "\n"))
+	h.Write(body)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// Get returns the cached body for key if it exists and is not expired.
+func (c *ResponseCache) Get(key string) ([]byte, bool) {
+	fileContent, err := os.ReadFile(filepath.Join(c.directory, key+".json"))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(fileContent, &entry); err != nil {
+		return nil, false
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		return nil, false
+	}
+
+	return entry.Body, true
+}
+
+// Set stores body for key, honoring the Cache-Control header of the response.
+// Responses with `no-store` or `no-cache` are not stored.
+func (c *ResponseCache) Set(key string, header http.Header, body []byte) error {
+	ttl, cacheable := c.ttl(header.Get("Cache-Control"))
+	if !cacheable {
+		return nil
+	}
+
+	if _, err := os.Stat(c.directory); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(c.directory, 0700); err != nil {
+			return err
+		}
+	}
+
+	fileContent, err := json.Marshal(cacheEntry{
+		ExpiresAt: time.Now().Add(ttl),
+		Body:      body,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Responses may contain personal data, so only the current user can read them
+	return os.WriteFile(filepath.Join(c.directory, key+".json"), fileContent, 0600)
+}
+
+func (c *ResponseCache) ttl(cacheControl string) (time.Duration, bool) {
+	ttl := c.defaultTTL
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return ttl, ttl > 0
+}
@@ -0,0 +1,169 @@
+package httpclient
+
+// Shared http client for all requests to the Buchhalter API and supplier APIs.
+// Adds timeouts, retries with exponential backoff and request ids.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"buchhalter/lib/i18n"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type Client struct {
+	logger     *slog.Logger
+	httpClient *http.Client
+
+	maxRetries  int
+	backoffBase time.Duration
+}
+
+// New creates a new http client.
+// Requests failing with a network error, a 5xx or a 429 status code are retried up to maxRetries times.
+func New(logger *slog.Logger, timeout time.Duration, maxRetries int) *Client {
+	return &Client{
+		logger: logger,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+
+		maxRetries:  maxRetries,
+		backoffBase: 500 * time.Millisecond,
+	}
+}
+
+// WithTimeout returns a copy of the client using a different timeout (e.g. for large uploads).
+func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	clone := *c
+	clone.httpClient = &http.Client{
+		Timeout: timeout,
+	}
+	return &clone
+}
+
+// Do sends the request and returns the response.
+// Network errors are returned as RequestError.
+// Responses with a 5xx/429 status code are retried. If all retries fail, the last response is returned.
+// Checking other status codes is up to the caller (see StatusError).
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	requestID := req.Header.Get(requestIDHeader)
+	if requestID == "" {
+		requestID = newRequestID()
+		req.Header.Set(requestIDHeader, requestID)
+	}
+
+	// Requests with a body can only be retried if the body can be read again
+	maxRetries := c.maxRetries
+	if req.Body != nil && req.GetBody == nil {
+		maxRetries = 0
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, RequestError{Code: RequestErrorCode, URL: req.URL.String(), RequestID: requestID, Err: err}
+			}
+		}
+
+		resp, err = c.httpClient.Do(req)
+		if !shouldRetry(resp, err) || attempt >= maxRetries {
+			break
+		}
+
+		delay := c.backoff(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+			c.logger.Info("Retrying http request", "url", req.URL.String(), "request_id", requestID, "status_code", resp.StatusCode, "attempt", attempt+1, "delay", delay)
+		} else {
+			c.logger.Info("Retrying http request", "url", req.URL.String(), "request_id", requestID, "error", err, "attempt", attempt+1, "delay", delay)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, RequestError{Code: RequestErrorCode, URL: req.URL.String(), RequestID: requestID, Err: req.Context().Err()}
+		}
+	}
+
+	if err != nil {
+		return nil, RequestError{Code: RequestErrorCode, URL: req.URL.String(), RequestID: requestID, Err: err}
+	}
+
+	return resp, nil
+}
+
+// StatusError creates a ResponseStatusError for an unexpected response.
+func StatusError(resp *http.Response, message string) error {
+	return ResponseStatusError{
+		Code:       ResponseStatusErrorCode,
+		URL:        resp.Request.URL.String(),
+		RequestID:  resp.Request.Header.Get(requestIDHeader),
+		StatusCode: resp.StatusCode,
+		Message:    message,
+	}
+}
+
+// GetHumanReadableErrorMessage returns an error message that can be shown to the user.
+func GetHumanReadableErrorMessage(err error) string {
+	var requestError RequestError
+	if errors.As(err, &requestError) {
+		return i18n.Tf("Could not connect to %s. Please check your internet connection and try again (request id %s).", requestError.URL, requestError.RequestID)
+	}
+
+	var statusError ResponseStatusError
+	if errors.As(err, &statusError) {
+		switch {
+		case statusError.StatusCode == http.StatusUnauthorized || statusError.StatusCode == http.StatusForbidden:
+			return i18n.Tf("Access to %s was denied. Please check your API-Token (request id %s).", statusError.URL, statusError.RequestID)
+		case statusError.StatusCode == http.StatusTooManyRequests:
+			return i18n.Tf("Too many requests to %s. Please try again later (request id %s).", statusError.URL, statusError.RequestID)
+		case statusError.StatusCode >= 500:
+			return i18n.Tf("The server %s is currently not available. Please try again later (request id %s).", statusError.URL, statusError.RequestID)
+		}
+		return i18n.Tf("The request to %s failed with status code %d (request id %s).", statusError.URL, statusError.StatusCode, statusError.RequestID)
+	}
+
+	return err.Error()
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff returns the delay before the next attempt: exponential backoff with jitter.
+// A Retry-After header (in seconds) of a 429 response takes precedence.
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	delay := c.backoffBase * time.Duration(1<<attempt)
+	jitter, err := rand.Int(rand.Reader, big.NewInt(int64(delay)))
+	if err != nil {
+		return delay
+	}
+	return delay + time.Duration(jitter.Int64())
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,77 @@
+package httpclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientRetriesServerErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Errorf("attempt %d: body = %s; want payload", attempts, body)
+		}
+		if r.Header.Get(requestIDHeader) == "" {
+			t.Errorf("attempt %d: missing request id", attempts)
+		}
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := New(slog.New(slog.NewTextHandler(io.Discard, nil)), time.Second, 3)
+	c.backoffBase = time.Millisecond
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL, bytes.NewBufferString("payload"))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Do() status code = %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+	if attempts != 3 {
+		t.Errorf("Do() attempts = %d; want 3", attempts)
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	c := New(slog.New(slog.NewTextHandler(io.Discard, nil)), time.Second, 3)
+	c.backoffBase = time.Millisecond
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if attempts != 1 {
+		t.Errorf("Do() attempts = %d; want 1", attempts)
+	}
+
+	var statusError ResponseStatusError
+	if !errors.As(StatusError(resp, ""), &statusError) || statusError.StatusCode != http.StatusNotFound || statusError.RequestID == "" {
+		t.Errorf("StatusError() = %v; want ResponseStatusError with status code 404 and request id", statusError)
+	}
+}
@@ -0,0 +1,43 @@
+package httpclient
+
+import (
+	"fmt"
+)
+
+const (
+	RequestErrorCode        int = 9101
+	ResponseStatusErrorCode int = 9102
+)
+
+// RequestError is returned if a request could not be sent or no response was received (e.g. offline, timeout).
+type RequestError struct {
+	Code      int
+	URL       string
+	RequestID string
+	Err       error
+}
+
+func (e RequestError) Error() string {
+	return fmt.Sprintf("Error %d sending request to \"%s\" (request id %s): %s", e.Code, e.URL, e.RequestID, e.Err.Error())
+}
+
+func (e RequestError) Unwrap() error {
+	return e.Err
+}
+
+// ResponseStatusError is returned if a response has an unexpected http status code.
+type ResponseStatusError struct {
+	Code       int
+	URL        string
+	RequestID  string
+	StatusCode int
+	Message    string
+}
+
+func (e ResponseStatusError) Error() string {
+	message := fmt.Sprintf("Error %d http request to \"%s\" (request id %s) failed with status code: %d", e.Code, e.URL, e.RequestID, e.StatusCode)
+	if e.Message != "" {
+		message += " (" + e.Message + ")"
+	}
+	return message
+}
@@ -0,0 +1,252 @@
+package i18n
+
+// german contains the German translations. Format strings must keep the verbs of the English message.
+var german = map[string]string{
+	// Sync
+	"Automatically sync all your incoming invoices from your suppliers. ": "Synchronisiere automatisch alle eingehenden Rechnungen deiner Lieferanten. ",
+	"More information at: ":                                          "Mehr Informationen unter: ",
+	"Using CLI v%s":                                                  "CLI v%s",
+	"Using OICDB %s and CLI %s":                                      "OICDB %s und CLI %s",
+	"Initializing...":                                                "Initialisiere...",
+	"Loading...":                                                     "Lade...",
+	"Build archive index":                                            "Erstelle Archivindex",
+	"Building document archive index":                                "Erstellen des Dokumentarchivindex",
+	"Remote archive: %s":                                             "Entferntes Archiv: %s",
+	"Fetching remote archive index":                                  "Lade Index des entfernten Archivs",
+	"Checking for OICDB schema updates ...":                          "Prüfe auf Updates des OICDB-Schemas ...",
+	"Checking for OICDB schema updates: %s":                          "Prüfen auf Updates des OICDB-Schemas: %s",
+	"Checking for OICDB repository updates ...":                      "Prüfe auf Updates der OICDB ...",
+	"Checking for OICDB repository updates: %s":                      "Prüfen auf Updates der OICDB: %s",
+	"No recipes found for suppliers":                                 "Keine Rezepte für Lieferanten gefunden",
+	"Running one recipe for supplier %s ...":                         "Führe ein Rezept für Lieferant %s aus ...",
+	"Your role %s in team %s doesn't permit syncs":                   "Deine Rolle %s im Team %s erlaubt keine Syncs",
+	"not permitted by your role":                                     "von deiner Rolle nicht erlaubt",
+	"Running recipes for %d suppliers ...":                           "Führe Rezepte für %d Lieferanten aus ...",
+	"Retrieving authenticated user: %s":                              "Abrufen des angemeldeten Benutzers: %s",
+	"Loading recipe permissions":                                     "Laden der Rezeptberechtigungen",
+	"Loading approved recipes":                                       "Laden der freigegebenen Rezepte",
+	"Skipping %s, as %s didn't complete":                             "Überspringe %s, da %s nicht abgeschlossen wurde",
+	"Downloading invoices from %s:":                                  "Lade Rechnungen von %s herunter:",
+	"Downloading invoices from %s (%d/%d):":                          "Lade Rechnungen von %s herunter (%d/%d):",
+	"Downloading statements from %s (%d/%d):":                        "Lade Kontoauszüge von %s herunter (%d/%d):",
+	"Waiting for confirmation of %s:":                                "Warte auf Bestätigung von %s:",
+	"Run aborted":                                                    "Lauf abgebrochen",
+	"Documents listed, nothing was downloaded":                       "Dokumente aufgelistet, nichts wurde heruntergeladen",
+	"Documents offered by the suppliers (nothing was downloaded)":    "Von den Lieferanten angebotene Dokumente (nichts wurde heruntergeladen)",
+	"(no id or link)":                                                "(keine ID oder Link)",
+	"Converting documents to PDF/A ...":                              "Konvertiere Dokumente in PDF/A ...",
+	"Converting documents to PDF/A: %s":                              "Konvertieren der Dokumente in PDF/A: %s",
+	"Converting %s to PDF/A: %s":                                     "Konvertieren von %s in PDF/A: %s",
+	"Timestamping documents ...":                                     "Versehe Dokumente mit Zeitstempeln ...",
+	"Timestamping documents: %s":                                     "Zeitstempeln der Dokumente: %s",
+	"Writing run manifest: %s":                                       "Schreiben des Lauf-Manifests: %s",
+	"Uploading documents to remote archive ...":                      "Lade Dokumente in das entfernte Archiv hoch ...",
+	"Uploading documents to remote archive: %s":                      "Hochladen der Dokumente in das entfernte Archiv: %s",
+	"Committing documents to git ...":                                "Committe Dokumente in git ...",
+	"Committing documents of archive %s to git: %s":                  "Committen der Dokumente des Archivs %s in git: %s",
+	"Pushing documents to Paperless-ngx ...":                         "Übertrage Dokumente an Paperless-ngx ...",
+	"Pushing documents to Paperless-ngx: %s":                         "Übertragen der Dokumente an Paperless-ngx: %s",
+	"Delivering documents to document sink ...":                      "Liefere Dokumente an die Dokumentablage aus ...",
+	"Delivering documents to document sink: %s":                      "Ausliefern der Dokumente an die Dokumentablage: %s",
+	"Sending webhook events: %s":                                     "Senden der Webhook-Ereignisse: %s",
+	"Sending usage metrics to Buchhalter API":                        "Senden der Nutzungsdaten an die Buchhalter API",
+	"Ordering recipes: %s":                                           "Sortieren der Rezepte: %s",
+	"Let's improve buchhalter-cli together!":                         "Lass uns buchhalter-cli gemeinsam verbessern!",
+	"Allow buchhalter-cli to send anonymized usage data to our api?": "Darf buchhalter-cli anonymisierte Nutzungsdaten an unsere API senden?",
+	"Yes":                                   "Ja",
+	"No":                                    "Nein",
+	"Always yes (don't ask again)":          "Immer ja (nicht mehr fragen)",
+	"The recipe for %s changed (%s -> %s):": "Das Rezept für %s hat sich geändert (%s -> %s):",
+	"Run the changed recipe? The full changelog is written to the log file. (y/n)":          "Geändertes Rezept ausführen? Alle Änderungen stehen in der Logdatei. (y/n)",
+	"Skipping recipe for %s: scripts without declared permission":                           "Überspringe Rezept für %s: Skripte ohne deklarierte Berechtigung",
+	"The recipe for %s wants to run %d new or changed script(s) in your logged in session:": "Das Rezept für %s möchte %d neue oder geänderte Skript(e) in deiner angemeldeten Sitzung ausführen:",
+	"Allow these scripts? The full sources are written to the log file. (y/n)":              "Diese Skripte erlauben? Der vollständige Quelltext steht in der Logdatei. (y/n)",
+	"ERROR: ":                             "FEHLER: ",
+	"ERROR while running recipes!":        "FEHLER beim Ausführen der Rezepte!",
+	"Thanks for using buchhalter.ai!":     "Danke, dass du buchhalter.ai nutzt!",
+	"HAVE A NICE DAY! :)":                 "EINEN SCHÖNEN TAG! :)",
+	"Press q to exit":                     "Drücke q zum Beenden",
+	"Inspect %s with Chrome DevTools: %s": "%s mit Chrome DevTools untersuchen: %s",
+	"l: toggle log %[1]s %[2]s/%[3]s: scroll suppliers %[1]s q: exit": "l: Log ein/aus %[1]s %[2]s/%[3]s: Lieferanten scrollen %[1]s q: beenden",
+
+	// Supplier panes
+	"%s %d more":                    "%s %d weitere",
+	"%d docs":                       "%d Dok.",
+	"ERROR":                         "FEHLER",
+	"not approved":                  "nicht freigegeben",
+	"skipped via control socket":    "über den Control-Socket übersprungen",
+	"credentials not available":     "Zugangsdaten nicht verfügbar",
+	"already synced in this period": "in diesem Zeitraum bereits synchronisiert",
+	"%s didn't complete":            "%s nicht abgeschlossen",
+	"documents can't be listed":     "Dokumente können nicht aufgelistet werden",
+	"%d found, %d archived":         "%d gefunden, %d archiviert",
+	"%s to %s":                      "%s bis %s",
+	"(no log entries yet)":          "(noch keine Logeinträge)",
+	"ETA %s (%s)":                   "Restzeit %s (%s)",
+
+	// Supplier picker and review
+	"space: toggle %[1]s a: toggle all %[1]s enter: sync selected %[1]s q: exit": "Leertaste: auswählen %[1]s a: alle auswählen %[1]s Enter: Auswahl synchronisieren %[1]s q: beenden",
+	"enter: save %s esc: cancel": "Enter: speichern %s Esc: abbrechen",
+	"o: open %[1]s r: rename %[1]s t: tag %[1]s a/enter: accept %[1]s x: reject %[1]s q: exit": "o: öffnen %[1]s r: umbenennen %[1]s t: taggen %[1]s a/Enter: annehmen %[1]s x: ablehnen %[1]s q: beenden",
+
+	// Status
+	"Sync running (pid %d, started %s, elapsed %s)": "Synchronisierung läuft (PID %d, gestartet %s, Laufzeit %s)",
+	"Supplier:":       "Lieferant:",
+	"Step:":           "Schritt:",
+	"Progress:":       "Fortschritt:",
+	"ETA:":            "Voraussichtliches Ende:",
+	"Queue:":          "Warteschlange:",
+	"(empty)":         "(leer)",
+	"Control socket:": "Control-Socket:",
+	"buchhalter serve is running (pid %d), no sync is running": "buchhalter serve läuft (PID %d), keine Synchronisierung aktiv",
+	"No sync is running":         "Keine Synchronisierung aktiv",
+	"No sync runs recorded yet.": "Noch keine Synchronisierungen aufgezeichnet.",
+	"Last run:":                  "Letzter Lauf:",
+	"Started:":                   "Gestartet:",
+	"%s (took %s)":               "%s (Dauer %s)",
+	"Suppliers:":                 "Lieferanten:",
+	"New documents:":             "Neue Dokumente:",
+	"Failed:":                    "Fehlgeschlagen:",
+	"Next scheduled run:":        "Nächster geplanter Lauf:",
+	"%s (in %s)":                 "%s (in %s)",
+	"No scheduled runs (see buchhalter_serve_sync_interval).": "Keine geplanten Läufe (siehe buchhalter_serve_sync_interval).",
+
+	// Show
+	"Document %s not found in archive.": "Dokument %s nicht im Archiv gefunden.",
+	"No preview: %s":                    "Keine Vorschau: %s",
+	"Path:":                             "Pfad:",
+	"Checksum:":                         "Prüfsumme:",
+	"Added:":                            "Hinzugefügt:",
+	"Size:":                             "Größe:",
+	"Pages:":                            "Seiten:",
+	"Title:":                            "Titel:",
+	"Author:":                           "Autor:",
+	"Creator:":                          "Erstellt mit:",
+	"Producer:":                         "PDF-Erzeuger:",
+	"Created:":                          "Erstellt:",
+	"Invoice no.:":                      "Rechnungsnr.:",
+	"Invoice date:":                     "Rechnungsdatum:",
+	"Amount:":                           "Betrag:",
+	"Tags:":                             "Tags:",
+	"Review:":                           "Prüfung:",
+	"rejected":                          "abgelehnt",
+	"accepted":                          "angenommen",
+	"pending":                           "ausstehend",
+
+	// Open
+	"Directory %s does not exist.": "Das Verzeichnis %s existiert nicht.",
+	"Opening %s":                   "Öffne %s",
+	"%d documents match %s, please refine the query:": "%d Dokumente passen zu %s, bitte verfeinere die Suche:",
+
+	// Trash
+	"The trash is empty.":                 "Der Papierkorb ist leer.",
+	"from":                                "aus",
+	"Document %s not found in the trash.": "Dokument %s nicht im Papierkorb gefunden.",
+	"Restored %s":                         "%s wiederhergestellt",
+
+	// Verify
+	"No run manifests found in the archive.": "Keine Lauf-Manifeste im Archiv gefunden.",
+	"Run of %s":                              "Lauf vom %s",
+	"%d of %d documents verified":            "%d von %d Dokumenten verifiziert",
+	"missing":                                "fehlt",
+	"changed":                                "verändert",
+
+	// Metrics
+	"Writing usage metrics to %s":                               "Schreiben der Nutzungsdaten nach %s",
+	"No usage metrics recorded yet, run buchhalter sync first.": "Noch keine Nutzungsdaten aufgezeichnet, führe zuerst buchhalter sync aus.",
+	"Payload written to %s:":                                    "In %s geschriebene Daten:",
+	"Payload sent to %s:":                                       "An %s gesendete Daten:",
+	"Decoded data field:":                                       "Dekodiertes Feld data:",
+	"Usage metrics are only sent with your consent, sync asks for it after each run (see buchhalter_always_send_metrics).": "Nutzungsdaten werden nur mit deiner Zustimmung gesendet, sync fragt danach nach jedem Lauf (siehe buchhalter_always_send_metrics).",
+
+	// Update
+	"Open Invoice Collector Database version %s": "Open Invoice Collector Database Version %s",
+
+	// Add
+	"The add command asks questions, please run it in a terminal.": "Der add-Befehl stellt Fragen, bitte führe ihn in einem Terminal aus.",
+	"Adding %s (%s)": "%s hinzufügen (%s)",
+	"Supplier %s not found in the Open Invoice Collector Database.": "Lieferant %s nicht in der Open Invoice Collector Database gefunden.",
+	"Did you mean one of these suppliers?":                          "Meintest du einen dieser Lieferanten?",
+	"No login for %s found in your vault.":                          "Kein Login für %s in deinem Tresor gefunden.",
+	"Create a login item for %s in your vault?":                     "Ein Login-Objekt für %s in deinem Tresor anlegen?",
+	"Found the vault item %s.":                                      "Tresor-Objekt %s gefunden.",
+	"Found %d logins for %s in your vault:":                         "%d Logins für %s in deinem Tresor gefunden:",
+	"Which vault item should buchhalter use? (1-%d)":                "Welches Tresor-Objekt soll buchhalter verwenden? (1-%d)",
+	"Username:":                  "Benutzername:",
+	"Password:":                  "Passwort:",
+	"Created the vault item %s.": "Tresor-Objekt %s angelegt.",
+	"Tag the vault item %s with %s, so buchhalter uses it?":       "Tresor-Objekt %s mit %s taggen, damit buchhalter es verwendet?",
+	"Without the tag %s, buchhalter does not use the vault item.": "Ohne den Tag %s verwendet buchhalter das Tresor-Objekt nicht.",
+	"%s uses the vault item %s.":                                  "%s verwendet das Tresor-Objekt %s.",
+	"%s was added to buchhalter_selected_suppliers.":              "%s wurde zu buchhalter_selected_suppliers hinzugefügt.",
+	"Run a first sync of %s now to validate the login?":           "Jetzt einen ersten Sync von %s ausführen, um den Login zu prüfen?",
+	"%s is ready, documents are downloaded with the next sync.":   "%s ist eingerichtet, Dokumente werden beim nächsten Sync heruntergeladen.",
+	"Aborted": "Abgebrochen",
+
+	// Refetch
+	"Please select the documents to download again with --invoice.":                                               "Bitte wähle die erneut herunterzuladenden Dokumente mit --invoice aus.",
+	"No downloaded documents of %s match %s.":                                                                     "Keine heruntergeladenen Dokumente von %s passen zu %s.",
+	"No recipe with credentials found for supplier %s.":                                                           "Kein Rezept mit Zugangsdaten für den Lieferanten %s gefunden.",
+	"Refetching is only supported for client recipes, %s is a %s recipe. Run buchhalter sync %s --force instead.": "Erneutes Herunterladen wird nur für Client-Rezepte unterstützt, %s ist ein %s-Rezept. Führe stattdessen buchhalter sync %s --force aus.",
+	"Downloading %d documents of %s again ...":                                                                    "Lade %d Dokumente von %s erneut herunter ...",
+	"Refetching documents of %s failed: %s":                                                                       "Erneutes Herunterladen der Dokumente von %s fehlgeschlagen: %s",
+	"Replaced %s":                                                                                                 "%s ersetzt",
+
+	// Backup
+	"Backed up %d files to %s.": "%d Dateien in %s gesichert.",
+	"The backup contains your cached tokens, keep it and its passphrase safe.":                                                "Die Sicherung enthält deine zwischengespeicherten Tokens, bewahre sie und ihre Passphrase sicher auf.",
+	"Backup of %s (CLI v%s) with %d files.":                                                                                   "Sicherung vom %s (CLI v%s) mit %d Dateien.",
+	"Replace the configuration, archive indexes, run history and cached tokens of this machine?":                              "Konfiguration, Archiv-Indizes, Lauf-Historie und zwischengespeicherte Tokens dieses Rechners ersetzen?",
+	"Skipped the archive %s, it is not configured anymore.":                                                                   "Archiv %s übersprungen, es ist nicht mehr konfiguriert.",
+	"Restored %d files from %s.":                                                                                              "%d Dateien aus %s wiederhergestellt.",
+	"Secrets stored in the keychain (buchhalter config set --secret) aren't part of backups, set them again on this machine.": "Im Schlüsselbund gespeicherte Secrets (buchhalter config set --secret) sind nicht Teil von Sicherungen, setze sie auf diesem Rechner erneut.",
+	"Passphrase of the backup:":                                                                                               "Passphrase der Sicherung:",
+	"The passphrase must not be empty.":                                                                                       "Die Passphrase darf nicht leer sein.",
+	"Repeat the passphrase:":                                                                                                  "Passphrase wiederholen:",
+	"The passphrases don't match.":                                                                                            "Die Passphrasen stimmen nicht überein.",
+
+	// Close period
+	"Completeness report %s":       "Vollständigkeitsbericht %s",
+	"Completeness report %s of %s": "Vollständigkeitsbericht %s von %s",
+	"OK":                           "OK",
+	"MISSING":                      "FEHLT",
+	"NOT CONFIGURED":               "NICHT KONFIGURIERT",
+	"(unknown)":                    "(unbekannt)",
+	"Total":                        "Gesamt",
+	"%d documents bundled in %s":   "%d Dokumente in %s gebündelt",
+	"The period is incomplete, no documents of: %s": "Der Zeitraum ist unvollständig, keine Dokumente von: %s",
+	"Spend report %s (gross amounts)":               "Ausgabenbericht %s (Bruttobeträge)",
+	"VAT":                                           "USt.",
+	"* incl. the currency or VAT rate of buchhalter_supplier_currency or buchhalter_supplier_vat_rate": "* inkl. Währung oder Steuersatz aus buchhalter_supplier_currency oder buchhalter_supplier_vat_rate",
+	"%d documents without amount are not included":                                                     "%d Dokumente ohne Betrag sind nicht enthalten",
+	"%d documents without VAT rate are included without VAT":                                           "%d Dokumente ohne Steuersatz sind ohne USt. enthalten",
+	"Conflicts with the supplier defaults (the extracted values are used):":                            "Abweichungen von den Lieferanten-Vorgaben (die ausgelesenen Werte werden verwendet):",
+	"Not included because of invalid amounts or VAT rates:":                                            "Wegen ungültiger Beträge oder Steuersätze nicht enthalten:",
+
+	// Crash reports
+	"buchhalter crashed unexpectedly: %s": "buchhalter ist unerwartet abgestürzt: %s",
+	"A crash report was written to %s. It contains the error message and the code location of the crash, but neither documents nor credentials.": "Ein Absturzbericht wurde in %s geschrieben. Er enthält die Fehlermeldung und die Stelle im Code, aber weder Dokumente noch Zugangsdaten.",
+	"Send the crash report to the Buchhalter Platform to help us fix the error? (y/n) ":                                                          "Absturzbericht an die Buchhalter Platform senden, damit wir den Fehler beheben können? (y/n) ",
+	"Sending the crash report failed: %s": "Senden des Absturzberichts fehlgeschlagen: %s",
+	"Crash report sent, thank you!":       "Absturzbericht gesendet, danke!",
+
+	// Roles
+	"Your role %s in team %s doesn't permit exports.":    "Deine Rolle %s im Team %s erlaubt keine Exporte.",
+	"Your role %s in team %s doesn't permit syncing %s.": "Deine Rolle %s im Team %s erlaubt keinen Sync von %s.",
+
+	// Errors
+	"Could not connect to %s. Please check your internet connection and try again (request id %s).": "Keine Verbindung zu %s möglich. Bitte prüfe deine Internetverbindung und versuche es erneut (Request-ID %s).",
+	"Access to %s was denied. Please check your API-Token (request id %s).":                         "Der Zugriff auf %s wurde verweigert. Bitte prüfe dein API-Token (Request-ID %s).",
+	"Too many requests to %s. Please try again later (request id %s).":                              "Zu viele Anfragen an %s. Bitte versuche es später erneut (Request-ID %s).",
+	"The server %s is currently not available. Please try again later (request id %s).":             "Der Server %s ist derzeit nicht erreichbar. Bitte versuche es später erneut (Request-ID %s).",
+	"The request to %s failed with status code %d (request id %s).":                                 "Die Anfrage an %s ist mit Statuscode %d fehlgeschlagen (Request-ID %s).",
+	`Could not find out 1Password cli version. Install 1Password cli, first.
+Please read "Get started with 1Password CLI" at https://developer.1password.com/docs/cli/get-started/`: `Die Version der 1Password CLI konnte nicht ermittelt werden. Installiere zuerst die 1Password CLI.
+Siehe "Get started with 1Password CLI" unter https://developer.1password.com/docs/cli/get-started/`,
+	`Could not connect to 1Password vault. Open 1Password vault with "eval $(op signin)", first.
+Please read "Sign in to 1Password CLI" at https://developer.1password.com/docs/cli/reference/commands/signin/`: `Keine Verbindung zum 1Password-Tresor möglich. Öffne den Tresor zuerst mit "eval $(op signin)".
+Siehe "Sign in to 1Password CLI" unter https://developer.1password.com/docs/cli/reference/commands/signin/`,
+	`Could not read response data from 1Password vault.`:                                         `Die Antwort des 1Password-Tresors konnte nicht gelesen werden.`,
+	`Could not write to 1Password vault. Check that you are allowed to edit items of the vault.`: `In den 1Password-Tresor konnte nicht geschrieben werden. Prüfe, ob du Einträge des Tresors bearbeiten darfst.`,
+	`An error occurred while executing a command: %s`:                                            `Beim Ausführen eines Befehls ist ein Fehler aufgetreten: %s`,
+}
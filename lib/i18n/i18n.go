@@ -0,0 +1,91 @@
+// Package i18n translates user-facing messages of the terminal output.
+//
+// Messages are identified by their English text (or format string), so code stays readable and untranslated
+// messages fall back to English. Log messages and data sent to APIs are not translated.
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DEFAULT_LANGUAGE is the language of the messages in the code.
+const DEFAULT_LANGUAGE = "en"
+
+// catalogs contains the translations of the additional languages, keyed by the English message
+var catalogs = map[string]map[string]string{
+	"de": german,
+}
+
+var (
+	mutex    sync.RWMutex
+	language = DEFAULT_LANGUAGE
+)
+
+// Languages returns the supported languages.
+func Languages() []string {
+	languages := []string{DEFAULT_LANGUAGE}
+	for l := range catalogs {
+		languages = append(languages, l)
+	}
+	sort.Strings(languages[1:])
+	return languages
+}
+
+// SetLanguage sets the language of the messages, e.g. "de".
+func SetLanguage(l string) error {
+	if _, ok := catalogs[l]; !ok && l != DEFAULT_LANGUAGE {
+		return fmt.Errorf("unsupported language %s (supported: %s)", l, strings.Join(Languages(), ", "))
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	language = l
+	return nil
+}
+
+// Language returns the language of the messages.
+func Language() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return language
+}
+
+// DetectLanguage returns the supported language of the locale environment variables (LC_ALL, LC_MESSAGES, LANG) of
+// getenv, e.g. "de" for LANG=de_DE.UTF-8. Unsupported locales fall back to the default language.
+func DetectLanguage(getenv func(string) string) string {
+	for _, variable := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		locale := getenv(variable)
+		if locale == "" {
+			continue
+		}
+		// The first variable set defines the locale, e.g. LC_ALL=C overrides LANG
+		fields := strings.FieldsFunc(locale, func(r rune) bool { return r == '_' || r == '-' || r == '.' || r == '@' })
+		if len(fields) == 0 {
+			return DEFAULT_LANGUAGE
+		}
+		l := strings.ToLower(fields[0])
+		if _, ok := catalogs[l]; ok {
+			return l
+		}
+		return DEFAULT_LANGUAGE
+	}
+	return DEFAULT_LANGUAGE
+}
+
+// T returns the translation of message in the current language.
+func T(message string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if translation, ok := catalogs[language][message]; ok {
+		return translation
+	}
+	return message
+}
+
+// Tf formats the translation of the format string in the current language with args, like fmt.Sprintf.
+func Tf(format string, args ...any) string {
+	return fmt.Sprintf(T(format), args...)
+}
@@ -0,0 +1,70 @@
+package i18n
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var verbPattern = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z%]`)
+
+func TestTranslationsKeepTheVerbsOfTheMessage(t *testing.T) {
+	for language, catalog := range catalogs {
+		for message, translation := range catalog {
+			expected := verbPattern.FindAllString(message, -1)
+			actual := verbPattern.FindAllString(translation, -1)
+			sort.Strings(expected)
+			sort.Strings(actual)
+			if !reflect.DeepEqual(expected, actual) {
+				t.Errorf("%s translation of %q has the verbs %v, expected %v", language, message, actual, expected)
+			}
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	for env, expected := range map[string]string{
+		"LANG=de_DE.UTF-8":              "de",
+		"LANG=de":                       "de",
+		"LC_MESSAGES=de_AT@euro":        "de",
+		"LANG=fr_FR.UTF-8":              DEFAULT_LANGUAGE,
+		"LC_ALL=C,LANG=de_DE.UTF-8":     DEFAULT_LANGUAGE,
+		"LC_ALL=,LANG=de_CH.UTF-8":      "de",
+		"":                              DEFAULT_LANGUAGE,
+		"LC_ALL=en_US.UTF-8,LANG=de_DE": DEFAULT_LANGUAGE,
+	} {
+		variables := map[string]string{}
+		for _, assignment := range strings.Split(env, ",") {
+			if name, value, ok := strings.Cut(assignment, "="); ok {
+				variables[name] = value
+			}
+		}
+		language := DetectLanguage(func(name string) string { return variables[name] })
+		if language != expected {
+			t.Errorf("%s: expected %s, got %s", env, expected, language)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	defer func() { _ = SetLanguage(DEFAULT_LANGUAGE) }()
+
+	if err := SetLanguage("de"); err != nil {
+		t.Fatal(err)
+	}
+	if message := Tf("Running recipes for %d suppliers ...", 3); message != "Führe Rezepte für 3 Lieferanten aus ..." {
+		t.Errorf("unexpected translation %q", message)
+	}
+	if message := T("A message without translation"); message != "A message without translation" {
+		t.Errorf("expected the English message, got %q", message)
+	}
+
+	if err := SetLanguage("xx"); err == nil {
+		t.Error("expected an error for an unsupported language")
+	}
+	if Language() != "de" {
+		t.Errorf("expected the language to be unchanged, got %s", Language())
+	}
+}
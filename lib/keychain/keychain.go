@@ -0,0 +1,148 @@
+package keychain
+
+// Stores secrets of the configuration (e.g. webhook URLs with tokens or passwords) in the keychain of the operating system.
+// The configuration file only contains a reference (`keychain:<key>`) to the secret.
+// macOS uses the login keychain (`security`), Linux the Secret Service (`secret-tool`, e.g. GNOME Keyring or KWallet).
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	SERVICE_NAME     = "buchhalter"
+	REFERENCE_PREFIX = "keychain:"
+)
+
+const (
+	KeychainUnavailableErrorCode    int = 9301
+	KeychainSecretNotFoundErrorCode int = 9302
+	KeychainCommandErrorCode        int = 9303
+)
+
+type KeychainError struct {
+	Code int
+	Key  string
+	Err  error
+}
+
+func (e KeychainError) Error() string {
+	return fmt.Sprintf("Error %d keychain secret %s: %s", e.Code, e.Key, e.Err.Error())
+}
+
+// commandRunner runs a command with stdin and returns its stdout.
+type commandRunner func(stdin string, name string, args ...string) ([]byte, error)
+
+type Keychain struct {
+	service string
+	goos    string
+	run     commandRunner
+}
+
+func New() *Keychain {
+	return &Keychain{
+		service: SERVICE_NAME,
+		goos:    runtime.GOOS,
+		run:     runCommand,
+	}
+}
+
+func runCommand(stdin string, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	return cmd.Output()
+}
+
+// Reference returns the value referencing the keychain secret key in the configuration file.
+func Reference(key string) string {
+	return REFERENCE_PREFIX + key
+}
+
+// ParseReference returns the key of a value referencing a keychain secret.
+func ParseReference(value string) (string, bool) {
+	key, ok := strings.CutPrefix(value, REFERENCE_PREFIX)
+	return key, ok && key != ""
+}
+
+// Set stores the secret value under key. Values are passed via stdin, so they don't show up in the process list.
+func (k *Keychain) Set(key, value string) error {
+	var err error
+	switch k.goos {
+	case "darwin":
+		// `security -i` reads commands from stdin, the hex encoded password avoids quoting issues
+		_, err = k.run(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n", k.service, key, hex.EncodeToString([]byte(value))), "security", "-i")
+	case "linux", "freebsd", "openbsd":
+		_, err = k.run(value, "secret-tool", "store", "--label", k.service+" "+key, "service", k.service, "key", key)
+	default:
+		return KeychainError{Code: KeychainUnavailableErrorCode, Key: key, Err: fmt.Errorf("no keychain support on %s", k.goos)}
+	}
+	if err != nil {
+		return k.commandError(key, err)
+	}
+
+	return nil
+}
+
+// Get returns the secret value stored under key.
+func (k *Keychain) Get(key string) (string, error) {
+	var output []byte
+	var err error
+	switch k.goos {
+	case "darwin":
+		output, err = k.run("", "security", "find-generic-password", "-s", k.service, "-a", key, "-w")
+		output = []byte(strings.TrimSuffix(string(output), "\n"))
+	case "linux", "freebsd", "openbsd":
+		output, err = k.run("", "secret-tool", "lookup", "service", k.service, "key", key)
+	default:
+		return "", KeychainError{Code: KeychainUnavailableErrorCode, Key: key, Err: fmt.Errorf("no keychain support on %s", k.goos)}
+	}
+
+	if k.notFound(err) || (err == nil && len(output) == 0) {
+		return "", KeychainError{Code: KeychainSecretNotFoundErrorCode, Key: key, Err: errors.New("not found (store it with `buchhalter config set --secret`)")}
+	}
+	if err != nil {
+		return "", k.commandError(key, err)
+	}
+
+	return string(output), nil
+}
+
+// notFound returns true if the lookup command failed because there is no secret.
+func (k *Keychain) notFound(err error) bool {
+	var exitError *exec.ExitError
+	if !errors.As(err, &exitError) {
+		return false
+	}
+	// errSecItemNotFound of `security`, `secret-tool` fails with 1 without output
+	if k.goos == "darwin" {
+		return exitError.ExitCode() == 44
+	}
+	return exitError.ExitCode() == 1
+}
+
+func (k *Keychain) commandError(key string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return KeychainError{Code: KeychainUnavailableErrorCode, Key: key, Err: fmt.Errorf("keychain command not found: %w", err)}
+	}
+	return KeychainError{Code: KeychainCommandErrorCode, Key: key, Err: err}
+}
+
+// GetHumanReadableErrorMessage returns a message for errors of the keychain to show to the user.
+func GetHumanReadableErrorMessage(err error) string {
+	var keychainError KeychainError
+	if !errors.As(err, &keychainError) {
+		return err.Error()
+	}
+
+	switch keychainError.Code {
+	case KeychainUnavailableErrorCode:
+		return fmt.Sprintf("The keychain is not available to store %s (on Linux, install secret-tool): %s", keychainError.Key, keychainError.Err)
+	case KeychainSecretNotFoundErrorCode:
+		return fmt.Sprintf("The secret %s was not found in the keychain. Store it again with `buchhalter config set --secret %s <value>`.", keychainError.Key, keychainError.Key)
+	}
+	return keychainError.Error()
+}
@@ -0,0 +1,75 @@
+package keychain
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// fakeKeychain stores secrets in memory and records the executed commands.
+type fakeKeychain struct {
+	secrets  map[string]string
+	commands []string
+}
+
+func (f *fakeKeychain) run(stdin string, name string, args ...string) ([]byte, error) {
+	f.commands = append(f.commands, name+" "+strings.Join(args, " "))
+	switch {
+	case name == "secret-tool" && args[0] == "store":
+		f.secrets[args[len(args)-1]] = stdin
+		return nil, nil
+	case name == "secret-tool" && args[0] == "lookup":
+		return []byte(f.secrets[args[len(args)-1]]), nil
+	}
+	return nil, errors.New("unexpected command")
+}
+
+func TestSetAndGetOnLinux(t *testing.T) {
+	f := &fakeKeychain{secrets: map[string]string{}}
+	k := &Keychain{service: SERVICE_NAME, goos: "linux", run: f.run}
+
+	err := k.Set("webhook_url", "https://hooks.example/T0/s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range f.commands {
+		if strings.Contains(command, "s3cr3t") {
+			t.Errorf("expected secret not to be passed as argument: %s", command)
+		}
+	}
+
+	secret, err := k.Get("webhook_url")
+	if err != nil || secret != "https://hooks.example/T0/s3cr3t" {
+		t.Errorf("unexpected secret %q (%v)", secret, err)
+	}
+
+	_, err = k.Get("unknown")
+	var keychainError KeychainError
+	if !errors.As(err, &keychainError) || keychainError.Code != KeychainSecretNotFoundErrorCode {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestUnsupportedOperatingSystem(t *testing.T) {
+	k := &Keychain{service: SERVICE_NAME, goos: "plan9", run: func(string, string, ...string) ([]byte, error) {
+		return nil, exec.ErrNotFound
+	}}
+
+	err := k.Set("key", "value")
+	var keychainError KeychainError
+	if !errors.As(err, &keychainError) || keychainError.Code != KeychainUnavailableErrorCode {
+		t.Errorf("expected unavailable error, got %v", err)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	if key, ok := ParseReference(Reference("smtp_password")); !ok || key != "smtp_password" {
+		t.Errorf("expected reference to smtp_password, got %q (%t)", key, ok)
+	}
+	for _, value := range []string{"keychain:", "https://example.com", ""} {
+		if _, ok := ParseReference(value); ok {
+			t.Errorf("expected %q not to be a reference", value)
+		}
+	}
+}
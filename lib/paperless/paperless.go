@@ -0,0 +1,303 @@
+package paperless
+
+// Client for the REST API of Paperless-ngx (https://docs.paperless-ngx.com/api/).
+// New documents of the archive are consumed by Paperless with the supplier as correspondent and the document tags as tags.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/httpclient"
+)
+
+const pushedDocumentsFileName = "paperless_pushed.json"
+
+type Client struct {
+	logger     *slog.Logger
+	httpClient *httpclient.Client
+	host       *url.URL
+	token      string
+
+	correspondentIDs map[string]int
+	tagIDs           map[string]int
+}
+
+type namedObject struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type namedObjectList struct {
+	Results []namedObject `json:"results"`
+}
+
+// NewClient creates a client for the Paperless-ngx instance at host, authenticated with an API token.
+func NewClient(logger *slog.Logger, httpClient *httpclient.Client, host, token string) (*Client, error) {
+	hostURL, err := url.Parse(host)
+	if err != nil || hostURL.Scheme == "" || hostURL.Host == "" {
+		return nil, fmt.Errorf("invalid Paperless-ngx host %s", host)
+	}
+	if token == "" {
+		return nil, errors.New("missing Paperless-ngx API token")
+	}
+
+	return &Client{
+		logger:     logger,
+		httpClient: httpClient,
+		host:       hostURL,
+		token:      token,
+
+		correspondentIDs: map[string]int{},
+		tagIDs:           map[string]int{},
+	}, nil
+}
+
+// PushDocument uploads the document to the consumption queue of Paperless-ngx.
+// The supplier is used as correspondent, tags are mapped to Paperless tags. Missing correspondents and tags are created.
+// Returns the id of the consumption task.
+func (c *Client) PushDocument(ctx context.Context, file archive.File) (string, error) {
+	var fields [][2]string
+	fields = append(fields, [2]string{"title", strings.TrimSuffix(filepath.Base(file.Path), filepath.Ext(file.Path))})
+	if !file.AddedAt.IsZero() {
+		fields = append(fields, [2]string{"created", file.AddedAt.Format(time.RFC3339)})
+	}
+	if file.Supplier != "" {
+		correspondentID, err := c.objectID(ctx, "correspondents", c.correspondentIDs, file.Supplier)
+		if err != nil {
+			return "", err
+		}
+		fields = append(fields, [2]string{"correspondent", strconv.Itoa(correspondentID)})
+	}
+	for _, tag := range file.Tags {
+		tagID, err := c.objectID(ctx, "tags", c.tagIDs, tag)
+		if err != nil {
+			return "", err
+		}
+		fields = append(fields, [2]string{"tags", strconv.Itoa(tagID)})
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, field := range fields {
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return "", err
+		}
+	}
+	part, err := writer.CreateFormFile("document", filepath.Base(file.Path))
+	if err != nil {
+		return "", err
+	}
+	fileHandle, err := os.Open(file.Path)
+	if err != nil {
+		return "", err
+	}
+	defer fileHandle.Close()
+	_, err = io.Copy(part, fileHandle)
+	if err != nil {
+		return "", err
+	}
+	err = writer.Close()
+	if err != nil {
+		return "", err
+	}
+
+	bodyBytes := body.Bytes()
+	req, err := c.newRequest(ctx, http.MethodPost, "api/documents/post_document/", bodyBytes)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", httpclient.StatusError(resp, "")
+	}
+
+	// The task id is returned as JSON string
+	var taskID string
+	err = json.NewDecoder(resp.Body).Decode(&taskID)
+	if err != nil {
+		return "", fmt.Errorf("error decoding Paperless-ngx response: %w", err)
+	}
+
+	return taskID, nil
+}
+
+// objectID returns the id of the correspondent or tag with the given name and creates it if it doesn't exist.
+func (c *Client) objectID(ctx context.Context, objectType string, cache map[string]int, name string) (int, error) {
+	if id, ok := cache[name]; ok {
+		return id, nil
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, "api/"+objectType+"/?name__iexact="+url.QueryEscape(name), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, httpclient.StatusError(resp, "")
+	}
+	var list namedObjectList
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return 0, fmt.Errorf("error decoding Paperless-ngx %s: %w", objectType, err)
+	}
+	if len(list.Results) > 0 {
+		cache[name] = list.Results[0].ID
+		return list.Results[0].ID, nil
+	}
+
+	c.logger.Info("Creating Paperless-ngx object", "type", objectType, "name", name)
+	payload, err := json.Marshal(namedObject{Name: name})
+	if err != nil {
+		return 0, err
+	}
+	req, err = c.newRequest(ctx, http.MethodPost, "api/"+objectType+"/", payload)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	createResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer createResp.Body.Close()
+	if createResp.StatusCode != http.StatusCreated {
+		return 0, httpclient.StatusError(createResp, "")
+	}
+	var created namedObject
+	err = json.NewDecoder(createResp.Body).Decode(&created)
+	if err != nil {
+		return 0, fmt.Errorf("error decoding Paperless-ngx %s: %w", objectType, err)
+	}
+
+	cache[name] = created.ID
+	return created.ID, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, endpoint string, body []byte) (*http.Request, error) {
+	path, query, _ := strings.Cut(endpoint, "?")
+	apiURL := c.host.JoinPath(path)
+	// JoinPath drops the trailing slash the Paperless API requires
+	if strings.HasSuffix(path, "/") && !strings.HasSuffix(apiURL.Path, "/") {
+		apiURL.Path += "/"
+	}
+	apiURL.RawQuery = query
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiURL.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json; version=5")
+	req.Header.Set("Authorization", "Token "+c.token)
+
+	return req, nil
+}
+
+// Consumer pushes all documents of the archive that haven't been pushed to Paperless-ngx before.
+// The checksums of pushed documents are persisted in the config directory.
+type Consumer struct {
+	logger *slog.Logger
+	client *Client
+
+	configDirectory string
+}
+
+func NewConsumer(logger *slog.Logger, client *Client, configDirectory string) *Consumer {
+	return &Consumer{
+		logger:          logger,
+		client:          client,
+		configDirectory: configDirectory,
+	}
+}
+
+// Push pushes all new, not rejected and not staged documents of fileIndex.
+// Pushing stops at the first error, the remaining documents are pushed on the next run.
+// Returns the number of pushed documents.
+func (c *Consumer) Push(ctx context.Context, fileIndex map[string]archive.File) (int, error) {
+	pushedDocuments, err := c.readPushedDocuments()
+	if err != nil {
+		return 0, err
+	}
+
+	// Oldest documents first, so Paperless gets them in order
+	checksums := make([]string, 0, len(fileIndex))
+	for checksum, f := range fileIndex {
+		if _, ok := pushedDocuments[checksum]; ok || f.Rejected || f.Staged {
+			continue
+		}
+		checksums = append(checksums, checksum)
+	}
+	sort.Slice(checksums, func(i, j int) bool {
+		return fileIndex[checksums[i]].AddedAt.Before(fileIndex[checksums[j]].AddedAt)
+	})
+
+	pushed := 0
+	for _, checksum := range checksums {
+		f := fileIndex[checksum]
+		taskID, err := c.client.PushDocument(ctx, f)
+		if err != nil {
+			return pushed, fmt.Errorf("error pushing %s to Paperless-ngx: %w", f.Path, err)
+		}
+		c.logger.Info("Pushed document to Paperless-ngx", "file", f.Path, "supplier", f.Supplier, "task_id", taskID)
+
+		pushedDocuments[checksum] = time.Now()
+		err = c.writePushedDocuments(pushedDocuments)
+		if err != nil {
+			return pushed, err
+		}
+		pushed++
+	}
+
+	return pushed, nil
+}
+
+func (c *Consumer) readPushedDocuments() (map[string]time.Time, error) {
+	pushedDocuments := map[string]time.Time{}
+
+	fileContent, err := os.ReadFile(filepath.Join(c.configDirectory, pushedDocumentsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return pushedDocuments, nil
+	}
+	if err != nil {
+		return pushedDocuments, err
+	}
+
+	err = json.Unmarshal(fileContent, &pushedDocuments)
+	return pushedDocuments, err
+}
+
+func (c *Consumer) writePushedDocuments(pushedDocuments map[string]time.Time) error {
+	fileContent, err := json.MarshalIndent(pushedDocuments, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(c.configDirectory, pushedDocumentsFileName), fileContent, 0600)
+}
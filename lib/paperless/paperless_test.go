@@ -0,0 +1,88 @@
+package paperless
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/httpclient"
+)
+
+func TestConsumerPushesNewDocuments(t *testing.T) {
+	var createdCorrespondents []string
+	var postedDocuments []*http.Request
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/correspondents/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost {
+			var object namedObject
+			_ = json.NewDecoder(r.Body).Decode(&object)
+			createdCorrespondents = append(createdCorrespondents, object.Name)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(namedObject{ID: 7, Name: object.Name})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(namedObjectList{})
+	})
+	mux.HandleFunc("/api/tags/", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(namedObjectList{Results: []namedObject{{ID: 3, Name: r.URL.Query().Get("name__iexact")}}})
+	})
+	mux.HandleFunc("/api/documents/post_document/", func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(1 << 20)
+		if err != nil {
+			t.Errorf("error parsing document upload: %s", err)
+		}
+		postedDocuments = append(postedDocuments, r)
+		_ = json.NewEncoder(w).Encode("task-1")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	documentPath := filepath.Join(t.TempDir(), "invoice-2024-01.pdf")
+	if err := os.WriteFile(documentPath, []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileIndex := map[string]archive.File{
+		"a": {Path: documentPath, Supplier: "acme", Tags: []string{"hosting"}, AddedAt: time.Now()},
+		"b": {Path: documentPath, Supplier: "acme", Rejected: true},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := NewClient(logger, httpclient.New(logger, 5*time.Second, 0), server.URL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := NewConsumer(logger, client, t.TempDir())
+
+	pushed, err := consumer.Push(context.Background(), fileIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushed != 1 || len(postedDocuments) != 1 {
+		t.Fatalf("expected 1 pushed document, got %d", pushed)
+	}
+	form := postedDocuments[0].MultipartForm
+	if form.Value["title"][0] != "invoice-2024-01" || form.Value["correspondent"][0] != "7" || form.Value["tags"][0] != "3" {
+		t.Errorf("unexpected document fields %v", form.Value)
+	}
+	if len(createdCorrespondents) != 1 || createdCorrespondents[0] != "acme" {
+		t.Errorf("expected correspondent acme to be created, got %v", createdCorrespondents)
+	}
+
+	// Documents are pushed only once
+	pushed, err = consumer.Push(context.Background(), fileIndex)
+	if err != nil || pushed != 0 {
+		t.Errorf("expected no document to be pushed again, got %d (%v)", pushed, err)
+	}
+}
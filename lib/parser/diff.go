@@ -0,0 +1,170 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+const approvedRecipesFileName = ".buchhalter-approved-recipes.json"
+
+// RecipeDiff describes the changes of a recipe compared to the version the user approved before.
+type RecipeDiff struct {
+	Supplier   string
+	OldVersion string
+	NewVersion string
+	Changes    []string
+}
+
+// DiffRecipes compares two versions of a recipe step by step.
+// URLs and scripts are reported in full, as they are the most sensitive parts of a recipe.
+func DiffRecipes(oldRecipe, newRecipe *Recipe) *RecipeDiff {
+	diff := &RecipeDiff{
+		Supplier:   newRecipe.Supplier,
+		OldVersion: oldRecipe.Version,
+		NewVersion: newRecipe.Version,
+	}
+
+	if oldRecipe.Type != newRecipe.Type {
+		diff.Changes = append(diff.Changes, fmt.Sprintf("type: %s -> %s", oldRecipe.Type, newRecipe.Type))
+	}
+	if !reflect.DeepEqual(oldRecipe.Domains, newRecipe.Domains) {
+		diff.Changes = append(diff.Changes, fmt.Sprintf("domains: %v -> %v", oldRecipe.Domains, newRecipe.Domains))
+	}
+	if !reflect.DeepEqual(oldRecipe.Permissions, newRecipe.Permissions) {
+		diff.Changes = append(diff.Changes, fmt.Sprintf("permissions: %v -> %v", oldRecipe.Permissions, newRecipe.Permissions))
+	}
+	if !reflect.DeepEqual(oldRecipe.DependsOn, newRecipe.DependsOn) {
+		diff.Changes = append(diff.Changes, fmt.Sprintf("dependsOn: %v -> %v", oldRecipe.DependsOn, newRecipe.DependsOn))
+	}
+
+	for i := 0; i < max(len(oldRecipe.Steps), len(newRecipe.Steps)); i++ {
+		switch {
+		case i >= len(oldRecipe.Steps):
+			diff.Changes = append(diff.Changes, fmt.Sprintf("step %d added: %s", i+1, describeStep(newRecipe.Steps[i])))
+		case i >= len(newRecipe.Steps):
+			diff.Changes = append(diff.Changes, fmt.Sprintf("step %d removed: %s", i+1, describeStep(oldRecipe.Steps[i])))
+		default:
+			diff.Changes = append(diff.Changes, diffSteps(i+1, oldRecipe.Steps[i], newRecipe.Steps[i])...)
+		}
+	}
+
+	return diff
+}
+
+func diffSteps(number int, oldStep, newStep Step) []string {
+	if reflect.DeepEqual(oldStep, newStep) {
+		return nil
+	}
+	if oldStep.Action != newStep.Action {
+		return []string{fmt.Sprintf("step %d replaced: %s -> %s", number, describeStep(oldStep), describeStep(newStep))}
+	}
+
+	var changes []string
+	if oldStep.URL != newStep.URL {
+		changes = append(changes, fmt.Sprintf("step %d (%s) url: %s -> %s", number, newStep.Action, oldStep.URL, newStep.URL))
+	}
+	if oldStep.Value != newStep.Value {
+		changes = append(changes, fmt.Sprintf("step %d (%s) value: %s -> %s", number, newStep.Action, oldStep.Value, newStep.Value))
+	}
+	if oldStep.Selector != newStep.Selector {
+		changes = append(changes, fmt.Sprintf("step %d (%s) selector: %s -> %s", number, newStep.Action, oldStep.Selector, newStep.Selector))
+	}
+
+	// Compare the remaining settings without the fields reported above
+	oldStep.URL, oldStep.Value, oldStep.Selector = "", "", ""
+	newStep.URL, newStep.Value, newStep.Selector = "", "", ""
+	if !reflect.DeepEqual(oldStep, newStep) {
+		changes = append(changes, fmt.Sprintf("step %d (%s) settings changed", number, newStep.Action))
+	}
+
+	return changes
+}
+
+func describeStep(step Step) string {
+	switch {
+	case IsScriptStep(step):
+		return fmt.Sprintf("%s %s", step.Action, step.Value)
+	case step.URL != "":
+		return fmt.Sprintf("%s %s", step.Action, step.URL)
+	case step.Selector != "":
+		return fmt.Sprintf("%s %s", step.Action, step.Selector)
+	}
+	return step.Action
+}
+
+// RecipeApprovalStore persists the last version of each recipe the user approved to run.
+type RecipeApprovalStore struct {
+	logger *slog.Logger
+	mutex  sync.Mutex
+
+	configDirectory string
+	recipes         map[string]Recipe
+}
+
+func NewRecipeApprovalStore(logger *slog.Logger, configDirectory string) (*RecipeApprovalStore, error) {
+	s := &RecipeApprovalStore{
+		logger:          logger,
+		configDirectory: configDirectory,
+		recipes:         make(map[string]Recipe),
+	}
+
+	fileContent, err := os.ReadFile(filepath.Join(configDirectory, approvedRecipesFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(fileContent, &s.recipes)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Diff returns the changes of recipe since the last approval.
+// It returns nil if the recipe is unchanged or has never been approved before.
+func (s *RecipeApprovalStore) Diff(recipe *Recipe) *RecipeDiff {
+	s.mutex.Lock()
+	approved, ok := s.recipes[recipe.Supplier]
+	s.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+
+	diff := DiffRecipes(&approved, recipe)
+	if len(diff.Changes) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// IsKnown returns true if a version of the supplier recipe has been approved before.
+func (s *RecipeApprovalStore) IsKnown(supplier string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.recipes[supplier]
+	return ok
+}
+
+// Approve stores recipe as the approved version of the supplier recipe.
+func (s *RecipeApprovalStore) Approve(recipe *Recipe) error {
+	s.logger.Info("Storing approved recipe", "supplier", recipe.Supplier, "version", recipe.Version)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.recipes[recipe.Supplier] = *recipe
+
+	fileContent, err := json.MarshalIndent(s.recipes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.configDirectory, approvedRecipesFileName), fileContent, 0600)
+}
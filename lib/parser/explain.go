@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// credentialPlaceholders are replaced with the credentials of the supplier by `type` steps.
+var credentialPlaceholders = map[string]string{
+	"{{ username }}": "your username",
+	"{{ password }}": "your password",
+	"{{ totp }}":     "your one-time password",
+}
+
+// Explain returns a human-readable description of each step of the recipe,
+// so users can audit what runs against their accounts before granting credentials.
+func (r *Recipe) Explain() []string {
+	explanations := make([]string, 0, len(r.Steps))
+	for _, step := range r.Steps {
+		explanation := ExplainStep(step)
+		if step.Optional {
+			explanation += " (optional)"
+		}
+		if step.When.URL != "" {
+			explanation += fmt.Sprintf(" (only if the page URL matches %s)", step.When.URL)
+		}
+		explanations = append(explanations, explanation)
+	}
+	return explanations
+}
+
+// ExplainStep returns a human-readable description of what a recipe step does.
+func ExplainStep(step Step) string {
+	switch step.Action {
+	case "open":
+		return fmt.Sprintf("Navigate to %s", step.URL)
+	case "removeElement":
+		return fmt.Sprintf("Remove the element %s from the page", step.Selector)
+	case "click":
+		return fmt.Sprintf("Click on %s", step.Selector)
+	case "type":
+		return fmt.Sprintf("Enter %s into %s", explainTypedValue(step.Value), step.Selector)
+	case "sleep":
+		return fmt.Sprintf("Wait %d seconds", step.SleepDuration)
+	case "waitFor":
+		return fmt.Sprintf("Wait until %s is shown", step.Selector)
+	case "downloadAll":
+		return fmt.Sprintf("Download all files linked by %s", step.Selector)
+	case "transform":
+		return explainTransform(step)
+	case "move":
+		return fmt.Sprintf("Move downloaded files matching %s into the document archive", step.Value)
+	case "runScript":
+		return fmt.Sprintf("Run JavaScript in the logged in session: %s", step.Value)
+	case "runScriptDownloadUrls":
+		return fmt.Sprintf("Run JavaScript in the logged in session and download the returned URLs: %s", step.Value)
+	case "extract":
+		return fmt.Sprintf("Read %s of %s into the variable %s", explainAttribute(step.Attribute, "the text"), step.Selector, step.Variable)
+	case "extractTable":
+		return fmt.Sprintf("Read the invoice numbers, dates and amounts of the documents listed in the table %s", step.Selector)
+	case "downloadWithSession", "downloadViaFetch":
+		return fmt.Sprintf("Download the documents %s with the session of the logged in browser", explainDownloadSource(step))
+	case "printToPdf":
+		return fmt.Sprintf("Save the current page as PDF %s", step.Value)
+	case "captureResponse":
+		return fmt.Sprintf("Capture the response of requests matching %s into the variable %s", step.Regex, step.Variable)
+	case "oauth2-setup":
+		return fmt.Sprintf("Use the OAuth2 login of %s", step.Oauth2.AuthUrl)
+	case "oauth2-check-tokens":
+		return "Reuse cached OAuth2 tokens of a previous login, if still valid"
+	case "oauth2-authenticate":
+		return "Log in with your username, password and one-time password to get new OAuth2 tokens"
+	case "oauth2-post-and-get-items":
+		return fmt.Sprintf("Request the document list from %s and download each document from %s", step.URL, step.DocumentUrl)
+	case "fints-statements":
+		return fmt.Sprintf("Log in to the FinTS server %s (bank code %s) with your login name and PIN and download the account statements", step.URL, step.Fints.BankCode)
+	case "ebics-statements":
+		return fmt.Sprintf("Download the account statements from the EBICS server %s (host %s) with the keys created by `buchhalter ebics init`", step.URL, step.Ebics.HostID)
+	}
+
+	if step.Description != "" {
+		return fmt.Sprintf("%s (%s)", step.Description, step.Action)
+	}
+	return fmt.Sprintf("Run the unknown action %s", step.Action)
+}
+
+// explainTypedValue replaces credential placeholders, literal values are quoted.
+func explainTypedValue(value string) string {
+	if explanation, ok := credentialPlaceholders[strings.TrimSpace(value)]; ok {
+		return explanation
+	}
+	for placeholder, explanation := range credentialPlaceholders {
+		if strings.Contains(value, placeholder) {
+			return fmt.Sprintf("%q (containing %s)", value, explanation)
+		}
+	}
+	return fmt.Sprintf("%q", value)
+}
+
+func explainAttribute(attribute, fallback string) string {
+	if attribute == "" {
+		return fallback
+	}
+	return fmt.Sprintf("the attribute %s", attribute)
+}
+
+func explainDownloadSource(step Step) string {
+	if step.Selector == "" {
+		return fmt.Sprintf("returned by the JavaScript %s", step.Value)
+	}
+	return fmt.Sprintf("linked by %s (%s)", step.Selector, explainAttribute(step.Attribute, "href"))
+}
+
+func explainTransform(step Step) string {
+	switch {
+	case (step.Value == "unzip" || step.Value == "extract") && step.Extract.Filter != "":
+		return fmt.Sprintf("Extract the downloaded archives (files matching %s)", step.Extract.Filter)
+	case step.Value == "splitPdf" && step.SplitPdf.Pages != "":
+		return fmt.Sprintf("Split the downloaded PDF files into the pages %s", step.SplitPdf.Pages)
+	case step.Value == "splitPdf":
+		return "Split the downloaded PDF files into one file per bookmark"
+	case step.Value == "mergePdf":
+		return "Merge the downloaded PDF files into one file"
+	case step.Value == "normalize-csv":
+		return "Convert the downloaded CSV statements into the canonical CSV schema (stored alongside the originals)"
+	}
+	return fmt.Sprintf("Transform the downloaded files (%s)", step.Value)
+}
@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"encoding/json"
+)
+
+// legacySupplierField is the name of the supplier of a recipe in older Open Invoice Collector Databases
+const legacySupplierField = "provider"
+
+// MigrateDatabase renames the legacy fields of an Open Invoice Collector Database, so older databases still validate
+// against the current schema: "provider" of recipes is "supplier" now.
+// Returns whether the database was migrated, unchanged databases are returned as they are.
+func MigrateDatabase(database []byte) ([]byte, bool, error) {
+	var db map[string]json.RawMessage
+	err := json.Unmarshal(database, &db)
+	if err != nil {
+		return database, false, err
+	}
+	var recipes []json.RawMessage
+	if db["recipes"] == nil {
+		return database, false, nil
+	}
+	err = json.Unmarshal(db["recipes"], &recipes)
+	if err != nil {
+		return database, false, err
+	}
+
+	migrated := false
+	for i := range recipes {
+		recipe, recipeMigrated, err := MigrateRecipe(recipes[i])
+		if err != nil {
+			return database, false, err
+		}
+		recipes[i] = recipe
+		migrated = migrated || recipeMigrated
+	}
+	if !migrated {
+		return database, false, nil
+	}
+
+	db["recipes"], err = json.Marshal(recipes)
+	if err != nil {
+		return database, false, err
+	}
+	database, err = json.Marshal(db)
+	return database, true, err
+}
+
+// MigrateRecipe renames the legacy fields of a recipe, e.g. of a local recipe (see MigrateDatabase).
+func MigrateRecipe(recipe []byte) ([]byte, bool, error) {
+	var r map[string]json.RawMessage
+	err := json.Unmarshal(recipe, &r)
+	if err != nil {
+		return recipe, false, err
+	}
+	supplier, ok := r[legacySupplierField]
+	if !ok {
+		return recipe, false, nil
+	}
+	delete(r, legacySupplierField)
+	if _, ok := r["supplier"]; !ok {
+		r["supplier"] = supplier
+	}
+	recipe, err = json.Marshal(r)
+	return recipe, true, err
+}
@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRecipesMigratesLegacySupplierField(t *testing.T) {
+	configDirectory := t.TempDir()
+	schema := `{"type":"object","properties":{"recipes":{"type":"array","items":{"type":"object","required":["supplier"]}}}}`
+	database := `{"name":"oicdb","version":"2023.01.01","recipes":[
+		{"provider":"hetzner","domains":["accounts.hetzner.com"],"version":"1.0.0","type":"browser","steps":[]},
+		{"supplier":"github","domains":["github.com"],"version":"1.0.0","type":"browser","steps":[]}
+	]}`
+	if err := os.WriteFile(filepath.Join(configDirectory, "oicdb.schema.json"), []byte(schema), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDirectory, "oicdb.json"), []byte(database), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewRecipeParser(slog.Default(), configDirectory, t.TempDir())
+	if _, err := p.LoadRecipes(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, supplier := range []string{"hetzner", "github"} {
+		if p.GetRecipeBySupplier(supplier) == nil {
+			t.Errorf("recipe of %s not loaded", supplier)
+		}
+	}
+	if err := ValidateDatabase(configDirectory, []byte(database)); err != nil {
+		t.Errorf("legacy database not valid: %s", err)
+	}
+}
+
+func TestMigrateRecipe(t *testing.T) {
+	recipe := []byte(`{"supplier":"hetzner","version":"1.0.0"}`)
+	migratedRecipe, migrated, err := MigrateRecipe(recipe)
+	if err != nil || migrated || string(migratedRecipe) != string(recipe) {
+		t.Errorf("expected the recipe to be unchanged, got %s (%v)", migratedRecipe, err)
+	}
+
+	migratedRecipe, migrated, err = MigrateRecipe([]byte(`{"provider":"hetzner","version":"1.0.0"}`))
+	if err != nil || !migrated || string(migratedRecipe) != `{"supplier":"hetzner","version":"1.0.0"}` {
+		t.Errorf("unexpected migrated recipe %s (%v)", migratedRecipe, err)
+	}
+}
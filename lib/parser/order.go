@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ExecutionOrder returns the indices of recipes in the order they run: dependencies (DependsOn) first, then the
+// highest priority, then the given order. Dependencies on suppliers without a recipe in recipes are ignored.
+// A dependency cycle is returned as error.
+func ExecutionOrder(recipes []*Recipe) ([]int, error) {
+	indicesOfSupplier := map[string][]int{}
+	for i, recipe := range recipes {
+		indicesOfSupplier[recipe.Supplier] = append(indicesOfSupplier[recipe.Supplier], i)
+	}
+
+	// dependents[i] are the recipes waiting for recipe i, pending[i] is the number of recipes recipe i waits for
+	dependents := make([][]int, len(recipes))
+	pending := make([]int, len(recipes))
+	for i, recipe := range recipes {
+		for _, dependency := range recipe.DependsOn {
+			for _, j := range indicesOfSupplier[dependency] {
+				if j == i {
+					continue
+				}
+				dependents[j] = append(dependents[j], i)
+				pending[i]++
+			}
+		}
+	}
+
+	var ready []int
+	for i := range recipes {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	order := make([]int, 0, len(recipes))
+	for len(ready) > 0 {
+		sort.SliceStable(ready, func(a, b int) bool {
+			if recipes[ready[a]].Priority != recipes[ready[b]].Priority {
+				return recipes[ready[a]].Priority > recipes[ready[b]].Priority
+			}
+			return ready[a] < ready[b]
+		})
+		next := ready[0]
+		ready = ready[1:]
+		order = append(order, next)
+		for _, dependent := range dependents[next] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(order) < len(recipes) {
+		var cycle []string
+		for i := range recipes {
+			if pending[i] > 0 {
+				cycle = append(cycle, recipes[i].Supplier)
+			}
+		}
+		return nil, fmt.Errorf("the recipes of %s depend on each other", strings.Join(cycle, ", "))
+	}
+	return order, nil
+}
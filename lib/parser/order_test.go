@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExecutionOrder(t *testing.T) {
+	recipes := []*Recipe{
+		{Supplier: "hetzner"},
+		{Supplier: "office365", DependsOn: []string{"azure-sso"}},
+		{Supplier: "azure-sso", DependsOn: []string{"not-in-this-run"}},
+		{Supplier: "bank", Priority: 10},
+		{Supplier: "github", DependsOn: []string{"azure-sso"}, Priority: 5},
+	}
+
+	order, err := ExecutionOrder(recipes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bank has the highest priority, github outranks office365 once azure-sso ran
+	expected := []int{3, 0, 2, 4, 1}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+}
+
+func TestExecutionOrderDetectsCycles(t *testing.T) {
+	recipes := []*Recipe{
+		{Supplier: "hetzner"},
+		{Supplier: "a", DependsOn: []string{"b"}},
+		{Supplier: "b", DependsOn: []string{"a"}},
+	}
+
+	_, err := ExecutionOrder(recipes)
+	if err == nil || !strings.Contains(err.Error(), "a, b depend on each other") {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
@@ -39,12 +41,31 @@ type Database struct {
 }
 
 type Recipe struct {
-	// TODO Rename Prodiver to Supplier
+	// Supplier of the recipe, older databases call it "provider" (see MigrateDatabase)
 	Supplier string   `json:"supplier"`
 	Domains  []string `json:"domains"`
 	Version  string   `json:"version"`
 	Type     string   `json:"type"`
 	Steps    []Step   `json:"steps"`
+	// Permissions declares sensitive capabilities the recipe needs (e.g. "script")
+	Permissions []string `json:"permissions,omitempty"`
+	// ResourcePolicy defines the resources blocked while running the recipe (default: images)
+	ResourcePolicy *ResourcePolicy `json:"resourcePolicy,omitempty"`
+	// Locale of the supplier portal (e.g. "de-DE"), sets the browser language and the Accept-Language header
+	Locale string `json:"locale,omitempty"`
+	// Priority orders the recipes of a run, recipes with a higher priority run first (default: 0)
+	Priority int `json:"priority,omitempty"`
+	// DependsOn lists suppliers whose recipes run before this one (e.g. the warm-up recipe of an SSO identity provider)
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Frequency the supplier issues documents at (weekly or monthly), the recipe runs once per period
+	Frequency string `json:"frequency,omitempty"`
+}
+
+// ResourcePolicy defines which resources (image, font, media, stylesheet, thirdParty) of supplier portals are blocked.
+// Requests to AllowDomains are never blocked.
+type ResourcePolicy struct {
+	Block        []string `json:"block"`
+	AllowDomains []string `json:"allowDomains,omitempty"`
 }
 
 type Step struct {
@@ -52,13 +73,27 @@ type Step struct {
 	URL          string `json:"url,omitempty"`
 	Selector     string `json:"selector,omitempty"`
 	SelectorType string `json:"selectorType,omitempty"`
-	Value        string `json:"value,omitempty"`
-	Description  string `json:"description,omitempty"`
-	When         struct {
+	// Selectors are alternatives of Selector keyed by language (e.g. "de", "en") for portals serving different DOMs per language
+	Selectors   map[string]string `json:"selectors,omitempty"`
+	Value       string            `json:"value,omitempty"`
+	Description string            `json:"description,omitempty"`
+	When        struct {
 		URL string `json:"url"`
 	} `json:"when,omitempty"`
 	SleepDuration int `json:"sleepDuration,omitempty"`
-	Oauth2        struct {
+	// Optional steps don't abort the recipe on failure (e.g. closing a promo popup that isn't always shown)
+	Optional bool `json:"optional,omitempty"`
+	// ContinueOnTimeout continues with the next step if this step times out (e.g. a download list that never finishes loading)
+	ContinueOnTimeout bool `json:"continueOnTimeout,omitempty"`
+	// WaitStrategy defines when a navigation is complete: load, domcontentloaded, networkidle (default) or selector
+	WaitStrategy string `json:"waitStrategy,omitempty"`
+	// WaitSelector is the element the "selector" wait strategy waits for
+	WaitSelector string `json:"waitSelector,omitempty"`
+	// WaitTimeout is the maximum time in seconds to wait for a navigation
+	WaitTimeout int `json:"waitTimeout,omitempty"`
+	// ResourcePolicy overrides the resource policy of the recipe for this step
+	ResourcePolicy *ResourcePolicy `json:"resourcePolicy,omitempty"`
+	Oauth2         struct {
 		AuthUrl            string `json:"authUrl"`
 		TokenUrl           string `json:"tokenUrl"`
 		RedirectUrl        string `json:"redirectUrl"`
@@ -66,15 +101,112 @@ type Step struct {
 		Scope              string `json:"scope"`
 		PkceMethod         string `json:"pkceMethod"`
 		PkceVerifierLength int    `json:"pkceVerifierLength"`
+		// AuthorizeParams are additional query parameters of the authorize URL (e.g. tenant, realm, audience or resource).
+		// Values may contain `{{ username }}` and the variables of `buchhalter_oauth2_variables` as placeholders.
+		AuthorizeParams map[string]string `json:"authorizeParams,omitempty"`
+		// TokenParams are additional fields of token requests, with the same placeholders as AuthorizeParams
+		TokenParams map[string]string `json:"tokenParams,omitempty"`
+		// TokenRequestEncoding of token requests: "json" (default) or "form" (application/x-www-form-urlencoded)
+		TokenRequestEncoding string `json:"tokenRequestEncoding,omitempty"`
+		// ClientAuthMethod of confidential clients: "none" (default), "client_secret_post" or "client_secret_basic"
+		ClientAuthMethod string `json:"clientAuthMethod,omitempty"`
+		// ClientSecret of confidential clients, usually a placeholder of `buchhalter_oauth2_variables` (e.g. `{{ client_secret }}`)
+		ClientSecret string `json:"clientSecret,omitempty"`
 	}
-	ExtractDocumentIds       string            `json:"extractDocumentIds,omitempty"`
-	ExtractDocumentFilenames string            `json:"extractDocumentFilenames,omitempty"`
-	DocumentUrl              string            `json:"documentUrl,omitempty"`
-	DocumentRequestMethod    string            `json:"documentRequestMethod,omitempty"`
-	DocumentRequestHeaders   map[string]string `json:"documentRequestHeaders,omitempty"`
-	Body                     string            `json:"body,omitempty"`
-	Headers                  map[string]string `json:"headers,omitempty"`
-	Execute                  string            `json:"execute,omitempty"`
+	ExtractDocumentIds       string `json:"extractDocumentIds,omitempty"`
+	ExtractDocumentFilenames string `json:"extractDocumentFilenames,omitempty"`
+	// ExtractDocumentChecksums and ExtractDocumentSizes extract the checksums and sizes (in bytes) of the documents
+	// announced by the supplier API. Downloads not matching them are retried.
+	ExtractDocumentChecksums string `json:"extractDocumentChecksums,omitempty"`
+	ExtractDocumentSizes     string `json:"extractDocumentSizes,omitempty"`
+	// ExtractDocumentDates extracts the dates of the documents, they are shown when listing documents (see `sync --list-only`)
+	ExtractDocumentDates string `json:"extractDocumentDates,omitempty"`
+	// DocumentChecksumAlgorithm of the extracted checksums: "sha256" (default), "sha1" or "md5"
+	DocumentChecksumAlgorithm string            `json:"documentChecksumAlgorithm,omitempty"`
+	DocumentUrl               string            `json:"documentUrl,omitempty"`
+	DocumentRequestMethod     string            `json:"documentRequestMethod,omitempty"`
+	DocumentRequestHeaders    map[string]string `json:"documentRequestHeaders,omitempty"`
+	Body                      string            `json:"body,omitempty"`
+	Headers                   map[string]string `json:"headers,omitempty"`
+	Execute                   string            `json:"execute,omitempty"`
+	Variable                  string            `json:"variable,omitempty"`
+	Attribute                 string            `json:"attribute,omitempty"`
+	Regex                     string            `json:"regex,omitempty"`
+	Concurrency               int               `json:"concurrency,omitempty"`
+	// Fints configures the FinTS server of `fints` recipes, the server URL is the URL of the step
+	Fints struct {
+		// BankCode is the German bank code (BLZ)
+		BankCode string `json:"bankCode"`
+		// Format of the statements: "mt940" (default) or "camt"
+		Format string `json:"format,omitempty"`
+		// Months is the number of completed months statements are retrieved for (default: 3)
+		Months int `json:"months,omitempty"`
+	} `json:"fints,omitempty"`
+	// Ebics configures the EBICS server of `ebics` recipes, the server URL is the URL of the step
+	Ebics struct {
+		// HostID is the EBICS host ID of the bank
+		HostID string `json:"hostId"`
+		// OrderType of the statements: "STA" (MT940, default) or "C53" (camt.053)
+		OrderType string `json:"orderType,omitempty"`
+		// Months is the number of completed months statements are downloaded for (default: 3)
+		Months int `json:"months,omitempty"`
+	} `json:"ebics,omitempty"`
+	// Extract configures `transform` steps with the value `extract` (or `unzip`), which extract downloaded archives
+	Extract struct {
+		// Filter is a glob of the names of the extracted files (e.g. *.pdf), all files are extracted if empty
+		Filter string `json:"filter,omitempty"`
+		// Password of encrypted archives, usually the placeholder `{{ password }}` of the credentials
+		Password string `json:"password,omitempty"`
+		// MaxDepth of nested archives (default: 3)
+		MaxDepth int `json:"maxDepth,omitempty"`
+	} `json:"extract,omitempty"`
+	// SplitPdf configures `transform` steps with the value `splitPdf`
+	SplitPdf struct {
+		// Filter is a glob of the names of the split files (default: *.pdf)
+		Filter string `json:"filter,omitempty"`
+		// Pages are the page ranges of the parts (e.g. `1-2,3-4` or `1,2-`). Without pages, each top-level bookmark starts a part.
+		Pages string `json:"pages,omitempty"`
+	} `json:"splitPdf,omitempty"`
+	// MergePdf configures `transform` steps with the value `mergePdf`
+	MergePdf struct {
+		// Filter is a glob of the names of the merged files, which are merged in the order of their names (default: *.pdf)
+		Filter string `json:"filter,omitempty"`
+		// Output is the name of the merged file (default: merged.pdf)
+		Output string `json:"output,omitempty"`
+	} `json:"mergePdf,omitempty"`
+	// Csv describes the CSV statements normalized by `transform` steps with the value `normalize-csv`
+	Csv struct {
+		// Delimiter of fields, detected from the header row if empty
+		Delimiter string `json:"delimiter,omitempty"`
+		// Encoding of the statements: "utf-8" (default), "iso-8859-1" or "windows-1252"
+		Encoding string `json:"encoding,omitempty"`
+		// DecimalComma is true for amounts like 1.234,56
+		DecimalComma bool `json:"decimalComma,omitempty"`
+		// SkipRows is the number of rows before the header row
+		SkipRows int `json:"skipRows,omitempty"`
+		// DateFormat is the Go layout of dates (e.g. 02.01.2006)
+		DateFormat string `json:"dateFormat,omitempty"`
+		// Currency of all rows if the statements have no currency column
+		Currency string `json:"currency,omitempty"`
+		// Columns maps the canonical columns (date, value_date, amount or debit and credit, currency, counterparty,
+		// counterparty_iban, purpose, reference) to the columns of the statements
+		Columns map[string]string `json:"columns,omitempty"`
+	} `json:"csv,omitempty"`
+	// Table configures `extractTable` steps, which scrape the document listing table matched by the selector
+	Table struct {
+		// Columns maps the metadata fields (invoiceNumber, date, amount, vatRate) to the columns of the table,
+		// either by the text of their header or by their position (starting at 1)
+		Columns map[string]string `json:"columns,omitempty"`
+	} `json:"table,omitempty"`
+	Pdf struct {
+		PaperFormat     string  `json:"paperFormat,omitempty"`
+		Landscape       bool    `json:"landscape,omitempty"`
+		PrintBackground bool    `json:"printBackground,omitempty"`
+		MarginTop       float64 `json:"marginTop,omitempty"`
+		MarginBottom    float64 `json:"marginBottom,omitempty"`
+		MarginLeft      float64 `json:"marginLeft,omitempty"`
+		MarginRight     float64 `json:"marginRight,omitempty"`
+	} `json:"pdf,omitempty"`
 }
 
 func NewRecipeParser(logger *slog.Logger, buchhalterConfigDirectory, buchhalterDirectory string) *RecipeParser {
@@ -90,11 +222,6 @@ func NewRecipeParser(logger *slog.Logger, buchhalterConfigDirectory, buchhalterD
 }
 
 func (p *RecipeParser) LoadRecipes(developmentMode bool) (bool, error) {
-	validationResult, err := validateRecipes(p.configDirectory)
-	if err != nil {
-		return validationResult, err
-	}
-
 	dbFile, err := os.Open(filepath.Join(p.configDirectory, "oicdb.json"))
 	if err != nil {
 		return false, err
@@ -102,6 +229,18 @@ func (p *RecipeParser) LoadRecipes(developmentMode bool) (bool, error) {
 	defer dbFile.Close()
 	byteValue, _ := io.ReadAll(dbFile)
 
+	byteValue, migrated, err := MigrateDatabase(byteValue)
+	if err != nil {
+		return false, err
+	}
+	if migrated {
+		p.logger.Info("Migrated legacy fields of the Open Invoice Collector Database", "legacy_field", legacySupplierField)
+	}
+	validationResult, err := validateRecipes(p.configDirectory, byteValue)
+	if err != nil {
+		return validationResult, err
+	}
+
 	err = json.Unmarshal(byteValue, &p.database)
 	if err != nil {
 		return false, err
@@ -154,11 +293,46 @@ func (p *RecipeParser) GetRecipeForItem(item vault.Item, urlsByItemId map[string
 	return nil
 }
 
-func validateRecipes(buchhalterConfigDirectory string) (bool, error) {
+// GetRecipeBySupplier returns the loaded recipe of supplier or nil if there is none.
+func (p *RecipeParser) GetRecipeBySupplier(supplier string) *Recipe {
+	recipe, ok := p.recipeBySupplier[supplier]
+	if !ok {
+		return nil
+	}
+	return &recipe
+}
+
+// FindSuppliers returns the suppliers of the loaded recipes whose name or domains contain query (case-insensitive), sorted by name.
+func (p *RecipeParser) FindSuppliers(query string) []string {
+	query = strings.ToLower(query)
+	var suppliers []string
+	for supplier, recipe := range p.recipeBySupplier {
+		match := strings.Contains(strings.ToLower(supplier), query)
+		for _, domain := range recipe.Domains {
+			match = match || strings.Contains(strings.ToLower(domain), query)
+		}
+		if match {
+			suppliers = append(suppliers, supplier)
+		}
+	}
+	sort.Strings(suppliers)
+	return suppliers
+}
+
+// Checksum returns the SHA-256 checksum of the recipe definition, e.g. to detect changes of a recipe without a new version.
+func (r *Recipe) Checksum() string {
+	definition, err := json.Marshal(r)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(definition))
+}
+
+func validateRecipes(buchhalterConfigDirectory string, database []byte) (bool, error) {
 	oicdbFile := "file://" + filepath.Join(buchhalterConfigDirectory, "oicdb.json")
 	oicdbSchemaFile := "file://" + filepath.Join(buchhalterConfigDirectory, "oicdb.schema.json")
 	schemaLoader := gojsonschema.NewReferenceLoader(oicdbSchemaFile)
-	documentLoader := gojsonschema.NewReferenceLoader(oicdbFile)
+	documentLoader := gojsonschema.NewBytesLoader(database)
 
 	result, err := gojsonschema.Validate(schemaLoader, documentLoader)
 	if err != nil {
@@ -178,6 +352,28 @@ func validateRecipes(buchhalterConfigDirectory string) (bool, error) {
 	return false, err
 }
 
+// ValidateDatabase validates an Open Invoice Collector Database against the local schema, e.g. before importing it.
+func ValidateDatabase(buchhalterConfigDirectory string, database []byte) error {
+	database, _, err := MigrateDatabase(database)
+	if err != nil {
+		return err
+	}
+	oicdbSchemaFile := "file://" + filepath.Join(buchhalterConfigDirectory, "oicdb.schema.json")
+	result, err := gojsonschema.Validate(gojsonschema.NewReferenceLoader(oicdbSchemaFile), gojsonschema.NewBytesLoader(database))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	errorMessageParts := []string{}
+	for _, errorDescription := range result.Errors() {
+		errorMessageParts = append(errorMessageParts, errorDescription.String())
+	}
+	return fmt.Errorf("the database (compared to schema %s) is not valid. See errors: %s", oicdbSchemaFile, strings.Join(errorMessageParts, ", "))
+}
+
 func (p *RecipeParser) loadLocalRecipes(buchhalterDirectory string) error {
 	sf := "_local/recipes"
 	recipesDir := filepath.Join(buchhalterDirectory, sf)
@@ -207,6 +403,10 @@ func (p *RecipeParser) loadLocalRecipes(buchhalterDirectory string) error {
 		if err != nil {
 			return err
 		}
+		byteValue, _, err = MigrateRecipe(byteValue)
+		if err != nil {
+			return err
+		}
 		n := p.getRecipeIndexBySupplier(filenameWithoutExtension)
 		if n >= 0 {
 			// Replace recipe if exists
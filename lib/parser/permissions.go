@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PERMISSION_SCRIPT allows a recipe to execute custom JavaScript in the authenticated browser session.
+	PERMISSION_SCRIPT = "script"
+
+	permissionsFileName = ".buchhalter-permissions.json"
+)
+
+// scriptActions are recipe step actions that evaluate JavaScript from the recipe.
+var scriptActions = map[string]bool{
+	"runScript":             true,
+	"runScriptDownloadUrls": true,
+}
+
+// scriptValueActions are recipe step actions that evaluate their value as JavaScript without a selector, e.g. to
+// collect the download URLs.
+var scriptValueActions = map[string]bool{
+	"downloadWithSession": true,
+	"downloadViaFetch":    true,
+}
+
+// IsScriptStep returns true if the step evaluates JavaScript from the recipe.
+func IsScriptStep(step Step) bool {
+	return scriptActions[step.Action] || (scriptValueActions[step.Action] && step.Selector == "")
+}
+
+// HasPermission returns true if the recipe declares the permission p.
+func (r *Recipe) HasPermission(p string) bool {
+	for _, permission := range r.Permissions {
+		if permission == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ScriptSources returns the JavaScript sources of all script steps of the recipe.
+func (r *Recipe) ScriptSources() []string {
+	var sources []string
+	for _, step := range r.Steps {
+		if IsScriptStep(step) {
+			sources = append(sources, step.Value)
+		}
+	}
+	return sources
+}
+
+// ScriptApproval is the stored decision of a user to allow scripts for a supplier.
+// The approved script sources are kept to show a diff once the recipe is updated.
+type ScriptApproval struct {
+	Checksum   string    `json:"checksum"`
+	Scripts    []string  `json:"scripts"`
+	Allowed    bool      `json:"allowed"`
+	ApprovedAt time.Time `json:"approvedAt"`
+}
+
+// PermissionStore persists the per-supplier permission decisions of the user.
+type PermissionStore struct {
+	logger *slog.Logger
+	mutex  sync.Mutex
+
+	configDirectory string
+	approvals       map[string]ScriptApproval
+}
+
+func NewPermissionStore(logger *slog.Logger, configDirectory string) (*PermissionStore, error) {
+	s := &PermissionStore{
+		logger:          logger,
+		configDirectory: configDirectory,
+		approvals:       make(map[string]ScriptApproval),
+	}
+
+	fileContent, err := os.ReadFile(filepath.Join(configDirectory, permissionsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(fileContent, &s.approvals)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ScriptsApproved returns true if the user allowed the current scripts of the recipe.
+// Recipes without scripts don't need an approval.
+func (s *PermissionStore) ScriptsApproved(recipe *Recipe) bool {
+	scripts := recipe.ScriptSources()
+	if len(scripts) == 0 {
+		return true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	approval, ok := s.approvals[recipe.Supplier]
+	return ok && approval.Allowed && approval.Checksum == scriptsChecksum(scripts)
+}
+
+// ChangedScripts returns the scripts of the recipe which have not been approved by the user before.
+func (s *PermissionStore) ChangedScripts(recipe *Recipe) []string {
+	s.mutex.Lock()
+	approved := make(map[string]bool)
+	for _, script := range s.approvals[recipe.Supplier].Scripts {
+		approved[script] = true
+	}
+	s.mutex.Unlock()
+
+	var changed []string
+	for _, script := range recipe.ScriptSources() {
+		if !approved[script] {
+			changed = append(changed, script)
+		}
+	}
+	return changed
+}
+
+// StoreScriptDecision stores the decision of the user for the current scripts of the recipe.
+func (s *PermissionStore) StoreScriptDecision(recipe *Recipe, allowed bool) error {
+	scripts := recipe.ScriptSources()
+	checksum := scriptsChecksum(scripts)
+	s.logger.Info("Storing script permission decision", "supplier", recipe.Supplier, "allowed", allowed, "script_checksum", checksum)
+	for _, script := range scripts {
+		s.logger.Info("Script source of recipe", "supplier", recipe.Supplier, "script", script)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.approvals[recipe.Supplier] = ScriptApproval{
+		Checksum:   checksum,
+		Scripts:    scripts,
+		Allowed:    allowed,
+		ApprovedAt: time.Now(),
+	}
+
+	fileContent, err := json.MarshalIndent(s.approvals, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.configDirectory, permissionsFileName), fileContent, 0600)
+}
+
+func scriptsChecksum(scripts []string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(scripts, "\x00"))))
+}
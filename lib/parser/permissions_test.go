@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestScriptStepsRequirePermission(t *testing.T) {
+	tests := []struct {
+		name   string
+		step   Step
+		script bool
+	}{
+		{"runScript", Step{Action: "runScript", Value: "document.title"}, true},
+		{"runScriptDownloadUrls", Step{Action: "runScriptDownloadUrls", Value: "[location.href]"}, true},
+		{"downloadViaFetch without selector", Step{Action: "downloadViaFetch", Value: "fetch('/steal')"}, true},
+		{"downloadWithSession without selector", Step{Action: "downloadWithSession", Value: "[location.href]"}, true},
+		{"downloadViaFetch with selector", Step{Action: "downloadViaFetch", Selector: "a.invoice"}, false},
+		{"click", Step{Action: "click", Selector: "#login"}, false},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if IsScriptStep(test.step) != test.script {
+				t.Errorf("expected IsScriptStep to be %t", test.script)
+			}
+
+			store, err := NewPermissionStore(logger, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			recipe := &Recipe{Supplier: "acme", Steps: []Step{test.step}}
+			if store.ScriptsApproved(recipe) == test.script {
+				t.Errorf("expected the approval to be required: %t", test.script)
+			}
+			if err := store.StoreScriptDecision(recipe, true); err != nil {
+				t.Fatal(err)
+			}
+			if !store.ScriptsApproved(recipe) {
+				t.Error("expected the scripts to be approved")
+			}
+		})
+	}
+}
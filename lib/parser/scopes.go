@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MinimalScopes is the catalogue of OAuth2 scopes each supplier needs to download documents.
+// It can be extended and overwritten with `buchhalter_minimal_scopes`.
+var MinimalScopes = map[string][]string{
+	// Fake supplier of `buchhalter devserver`
+	"devserver-oauth2": {"invoices"},
+}
+
+// broadScopePattern matches OAuth2 scopes granting more than read access, if a supplier is not in the catalogue.
+var broadScopePattern = regexp.MustCompile(`(?i)(^|[^a-z])(admin|write|manage|full|all|delete|owner)([^a-z]|$)|^\*$`)
+
+// adminURLPattern matches admin and account management areas of supplier portals, which are never needed to download documents.
+var adminURLPattern = regexp.MustCompile(`(?i)/(admin|administration|iam|api-?keys|access-management)(/|\?|#|$)`)
+
+// Oauth2Scopes returns the OAuth2 scopes requested by the recipe.
+func (r *Recipe) Oauth2Scopes() []string {
+	var scopes []string
+	for _, step := range r.Steps {
+		if step.Action == "oauth2-setup" {
+			scopes = append(scopes, strings.Fields(step.Oauth2.Scope)...)
+		}
+	}
+	return scopes
+}
+
+// OverrideOauth2Scope replaces the scope requested by all OAuth2 setup steps of the recipe.
+func (r *Recipe) OverrideOauth2Scope(scope string) {
+	for i := range r.Steps {
+		if r.Steps[i].Action == "oauth2-setup" {
+			r.Steps[i].Oauth2.Scope = scope
+		}
+	}
+}
+
+// ScopeWarnings returns a warning for each scope and portal URL of the recipe exceeding what is needed to download documents.
+// Scopes are compared against the minimal scopes of the supplier. Without a catalogue entry, only broad scopes (e.g. admin or write access) are reported.
+func (r *Recipe) ScopeWarnings(minimalScopes map[string][]string) []string {
+	var warnings []string
+
+	minimal, inCatalogue := minimalScopes[r.Supplier]
+	allowed := make(map[string]bool, len(minimal))
+	for _, scope := range minimal {
+		allowed[scope] = true
+	}
+	var excessScopes []string
+	for _, scope := range r.Oauth2Scopes() {
+		if (inCatalogue && !allowed[scope]) || (!inCatalogue && broadScopePattern.MatchString(scope)) {
+			excessScopes = append(excessScopes, scope)
+		}
+	}
+	if len(excessScopes) > 0 {
+		warnings = append(warnings, fmt.Sprintf("requests OAuth2 scopes beyond the minimum needed: %s", strings.Join(excessScopes, ", ")))
+	}
+
+	for _, step := range r.Steps {
+		if step.URL != "" && adminURLPattern.MatchString(step.URL) {
+			warnings = append(warnings, fmt.Sprintf("opens the admin area %s", step.URL))
+		}
+	}
+
+	return warnings
+}
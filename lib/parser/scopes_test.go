@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func scopeTestRecipe(supplier, scope, url string) *Recipe {
+	recipe := &Recipe{Supplier: supplier, Steps: []Step{{Action: "oauth2-setup"}, {Action: "oauth2-post-and-get-items", URL: url}}}
+	recipe.Steps[0].Oauth2.Scope = scope
+	return recipe
+}
+
+func TestScopeWarnings(t *testing.T) {
+	catalogue := map[string][]string{"acme": {"invoices:read", "offline_access"}}
+
+	tests := []struct {
+		name     string
+		recipe   *Recipe
+		expected []string
+	}{
+		{"minimal scopes", scopeTestRecipe("acme", "invoices:read offline_access", "https://acme.example/api/invoices"), nil},
+		{"scopes beyond catalogue", scopeTestRecipe("acme", "invoices:read profile users:write", "https://acme.example/api/invoices"), []string{"requests OAuth2 scopes beyond the minimum needed: profile, users:write"}},
+		{"broad scopes without catalogue", scopeTestRecipe("other", "openid read write admin.all", "https://other.example/api/invoices"), []string{"requests OAuth2 scopes beyond the minimum needed: write, admin.all"}},
+		{"admin url", scopeTestRecipe("other", "openid", "https://other.example/admin/billing"), []string{"opens the admin area https://other.example/admin/billing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := tt.recipe.ScopeWarnings(catalogue)
+			if !reflect.DeepEqual(warnings, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, warnings)
+			}
+		})
+	}
+}
+
+func TestOverrideOauth2Scope(t *testing.T) {
+	recipe := scopeTestRecipe("acme", "invoices:read users:write", "")
+	recipe.OverrideOauth2Scope("invoices:read")
+	if warnings := recipe.ScopeWarnings(map[string][]string{"acme": {"invoices:read"}}); len(warnings) != 0 {
+		t.Errorf("expected no warnings after override, got %q", warnings)
+	}
+}
@@ -0,0 +1,130 @@
+package pdf
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Info contains the metadata of the document information dictionary of a PDF document.
+type Info struct {
+	Title        string
+	Author       string
+	Creator      string
+	Producer     string
+	CreationDate time.Time
+}
+
+var infoEntryPattern = regexp.MustCompile(`/(Title|Author|Creator|Producer|CreationDate)\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+
+// ReadInfo extracts the document information of the PDF document content.
+// Information dictionaries in compressed object streams are not found, their fields stay empty.
+func ReadInfo(content []byte) Info {
+	var info Info
+	for _, match := range infoEntryPattern.FindAllSubmatch(content, -1) {
+		value := decodeString(string(match[2]))
+		switch string(match[1]) {
+		case "Title":
+			info.Title = value
+		case "Author":
+			info.Author = value
+		case "Creator":
+			info.Creator = value
+		case "Producer":
+			info.Producer = value
+		case "CreationDate":
+			info.CreationDate = parseDate(value)
+		}
+	}
+	return info
+}
+
+// decodeString decodes a PDF literal string (`(...)`) or hex string (`<...>`), UTF-16 strings are detected by their BOM.
+func decodeString(s string) string {
+	var raw []byte
+	if strings.HasPrefix(s, "<") {
+		var err error
+		raw, err = hex.DecodeString(strings.Join(strings.Fields(strings.Trim(s, "<>")), ""))
+		if err != nil {
+			return ""
+		}
+	} else {
+		raw = unescapeLiteral(s[1 : len(s)-1])
+	}
+
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+func unescapeLiteral(s string) []byte {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b = append(b, s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// Up to three octal digits
+			end := i + 1
+			for end < len(s) && end < i+3 && s[end] >= '0' && s[end] <= '7' {
+				end++
+			}
+			n, _ := strconv.ParseUint(s[i:end], 8, 8)
+			b = append(b, byte(n))
+			i = end - 1
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return b
+}
+
+// parseDate parses PDF dates like `D:20240901120000+02'00'`, the zero time if invalid.
+func parseDate(s string) time.Time {
+	s = strings.TrimPrefix(s, "D:")
+	if len(s) < 8 {
+		return time.Time{}
+	}
+	layout := "20060102150405"
+	if len(s) < len(layout) {
+		layout = layout[:len(s)]
+	}
+	value := s[:len(layout)]
+
+	location := time.UTC
+	zone := strings.ReplaceAll(s[len(layout):], "'", "")
+	if len(zone) == 5 && (zone[0] == '+' || zone[0] == '-') {
+		hours, errHours := strconv.Atoi(zone[1:3])
+		minutes, errMinutes := strconv.Atoi(zone[3:5])
+		if errHours == nil && errMinutes == nil {
+			offset := hours*3600 + minutes*60
+			if zone[0] == '-' {
+				offset = -offset
+			}
+			location = time.FixedZone(zone, offset)
+		}
+	}
+
+	t, err := time.ParseInLocation(layout, value, location)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,40 @@
+package pdf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadInfo(t *testing.T) {
+	content := []byte("%PDF-1.4\n1 0 obj\n<< /Title (Invoice \\(September\\)) /Author <FEFF004800e4006e0064006c00650072> /Producer (Acme\\040PDF) /CreationDate (D:20240901120000+02'00') >>\nendobj\n")
+
+	info := ReadInfo(content)
+	if info.Title != "Invoice (September)" {
+		t.Errorf("unexpected title %q", info.Title)
+	}
+	if info.Author != "Händler" {
+		t.Errorf("unexpected author %q", info.Author)
+	}
+	if info.Producer != "Acme PDF" {
+		t.Errorf("unexpected producer %q", info.Producer)
+	}
+	if !info.CreationDate.Equal(time.Date(2024, time.September, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected creation date %s", info.CreationDate)
+	}
+	if info.Creator != "" {
+		t.Errorf("expected no creator, got %q", info.Creator)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	for value, expected := range map[string]time.Time{
+		"D:20240901":             time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC),
+		"D:20240901120000Z":      time.Date(2024, time.September, 1, 12, 0, 0, 0, time.UTC),
+		"D:20240901120000-05'00": time.Date(2024, time.September, 1, 17, 0, 0, 0, time.UTC),
+		"invalid":                {},
+	} {
+		if date := parseDate(value); !date.Equal(expected) {
+			t.Errorf("%s: expected %s, got %s", value, expected, date)
+		}
+	}
+}
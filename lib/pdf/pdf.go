@@ -0,0 +1,177 @@
+package pdf
+
+// Splits and merges PDF documents with qpdf (https://qpdf.readthedocs.io), e.g. supplier PDFs containing several
+// invoices or invoices split across several files, and converts them to PDF/A with Ghostscript.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"buchhalter/lib/utils"
+)
+
+const QPDF_BINARY = "qpdf"
+
+// qpdf exits with 3 if it succeeded with warnings (e.g. for slightly damaged files)
+const qpdfExitCodeWarnings = 3
+
+// commandRunner runs a command and returns its stdout.
+type commandRunner func(name string, args ...string) ([]byte, error)
+
+type Tool struct {
+	binary string
+	run    commandRunner
+}
+
+func New() *Tool {
+	return &Tool{
+		binary: QPDF_BINARY,
+		run:    runCommand,
+	}
+}
+
+func runCommand(name string, args ...string) ([]byte, error) {
+	// #nosec G204
+	output, err := exec.Command(name, args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return output, fmt.Errorf("%s failed: %s: %w", filepath.Base(name), strings.TrimSpace(string(exitErr.Stderr)), err)
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("the command %s was not found, install it or configure its path: %w", name, err)
+	}
+	return output, err
+}
+
+// qpdf runs qpdf and accepts warnings.
+func (t *Tool) qpdf(args ...string) ([]byte, error) {
+	output, err := t.run(t.binary, args...)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == qpdfExitCodeWarnings {
+		return output, nil
+	}
+	return output, err
+}
+
+// PageRange is a range of pages, starting at 1. To is 0 for ranges until the last page.
+type PageRange struct {
+	From int
+	To   int
+}
+
+func (r PageRange) String() string {
+	if r.To == 0 {
+		return strconv.Itoa(r.From) + "-z"
+	}
+	return strconv.Itoa(r.From) + "-" + strconv.Itoa(r.To)
+}
+
+// ParsePageRanges parses comma separated page ranges, e.g. `1-2,3,4-` (from page 4 until the last page).
+func ParsePageRanges(value string) ([]PageRange, error) {
+	var ranges []PageRange
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		r := PageRange{}
+		var err error
+		r.From, err = strconv.Atoi(strings.TrimSpace(from))
+		if err != nil || r.From < 1 {
+			return nil, fmt.Errorf("invalid page range %s", part)
+		}
+		r.To = r.From
+		if isRange {
+			r.To = 0
+			if strings.TrimSpace(to) != "" {
+				r.To, err = strconv.Atoi(strings.TrimSpace(to))
+				if err != nil || r.To < r.From {
+					return nil, fmt.Errorf("invalid page range %s", part)
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// PageCount returns the number of pages of file.
+func (t *Tool) PageCount(file string) (int, error) {
+	output, err := t.qpdf("--show-npages", file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
+// Split writes the page ranges of file into separate files next to it (`<name>-1.pdf`, `<name>-2.pdf`, ...) and
+// returns their paths.
+func (t *Tool) Split(file string, ranges []PageRange) ([]string, error) {
+	pageCount, err := t.PageCount(file)
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	var parts []string
+	for i, r := range ranges {
+		if r.From > pageCount || r.To > pageCount {
+			return parts, fmt.Errorf("page range %s exceeds the %d pages of %s", r, pageCount, filepath.Base(file))
+		}
+		part := utils.UniqueFileName(fmt.Sprintf("%s-%d.pdf", base, i+1))
+		_, err = t.qpdf("--empty", "--pages", file, r.String(), "--", part)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// BookmarkRanges returns the page ranges of the top-level bookmarks of file, e.g. one bookmark per invoice.
+// Pages before the first bookmark belong to the first range. Without bookmarks, the range covers all pages.
+func (t *Tool) BookmarkRanges(file string) ([]PageRange, error) {
+	output, err := t.qpdf("--json", "--json-key=outlines", file)
+	if err != nil {
+		return nil, err
+	}
+	var document struct {
+		Outlines []struct {
+			Page int `json:"destpageposfrom1"`
+		} `json:"outlines"`
+	}
+	err = json.Unmarshal(output, &document)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bookmarks of %s: %w", filepath.Base(file), err)
+	}
+
+	var starts []int
+	for _, outline := range document.Outlines {
+		if outline.Page > 0 {
+			starts = append(starts, outline.Page)
+		}
+	}
+	sort.Ints(starts)
+	ranges := []PageRange{{From: 1}}
+	for i, start := range starts {
+		if i > 0 && start > ranges[len(ranges)-1].From {
+			ranges[len(ranges)-1].To = start - 1
+			ranges = append(ranges, PageRange{From: start})
+		}
+	}
+	return ranges, nil
+}
+
+// Merge writes the pages of files into output.
+func (t *Tool) Merge(files []string, output string) error {
+	if len(files) == 0 {
+		return errors.New("no files to merge")
+	}
+	args := append([]string{"--empty", "--pages"}, files...)
+	_, err := t.qpdf(append(args, "--", output)...)
+	return err
+}
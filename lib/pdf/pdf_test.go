@@ -0,0 +1,109 @@
+package pdf
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeQpdf answers qpdf commands for a document with 5 pages and records the executed commands.
+type fakeQpdf struct {
+	outlines string
+	commands []string
+}
+
+func (f *fakeQpdf) run(name string, args ...string) ([]byte, error) {
+	f.commands = append(f.commands, name+" "+strings.Join(args, " "))
+	switch args[0] {
+	case "--show-npages":
+		return []byte("5\n"), nil
+	case "--json":
+		return []byte(f.outlines), nil
+	case "--empty":
+		return nil, nil
+	}
+	return nil, errors.New("unexpected command")
+}
+
+func TestParsePageRanges(t *testing.T) {
+	ranges, err := ParsePageRanges("1-2, 3,4-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 3 || ranges[0] != (PageRange{1, 2}) || ranges[1] != (PageRange{3, 3}) || ranges[2] != (PageRange{4, 0}) {
+		t.Errorf("unexpected ranges %v", ranges)
+	}
+	if ranges[2].String() != "4-z" {
+		t.Errorf("expected the qpdf range until the last page, got %s", ranges[2])
+	}
+
+	for _, invalid := range []string{"", "0", "3-2", "a-b", "1,,2"} {
+		if _, err := ParsePageRanges(invalid); err == nil {
+			t.Errorf("expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	f := &fakeQpdf{}
+	tool := &Tool{binary: QPDF_BINARY, run: f.run}
+	file := filepath.Join(t.TempDir(), "invoices.pdf")
+
+	parts, err := tool.Split(file, []PageRange{{1, 2}, {3, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(file)
+	if len(parts) != 2 || parts[0] != filepath.Join(dir, "invoices-1.pdf") || parts[1] != filepath.Join(dir, "invoices-2.pdf") {
+		t.Errorf("unexpected parts %v", parts)
+	}
+	if f.commands[2] != "qpdf --empty --pages "+file+" 3-z -- "+parts[1] {
+		t.Errorf("unexpected command %s", f.commands[2])
+	}
+
+	_, err = tool.Split(file, []PageRange{{4, 6}})
+	if err == nil || !strings.Contains(err.Error(), "exceeds the 5 pages") {
+		t.Errorf("expected an error for pages beyond the document, got %v", err)
+	}
+}
+
+func TestBookmarkRanges(t *testing.T) {
+	f := &fakeQpdf{outlines: `{"version": 2, "outlines": [
+		{"title": "Invoice 2", "destpageposfrom1": 4, "kids": [{"title": "Details", "destpageposfrom1": 5}]},
+		{"title": "Invoice 1", "destpageposfrom1": 2},
+		{"title": "Broken link", "destpageposfrom1": null}
+	]}`}
+	tool := &Tool{binary: QPDF_BINARY, run: f.run}
+
+	ranges, err := tool.BookmarkRanges("invoices.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cover page belongs to the first invoice, nested bookmarks don't start parts
+	if len(ranges) != 2 || ranges[0] != (PageRange{1, 3}) || ranges[1] != (PageRange{4, 0}) {
+		t.Errorf("unexpected ranges %v", ranges)
+	}
+
+	f.outlines = `{"outlines": []}`
+	ranges, err = tool.BookmarkRanges("invoice.pdf")
+	if err != nil || len(ranges) != 1 || ranges[0] != (PageRange{1, 0}) {
+		t.Errorf("expected one range without bookmarks, got %v (%v)", ranges, err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	f := &fakeQpdf{}
+	tool := &Tool{binary: QPDF_BINARY, run: f.run}
+
+	err := tool.Merge([]string{"a.pdf", "b.pdf"}, "merged.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.commands) != 1 || f.commands[0] != "qpdf --empty --pages a.pdf b.pdf -- merged.pdf" {
+		t.Errorf("unexpected commands %v", f.commands)
+	}
+	if err = tool.Merge(nil, "merged.pdf"); err == nil {
+		t.Error("expected an error merging no files")
+	}
+}
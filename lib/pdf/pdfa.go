@@ -0,0 +1,71 @@
+package pdf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const GHOSTSCRIPT_BINARY = "gs"
+
+// PDFA_SUFFIX is appended to the name of PDF/A copies (invoice.pdf becomes invoice.pdfa.pdf)
+const PDFA_SUFFIX = ".pdfa.pdf"
+
+// PdfAConverter converts PDF documents to PDF/A with Ghostscript (https://ghostscript.com).
+type PdfAConverter struct {
+	binary string
+	level  int
+	run    commandRunner
+}
+
+// NewPdfAConverter returns a converter to PDF/A-1, PDF/A-2 or PDF/A-3 (level). binary is the path of the Ghostscript
+// command, GHOSTSCRIPT_BINARY if empty.
+func NewPdfAConverter(binary string, level int) (*PdfAConverter, error) {
+	if binary == "" {
+		binary = GHOSTSCRIPT_BINARY
+	}
+	if level < 1 || level > 3 {
+		return nil, fmt.Errorf("unsupported PDF/A level %d, supported are 1, 2 and 3", level)
+	}
+	return &PdfAConverter{
+		binary: binary,
+		level:  level,
+		run:    runCommand,
+	}, nil
+}
+
+// PdfAFileName returns the name of the PDF/A copy of file.
+func PdfAFileName(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + PDFA_SUFFIX
+}
+
+// IsPdfAFileName returns true for the names of PDF/A copies.
+func IsPdfAFileName(file string) bool {
+	return strings.HasSuffix(strings.ToLower(file), PDFA_SUFFIX)
+}
+
+// Convert writes the PDF/A version of source to dest. source is kept unchanged.
+func (c *PdfAConverter) Convert(source, dest string) error {
+	// Write to a temporary file first, Ghostscript leaves incomplete files behind on errors
+	tmp := dest + ".tmp"
+	_, err := c.run(c.binary,
+		"-dSAFER",
+		"-dBATCH",
+		"-dNOPAUSE",
+		"-dQUIET",
+		fmt.Sprintf("-dPDFA=%d", c.level),
+		// Convert features PDF/A doesn't allow (e.g. transparency in PDF/A-1) instead of failing
+		"-dPDFACompatibilityPolicy=1",
+		"-sColorConversionStrategy=RGB",
+		"-sDEVICE=pdfwrite",
+		"-sOutputFile="+tmp,
+		source,
+	)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("error converting %s to PDF/A: %w", filepath.Base(source), err)
+	}
+
+	return os.Rename(tmp, dest)
+}
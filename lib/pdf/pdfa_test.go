@@ -0,0 +1,65 @@
+package pdf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPdfAFileName(t *testing.T) {
+	if name := PdfAFileName("/documents/invoice.pdf"); name != "/documents/invoice.pdfa.pdf" {
+		t.Errorf("unexpected name %s", name)
+	}
+	if !IsPdfAFileName("invoice.PDFA.pdf") || IsPdfAFileName("invoice.pdf") {
+		t.Error("expected only PDF/A copies to be detected")
+	}
+}
+
+func TestConvertToPdfA(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "invoice.pdf")
+	dest := PdfAFileName(source)
+	var commands []string
+	converter := &PdfAConverter{binary: "/usr/bin/gs", level: 2, run: func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		output := strings.TrimPrefix(args[len(args)-2], "-sOutputFile=")
+		return nil, os.WriteFile(output, []byte("%PDF-1.7"), 0600)
+	}}
+
+	err := converter.Convert(source, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "/usr/bin/gs -dSAFER") || !strings.Contains(commands[0], " -dPDFA=2 ") || !strings.HasSuffix(commands[0], " "+source) {
+		t.Errorf("unexpected commands %v", commands)
+	}
+	if _, err = os.Stat(dest); err != nil {
+		t.Errorf("expected the PDF/A file: %v", err)
+	}
+
+	converter.run = func(name string, args ...string) ([]byte, error) {
+		output := strings.TrimPrefix(args[len(args)-2], "-sOutputFile=")
+		_ = os.WriteFile(output, []byte("%PDF incomplete"), 0600)
+		return nil, errors.New("gs failed")
+	}
+	dest = filepath.Join(dir, "broken.pdfa.pdf")
+	if err = converter.Convert(filepath.Join(dir, "broken.pdf"), dest); err == nil {
+		t.Error("expected an error")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected no incomplete files, got %d files", len(entries))
+	}
+}
+
+func TestNewPdfAConverterValidatesTheLevel(t *testing.T) {
+	if _, err := NewPdfAConverter("", 4); err == nil {
+		t.Error("expected an error for an unsupported level")
+	}
+	converter, err := NewPdfAConverter("", 3)
+	if err != nil || converter.binary != GHOSTSCRIPT_BINARY {
+		t.Errorf("expected the default binary, got %v (%v)", converter, err)
+	}
+}
@@ -0,0 +1,54 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"path/filepath"
+	"strconv"
+)
+
+// PageRenderer renders pages of PDF documents to images with Ghostscript (https://ghostscript.com).
+type PageRenderer struct {
+	binary string
+	run    commandRunner
+}
+
+// NewPageRenderer returns a renderer using the Ghostscript command binary, GHOSTSCRIPT_BINARY if empty.
+func NewPageRenderer(binary string) *PageRenderer {
+	if binary == "" {
+		binary = GHOSTSCRIPT_BINARY
+	}
+	return &PageRenderer{
+		binary: binary,
+		run:    runCommand,
+	}
+}
+
+// Render returns the page (starting at 1) of file as image with a resolution of dpi.
+func (r *PageRenderer) Render(file string, page, dpi int) (image.Image, error) {
+	output, err := r.run(r.binary,
+		"-dSAFER",
+		"-dBATCH",
+		"-dNOPAUSE",
+		"-dQUIET",
+		"-sDEVICE=png16m",
+		"-dTextAlphaBits=4",
+		"-dGraphicsAlphaBits=4",
+		"-r"+strconv.Itoa(dpi),
+		"-dFirstPage="+strconv.Itoa(page),
+		"-dLastPage="+strconv.Itoa(page),
+		"-sOutputFile=-",
+		file,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering page %d of %s: %w", page, filepath.Base(file), err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(output))
+	if err != nil {
+		return nil, fmt.Errorf("error rendering page %d of %s: %w", page, filepath.Base(file), err)
+	}
+	return img, nil
+}
@@ -0,0 +1,37 @@
+package pdf
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRenderPage(t *testing.T) {
+	var commands []string
+	renderer := &PageRenderer{binary: "gs", run: func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		var b bytes.Buffer
+		err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 4, 6)))
+		return b.Bytes(), err
+	}}
+
+	img, err := renderer.Render("/documents/invoice.pdf", 1, 96)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 4 || img.Bounds().Dy() != 6 {
+		t.Errorf("unexpected image size %v", img.Bounds())
+	}
+	if len(commands) != 1 || !strings.Contains(commands[0], " -r96 -dFirstPage=1 -dLastPage=1 -sOutputFile=- /documents/invoice.pdf") {
+		t.Errorf("unexpected commands %v", commands)
+	}
+
+	renderer.run = func(name string, args ...string) ([]byte, error) {
+		return []byte("Error: /undefined in --run--"), nil
+	}
+	if _, err = renderer.Render("/documents/broken.pdf", 1, 96); err == nil {
+		t.Error("expected an error for output that isn't an image")
+	}
+}
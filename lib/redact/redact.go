@@ -0,0 +1,241 @@
+// Package redact keeps credentials, tokens and other secrets out of logs and terminal output.
+//
+// Secrets are registered at runtime (e.g. once credentials are loaded from the vault)
+// and replaced by a mask wherever they show up in log records or rendered text.
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Mask replaces redacted values.
+const Mask = "[REDACTED]"
+
+// minSecretLength avoids masking trivial values (e.g. empty or single character passwords) all over the output.
+const minSecretLength = 4
+
+// sensitiveKeys are log attribute keys (or their words, see IsSensitiveKey) which values are always redacted.
+var sensitiveKeys = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"totp",
+	"authorization",
+	"cookie",
+	"api_key",
+	"apikey",
+}
+
+var (
+	mutex   sync.RWMutex
+	secrets = make(map[string]bool)
+)
+
+// AddSecrets registers values which must never appear in logs or terminal output.
+func AddSecrets(values ...string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, value := range values {
+		if len(value) >= minSecretLength {
+			secrets[value] = true
+		}
+	}
+}
+
+// String replaces all registered secrets in s with Mask.
+func String(s string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	return s
+}
+
+// IsSensitiveKey returns true if key (e.g. a log attribute or HTTP header name) usually holds a secret.
+// Keys are compared by their words, so "X-Api-Key", "accessToken" and "cookies" are sensitive, but "author" isn't.
+func IsSensitiveKey(key string) bool {
+	segments := keySegments(key)
+	for _, sensitiveKey := range sensitiveKeys {
+		if containsSegments(segments, keySegments(sensitiveKey)) {
+			return true
+		}
+	}
+	return false
+}
+
+// keySegments splits key into its lower case words, e.g. "X-Api-Key" and "apiKey" into "api" and "key".
+func keySegments(key string) []string {
+	var segments []string
+	var segment strings.Builder
+	flush := func() {
+		if segment.Len() > 0 {
+			segments = append(segments, segment.String())
+			segment.Reset()
+		}
+	}
+	var previous rune
+	for _, r := range key {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && (unicode.IsLower(previous) || unicode.IsDigit(previous)):
+			flush()
+			segment.WriteRune(unicode.ToLower(r))
+		default:
+			segment.WriteRune(unicode.ToLower(r))
+		}
+		previous = r
+	}
+	flush()
+	return segments
+}
+
+// containsSegments returns true if words contains the consecutive words of sensitiveKey, the last one in plural as well.
+func containsSegments(words, sensitiveKey []string) bool {
+	for start := 0; start+len(sensitiveKey) <= len(words); start++ {
+		matches := true
+		for i, word := range sensitiveKey {
+			w := words[start+i]
+			if w != word && (i < len(sensitiveKey)-1 || w != word+"s") {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler is a slog.Handler redacting secrets from all records before passing them to the wrapped handler.
+type Handler struct {
+	handler slog.Handler
+}
+
+func NewHandler(handler slog.Handler) *Handler {
+	return &Handler{
+		handler: handler,
+	}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	redactedRecord := slog.NewRecord(record.Time, record.Level, String(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redactedRecord.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.handler.Handle(ctx, redactedRecord)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		redactedAttrs = append(redactedAttrs, redactAttr(attr))
+	}
+	return NewHandler(h.handler.WithAttrs(redactedAttrs))
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return NewHandler(h.handler.WithGroup(name))
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	if IsSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, Mask)
+	}
+
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindGroup:
+		groupAttrs := value.Group()
+		redactedAttrs := make([]any, 0, len(groupAttrs))
+		for _, groupAttr := range groupAttrs {
+			redactedAttrs = append(redactedAttrs, redactAttr(groupAttr))
+		}
+		return slog.Group(attr.Key, redactedAttrs...)
+	case slog.KindString:
+		return slog.String(attr.Key, String(value.String()))
+	case slog.KindAny:
+		// Errors and other values are rendered as text to catch secrets in their messages
+		if err, ok := value.Any().(error); ok {
+			return slog.String(attr.Key, String(err.Error()))
+		}
+	}
+
+	return attr
+}
+
+// urlTokenKeys are query parameters which hold short lived secrets in URLs (e.g. OAuth2 codes or signed links).
+var urlTokenKeys = []string{"code", "state", "sid", "session", "sig", "signature", "key", "auth", "access", "nonce"}
+
+// minTokenLength is the length from which query values are treated as tokens, whatever their name is.
+const minTokenLength = 24
+
+// URL masks the values of token-like query parameters and the fragment of rawURL.
+// Invalid URLs are returned with all registered secrets redacted.
+func URL(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return String(rawURL)
+	}
+
+	query := parsedURL.Query()
+	for key, values := range query {
+		for i, value := range values {
+			if IsSensitiveKey(key) || isURLTokenKey(key) || len(value) >= minTokenLength {
+				values[i] = Mask
+			}
+		}
+		query[key] = values
+	}
+	parsedURL.RawQuery = query.Encode()
+
+	// Implicit OAuth2 flows return tokens in the fragment
+	if strings.Contains(parsedURL.Fragment, "=") {
+		parsedURL.Fragment = Mask
+	}
+
+	return String(parsedURL.String())
+}
+
+func isURLTokenKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, tokenKey := range urlTokenKeys {
+		if key == tokenKey || strings.HasSuffix(key, "_"+tokenKey) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	textURLPattern      = regexp.MustCompile(`https?://[^\s"'<>]+`)
+	textKeyValuePattern = regexp.MustCompile(`([\w-]+)=("(?:[^"\\]|\\.)*"|\S+)`)
+)
+
+// Text redacts free text like log files or DOM dumps.
+// Besides registered secrets, token-like URL parameters and values of sensitive `key=value` pairs are masked.
+func Text(s string) string {
+	s = textURLPattern.ReplaceAllStringFunc(s, URL)
+	s = textKeyValuePattern.ReplaceAllStringFunc(s, func(pair string) string {
+		key := pair[:strings.Index(pair, "=")]
+		if IsSensitiveKey(key) {
+			return key + "=" + Mask
+		}
+		return pair
+	})
+	return String(s)
+}
@@ -0,0 +1,78 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandlerRedactsSecrets(t *testing.T) {
+	AddSecrets("s3cr3t-password", "x")
+
+	var output bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&output, nil)))
+	logger.Info("Typing s3cr3t-password",
+		"value", "prefix s3cr3t-password suffix",
+		"Authorization", "Bearer abc",
+		"error", errors.New("login failed for s3cr3t-password"),
+		"selector", "#x",
+	)
+
+	logLine := output.String()
+	if strings.Contains(logLine, "s3cr3t-password") || strings.Contains(logLine, "Bearer abc") {
+		t.Errorf("log line contains secrets: %s", logLine)
+	}
+	if !strings.Contains(logLine, "selector=#x") {
+		t.Errorf("log line misses non-sensitive attribute: %s", logLine)
+	}
+}
+
+func TestTextMasksSensitivePairs(t *testing.T) {
+	maskedText := Text(`level=INFO msg="Request" password="a b" url=https://example.com/?token=abc`)
+
+	expectedText := `level=INFO msg="Request" password=[REDACTED] url=https://example.com/?token=%5BREDACTED%5D`
+	if maskedText != expectedText {
+		t.Errorf("expected %s, got %s", expectedText, maskedText)
+	}
+}
+
+func TestURLMasksTokens(t *testing.T) {
+	maskedURL := URL("https://example.com/callback?code=abc&page=2&download=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaa#access_token=xyz")
+
+	expectedURL := "https://example.com/callback?code=%5BREDACTED%5D&download=%5BREDACTED%5D&page=2#%5BREDACTED%5D"
+	if maskedURL != expectedURL {
+		t.Errorf("expected %s, got %s", expectedURL, maskedURL)
+	}
+}
+
+func TestIsSensitiveKey(t *testing.T) {
+	tests := []struct {
+		key       string
+		sensitive bool
+	}{
+		{"password", true},
+		{"Authorization", true},
+		{"X-Api-Key", true},
+		{"apiKey", true},
+		{"api_key", true},
+		{"accessToken", true},
+		{"refresh_token", true},
+		{"Set-Cookie", true},
+		{"cookies", true},
+		{"client_secret", true},
+		{"author", false},
+		{"authority", false},
+		{"tokenizer", false},
+		{"secretary", false},
+		{"passwordless", false},
+		{"key", false},
+		{"selector", false},
+	}
+	for _, test := range tests {
+		if sensitive := IsSensitiveKey(test.key); sensitive != test.sensitive {
+			t.Errorf("expected IsSensitiveKey(%q) to be %t, got %t", test.key, test.sensitive, sensitive)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package repository
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type BuchhalterConfig struct {
@@ -19,7 +22,10 @@ type APIConfig struct {
 	TeamSlug string `json:"team_slug"`
 }
 
-const apiTokenFileName = ".buchhalter-api-token"
+const (
+	apiTokenFileName  = ".buchhalter-api-token"
+	uploadKeyFileName = ".buchhalter-upload-key"
+)
 
 func NewBuchhalterConfig(logger *slog.Logger, configDirectory string) *BuchhalterConfig {
 	return &BuchhalterConfig{
@@ -40,7 +46,7 @@ func (b *BuchhalterConfig) WriteLocalAPIConfig(apiToken, teamSlug string) error
 
 	apiTokenFile := filepath.Join(b.configDirectory, apiTokenFileName)
 	b.logger.Info("Writing API token to file", "file", apiTokenFile)
-	err = os.WriteFile(apiTokenFile, fileContent, 0644)
+	err = os.WriteFile(apiTokenFile, fileContent, 0600)
 	return err
 }
 
@@ -74,3 +80,26 @@ func (b *BuchhalterConfig) GetLocalAPIConfig() (*APIConfig, error) {
 
 	return c, nil
 }
+
+// GetOrCreateUploadKey returns the key to encrypt documents before uploading them to the Buchhalter Platform.
+// The key is created on first use and never sent to the platform, which only stores the encrypted chunks. Other
+// machines or team members need a copy of the key file (e.g. via `backup create --include-tokens`) to decrypt the
+// uploaded documents, they can't be recovered without it.
+func (b *BuchhalterConfig) GetOrCreateUploadKey() ([]byte, error) {
+	uploadKeyFile := filepath.Join(b.configDirectory, uploadKeyFileName)
+	fileContent, err := os.ReadFile(uploadKeyFile)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(fileContent)))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	b.logger.Info("Writing upload encryption key to file", "file", uploadKeyFile)
+	err = os.WriteFile(uploadKeyFile, []byte(hex.EncodeToString(key)), 0600)
+	return key, err
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"buchhalter/lib/httpclient"
+)
+
+const advisoriesAPIEndpoint = "/api/cli/advisories"
+
+// Error categories of failed recipes reported to the supplier health feed.
+// They group failures without sending supplier specific error messages.
+const (
+	ERROR_CATEGORY_TIMEOUT        = "timeout"
+	ERROR_CATEGORY_AUTHENTICATION = "authentication"
+	ERROR_CATEGORY_NAVIGATION     = "navigation"
+	ERROR_CATEGORY_DOWNLOAD       = "download"
+	ERROR_CATEGORY_SCRIPT         = "script"
+	ERROR_CATEGORY_UNKNOWN        = "unknown"
+)
+
+// SupplierAdvisory is a known issue of a supplier recipe announced by the Buchhalter Platform,
+// e.g. "this supplier's recipe is known-broken since 2024-06-01, fix pending".
+type SupplierAdvisory struct {
+	Supplier string `json:"supplier"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Since    string `json:"since,omitempty"`
+	// Versions are the affected recipe versions, all versions are affected if empty
+	Versions []string `json:"versions,omitempty"`
+}
+
+type advisoriesResponse struct {
+	Status     string             `json:"status"`
+	Advisories []SupplierAdvisory `json:"advisories"`
+}
+
+// Affects returns true if the advisory applies to the given recipe version.
+func (a SupplierAdvisory) Affects(recipeVersion string) bool {
+	if len(a.Versions) == 0 {
+		return true
+	}
+	for _, version := range a.Versions {
+		if version == recipeVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifyRecipeError returns the error category (see ERROR_CATEGORY_* constants) of a failed recipe
+// by the action and status of the failed step and its error message.
+func ClassifyRecipeError(action, stepStatus, errorMessage string) string {
+	message := strings.ToLower(errorMessage)
+	switch {
+	case stepStatus == "timeout" || strings.Contains(message, "timeout") || strings.Contains(message, "deadline exceeded"):
+		return ERROR_CATEGORY_TIMEOUT
+	case strings.HasPrefix(action, "oauth2-") || action == "type" ||
+		strings.Contains(message, "unauthorized") || strings.Contains(message, "invalid_grant") || strings.Contains(message, "login"):
+		return ERROR_CATEGORY_AUTHENTICATION
+	case action == "open" || action == "click" || action == "waitFor" || action == "removeElement":
+		return ERROR_CATEGORY_NAVIGATION
+	case strings.HasPrefix(action, "download") || action == "printToPdf" || action == "move" || action == "transform":
+		return ERROR_CATEGORY_DOWNLOAD
+	case strings.HasPrefix(action, "runScript") || action == "extract" || action == "captureResponse":
+		return ERROR_CATEGORY_SCRIPT
+	}
+	return ERROR_CATEGORY_UNKNOWN
+}
+
+// GetSupplierAdvisories returns the advisories of the given suppliers grouped by supplier.
+// Advisories are public, no API token is needed.
+func (c *BuchhalterAPIClient) GetSupplierAdvisories(ctx context.Context, suppliers []string) (map[string][]SupplierAdvisory, error) {
+	advisories := map[string][]SupplierAdvisory{}
+	if len(suppliers) == 0 {
+		return advisories, nil
+	}
+
+	apiUrl, err := url.JoinPath(c.apiHost.String(), advisoriesAPIEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl+"?"+url.Values{"suppliers": {strings.Join(suppliers, ",")}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logger.Error("Error sending request", "url", apiUrl, "error", err)
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Older platform versions don't provide advisories yet
+	if resp.StatusCode == http.StatusNotFound {
+		return advisories, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.StatusError(resp, "")
+	}
+
+	var response advisoriesResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	for _, advisory := range response.Advisories {
+		advisories[advisory.Supplier] = append(advisories[advisory.Supplier], advisory)
+	}
+
+	return advisories, nil
+}
@@ -0,0 +1,32 @@
+package repository
+
+import "testing"
+
+func TestClassifyRecipeError(t *testing.T) {
+	tests := []struct {
+		action, stepStatus, errorMessage, expected string
+	}{
+		{"waitFor", "timeout", "", ERROR_CATEGORY_TIMEOUT},
+		{"oauth2-authenticate", "error", "invalid credentials", ERROR_CATEGORY_AUTHENTICATION},
+		{"click", "error", "node not found", ERROR_CATEGORY_NAVIGATION},
+		{"downloadAll", "error", "no files downloaded", ERROR_CATEGORY_DOWNLOAD},
+		{"runScript", "error", "TypeError: x is undefined", ERROR_CATEGORY_SCRIPT},
+		{"sleep", "error", "", ERROR_CATEGORY_UNKNOWN},
+	}
+	for _, test := range tests {
+		category := ClassifyRecipeError(test.action, test.stepStatus, test.errorMessage)
+		if category != test.expected {
+			t.Errorf("expected %s for %s (%s), got %s", test.expected, test.action, test.errorMessage, category)
+		}
+	}
+}
+
+func TestSupplierAdvisoryAffects(t *testing.T) {
+	if !(SupplierAdvisory{}).Affects("1.0.0") {
+		t.Error("expected advisory without versions to affect all versions")
+	}
+	advisory := SupplierAdvisory{Versions: []string{"1.0.0", "1.0.1"}}
+	if !advisory.Affects("1.0.1") || advisory.Affects("1.1.0") {
+		t.Errorf("unexpected affected versions of %+v", advisory)
+	}
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LAST_RUN_METRICS_FILE_NAME is the file in the buchhalter directory keeping the usage metrics of the last run
+const LAST_RUN_METRICS_FILE_NAME = "_last_run_metrics.json"
+
+// RedactableMetricFields are the fields of the usage metrics that can be left out (see RunMetrics.Payload).
+var RedactableMetricFields = []string{"version", "lastErrorMessage", "errorCategory", "failedStepAction", "duration", "newFilesCount", "chromeVersion", "vaultVersion", "os"}
+
+// RunMetrics are the usage metrics of a run before they are turned into the payload sent to the Buchhalter API.
+type RunMetrics struct {
+	RunData       RunData `json:"runData"`
+	CliVersion    string  `json:"cliVersion"`
+	ChromeVersion string  `json:"chromeVersion"`
+	VaultVersion  string  `json:"vaultVersion"`
+	OicdbVersion  string  `json:"oicdbVersion"`
+}
+
+// metricRecord is a line of a local metrics file.
+type metricRecord struct {
+	RecordedAt time.Time `json:"recordedAt"`
+	Metric
+}
+
+// Payload returns the metric sent to the Buchhalter API with the fields in redact (see RedactableMetricFields) left out.
+func (m RunMetrics) Payload(redact []string) (Metric, error) {
+	for _, field := range redact {
+		if !slices.Contains(RedactableMetricFields, field) {
+			return Metric{}, fmt.Errorf("metric field %s can't be redacted (supported: %s)", field, strings.Join(RedactableMetricFields, ", "))
+		}
+	}
+	redacted := func(field string) bool {
+		return slices.Contains(redact, field)
+	}
+
+	runData := make(RunData, len(m.RunData))
+	for i, rdx := range m.RunData {
+		// Artifacts (e.g. file names and extracted variables) are never sent as usage metrics
+		rdx.Steps = nil
+		if redacted("version") {
+			rdx.Version = ""
+		}
+		if redacted("lastErrorMessage") {
+			rdx.LastErrorMessage = ""
+		}
+		if redacted("errorCategory") {
+			rdx.ErrorCategory = ""
+		}
+		if redacted("failedStepAction") {
+			rdx.FailedStepAction = ""
+		}
+		if redacted("duration") {
+			rdx.Duration = 0
+		}
+		if redacted("newFilesCount") {
+			rdx.NewFilesCount = 0
+		}
+		runData[i] = rdx
+	}
+	rdx, err := json.Marshal(runData)
+	if err != nil {
+		return Metric{}, fmt.Errorf("error marshalling run data: %w", err)
+	}
+
+	md := Metric{
+		MetricType:    "runMetrics",
+		Data:          string(rdx),
+		CliVersion:    m.CliVersion,
+		OicdbVersion:  m.OicdbVersion,
+		VaultVersion:  m.VaultVersion,
+		ChromeVersion: m.ChromeVersion,
+		OS:            runtime.GOOS,
+	}
+	if redacted("chromeVersion") {
+		md.ChromeVersion = ""
+	}
+	if redacted("vaultVersion") {
+		md.VaultVersion = ""
+	}
+	if redacted("os") {
+		md.OS = ""
+	}
+	return md, nil
+}
+
+// MetricsURL returns the URL of the Buchhalter API usage metrics are sent to.
+func MetricsURL(apiHost string) (string, error) {
+	return url.JoinPath(apiHost, metricsAPIEndpoint)
+}
+
+// SaveRunMetrics stores the usage metrics of the last run, so they can be previewed after the run.
+func SaveRunMetrics(filePath string, m RunMetrics) error {
+	fileContent, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filePath, fileContent, 0600)
+}
+
+// LoadRunMetrics reads the usage metrics of the last run, false if no run stored its metrics yet.
+func LoadRunMetrics(filePath string) (RunMetrics, bool, error) {
+	var m RunMetrics
+	fileContent, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, false, nil
+	}
+	if err != nil {
+		return m, false, err
+	}
+
+	err = json.Unmarshal(fileContent, &m)
+	return m, err == nil, err
+}
+
+// AppendMetric writes metric as line of the JSONL file filePath instead of sending it to the Buchhalter API.
+func AppendMetric(filePath string, metric Metric) error {
+	line, err := json.Marshal(metricRecord{RecordedAt: time.Now(), Metric: metric})
+	if err != nil {
+		return fmt.Errorf("error marshalling metric: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(filePath), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"buchhalter/lib/utils"
+)
+
+func TestRunMetricsPayload(t *testing.T) {
+	m := RunMetrics{
+		RunData: RunData{
+			{Supplier: "acme", Version: "1.0.0", Status: "error", LastErrorMessage: "login failed for jane@example.com", Duration: 12.5, ErrorCategory: ERROR_CATEGORY_AUTHENTICATION, FailedStepAction: "type", Steps: []RunDataStep{
+				{Number: 1, Action: "extract", Status: "success", StepArtifacts: utils.StepArtifacts{Variables: map[string]string{"customerNumber": "4711"}}},
+			}},
+		},
+		CliVersion:    "1.2.3",
+		ChromeVersion: "128",
+		VaultVersion:  "2.30.0",
+		OicdbVersion:  "abc",
+	}
+
+	metric, err := m.Payload(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metric.MetricType != "runMetrics" || metric.ChromeVersion != "128" || metric.OS != runtime.GOOS {
+		t.Errorf("unexpected metric %+v", metric)
+	}
+	var runData RunData
+	if err := json.Unmarshal([]byte(metric.Data), &runData); err != nil {
+		t.Fatal(err)
+	}
+	expected := m.RunData[0]
+	expected.Steps = nil
+	if !reflect.DeepEqual(runData, RunData{expected}) {
+		t.Errorf("unexpected run data %+v", runData)
+	}
+
+	metric, err = m.Payload([]string{"lastErrorMessage", "chromeVersion", "os"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runData = nil
+	if err := json.Unmarshal([]byte(metric.Data), &runData); err != nil {
+		t.Fatal(err)
+	}
+	if runData[0].LastErrorMessage != "" || runData[0].ErrorCategory != ERROR_CATEGORY_AUTHENTICATION || metric.ChromeVersion != "" || metric.OS != "" || metric.VaultVersion != "2.30.0" {
+		t.Errorf("unexpected redacted metric %+v with run data %+v", metric, runData)
+	}
+	if m.RunData[0].LastErrorMessage == "" {
+		t.Error("expected the run metrics to be unchanged")
+	}
+
+	if _, err := m.Payload([]string{"supplier"}); err == nil {
+		t.Error("expected an error for a field that can't be redacted")
+	}
+}
+
+func TestSaveAndLoadRunMetrics(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), LAST_RUN_METRICS_FILE_NAME)
+	if _, ok, err := LoadRunMetrics(filePath); ok || err != nil {
+		t.Fatalf("expected no run metrics, got %v, %v", ok, err)
+	}
+
+	m := RunMetrics{RunData: RunData{{Supplier: "acme", Status: "success", NewFilesCount: 2}}, CliVersion: "1.2.3"}
+	if err := SaveRunMetrics(filePath, m); err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok, err := LoadRunMetrics(filePath)
+	if !ok || err != nil {
+		t.Fatalf("expected run metrics, got %v, %v", ok, err)
+	}
+	if !reflect.DeepEqual(loaded, m) {
+		t.Errorf("unexpected run metrics %+v", loaded)
+	}
+}
+
+func TestAppendMetric(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics", "runs.jsonl")
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if err := AppendMetric(filePath, Metric{MetricType: "runMetrics", CliVersion: version}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var versions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record metricRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.RecordedAt.IsZero() {
+			t.Errorf("expected the time of the record in %s", scanner.Text())
+		}
+		versions = append(versions, record.CliVersion)
+	}
+	if !reflect.DeepEqual(versions, []string{"1.0.0", "1.1.0"}) {
+		t.Errorf("unexpected records %v", versions)
+	}
+}
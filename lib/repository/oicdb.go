@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidSignature = errors.New("the signature of the Open Invoice Collector Database is invalid")
+
+// ImportOpenInvoiceCollectorDB replaces the local Open Invoice Collector Database with database, e.g. a file copied into an
+// air-gapped environment. The database has to be signed (Ed25519, base64 encoded signature) with one of OfficialPublicKeys or publicKeys.
+func ImportOpenInvoiceCollectorDB(configDirectory string, database, signature []byte, publicKeys []string) error {
+	err := VerifySignature(database, signature, withOfficialPublicKeys(publicKeys))
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFileAtomically(filepath.Join(configDirectory, "oicdb.json"), bytes.NewReader(database))
+	return err
+}
+
+// OfficialPublicKeys are the base64 encoded Ed25519 keys the Buchhalter Platform signs the Open Invoice Collector
+// Database with. They are always accepted, buchhalter_oicdb_public_keys adds keys (e.g. of an in-house mirror).
+// TODO Add the signing key of the Buchhalter Platform once it publishes signatures
+var OfficialPublicKeys = []string{}
+
+// withOfficialPublicKeys returns OfficialPublicKeys and publicKeys.
+func withOfficialPublicKeys(publicKeys []string) []string {
+	return append(append([]string{}, OfficialPublicKeys...), publicKeys...)
+}
+
+// VerifySignature verifies the base64 encoded Ed25519 signature of data against the base64 encoded public keys.
+func VerifySignature(data, signature []byte, publicKeys []string) error {
+	if len(publicKeys) == 0 {
+		return fmt.Errorf("no public key configured to verify the signature")
+	}
+	decodedSignature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	for _, publicKey := range publicKeys {
+		decodedKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+		if err != nil || len(decodedKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid public key %s", publicKey)
+		}
+		if ed25519.Verify(decodedKey, data, decodedSignature) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// writeFileAtomically replaces filePath with the content of r, an interrupted download never leaves a truncated file behind.
+func writeFileAtomically(filePath string, r io.Reader) (int64, error) {
+	temporaryFile, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	bytesCopied, err := io.Copy(temporaryFile, r)
+	if closeErr := temporaryFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temporaryFile.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temporaryFile.Name(), filePath)
+	}
+	return bytesCopied, err
+}
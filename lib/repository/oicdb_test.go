@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+func TestImportOpenInvoiceCollectorDB(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeys := []string{base64.StdEncoding.EncodeToString(publicKey)}
+	database := []byte(`{"version":"2024.03.01","recipes":[]}`)
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, database)) + "\n")
+
+	configDirectory := t.TempDir()
+	oicdbFile := filepath.Join(configDirectory, "oicdb.json")
+	if err := os.WriteFile(oicdbFile, []byte(`{"version":"old"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, database...)
+	tampered[12] = '5'
+	if err := ImportOpenInvoiceCollectorDB(configDirectory, tampered, signature, publicKeys); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected an invalid signature, got %v", err)
+	}
+	if err := ImportOpenInvoiceCollectorDB(configDirectory, database, signature, nil); err == nil {
+		t.Error("expected an error without public keys")
+	}
+	if content, _ := os.ReadFile(oicdbFile); string(content) != `{"version":"old"}` {
+		t.Errorf("database replaced despite invalid signature: %s", content)
+	}
+
+	if err := ImportOpenInvoiceCollectorDB(configDirectory, database, signature, publicKeys); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(oicdbFile); string(content) != string(database) {
+		t.Errorf("unexpected database %s", content)
+	}
+}
+
+func TestUpdateFailsOverToMirror(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer unavailable.Close()
+	newMirror := func(database []byte, signature string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case repositoryAPIEndpoint:
+				w.Header().Set("x-checksum", "new")
+				if r.Method == http.MethodGet {
+					w.Write(database)
+				}
+			case repositorySignatureAPIEndpoint:
+				w.Write([]byte(signature))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	}
+	database := []byte(`{"version":"mirrored"}`)
+	mirror := newMirror(database, base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, database)))
+	defer mirror.Close()
+	tampered := newMirror([]byte(`{"version":"tampered"}`), base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, database)))
+	defer tampered.Close()
+
+	configDirectory := t.TempDir()
+	c, err := NewBuchhalterAPIClient(logger, httpclient.New(logger, 5*time.Second, 0), unavailable.URL, configDirectory, "", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateOpenInvoiceCollectorDBIfAvailable(context.Background(), "old"); err == nil {
+		t.Error("expected an error without mirrors")
+	}
+
+	if err := c.SetRepositoryMirrors([]string{unavailable.URL, tampered.URL, mirror.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateOpenInvoiceCollectorDBIfAvailable(context.Background(), "old"); err == nil {
+		t.Error("expected an error without public keys")
+	}
+	if _, err := os.Stat(filepath.Join(configDirectory, "oicdb.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no database without public keys, got %v", err)
+	}
+
+	c.SetRepositoryPublicKeys([]string{base64.StdEncoding.EncodeToString(publicKey)})
+	if err := c.UpdateOpenInvoiceCollectorDBIfAvailable(context.Background(), "old"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(configDirectory, "oicdb.json")); string(content) != `{"version":"mirrored"}` {
+		t.Errorf("unexpected database %s", content)
+	}
+}
+
+func TestUpdateFromAPIHostWithoutSignature(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	apiHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != repositoryAPIEndpoint {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("x-checksum", "new")
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"version":"official"}`))
+		}
+	}))
+	defer apiHost.Close()
+
+	configDirectory := t.TempDir()
+	c, err := NewBuchhalterAPIClient(logger, httpclient.New(logger, 5*time.Second, 0), apiHost.URL, configDirectory, "", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetRepositoryPublicKeys(nil)
+	if err := c.UpdateOpenInvoiceCollectorDBIfAvailable(context.Background(), "old"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(configDirectory, "oicdb.json")); string(content) != `{"version":"official"}` {
+		t.Errorf("unexpected database %s", content)
+	}
+}
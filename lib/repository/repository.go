@@ -4,32 +4,44 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
-	"runtime"
-	"time"
+
+	"buchhalter/lib/crash"
+	"buchhalter/lib/httpclient"
+	"buchhalter/lib/utils"
 )
 
 const (
 	schemaAPIEndpoint     = "/api/cli/schema"
 	repositoryAPIEndpoint = "/api/cli/repository"
-	metricsAPIEndpoint    = "/api/cli/metrics"
-	userAuthAPIEndpoint   = "/api/cli/sync"
+	// repositorySignatureAPIEndpoint serves the base64 encoded Ed25519 signature of the repository
+	repositorySignatureAPIEndpoint = "/api/cli/repository/signature"
+	metricsAPIEndpoint             = "/api/cli/metrics"
+	crashReportAPIEndpoint         = "/api/cli/crash-reports"
+	userAuthAPIEndpoint            = "/api/cli/sync"
 )
 
 type BuchhalterAPIClient struct {
 	logger            *slog.Logger
+	httpClient        *httpclient.Client
 	apiHost           *url.URL
 	apiToken          string
 	authenticatedUser AuthenticatedUser
 	configDirectory   string
 	userAgent         string
+
+	// mirrors are tried in order if the repository can't be updated from apiHost
+	mirrors []*url.URL
+	// publicKeys verify the signature of downloaded repositories, see SetRepositoryPublicKeys
+	publicKeys []string
+	// teamSlug selects the team of the authenticated user, see SetTeamSlug
+	teamSlug string
 }
 
 type Metric struct {
@@ -50,6 +62,20 @@ type RunDataSupplier struct {
 	LastErrorMessage string  `json:"lastErrorMessage,omitempty"`
 	Duration         float64 `json:"duration,omitempty"`
 	NewFilesCount    int     `json:"newFilesCount,omitempty"`
+	// ErrorCategory groups failed recipes for the supplier health feed (see ERROR_CATEGORY_* constants)
+	ErrorCategory    string `json:"errorCategory,omitempty"`
+	FailedStepAction string `json:"failedStepAction,omitempty"`
+	// Steps are the executed steps of the recipe with their artifacts, they are not part of the usage metrics
+	Steps []RunDataStep `json:"steps,omitempty"`
+}
+
+// RunDataStep is an executed step of a recipe with its artifacts (downloaded files, extracted variables, ...).
+type RunDataStep struct {
+	Number   int     `json:"number"`
+	Action   string  `json:"action"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration"`
+	utils.StepArtifacts
 }
 
 type CliSyncResponse struct {
@@ -71,6 +97,9 @@ type Team struct {
 	Subscription string `json:"subscription"`
 	CreatedAt    string `json:"created_at"`
 	UpdatedAt    string `json:"updated_at"`
+	// Role of the authenticated user in the team and its restrictions
+	Role         string           `json:"role"`
+	Restrictions RoleRestrictions `json:"restrictions"`
 }
 
 type DocumentCheckResponse struct {
@@ -78,18 +107,13 @@ type DocumentCheckResponse struct {
 	File   string `json:"file"`
 }
 
-type DocumentUploadResponse struct {
-	Status     string `json:"status"`
-	DocumentID string `json:"document_id"`
-}
-
 type ErrorAPIResponse struct {
 	Status       string `json:"status"`
 	ErrorCode    string `json:"error_code"`
 	ErrorMessage string `json:"error_message"`
 }
 
-func NewBuchhalterAPIClient(logger *slog.Logger, apiHost, configDirectory, apiToken, cliVersion string) (*BuchhalterAPIClient, error) {
+func NewBuchhalterAPIClient(logger *slog.Logger, httpClient *httpclient.Client, apiHost, configDirectory, apiToken, cliVersion string) (*BuchhalterAPIClient, error) {
 	u, err := url.Parse(apiHost)
 	if err != nil {
 		return nil, err
@@ -97,6 +121,7 @@ func NewBuchhalterAPIClient(logger *slog.Logger, apiHost, configDirectory, apiTo
 
 	c := &BuchhalterAPIClient{
 		logger:          logger,
+		httpClient:      httpClient,
 		configDirectory: configDirectory,
 		apiHost:         u,
 		userAgent:       fmt.Sprintf("buchhalter-cli/v%s", cliVersion),
@@ -106,74 +131,123 @@ func NewBuchhalterAPIClient(logger *slog.Logger, apiHost, configDirectory, apiTo
 	return c, nil
 }
 
-func (c *BuchhalterAPIClient) UpdateOpenInvoiceCollectorDBIfAvailable(currentChecksum string) error {
-	err := c.downloadFileFromAPIEndpoint(currentChecksum, repositoryAPIEndpoint, "oicdb.json")
+// SetRepositoryMirrors sets the mirrors of the Open Invoice Collector Database. Mirrors provide the repository and schema
+// endpoints of the Buchhalter API and are tried in order if the API host isn't reachable.
+func (c *BuchhalterAPIClient) SetRepositoryMirrors(mirrors []string) error {
+	c.mirrors = nil
+	for _, mirror := range mirrors {
+		u, err := url.Parse(mirror)
+		if err != nil {
+			return fmt.Errorf("invalid repository mirror %s: %w", mirror, err)
+		}
+		c.mirrors = append(c.mirrors, u)
+	}
+	return nil
+}
+
+// SetRepositoryPublicKeys sets the base64 encoded Ed25519 public keys accepted for the signature of the Open Invoice
+// Collector Database in addition to OfficialPublicKeys. Repositories downloaded from mirrors without a valid signature
+// are rejected.
+func (c *BuchhalterAPIClient) SetRepositoryPublicKeys(publicKeys []string) {
+	c.publicKeys = withOfficialPublicKeys(publicKeys)
+}
+
+func (c *BuchhalterAPIClient) UpdateOpenInvoiceCollectorDBIfAvailable(ctx context.Context, currentChecksum string) error {
+	err := c.downloadFileFromAPIEndpoint(ctx, currentChecksum, repositoryAPIEndpoint, repositorySignatureAPIEndpoint, "oicdb.json")
 	return err
 }
 
-func (c *BuchhalterAPIClient) UpdateOpenInvoiceCollectorDBSchemaIfAvailable(currentChecksum string) error {
-	err := c.downloadFileFromAPIEndpoint(currentChecksum, schemaAPIEndpoint, "oicdb.schema.json")
+func (c *BuchhalterAPIClient) UpdateOpenInvoiceCollectorDBSchemaIfAvailable(ctx context.Context, currentChecksum string) error {
+	err := c.downloadFileFromAPIEndpoint(ctx, currentChecksum, schemaAPIEndpoint, "", "oicdb.schema.json")
 	return err
 }
 
-func (c *BuchhalterAPIClient) downloadFileFromAPIEndpoint(currentChecksum, apiEndpoint, localFileName string) error {
-	updateExists, err := c.updateExists(currentChecksum, apiEndpoint)
+// downloadFileFromAPIEndpoint updates localFileName from the API host or, if that fails, from the first mirror that works.
+// With a signatureEndpoint, files of mirrors are only updated if their signature of the same host is valid. The API host
+// is trusted via TLS until it provides signatures.
+func (c *BuchhalterAPIClient) downloadFileFromAPIEndpoint(ctx context.Context, currentChecksum, apiEndpoint, signatureEndpoint, localFileName string) error {
+	var errs []error
+	for _, host := range append([]*url.URL{c.apiHost}, c.mirrors...) {
+		hostSignatureEndpoint := signatureEndpoint
+		if host == c.apiHost {
+			hostSignatureEndpoint = ""
+		}
+		err := c.downloadFileFromHost(ctx, host, currentChecksum, apiEndpoint, hostSignatureEndpoint, localFileName)
+		if err == nil {
+			return nil
+		}
+		c.logger.Error("Error updating the local file", "file", localFileName, "host", host.String(), "error", err)
+		errs = append(errs, err)
+	}
+	if len(errs) > 1 {
+		return fmt.Errorf("no repository mirror is reachable: %w", errors.Join(errs...))
+	}
+	return errs[0]
+}
+
+func (c *BuchhalterAPIClient) downloadFileFromHost(ctx context.Context, host *url.URL, currentChecksum, apiEndpoint, signatureEndpoint, localFileName string) error {
+	updateExists, err := c.updateExists(ctx, host, currentChecksum, apiEndpoint)
 	if err != nil {
 		return fmt.Errorf("you're offline - please connect to the internet for using buchhalter-cli: %w", err)
 	}
 
 	if updateExists {
-		c.logger.Info("Starting to update the local file ...", "file", localFileName, "api_endpoint", apiEndpoint)
-		client := &http.Client{
-			Timeout: 10 * time.Second,
-		}
-		ctx := context.Background()
-		apiUrl, err := url.JoinPath(c.apiHost.String(), apiEndpoint)
-		if err != nil {
-			return err
-		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl, nil)
-		if err != nil {
-			return err
-		}
-
-		req.Header.Set("User-Agent", c.userAgent)
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Accept", "application/json")
-		resp, err := client.Do(req)
+		c.logger.Info("Starting to update the local file ...", "file", localFileName, "api_endpoint", apiEndpoint, "host", host.String())
+		content, err := c.getFromHost(ctx, host, apiEndpoint)
 		if err != nil {
 			return err
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusOK {
-			fileToUpdate := filepath.Join(c.configDirectory, localFileName)
-			out, err := os.Create(fileToUpdate)
+		if signatureEndpoint != "" {
+			signature, err := c.getFromHost(ctx, host, signatureEndpoint)
 			if err != nil {
-				return fmt.Errorf("couldn't create "+localFileName+" file: %w", err)
+				return fmt.Errorf("couldn't download signature of "+localFileName+" file: %w", err)
 			}
-			defer out.Close()
-
-			bytesCopied, err := io.Copy(out, resp.Body)
+			err = VerifySignature(content, signature, c.publicKeys)
 			if err != nil {
-				return fmt.Errorf("error copying response body to file: %w", err)
+				return fmt.Errorf("couldn't verify "+localFileName+" file: %w", err)
 			}
+		}
 
-			c.logger.Info("Starting to update the local file ... completed", "file", fileToUpdate, "bytes_written", bytesCopied, "api_endpoint", apiEndpoint)
-			return nil
+		fileToUpdate := filepath.Join(c.configDirectory, localFileName)
+		bytesCopied, err := writeFileAtomically(fileToUpdate, bytes.NewReader(content))
+		if err != nil {
+			return fmt.Errorf("couldn't update "+localFileName+" file: %w", err)
 		}
-		return fmt.Errorf("http request to %s failed with status code: %d", apiUrl, resp.StatusCode)
+
+		c.logger.Info("Starting to update the local file ... completed", "file", fileToUpdate, "bytes_written", bytesCopied, "api_endpoint", apiEndpoint, "host", host.String())
 	}
 
 	return nil
 }
 
-func (c *BuchhalterAPIClient) updateExists(currentChecksum, apiEndpoint string) (bool, error) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+// getFromHost returns the response body of a GET request to apiEndpoint of host.
+func (c *BuchhalterAPIClient) getFromHost(ctx context.Context, host *url.URL, apiEndpoint string) ([]byte, error) {
+	apiUrl, err := url.JoinPath(host.String(), apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.StatusError(resp, "")
 	}
-	ctx := context.Background()
-	apiUrl, err := url.JoinPath(c.apiHost.String(), apiEndpoint)
+	return io.ReadAll(resp.Body)
+}
+
+func (c *BuchhalterAPIClient) updateExists(ctx context.Context, host *url.URL, currentChecksum, apiEndpoint string) (bool, error) {
+	apiUrl, err := url.JoinPath(host.String(), apiEndpoint)
 	if err != nil {
 		return false, err
 	}
@@ -185,7 +259,7 @@ func (c *BuchhalterAPIClient) updateExists(currentChecksum, apiEndpoint string)
 	req.Header.Set("User-Agent", c.userAgent)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		c.logger.Error("Error sending request", "url", apiUrl, "error", err)
 		return false, fmt.Errorf("error sending request: %w", err)
@@ -207,32 +281,17 @@ func (c *BuchhalterAPIClient) updateExists(currentChecksum, apiEndpoint string)
 		return false, fmt.Errorf("update failed with checksum mismatch")
 	}
 
-	return false, fmt.Errorf("http request to %s failed with status code: %d", apiUrl, resp.StatusCode)
+	return false, httpclient.StatusError(resp, "")
 }
 
-func (c *BuchhalterAPIClient) SendMetrics(runData RunData, cliVersion, chromeVersion, vaultVersion, oicdbVersion string) error {
-	rdx, err := json.Marshal(runData)
-	if err != nil {
-		return fmt.Errorf("error marshalling run data: %w", err)
-	}
-
-	md := Metric{
-		MetricType:    "runMetrics",
-		Data:          string(rdx),
-		CliVersion:    cliVersion,
-		OicdbVersion:  oicdbVersion,
-		VaultVersion:  vaultVersion,
-		ChromeVersion: chromeVersion,
-		OS:            runtime.GOOS,
-	}
+// SendMetrics sends the usage metrics of a run (see RunMetrics.Payload) to the Buchhalter API.
+func (c *BuchhalterAPIClient) SendMetrics(ctx context.Context, md Metric) error {
 	mdj, err := json.Marshal(md)
 	if err != nil {
 		return fmt.Errorf("error marshalling run data: %w", err)
 	}
 
-	client := &http.Client{}
-	ctx := context.Background() // Consider using a meaningful context
-	apiUrl, err := url.JoinPath(c.apiHost.String(), metricsAPIEndpoint)
+	apiUrl, err := MetricsURL(c.apiHost.String())
 	if err != nil {
 		return err
 	}
@@ -245,7 +304,7 @@ func (c *BuchhalterAPIClient) SendMetrics(runData RunData, cliVersion, chromeVer
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		c.logger.Error("Error sending request", "url", apiUrl, "error", err)
 		return fmt.Errorf("error sending request: %w", err)
@@ -256,19 +315,48 @@ func (c *BuchhalterAPIClient) SendMetrics(runData RunData, cliVersion, chromeVer
 		return nil
 	}
 
-	return fmt.Errorf("http request to %s failed with status code: %d", apiUrl, resp.StatusCode)
+	return httpclient.StatusError(resp, "")
+}
+
+// SendCrashReport submits a crash report. It must only be called with the consent of the user.
+func (c *BuchhalterAPIClient) SendCrashReport(ctx context.Context, report crash.Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("error marshalling crash report: %w", err)
+	}
+
+	apiUrl, err := url.JoinPath(c.apiHost.String(), crashReportAPIEndpoint)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiUrl, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+
+	return httpclient.StatusError(resp, "")
 }
 
-func (c *BuchhalterAPIClient) GetAuthenticatedUser() (*CliSyncResponse, error) {
+func (c *BuchhalterAPIClient) GetAuthenticatedUser(ctx context.Context) (*CliSyncResponse, error) {
 	// If we don't have an API token, we can't authenticate
 	if len(c.apiToken) == 0 {
 		return nil, nil
 	}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-	ctx := context.Background()
 	apiUrl, err := url.JoinPath(c.apiHost.String(), userAuthAPIEndpoint)
 	if err != nil {
 		return nil, err
@@ -282,7 +370,7 @@ func (c *BuchhalterAPIClient) GetAuthenticatedUser() (*CliSyncResponse, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.apiToken))
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -294,7 +382,7 @@ func (c *BuchhalterAPIClient) GetAuthenticatedUser() (*CliSyncResponse, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http request to %s failed with status code: %d", apiUrl, resp.StatusCode)
+		return nil, httpclient.StatusError(resp, "")
 	}
 
 	var cliSyncResponse CliSyncResponse
@@ -309,15 +397,11 @@ func (c *BuchhalterAPIClient) GetAuthenticatedUser() (*CliSyncResponse, error) {
 	return &cliSyncResponse, nil
 }
 
-func (c *BuchhalterAPIClient) DoesDocumentExist(documentHash string) (bool, error) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+func (c *BuchhalterAPIClient) DoesDocumentExist(ctx context.Context, documentHash string) (bool, error) {
+	team, err := c.team()
+	if err != nil {
+		return false, err
 	}
-	ctx := context.Background()
-
-	// TODO How do we select the correct team?
-	// For now we just get the first one
-	teamId := c.authenticatedUser.Teams[0].ID
 
 	requestPayload := struct {
 		FileChecksum string `json:"file_checksum"`
@@ -329,7 +413,7 @@ func (c *BuchhalterAPIClient) DoesDocumentExist(documentHash string) (bool, erro
 		return false, err
 	}
 
-	apiEndpoint := fmt.Sprintf("api/cli/%s/check", teamId)
+	apiEndpoint := fmt.Sprintf("api/cli/%s/check", team.ID)
 	apiUrl, err := url.JoinPath(c.apiHost.String(), apiEndpoint)
 	if err != nil {
 		return false, err
@@ -344,14 +428,14 @@ func (c *BuchhalterAPIClient) DoesDocumentExist(documentHash string) (bool, erro
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.apiToken))
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return false, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("http request to %s failed with status code: %d", apiUrl, resp.StatusCode)
+		return false, httpclient.StatusError(resp, "")
 	}
 
 	var checkResponse DocumentCheckResponse
@@ -366,102 +450,3 @@ func (c *BuchhalterAPIClient) DoesDocumentExist(documentHash string) (bool, erro
 
 	return true, nil
 }
-
-func (c *BuchhalterAPIClient) UploadDocument(filePath, supplier string) error {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-	ctx := context.Background()
-
-	// Prepare a form that you will submit to that URL.
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
-
-	fileName := filepath.Base(filePath)
-
-	// Add file to request
-	fileWriter, err := writer.CreateFormFile("file", fileName)
-	if err != nil {
-		c.logger.Error("Error creating form `file`", "file", fileName, "error", err)
-		return err
-	}
-	fileHandle, err := os.Open(filePath)
-	if err != nil {
-		c.logger.Error("Error opening file", "file", fileName, "error", err)
-		return err
-	}
-	defer fileHandle.Close()
-	_, err = io.Copy(fileWriter, fileHandle)
-	if err != nil {
-		c.logger.Error("Error copying file", "file", fileName, "error", err)
-		return err
-	}
-
-	// Add supplier to request
-	supplierWriter, err := writer.CreateFormField("supplier")
-	if err != nil {
-		c.logger.Error("Error creating form `supplier`", "supplier", supplier, "error", err)
-		return err
-	}
-	buf := bytes.NewBufferString(supplier)
-	if _, err = io.Copy(supplierWriter, buf); err != nil {
-		c.logger.Error("Error copying supplier", "supplier", supplier, "error", err)
-		return err
-	}
-
-	err = writer.Close()
-	if err != nil {
-		c.logger.Error("Error closing writer", "error", err)
-		return err
-	}
-
-	// TODO How do we select the correct team?
-	// For now we just get the first one
-	teamId := c.authenticatedUser.Teams[0].ID
-
-	apiEndpoint := fmt.Sprintf("api/cli/%s/upload", teamId)
-	apiUrl, err := url.JoinPath(c.apiHost.String(), apiEndpoint)
-	if err != nil {
-		return err
-	}
-	c.logger.Info("Upload document to API", "url", apiUrl, "file", filePath, "supplier", supplier)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiUrl, body)
-	if err != nil {
-		c.logger.Error("Error creating request", "url", apiUrl, "file", filePath, "supplier", supplier, "error", err)
-		return err
-	}
-
-	req.Header.Set("User-Agent", c.userAgent)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Accept", "application/json")
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.apiToken))
-	resp, err := client.Do(req)
-	if err != nil {
-		c.logger.Error("Error sending request", "url", apiUrl, "file", filePath, "supplier", supplier, "error", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var errorResponse ErrorAPIResponse
-		err = json.NewDecoder(resp.Body).Decode(&errorResponse)
-		if err != nil {
-			c.logger.Error("Error decoding error response", "url", apiUrl, "file", filePath, "supplier", supplier, "status_code", resp.StatusCode, "error", err)
-			return err
-		}
-
-		c.logger.Error("Upload document to API ... failed", "url", apiUrl, "file", filePath, "supplier", supplier, "status_code", resp.StatusCode, "error_code", errorResponse.ErrorCode, "error_message", errorResponse.ErrorMessage)
-		return fmt.Errorf("http request to %s failed with status code: %d (code: %s, message %s)", apiUrl, resp.StatusCode, errorResponse.ErrorCode, errorResponse.ErrorMessage)
-	}
-
-	var uploadResponse DocumentUploadResponse
-	err = json.NewDecoder(resp.Body).Decode(&uploadResponse)
-	if err != nil {
-		c.logger.Error("Error decoding upload response", "url", apiUrl, "file", filePath, "supplier", supplier, "error", err)
-		return err
-	}
-
-	c.logger.Info("Upload document to API ... success", "url", apiUrl, "file", filePath, "supplier", supplier, "status_code", resp.StatusCode, "status", uploadResponse.Status, "document_id", uploadResponse.DocumentID)
-
-	return nil
-}
@@ -0,0 +1,58 @@
+package repository
+
+import "errors"
+
+// ErrNoTeam is returned by requests to the team workspace if the authenticated user isn't a member of any team.
+var ErrNoTeam = errors.New("the authenticated user isn't a member of a team")
+
+// RoleRestrictions are the restrictions of the role of a user in a team. The Buchhalter Platform provides them, the
+// CLI enforces them. The zero value restricts nothing, e.g. for owners or platforms without roles.
+type RoleRestrictions struct {
+	// DenySync forbids running supplier recipes
+	DenySync bool `json:"denySync,omitempty"`
+	// DenyExport forbids exporting documents or the buchhalter state, e.g. period bundles and backups
+	DenyExport bool `json:"denyExport,omitempty"`
+	// Suppliers limits syncs to these suppliers, all suppliers may be synced if empty
+	Suppliers []string `json:"suppliers,omitempty"`
+}
+
+// AllowsSupplier returns true if supplier may be synced.
+func (r RoleRestrictions) AllowsSupplier(supplier string) bool {
+	if r.DenySync {
+		return false
+	}
+	if len(r.Suppliers) == 0 {
+		return true
+	}
+	for _, s := range r.Suppliers {
+		if s == supplier {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTeamSlug selects the team of the authenticated user the client works for (see connect --team).
+// Without a slug or if the user isn't a member of the team, the first team of the user is used.
+func (c *BuchhalterAPIClient) SetTeamSlug(slug string) {
+	c.teamSlug = slug
+}
+
+// Team returns the selected team of the authenticated user incl. the role of the user, false if the user isn't
+// authenticated (see GetAuthenticatedUser).
+func (c *BuchhalterAPIClient) Team() (Team, bool) {
+	team, err := c.team()
+	return team, err == nil
+}
+
+func (c *BuchhalterAPIClient) team() (Team, error) {
+	if len(c.authenticatedUser.Teams) == 0 {
+		return Team{}, ErrNoTeam
+	}
+	for _, team := range c.authenticatedUser.Teams {
+		if team.Slug == c.teamSlug {
+			return team, nil
+		}
+	}
+	return c.authenticatedUser.Teams[0], nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import "testing"
+
+func TestRoleRestrictionsAllowsSupplier(t *testing.T) {
+	tests := []struct {
+		restrictions RoleRestrictions
+		supplier     string
+		expected     bool
+	}{
+		{restrictions: RoleRestrictions{}, supplier: "hetzner", expected: true},
+		{restrictions: RoleRestrictions{DenyExport: true}, supplier: "hetzner", expected: true},
+		{restrictions: RoleRestrictions{DenySync: true}, supplier: "hetzner", expected: false},
+		{restrictions: RoleRestrictions{Suppliers: []string{"hetzner", "ionos"}}, supplier: "ionos", expected: true},
+		{restrictions: RoleRestrictions{Suppliers: []string{"hetzner", "ionos"}}, supplier: "aws", expected: false},
+	}
+	for _, tt := range tests {
+		if allowed := tt.restrictions.AllowsSupplier(tt.supplier); allowed != tt.expected {
+			t.Errorf("expected %t for %s with %+v, got %t", tt.expected, tt.supplier, tt.restrictions, allowed)
+		}
+	}
+}
+
+func TestTeamSelection(t *testing.T) {
+	c := &BuchhalterAPIClient{}
+	if _, ok := c.Team(); ok {
+		t.Error("expected no team without an authenticated user")
+	}
+
+	c.authenticatedUser = AuthenticatedUser{Teams: []Team{
+		{ID: "1", Slug: "acme", Role: "owner"},
+		{ID: "2", Slug: "client", Role: "member", Restrictions: RoleRestrictions{DenyExport: true}},
+	}}
+	if team, _ := c.Team(); team.ID != "1" {
+		t.Errorf("expected the first team without a team slug, got %+v", team)
+	}
+	c.SetTeamSlug("client")
+	if team, _ := c.Team(); team.ID != "2" || !team.Restrictions.DenyExport {
+		t.Errorf("expected the team client, got %+v", team)
+	}
+	c.SetTeamSlug("unknown")
+	if team, _ := c.Team(); team.ID != "1" {
+		t.Errorf("expected the first team for an unknown team slug, got %+v", team)
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+const (
+	RUN_STATUS_RUNNING   = "running"
+	RUN_STATUS_COMPLETED = "completed"
+	RUN_STATUS_FAILED    = "failed"
+)
+
+type runStartRequest struct {
+	Hostname   string    `json:"hostname"`
+	OS         string    `json:"os"`
+	CliVersion string    `json:"cliVersion"`
+	Suppliers  []string  `json:"suppliers"`
+	StartedAt  time.Time `json:"startedAt"`
+	// Role of the user starting the run and the suppliers skipped due to its restrictions
+	Role                string   `json:"role,omitempty"`
+	RestrictedSuppliers []string `json:"restrictedSuppliers,omitempty"`
+}
+
+type runStartResponse struct {
+	Status string `json:"status"`
+	RunID  string `json:"run_id"`
+}
+
+type runEndRequest struct {
+	Status     string    `json:"status"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// CredentialAccess are the number of credential and token accesses of the run by kind (see lib/audit)
+	CredentialAccess map[string]int `json:"credentialAccess,omitempty"`
+}
+
+// ReportRunStart registers a new sync run for the team of the authenticated user.
+// restrictedSuppliers are the suppliers the role of the user may not sync.
+// The returned run id is needed to report supplier results and the end of the run.
+func (c *BuchhalterAPIClient) ReportRunStart(ctx context.Context, cliVersion string, suppliers, restrictedSuppliers []string) (string, error) {
+	team, err := c.team()
+	if err != nil {
+		return "", err
+	}
+	hostname, _ := os.Hostname()
+	payload, err := json.Marshal(runStartRequest{
+		Hostname:            hostname,
+		OS:                  runtime.GOOS,
+		CliVersion:          cliVersion,
+		Suppliers:           suppliers,
+		StartedAt:           time.Now(),
+		Role:                team.Role,
+		RestrictedSuppliers: restrictedSuppliers,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	apiEndpoint := fmt.Sprintf("api/cli/%s/runs", team.ID)
+	responseBody, err := c.doTeamRequest(ctx, http.MethodPost, apiEndpoint, "application/json", bytes.NewReader(payload), teamRequestTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	var response runStartResponse
+	err = json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return "", err
+	}
+	c.logger.Info("Registered run at Buchhalter API", "run_id", response.RunID)
+
+	return response.RunID, nil
+}
+
+// ReportSupplierStatus reports the result of a single supplier recipe of a run.
+func (c *BuchhalterAPIClient) ReportSupplierStatus(ctx context.Context, runID string, supplierResult RunDataSupplier) error {
+	payload, err := json.Marshal(supplierResult)
+	if err != nil {
+		return err
+	}
+
+	team, err := c.team()
+	if err != nil {
+		return err
+	}
+	apiEndpoint := fmt.Sprintf("api/cli/%s/runs/%s/suppliers", team.ID, runID)
+	_, err = c.doTeamRequest(ctx, http.MethodPost, apiEndpoint, "application/json", bytes.NewReader(payload), teamRequestTimeout)
+	return err
+}
+
+// ReportRunEnd marks a run as finished with the given status (see RUN_STATUS_* constants).
+// credentialAccess are the aggregated credential accesses of the run, nil to not report them.
+func (c *BuchhalterAPIClient) ReportRunEnd(ctx context.Context, runID, status string, credentialAccess map[string]int) error {
+	payload, err := json.Marshal(runEndRequest{
+		Status:           status,
+		FinishedAt:       time.Now(),
+		CredentialAccess: credentialAccess,
+	})
+	if err != nil {
+		return err
+	}
+
+	team, err := c.team()
+	if err != nil {
+		return err
+	}
+	apiEndpoint := fmt.Sprintf("api/cli/%s/runs/%s", team.ID, runID)
+	_, err = c.doTeamRequest(ctx, http.MethodPut, apiEndpoint, "application/json", bytes.NewReader(payload), teamRequestTimeout)
+	return err
+}
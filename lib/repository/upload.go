@@ -0,0 +1,301 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+const (
+	uploadChunkSize     = 4 * 1024 * 1024
+	uploadStateFileName = ".buchhalter-uploads.json"
+	// teamRequestTimeout is the timeout of team requests, chunks uploaded with a bandwidth limit get the time their
+	// transfer takes in addition (see uploadChunkTimeout)
+	teamRequestTimeout = 60 * time.Second
+)
+
+// UploadOptions configures the chunked document upload.
+type UploadOptions struct {
+	// EncryptionKey is the AES-256 key used to encrypt every chunk on the client side (see GetOrCreateUploadKey)
+	EncryptionKey []byte
+
+	// BandwidthLimit is the maximum upload rate in bytes per second (0 = unlimited)
+	BandwidthLimit int64
+}
+
+type uploadSessionRequest struct {
+	FileChecksum string   `json:"file_checksum"`
+	FileName     string   `json:"file_name"`
+	FileSize     int64    `json:"file_size"`
+	Supplier     string   `json:"supplier"`
+	Tags         []string `json:"tags,omitempty"`
+	ChunkSize    int      `json:"chunk_size"`
+	ChunkCount   int      `json:"chunk_count"`
+	Encryption   string   `json:"encryption"`
+}
+
+type uploadSessionResponse struct {
+	Status   string `json:"status"`
+	UploadID string `json:"upload_id"`
+}
+
+// uploadState tracks unfinished uploads by file checksum, so interrupted uploads can be resumed.
+type uploadState map[string]uploadStateEntry
+
+type uploadStateEntry struct {
+	UploadID       string `json:"uploadId"`
+	UploadedChunks int    `json:"uploadedChunks"`
+}
+
+// UploadDocumentChunked uploads a document in encrypted chunks to the team workspace.
+// Interrupted uploads are resumed with the next missing chunk.
+func (c *BuchhalterAPIClient) UploadDocumentChunked(ctx context.Context, filePath, fileChecksum, supplier string, tags []string, options UploadOptions) error {
+	team, err := c.team()
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(options.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("error initializing document encryption: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("error initializing document encryption: %w", err)
+	}
+
+	fileHandle, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer fileHandle.Close()
+	fileStat, err := fileHandle.Stat()
+	if err != nil {
+		return err
+	}
+	chunkCount := int((fileStat.Size() + uploadChunkSize - 1) / uploadChunkSize)
+
+	state, err := c.readUploadState()
+	if err != nil {
+		return err
+	}
+	entry, ok := state[fileChecksum]
+	if !ok {
+		entry.UploadID, err = c.createUploadSession(ctx, team.ID, uploadSessionRequest{
+			FileChecksum: fileChecksum,
+			FileName:     filepath.Base(filePath),
+			FileSize:     fileStat.Size(),
+			Supplier:     supplier,
+			Tags:         tags,
+			ChunkSize:    uploadChunkSize,
+			ChunkCount:   chunkCount,
+			Encryption:   "aes-256-gcm",
+		})
+		if err != nil {
+			return err
+		}
+		state[fileChecksum] = entry
+		err = c.writeUploadState(state)
+		if err != nil {
+			return err
+		}
+	} else {
+		c.logger.Info("Resuming document upload", "file", filePath, "upload_id", entry.UploadID, "uploaded_chunks", entry.UploadedChunks, "chunk_count", chunkCount)
+	}
+
+	chunk := make([]byte, uploadChunkSize)
+	for i := entry.UploadedChunks; i < chunkCount; i++ {
+		n, err := fileHandle.ReadAt(chunk, int64(i)*uploadChunkSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		// Every chunk is sealed on its own (nonce + ciphertext), so chunks can be decrypted independently.
+		// The position of the chunk is authenticated, so chunks can't be swapped, reordered or truncated unnoticed.
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		encryptedChunk := gcm.Seal(nonce, nonce, chunk[:n], uploadChunkAAD(entry.UploadID, i, i == chunkCount-1))
+
+		apiEndpoint := fmt.Sprintf("api/cli/%s/uploads/%s/chunks/%d", team.ID, entry.UploadID, i)
+		var body io.Reader = bytes.NewReader(encryptedChunk)
+		if options.BandwidthLimit > 0 {
+			body = newRateLimitedReader(body, options.BandwidthLimit)
+		}
+		_, err = c.doTeamRequest(ctx, http.MethodPut, apiEndpoint, "application/octet-stream", body, uploadChunkTimeout(len(encryptedChunk), options.BandwidthLimit))
+		if err != nil {
+			return fmt.Errorf("error uploading chunk %d/%d of %s: %w", i+1, chunkCount, filePath, err)
+		}
+
+		entry.UploadedChunks = i + 1
+		state[fileChecksum] = entry
+		err = c.writeUploadState(state)
+		if err != nil {
+			return err
+		}
+	}
+
+	apiEndpoint := fmt.Sprintf("api/cli/%s/uploads/%s/complete", team.ID, entry.UploadID)
+	_, err = c.doTeamRequest(ctx, http.MethodPost, apiEndpoint, "application/json", nil, teamRequestTimeout)
+	if err != nil {
+		return fmt.Errorf("error completing upload of %s: %w", filePath, err)
+	}
+
+	delete(state, fileChecksum)
+	c.logger.Info("Upload document to API ... success", "file", filePath, "supplier", supplier, "upload_id", entry.UploadID, "chunk_count", chunkCount)
+
+	return c.writeUploadState(state)
+}
+
+// uploadChunkTimeout returns the timeout of the upload of a chunk of size bytes, which includes its transfer time at
+// bandwidthLimit bytes per second (0 = unlimited).
+func uploadChunkTimeout(size int, bandwidthLimit int64) time.Duration {
+	if bandwidthLimit <= 0 {
+		return teamRequestTimeout
+	}
+	return teamRequestTimeout + time.Duration(int64(size)*int64(time.Second)/bandwidthLimit)
+}
+
+// uploadChunkAAD returns the additional authenticated data of a chunk: the upload id, the index of the chunk and
+// whether it is the final chunk of the upload (e.g. "upload-1:3:true").
+func uploadChunkAAD(uploadID string, index int, final bool) []byte {
+	return []byte(fmt.Sprintf("%s:%d:%t", uploadID, index, final))
+}
+
+func (c *BuchhalterAPIClient) createUploadSession(ctx context.Context, teamID string, sessionRequest uploadSessionRequest) (string, error) {
+	payload, err := json.Marshal(sessionRequest)
+	if err != nil {
+		return "", err
+	}
+
+	apiEndpoint := fmt.Sprintf("api/cli/%s/uploads", teamID)
+	responseBody, err := c.doTeamRequest(ctx, http.MethodPost, apiEndpoint, "application/json", bytes.NewReader(payload), teamRequestTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	var sessionResponse uploadSessionResponse
+	err = json.Unmarshal(responseBody, &sessionResponse)
+	if err != nil {
+		return "", err
+	}
+	if sessionResponse.UploadID == "" {
+		return "", fmt.Errorf("no upload id returned for %s", sessionRequest.FileName)
+	}
+
+	return sessionResponse.UploadID, nil
+}
+
+func (c *BuchhalterAPIClient) doTeamRequest(ctx context.Context, method, apiEndpoint, contentType string, body io.Reader, timeout time.Duration) ([]byte, error) {
+	// Uploads take longer than regular API requests
+	client := c.httpClient.WithTimeout(timeout)
+
+	apiUrl, err := url.JoinPath(c.apiHost.String(), apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiUrl, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.apiToken))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errorResponse ErrorAPIResponse
+		_ = json.Unmarshal(responseBody, &errorResponse)
+		return nil, httpclient.StatusError(resp, fmt.Sprintf("code: %s, message %s", errorResponse.ErrorCode, errorResponse.ErrorMessage))
+	}
+
+	return responseBody, nil
+}
+
+func (c *BuchhalterAPIClient) readUploadState() (uploadState, error) {
+	state := uploadState{}
+
+	fileContent, err := os.ReadFile(filepath.Join(c.configDirectory, uploadStateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(fileContent, &state)
+	return state, err
+}
+
+func (c *BuchhalterAPIClient) writeUploadState(state uploadState) error {
+	fileContent, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(c.configDirectory, uploadStateFileName), fileContent, 0600)
+}
+
+// rateLimitedReader limits the read throughput to bytesPerSecond.
+type rateLimitedReader struct {
+	reader         io.Reader
+	bytesPerSecond int64
+	start          time.Time
+	bytesRead      int64
+}
+
+func newRateLimitedReader(reader io.Reader, bytesPerSecond int64) *rateLimitedReader {
+	return &rateLimitedReader{
+		reader:         reader,
+		bytesPerSecond: bytesPerSecond,
+	}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+
+	// Never read more than a tenth of the allowed bytes per second at once to keep the rate smooth
+	maxRead := r.bytesPerSecond / 10
+	if maxRead < 1 {
+		maxRead = 1
+	}
+	if int64(len(p)) > maxRead {
+		p = p[:maxRead]
+	}
+
+	n, err := r.reader.Read(p)
+	r.bytesRead += int64(n)
+
+	expectedDuration := time.Duration(float64(r.bytesRead) / float64(r.bytesPerSecond) * float64(time.Second))
+	if elapsed := time.Since(r.start); expectedDuration > elapsed {
+		time.Sleep(expectedDuration - elapsed)
+	}
+
+	return n, err
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+func TestUploadDocumentChunked(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	var mutex sync.Mutex
+	chunks := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/cli/1/uploads":
+			w.Write([]byte(`{"status":"ok","upload_id":"upload-1"}`))
+		case strings.HasPrefix(r.URL.Path, "/api/cli/1/uploads/upload-1/chunks/"):
+			body, _ := io.ReadAll(r.Body)
+			mutex.Lock()
+			chunks[filepath.Base(r.URL.Path)] = body
+			mutex.Unlock()
+		case r.URL.Path == "/api/cli/1/uploads/upload-1/complete":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	configDirectory := t.TempDir()
+	document := bytes.Repeat([]byte("invoice "), uploadChunkSize/8+1)
+	documentFile := filepath.Join(t.TempDir(), "invoice.pdf")
+	if err := os.WriteFile(documentFile, document, 0644); err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{7}, 32)
+	c, err := NewBuchhalterAPIClient(logger, httpclient.New(logger, 5*time.Second, 0), server.URL, configDirectory, "", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.UploadDocumentChunked(context.Background(), documentFile, "checksum", "hetzner", nil, UploadOptions{EncryptionKey: key})
+	if !errors.Is(err, ErrNoTeam) {
+		t.Errorf("expected an error without a team, got %v", err)
+	}
+
+	c.authenticatedUser = AuthenticatedUser{Teams: []Team{{ID: "1", Slug: "acme"}}}
+	err = c.UploadDocumentChunked(context.Background(), documentFile, "checksum", "hetzner", nil, UploadOptions{EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(chunks))
+	}
+
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	open := func(chunk []byte, aad []byte) ([]byte, error) {
+		return gcm.Open(nil, chunk[:gcm.NonceSize()], chunk[gcm.NonceSize():], aad)
+	}
+	first, err := open(chunks["0"], uploadChunkAAD("upload-1", 0, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := open(chunks["1"], uploadChunkAAD("upload-1", 1, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(first, last...), document) {
+		t.Error("decrypted chunks differ from the document")
+	}
+
+	// Chunks are bound to their upload and position
+	if _, err := open(chunks["0"], uploadChunkAAD("upload-1", 1, true)); err == nil {
+		t.Error("expected a chunk moved to another position to fail")
+	}
+	if _, err := open(chunks["0"], uploadChunkAAD("upload-1", 0, true)); err == nil {
+		t.Error("expected a chunk marked as final to fail")
+	}
+	if _, err := open(chunks["1"], uploadChunkAAD("upload-2", 1, true)); err == nil {
+		t.Error("expected a chunk of another upload to fail")
+	}
+}
+
+func TestUploadChunkTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		bandwidthLimit int64
+		timeout        time.Duration
+	}{
+		{"unlimited", 0, teamRequestTimeout},
+		// 4 MiB at 32 KiB/s take 128 seconds
+		{"limited", 32 * 1024, teamRequestTimeout + 128*time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if timeout := uploadChunkTimeout(uploadChunkSize, test.bandwidthLimit); timeout != test.timeout {
+				t.Errorf("expected %s, got %s", test.timeout, timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// JWTClaims are the claims of a JWT access token relevant for its validity.
+type JWTClaims struct {
+	ExpiresAt float64 `json:"exp"`
+	IssuedAt  float64 `json:"iat"`
+	Issuer    string  `json:"iss"`
+	Scope     string  `json:"scope"`
+}
+
+// ParseJWTClaims decodes the claims of a JWT. It returns false if token is not a JWT (e.g. an opaque access token).
+// The signature is not verified: the claims are only used to avoid sending expired tokens, the supplier still validates them.
+func ParseJWTClaims(token string) (JWTClaims, bool) {
+	var claims JWTClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return claims, false
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return claims, false
+	}
+
+	return claims, true
+}
+
+// ExpiresAt returns when the access token expires.
+// For JWT access tokens the exp (or iat) claim is the source of truth, as the locally recorded CreatedAt
+// drifts if the tokens are copied between machines. Other tokens expire ExpiresIn seconds after CreatedAt.
+func (t Oauth2Tokens) ExpiresAt() time.Time {
+	if claims, ok := ParseJWTClaims(t.AccessToken); ok {
+		if claims.ExpiresAt > 0 {
+			return time.Unix(int64(claims.ExpiresAt), 0)
+		}
+		if claims.IssuedAt > 0 && t.ExpiresIn > 0 {
+			return time.Unix(int64(claims.IssuedAt)+int64(t.ExpiresIn), 0)
+		}
+	}
+
+	return time.Unix(int64(t.CreatedAt+t.ExpiresIn), 0)
+}
+
+// SessionExpiresAt returns when the cached session ends and the next sync has to log in again: without refresh token
+// when the access token expires, otherwise when a JWT refresh token expires. False if the end is unknown (e.g. for
+// opaque refresh tokens).
+func (t Oauth2Tokens) SessionExpiresAt() (time.Time, bool) {
+	if t.RefreshToken == "" {
+		return t.ExpiresAt(), true
+	}
+	if claims, ok := ParseJWTClaims(t.RefreshToken); ok && claims.ExpiresAt > 0 {
+		return time.Unix(int64(claims.ExpiresAt), 0), true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func testJWT(payload string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+}
+
+func TestExpiresAt(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name     string
+		tokens   Oauth2Tokens
+		expected int64
+	}{
+		{"opaque token", Oauth2Tokens{AccessToken: "opaque", CreatedAt: int(now), ExpiresIn: 3600}, now + 3600},
+		{"jwt exp overrides local lifetime", Oauth2Tokens{AccessToken: testJWT(`{"exp": 1700000000, "iat": 1699996400}`), CreatedAt: int(now), ExpiresIn: 3600}, 1700000000},
+		{"jwt iat with expires in", Oauth2Tokens{AccessToken: testJWT(`{"iat": 1699996400}`), CreatedAt: int(now), ExpiresIn: 3600}, 1700000000},
+		{"jwt without time claims", Oauth2Tokens{AccessToken: testJWT(`{"sub": "user"}`), CreatedAt: int(now), ExpiresIn: 60}, now + 60},
+		{"malformed jwt payload", Oauth2Tokens{AccessToken: "a.not-base64!.c", CreatedAt: int(now), ExpiresIn: 60}, now + 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if expiresAt := tt.tokens.ExpiresAt().Unix(); expiresAt != tt.expected {
+				t.Errorf("expected expiry %d, got %d", tt.expected, expiresAt)
+			}
+		})
+	}
+}
+
+func TestSessionExpiresAt(t *testing.T) {
+	now := time.Now().Unix()
+
+	expiresAt, ok := Oauth2Tokens{AccessToken: "opaque", CreatedAt: int(now), ExpiresIn: 3600}.SessionExpiresAt()
+	if !ok || expiresAt.Unix() != now+3600 {
+		t.Errorf("expected the expiry of the access token without refresh token, got %s (%t)", expiresAt, ok)
+	}
+	expiresAt, ok = Oauth2Tokens{AccessToken: "opaque", RefreshToken: testJWT(`{"exp": 1700000000}`), CreatedAt: int(now), ExpiresIn: 3600}.SessionExpiresAt()
+	if !ok || expiresAt.Unix() != 1700000000 {
+		t.Errorf("expected the expiry of the refresh token, got %s (%t)", expiresAt, ok)
+	}
+	if _, ok = (Oauth2Tokens{AccessToken: "opaque", RefreshToken: "opaque", CreatedAt: int(now), ExpiresIn: 3600}).SessionExpiresAt(); ok {
+		t.Error("expected an unknown expiry for opaque refresh tokens")
+	}
+}
+
+func TestParseJWTClaims(t *testing.T) {
+	claims, ok := ParseJWTClaims(testJWT(`{"exp": 1700000000, "iss": "https://idp.example", "scope": "invoices:read"}`))
+	if !ok || claims.Issuer != "https://idp.example" || claims.Scope != "invoices:read" || claims.ExpiresAt != 1700000000 {
+		t.Errorf("unexpected claims %+v (%t)", claims, ok)
+	}
+	if _, ok := ParseJWTClaims("opaque-token"); ok {
+		t.Error("expected opaque token not to be parsed as JWT")
+	}
+}
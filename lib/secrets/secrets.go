@@ -1,15 +1,26 @@
 package secrets
 
+// Cache of OAuth2 tokens. Each credential of a supplier has its own file in the token directory of a profile.
+// Filenames are hashes, so they don't reveal suppliers or credentials.
+
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
-const secretsFilename string = ".secrets.json"
+const (
+	// legacySecretsFilename is the token cache of all suppliers used before tokens were stored per credential
+	legacySecretsFilename string = ".secrets.json"
+
+	DEFAULT_PROFILE = "default"
+)
 
 type Oauth2Tokens struct {
 	AccessToken  string `json:"access_token"`
@@ -20,6 +31,13 @@ type Oauth2Tokens struct {
 	CreatedAt    int    `json:"created_at"`
 }
 
+// tokenFile is the cache file of the tokens of one credential of a supplier.
+type tokenFile struct {
+	Supplier     string                `json:"supplier"`
+	CredentialId string                `json:"credentialId"`
+	Tokens       secretFileEntryTokens `json:"accessTokens"`
+}
+
 type secretFile struct {
 	Secrets []secretFileEntry `json:"secrets"`
 }
@@ -38,115 +56,178 @@ type secretFileEntryTokens struct {
 	CreatedAt    int    `json:"createdAt"`
 }
 
-func SaveOauth2TokensToFile(id string, tokens Oauth2Tokens, buchhalterConfigDirectory string) error {
-	sfe, err := readSecretsFile(buchhalterConfigDirectory)
-	if err != nil {
-		return err
+// TokenDirectory returns the token cache directory of a profile.
+func TokenDirectory(buchhalterConfigDirectory, profile string) string {
+	if profile == "" {
+		profile = DEFAULT_PROFILE
 	}
+	return filepath.Join(buchhalterConfigDirectory, "tokens", profile)
+}
 
-	ca := int(time.Now().Unix())
-	t := secretFileEntryTokens{
-		AccessToken:  tokens.AccessToken,
-		RefreshToken: tokens.RefreshToken,
-		TokenType:    tokens.TokenType,
-		State:        tokens.State,
-		ExpiresIn:    tokens.ExpiresIn,
-		CreatedAt:    ca,
-	}
+// tokenFilePath returns the cache file of a credential of a supplier, named by a hash of both.
+func tokenFilePath(supplier, credentialId, tokenDirectory string) string {
+	hash := sha256.Sum256([]byte(supplier + "|" + credentialId))
+	return filepath.Join(tokenDirectory, hex.EncodeToString(hash[:])+".json")
+}
 
-	// Update secret
-	f := false
-	for i, e := range sfe.Secrets {
-		if e.Id == id {
-			f = true
-			sfe.Secrets[i].Tokens = t
-		}
+func SaveOauth2TokensToFile(supplier, credentialId string, tokens Oauth2Tokens, tokenDirectory string) error {
+	ca := tokens.CreatedAt
+	if ca == 0 {
+		ca = int(time.Now().Unix())
 	}
 
-	// Add secret
-	if !f {
-		sfn := secretFileEntry{
-			Id:     id,
-			Tokens: t,
-		}
-		sfe.Secrets = append(sfe.Secrets, sfn)
+	return writeTokenFile(tokenFilePath(supplier, credentialId, tokenDirectory), tokenFile{
+		Supplier:     supplier,
+		CredentialId: credentialId,
+		Tokens: secretFileEntryTokens{
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+			TokenType:    tokens.TokenType,
+			State:        tokens.State,
+			ExpiresIn:    tokens.ExpiresIn,
+			CreatedAt:    ca,
+		},
+	})
+}
+
+func GetOauthAccessTokenFromCache(supplier, credentialId, tokenDirectory string) (Oauth2Tokens, error) {
+	tf, err := readTokenFile(tokenFilePath(supplier, credentialId, tokenDirectory))
+	if errors.Is(err, os.ErrNotExist) {
+		return Oauth2Tokens{}, fmt.Errorf("no tokens found for supplier %s", supplier)
+	}
+	if err != nil {
+		return Oauth2Tokens{}, err
 	}
 
-	return writeSecretsFile(sfe, buchhalterConfigDirectory)
+	return tf.Tokens.oauth2Tokens(), nil
+}
+
+func (t secretFileEntryTokens) oauth2Tokens() Oauth2Tokens {
+	return Oauth2Tokens{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		ExpiresIn:    t.ExpiresIn,
+		State:        t.State,
+		TokenType:    t.TokenType,
+		CreatedAt:    t.CreatedAt,
+	}
 }
 
-func GetOauthAccessTokenFromCache(id, buchhalterConfigDirectory string) (Oauth2Tokens, error) {
-	var tokens Oauth2Tokens
+// CachedOauth2Tokens are the cached tokens of a credential of a supplier.
+type CachedOauth2Tokens struct {
+	Supplier     string
+	CredentialId string
+	Tokens       Oauth2Tokens
+}
 
-	sfe, err := readSecretsFile(buchhalterConfigDirectory)
+// ListOauth2Tokens returns all cached tokens of the token directory.
+func ListOauth2Tokens(tokenDirectory string) ([]CachedOauth2Tokens, error) {
+	paths, err := filepath.Glob(filepath.Join(tokenDirectory, "*.json"))
 	if err != nil {
-		return tokens, err
+		return nil, err
 	}
 
-	for _, e := range sfe.Secrets {
-		if e.Id == id {
-			tokens = Oauth2Tokens{
-				AccessToken:  e.Tokens.AccessToken,
-				RefreshToken: e.Tokens.RefreshToken,
-				ExpiresIn:    e.Tokens.ExpiresIn,
-				State:        e.Tokens.State,
-				TokenType:    e.Tokens.TokenType,
-				CreatedAt:    e.Tokens.CreatedAt,
-			}
-			return tokens, nil
+	cachedTokens := make([]CachedOauth2Tokens, 0, len(paths))
+	for _, path := range paths {
+		tf, err := readTokenFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading token file %s: %w", path, err)
 		}
+		cachedTokens = append(cachedTokens, CachedOauth2Tokens{
+			Supplier:     tf.Supplier,
+			CredentialId: tf.CredentialId,
+			Tokens:       tf.Tokens.oauth2Tokens(),
+		})
 	}
 
-	return tokens, fmt.Errorf("no tokens found for id %s", id)
+	return cachedTokens, nil
 }
 
-func readSecretsFile(buchhalterConfigDirectory string) (secretFile, error) {
-	var sfe secretFile
+// DeleteOauth2Tokens removes the cached tokens of all credentials of a supplier and returns the number of removed files.
+func DeleteOauth2Tokens(supplier, tokenDirectory string) (int, error) {
+	cachedTokens, err := ListOauth2Tokens(tokenDirectory)
+	if err != nil {
+		return 0, err
+	}
 
-	sef := filepath.Join(buchhalterConfigDirectory, secretsFilename)
-	if _, err := os.Stat(sef); os.IsNotExist(err) {
-		emptyFile := secretFile{}
-		emptyFileJson, err := json.Marshal(emptyFile)
-		if err != nil {
-			return sfe, err
+	removed := 0
+	for _, cached := range cachedTokens {
+		if cached.Supplier != supplier {
+			continue
 		}
-		err = os.WriteFile(filepath.Join(buchhalterConfigDirectory, secretsFilename), emptyFileJson, 0600)
+		err = os.Remove(tokenFilePath(cached.Supplier, cached.CredentialId, tokenDirectory))
 		if err != nil {
-			return sfe, err
+			return removed, err
 		}
-		return sfe, nil
+		removed++
+	}
 
-	} else {
-		sf, err := os.Open(sef)
-		if err != nil {
-			return sfe, err
-		}
-		defer sf.Close()
+	return removed, nil
+}
 
-		byteValue, err := io.ReadAll(sf)
-		if err != nil {
-			return sfe, err
-		}
+// MigrateLegacyTokens moves the tokens of the legacy `.secrets.json` file of the config directory into
+// per-credential files of the token directory and removes the legacy file. Tokens already in the
+// token directory are newer and kept. It returns the number of migrated tokens.
+func MigrateLegacyTokens(buchhalterConfigDirectory, tokenDirectory string) (int, error) {
+	legacyFile := filepath.Join(buchhalterConfigDirectory, legacySecretsFilename)
+	fileContent, err := os.ReadFile(legacyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
 
-		err = json.Unmarshal(byteValue, &sfe)
+	var sfe secretFile
+	err = json.Unmarshal(fileContent, &sfe)
+	if err != nil {
+		return 0, fmt.Errorf("error reading legacy token file %s: %w", legacyFile, err)
+	}
+
+	migrated := 0
+	for _, e := range sfe.Secrets {
+		supplier, credentialId, _ := strings.Cut(e.Id, "|")
+		path := tokenFilePath(supplier, credentialId, tokenDirectory)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		err = writeTokenFile(path, tokenFile{Supplier: supplier, CredentialId: credentialId, Tokens: e.Tokens})
 		if err != nil {
-			return sfe, err
+			return migrated, err
 		}
+		migrated++
+	}
+
+	return migrated, os.Remove(legacyFile)
+}
 
-		return sfe, nil
+func readTokenFile(path string) (tokenFile, error) {
+	var tf tokenFile
+
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return tf, err
 	}
+	err = json.Unmarshal(fileContent, &tf)
+
+	return tf, err
 }
 
-func writeSecretsFile(sfe secretFile, buchhalterConfigDirectory string) error {
-	sfj, err := json.MarshalIndent(sfe, "", "    ")
+func writeTokenFile(path string, tf tokenFile) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(filepath.Join(buchhalterConfigDirectory, secretsFilename), sfj, 0600)
+	tfj, err := json.MarshalIndent(tf, "", "    ")
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = os.WriteFile(path, tfj, 0600)
+	if err != nil {
+		return err
+	}
+	// WriteFile keeps the permissions of existing files
+	return os.Chmod(path, 0600)
 }
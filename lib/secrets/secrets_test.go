@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListAndDeleteOauth2Tokens(t *testing.T) {
+	directory := TokenDirectory(t.TempDir(), "")
+	for _, credential := range []struct{ supplier, credentialId string }{{"acme", "item-1"}, {"acme", "item-2"}, {"other", "item-3"}} {
+		err := SaveOauth2TokensToFile(credential.supplier, credential.credentialId, Oauth2Tokens{AccessToken: "access-" + credential.credentialId}, directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := DeleteOauth2Tokens("acme", directory)
+	if err != nil || removed != 2 {
+		t.Fatalf("expected 2 removed tokens, got %d (%v)", removed, err)
+	}
+
+	cachedTokens, err := ListOauth2Tokens(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cachedTokens) != 1 || cachedTokens[0].Supplier != "other" || cachedTokens[0].CredentialId != "item-3" || cachedTokens[0].Tokens.AccessToken != "access-item-3" {
+		t.Errorf("unexpected cached tokens %+v", cachedTokens)
+	}
+}
+
+func TestTokenFilesAreHashedAndPrivate(t *testing.T) {
+	directory := TokenDirectory(t.TempDir(), "work")
+	err := SaveOauth2TokensToFile("acme", "jane.doe@example.com", Oauth2Tokens{AccessToken: "access"}, directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one token file, got %v (%v)", entries, err)
+	}
+	if strings.Contains(entries[0].Name(), "acme") || strings.Contains(entries[0].Name(), "jane") {
+		t.Errorf("expected hashed filename, got %s", entries[0].Name())
+	}
+	info, err := entries[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected permissions 0600, got %o", info.Mode().Perm())
+	}
+}
+
+func TestMigrateLegacyTokens(t *testing.T) {
+	configDirectory := t.TempDir()
+	legacyFile := filepath.Join(configDirectory, legacySecretsFilename)
+	err := os.WriteFile(legacyFile, []byte(`{"secrets": [
+		{"id": "acme|item-1", "accessTokens": {"accessToken": "legacy-1", "refreshToken": "refresh-1", "expiresIn": 3600, "createdAt": 1700000000}},
+		{"id": "other|item-2", "accessTokens": {"accessToken": "legacy-2"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := TokenDirectory(configDirectory, "")
+	// Tokens of the new cache are newer than the legacy ones
+	err = SaveOauth2TokensToFile("other", "item-2", Oauth2Tokens{AccessToken: "new-2"}, directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := MigrateLegacyTokens(configDirectory, directory)
+	if err != nil || migrated != 1 {
+		t.Fatalf("expected 1 migrated token, got %d (%v)", migrated, err)
+	}
+	if _, err := os.Stat(legacyFile); !os.IsNotExist(err) {
+		t.Errorf("expected legacy file to be removed, got %v", err)
+	}
+
+	tokens, err := GetOauthAccessTokenFromCache("acme", "item-1", directory)
+	if err != nil || tokens.AccessToken != "legacy-1" || tokens.RefreshToken != "refresh-1" || tokens.CreatedAt != 1700000000 {
+		t.Errorf("unexpected migrated tokens %+v (%v)", tokens, err)
+	}
+	tokens, err = GetOauthAccessTokenFromCache("other", "item-2", directory)
+	if err != nil || tokens.AccessToken != "new-2" {
+		t.Errorf("expected newer tokens to be kept, got %+v (%v)", tokens, err)
+	}
+}
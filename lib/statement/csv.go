@@ -0,0 +1,264 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Columns of the canonical CSV schema: comma separated, UTF-8, ISO dates and amounts with a decimal point
+// (negative for debits).
+var CanonicalColumns = []string{"date", "value_date", "amount", "currency", "counterparty", "counterparty_iban", "purpose", "reference"}
+
+// NORMALIZED_SUFFIX is appended to the name of normalized statements, which are stored alongside the originals.
+const NORMALIZED_SUFFIX = ".normalized.csv"
+
+// Date formats tried if a CSV format has no date format
+var defaultDateFormats = []string{"2006-01-02", "02.01.2006", "02.01.06"}
+
+// CSVFormat describes the CSV statements of a supplier.
+type CSVFormat struct {
+	// Delimiter of fields, detected from the header row if empty
+	Delimiter string
+	// Encoding of the file: "utf-8" (default), "iso-8859-1" or "windows-1252"
+	Encoding string
+	// DecimalComma is true for amounts like 1.234,56
+	DecimalComma bool
+	// SkipRows is the number of rows before the header row (e.g. account details of bank exports)
+	SkipRows int
+	// DateFormat is the Go layout of dates (e.g. 02.01.2006)
+	DateFormat string
+	// Currency of all rows if the statement has no currency column
+	Currency string
+	// Columns maps canonical columns to the columns of the statement. Instead of amount, debits and credits may be
+	// mapped from separate columns (`debit` and `credit`).
+	Columns map[string]string
+}
+
+// NormalizedFileName returns the name of the normalized statement of file.
+func NormalizedFileName(file string) string {
+	return strings.TrimSuffix(file, ".csv") + NORMALIZED_SUFFIX
+}
+
+// NormalizeCSVFile writes the statement src in the canonical schema to dst.
+func NormalizeCSVFile(src, dst string, format CSVFormat) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	normalized, err := NormalizeCSV(data, format)
+	if err != nil {
+		return fmt.Errorf("error normalizing %s: %w", src, err)
+	}
+	return os.WriteFile(dst, normalized, 0600)
+}
+
+// NormalizeCSV converts a CSV statement into the canonical schema.
+func NormalizeCSV(data []byte, format CSVFormat) ([]byte, error) {
+	if format.Columns["date"] == "" || (format.Columns["amount"] == "" && format.Columns["debit"] == "" && format.Columns["credit"] == "") {
+		return nil, errors.New("the column mapping requires date and amount (or debit and credit)")
+	}
+
+	content, err := decode(data, format.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if format.SkipRows >= len(lines) {
+		return nil, errors.New("the statement has no header row")
+	}
+	content = strings.Join(lines[format.SkipRows:], "")
+
+	delimiter := format.Delimiter
+	if delimiter == "" {
+		delimiter = detectDelimiter(lines[format.SkipRows])
+	}
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.Comma, _ = utf8.DecodeRuneInString(delimiter)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the statement has no header row")
+	}
+
+	header := make(map[string]int)
+	for i, name := range records[0] {
+		header[strings.TrimSpace(name)] = i
+	}
+	columnIndex := make(map[string]int)
+	for canonical, column := range format.Columns {
+		i, ok := header[column]
+		if !ok {
+			return nil, fmt.Errorf("column %s (%s) not found in the header row", column, canonical)
+		}
+		columnIndex[canonical] = i
+	}
+
+	var b bytes.Buffer
+	writer := csv.NewWriter(&b)
+	_ = writer.Write(CanonicalColumns)
+	for n, record := range records[1:] {
+		if isEmpty(record) {
+			continue
+		}
+		value := func(canonical string) string {
+			i, ok := columnIndex[canonical]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := make([]string, 0, len(CanonicalColumns))
+		for _, canonical := range CanonicalColumns {
+			var v string
+			switch canonical {
+			case "date", "value_date":
+				v, err = normalizeDate(value(canonical), format.DateFormat)
+			case "amount":
+				v, err = normalizeAmount(value("amount"), value("debit"), value("credit"), format.DecimalComma)
+			case "currency":
+				v = value(canonical)
+				if v == "" {
+					v = format.Currency
+				}
+			default:
+				v = value(canonical)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", format.SkipRows+n+2, err)
+			}
+			row = append(row, v)
+		}
+		_ = writer.Write(row)
+	}
+	writer.Flush()
+
+	return b.Bytes(), writer.Error()
+}
+
+func decode(data []byte, encoding string) (string, error) {
+	switch strings.ToLower(encoding) {
+	case "", "utf-8", "utf8":
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+		if !utf8.Valid(data) {
+			return "", errors.New("the statement is not UTF-8 encoded, configure its encoding")
+		}
+		return string(data), nil
+	case "iso-8859-1", "latin1":
+		runes := make([]rune, len(data))
+		for i, c := range data {
+			runes[i] = rune(c)
+		}
+		return string(runes), nil
+	case "windows-1252", "cp1252":
+		runes := make([]rune, len(data))
+		for i, c := range data {
+			runes[i] = rune(c)
+			if r, ok := windows1252[c]; ok {
+				runes[i] = r
+			}
+		}
+		return string(runes), nil
+	}
+	return "", fmt.Errorf("unknown encoding %s", encoding)
+}
+
+// Characters of Windows-1252 that differ from ISO-8859-1
+var windows1252 = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ', 0x89: '‰', 0x8a: 'Š',
+	0x8b: '‹', 0x8c: 'Œ', 0x8e: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—',
+	0x98: '˜', 0x99: '™', 0x9a: 'š', 0x9b: '›', 0x9c: 'œ', 0x9e: 'ž', 0x9f: 'Ÿ',
+}
+
+func detectDelimiter(headerRow string) string {
+	delimiter := ","
+	count := strings.Count(headerRow, delimiter)
+	for _, candidate := range []string{";", "\t", "|"} {
+		if c := strings.Count(headerRow, candidate); c > count {
+			delimiter, count = candidate, c
+		}
+	}
+	return delimiter
+}
+
+func isEmpty(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeDate(value, layout string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	layouts := defaultDateFormats
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, l := range layouts {
+		if t, err := time.Parse(l, value); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %s", value)
+}
+
+// normalizeAmount returns the amount, or the credit minus the debit if the statement has separate columns.
+func normalizeAmount(amount, debit, credit string, decimalComma bool) (string, error) {
+	if amount != "" {
+		v, err := parseAmount(amount, decimalComma)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatFloat(v, 'f', 2, 64), nil
+	}
+
+	var total float64
+	for _, field := range []struct {
+		value string
+		sign  float64
+	}{{credit, 1}, {debit, -1}} {
+		if field.value == "" {
+			continue
+		}
+		v, err := parseAmount(field.value, decimalComma)
+		if err != nil {
+			return "", err
+		}
+		total += field.sign * math.Abs(v)
+	}
+	return strconv.FormatFloat(total, 'f', 2, 64), nil
+}
+
+func parseAmount(value string, decimalComma bool) (float64, error) {
+	v := strings.NewReplacer(" ", "", " ", "", "€", "", "EUR", "", "+", "").Replace(value)
+	// Some banks put the sign after the amount (e.g. 12,50-)
+	if strings.HasSuffix(v, "-") {
+		v = "-" + strings.TrimSuffix(v, "-")
+	}
+	if decimalComma {
+		v = strings.ReplaceAll(strings.ReplaceAll(v, ".", ""), ",", ".")
+	} else {
+		v = strings.ReplaceAll(v, ",", "")
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %s", value)
+	}
+	return f, nil
+}
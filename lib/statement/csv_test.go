@@ -0,0 +1,101 @@
+package statement
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCSVOfGermanBankExport(t *testing.T) {
+	// Windows-1252 with account details before the header row, decimal comma and the sign after the amount
+	export := "Konto;DE02120300000000202051\r\n" +
+		"\r\n" +
+		"Buchungstag;Valuta;Empf\xe4nger;IBAN;Verwendungszweck;Betrag\r\n" +
+		"30.09.2024;01.10.2024;M\xfcller GmbH;DE89370400440532013000;\"Rechnung 42; \x80 inkl.\";1.234,56-\r\n" +
+		"28.09.2024;28.09.2024;Kunde AG;;Gutschrift;99,90\r\n" +
+		";;;;;\r\n"
+
+	normalized, err := NormalizeCSV([]byte(export), CSVFormat{
+		Encoding:     "windows-1252",
+		DecimalComma: true,
+		SkipRows:     2,
+		DateFormat:   "02.01.2006",
+		Currency:     "EUR",
+		Columns: map[string]string{
+			"date":              "Buchungstag",
+			"value_date":        "Valuta",
+			"counterparty":      "Empfänger",
+			"counterparty_iban": "IBAN",
+			"purpose":           "Verwendungszweck",
+			"amount":            "Betrag",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "date,value_date,amount,currency,counterparty,counterparty_iban,purpose,reference\n" +
+		"2024-09-30,2024-10-01,-1234.56,EUR,Müller GmbH,DE89370400440532013000,Rechnung 42; € inkl.,\n" +
+		"2024-09-28,2024-09-28,99.90,EUR,Kunde AG,,Gutschrift,\n"
+	if string(normalized) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, normalized)
+	}
+}
+
+func TestNormalizeCSVWithDebitAndCreditColumns(t *testing.T) {
+	export := "\xef\xbb\xbfDate,Debit,Credit,Currency,Description\n" +
+		"2024-09-01,\"1,200.00\",,USD,Rent\n" +
+		"2024-09-02,,15.5,USD,Refund\n"
+
+	normalized, err := NormalizeCSV([]byte(export), CSVFormat{
+		Columns: map[string]string{"date": "Date", "debit": "Debit", "credit": "Credit", "currency": "Currency", "purpose": "Description"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(normalized)), "\n")
+	if len(lines) != 3 || lines[1] != "2024-09-01,,-1200.00,USD,,,Rent," || lines[2] != "2024-09-02,,15.50,USD,,,Refund," {
+		t.Errorf("unexpected normalized statement %q", lines)
+	}
+}
+
+func TestNormalizeCSVFailsOnInvalidStatements(t *testing.T) {
+	columns := map[string]string{"date": "Datum", "amount": "Betrag"}
+	for name, test := range map[string]struct {
+		export string
+		format CSVFormat
+	}{
+		"missing column":   {"Datum;Wert\n01.09.2024;1,00\n", CSVFormat{Columns: columns}},
+		"invalid amount":   {"Datum;Betrag\n01.09.2024;abc\n", CSVFormat{Columns: columns}},
+		"invalid date":     {"Datum;Betrag\n2024/09/01;1,00\n", CSVFormat{Columns: columns, DecimalComma: true}},
+		"invalid encoding": {"Datum;Betrag\n01.09.2024;1,00 \x80\n", CSVFormat{Columns: columns}},
+		"missing mapping":  {"Datum;Betrag\n", CSVFormat{Columns: map[string]string{"date": "Datum"}}},
+	} {
+		if _, err := NormalizeCSV([]byte(test.export), test.format); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestNormalizeCSVFileIsStoredAlongsideTheOriginal(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "umsaetze-2024-09.csv")
+	err := os.WriteFile(src, []byte("Datum;Betrag\n01.09.2024;1,00\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NormalizedFileName(src)
+	if dst != filepath.Join(dir, "umsaetze-2024-09.normalized.csv") {
+		t.Errorf("unexpected file name %s", dst)
+	}
+	err = NormalizeCSVFile(src, dst, CSVFormat{DecimalComma: true, Columns: map[string]string{"date": "Datum", "amount": "Betrag"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalized, _ := os.ReadFile(dst)
+	if !strings.HasSuffix(string(normalized), "\n2024-09-01,,1.00,,,,,\n") {
+		t.Errorf("unexpected normalized statement %q", normalized)
+	}
+}
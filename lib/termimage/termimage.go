@@ -0,0 +1,162 @@
+// Package termimage shows images in terminals supporting the kitty graphics protocol or sixel graphics.
+package termimage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+)
+
+const (
+	PROTOCOL_KITTY = "kitty"
+	PROTOCOL_SIXEL = "sixel"
+	PROTOCOL_NONE  = "none"
+
+	// kittyChunkSize is the maximum size of the base64 encoded payload of a kitty graphics escape sequence
+	kittyChunkSize = 4096
+)
+
+// DetectProtocol returns the graphics protocol of the terminal, based on its environment variables (getenv).
+// Terminals can't be detected reliably without querying them, unknown terminals and tmux get PROTOCOL_NONE.
+func DetectProtocol(getenv func(string) string) string {
+	term := getenv("TERM")
+	program := getenv("TERM_PROGRAM")
+	switch {
+	case term == "dumb" || getenv("TMUX") != "":
+		return PROTOCOL_NONE
+	case getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" || program == "ghostty" || program == "WezTerm":
+		return PROTOCOL_KITTY
+	case strings.Contains(term, "sixel") || strings.HasPrefix(term, "foot") || term == "mlterm" || program == "iTerm.app":
+		return PROTOCOL_SIXEL
+	}
+	return PROTOCOL_NONE
+}
+
+// Write writes img to w with the graphics protocol, followed by a newline.
+func Write(w io.Writer, protocol string, img image.Image) error {
+	switch protocol {
+	case PROTOCOL_KITTY:
+		return writeKitty(w, img)
+	case PROTOCOL_SIXEL:
+		return writeSixel(w, img)
+	}
+	return fmt.Errorf("unsupported graphics protocol %q (supported: %s, %s)", protocol, PROTOCOL_KITTY, PROTOCOL_SIXEL)
+}
+
+// writeKitty transmits img as PNG and displays it at the cursor (https://sw.kovidgoyal.net/kitty/graphics-protocol/).
+func writeKitty(w io.Writer, img image.Image) error {
+	var pngData bytes.Buffer
+	err := png.Encode(&pngData, img)
+	if err != nil {
+		return err
+	}
+
+	payload := base64.StdEncoding.EncodeToString(pngData.Bytes())
+	bw := bufio.NewWriter(w)
+	for offset := 0; offset < len(payload); offset += kittyChunkSize {
+		end := min(offset+kittyChunkSize, len(payload))
+		more := 0
+		if end < len(payload) {
+			more = 1
+		}
+		// Only the first chunk carries the control data (f=100: PNG, a=T: transmit and display)
+		if offset == 0 {
+			fmt.Fprintf(bw, "\x1b_Gf=100,a=T,m=%d;%s\x1b\\", more, payload[offset:end])
+		} else {
+			fmt.Fprintf(bw, "\x1b_Gm=%d;%s\x1b\\", more, payload[offset:end])
+		}
+	}
+	bw.WriteString("\n")
+	return bw.Flush()
+}
+
+// writeSixel writes img as sixel graphics with the 216 colors of the web safe palette.
+// Each sixel character encodes a column of 6 pixels of one color, bands of 6 rows are written color by color.
+func writeSixel(w io.Writer, img image.Image) error {
+	bounds := image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+	paletted := image.NewPaletted(bounds, palette.WebSafe)
+	draw.FloydSteinberg.Draw(paletted, bounds, img, img.Bounds().Min)
+
+	bw := bufio.NewWriter(w)
+	// Pixel aspect ratio 1:1 and the size of the image
+	fmt.Fprintf(bw, "\x1bPq\"1;1;%d;%d", bounds.Dx(), bounds.Dy())
+	for i, c := range paletted.Palette {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(bw, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+	}
+
+	for top := 0; top < bounds.Dy(); top += 6 {
+		var used [256]bool
+		for y := top; y < min(top+6, bounds.Dy()); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				used[paletted.ColorIndexAt(x, y)] = true
+			}
+		}
+
+		for color := range paletted.Palette {
+			if !used[color] {
+				continue
+			}
+			fmt.Fprintf(bw, "#%d", color)
+			run := 0
+			var last byte
+			for x := 0; x < bounds.Dx(); x++ {
+				var bits byte
+				for row := 0; row < 6 && top+row < bounds.Dy(); row++ {
+					if int(paletted.ColorIndexAt(x, top+row)) == color {
+						bits |= 1 << row
+					}
+				}
+				char := 63 + bits
+				if run > 0 && char != last {
+					writeSixelRun(bw, last, run)
+					run = 0
+				}
+				last = char
+				run++
+			}
+			writeSixelRun(bw, last, run)
+			// Carriage return to draw the next color over the same band
+			bw.WriteByte('$')
+		}
+		// Next band
+		bw.WriteByte('-')
+	}
+	bw.WriteString("\x1b\\\n")
+	return bw.Flush()
+}
+
+// writeSixelRun writes count repetitions of a sixel character, run-length encoded if shorter.
+func writeSixelRun(w *bufio.Writer, char byte, count int) {
+	if count > 3 {
+		fmt.Fprintf(w, "!%d%c", count, char)
+		return
+	}
+	for i := 0; i < count; i++ {
+		w.WriteByte(char)
+	}
+}
+
+// Fit scales img down to maxWidth pixels (nearest neighbor), smaller images are returned unchanged.
+func Fit(img image.Image, maxWidth int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= maxWidth {
+		return img
+	}
+
+	height := bounds.Dy() * maxWidth / bounds.Dx()
+	scaled := image.NewRGBA(image.Rect(0, 0, maxWidth, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < maxWidth; x++ {
+			scaled.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/maxWidth, bounds.Min.Y+y*bounds.Dy()/height))
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,132 @@
+package termimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDetectProtocol(t *testing.T) {
+	for env, expected := range map[string]string{
+		"TERM=xterm-kitty":                      PROTOCOL_KITTY,
+		"TERM=xterm-256color,KITTY_WINDOW_ID=1": PROTOCOL_KITTY,
+		"TERM_PROGRAM=WezTerm":                  PROTOCOL_KITTY,
+		"TERM=foot":                             PROTOCOL_SIXEL,
+		"TERM=xterm-sixel":                      PROTOCOL_SIXEL,
+		"TERM=xterm-256color":                   PROTOCOL_NONE,
+		"TERM=xterm-kitty,TMUX=/tmp/tmux":       PROTOCOL_NONE,
+		"":                                      PROTOCOL_NONE,
+	} {
+		variables := map[string]string{}
+		for _, assignment := range strings.Split(env, ",") {
+			if name, value, ok := strings.Cut(assignment, "="); ok {
+				variables[name] = value
+			}
+		}
+		protocol := DetectProtocol(func(name string) string { return variables[name] })
+		if protocol != expected {
+			t.Errorf("%s: expected %s, got %s", env, expected, protocol)
+		}
+	}
+}
+
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if x < width/2 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	return img
+}
+
+func TestWriteKitty(t *testing.T) {
+	// A noisy image, so the PNG needs several chunks
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	random := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		img.Pix[i] = byte(random.Intn(256))
+	}
+
+	var b bytes.Buffer
+	if err := Write(&b, PROTOCOL_KITTY, img); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload strings.Builder
+	chunks := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\x1b\\")
+	chunks = chunks[:len(chunks)-1]
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		control, data, _ := strings.Cut(strings.TrimPrefix(chunk, "\x1b_G"), ";")
+		expectedControl := "m=1"
+		if i == 0 {
+			expectedControl = "f=100,a=T,m=1"
+		}
+		if i == len(chunks)-1 {
+			expectedControl = "m=0"
+		}
+		if control != expectedControl {
+			t.Errorf("expected control data %s of chunk %d, got %s", expectedControl, i, control)
+		}
+		payload.WriteString(data)
+	}
+
+	pngData, err := base64.StdEncoding.DecodeString(payload.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Errorf("expected bounds %v, got %v", img.Bounds(), decoded.Bounds())
+	}
+}
+
+func TestWriteSixel(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, PROTOCOL_SIXEL, testImage(8, 7)); err != nil {
+		t.Fatal(err)
+	}
+	sixel := b.String()
+
+	if !strings.HasPrefix(sixel, "\x1bPq\"1;1;8;7") || !strings.HasSuffix(sixel, "\x1b\\\n") {
+		t.Fatalf("unexpected sixel sequence %q", sixel)
+	}
+	// Two bands, the first one with 6 rows (~ is 63+0b111111) in black and white
+	if !strings.Contains(sixel, "#0!4?!4~$#215!4~!4?$-") {
+		t.Errorf("expected a full band in white and black, got %q", sixel)
+	}
+	// The second band only has 1 row (@ is 63+0b000001)
+	if !strings.Contains(sixel, "#0!4?!4@$#215!4@!4?$-") {
+		t.Errorf("expected a band of one row, got %q", sixel)
+	}
+}
+
+func TestWriteUnsupportedProtocol(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, PROTOCOL_NONE, testImage(1, 1)); err == nil {
+		t.Error("expected an error for an unsupported protocol")
+	}
+}
+
+func TestFit(t *testing.T) {
+	if fitted := Fit(testImage(100, 50), 200); fitted.Bounds().Dx() != 100 {
+		t.Errorf("expected small images to be unchanged, got %v", fitted.Bounds())
+	}
+	if fitted := Fit(testImage(400, 200), 100); fitted.Bounds() != image.Rect(0, 0, 100, 50) {
+		t.Errorf("expected a size of 100x50, got %v", fitted.Bounds())
+	}
+}
@@ -0,0 +1,268 @@
+package timestamp
+
+// Client for RFC 3161 time stamp authorities (TSA). The TSA signs the hash of a document together with the current
+// time, which proves that the document existed unchanged at that time. The tokens are stored next to the documents
+// and can be verified with e.g. `openssl ts -verify -data invoice.pdf -in invoice.pdf.tsr -CAfile tsa.pem`.
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/httpclient"
+)
+
+// TOKEN_EXTENSION is appended to the document path for the file of its timestamp token (invoice.pdf.tsr).
+// The document archive doesn't index these files.
+const TOKEN_EXTENSION = ".tsr"
+
+var (
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+)
+
+// PKIStatus values of granted requests
+const (
+	statusGranted         = 0
+	statusGrantedWithMods = 1
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is explicitly tagged with [0]
+	Content asn1.RawValue
+}
+
+// signedData contains the fields of the CMS SignedData up to the signed content, the certificates and signatures follow
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo encapsulatedContentInfo
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+// Token is a timestamp token of a TSA.
+type Token struct {
+	// Response is the DER encoded TimeStampResp, as stored in the token file
+	Response     []byte
+	GenTime      time.Time
+	SerialNumber *big.Int
+}
+
+type Client struct {
+	logger     *slog.Logger
+	httpClient *httpclient.Client
+	url        string
+}
+
+// NewClient creates a client for the TSA at tsaURL (e.g. https://freetsa.org/tsr).
+func NewClient(logger *slog.Logger, httpClient *httpclient.Client, tsaURL string) (*Client, error) {
+	parsedURL, err := url.Parse(tsaURL)
+	if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
+		return nil, fmt.Errorf("invalid time stamp authority URL %s", tsaURL)
+	}
+
+	return &Client{
+		logger:     logger,
+		httpClient: httpClient,
+		url:        tsaURL,
+	}, nil
+}
+
+// TokenFileName returns the path of the timestamp token of the document at filePath.
+func TokenFileName(filePath string) string {
+	return filePath + TOKEN_EXTENSION
+}
+
+// Timestamp requests a timestamp token for a SHA-256 hash. The token is checked to contain the hash and the nonce of the
+// request, the signature of the TSA is not verified.
+func (c *Client) Timestamp(ctx context.Context, hash []byte) (*Token, error) {
+	if len(hash) != 32 {
+		return nil, errors.New("invalid SHA-256 hash")
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	imprint := messageImprint{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+		HashedMessage: hash,
+	}
+	body, err := asn1.Marshal(timeStampReq{
+		Version:        1,
+		MessageImprint: imprint,
+		Nonce:          nonce,
+		// The token contains the certificate of the TSA, so it can be verified without fetching it
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+	req.Header.Set("Accept", "application/timestamp-reply")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, httpclient.StatusError(resp, "")
+	}
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := parseResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || !bytes.Equal(info.MessageImprint.HashedMessage, hash) {
+		return nil, errors.New("the timestamp token doesn't match the document hash")
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("the timestamp token doesn't match the nonce of the request")
+	}
+
+	return &Token{
+		Response:     response,
+		GenTime:      info.GenTime,
+		SerialNumber: info.SerialNumber,
+	}, nil
+}
+
+// parseResponse checks the status of a TimeStampResp and returns the TSTInfo of its token.
+func parseResponse(response []byte) (*tstInfo, error) {
+	var resp timeStampResp
+	_, err := asn1.Unmarshal(response, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp response: %w", err)
+	}
+	if resp.Status.Status != statusGranted && resp.Status.Status != statusGrantedWithMods {
+		return nil, fmt.Errorf("the time stamp authority rejected the request (status %d): %s", resp.Status.Status, strings.Join(resp.Status.StatusString, ", "))
+	}
+
+	var token contentInfo
+	_, err = asn1.Unmarshal(resp.TimeStampToken.FullBytes, &token)
+	if err != nil || !token.ContentType.Equal(oidSignedData) || token.Content.Class != asn1.ClassContextSpecific || token.Content.Tag != 0 {
+		return nil, errors.New("invalid timestamp token")
+	}
+	var signed signedData
+	_, err = asn1.Unmarshal(token.Content.Bytes, &signed)
+	if err != nil || !signed.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, errors.New("invalid timestamp token")
+	}
+	var info tstInfo
+	_, err = asn1.Unmarshal(signed.EncapContentInfo.EContent, &info)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp token: %w", err)
+	}
+
+	return &info, nil
+}
+
+// TimestampAll stores timestamp tokens of all documents of fileIndex that don't have one yet, oldest first.
+// Rejected and staged documents are skipped. Timestamping stops at the first error, the remaining documents are
+// timestamped on the next run. Returns the number of timestamped documents.
+func (c *Client) TimestampAll(ctx context.Context, fileIndex map[string]archive.File) (int, error) {
+	checksums := make([]string, 0, len(fileIndex))
+	for checksum, f := range fileIndex {
+		if f.Rejected || f.Staged {
+			continue
+		}
+		if _, err := os.Stat(TokenFileName(f.Path)); err == nil {
+			continue
+		}
+		checksums = append(checksums, checksum)
+	}
+	sort.Slice(checksums, func(i, j int) bool {
+		return fileIndex[checksums[i]].AddedAt.Before(fileIndex[checksums[j]].AddedAt)
+	})
+
+	timestamped := 0
+	for _, checksum := range checksums {
+		f := fileIndex[checksum]
+		// The checksums of the archive index are SHA-256 hashes of the documents
+		hash, err := hex.DecodeString(checksum)
+		if err != nil {
+			return timestamped, fmt.Errorf("invalid checksum of %s: %w", f.Path, err)
+		}
+		token, err := c.Timestamp(ctx, hash)
+		if err != nil {
+			return timestamped, fmt.Errorf("error timestamping %s: %w", f.Path, err)
+		}
+		err = os.WriteFile(TokenFileName(f.Path), token.Response, 0600)
+		if err != nil {
+			return timestamped, err
+		}
+		c.logger.Info("Timestamped document", "file", f.Path, "supplier", f.Supplier, "time", token.GenTime, "serial_number", token.SerialNumber)
+		timestamped++
+	}
+
+	return timestamped, nil
+}
@@ -0,0 +1,155 @@
+package timestamp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/httpclient"
+)
+
+var genTime = time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeTSA answers timestamp requests with unsigned tokens. status is the PKIStatus of the responses.
+func fakeTSA(t *testing.T, status int, tamper func(*tstInfo)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/timestamp-query" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req timeStampReq
+		if _, err := asn1.Unmarshal(body, &req); err != nil {
+			t.Errorf("invalid request: %s", err)
+		}
+
+		resp := timeStampResp{Status: pkiStatusInfo{Status: status}}
+		if status > statusGrantedWithMods {
+			resp.Status.StatusString = []string{"unsupported algorithm"}
+		} else {
+			info := tstInfo{
+				Version:        1,
+				Policy:         asn1.ObjectIdentifier{1, 2, 3, 4},
+				MessageImprint: req.MessageImprint,
+				SerialNumber:   big.NewInt(42),
+				GenTime:        genTime,
+				Accuracy:       accuracy{Seconds: 1},
+				Nonce:          req.Nonce,
+			}
+			if tamper != nil {
+				tamper(&info)
+			}
+			resp.TimeStampToken = asn1.RawValue{FullBytes: marshal(t, contentInfo{
+				ContentType: oidSignedData,
+				Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: marshal(t, signedData{
+					Version:          3,
+					DigestAlgorithms: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+					EncapContentInfo: encapsulatedContentInfo{EContentType: oidTSTInfo, EContent: marshal(t, info)},
+				})},
+			})}
+		}
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		_, _ = w.Write(marshal(t, resp))
+	}))
+}
+
+func marshal(t *testing.T, value any) []byte {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func newTestClient(t *testing.T, url string) *Client {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := NewClient(logger, httpclient.New(logger, 5*time.Second, 0), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestTimestamp(t *testing.T) {
+	server := fakeTSA(t, statusGranted, nil)
+	defer server.Close()
+
+	hash := sha256.Sum256([]byte("%PDF-1.4"))
+	token, err := newTestClient(t, server.URL).Timestamp(context.Background(), hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.GenTime.Equal(genTime) || token.SerialNumber.Int64() != 42 {
+		t.Errorf("unexpected token %v %v", token.GenTime, token.SerialNumber)
+	}
+	if _, err = parseResponse(token.Response); err != nil {
+		t.Errorf("expected the stored response to be parseable: %s", err)
+	}
+}
+
+func TestTimestampFailsOnInvalidTokens(t *testing.T) {
+	hash := sha256.Sum256([]byte("%PDF-1.4"))
+	for name, test := range map[string]struct {
+		status int
+		tamper func(*tstInfo)
+		error  string
+	}{
+		"rejected":   {2, nil, "rejected the request (status 2): unsupported algorithm"},
+		"other hash": {statusGranted, func(info *tstInfo) { info.MessageImprint.HashedMessage = make([]byte, 32) }, "doesn't match the document hash"},
+		"replayed":   {statusGranted, func(info *tstInfo) { info.Nonce = big.NewInt(1) }, "doesn't match the nonce"},
+	} {
+		server := fakeTSA(t, test.status, test.tamper)
+		_, err := newTestClient(t, server.URL).Timestamp(context.Background(), hash[:])
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected error %q, got %v", name, test.error, err)
+		}
+	}
+}
+
+func TestTimestampAllStoresTokensNextToTheDocuments(t *testing.T) {
+	server := fakeTSA(t, statusGranted, nil)
+	defer server.Close()
+
+	dir := t.TempDir()
+	fileIndex := map[string]archive.File{}
+	for _, name := range []string{"invoice.pdf", "rejected.pdf", "timestamped.pdf"} {
+		path := filepath.Join(dir, name)
+		_ = os.WriteFile(path, []byte(name), 0600)
+		hash := sha256.Sum256([]byte(name))
+		fileIndex[hex.EncodeToString(hash[:])] = archive.File{Path: path, Supplier: "acme", Rejected: name == "rejected.pdf"}
+	}
+	_ = os.WriteFile(TokenFileName(filepath.Join(dir, "timestamped.pdf")), []byte("token"), 0600)
+
+	timestamped, err := newTestClient(t, server.URL).TimestampAll(context.Background(), fileIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timestamped != 1 {
+		t.Errorf("expected one timestamped document, got %d", timestamped)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "invoice.pdf.tsr")); err != nil {
+		t.Errorf("expected the token file: %s", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "rejected.pdf.tsr")); err == nil {
+		t.Error("expected no token of the rejected document")
+	}
+}
+
+func TestNewClientValidatesTheURL(t *testing.T) {
+	if _, err := NewClient(nil, nil, "freetsa.org"); err == nil {
+		t.Error("expected an error for a URL without scheme")
+	}
+}
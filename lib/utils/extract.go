@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DEFAULT_MAX_ARCHIVE_DEPTH is the number of nested archives (archives in archives) extracted by default.
+const DEFAULT_MAX_ARCHIVE_DEPTH = 3
+
+var ErrArchivePassword = errors.New("the archive is encrypted, the password is missing or wrong")
+
+// ExtractOptions configure the extraction of downloaded archives.
+type ExtractOptions struct {
+	// Filter is a glob of the names of the extracted files (e.g. *.pdf), all files are extracted if empty
+	Filter string
+	// Password of encrypted archives
+	Password string
+	// MaxDepth of nested archives, DEFAULT_MAX_ARCHIVE_DEPTH if 0
+	MaxDepth int
+}
+
+// IsArchive returns true for the file types ExtractArchive supports.
+func IsArchive(name string) bool {
+	return archiveType(name) != ""
+}
+
+func archiveType(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".7z"):
+		return "7z"
+	}
+	return ""
+}
+
+// ExtractArchive extracts the files of a zip, tar, tar.gz or 7z archive into dest and returns their paths.
+// Directories are flattened and files with the name of an existing file get a numeric suffix. Nested archives are
+// extracted as well. 7z archives and AES encrypted zip files require the 7z command.
+func ExtractArchive(source, dest string, options ExtractOptions) ([]string, error) {
+	if options.MaxDepth <= 0 {
+		options.MaxDepth = DEFAULT_MAX_ARCHIVE_DEPTH
+	}
+	if options.Filter != "" {
+		if _, err := filepath.Match(options.Filter, ""); err != nil {
+			return nil, fmt.Errorf("invalid filter %s: %w", options.Filter, err)
+		}
+	}
+	err := CreateDirectoryIfNotExists(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &extractor{dest: dest, options: options}
+	err = e.extract(source, 1)
+	return e.files, err
+}
+
+type extractor struct {
+	dest    string
+	options ExtractOptions
+	files   []string
+}
+
+func (e *extractor) extract(source string, depth int) error {
+	switch archiveType(source) {
+	case "zip":
+		return e.extractZip(source, depth)
+	case "tar", "tar.gz":
+		return e.extractTar(source, depth)
+	case "7z":
+		return e.extract7z(source, depth)
+	}
+	return fmt.Errorf("unsupported archive %s", filepath.Base(source))
+}
+
+// add writes a file of an archive, or extracts it if it is a nested archive.
+func (e *extractor) add(name string, r io.Reader, depth int) error {
+	// Sanitize the filename to prevent path traversal
+	name = filepath.Base(name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return nil
+	}
+
+	if IsArchive(name) && depth < e.options.MaxDepth {
+		nested, err := os.CreateTemp(e.dest, ".nested-*-"+name)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(nested.Name())
+		_, err = io.Copy(nested, r)
+		nested.Close()
+		if err != nil {
+			return err
+		}
+		return e.extract(nested.Name(), depth+1)
+	}
+
+	if e.options.Filter != "" {
+		if match, _ := filepath.Match(e.options.Filter, name); !match {
+			return nil
+		}
+	}
+	target := UniqueFileName(filepath.Join(e.dest, name))
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	e.files = append(e.files, target)
+
+	return nil
+}
+
+func (e *extractor) extractZip(source string, depth int) error {
+	reader, err := zip.OpenReader(source)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// Method 99 is AES encryption (WinZip), which the standard library doesn't support
+	for _, file := range reader.File {
+		if file.Method == 99 {
+			return e.extract7z(source, depth)
+		}
+	}
+
+	for _, file := range reader.File {
+		if file.Mode().IsDir() {
+			continue
+		}
+		var rc io.ReadCloser
+		switch {
+		case file.Flags&0x1 != 0:
+			rc, err = openZipCrypto(file, e.options.Password)
+		default:
+			rc, err = file.Open()
+		}
+		if err != nil {
+			return err
+		}
+		err = e.add(file.Name, rc, depth)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *extractor) extractTar(source string, depth int) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if archiveType(source) == "tar.gz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = e.add(header.Name, reader, depth)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extract7z extracts the archive with the 7z command into a temporary directory and adds its files.
+func (e *extractor) extract7z(source string, depth int) error {
+	binary := ""
+	for _, candidate := range []string{"7z", "7zz", "7za"} {
+		if path, err := exec.LookPath(candidate); err == nil {
+			binary = path
+			break
+		}
+	}
+	if binary == "" {
+		return fmt.Errorf("extracting %s requires the 7z command (e.g. p7zip)", filepath.Base(source))
+	}
+
+	tmp, err := os.MkdirTemp(e.dest, ".7z-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	cmdArgs := []string{"x", "-y", "-o" + tmp}
+	// 7z only reads the password from the arguments. Without a password, it fails on encrypted archives, as stdin is empty.
+	if e.options.Password != "" {
+		cmdArgs = append(cmdArgs, "-p"+e.options.Password)
+	}
+	// #nosec G204
+	output, err := exec.Command(binary, append(cmdArgs, source)...).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "Wrong password") {
+			return ErrArchivePassword
+		}
+		return fmt.Errorf("error extracting %s: %s", filepath.Base(source), strings.TrimSpace(string(output)))
+	}
+
+	return filepath.WalkDir(tmp, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return e.add(d.Name(), f, depth)
+	})
+}
+
+// openZipCrypto decrypts a file encrypted with the traditional PKWARE encryption (ZipCrypto).
+func openZipCrypto(file *zip.File, password string) (io.ReadCloser, error) {
+	if password == "" {
+		return nil, ErrArchivePassword
+	}
+	raw, err := file.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := io.ReadAll(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) < 12 {
+		return nil, errors.New("invalid encrypted zip file " + file.Name)
+	}
+
+	keys := [3]uint32{0x12345678, 0x23456789, 0x34567890}
+	update := func(c byte) {
+		keys[0] = crc32Update(keys[0], c)
+		keys[1] = (keys[1]+(keys[0]&0xff))*134775813 + 1
+		keys[2] = crc32Update(keys[2], byte(keys[1]>>24))
+	}
+	for i := 0; i < len(password); i++ {
+		update(password[i])
+	}
+	decrypted := make([]byte, len(encrypted))
+	for i, c := range encrypted {
+		temp := uint16(keys[2] | 2)
+		decrypted[i] = c ^ byte((temp*(temp^1))>>8)
+		update(decrypted[i])
+	}
+
+	// The last byte of the encryption header is the high byte of the CRC (or of the modification time with a data descriptor)
+	check := byte(file.CRC32 >> 24)
+	if file.Flags&0x8 != 0 {
+		check = byte(file.ModifiedTime >> 8)
+	}
+	if decrypted[11] != check {
+		return nil, ErrArchivePassword
+	}
+
+	var content []byte
+	switch file.Method {
+	case zip.Store:
+		content = decrypted[12:]
+	case zip.Deflate:
+		content, err = io.ReadAll(flate.NewReader(bytes.NewReader(decrypted[12:])))
+		if err != nil {
+			return nil, ErrArchivePassword
+		}
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+	if crc32.ChecksumIEEE(content) != file.CRC32 {
+		return nil, ErrArchivePassword
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func crc32Update(crc uint32, c byte) uint32 {
+	return crc32.IEEETable[byte(crc)^c] ^ (crc >> 8)
+}
+
+// UniqueFileName returns name, or name with a numeric suffix (e.g. invoice-2.pdf) if a file with the name exists.
+func UniqueFileName(name string) string {
+	extension := filepath.Ext(name)
+	if strings.HasSuffix(strings.ToLower(name), ".tar.gz") {
+		extension = name[len(name)-len(".tar.gz"):]
+	}
+	unique := name
+	for i := 2; ; i++ {
+		if _, err := os.Stat(unique); errors.Is(err, fs.ErrNotExist) {
+			return unique
+		}
+		unique = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, extension), i, extension)
+	}
+}
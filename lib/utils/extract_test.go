@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func zipArchive(t *testing.T, files map[string][]byte) []byte {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// zipCryptoArchive creates a zip file with one stored file encrypted with the traditional PKWARE encryption.
+func zipCryptoArchive(t *testing.T, name string, content []byte, password string) []byte {
+	keys := [3]uint32{0x12345678, 0x23456789, 0x34567890}
+	update := func(c byte) {
+		keys[0] = crc32Update(keys[0], c)
+		keys[1] = (keys[1]+(keys[0]&0xff))*134775813 + 1
+		keys[2] = crc32Update(keys[2], byte(keys[1]>>24))
+	}
+	for i := 0; i < len(password); i++ {
+		update(password[i])
+	}
+	checksum := crc32.ChecksumIEEE(content)
+	plain := append([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, byte(checksum >> 24)}, content...)
+	encrypted := make([]byte, len(plain))
+	for i, c := range plain {
+		temp := uint16(keys[2] | 2)
+		encrypted[i] = c ^ byte((temp*(temp^1))>>8)
+		update(c)
+	}
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	f, err := w.CreateRaw(&zip.FileHeader{Name: name, Method: zip.Store, Flags: 0x1, CRC32: checksum, CompressedSize64: uint64(len(encrypted)), UncompressedSize64: uint64(len(content))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write(encrypted)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func extractedNames(files []string) []string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = filepath.Base(f)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestExtractArchiveWithNestedArchivesAndFilter(t *testing.T) {
+	nested := zipArchive(t, map[string][]byte{"2024/invoice.pdf": []byte("nested invoice"), "terms.txt": []byte("terms")})
+
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	for name, content := range map[string][]byte{"invoice.pdf": []byte("invoice"), "../../escape.pdf": []byte("escape"), "documents.zip": nested} {
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write(content)
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "download.tar.gz")
+	if err := os.WriteFile(source, tgz.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ExtractArchive(source, dir, ExtractOptions{Filter: "*.pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := extractedNames(files)
+	if len(names) != 3 || names[0] != "escape.pdf" || names[1] != "invoice-2.pdf" || names[2] != "invoice.pdf" {
+		t.Errorf("expected the PDF files of both archives with unique names, got %v", names)
+	}
+	for _, f := range files {
+		if filepath.Dir(f) != dir {
+			t.Errorf("expected %s to be extracted into %s", f, dir)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 4 {
+		t.Errorf("expected the archive and the extracted files only (no nested archives), got %d files", len(entries))
+	}
+}
+
+func TestExtractArchiveStopsAtMaxDepth(t *testing.T) {
+	nested := zipArchive(t, map[string][]byte{"invoice.pdf": []byte("invoice")})
+	dir := t.TempDir()
+	source := filepath.Join(dir, "download.zip")
+	if err := os.WriteFile(source, zipArchive(t, map[string][]byte{"nested.zip": nested}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ExtractArchive(source, filepath.Join(dir, "out"), ExtractOptions{MaxDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := extractedNames(files); len(names) != 1 || names[0] != "nested.zip" {
+		t.Errorf("expected the nested archive to be kept, got %v", names)
+	}
+}
+
+func TestExtractArchiveWithPassword(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "payslips.zip")
+	if err := os.WriteFile(source, zipCryptoArchive(t, "payslip.pdf", []byte("%PDF-1.4 payslip"), "secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{"", "wrong"} {
+		_, err := ExtractArchive(source, filepath.Join(dir, "out-"+password), ExtractOptions{Password: password})
+		if !errors.Is(err, ErrArchivePassword) {
+			t.Errorf("expected the password error with password %q, got %v", password, err)
+		}
+	}
+
+	files, err := ExtractArchive(source, filepath.Join(dir, "out"), ExtractOptions{Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one file, got %v", files)
+	}
+	content, _ := os.ReadFile(files[0])
+	if string(content) != "%PDF-1.4 payslip" {
+		t.Errorf("unexpected decrypted content %q", content)
+	}
+}
+
+func TestUniqueFileName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"invoice.pdf", "invoice-2.pdf", "backup.tar.gz"} {
+		_ = os.WriteFile(filepath.Join(dir, name), nil, 0600)
+	}
+
+	for name, expected := range map[string]string{"invoice.pdf": "invoice-3.pdf", "backup.tar.gz": "backup-2.tar.gz", "new.pdf": "new.pdf"} {
+		if got := UniqueFileName(filepath.Join(dir, name)); got != filepath.Join(dir, expected) {
+			t.Errorf("expected %s, got %s", expected, filepath.Base(got))
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"archive/zip"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
@@ -11,9 +10,11 @@ import (
 	"io/fs"
 	"math/big"
 	"os"
-	"path"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 )
 
 const (
@@ -21,9 +22,12 @@ const (
 )
 
 // ViewMsgProgressUpdate updates the progress bar in the bubbletea application.
-// "Percent" represents the percentage of the progress bar.
+// "Percent" represents the percentage of the progress bar, "Step" the number of completed steps of the current recipe
+// and "NewFilesCount" the number of new documents of the current supplier so far.
 type ViewMsgProgressUpdate struct {
-	Percent float64
+	Percent       float64
+	Step          int
+	NewFilesCount int
 }
 
 // ViewMsgStatusAndDescriptionUpdate updates the status and description in the bubbletea application.
@@ -41,19 +45,64 @@ type RecipeResult struct {
 	LastStepDescription string
 	LastErrorMessage    string
 	NewFilesCount       int
+	StepTimings         []StepTiming
+	// Warnings are the failures of optional steps
+	Warnings []string
+}
+
+// StepTiming is the duration of a single executed recipe step.
+type StepTiming struct {
+	Number      int
+	Action      string
+	Description string
+	Status      string
+	Duration    time.Duration
+	Artifacts   StepArtifacts
 }
 
 // StepResult represents the result of a single step execution.
 type StepResult struct {
-	Status  string
-	Message string
-	Break   bool
+	Status    string
+	Message   string
+	Break     bool
+	Artifacts StepArtifacts
+}
+
+// StepArtifacts are the structured results of a recipe step, e.g. for the run results of `serve` and the platform sync.
+type StepArtifacts struct {
+	// Files are the files downloaded by the step or, for steps archiving documents, the new documents
+	Files []string `json:"files,omitempty"`
+	// Variables are the recipe variables set by the step
+	Variables map[string]string `json:"variables,omitempty"`
+	// HTTPStatusCodes are the status codes of the pages and documents loaded by the step
+	HTTPStatusCodes []int `json:"httpStatusCodes,omitempty"`
+	// Screenshots are the screenshots taken of the step, e.g. the debug artifacts of a failed step
+	Screenshots []string `json:"screenshots,omitempty"`
+	// Documents are the documents offered by the supplier, listed instead of downloaded in list-only mode
+	Documents []DiscoveredDocument `json:"documents,omitempty"`
 }
 
-func InitSupplierDirectories(buchhalterDirectory, supplier string) (string, string, error) {
-	downloadsDirectory := filepath.Join(buchhalterDirectory, "_tmp", supplier)
-	documentsDirectory := filepath.Join(buchhalterDirectory, supplier)
-	err := CreateDirectoryIfNotExists(downloadsDirectory)
+// DiscoveredDocument is a document offered by a supplier that is listed instead of downloaded (see `sync --list-only`).
+type DiscoveredDocument struct {
+	// ID of the document at the supplier, if the recipe extracts it
+	ID  string `json:"id,omitempty"`
+	URL string `json:"url,omitempty"`
+	// Date of the document as offered by the supplier, if the recipe extracts it
+	Date string `json:"date,omitempty"`
+	// Archived is true if the document was downloaded before, i.e. the archive has a source with its id or url
+	Archived bool `json:"archived,omitempty"`
+}
+
+// InitSupplierDirectories creates a unique temporary downloads directory of supplier and the given documents directory.
+// Every run gets its own downloads directory, so files of previous or parallel runs can't be mixed up.
+// The caller is responsible to remove the downloads directory (see RemoveTemporaryDirectory).
+func InitSupplierDirectories(buchhalterDirectory, documentsDirectory, supplier string) (string, string, error) {
+	tmpDirectory := filepath.Join(buchhalterDirectory, "_tmp")
+	err := CreateDirectoryIfNotExists(tmpDirectory)
+	if err != nil {
+		return "", "", err
+	}
+	downloadsDirectory, err := os.MkdirTemp(tmpDirectory, supplier+"-")
 	if err != nil {
 		return "", "", err
 	}
@@ -75,8 +124,65 @@ func CreateDirectoryIfNotExists(path string) error {
 	return nil
 }
 
-func TruncateDirectory(path string) error {
-	return os.RemoveAll(path)
+// RemoveTemporaryDirectory removes a temporary directory.
+// If shred is set, all files are overwritten with zeros before, because they may contain sensitive documents.
+// Files that can't be shredded are removed nevertheless, the errors are returned.
+func RemoveTemporaryDirectory(path string, shred bool) error {
+	if path == "" {
+		return nil
+	}
+
+	var errs []error
+	if shred {
+		err := filepath.WalkDir(path, func(s string, d fs.DirEntry, e error) error {
+			if e != nil {
+				if !errors.Is(e, os.ErrNotExist) {
+					errs = append(errs, e)
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			if err := shredFile(s); err != nil {
+				errs = append(errs, err)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, os.RemoveAll(path))
+	return errors.Join(errs...)
+}
+
+// shredFile overwrites the content of a file with zeros.
+// On SSDs and copy-on-write file systems, the original blocks may still be recoverable.
+func shredFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(f, zeroReader{}, info.Size())
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
 }
 
 func FindFiles(root, ext string) ([]string, error) {
@@ -124,43 +230,6 @@ func CopyFile(src, dst string) (int64, error) {
 	return nBytes, err
 }
 
-func UnzipFile(source, dest string) error {
-	read, err := zip.OpenReader(source)
-	if err != nil {
-		return err
-	}
-	defer read.Close()
-
-	for _, file := range read.File {
-		if file.Mode().IsDir() {
-			continue
-		}
-		open, err := file.Open()
-		if err != nil {
-			return err
-		}
-		// Sanitize the filename to prevent path traversal
-		name := filepath.Join(dest, filepath.Base(file.Name))
-		err = CreateDirectoryIfNotExists(path.Dir(name))
-		if err != nil {
-			return err
-		}
-
-		create, err := os.Create(name)
-		if err != nil {
-			return err
-		}
-		defer create.Close()
-
-		_, err = create.ReadFrom(open)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
 func RandomString(length int) string {
 	if length == 0 {
 		return ""
@@ -196,3 +265,34 @@ func encode(msg []byte) string {
 func WriteStringToFile(filePath, content string) error {
 	return os.WriteFile(filePath, []byte(content), 0644)
 }
+
+// ReplacePlaceholders replaces all `{{ name }}` placeholders in value with the matching entry of variables.
+// Unknown placeholders are kept as they are.
+func ReplacePlaceholders(value string, variables map[string]string) string {
+	for name, v := range variables {
+		value = strings.Replace(value, "{{ "+name+" }}", v, -1)
+	}
+	return value
+}
+
+// OpenFile opens a file with the default application of the operating system.
+// File names come from suppliers, so they are never passed through a shell (e.g. cmd.exe interprets `&`).
+func OpenFile(filePath string) error {
+	// An absolute path can't be mistaken for an option of the opener
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", filePath)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", filePath)
+	default:
+		cmd = exec.Command("xdg-open", filePath)
+	}
+
+	return cmd.Start()
+}
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -34,3 +38,105 @@ func TestRandomString(t *testing.T) {
 		}
 	}
 }
+
+func TestReplacePlaceholders(t *testing.T) {
+	variables := map[string]string{
+		"accountId": "12345",
+		"token":     "abc",
+	}
+
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"", ""},
+		{"https://example.com/invoices", "https://example.com/invoices"},
+		{"https://example.com/{{ accountId }}/invoices", "https://example.com/12345/invoices"},
+		{"{{ accountId }}-{{ token }}-{{ accountId }}", "12345-abc-12345"},
+		{"{{ unknown }}", "{{ unknown }}"},
+	}
+
+	for _, test := range tests {
+		result := ReplacePlaceholders(test.value, variables)
+		if result != test.expected {
+			t.Errorf("ReplacePlaceholders(%s) = %s; want %s", test.value, result, test.expected)
+		}
+	}
+}
+
+func TestInitSupplierDirectoriesCreatesUniqueDownloadsDirectories(t *testing.T) {
+	buchhalterDirectory := t.TempDir()
+	documentsDirectory := filepath.Join(buchhalterDirectory, "documents", "hetzner")
+
+	first, _, err := InitSupplierDirectories(buchhalterDirectory, documentsDirectory, "hetzner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := InitSupplierDirectories(buchhalterDirectory, documentsDirectory, "hetzner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("expected unique downloads directories, got %s twice", first)
+	}
+	if !strings.HasPrefix(filepath.Base(first), "hetzner-") {
+		t.Errorf("expected downloads directory to be named after the supplier, got %s", first)
+	}
+}
+
+func TestRemoveTemporaryDirectoryShredsFiles(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "downloads")
+	err := os.MkdirAll(filepath.Join(directory, "nested"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(directory, "nested", "invoice.pdf")
+	err = os.WriteFile(file, []byte("sensitive"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Shredding keeps the file size and overwrites the content
+	err = shredFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != strings.Repeat("\x00", len("sensitive")) {
+		t.Errorf("expected file to be overwritten with zeros, got %q", content)
+	}
+
+	err = RemoveTemporaryDirectory(directory, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(directory); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", directory)
+	}
+}
+
+func TestRemoveTemporaryDirectoryRemovesFilesThatCantBeShredded(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write read-only files")
+	}
+	directory := filepath.Join(t.TempDir(), "downloads")
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(directory, "invoice.pdf"), []byte("sensitive"), 0400)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RemoveTemporaryDirectory(directory, true)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("expected the shred error, got %v", err)
+	}
+	if _, err := os.Stat(directory); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", directory)
+	}
+}
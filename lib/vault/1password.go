@@ -1,10 +1,18 @@
 package vault
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
+
+	"buchhalter/lib/i18n"
+	"buchhalter/lib/redact"
 )
 
 const (
@@ -23,6 +31,12 @@ type Provider1Password struct {
 
 	// TODO Check if this is needed
 	UrlsByItemId map[string][]string
+
+	// session is the token of `op signin`, it is reused by all commands instead of unlocking the vault for each of them
+	session string
+	// credentials are cached in memory only, from PrefetchCredentials or GetCredentialsByItemId until ClearCredentials
+	credentials map[string]*Credentials
+	mutex       sync.Mutex
 }
 
 func New1PasswordProvider(binary, base, tag string) (*Provider1Password, error) {
@@ -30,6 +44,7 @@ func New1PasswordProvider(binary, base, tag string) (*Provider1Password, error)
 		base:         base,
 		tag:          tag,
 		UrlsByItemId: make(map[string][]string),
+		credentials:  make(map[string]*Credentials),
 	}
 
 	binaryPath, err := DetermineBinary(binary)
@@ -61,10 +76,20 @@ func (p *Provider1Password) initializeVaultversion() error {
 }
 
 func (p *Provider1Password) LoadVaultItems() (Items, error) {
+	p.startSession()
+
 	// Build item list command
 	// #nosec G204
 	cmdArgs := p.buildVaultCommandArguments([]string{"item", "list"}, true)
 	itemListResponse, err := exec.Command(p.binary, cmdArgs...).Output()
+	if err != nil && p.session != "" {
+		// The session expired (e.g. between two runs of buchhalter serve), so sign in again
+		p.session = ""
+		p.startSession()
+		cmdArgs = p.buildVaultCommandArguments([]string{"item", "list"}, true)
+		// #nosec G204
+		itemListResponse, err = exec.Command(p.binary, cmdArgs...).Output()
+	}
 	if err != nil {
 		return nil, ProviderConnectionError{
 			Code: ProviderConnectionErrorCode,
@@ -98,11 +123,18 @@ func (p *Provider1Password) LoadVaultItems() (Items, error) {
 	return vaultItems, nil
 }
 
-func (p Provider1Password) GetCredentialsByItemId(itemId string) (*Credentials, error) {
+func (p *Provider1Password) GetCredentialsByItemId(ctx context.Context, itemId string) (*Credentials, error) {
+	p.mutex.Lock()
+	credentials, ok := p.credentials[itemId]
+	p.mutex.Unlock()
+	if ok {
+		return credentials, nil
+	}
+
 	cmdArgs := p.buildVaultCommandArguments([]string{"item", "get", itemId}, false)
 
 	// #nosec G204
-	itemGetResponse, err := exec.Command(p.binary, cmdArgs...).Output()
+	itemGetResponse, err := exec.CommandContext(ctx, p.binary, cmdArgs...).Output()
 	if err != nil {
 		return nil, ProviderNotInstalledError{
 			Code: ProviderNotInstalledErrorCode,
@@ -121,17 +153,256 @@ func (p Provider1Password) GetCredentialsByItemId(itemId string) (*Credentials,
 		}
 	}
 
+	credentials = p.cacheCredentials(item)
+
+	return credentials, nil
+}
+
+// PrefetchCredentials loads the credentials of several items with a single command, e.g. of all suppliers of a run.
+// GetCredentialsByItemId returns them from the cache afterward.
+func (p *Provider1Password) PrefetchCredentials(ctx context.Context, itemIds []string) error {
+	var references []map[string]string
+	p.mutex.Lock()
+	for _, itemId := range itemIds {
+		if _, ok := p.credentials[itemId]; !ok {
+			references = append(references, map[string]string{"id": itemId})
+		}
+	}
+	p.mutex.Unlock()
+	if len(references) == 0 {
+		return nil
+	}
+	referencesJSON, err := json.Marshal(references)
+	if err != nil {
+		return err
+	}
+
+	// `op item get -` reads the items from stdin
+	cmdArgs := p.buildVaultCommandArguments([]string{"item", "get", "-"}, false)
+	// #nosec G204
+	cmd := exec.CommandContext(ctx, p.binary, cmdArgs...)
+	cmd.Stdin = bytes.NewReader(referencesJSON)
+	itemGetResponse, err := cmd.Output()
+	if err != nil {
+		return ProviderConnectionError{
+			Code: ProviderConnectionErrorCode,
+			Cmd:  fmt.Sprintf("%s %s", p.binary, strings.Join(cmdArgs, " ")),
+			Err:  err,
+		}
+	}
+
+	items, err := decodeItems(itemGetResponse)
+	if err != nil {
+		return ProviderResponseParsingError{
+			Code: ProviderResponseParsingErrorCode,
+			Cmd:  fmt.Sprintf("%s %s", p.binary, strings.Join(cmdArgs, " ")),
+			Err:  err,
+		}
+	}
+	for _, item := range items {
+		p.cacheCredentials(item)
+	}
+
+	return nil
+}
+
+// ClearCredentials removes all cached credentials, e.g. at the end of a run.
+func (p *Provider1Password) ClearCredentials() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.credentials = make(map[string]*Credentials)
+}
+
+func (p *Provider1Password) cacheCredentials(item Item) *Credentials {
 	credentials := &Credentials{
-		Id:       itemId,
+		Id:       item.ID,
 		Username: getValueByField(item, "username"),
 		Password: getValueByField(item, "password"),
 		Totp:     getValueByField(item, "totp"),
 	}
+	redact.AddSecrets(credentials.Password, credentials.Totp)
 
-	return credentials, nil
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.credentials[item.ID] = credentials
+
+	return credentials
 }
 
-func (p Provider1Password) buildVaultCommandArguments(baseCmd []string, includeTag bool) []string {
+// startSession signs in to 1Password once, so the following commands reuse the session token.
+// With the integration into the 1Password app (biometric unlock), `op signin` returns no token and the app keeps the
+// vault unlocked instead. Sessions of the environment (OP_SESSION_*) are used as they are.
+func (p *Provider1Password) startSession() {
+	if p.session != "" || hasSessionEnvironment() {
+		return
+	}
+
+	// #nosec G204
+	token, err := exec.Command(p.binary, "signin", "--raw").Output()
+	if err != nil {
+		// Each command asks for the unlock instead
+		return
+	}
+	p.session = strings.TrimSpace(string(token))
+	redact.AddSecrets(p.session)
+}
+
+func hasSessionEnvironment() bool {
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, "OP_SESSION_") {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeItems decodes the response of `op item get -`, a sequence of JSON objects (or an array of them).
+func decodeItems(response []byte) (Items, error) {
+	response = bytes.TrimSpace(response)
+	if bytes.HasPrefix(response, []byte("[")) {
+		var items Items
+		err := json.Unmarshal(response, &items)
+		return items, err
+	}
+
+	var items Items
+	decoder := json.NewDecoder(bytes.NewReader(response))
+	for decoder.More() {
+		var item Item
+		err := decoder.Decode(&item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// UpdateItemMetadata writes metadata of the last sync into the "buchhalter" section of a vault item.
+func (p *Provider1Password) UpdateItemMetadata(ctx context.Context, itemId string, metadata ItemMetadata) error {
+	baseCmd := []string{"item", "edit", itemId, fmt.Sprintf("buchhalter.last synced[text]=%s", metadata.LastSynced.Format(time.RFC3339))}
+	if metadata.LoginURL != "" {
+		baseCmd = append(baseCmd, "--url", metadata.LoginURL)
+	}
+	cmdArgs := p.buildVaultCommandArguments(baseCmd, false)
+
+	// #nosec G204
+	err := exec.CommandContext(ctx, p.binary, cmdArgs...).Run()
+	if err != nil {
+		return ProviderWriteError{
+			Code: ProviderWriteErrorCode,
+			Cmd:  fmt.Sprintf("%s %s", p.binary, strings.Join(cmdArgs, " ")),
+			Err:  err,
+		}
+	}
+
+	return nil
+}
+
+// FindItemsByDomains returns the items of the vault with a URL of one of domains, regardless of their tag.
+func (p *Provider1Password) FindItemsByDomains(domains []string) (Items, error) {
+	// #nosec G204
+	cmdArgs := p.buildVaultCommandArguments([]string{"item", "list"}, false)
+	itemListResponse, err := exec.Command(p.binary, cmdArgs...).Output()
+	if err != nil {
+		return nil, ProviderConnectionError{
+			Code: ProviderConnectionErrorCode,
+			Cmd:  fmt.Sprintf("%s %s", p.binary, strings.Join(cmdArgs, " ")),
+			Err:  err,
+		}
+	}
+
+	var vaultItems Items
+	err = json.Unmarshal(itemListResponse, &vaultItems)
+	if err != nil {
+		return nil, ProviderResponseParsingError{
+			Code: ProviderResponseParsingErrorCode,
+			Cmd:  fmt.Sprintf("%s %s", p.binary, strings.Join(cmdArgs, " ")),
+			Err:  err,
+		}
+	}
+
+	var items Items
+	for _, item := range vaultItems {
+		if item.MatchesDomain(domains) {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+// AddTag adds the tag of the provider to an item, so it is used by sync.
+func (p *Provider1Password) AddTag(item Item) error {
+	if len(p.tag) == 0 || item.HasTag(p.tag) {
+		return nil
+	}
+	tags := append(append([]string{}, item.Tags...), p.tag)
+	cmdArgs := p.buildVaultCommandArguments([]string{"item", "edit", item.ID, "--tags", strings.Join(tags, ",")}, false)
+
+	// #nosec G204
+	err := exec.Command(p.binary, cmdArgs...).Run()
+	if err != nil {
+		return ProviderWriteError{
+			Code: ProviderWriteErrorCode,
+			Cmd:  fmt.Sprintf("%s %s", p.binary, strings.Join(cmdArgs, " ")),
+			Err:  err,
+		}
+	}
+
+	return nil
+}
+
+// CreateLoginItem creates a login item with the tag of the provider.
+// The item is passed as template on stdin, so the password does not show up in the process list.
+func (p *Provider1Password) CreateLoginItem(title, loginURL, username, password string) (Item, error) {
+	redact.AddSecrets(password)
+
+	template := loginItemTemplate{
+		Title:    title,
+		Category: "LOGIN",
+		Fields: []loginItemTemplateField{
+			{ID: "username", Type: "STRING", Purpose: "USERNAME", Label: "username", Value: username},
+			{ID: "password", Type: "CONCEALED", Purpose: "PASSWORD", Label: "password", Value: password},
+		},
+		Urls: []loginItemTemplateURL{{Href: loginURL, Primary: true}},
+	}
+	if len(p.tag) > 0 {
+		template.Tags = []string{p.tag}
+	}
+	templateJSON, err := json.Marshal(template)
+	if err != nil {
+		return Item{}, err
+	}
+
+	cmdArgs := p.buildVaultCommandArguments([]string{"item", "create", "-"}, false)
+	// #nosec G204
+	cmd := exec.Command(p.binary, cmdArgs...)
+	cmd.Stdin = bytes.NewReader(templateJSON)
+	itemCreateResponse, err := cmd.Output()
+	if err != nil {
+		return Item{}, ProviderWriteError{
+			Code: ProviderWriteErrorCode,
+			Cmd:  fmt.Sprintf("%s %s", p.binary, strings.Join(cmdArgs, " ")),
+			Err:  err,
+		}
+	}
+
+	var item Item
+	err = json.Unmarshal(itemCreateResponse, &item)
+	if err != nil {
+		return Item{}, ProviderResponseParsingError{
+			Code: ProviderResponseParsingErrorCode,
+			Cmd:  fmt.Sprintf("%s %s", p.binary, strings.Join(cmdArgs, " ")),
+			Err:  err,
+		}
+	}
+
+	return item, nil
+}
+
+func (p *Provider1Password) buildVaultCommandArguments(baseCmd []string, includeTag bool) []string {
 	cmdArgs := baseCmd
 	if len(p.base) > 0 {
 		cmdArgs = append(cmdArgs, "--vault", p.base)
@@ -139,6 +410,9 @@ func (p Provider1Password) buildVaultCommandArguments(baseCmd []string, includeT
 	if includeTag && len(p.tag) > 0 {
 		cmdArgs = append(cmdArgs, "--tags", p.tag)
 	}
+	if len(p.session) > 0 {
+		cmdArgs = append(cmdArgs, "--session", p.session)
+	}
 	cmdArgs = append(cmdArgs, "--format", "json")
 
 	return cmdArgs
@@ -150,20 +424,22 @@ func (p *Provider1Password) GetHumanReadableErrorMessage(err error) string {
 	// The concrete (developer oriented) error message is available in err
 	switch err.(type) {
 	case ProviderNotInstalledError:
-		message = `Could not find out 1Password cli version. Install 1Password cli, first.
-Please read "Get started with 1Password CLI" at https://developer.1password.com/docs/cli/get-started/`
+		message = i18n.T(`Could not find out 1Password cli version. Install 1Password cli, first.
+Please read "Get started with 1Password CLI" at https://developer.1password.com/docs/cli/get-started/`)
 
 	case ProviderConnectionError:
-		message = `Could not connect to 1Password vault. Open 1Password vault with "eval $(op signin)", first.
-Please read "Sign in to 1Password CLI" at https://developer.1password.com/docs/cli/reference/commands/signin/`
+		message = i18n.T(`Could not connect to 1Password vault. Open 1Password vault with "eval $(op signin)", first.
+Please read "Sign in to 1Password CLI" at https://developer.1password.com/docs/cli/reference/commands/signin/`)
 
 	case ProviderResponseParsingError:
-		message = `Could not read response data from 1Password vault.`
+		message = i18n.T(`Could not read response data from 1Password vault.`)
+
+	case ProviderWriteError:
+		message = i18n.T(`Could not write to 1Password vault. Check that you are allowed to edit items of the vault.`)
 
 	case CommandExecutionError:
 		ceErr, _ := err.(*CommandExecutionError)
-		message = `An error occurred while executing a command: %s`
-		message = fmt.Sprintf(message, ceErr.Cmd)
+		message = i18n.Tf(`An error occurred while executing a command: %s`, ceErr.Cmd)
 	}
 
 	return message
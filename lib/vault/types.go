@@ -2,6 +2,8 @@ package vault
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -50,6 +52,51 @@ type Item struct {
 	} `json:"fields"`
 }
 
+// MatchesDomain returns true if a URL of the item belongs to one of domains (e.g. "https://hetzner.com/login" to "hetzner.com").
+func (i Item) MatchesDomain(domains []string) bool {
+	for _, domain := range domains {
+		pattern := regexp.MustCompile("^(https?://)?" + regexp.QuoteMeta(domain))
+		for _, u := range i.Urls {
+			if pattern.MatchString(strings.TrimSpace(u.Href)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasTag returns true if the item is tagged with tag.
+func (i Item) HasTag(tag string) bool {
+	for _, t := range i.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// loginItemTemplate is the item template of `op item create` for a login item.
+type loginItemTemplate struct {
+	Title    string                   `json:"title"`
+	Category string                   `json:"category"`
+	Tags     []string                 `json:"tags,omitempty"`
+	Fields   []loginItemTemplateField `json:"fields"`
+	Urls     []loginItemTemplateURL   `json:"urls"`
+}
+
+type loginItemTemplateField struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose"`
+	Label   string `json:"label"`
+	Value   string `json:"value"`
+}
+
+type loginItemTemplateURL struct {
+	Href    string `json:"href"`
+	Primary bool   `json:"primary"`
+}
+
 type Credentials struct {
 	Id       string
 	Username string
@@ -57,11 +104,19 @@ type Credentials struct {
 	Totp     string
 }
 
+// ItemMetadata is written back to a vault item after a successful recipe run.
+type ItemMetadata struct {
+	LastSynced time.Time
+	// LoginURL replaces the primary URL of the item (optional)
+	LoginURL string
+}
+
 const (
 	ProviderNotInstalledErrorCode    int = 9001
 	ProviderConnectionErrorCode      int = 9002
 	ProviderResponseParsingErrorCode int = 9003
 	CommandExecutionErrorCode        int = 9004
+	ProviderWriteErrorCode           int = 9005
 )
 
 type ProviderNotInstalledError struct {
@@ -103,3 +158,13 @@ type CommandExecutionError struct {
 func (e CommandExecutionError) Error() string {
 	return fmt.Sprintf("Error %d executing command \"%s\": %s", e.Code, e.Cmd, e.Err.Error())
 }
+
+type ProviderWriteError struct {
+	Code int
+	Cmd  string
+	Err  error
+}
+
+func (e ProviderWriteError) Error() string {
+	return fmt.Sprintf("Error %d writing to password vault \"%s\": %s", e.Code, e.Cmd, e.Err.Error())
+}
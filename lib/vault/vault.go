@@ -72,6 +72,19 @@ func DetermineBinary(binaryPath string) (string, error) {
 	return foundBinary, nil
 }
 
+// CorrectedLoginURL returns the first url of a vault item without a scheme (e.g. "hetzner.com/login") prefixed with "https://".
+// It returns an empty string if all urls are valid.
+func CorrectedLoginURL(urls []string) string {
+	for _, u := range urls {
+		u = strings.TrimSpace(u)
+		if u != "" && !strings.Contains(u, "://") {
+			return "https://" + u
+		}
+	}
+
+	return ""
+}
+
 func getValueByField(item Item, fieldName string) string {
 	for n := 0; n < len(item.Fields); n++ {
 		if item.Fields[n].Type == "OTP" && fieldName == "totp" {
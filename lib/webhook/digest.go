@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"sort"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/history"
+)
+
+// maxMissingCadence is the longest cadence of suppliers expected to issue a document every month
+const maxMissingCadence = 31 * 24 * time.Hour
+
+// MonthlyDigestData is the payload of a `digest.monthly` event.
+type MonthlyDigestData struct {
+	// Month of the digest, e.g. 2024-09 (since 2)
+	Month string `json:"month"`
+	// Runs is the number of syncs started in the month (since 2)
+	Runs int `json:"runs"`
+	// Documents is the number of documents added to the archives in the month (since 2)
+	Documents int `json:"documents"`
+	// Failures is the number of supplier runs with status `error` (since 2)
+	Failures int `json:"failures"`
+	// Totals are the gross amounts of the documents with an amount per currency, never null (since 2)
+	Totals []MonthlyDigestTotal `json:"totals"`
+	// MissingSuppliers are the suppliers expected to issue a document every month (see buchhalter_supplier_cadence)
+	// without a document in the month, never null (since 2)
+	MissingSuppliers []string `json:"missingSuppliers"`
+	// Suppliers are the suppliers with documents, failures or missing documents in the month, never null (since 2)
+	Suppliers []MonthlyDigestSupplier `json:"suppliers"`
+}
+
+type MonthlyDigestTotal struct {
+	// Currency of the amount, empty if neither the documents nor buchhalter_supplier_currency have one (since 2)
+	Currency string `json:"currency"`
+	// Amount is the gross amount with two decimals, e.g. 1234.56 (since 2)
+	Amount string `json:"amount"`
+	// Documents is the number of documents of the amount (since 2)
+	Documents int `json:"documents"`
+}
+
+type MonthlyDigestSupplier struct {
+	// Supplier is the supplier of the documents (since 2)
+	Supplier string `json:"supplier"`
+	// Documents is the number of documents of the supplier added in the month (since 2)
+	Documents int `json:"documents"`
+	// Failures is the number of runs of the supplier with status `error` (since 2)
+	Failures int `json:"failures"`
+	// Missing is true if the supplier is expected to issue a document every month but has none (since 2)
+	Missing bool `json:"missing"`
+}
+
+// NewMonthlyDigest summarizes a month (see archive.ParsePeriod): the documents added to the archive (files), the
+// supplier runs of the history that failed and the suppliers whose cadence is a month or less without documents.
+// The totals use the supplier defaults for documents whose metadata lacks a currency (see archive.ResolveSpend).
+func NewMonthlyDigest(month archive.Period, runs []history.Run, files map[string]archive.File, cadences map[string]time.Duration, defaults map[string]archive.SupplierDefaults) MonthlyDigestData {
+	digest := MonthlyDigestData{
+		Month:            month.Name,
+		Totals:           []MonthlyDigestTotal{},
+		MissingSuppliers: []string{},
+		Suppliers:        []MonthlyDigestSupplier{},
+	}
+	suppliers := map[string]*MonthlyDigestSupplier{}
+	supplier := func(name string) *MonthlyDigestSupplier {
+		if suppliers[name] == nil {
+			suppliers[name] = &MonthlyDigestSupplier{Supplier: name}
+		}
+		return suppliers[name]
+	}
+
+	type total struct {
+		amount    int64
+		documents int
+	}
+	totals := map[string]*total{}
+	for _, f := range files {
+		if f.Rejected || !month.Contains(f.AddedAt) {
+			continue
+		}
+		digest.Documents++
+		supplier(f.Supplier).Documents++
+
+		spend, err := archive.ResolveSpend(f.Metadata, defaults[f.Supplier])
+		if err != nil {
+			continue
+		}
+		if totals[spend.Currency] == nil {
+			totals[spend.Currency] = &total{}
+		}
+		totals[spend.Currency].amount += spend.Gross
+		totals[spend.Currency].documents++
+	}
+
+	for _, run := range runs {
+		if !month.Contains(run.StartedAt) {
+			continue
+		}
+		digest.Runs++
+		for _, supplierRun := range run.Suppliers {
+			if supplierRun.Status == "error" {
+				digest.Failures++
+				supplier(supplierRun.Supplier).Failures++
+			}
+		}
+	}
+
+	for name, cadence := range cadences {
+		if cadence <= maxMissingCadence && (suppliers[name] == nil || suppliers[name].Documents == 0) {
+			supplier(name).Missing = true
+			digest.MissingSuppliers = append(digest.MissingSuppliers, name)
+		}
+	}
+	sort.Strings(digest.MissingSuppliers)
+
+	for _, s := range suppliers {
+		digest.Suppliers = append(digest.Suppliers, *s)
+	}
+	sort.Slice(digest.Suppliers, func(i, j int) bool {
+		return digest.Suppliers[i].Supplier < digest.Suppliers[j].Supplier
+	})
+	for currency, t := range totals {
+		digest.Totals = append(digest.Totals, MonthlyDigestTotal{Currency: currency, Amount: archive.FormatAmount(t.amount), Documents: t.documents})
+	}
+	sort.Slice(digest.Totals, func(i, j int) bool {
+		return digest.Totals[i].Currency < digest.Totals[j].Currency
+	})
+
+	return digest
+}
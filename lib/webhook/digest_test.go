@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/history"
+)
+
+func TestNewMonthlyDigest(t *testing.T) {
+	month, err := archive.ParsePeriod("2024-09", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	september := time.Date(2024, time.September, 15, 10, 0, 0, 0, time.UTC)
+	august := time.Date(2024, time.August, 31, 10, 0, 0, 0, time.UTC)
+
+	files := map[string]archive.File{
+		"c1": {Supplier: "hetzner", AddedAt: september, Metadata: &archive.Metadata{Amount: "11,90 €"}},
+		"c2": {Supplier: "hetzner", AddedAt: september, Metadata: &archive.Metadata{Amount: "5,00"}},
+		"c3": {Supplier: "aws", AddedAt: september, Metadata: &archive.Metadata{Amount: "USD 20.00"}},
+		"c4": {Supplier: "aws", AddedAt: september},
+		"c5": {Supplier: "hetzner", AddedAt: september, Rejected: true, Metadata: &archive.Metadata{Amount: "100,00 €"}},
+		"c6": {Supplier: "acme", AddedAt: august},
+	}
+	runs := []history.Run{
+		{StartedAt: august, Suppliers: []history.SupplierRun{{Supplier: "acme", Status: "error"}}},
+		{StartedAt: september, Suppliers: []history.SupplierRun{{Supplier: "hetzner", Status: "success"}, {Supplier: "acme", Status: "error"}}},
+		{StartedAt: september.Add(24 * time.Hour), Suppliers: []history.SupplierRun{{Supplier: "acme", Status: "error"}}},
+	}
+	cadences := map[string]time.Duration{
+		"acme":    31 * 24 * time.Hour,
+		"hetzner": 31 * 24 * time.Hour,
+		"yearly":  366 * 24 * time.Hour,
+	}
+	defaults := map[string]archive.SupplierDefaults{"hetzner": {Currency: "EUR"}}
+
+	digest := NewMonthlyDigest(month, runs, files, cadences, defaults)
+	expected := MonthlyDigestData{
+		Month:     "2024-09",
+		Runs:      2,
+		Documents: 4,
+		Failures:  2,
+		Totals: []MonthlyDigestTotal{
+			{Currency: "EUR", Amount: "16.90", Documents: 2},
+			{Currency: "USD", Amount: "20.00", Documents: 1},
+		},
+		MissingSuppliers: []string{"acme"},
+		Suppliers: []MonthlyDigestSupplier{
+			{Supplier: "acme", Failures: 2, Missing: true},
+			{Supplier: "aws", Documents: 2},
+			{Supplier: "hetzner", Documents: 2},
+		},
+	}
+	if !reflect.DeepEqual(digest, expected) {
+		t.Errorf("expected digest %+v, got %+v", expected, digest)
+	}
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+// Event types of the webhook subsystem.
+// The payloads are stable: fields are never renamed or removed, new fields are only added with a new SCHEMA_VERSION.
+// Every field documents the schema version it was added in (`since`), so consumers like Zapier or Make can rely on them.
+const (
+	EVENT_DOCUMENT_CREATED = "document.created"
+	EVENT_RUN_COMPLETED    = "run.completed"
+	// EVENT_MONTHLY_DIGEST is sent by `buchhalter serve` after the end of each month (see NewMonthlyDigest)
+	EVENT_MONTHLY_DIGEST = "digest.monthly"
+
+	// SCHEMA_VERSION is the version of the event payloads
+	SCHEMA_VERSION = 2
+)
+
+// Event is the envelope of all events.
+type Event struct {
+	// ID is unique per event, so receivers can deduplicate retried deliveries (since 1)
+	ID string `json:"id"`
+	// Type is one of the EVENT_* constants (since 1)
+	Type string `json:"type"`
+	// SchemaVersion is the SCHEMA_VERSION of the payload (since 1)
+	SchemaVersion int `json:"schemaVersion"`
+	// CreatedAt is the time the event occurred (since 1)
+	CreatedAt time.Time `json:"createdAt"`
+	// Data is DocumentCreatedData, RunCompletedData or MonthlyDigestData, depending on Type (since 1)
+	Data any `json:"data"`
+}
+
+// DocumentCreatedData is the payload of a `document.created` event.
+type DocumentCreatedData struct {
+	// Checksum is the SHA-256 checksum of the document (since 1)
+	Checksum string `json:"checksum"`
+	// FileName is the file name of the document in the archive (since 1)
+	FileName string `json:"fileName"`
+	// Supplier is the supplier the document has been downloaded from (since 1)
+	Supplier string `json:"supplier"`
+	// Archive is the name of the archive storing the document (since 1)
+	Archive string `json:"archive"`
+	// Tags are the tags of the document, never null (since 1)
+	Tags []string `json:"tags"`
+	// AddedAt is the time the document has been added to the archive (since 1)
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// RunCompletedData is the payload of a `run.completed` event.
+type RunCompletedData struct {
+	// StartedAt is the start time of the run (since 1)
+	StartedAt time.Time `json:"startedAt"`
+	// DurationSeconds is the duration of the run (since 1)
+	DurationSeconds float64 `json:"durationSeconds"`
+	// NewDocuments is the number of new documents of all suppliers (since 1)
+	NewDocuments int `json:"newDocuments"`
+	// FailedSuppliers is the number of suppliers with status `error` (since 1)
+	FailedSuppliers int `json:"failedSuppliers"`
+	// Suppliers are the results of the suppliers, never null (since 1)
+	Suppliers []RunCompletedSupplier `json:"suppliers"`
+}
+
+type RunCompletedSupplier struct {
+	// Supplier is the supplier of the recipe (since 1)
+	Supplier string `json:"supplier"`
+	// Status is `success`, `warning` or `error` (since 1)
+	Status string `json:"status"`
+	// NewDocuments is the number of new documents of the supplier (since 1)
+	NewDocuments int `json:"newDocuments"`
+	// ErrorMessage is the last error of the recipe, empty on success (since 1)
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// NewEvent creates an event with a random id.
+func NewEvent(eventType string, data any) Event {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	return Event{
+		ID:            "evt_" + hex.EncodeToString(id),
+		Type:          eventType,
+		SchemaVersion: SCHEMA_VERSION,
+		CreatedAt:     time.Now().UTC(),
+		Data:          data,
+	}
+}
+
+// EventSender POSTs events as JSON to a webhook URL (e.g. a Zapier or Make catch hook).
+// Requests are signed like the requests of the DocumentSink.
+type EventSender struct {
+	logger     *slog.Logger
+	httpClient *httpclient.Client
+
+	url    string
+	secret string
+	events map[string]bool
+}
+
+// NewEventSender creates a sender for the given event types. If events is empty, all events are sent.
+func NewEventSender(logger *slog.Logger, httpClient *httpclient.Client, url, secret string, events []string) *EventSender {
+	enabledEvents := map[string]bool{}
+	for _, eventType := range events {
+		enabledEvents[eventType] = true
+	}
+
+	return &EventSender{
+		logger:     logger,
+		httpClient: httpClient,
+		url:        url,
+		secret:     secret,
+		events:     enabledEvents,
+	}
+}
+
+// Send sends the event, unless its type is not enabled.
+func (s *EventSender) Send(ctx context.Context, event Event) error {
+	if len(s.events) > 0 && !s.events[event.Type] {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	timestamp := time.Now().Unix()
+	req.Header.Set(TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	if s.secret != "" {
+		req.Header.Set(SIGNATURE_HEADER, Sign(s.secret, timestamp, body))
+	}
+
+	s.logger.Info("Sending webhook event", "type", event.Type, "id", event.ID)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error sending %s event: %w", event.Type, httpclient.StatusError(resp, ""))
+	}
+
+	return nil
+}
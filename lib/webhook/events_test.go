@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"buchhalter/lib/httpclient"
+)
+
+// The field names are part of the stable event schema
+func TestEventPayloadFields(t *testing.T) {
+	event := NewEvent(EVENT_DOCUMENT_CREATED, DocumentCreatedData{Checksum: "abc", Tags: []string{}})
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"id", "type", "schemaVersion", "createdAt", "data"} {
+		if _, ok := decoded[field]; !ok {
+			t.Errorf("expected field %s in event", field)
+		}
+	}
+	data := decoded["data"].(map[string]any)
+	for _, field := range []string{"checksum", "fileName", "supplier", "archive", "tags", "addedAt"} {
+		if _, ok := data[field]; !ok {
+			t.Errorf("expected field %s in document.created data", field)
+		}
+	}
+	if decoded["schemaVersion"].(float64) != SCHEMA_VERSION {
+		t.Errorf("unexpected schema version %v", decoded["schemaVersion"])
+	}
+}
+
+func TestEventSenderSendsEnabledEvents(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		received = append(received, event.Type)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sender := NewEventSender(logger, httpclient.New(logger, 5*time.Second, 0), server.URL, "", []string{EVENT_RUN_COMPLETED})
+	for _, eventType := range []string{EVENT_DOCUMENT_CREATED, EVENT_RUN_COMPLETED} {
+		if err := sender.Send(context.Background(), NewEvent(eventType, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(received) != 1 || received[0] != EVENT_RUN_COMPLETED {
+		t.Errorf("expected only run.completed to be sent, got %v", received)
+	}
+}
@@ -0,0 +1,224 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/httpclient"
+)
+
+const (
+	SIGNATURE_HEADER = "X-Buchhalter-Signature"
+	TIMESTAMP_HEADER = "X-Buchhalter-Timestamp"
+
+	deliveredDocumentsFileName = "document_sink_delivered.json"
+)
+
+// DocumentMetadata is sent as JSON part `metadata` along with the document.
+type DocumentMetadata struct {
+	Checksum string    `json:"checksum"`
+	FileName string    `json:"fileName"`
+	Supplier string    `json:"supplier"`
+	Tags     []string  `json:"tags"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+// DocumentSink POSTs new documents (multipart: `metadata` JSON and `document` file) to an endpoint of an in-house system (e.g. a DMS or ERP).
+// Requests are signed with HMAC-SHA256 (see Sign), so the receiver can verify their origin.
+// The checksums of delivered documents are persisted in the config directory.
+type DocumentSink struct {
+	logger     *slog.Logger
+	httpClient *httpclient.Client
+
+	url             string
+	secret          string
+	configDirectory string
+}
+
+func NewDocumentSink(logger *slog.Logger, httpClient *httpclient.Client, url, secret, configDirectory string) *DocumentSink {
+	return &DocumentSink{
+		logger:          logger,
+		httpClient:      httpClient,
+		url:             url,
+		secret:          secret,
+		configDirectory: configDirectory,
+	}
+}
+
+// Sign returns the signature of a request body sent at timestamp (unix seconds): the hex encoded HMAC-SHA256 of `<timestamp>.<body>`.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverAll delivers all documents of fileIndex that haven't been delivered before, oldest first.
+// Rejected and staged documents are skipped. Delivery stops at the first error, the remaining documents are delivered on the next run.
+// On the first delivery, the documents of fileIndex are marked as delivered instead, so an existing archive isn't sent
+// to the endpoint when the sink is configured. Staged documents are delivered once they are accepted.
+// Returns the number of delivered documents.
+func (s *DocumentSink) DeliverAll(ctx context.Context, fileIndex map[string]archive.File) (int, error) {
+	deliveredDocuments, err := s.readDeliveredDocuments()
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, s.seedDeliveredDocuments(fileIndex)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	checksums := make([]string, 0, len(fileIndex))
+	for checksum, f := range fileIndex {
+		if _, ok := deliveredDocuments[checksum]; ok || f.Rejected || f.Staged {
+			continue
+		}
+		checksums = append(checksums, checksum)
+	}
+	sort.Slice(checksums, func(i, j int) bool {
+		return fileIndex[checksums[i]].AddedAt.Before(fileIndex[checksums[j]].AddedAt)
+	})
+
+	delivered := 0
+	for _, checksum := range checksums {
+		f := fileIndex[checksum]
+		err = s.Deliver(ctx, checksum, f)
+		if err != nil {
+			return delivered, fmt.Errorf("error delivering %s to document sink: %w", f.Path, err)
+		}
+		s.logger.Info("Delivered document to document sink", "file", f.Path, "supplier", f.Supplier)
+
+		deliveredDocuments[checksum] = time.Now()
+		err = s.writeDeliveredDocuments(deliveredDocuments)
+		if err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// Deliver POSTs a single document to the endpoint.
+func (s *DocumentSink) Deliver(ctx context.Context, checksum string, file archive.File) error {
+	tags := file.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	metadata, err := json.Marshal(DocumentMetadata{
+		Checksum: checksum,
+		FileName: filepath.Base(file.Path),
+		Supplier: file.Supplier,
+		Tags:     tags,
+		AddedAt:  file.AddedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	metadataHeader := textproto.MIMEHeader{}
+	metadataHeader.Set("Content-Disposition", `form-data; name="metadata"`)
+	metadataHeader.Set("Content-Type", "application/json")
+	part, err := writer.CreatePart(metadataHeader)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(metadata)
+	if err != nil {
+		return err
+	}
+	part, err = writer.CreateFormFile("document", filepath.Base(file.Path))
+	if err != nil {
+		return err
+	}
+	fileHandle, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer fileHandle.Close()
+	_, err = io.Copy(part, fileHandle)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	timestamp := time.Now().Unix()
+	req.Header.Set(TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	if s.secret != "" {
+		req.Header.Set(SIGNATURE_HEADER, Sign(s.secret, timestamp, body.Bytes()))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return httpclient.StatusError(resp, "")
+	}
+
+	return nil
+}
+
+// seedDeliveredDocuments marks the documents of fileIndex as delivered, except staged documents.
+func (s *DocumentSink) seedDeliveredDocuments(fileIndex map[string]archive.File) error {
+	deliveredDocuments := map[string]time.Time{}
+	now := time.Now()
+	for checksum, f := range fileIndex {
+		if f.Staged {
+			continue
+		}
+		deliveredDocuments[checksum] = now
+	}
+	s.logger.Info("Marking archived documents as delivered to document sink", "documents", len(deliveredDocuments))
+
+	return s.writeDeliveredDocuments(deliveredDocuments)
+}
+
+// readDeliveredDocuments returns the delivered documents by checksum, os.ErrNotExist if nothing was delivered yet.
+func (s *DocumentSink) readDeliveredDocuments() (map[string]time.Time, error) {
+	deliveredDocuments := map[string]time.Time{}
+
+	fileContent, err := os.ReadFile(filepath.Join(s.configDirectory, deliveredDocumentsFileName))
+	if err != nil {
+		return deliveredDocuments, err
+	}
+
+	err = json.Unmarshal(fileContent, &deliveredDocuments)
+	return deliveredDocuments, err
+}
+
+func (s *DocumentSink) writeDeliveredDocuments(deliveredDocuments map[string]time.Time) error {
+	fileContent, err := json.MarshalIndent(deliveredDocuments, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(s.configDirectory, deliveredDocumentsFileName), fileContent, 0600)
+}
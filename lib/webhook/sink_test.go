@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"buchhalter/lib/archive"
+	"buchhalter/lib/httpclient"
+)
+
+func TestDocumentSinkDeliversSignedDocuments(t *testing.T) {
+	var received []DocumentMetadata
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TIMESTAMP_HEADER), 10, 64)
+		if r.Header.Get(SIGNATURE_HEADER) != Sign("secret", timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("error parsing multipart body: %s", err)
+		}
+		var metadata DocumentMetadata
+		if err := json.Unmarshal([]byte(r.MultipartForm.Value["metadata"][0]), &metadata); err != nil {
+			t.Errorf("error decoding metadata: %s", err)
+		}
+		if len(r.MultipartForm.File["document"]) != 1 {
+			t.Error("expected document part")
+		}
+		received = append(received, metadata)
+	}))
+	defer server.Close()
+
+	documentPath := filepath.Join(t.TempDir(), "invoice.pdf")
+	if err := os.WriteFile(documentPath, []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileIndex := map[string]archive.File{
+		"abc": {Path: documentPath, Supplier: "acme", Tags: []string{"hosting"}, AddedAt: time.Now()},
+		"def": {Path: documentPath, Supplier: "acme", Staged: true},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sink := NewDocumentSink(logger, httpclient.New(logger, 5*time.Second, 0), server.URL, "secret", t.TempDir())
+	// The sink is configured before the documents are added
+	if _, err := sink.DeliverAll(context.Background(), map[string]archive.File{}); err != nil {
+		t.Fatal(err)
+	}
+	delivered, err := sink.DeliverAll(context.Background(), fileIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered != 1 || len(received) != 1 {
+		t.Fatalf("expected 1 delivered document, got %d", delivered)
+	}
+	if received[0].Checksum != "abc" || received[0].FileName != "invoice.pdf" || received[0].Supplier != "acme" {
+		t.Errorf("unexpected metadata %+v", received[0])
+	}
+
+	// Documents are delivered only once
+	delivered, err = sink.DeliverAll(context.Background(), fileIndex)
+	if err != nil || delivered != 0 {
+		t.Errorf("expected no document to be delivered again, got %d (%v)", delivered, err)
+	}
+}
+
+func TestDocumentSinkSkipsArchivedDocumentsOnFirstDelivery(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("error parsing multipart body: %s", err)
+		}
+		var metadata DocumentMetadata
+		if err := json.Unmarshal([]byte(r.MultipartForm.Value["metadata"][0]), &metadata); err != nil {
+			t.Errorf("error decoding metadata: %s", err)
+		}
+		received = append(received, metadata.Checksum)
+	}))
+	defer server.Close()
+
+	documentPath := filepath.Join(t.TempDir(), "invoice.pdf")
+	if err := os.WriteFile(documentPath, []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileIndex := map[string]archive.File{
+		"archived": {Path: documentPath, Supplier: "acme", AddedAt: time.Now().Add(-24 * time.Hour)},
+		"staged":   {Path: documentPath, Supplier: "acme", Staged: true},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	configDirectory := t.TempDir()
+	sink := NewDocumentSink(logger, httpclient.New(logger, 5*time.Second, 0), server.URL, "", configDirectory)
+	delivered, err := sink.DeliverAll(context.Background(), fileIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered != 0 || len(received) != 0 {
+		t.Fatalf("expected the archived documents not to be delivered, got %v", received)
+	}
+	if _, err := os.Stat(filepath.Join(configDirectory, deliveredDocumentsFileName)); err != nil {
+		t.Errorf("expected the delivered documents to be seeded: %s", err)
+	}
+
+	// Documents added afterwards and accepted staged documents are delivered
+	fileIndex["added"] = archive.File{Path: documentPath, Supplier: "acme", AddedAt: time.Now()}
+	fileIndex["staged"] = archive.File{Path: documentPath, Supplier: "acme", AddedAt: time.Now().Add(-time.Hour)}
+	delivered, err = sink.DeliverAll(context.Background(), fileIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered != 2 || len(received) != 2 || received[0] != "staged" || received[1] != "added" {
+		t.Errorf("expected the added and the accepted document to be delivered, got %v", received)
+	}
+}